@@ -0,0 +1,98 @@
+// 9pcache is a caching 9P proxy, like Plan 9's cfs: it serves what a
+// remote server serves, keeping what clients read, and the stats of the
+// files they walk to, on local disk, so that reading them again need not
+// cross the network:
+//
+//	9pcache -remote fileserver:5640 [-addr :5642] [-cache dir]
+//
+// What is kept is used only while the server gives the file the QID it
+// had when it was read, QID version and all, which it has to ask for as
+// a client opens the file anyway. Files whose QID version is 0, from
+// servers which don't keep versions, are never kept, nor are directories,
+// and writes pass straight through, forgetting what was kept.
+//
+// Each client gets a connection to the server of its own, made when it
+// sends its Tversion. The cache is shared, and outlives 9pcache.
+package main
+
+import (
+	"flag"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"harvey-os.org/pkg/ninep"
+	"harvey-os.org/pkg/ninep/protocol"
+)
+
+var (
+	network = flag.String("net", "tcp", "Network to serve on")
+	netaddr = flag.String("addr", ":5642", "Network address to serve on")
+	rnet    = flag.String("rnet", "tcp", "Network of the remote server")
+	remote  = flag.String("remote", "", "Address of the remote server")
+	cacheTo = flag.String("cache", "", "Keep the cache in this directory; by default, one in the user's cache directory, named for the server")
+	debug   = flag.Int("debug", 0, "Print debug messages")
+)
+
+// debugProxy is a proxy which prints what it does, and still hears of
+// hangups, which a DebugFileServer does not pass on.
+type debugProxy struct {
+	*ninep.DebugFileServer
+	p *proxy
+}
+
+func (d debugProxy) Hangup() {
+	d.p.Hangup()
+}
+
+func main() {
+	flag.Parse()
+
+	if *remote == "" {
+		flag.Usage()
+		os.Exit(1)
+	}
+	dir := *cacheTo
+	if dir == "" {
+		d, err := os.UserCacheDir()
+		if err != nil {
+			log.Fatal(err)
+		}
+		name := strings.NewReplacer("/", "_", ":", "_", "\\", "_").Replace(*rnet + "!" + *remote)
+		dir = filepath.Join(d, "9pcache", name)
+	}
+	cache, err := newDiskCache(dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ln, err := net.Listen(*network, *netaddr)
+	if err != nil {
+		log.Fatalf("Listen failed: %v", err)
+	}
+	dial := func() (io.ReadWriteCloser, error) {
+		return net.Dial(*rnet, *remote)
+	}
+	l, err := protocol.NewListener(func() protocol.NineServer {
+		p := newProxy(dial, cache)
+		if *debug != 0 {
+			return debugProxy{DebugFileServer: &ninep.DebugFileServer{FileServer: p}, p: p}
+		}
+		return p
+	}, func(l *protocol.Listener) error {
+		l.Trace = nil
+		if *debug > 1 {
+			l.Trace = log.Printf
+		}
+		return nil
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := l.Serve(ln); err != nil {
+		log.Fatal(err)
+	}
+}
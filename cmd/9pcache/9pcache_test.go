@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"harvey-os.org/internal/ninetest"
+	"harvey-os.org/internal/ufs"
+	"harvey-os.org/pkg/ninep/protocol"
+)
+
+// remoteServer returns a ufs of root, to proxy, which counts the Treads
+// and Tstats it is sent, and a dial for it.
+func remoteServer(t *testing.T, root string) (*protocol.Listener, func() (io.ReadWriteCloser, error)) {
+	l, err := ufs.NewServer(root, 0, nil, func(l *protocol.Listener) error {
+		l.Trace = func(string, ...interface{}) {}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ufs.NewServer: want nil, got %v", err)
+	}
+	if err := l.SetTraceFilter(&protocol.TraceFilter{Types: []protocol.MType{protocol.Tread, protocol.Tstat}}); err != nil {
+		t.Fatalf("SetTraceFilter: want nil, got %v", err)
+	}
+	return l, func() (io.ReadWriteCloser, error) {
+		p, p2 := net.Pipe()
+		if err := l.Accept(p2); err != nil {
+			return nil, err
+		}
+		return p, nil
+	}
+}
+
+// proxyClient returns a client of a proxy with the cache in dir.
+func proxyClient(t *testing.T, dial func() (io.ReadWriteCloser, error), dir string) *protocol.Client {
+	cache, err := newDiskCache(dir)
+	if err != nil {
+		t.Fatalf("newDiskCache: want nil, got %v", err)
+	}
+	c := ninetest.Serve(t, newProxy(dial, cache))
+	ninetest.Attach(t, c, "glenda")
+	return c
+}
+
+func readFile(t *testing.T, c *protocol.Client, name string) string {
+	f, err := c.Open(0, []string{name}, protocol.OREAD)
+	if err != nil {
+		t.Fatalf("Open(%v): want nil, got %v", name, err)
+	}
+	defer f.Close()
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatalf("ReadAll(%v): want nil, got %v", name, err)
+	}
+	return string(b)
+}
+
+func statFile(t *testing.T, c *protocol.Client, name string) protocol.Dir {
+	w, err := c.Walk(0, []string{name})
+	if err != nil {
+		t.Fatalf("Walk(%v): want nil, got %v", name, err)
+	}
+	defer c.CallTclunk(w.FID)
+	b, err := c.CallTstat(w.FID)
+	if err != nil {
+		t.Fatalf("CallTstat(%v): want nil, got %v", name, err)
+	}
+	d, err := protocol.Unmarshaldir(bytes.NewBuffer(b))
+	if err != nil {
+		t.Fatalf("Unmarshaldir: want nil, got %v", err)
+	}
+	return d
+}
+
+func TestCache(t *testing.T) {
+	if protocol.Minimal {
+		t.Skip("the server's requests are counted by tracing, which the minimal build leaves out")
+	}
+	root, dir := t.TempDir(), t.TempDir()
+	name := filepath.Join(root, "a")
+	if err := ioutil.WriteFile(name, []byte("hello, world"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	up, dial := remoteServer(t, root)
+	asked := func(typ string) uint64 {
+		return up.Stats().Traced[typ]
+	}
+
+	c := proxyClient(t, dial, dir)
+	if got := readFile(t, c, "a"); got != "hello, world" {
+		t.Errorf("read a: got %q, want %q", got, "hello, world")
+	}
+	if d := statFile(t, c, "a"); d.Length != 12 {
+		t.Errorf("stat a: got length %d, want 12", d.Length)
+	}
+	reads, stats := asked("Tread"), asked("Tstat")
+	if reads == 0 || stats == 0 {
+		t.Fatalf("first read and stat of a: server asked for %d reads and %d stats, want some", reads, stats)
+	}
+
+	// Another client, of another proxy with the same cache, as after a
+	// restart, is given what was kept.
+	c = proxyClient(t, dial, dir)
+	if got := readFile(t, c, "a"); got != "hello, world" {
+		t.Errorf("read a again: got %q, want %q", got, "hello, world")
+	}
+	if d := statFile(t, c, "a"); d.Length != 12 {
+		t.Errorf("stat a again: got length %d, want 12", d.Length)
+	}
+	if asked("Tread") != reads || asked("Tstat") != stats {
+		t.Errorf("second read and stat of a: server asked for %d reads and %d stats, want none", asked("Tread")-reads, asked("Tstat")-stats)
+	}
+
+	// A change on the server makes a new version, which is read.
+	if err := ioutil.WriteFile(name, []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(name, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, c, "a"); got != "changed" {
+		t.Errorf("read a after a change: got %q, want %q", got, "changed")
+	}
+	if d := statFile(t, c, "a"); d.Length != 7 {
+		t.Errorf("stat a after a change: got length %d, want 7", d.Length)
+	}
+	reads = asked("Tread")
+
+	// Writes pass through, and what was kept is forgotten.
+	f, err := c.Open(0, []string{"a"}, protocol.OWRITE)
+	if err != nil {
+		t.Fatalf("Open(a, OWRITE): want nil, got %v", err)
+	}
+	if _, err := f.WriteAt([]byte("C"), 0); err != nil {
+		t.Fatalf("WriteAt: want nil, got %v", err)
+	}
+	f.Close()
+	if got := readFile(t, c, "a"); got != "Changed" {
+		t.Errorf("read a after a write: got %q, want %q", got, "Changed")
+	}
+	if asked("Tread") == reads {
+		t.Errorf("read a after a write: server asked for no reads, want some")
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"harvey-os.org/pkg/ninep/protocol"
+)
+
+// A diskCache keeps what is read through the proxies on local disk, by
+// the QID path of each file, along with the QID it was read at. What is
+// kept is used only while the server still gives the file that QID, so
+// a file which has changed, and been given a new version, is read again.
+//
+// Each file has two: its data, named for its QID path, and what is known
+// about it, in the same name with .meta added, which is written after
+// the data, and renamed into place, so that a crash can lose what was
+// read, but never has the cache trust what was not.
+type diskCache struct {
+	dir string
+
+	// mu guards entries, and the files.
+	mu      sync.Mutex
+	entries map[uint64]*entry
+}
+
+// An entry is what is known about one file.
+type entry struct {
+	qid protocol.QID
+	// have is how much of the file, from its start, is kept, and eof
+	// is set once a read at have found nothing more.
+	have int64
+	eof  bool
+	// stat is the file's Rstat, if it has been kept.
+	stat []byte
+}
+
+func newDiskCache(dir string) (*diskCache, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &diskCache{dir: dir, entries: make(map[uint64]*entry)}, nil
+}
+
+// cacheable reports whether what is read of the file with q can be
+// kept. A version of 0 is that of a server which does not version its
+// files, so there is no telling when they change.
+func cacheable(q protocol.QID) bool {
+	return q.Version != 0 && q.Type&(protocol.QTAPPEND|protocol.QTEXCL|protocol.QTAUTH) == 0
+}
+
+func (d *diskCache) name(path uint64) string {
+	return filepath.Join(d.dir, fmt.Sprintf("%016x", path))
+}
+
+// entry returns the entry of the file with q, loading it if need be. An
+// entry of another version is the wrong one, and is forgotten: the QID
+// last seen wins. d.mu is held.
+func (d *diskCache) entry(q protocol.QID) *entry {
+	e, ok := d.entries[q.Path]
+	if !ok {
+		e = d.load(q.Path)
+		d.entries[q.Path] = e
+	}
+	if e.qid != q {
+		if e.have > 0 || e.stat != nil {
+			d.remove(q.Path)
+		}
+		*e = entry{qid: q}
+	}
+	return e
+}
+
+// stat returns the kept Rstat of the file with q, or nil.
+func (d *diskCache) stat(q protocol.QID) []byte {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.entry(q).stat
+}
+
+// setStat keeps the Rstat b of the file with q.
+func (d *diskCache) setStat(q protocol.QID, b []byte) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	e := d.entry(q)
+	e.stat = b
+	d.store(q.Path, e)
+}
+
+// read returns up to n bytes of the file with q, from o, and true, if
+// they are kept, or false if they have to be read from the server.
+func (d *diskCache) read(q protocol.QID, o protocol.Offset, n protocol.Count) ([]byte, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	e := d.entry(q)
+	end := int64(o) + int64(n)
+	switch {
+	case e.eof && int64(o) >= e.have:
+		return nil, true
+	case e.eof && end > e.have:
+		end = e.have
+	case end > e.have:
+		return nil, false
+	}
+	f, err := os.Open(d.name(q.Path))
+	if err != nil {
+		return nil, false
+	}
+	defer f.Close()
+	b := make([]byte, end-int64(o))
+	if _, err := f.ReadAt(b, int64(o)); err != nil {
+		// The data is not what the entry says: start again.
+		d.remove(q.Path)
+		*e = entry{qid: q}
+		return nil, false
+	}
+	return b, true
+}
+
+// fill keeps b, read from the server at o, of the file with q. Only
+// what carries on from what is kept is: reads which skip ahead are not.
+func (d *diskCache) fill(q protocol.QID, o protocol.Offset, b []byte) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	e := d.entry(q)
+	if int64(o) > e.have || e.eof {
+		return
+	}
+	if len(b) == 0 {
+		if int64(o) == e.have {
+			e.eof = true
+			d.store(q.Path, e)
+		}
+		return
+	}
+	if int64(o)+int64(len(b)) <= e.have {
+		return
+	}
+	f, err := os.OpenFile(d.name(q.Path), os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return
+	}
+	_, err = f.WriteAt(b, int64(o))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return
+	}
+	e.have = int64(o) + int64(len(b))
+	d.store(q.Path, e)
+}
+
+// drop forgets the file with QID path, which is changing.
+func (d *diskCache) drop(path uint64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.entries, path)
+	d.remove(path)
+}
+
+// remove removes the files of path. d.mu is held.
+func (d *diskCache) remove(path uint64) {
+	os.Remove(d.name(path) + ".meta")
+	os.Remove(d.name(path))
+}
+
+// load reads the entry of path from disk. An entry which can't be read
+// is an empty one. d.mu is held.
+//
+// The .meta file is qid.type[1] qid.vers[4] qid.path[8] have[8] eof[1]
+// stat[...].
+func (d *diskCache) load(path uint64) *entry {
+	b, err := ioutil.ReadFile(d.name(path) + ".meta")
+	if err != nil || len(b) < 22 {
+		return &entry{}
+	}
+	e := &entry{
+		qid: protocol.QID{
+			Type:    b[0],
+			Version: binary.LittleEndian.Uint32(b[1:]),
+			Path:    binary.LittleEndian.Uint64(b[5:]),
+		},
+		have: int64(binary.LittleEndian.Uint64(b[13:])),
+		eof:  b[21] != 0,
+	}
+	if len(b) > 22 {
+		e.stat = b[22:]
+	}
+	if e.qid.Path != path {
+		return &entry{}
+	}
+	if st, err := os.Stat(d.name(path)); e.have > 0 && (err != nil || st.Size() < e.have) {
+		return &entry{}
+	}
+	return e
+}
+
+// store writes the entry of path to disk. d.mu is held.
+func (d *diskCache) store(path uint64, e *entry) error {
+	var b bytes.Buffer
+	var n [8]byte
+	b.WriteByte(e.qid.Type)
+	binary.LittleEndian.PutUint32(n[:4], e.qid.Version)
+	b.Write(n[:4])
+	binary.LittleEndian.PutUint64(n[:], e.qid.Path)
+	b.Write(n[:])
+	binary.LittleEndian.PutUint64(n[:], uint64(e.have))
+	b.Write(n[:])
+	if e.eof {
+		b.WriteByte(1)
+	} else {
+		b.WriteByte(0)
+	}
+	b.Write(e.stat)
+
+	t, err := ioutil.TempFile(d.dir, "tmp")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(t, &b)
+	if cerr := t.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(t.Name(), d.name(path)+".meta")
+	}
+	if err != nil {
+		os.Remove(t.Name())
+	}
+	return err
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"harvey-os.org/pkg/ninep/protocol"
+)
+
+// A proxy serves one client, passing what it asks for on to the server,
+// over a connection of its own, and keeping what it reads in the cache.
+// The Server calls its methods one at a time.
+type proxy struct {
+	dial  func() (io.ReadWriteCloser, error)
+	cache *diskCache
+
+	// c is the client of the server, on rwc, once there is a Tversion.
+	c    *protocol.Client
+	rwc  io.Closer
+	fids map[protocol.FID]*fid
+}
+
+// A fid is a fid of the client, and the one of the server's it stands for.
+type fid struct {
+	remote protocol.FID
+	qid    protocol.QID
+	// fresh is set while qid is what the server just said, so that a
+	// stat may come from the cache without asking it again.
+	fresh bool
+	// cached is set for a file open only for reading, whose reads may
+	// come from the cache.
+	cached bool
+}
+
+func newProxy(dial func() (io.ReadWriteCloser, error), cache *diskCache) *proxy {
+	return &proxy{dial: dial, cache: cache}
+}
+
+// Rversion starts a new session with the server, as the client does with
+// the proxy, with a message size no larger than the server's.
+func (p *proxy) Rversion(msize protocol.MaxSize, version string) (protocol.MaxSize, string, error) {
+	if version != "9P2000" {
+		return 0, "", protocol.Errorf(protocol.ErrNotSupported, "%v: only 9P2000", version)
+	}
+	p.Hangup()
+	rwc, err := p.dial()
+	if err != nil {
+		return 0, "", fmt.Errorf("dialing server: %v", err)
+	}
+	c, err := protocol.NewClient(func(c *protocol.Client) error {
+		c.FromNet, c.ToNet = rwc, rwc
+		c.Msize = uint32(msize)
+		return nil
+	})
+	if err != nil {
+		rwc.Close()
+		return 0, "", err
+	}
+	m, v, _, err := c.Version(msize, version)
+	if err == nil && v != version {
+		err = fmt.Errorf("server speaks %v, not %v", v, version)
+	}
+	if err != nil {
+		rwc.Close()
+		return 0, "", fmt.Errorf("server Tversion: %v", err)
+	}
+	if m < msize {
+		msize = m
+		c.Msize = uint32(m)
+	}
+	p.c, p.rwc, p.fids = c, rwc, make(map[protocol.FID]*fid)
+	return msize, version, nil
+}
+
+// Hangup closes the connection to the server, which clunks the fids the
+// client left.
+func (p *proxy) Hangup() {
+	if p.rwc != nil {
+		p.rwc.Close()
+		p.c, p.rwc, p.fids = nil, nil, nil
+	}
+}
+
+// fid returns the fid f, which has to be in use.
+func (p *proxy) fid(f protocol.FID) (*fid, error) {
+	if p.c == nil {
+		return nil, protocol.Errorf(protocol.ErrInvalid, "no Tversion")
+	}
+	r, ok := p.fids[f]
+	if !ok {
+		return nil, protocol.Errorf(protocol.ErrUnknownFID, "fid %d", f)
+	}
+	return r, nil
+}
+
+// unused reports an error if the fid f is in use.
+func (p *proxy) unused(f protocol.FID) error {
+	if p.c == nil {
+		return protocol.Errorf(protocol.ErrInvalid, "no Tversion")
+	}
+	if _, ok := p.fids[f]; ok {
+		return protocol.Errorf(protocol.ErrFIDInUse, "fid %d", f)
+	}
+	return nil
+}
+
+func (p *proxy) Rattach(f protocol.FID, afid protocol.FID, uname string, aname string) (protocol.QID, error) {
+	if afid != protocol.NOFID {
+		return protocol.QID{}, protocol.Errorf(protocol.ErrNotSupported, "auth attach")
+	}
+	if err := p.unused(f); err != nil {
+		return protocol.QID{}, err
+	}
+	r := p.c.GetFID()
+	q, err := p.c.CallTattach(r, protocol.NOFID, uname, aname)
+	if err != nil {
+		return protocol.QID{}, err
+	}
+	p.fids[f] = &fid{remote: r, qid: q, fresh: true}
+	return q, nil
+}
+
+func (p *proxy) Rwalk(f protocol.FID, newfid protocol.FID, paths []string) ([]protocol.QID, error) {
+	o, err := p.fid(f)
+	if err != nil {
+		return nil, err
+	}
+	if newfid != f {
+		if err := p.unused(newfid); err != nil {
+			return nil, err
+		}
+	}
+	r := p.c.GetFID()
+	qids, err := p.c.CallTwalk(o.remote, r, paths)
+	if err != nil || len(qids) < len(paths) {
+		return qids, err
+	}
+	q := o.qid
+	if len(qids) > 0 {
+		q = qids[len(qids)-1]
+	}
+	if newfid == f {
+		p.c.CallTclunk(o.remote)
+	}
+	p.fids[newfid] = &fid{remote: r, qid: q, fresh: true}
+	return qids, nil
+}
+
+func (p *proxy) Ropen(f protocol.FID, mode protocol.Mode) (protocol.QID, protocol.MaxSize, error) {
+	o, err := p.fid(f)
+	if err != nil {
+		return protocol.QID{}, 0, err
+	}
+	q, iounit, err := p.c.CallTopen(o.remote, mode)
+	if err != nil {
+		return protocol.QID{}, 0, err
+	}
+	if mode&protocol.OTRUNC != 0 {
+		p.cache.drop(q.Path)
+	}
+	o.qid, o.fresh = q, true
+	rw := mode & 3
+	o.cached = (rw == protocol.OREAD || rw == protocol.OEXEC) && mode&protocol.ORCLOSE == 0 &&
+		q.Type&protocol.QTDIR == 0 && cacheable(q)
+	return q, iounit, nil
+}
+
+func (p *proxy) Rcreate(f protocol.FID, name string, perm protocol.Perm, mode protocol.Mode) (protocol.QID, protocol.MaxSize, error) {
+	o, err := p.fid(f)
+	if err != nil {
+		return protocol.QID{}, 0, err
+	}
+	q, iounit, err := p.c.CallTcreate(o.remote, name, perm, mode)
+	if err != nil {
+		return protocol.QID{}, 0, err
+	}
+	// Whatever had the path before is gone.
+	p.cache.drop(q.Path)
+	o.qid, o.fresh, o.cached = q, true, false
+	return q, iounit, nil
+}
+
+// Rstat comes from the cache if the fid's QID is what the server just
+// said, and the cache has the stat of that QID, as it does when an ls
+// walks to each file and stats it.
+func (p *proxy) Rstat(f protocol.FID) ([]byte, error) {
+	o, err := p.fid(f)
+	if err != nil {
+		return nil, err
+	}
+	fresh := o.fresh && cacheable(o.qid)
+	o.fresh = false
+	if fresh {
+		if b := p.cache.stat(o.qid); b != nil {
+			return b, nil
+		}
+	}
+	b, err := p.c.CallTstat(o.remote)
+	if err != nil {
+		return nil, err
+	}
+	if d, err := protocol.Unmarshaldir(bytes.NewBuffer(b)); err == nil && cacheable(d.QID) {
+		p.cache.setStat(d.QID, b)
+	}
+	return b, nil
+}
+
+func (p *proxy) Rwstat(f protocol.FID, b []byte) error {
+	o, err := p.fid(f)
+	if err != nil {
+		return err
+	}
+	err = p.c.CallTwstat(o.remote, b)
+	p.cache.drop(o.qid.Path)
+	o.fresh = false
+	return err
+}
+
+func (p *proxy) Rclunk(f protocol.FID) error {
+	o, err := p.fid(f)
+	if err != nil {
+		return err
+	}
+	delete(p.fids, f)
+	return p.c.CallTclunk(o.remote)
+}
+
+func (p *proxy) Rremove(f protocol.FID) error {
+	o, err := p.fid(f)
+	if err != nil {
+		return err
+	}
+	delete(p.fids, f)
+	err = p.c.CallTremove(o.remote)
+	p.cache.drop(o.qid.Path)
+	return err
+}
+
+func (p *proxy) Rread(f protocol.FID, off protocol.Offset, n protocol.Count) ([]byte, error) {
+	o, err := p.fid(f)
+	if err != nil {
+		return nil, err
+	}
+	if o.cached {
+		if b, ok := p.cache.read(o.qid, off, n); ok {
+			return b, nil
+		}
+	}
+	b, err := p.c.CallTread(o.remote, off, n)
+	if err != nil {
+		return nil, err
+	}
+	if o.cached {
+		p.cache.fill(o.qid, off, b)
+	}
+	return b, nil
+}
+
+func (p *proxy) Rwrite(f protocol.FID, off protocol.Offset, b []byte) (protocol.Count, error) {
+	o, err := p.fid(f)
+	if err != nil {
+		return 0, err
+	}
+	n, err := p.c.CallTwrite(o.remote, off, b)
+	p.cache.drop(o.qid.Path)
+	o.fresh = false
+	return n, err
+}
+
+// Rflush has nothing to do, since the proxy answers each message before
+// it takes the next.
+func (p *proxy) Rflush(o protocol.Tag) error {
+	return nil
+}
@@ -0,0 +1,89 @@
+// 9pdemo runs scenarios which put this module's packages together end to
+// end, each a server and a client of it, talking over the loopback
+// network:
+//
+//	9pdemo [-v] [-list] [scenario ...]
+//
+// With no scenarios named, it runs them all, and prints whether each
+// passed; -v prints what they do as well, and -list lists them. It exits
+// with status 1 if any failed. Each is a function in scenarios.go, which
+// uses the packages as any program would, and is meant to be read: its
+// tests run every scenario, so they are kept working as the packages
+// change.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"text/tabwriter"
+)
+
+var (
+	verbose = flag.Bool("v", false, "Print what each scenario does")
+	list    = flag.Bool("list", false, "List the scenarios, and run none")
+)
+
+// find returns the scenarios named, or all of them if none are.
+func find(names []string) ([]scenario, error) {
+	if len(names) == 0 {
+		return scenarios, nil
+	}
+	var ss []scenario
+	for _, n := range names {
+		i := 0
+		for i < len(scenarios) && scenarios[i].name != n {
+			i++
+		}
+		if i == len(scenarios) {
+			return nil, fmt.Errorf("no scenario %q; -list lists them", n)
+		}
+		ss = append(ss, scenarios[i])
+	}
+	return ss, nil
+}
+
+// runAll runs ss, printing what they do to w, and whether they passed
+// to sum, and returns how many failed.
+func runAll(ss []scenario, w, sum io.Writer) int {
+	tw := tabwriter.NewWriter(sum, 0, 8, 1, ' ', 0)
+	failed := 0
+	for _, s := range ss {
+		fmt.Fprintf(w, "== %v: %v\n", s.name, s.about)
+		if err := s.run(w); err != nil {
+			failed++
+			fmt.Fprintf(tw, "FAIL\t%v\t%v\n", s.name, err)
+			continue
+		}
+		fmt.Fprintf(tw, "PASS\t%v\t%v\n", s.name, s.about)
+	}
+	tw.Flush()
+	return failed
+}
+
+func main() {
+	flag.Parse()
+
+	if *list {
+		tw := tabwriter.NewWriter(os.Stdout, 0, 8, 1, ' ', 0)
+		for _, s := range scenarios {
+			fmt.Fprintf(tw, "%v\t%v\n", s.name, s.about)
+		}
+		tw.Flush()
+		return
+	}
+	ss, err := find(flag.Args())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "9pdemo: %v\n", err)
+		os.Exit(2)
+	}
+	var w io.Writer = ioutil.Discard
+	if *verbose {
+		w = os.Stdout
+	}
+	if runAll(ss, w, os.Stdout) > 0 {
+		os.Exit(1)
+	}
+}
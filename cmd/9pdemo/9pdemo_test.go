@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestScenarios(t *testing.T) {
+	for _, s := range scenarios {
+		s := s
+		t.Run(s.name, func(t *testing.T) {
+			var b bytes.Buffer
+			if err := s.run(&b); err != nil {
+				t.Errorf("%v: %v\n%s", s.name, err, b.String())
+			}
+		})
+	}
+}
+
+func TestFind(t *testing.T) {
+	ss, err := find(nil)
+	if err != nil || len(ss) != len(scenarios) {
+		t.Errorf("find(): got %d scenarios, %v; want all %d", len(ss), err, len(scenarios))
+	}
+	ss, err = find([]string{"tls", "serve"})
+	if err != nil || len(ss) != 2 || ss[0].name != "tls" || ss[1].name != "serve" {
+		t.Errorf("find(tls, serve): got %v, %v; want tls and serve", ss, err)
+	}
+	if _, err := find([]string{"nope"}); err == nil {
+		t.Errorf("find(nope): want error, got nil")
+	}
+}
+
+func TestRunAll(t *testing.T) {
+	var w, sum bytes.Buffer
+	ss, _ := find([]string{"serve"})
+	if failed := runAll(ss, &w, &sum); failed != 0 {
+		t.Errorf("runAll(serve): %d failed:\n%s", failed, sum.String())
+	}
+	if !strings.HasPrefix(sum.String(), "PASS serve") {
+		t.Errorf("runAll(serve): got summary %q, want a PASS", sum.String())
+	}
+	if !strings.Contains(w.String(), "== serve:") {
+		t.Errorf("runAll(serve): got %q, want the scenario's heading", w.String())
+	}
+}
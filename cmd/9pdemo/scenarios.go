@@ -0,0 +1,474 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"harvey-os.org/internal/ramfs"
+	"harvey-os.org/pkg/ninep/client"
+	"harvey-os.org/pkg/ninep/kvfs"
+	"harvey-os.org/pkg/ninep/protocol"
+)
+
+// A scenario is one way of using the packages, from start to finish.
+// It prints what it does to w, and returns an error if anything went
+// other than it should have.
+type scenario struct {
+	name  string
+	about string
+	run   func(w io.Writer) error
+}
+
+var scenarios = []scenario{
+	{"serve", "serve a ramfs over TCP, and make, read and list its files with a client.Session", serveRamfs},
+	{"fs", "read the files of a ramfs as an fs.FS, with fs.WalkDir and fs.ReadFile", mountFS},
+	{"proc", "export files made up when they are opened, as /proc's are, with kvfs", exportProc},
+	{"tls", "reach a ramfs through a proxy which speaks TLS to its clients", proxyTLS},
+}
+
+// user is who the scenarios attach as, and who owns the ramfs.
+const user = "glenda"
+
+// listen serves on a loopback TCP port with serve, until stop is called,
+// and returns the port's address.
+func listen(serve func(net.Listener) error) (addr string, stop func(), err error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", nil, err
+	}
+	go serve(ln)
+	return ln.Addr().String(), func() { ln.Close() }, nil
+}
+
+// newRamfs serves an empty ramfs, owned by user, until stop is called.
+func newRamfs() (addr string, stop func(), err error) {
+	fsys, err := ramfs.New(ramfs.RootOwner(user, user))
+	if err != nil {
+		return "", nil, err
+	}
+	l, err := ramfs.NewServer(fsys)
+	if err != nil {
+		return "", nil, err
+	}
+	return listen(l.Serve)
+}
+
+// put makes the file name, holding data.
+func put(s client.Session, name, data string) error {
+	f, err := s.Create(name, 0644, protocol.OWRITE)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(f, data); err != nil {
+		f.Close()
+		return err
+	}
+	// Some servers only store what was written on the clunk, and
+	// only say then if they can't.
+	return f.Close()
+}
+
+// cat returns what the file name holds.
+func cat(s client.Session, name string) (string, error) {
+	f, err := s.Open(name, protocol.OREAD)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	b, err := ioutil.ReadAll(f)
+	return string(b), err
+}
+
+// ls prints the directory name to w, and returns the names in it.
+func ls(w io.Writer, s client.Session, name string) ([]string, error) {
+	d, err := s.OpenDir(name)
+	if err != nil {
+		return nil, err
+	}
+	defer d.Close()
+	ents, err := d.ReadDir(0)
+	var names []string
+	for _, e := range ents {
+		fmt.Fprintf(w, "\t%v %v %8d %v\n", os.FileMode(e.Mode&0777), e.User, e.Length, e.Name)
+		names = append(names, e.Name)
+	}
+	return names, err
+}
+
+// serveRamfs is the usual server and client: the server is a ramfs, its
+// files in memory, and the client a client.Session, which names files by
+// path, as os does.
+func serveRamfs(w io.Writer) error {
+	addr, stop, err := newRamfs()
+	if err != nil {
+		return err
+	}
+	defer stop()
+	fmt.Fprintf(w, "ramfs serving on %v\n", addr)
+
+	s, err := client.Dial("tcp", addr, user, "")
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	fmt.Fprintf(w, "attached as %v, with messages of up to %d bytes\n", user, s.Msize())
+
+	if _, err := s.Create("lib", protocol.DMDIR|0755, protocol.OREAD); err != nil {
+		return err
+	}
+	if err := put(s, "lib/motd", "hello, world\n"); err != nil {
+		return err
+	}
+	got, err := cat(s, "lib/motd")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "lib/motd holds %q\n", got)
+	if want := "hello, world\n"; got != want {
+		return fmt.Errorf("lib/motd: got %q, want %q", got, want)
+	}
+	fmt.Fprintf(w, "lib:\n")
+	names, err := ls(w, s, "lib")
+	if err != nil {
+		return err
+	}
+	if len(names) != 1 || names[0] != "motd" {
+		return fmt.Errorf("lib: got %q, want motd", names)
+	}
+	if err := s.Remove("lib/motd"); err != nil {
+		return err
+	}
+	if _, err := s.Stat("lib/motd"); err == nil {
+		return fmt.Errorf("lib/motd: there after Remove")
+	}
+	fmt.Fprintf(w, "removed lib/motd\n")
+	return nil
+}
+
+// mountFS reads a server's files as an fs.FS, as a program which knows
+// nothing of 9P does: protocol.Client.FS makes one of an attach.
+func mountFS(w io.Writer) error {
+	addr, stop, err := newRamfs()
+	if err != nil {
+		return err
+	}
+	defer stop()
+	s, err := client.Dial("tcp", addr, user, "")
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	files := map[string]string{"a": "alpha\n", "lib/b": "beta\n", "lib/c": "gamma\n"}
+	if _, err := s.Create("lib", protocol.DMDIR|0755, protocol.OREAD); err != nil {
+		return err
+	}
+	for name, data := range files {
+		if err := put(s, name, data); err != nil {
+			return err
+		}
+	}
+
+	// The fs.FS is over a connection of its own, versioned and
+	// attached.
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	c, err := protocol.NewClient(func(c *protocol.Client) error {
+		c.FromNet, c.ToNet = conn, conn
+		c.Msize = 8192
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if _, _, _, err := c.Version(8192, "9P2000"); err != nil {
+		return err
+	}
+	root, _, err := c.Attach(user, "", nil)
+	if err != nil {
+		return err
+	}
+	fsys := c.FS(root)
+
+	found := map[string]string{}
+	err = fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			fmt.Fprintf(w, "\t%v/\n", name)
+			return nil
+		}
+		fmt.Fprintf(w, "\t%v\n", name)
+		b, err := fs.ReadFile(fsys, name)
+		found[name] = string(b)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	for name, data := range files {
+		if found[name] != data {
+			return fmt.Errorf("fs.ReadFile(%v): got %q, want %q", name, found[name], data)
+		}
+	}
+	if len(found) != len(files) {
+		return fmt.Errorf("fs.WalkDir: found %d files, want %d", len(found), len(files))
+	}
+	fmt.Fprintf(w, "read %d files\n", len(found))
+
+	matches, err := fs.Glob(fsys, "lib/*")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "lib/* is %q\n", matches)
+	if len(matches) != 2 {
+		return fmt.Errorf("fs.Glob(lib/*): got %q, want lib/b and lib/c", matches)
+	}
+	if _, err := fs.Stat(fsys, "nope"); !os.IsNotExist(err) {
+		return fmt.Errorf("fs.Stat(nope): got %v, want fs.ErrNotExist", err)
+	}
+	return nil
+}
+
+// procKV is a kvfs.KV of the state of this process, worked out as each
+// key is read, so that its files are like those of /proc: what they
+// hold is what is true when they are opened. It can't be written.
+type procKV struct {
+	start time.Time
+}
+
+var procKeys = map[string]func(p *procKV) string{
+	"pid":        func(p *procKV) string { return strconv.Itoa(os.Getpid()) },
+	"goroutines": func(p *procKV) string { return strconv.Itoa(runtime.NumGoroutine()) },
+	"uptime":     func(p *procKV) string { return time.Since(p.start).Round(time.Millisecond).String() },
+	"heap": func(p *procKV) string {
+		var m runtime.MemStats
+		runtime.ReadMemStats(&m)
+		return strconv.FormatUint(m.HeapAlloc, 10)
+	},
+	"version": func(p *procKV) string { return runtime.Version() },
+}
+
+func (p *procKV) Get(key string) ([]byte, error) {
+	f, ok := procKeys[key]
+	if !ok {
+		return nil, fmt.Errorf("%v: %w", key, kvfs.ErrNotFound)
+	}
+	return []byte(f(p) + "\n"), nil
+}
+
+func (p *procKV) Put(key string, value []byte) error {
+	return protocol.Errorf(protocol.ErrReadOnly, "%v", key)
+}
+
+func (p *procKV) Delete(key string) error {
+	return protocol.Errorf(protocol.ErrReadOnly, "%v", key)
+}
+
+func (p *procKV) List(prefix string) ([]string, error) {
+	var keys []string
+	for k := range procKeys {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	return keys, nil
+}
+
+// exportProc serves files which are made up as they are read. kvfs
+// serves any key/value store, with each key a file; here the store is
+// procKV, whose values are worked out as they are asked for.
+func exportProc(w io.Writer) error {
+	l, err := kvfs.NewServer(&procKV{start: time.Now()}, []kvfs.Opt{kvfs.Owner(user, user)})
+	if err != nil {
+		return err
+	}
+	addr, stop, err := listen(l.Serve)
+	if err != nil {
+		return err
+	}
+	defer stop()
+	fmt.Fprintf(w, "proc serving on %v\n", addr)
+
+	s, err := client.Dial("tcp", addr, user, "")
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	names, err := ls(w, s, "/")
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+	if len(names) != len(procKeys) {
+		return fmt.Errorf("/: got %q, want %d files", names, len(procKeys))
+	}
+	for _, name := range names {
+		v, err := cat(s, name)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%v: %v", name, v)
+	}
+	pid, err := cat(s, "pid")
+	if err != nil {
+		return err
+	}
+	if want := strconv.Itoa(os.Getpid()) + "\n"; pid != want {
+		return fmt.Errorf("pid: got %q, want %q", pid, want)
+	}
+
+	// kvfs writes a value back to the store when the file is closed,
+	// so that is when procKV says no.
+	f, err := s.Open("pid", protocol.OWRITE|protocol.OTRUNC)
+	if err != nil {
+		return err
+	}
+	io.WriteString(f, "1\n")
+	if err = f.Close(); !errors.Is(err, protocol.ErrReadOnly) {
+		return fmt.Errorf("writing pid: got %v, want ErrReadOnly", err)
+	}
+	fmt.Fprintf(w, "writing pid: %v\n", err)
+	return nil
+}
+
+// newCert returns a certificate for 127.0.0.1, made up for the scenario,
+// and a pool of roots which trusts it, as a client must be given.
+func newCert() (tls.Certificate, *x509.CertPool, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "9pdemo"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert}, roots, nil
+}
+
+// forward passes each connection ln accepts on to a connection of its
+// own to addr, and back, until ln is closed.
+func forward(ln net.Listener, addr string) error {
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer c.Close()
+			s, err := net.Dial("tcp", addr)
+			if err != nil {
+				return
+			}
+			defer s.Close()
+			done := make(chan struct{}, 2)
+			copy := func(dst, src net.Conn) {
+				io.Copy(dst, src)
+				done <- struct{}{}
+			}
+			go copy(s, c)
+			go copy(c, s)
+			<-done
+		}()
+	}
+}
+
+// proxyTLS reaches a server which speaks only plain 9P, on a network
+// which is not to be trusted, through a proxy in front of it which
+// speaks TLS. 9P is a stream of bytes, so the proxy need know nothing of
+// it, and a client.Session can be made over any connection with New.
+func proxyTLS(w io.Writer) error {
+	addr, stop, err := newRamfs()
+	if err != nil {
+		return err
+	}
+	defer stop()
+
+	cert, roots, err := newCert()
+	if err != nil {
+		return err
+	}
+	paddr, pstop, err := listen(func(ln net.Listener) error {
+		return forward(tls.NewListener(ln, &tls.Config{Certificates: []tls.Certificate{cert}}), addr)
+	})
+	if err != nil {
+		return err
+	}
+	defer pstop()
+	fmt.Fprintf(w, "ramfs serving on %v, and a TLS proxy of it on %v\n", addr, paddr)
+
+	conn, err := tls.Dial("tcp", paddr, &tls.Config{RootCAs: roots})
+	if err != nil {
+		return err
+	}
+	st := conn.ConnectionState()
+	fmt.Fprintf(w, "TLS %x with %v, cipher suite %v\n", st.Version, st.PeerCertificates[0].Subject, tls.CipherSuiteName(st.CipherSuite))
+	s, err := client.New(conn, user, "")
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer s.Close()
+	if err := put(s, "secret", "over TLS\n"); err != nil {
+		return err
+	}
+
+	// What was written through the proxy is in the server, as a
+	// client of it, with no TLS, sees.
+	direct, err := client.Dial("tcp", addr, user, "")
+	if err != nil {
+		return err
+	}
+	defer direct.Close()
+	got, err := cat(direct, "secret")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "secret, read from the server itself, holds %q\n", got)
+	if want := "over TLS\n"; got != want {
+		return fmt.Errorf("secret: got %q, want %q", got, want)
+	}
+
+	// A client which does not trust the certificate is turned away.
+	if _, err := tls.Dial("tcp", paddr, &tls.Config{}); err == nil {
+		return fmt.Errorf("TLS without the root: want an error, got nil")
+	} else {
+		fmt.Fprintf(w, "TLS without the root: %v\n", err)
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+// 9pmini is a 9P server of files kept in memory, and a client, small
+// enough for firmware, an initramfs or u-root. It is meant to be built
+// with the minimal tag, which leaves out tracing and all but 9P2000:
+//
+//	go build -tags minimal harvey-os.org/cmd/9pmini
+//
+// It serves, until it is killed:
+//
+//	9pmini [-net tcp] [-addr :5642] serve
+//
+// or reads, writes, or lists, the files of a server, as user:
+//
+//	9pmini [-net tcp] [-user glenda] cat host:port name
+//	9pmini [-net tcp] [-user glenda] put host:port name < data
+//	9pmini [-net tcp] [-user glenda] ls host:port name
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+
+	"harvey-os.org/internal/ramfs"
+	"harvey-os.org/pkg/ninep/client"
+	"harvey-os.org/pkg/ninep/protocol"
+)
+
+var (
+	network = flag.String("net", "tcp", "Network to serve, or of the server")
+	addr    = flag.String("addr", ":5642", "Address to serve")
+	user    = flag.String("user", "glenda", "User to own the files served, or to attach as")
+)
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "usage: 9pmini [flags] serve | cat addr name | put addr name | ls addr name\n")
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+// serve serves a ramfs, of files owned by user, on ln.
+func serve(ln net.Listener, user string) error {
+	fs, err := ramfs.New(ramfs.RootOwner(user, user))
+	if err != nil {
+		return err
+	}
+	l, err := ramfs.NewServer(fs)
+	if err != nil {
+		return err
+	}
+	return l.Serve(ln)
+}
+
+// run runs the client command cmd, with its args, on s, writing what it
+// reads to w, and reading what it writes from r.
+func run(s client.Session, cmd string, args []string, r io.Reader, w io.Writer) error {
+	if len(args) != 1 {
+		return fmt.Errorf("%v: want one name, got %d", cmd, len(args))
+	}
+	name := args[0]
+	switch cmd {
+	case "cat":
+		f, err := s.Open(name, protocol.OREAD)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(w, f)
+		return err
+	case "put":
+		f, err := s.Create(name, 0644, protocol.OWRITE)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(f, r); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	case "ls":
+		d, err := s.OpenDir(name)
+		if err != nil {
+			return err
+		}
+		defer d.Close()
+		ents, err := d.ReadDir(0)
+		for _, e := range ents {
+			m := os.FileMode(e.Mode & 0777)
+			if e.Mode&protocol.DMDIR != 0 {
+				m |= os.ModeDir
+			}
+			fmt.Fprintf(w, "%v %8d %v\n", m, e.Length, e.Name)
+		}
+		return err
+	}
+	return fmt.Errorf("no command %q", cmd)
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+	}
+
+	if cmd := flag.Arg(0); cmd == "serve" {
+		ln, err := net.Listen(*network, *addr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Fatal(serve(ln, *user))
+	}
+	if flag.NArg() < 2 {
+		usage()
+	}
+	s, err := client.Dial(*network, flag.Arg(1), *user, "")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer s.Close()
+	if err := run(s, flag.Arg(0), flag.Args()[2:], os.Stdin, os.Stdout); err != nil {
+		log.Print(err)
+		s.Close()
+		os.Exit(1)
+	}
+}
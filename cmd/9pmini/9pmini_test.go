@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"harvey-os.org/pkg/ninep/client"
+)
+
+func TestRun(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go serve(ln, "glenda")
+
+	s, err := client.Dial("tcp", ln.Addr().String(), "glenda", "")
+	if err != nil {
+		t.Fatalf("Dial: want nil, got %v", err)
+	}
+	defer s.Close()
+
+	var out bytes.Buffer
+	if err := run(s, "put", []string{"a"}, strings.NewReader("hello"), &out); err != nil {
+		t.Fatalf("put a: want nil, got %v", err)
+	}
+	if err := run(s, "cat", []string{"a"}, nil, &out); err != nil {
+		t.Fatalf("cat a: want nil, got %v", err)
+	}
+	if got := out.String(); got != "hello" {
+		t.Errorf("cat a: got %q, want %q", got, "hello")
+	}
+	out.Reset()
+	if err := run(s, "ls", []string{"/"}, nil, &out); err != nil {
+		t.Fatalf("ls /: want nil, got %v", err)
+	}
+	if got, want := out.String(), "-rw-r--r--        5 a\n"; got != want {
+		t.Errorf("ls /: got %q, want %q", got, want)
+	}
+	if err := run(s, "cat", []string{"b"}, nil, &out); err == nil {
+		t.Errorf("cat b: want error, got nil")
+	}
+	if err := run(s, "rm", []string{"a"}, nil, &out); err == nil {
+		t.Errorf("rm a: want error, got nil")
+	}
+}
+
+// sizeBudget is the most 9pmini may be, built with the minimal tag and
+// stripped. It was measured at 3.0MB, with go1.27 on linux/amd64.
+const sizeBudget = 4 << 20
+
+func TestSize(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds 9pmini")
+	}
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skipf("no go tool: %v", err)
+	}
+	bin := filepath.Join(t.TempDir(), "9pmini")
+	cmd := exec.Command(goTool, "build", "-tags", "minimal", "-trimpath", "-ldflags=-s -w", "-o", bin, ".")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("go build: %v\n%s", err, out)
+	}
+	fi, err := os.Stat(bin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() > sizeBudget {
+		t.Errorf("9pmini is %d bytes, over the budget of %d", fi.Size(), sizeBudget)
+	}
+	t.Logf("9pmini is %d bytes", fi.Size())
+}
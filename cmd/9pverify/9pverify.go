@@ -0,0 +1,77 @@
+// 9pverify checks that a 9P server does what the protocol asks of it,
+// so that it can be trusted before this package's clients are pointed at
+// it:
+//
+//	9pverify [-net tcp] [-uname name] [-aname tree] [-ro] host:port
+//
+// It runs each check on a connection of its own, and prints whether it
+// passed, failed, and why, or was skipped, and exits with status 1 if
+// any failed. Checks which make files make them in a scratch directory
+// it makes at the root, and removes; -ro skips them, for servers which
+// can't be written to.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"text/tabwriter"
+
+	"harvey-os.org/pkg/ninep/protocol"
+)
+
+var (
+	network  = flag.String("net", "tcp", "Network of the server")
+	user     = flag.String("uname", "glenda", "User to attach as")
+	aname    = flag.String("aname", "", "Tree to attach to")
+	msize    = flag.Uint("msize", 8192, "Largest message to ask for")
+	scratch  = flag.String("scratch", "9pverify.tmp", "Scratch directory to make at the root, for the checks which make files")
+	readOnly = flag.Bool("ro", false, "Skip the checks which make files")
+)
+
+// report prints rs to w, and returns how many failed.
+func report(w io.Writer, rs []result) int {
+	tw := tabwriter.NewWriter(w, 0, 8, 1, ' ', 0)
+	failed := 0
+	for _, r := range rs {
+		switch {
+		case r.skipped:
+			fmt.Fprintf(tw, "SKIP\t%v\t%v (%v)\n", r.check.name, r.check.about, r.err)
+		case r.err != nil:
+			failed++
+			fmt.Fprintf(tw, "FAIL\t%v\t%v: %v\n", r.check.name, r.check.about, r.err)
+		default:
+			fmt.Fprintf(tw, "PASS\t%v\t%v\n", r.check.name, r.check.about)
+		}
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "%d checks, %d failed\n", len(rs), failed)
+	return failed
+}
+
+func main() {
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	addr := flag.Arg(0)
+	dial := func() (io.ReadWriteCloser, error) {
+		return net.Dial(*network, addr)
+	}
+	// Fail early, and once, if there is no server.
+	c, err := dial()
+	if err != nil {
+		log.Fatal(err)
+	}
+	c.Close()
+
+	cfg := &config{user: *user, aname: *aname, msize: protocol.MaxSize(*msize), scratch: *scratch, readOnly: *readOnly}
+	if report(os.Stdout, verify(dial, cfg)) > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"harvey-os.org/internal/ramfs"
+	"harvey-os.org/pkg/ninep/protocol"
+)
+
+func newTestDialer(t *testing.T) func() (io.ReadWriteCloser, error) {
+	fs, err := ramfs.New(ramfs.RootOwner("glenda", "glenda"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := ramfs.NewServer(fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return func() (io.ReadWriteCloser, error) {
+		p, p2 := net.Pipe()
+		if err := l.Accept(p2); err != nil {
+			return nil, err
+		}
+		return p, nil
+	}
+}
+
+func TestVerify(t *testing.T) {
+	dial := newTestDialer(t)
+	cfg := &config{user: "glenda", msize: 8192, scratch: "9pverify.tmp"}
+	var b bytes.Buffer
+	if failed := report(&b, verify(dial, cfg)); failed > 0 {
+		t.Errorf("%d checks failed:\n%s", failed, b.String())
+	}
+	e, rwc, err := session(dial, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rwc.Close()
+	if _, err := e.walk(e.scratch); err == nil {
+		t.Errorf("scratch directory left behind")
+	}
+}
+
+func TestVerifyReadOnly(t *testing.T) {
+	var b bytes.Buffer
+	rs := verify(newTestDialer(t), &config{user: "glenda", msize: 8192, scratch: "9pverify.tmp", readOnly: true})
+	if failed := report(&b, rs); failed > 0 {
+		t.Errorf("%d checks failed:\n%s", failed, b.String())
+	}
+	for _, r := range rs {
+		if r.check.write && !r.skipped {
+			t.Errorf("%v: ran, want it skipped", r.check.name)
+		}
+	}
+}
+
+func TestReport(t *testing.T) {
+	rs := []result{
+		{check: &check{name: "a", about: "A works"}},
+		{check: &check{name: "b", about: "B works"}, err: fmt.Errorf("it doesn't")},
+		{check: &check{name: "c", about: "C works"}, err: fmt.Errorf("read-only"), skipped: true},
+	}
+	var b bytes.Buffer
+	if failed := report(&b, rs); failed != 1 {
+		t.Errorf("report: got %d failed, want 1", failed)
+	}
+	for _, want := range []string{"PASS a", "FAIL b B works: it doesn't", "SKIP c C works (read-only)", "3 checks, 1 failed"} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("report: got\n%s\nwant a line with %q", b.String(), want)
+		}
+	}
+}
+
+// A server which agrees to any version fails version-unknown.
+func TestVerifyFails(t *testing.T) {
+	dial := newTestDialer(t)
+	for _, ch := range checks {
+		if ch.name != "version-unknown" {
+			continue
+		}
+		err := run(ch, func() (io.ReadWriteCloser, error) {
+			rwc, err := dial()
+			if err != nil {
+				return nil, err
+			}
+			return &anyVersion{ReadWriteCloser: rwc}, nil
+		}, &config{user: "glenda", msize: 8192})
+		if err == nil {
+			t.Errorf("version-unknown of a server agreeing to any version: want an error, got nil")
+		}
+	}
+}
+
+// anyVersion makes a connection's Tversions ask for 9P2000, whatever
+// they asked for, so that the server seems to agree to them.
+type anyVersion struct {
+	io.ReadWriteCloser
+}
+
+func (a *anyVersion) Write(b []byte) (int, error) {
+	if len(b) > 4 && protocol.MType(b[4]) == protocol.Tversion {
+		m := bytes.Replace(b, []byte("9P9999"), []byte("9P2000"), 1)
+		return a.ReadWriteCloser.Write(m)
+	}
+	return a.ReadWriteCloser.Write(b)
+}
@@ -0,0 +1,578 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"harvey-os.org/pkg/ninep/client"
+	"harvey-os.org/pkg/ninep/protocol"
+)
+
+// A check is one behavior of a server which the protocol asks for.
+type check struct {
+	name  string
+	about string
+	// write is set for checks which make files, in the scratch
+	// directory, and which are skipped for read-only servers.
+	write bool
+	run   func(e *env) error
+}
+
+// An env is what a check runs in: a connection of its own, versioned
+// and attached, unless the check is of those.
+type env struct {
+	c    *protocol.Client
+	root protocol.FID
+	// qid is the root's QID, as the Rattach said.
+	qid protocol.QID
+	// scratch are the names of the scratch directory, from the root.
+	scratch []string
+
+	cfg  *config
+	dial func() (io.ReadWriteCloser, error)
+}
+
+// A config is how to reach the server, and what to check.
+type config struct {
+	user, aname string
+	msize       protocol.MaxSize
+	scratch     string
+	readOnly    bool
+}
+
+// A result is how one check went.
+type result struct {
+	check *check
+	// err is why the check failed, or, if skipped is set, why it was
+	// not run.
+	err     error
+	skipped bool
+}
+
+var checks = []*check{
+	{name: "version", about: "Tversion of 9P2000 is agreed to, with a msize no larger than asked for", run: checkVersion},
+	{name: "version-unknown", about: "Tversion of an unknown version is refused", run: checkVersionUnknown},
+	{name: "attach", about: "Tattach gives a directory", run: checkAttach},
+	{name: "attach-fid-in-use", about: "Tattach to a fid in use fails", run: checkAttachInUse},
+	{name: "walk-clone", about: "Twalk of no names clones the fid", run: checkWalkClone},
+	{name: "walk-dotdot-root", about: "Twalk of .. from the root stays there", run: checkWalkDotdot},
+	{name: "walk-missing", about: "Twalk whose first name is missing fails", run: checkWalkMissing},
+	{name: "walk-partial", about: "Twalk whose later name is missing gives the QIDs found, and no newfid", run: checkWalkPartial},
+	{name: "clunk-unknown", about: "Tclunk of an unknown fid fails", run: checkClunkUnknown},
+	{name: "flush", about: "Tflush of a tag with no request is answered", run: checkFlush},
+	{name: "stat-root", about: "Tstat of the root gives a directory, with the QID of the attach", run: checkStatRoot},
+	{name: "open-dir-write", about: "Topen of a directory for writing fails", run: checkOpenDirWrite},
+	{name: "dirread", about: "Reads of a directory give whole entries, and end with nothing", run: checkDirread},
+	{name: "create", about: "Tcreate makes a file, and opens the fid on it", write: true, run: checkCreate},
+	{name: "create-exists", about: "Tcreate of a file which exists fails", write: true, run: checkCreateExists},
+	{name: "mkdir", about: "Tcreate with DMDIR makes a directory", write: true, run: checkMkdir},
+	{name: "write-read", about: "What is written is read back", write: true, run: checkWriteRead},
+	{name: "read-eof", about: "Reads past the end give nothing", write: true, run: checkReadEOF},
+	{name: "stat-file", about: "Tstat gives a file's name and length", write: true, run: checkStatFile},
+	{name: "wstat-length", about: "Twstat of a length truncates", write: true, run: checkWstatLength},
+	{name: "wstat-rename", about: "Twstat of a name renames", write: true, run: checkWstatRename},
+	{name: "remove", about: "Tremove removes the file, and clunks the fid", write: true, run: checkRemove},
+}
+
+// verify runs the checks against the server dial reaches, each on a
+// connection of its own.
+func verify(dial func() (io.ReadWriteCloser, error), cfg *config) []result {
+	var scratchErr error
+	if !cfg.readOnly {
+		scratchErr = makeScratch(dial, cfg)
+		defer removeScratch(dial, cfg)
+	}
+	var rs []result
+	for _, ch := range checks {
+		switch {
+		case ch.write && cfg.readOnly:
+			rs = append(rs, result{check: ch, err: fmt.Errorf("read-only"), skipped: true})
+		case ch.write && scratchErr != nil:
+			rs = append(rs, result{check: ch, err: scratchErr, skipped: true})
+		default:
+			rs = append(rs, result{check: ch, err: run(ch, dial, cfg)})
+		}
+	}
+	return rs
+}
+
+// connect makes a connection, and does a Tversion.
+func connect(dial func() (io.ReadWriteCloser, error), cfg *config) (*protocol.Client, io.Closer, error) {
+	rwc, err := dial()
+	if err != nil {
+		return nil, nil, err
+	}
+	c, err := protocol.NewClient(func(c *protocol.Client) error {
+		c.FromNet, c.ToNet = rwc, rwc
+		c.Msize = uint32(cfg.msize)
+		return nil
+	})
+	if err != nil {
+		rwc.Close()
+		return nil, nil, err
+	}
+	return c, rwc, nil
+}
+
+// session makes a connection, versioned and attached, as most checks
+// want.
+func session(dial func() (io.ReadWriteCloser, error), cfg *config) (*env, io.Closer, error) {
+	c, rwc, err := connect(dial, cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	m, v, err := c.CallTversion(cfg.msize, "9P2000")
+	if err == nil && v != "9P2000" {
+		err = fmt.Errorf("Tversion: got %q, want 9P2000", v)
+	}
+	if err != nil {
+		rwc.Close()
+		return nil, nil, fmt.Errorf("Tversion: %v", err)
+	}
+	c.Msize = uint32(m)
+	e := &env{c: c, root: c.GetFID(), scratch: []string{cfg.scratch}, cfg: cfg, dial: dial}
+	if e.qid, err = c.CallTattach(e.root, protocol.NOFID, cfg.user, cfg.aname); err != nil {
+		rwc.Close()
+		return nil, nil, fmt.Errorf("Tattach: %v", err)
+	}
+	return e, rwc, nil
+}
+
+func run(ch *check, dial func() (io.ReadWriteCloser, error), cfg *config) error {
+	if ch.run == nil {
+		return fmt.Errorf("not written")
+	}
+	e, rwc, err := session(dial, cfg)
+	if err != nil {
+		return err
+	}
+	defer rwc.Close()
+	return ch.run(e)
+}
+
+func makeScratch(dial func() (io.ReadWriteCloser, error), cfg *config) error {
+	e, rwc, err := session(dial, cfg)
+	if err != nil {
+		return err
+	}
+	defer rwc.Close()
+	fid, err := e.walk(nil)
+	if err != nil {
+		return err
+	}
+	defer e.c.CallTclunk(fid)
+	if _, _, err := e.c.CallTcreate(fid, cfg.scratch, protocol.DMDIR|0777, protocol.OREAD); err != nil {
+		return fmt.Errorf("making scratch directory %v: %v", cfg.scratch, err)
+	}
+	return nil
+}
+
+// removeScratch removes the scratch directory, and what the checks left
+// in it.
+func removeScratch(dial func() (io.ReadWriteCloser, error), cfg *config) error {
+	e, rwc, err := session(dial, cfg)
+	if err != nil {
+		return err
+	}
+	defer rwc.Close()
+	return e.removeAll(e.scratch)
+}
+
+func (e *env) removeAll(names []string) error {
+	fid, err := e.walk(names)
+	if err != nil {
+		return err
+	}
+	st, err := e.stat(fid)
+	if err == nil && st.QID.Type&protocol.QTDIR != 0 {
+		var ents []protocol.Dir
+		if ents, err = e.readDir(fid); err == nil {
+			for _, d := range ents {
+				e.removeAll(append(names[:len(names):len(names)], d.Name))
+			}
+		}
+		e.c.CallTclunk(fid)
+		if fid, err = e.walk(names); err != nil {
+			return err
+		}
+	}
+	return e.c.CallTremove(fid)
+}
+
+// walk walks a new fid to names, from the root.
+func (e *env) walk(names []string) (protocol.FID, error) {
+	fid := e.c.GetFID()
+	q, err := e.c.CallTwalk(e.root, fid, names)
+	if err != nil {
+		return 0, err
+	}
+	if len(q) != len(names) {
+		return 0, fmt.Errorf("Twalk of %q: got %d QIDs, want %d", names, len(q), len(names))
+	}
+	return fid, nil
+}
+
+func (e *env) stat(fid protocol.FID) (protocol.Dir, error) {
+	b, err := e.c.CallTstat(fid)
+	if err != nil {
+		return protocol.Dir{}, err
+	}
+	return protocol.Unmarshaldir(bytes.NewBuffer(b))
+}
+
+// readDir opens fid, and reads all its entries, a little at a time, so
+// that there are several reads, checking that each gives whole entries.
+func (e *env) readDir(fid protocol.FID) ([]protocol.Dir, error) {
+	if _, _, err := e.c.CallTopen(fid, protocol.OREAD); err != nil {
+		return nil, err
+	}
+	var ents []protocol.Dir
+	for o := protocol.Offset(0); ; {
+		b, err := e.c.CallTread(fid, o, 256)
+		if err != nil {
+			return nil, err
+		}
+		if len(b) == 0 {
+			return ents, nil
+		}
+		for r := bytes.NewBuffer(b); r.Len() > 0; {
+			d, err := protocol.Unmarshaldir(r)
+			if err != nil {
+				return nil, fmt.Errorf("directory read at %d: %d bytes are not whole entries: %v", o, len(b), err)
+			}
+			ents = append(ents, d)
+		}
+		o += protocol.Offset(len(b))
+	}
+}
+
+// create creates the file name in the scratch directory, and returns the
+// fid open on it.
+func (e *env) create(name string, perm protocol.Perm, mode protocol.Mode) (protocol.FID, protocol.QID, error) {
+	fid, err := e.walk(e.scratch)
+	if err != nil {
+		return 0, protocol.QID{}, err
+	}
+	q, _, err := e.c.CallTcreate(fid, name, perm, mode)
+	if err != nil {
+		return 0, protocol.QID{}, fmt.Errorf("Tcreate of %v: %v", name, err)
+	}
+	return fid, q, nil
+}
+
+// scratchFile creates the file name in the scratch directory, with data
+// in it, and returns the fid open on it for reading and writing.
+func (e *env) scratchFile(name, data string) (protocol.FID, error) {
+	fid, _, err := e.create(name, 0666, protocol.ORDWR)
+	if err != nil {
+		return 0, err
+	}
+	if n, err := e.c.CallTwrite(fid, 0, []byte(data)); err != nil || int(n) != len(data) {
+		return 0, fmt.Errorf("Twrite of %d bytes: got %d, %v", len(data), n, err)
+	}
+	return fid, nil
+}
+
+func (e *env) in(name string) []string {
+	return append(e.scratch[:len(e.scratch):len(e.scratch)], name)
+}
+
+func checkVersion(e *env) error {
+	c, rwc, err := connect(e.dial, e.cfg)
+	if err != nil {
+		return err
+	}
+	defer rwc.Close()
+	m, v, err := c.CallTversion(e.cfg.msize, "9P2000")
+	if err != nil {
+		return err
+	}
+	if v != "9P2000" {
+		return fmt.Errorf("got version %q", v)
+	}
+	if m > e.cfg.msize || m < protocol.IOHDRSZ {
+		return fmt.Errorf("got msize %d, asking for %d", m, e.cfg.msize)
+	}
+	return nil
+}
+
+func checkVersionUnknown(e *env) error {
+	c, rwc, err := connect(e.dial, e.cfg)
+	if err != nil {
+		return err
+	}
+	defer rwc.Close()
+	_, v, err := c.CallTversion(e.cfg.msize, "9P9999")
+	if err == nil && v != "unknown" {
+		return fmt.Errorf("got version %q, want unknown, or an Rerror", v)
+	}
+	return nil
+}
+
+func checkAttach(e *env) error {
+	if e.qid.Type&protocol.QTDIR == 0 {
+		return fmt.Errorf("got QID %v, not a directory", e.qid)
+	}
+	return nil
+}
+
+func checkAttachInUse(e *env) error {
+	if _, err := e.c.CallTattach(e.root, protocol.NOFID, e.cfg.user, e.cfg.aname); err == nil {
+		return fmt.Errorf("second Tattach to fid %d: want an error, got nil", e.root)
+	}
+	return nil
+}
+
+func checkWalkClone(e *env) error {
+	fid := e.c.GetFID()
+	q, err := e.c.CallTwalk(e.root, fid, nil)
+	if err != nil {
+		return err
+	}
+	if len(q) != 0 {
+		return fmt.Errorf("got %d QIDs, want none", len(q))
+	}
+	d, err := e.stat(fid)
+	if err != nil {
+		return fmt.Errorf("Tstat of the clone: %v", err)
+	}
+	if d.QID.Path != e.qid.Path {
+		return fmt.Errorf("clone has QID %v, want the root's, %v", d.QID, e.qid)
+	}
+	return nil
+}
+
+func checkWalkDotdot(e *env) error {
+	q, err := e.c.CallTwalk(e.root, e.c.GetFID(), []string{".."})
+	if err != nil {
+		return err
+	}
+	if len(q) != 1 || q[0].Path != e.qid.Path {
+		return fmt.Errorf("got QIDs %v, want the root's, %v", q, e.qid)
+	}
+	return nil
+}
+
+func checkWalkMissing(e *env) error {
+	if q, err := e.c.CallTwalk(e.root, e.c.GetFID(), []string{"9pverify.missing"}); err == nil {
+		return fmt.Errorf("got QIDs %v, want an error", q)
+	}
+	return nil
+}
+
+func checkWalkPartial(e *env) error {
+	fid := e.c.GetFID()
+	q, err := e.c.CallTwalk(e.root, fid, []string{"..", "9pverify.missing"})
+	if err != nil {
+		return fmt.Errorf("got %v, want an Rwalk of one QID", err)
+	}
+	if len(q) != 1 {
+		return fmt.Errorf("got QIDs %v, want one", q)
+	}
+	if err := e.c.CallTclunk(fid); err == nil {
+		return fmt.Errorf("Tclunk of newfid %d succeeded: the partial walk made it", fid)
+	}
+	return nil
+}
+
+func checkClunkUnknown(e *env) error {
+	if err := e.c.CallTclunk(e.c.GetFID()); err == nil {
+		return fmt.Errorf("want an error, got nil")
+	}
+	return nil
+}
+
+func checkFlush(e *env) error {
+	return e.c.CallTflush(e.c.GetTag())
+}
+
+func checkStatRoot(e *env) error {
+	d, err := e.stat(e.root)
+	if err != nil {
+		return err
+	}
+	if d.Mode&protocol.DMDIR == 0 || d.QID.Type&protocol.QTDIR == 0 {
+		return fmt.Errorf("got mode %#o, QID %v: not a directory", d.Mode, d.QID)
+	}
+	if d.QID.Path != e.qid.Path {
+		return fmt.Errorf("got QID %v, want the attach's, %v", d.QID, e.qid)
+	}
+	return nil
+}
+
+func checkOpenDirWrite(e *env) error {
+	fid, err := e.walk(nil)
+	if err != nil {
+		return err
+	}
+	if _, _, err := e.c.CallTopen(fid, protocol.OWRITE); err == nil {
+		return fmt.Errorf("want an error, got nil")
+	}
+	return nil
+}
+
+func checkDirread(e *env) error {
+	fid, err := e.walk(nil)
+	if err != nil {
+		return err
+	}
+	ents, err := e.readDir(fid)
+	if err != nil {
+		return err
+	}
+	for _, d := range ents {
+		if d.Name == "" || d.Name == "." || d.Name == ".." {
+			return fmt.Errorf("entry named %q", d.Name)
+		}
+	}
+	return nil
+}
+
+func checkCreate(e *env) error {
+	fid, q, err := e.create("create", 0666, protocol.ORDWR)
+	if err != nil {
+		return err
+	}
+	if q.Type&protocol.QTDIR != 0 {
+		return fmt.Errorf("got QID %v, a directory", q)
+	}
+	// The fid is open: a write to it works.
+	if _, err := e.c.CallTwrite(fid, 0, []byte("x")); err != nil {
+		return fmt.Errorf("Twrite to the created fid: %v", err)
+	}
+	if _, err := e.walk(e.in("create")); err != nil {
+		return fmt.Errorf("after Tcreate: %v", err)
+	}
+	return nil
+}
+
+func checkCreateExists(e *env) error {
+	if _, err := e.scratchFile("exists", ""); err != nil {
+		return err
+	}
+	if _, _, err := e.create("exists", 0666, protocol.ORDWR); err == nil {
+		return fmt.Errorf("second Tcreate: want an error, got nil")
+	}
+	return nil
+}
+
+func checkMkdir(e *env) error {
+	_, q, err := e.create("dir", protocol.DMDIR|0777, protocol.OREAD)
+	if err != nil {
+		return err
+	}
+	if q.Type&protocol.QTDIR == 0 {
+		return fmt.Errorf("got QID %v, not a directory", q)
+	}
+	return nil
+}
+
+func checkWriteRead(e *env) error {
+	fid, err := e.scratchFile("write-read", "hello, world")
+	if err != nil {
+		return err
+	}
+	b, err := e.c.CallTread(fid, 7, 100)
+	if err != nil {
+		return err
+	}
+	if string(b) != "world" {
+		return fmt.Errorf("Tread at 7: got %q, want %q", b, "world")
+	}
+	return nil
+}
+
+func checkReadEOF(e *env) error {
+	fid, err := e.scratchFile("read-eof", "hello")
+	if err != nil {
+		return err
+	}
+	for _, o := range []protocol.Offset{5, 1000} {
+		b, err := e.c.CallTread(fid, o, 100)
+		if err != nil {
+			return fmt.Errorf("Tread at %d: %v", o, err)
+		}
+		if len(b) != 0 {
+			return fmt.Errorf("Tread at %d: got %q, want nothing", o, b)
+		}
+	}
+	return nil
+}
+
+func checkStatFile(e *env) error {
+	fid, err := e.scratchFile("stat-file", "hello")
+	if err != nil {
+		return err
+	}
+	d, err := e.stat(fid)
+	if err != nil {
+		return err
+	}
+	if d.Name != "stat-file" || d.Length != 5 {
+		return fmt.Errorf("got name %q, length %d, want stat-file, 5", d.Name, d.Length)
+	}
+	return nil
+}
+
+func checkWstatLength(e *env) error {
+	fid, err := e.scratchFile("wstat-length", "hello")
+	if err != nil {
+		return err
+	}
+	d := client.NoChange()
+	d.Length = 2
+	var b bytes.Buffer
+	protocol.Marshaldir(&b, d)
+	if err := e.c.CallTwstat(fid, b.Bytes()); err != nil {
+		return err
+	}
+	if d, err = e.stat(fid); err != nil {
+		return err
+	}
+	if d.Length != 2 {
+		return fmt.Errorf("got length %d, want 2", d.Length)
+	}
+	return nil
+}
+
+func checkWstatRename(e *env) error {
+	fid, err := e.scratchFile("wstat-rename", "hello")
+	if err != nil {
+		return err
+	}
+	d := client.NoChange()
+	d.Name = "wstat-renamed"
+	var b bytes.Buffer
+	protocol.Marshaldir(&b, d)
+	if err := e.c.CallTwstat(fid, b.Bytes()); err != nil {
+		return err
+	}
+	if _, err := e.walk(e.in("wstat-renamed")); err != nil {
+		return fmt.Errorf("after renaming: %v", err)
+	}
+	if _, err := e.walk(e.in("wstat-rename")); err == nil {
+		return fmt.Errorf("the old name is still there")
+	}
+	return nil
+}
+
+func checkRemove(e *env) error {
+	if _, err := e.scratchFile("remove", "hello"); err != nil {
+		return err
+	}
+	fid, err := e.walk(e.in("remove"))
+	if err != nil {
+		return err
+	}
+	if err := e.c.CallTremove(fid); err != nil {
+		return err
+	}
+	if _, err := e.walk(e.in("remove")); err == nil {
+		return fmt.Errorf("the file is still there")
+	}
+	if err := e.c.CallTclunk(fid); err == nil {
+		return fmt.Errorf("Tclunk after Tremove: the fid is still there")
+	}
+	return nil
+}
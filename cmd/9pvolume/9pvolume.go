@@ -0,0 +1,60 @@
+// 9pvolume is a Docker volume plugin which mounts 9P exports, such as
+// those of ufs, into containers, using the kernel's v9fs.
+//
+// Run it as root, and create volumes with the driver, e.g.
+//
+//	docker volume create -d 9p -o addr=fileserver:5640 -o aname=/src src
+//
+// The options, which default to the flags of the same name, are
+//
+//	addr     server address: host:port, or the path of a Unix socket
+//	aname    tree to attach to
+//	msize    largest message, in bytes
+//	cache    cache mode: none, loose, fscache or mmap
+//	version  protocol: 9p2000, 9p2000.u or 9p2000.L
+package main
+
+import (
+	"flag"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+var (
+	socket  = flag.String("socket", "/run/docker/plugins/9p.sock", "Serve Docker on this Unix socket, named for the driver")
+	root    = flag.String("root", "/var/lib/docker-volumes/9p", "Mount volumes, and keep the list of them, here")
+	addr    = flag.String("addr", "", "Default server address")
+	aname   = flag.String("aname", "", "Default tree to attach to")
+	msize   = flag.String("msize", "", "Default largest message, in bytes")
+	cache   = flag.String("cache", "", "Default cache mode")
+	version = flag.String("version", "", "Default protocol")
+)
+
+func main() {
+	flag.Parse()
+
+	defaults := map[string]string{}
+	for k, v := range map[string]string{"addr": *addr, "aname": *aname, "msize": *msize, "cache": *cache, "version": *version} {
+		if v != "" {
+			defaults[k] = v
+		}
+	}
+	d, err := newDriver(*root, defaults)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(*socket), 0755); err != nil {
+		log.Fatal(err)
+	}
+	// A socket left by an earlier run would keep us from listening.
+	os.Remove(*socket)
+	ln, err := net.Listen("unix", *socket)
+	if err != nil {
+		log.Fatalf("Listen failed: %v", err)
+	}
+	log.Fatal(http.Serve(ln, d.handler()))
+}
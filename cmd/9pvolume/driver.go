@@ -0,0 +1,380 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// defaultPort is the port of a volume's server when its addr has none.
+const defaultPort = "5640"
+
+// A volume is a 9P export, mounted for the containers using it.
+type volume struct {
+	Name string
+	Opts map[string]string
+
+	// ids are the containers which have the volume mounted. It is
+	// mounted while there are any.
+	ids map[string]bool
+}
+
+// driver is a Docker volume plugin, speaking the volume plugin protocol:
+// JSON requests, POSTed to /Plugin.Activate and /VolumeDriver.*, each
+// answered with an Err which is empty on success. It mounts volumes with
+// the kernel's v9fs, each at its own directory under root. The volumes
+// are kept in root/volumes.json, so that they outlive the plugin.
+type driver struct {
+	root string
+
+	// defaults are the options of a volume created without them.
+	defaults map[string]string
+
+	// mount and unmount are the system calls, or a test's.
+	mount   func(source, target, data string) error
+	unmount func(target string) error
+
+	// mu guards below
+	mu   sync.Mutex
+	vols map[string]*volume
+}
+
+// volumeOpts are the options a volume may be created with.
+var volumeOpts = map[string]string{
+	"addr":    "server address: host:port, or the path of a Unix socket",
+	"aname":   "tree to attach to",
+	"msize":   "largest message, in bytes",
+	"cache":   "cache mode: none, loose, fscache or mmap",
+	"version": "protocol: 9p2000, 9p2000.u or 9p2000.L",
+}
+
+var cacheModes = map[string]bool{"none": true, "loose": true, "fscache": true, "mmap": true}
+var versions = map[string]bool{"9p2000": true, "9p2000.u": true, "9p2000.L": true}
+
+func newDriver(root string, defaults map[string]string) (*driver, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+	d := &driver{root: root, defaults: defaults, mount: mount, unmount: unmount, vols: map[string]*volume{}}
+	b, err := ioutil.ReadFile(d.state())
+	if os.IsNotExist(err) {
+		return d, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var vols []*volume
+	if err := json.Unmarshal(b, &vols); err != nil {
+		return nil, fmt.Errorf("%v: %v", d.state(), err)
+	}
+	for _, v := range vols {
+		d.vols[v.Name] = v
+	}
+	return d, nil
+}
+
+// state is the name of the file the volumes are kept in.
+func (d *driver) state() string {
+	return filepath.Join(d.root, "volumes.json")
+}
+
+// save writes the volumes to the state file. Call with d.mu held.
+func (d *driver) save() error {
+	vols := []*volume{}
+	for _, v := range d.vols {
+		vols = append(vols, v)
+	}
+	sort.Slice(vols, func(i, j int) bool { return vols[i].Name < vols[j].Name })
+	b, err := json.MarshalIndent(vols, "", "\t")
+	if err != nil {
+		return err
+	}
+	t := d.state() + ".tmp"
+	if err := ioutil.WriteFile(t, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(t, d.state())
+}
+
+func (d *driver) mountpoint(v *volume) string {
+	return filepath.Join(d.root, v.Name)
+}
+
+// mountArgs returns the source and data with which v9fs mounts a volume
+// with opts.
+func mountArgs(opts map[string]string) (string, string, error) {
+	addr := opts["addr"]
+	if addr == "" {
+		return "", "", fmt.Errorf("no addr")
+	}
+	var source string
+	var data []string
+	if strings.HasPrefix(addr, "/") {
+		source, data = addr, append(data, "trans=unix")
+	} else {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			addr = net.JoinHostPort(addr, defaultPort)
+		}
+		// v9fs wants an address, not a name.
+		a, err := net.ResolveTCPAddr("tcp", addr)
+		if err != nil {
+			return "", "", err
+		}
+		source, data = a.IP.String(), append(data, "trans=tcp", "port="+strconv.Itoa(a.Port))
+	}
+	if a := opts["aname"]; a != "" {
+		if strings.Contains(a, ",") {
+			return "", "", fmt.Errorf("aname %q: no commas allowed", a)
+		}
+		data = append(data, "aname="+a)
+	}
+	if m := opts["msize"]; m != "" {
+		if _, err := strconv.ParseUint(m, 10, 32); err != nil {
+			return "", "", fmt.Errorf("msize %q: %v", m, err)
+		}
+		data = append(data, "msize="+m)
+	}
+	if c := opts["cache"]; c != "" {
+		if !cacheModes[c] {
+			return "", "", fmt.Errorf("cache %q: want none, loose, fscache or mmap", c)
+		}
+		data = append(data, "cache="+c)
+	}
+	if v := opts["version"]; v != "" {
+		if !versions[v] {
+			return "", "", fmt.Errorf("version %q: want 9p2000, 9p2000.u or 9p2000.L", v)
+		}
+		data = append(data, "version="+v)
+	}
+	return source, strings.Join(data, ","), nil
+}
+
+func (d *driver) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/Plugin.Activate", d.call(d.activate))
+	mux.HandleFunc("/VolumeDriver.Capabilities", d.call(d.capabilities))
+	mux.HandleFunc("/VolumeDriver.Create", d.call(d.create))
+	mux.HandleFunc("/VolumeDriver.Remove", d.call(d.remove))
+	mux.HandleFunc("/VolumeDriver.Mount", d.call(d.mountVolume))
+	mux.HandleFunc("/VolumeDriver.Unmount", d.call(d.unmountVolume))
+	mux.HandleFunc("/VolumeDriver.Path", d.call(d.path))
+	mux.HandleFunc("/VolumeDriver.Get", d.call(d.get))
+	mux.HandleFunc("/VolumeDriver.List", d.call(d.list))
+	return mux
+}
+
+// request is what Docker sends; each call uses some of it.
+type request struct {
+	Name string
+	ID   string
+	Opts map[string]string
+}
+
+// response is what the driver answers; each call sets some of it.
+type response struct {
+	Implements   []string      `json:",omitempty"`
+	Capabilities *capabilities `json:",omitempty"`
+	Mountpoint   string        `json:",omitempty"`
+	Volume       *volumeInfo   `json:",omitempty"`
+	Volumes      []volumeInfo  `json:",omitempty"`
+	Err          string
+}
+
+type capabilities struct {
+	Scope string
+}
+
+type volumeInfo struct {
+	Name       string
+	Mountpoint string            `json:",omitempty"`
+	Status     map[string]string `json:",omitempty"`
+}
+
+// call adapts f, a plugin call, to HTTP. Errors are replies, with Err
+// set, as the protocol has it.
+func (d *driver) call(f func(*request) (*response, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "POST only", http.StatusMethodNotAllowed)
+			return
+		}
+		var req request
+		// Some calls have no body at all.
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		resp, err := f(&req)
+		if err != nil {
+			resp = &response{Err: err.Error()}
+		}
+		w.Header().Set("Content-Type", "application/vnd.docker.plugins.v1+json")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+func (d *driver) activate(*request) (*response, error) {
+	return &response{Implements: []string{"VolumeDriver"}}, nil
+}
+
+func (d *driver) capabilities(*request) (*response, error) {
+	return &response{Capabilities: &capabilities{Scope: "local"}}, nil
+}
+
+// lookup returns the volume named in r. Call with d.mu held.
+func (d *driver) lookup(r *request) (*volume, error) {
+	v, ok := d.vols[r.Name]
+	if !ok {
+		return nil, fmt.Errorf("volume %q: no such volume", r.Name)
+	}
+	return v, nil
+}
+
+func (d *driver) create(r *request) (*response, error) {
+	if r.Name == "" || strings.ContainsAny(r.Name, `/\`) || r.Name == "." || r.Name == ".." {
+		return nil, fmt.Errorf("volume %q: bad name", r.Name)
+	}
+	opts := map[string]string{}
+	for k, v := range d.defaults {
+		opts[k] = v
+	}
+	for k, v := range r.Opts {
+		if _, ok := volumeOpts[k]; !ok {
+			return nil, fmt.Errorf("volume %q: unknown option %q", r.Name, k)
+		}
+		opts[k] = v
+	}
+	// Check the options now, rather than at the first mount.
+	if _, _, err := mountArgs(opts); err != nil {
+		return nil, fmt.Errorf("volume %q: %v", r.Name, err)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.vols[r.Name]; ok {
+		return nil, fmt.Errorf("volume %q: already exists", r.Name)
+	}
+	d.vols[r.Name] = &volume{Name: r.Name, Opts: opts}
+	if err := d.save(); err != nil {
+		delete(d.vols, r.Name)
+		return nil, err
+	}
+	return &response{}, nil
+}
+
+func (d *driver) remove(r *request) (*response, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	v, err := d.lookup(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(v.ids) != 0 {
+		return nil, fmt.Errorf("volume %q: in use", r.Name)
+	}
+	delete(d.vols, r.Name)
+	if err := d.save(); err != nil {
+		d.vols[r.Name] = v
+		return nil, err
+	}
+	os.Remove(d.mountpoint(v))
+	return &response{}, nil
+}
+
+func (d *driver) mountVolume(r *request) (*response, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	v, err := d.lookup(r)
+	if err != nil {
+		return nil, err
+	}
+	mp := d.mountpoint(v)
+	if len(v.ids) == 0 {
+		source, data, err := mountArgs(v.Opts)
+		if err != nil {
+			return nil, fmt.Errorf("volume %q: %v", v.Name, err)
+		}
+		if err := os.MkdirAll(mp, 0755); err != nil {
+			return nil, err
+		}
+		if err := d.mount(source, mp, data); err != nil {
+			return nil, fmt.Errorf("volume %q: mount %v: %v", v.Name, source, err)
+		}
+		v.ids = map[string]bool{}
+	}
+	v.ids[r.ID] = true
+	return &response{Mountpoint: mp}, nil
+}
+
+func (d *driver) unmountVolume(r *request) (*response, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	v, err := d.lookup(r)
+	if err != nil {
+		return nil, err
+	}
+	if !v.ids[r.ID] {
+		return nil, fmt.Errorf("volume %q: not mounted for %q", v.Name, r.ID)
+	}
+	if len(v.ids) == 1 {
+		if err := d.unmount(d.mountpoint(v)); err != nil {
+			return nil, fmt.Errorf("volume %q: unmount: %v", v.Name, err)
+		}
+	}
+	delete(v.ids, r.ID)
+	return &response{}, nil
+}
+
+// info describes v. Call with d.mu held.
+func (d *driver) info(v *volume) volumeInfo {
+	i := volumeInfo{Name: v.Name, Status: map[string]string{}}
+	for k, o := range v.Opts {
+		i.Status[k] = o
+	}
+	if len(v.ids) != 0 {
+		i.Mountpoint = d.mountpoint(v)
+		i.Status["mounts"] = strconv.Itoa(len(v.ids))
+	}
+	return i
+}
+
+func (d *driver) path(r *request) (*response, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	v, err := d.lookup(r)
+	if err != nil {
+		return nil, err
+	}
+	return &response{Mountpoint: d.info(v).Mountpoint}, nil
+}
+
+func (d *driver) get(r *request) (*response, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	v, err := d.lookup(r)
+	if err != nil {
+		return nil, err
+	}
+	i := d.info(v)
+	return &response{Volume: &i}, nil
+}
+
+func (d *driver) list(*request) (*response, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	vols := []volumeInfo{}
+	for _, v := range d.vols {
+		vols = append(vols, d.info(v))
+	}
+	sort.Slice(vols, func(i, j int) bool { return vols[i].Name < vols[j].Name })
+	return &response{Volumes: vols}, nil
+}
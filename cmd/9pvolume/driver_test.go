@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestMountArgs(t *testing.T) {
+	for _, tc := range []struct {
+		opts         map[string]string
+		source, data string
+		err          bool
+	}{
+		{map[string]string{"addr": "127.0.0.1:564"}, "127.0.0.1", "trans=tcp,port=564", false},
+		{map[string]string{"addr": "127.0.0.1"}, "127.0.0.1", "trans=tcp,port=5640", false},
+		{map[string]string{"addr": "/run/ufs.sock", "aname": "/src", "msize": "65536", "cache": "loose", "version": "9p2000.L"},
+			"/run/ufs.sock", "trans=unix,aname=/src,msize=65536,cache=loose,version=9p2000.L", false},
+		{map[string]string{}, "", "", true},
+		{map[string]string{"addr": "/s", "aname": "a,b"}, "", "", true},
+		{map[string]string{"addr": "/s", "msize": "big"}, "", "", true},
+		{map[string]string{"addr": "/s", "cache": "lots"}, "", "", true},
+		{map[string]string{"addr": "/s", "version": "9p1"}, "", "", true},
+	} {
+		source, data, err := mountArgs(tc.opts)
+		if (err != nil) != tc.err {
+			t.Errorf("mountArgs(%v): got %v, want error %v", tc.opts, err, tc.err)
+			continue
+		}
+		if source != tc.source || data != tc.data {
+			t.Errorf("mountArgs(%v): got %q, %q, want %q, %q", tc.opts, source, data, tc.source, tc.data)
+		}
+	}
+}
+
+func TestDriver(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "9pvolume")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+	d, err := newDriver(tmpdir, map[string]string{"msize": "8192"})
+	if err != nil {
+		t.Fatalf("newDriver: want nil, got %v", err)
+	}
+	mounts := map[string]string{}
+	d.mount = func(source, target, data string) error {
+		mounts[target] = source + " " + data
+		return nil
+	}
+	d.unmount = func(target string) error {
+		delete(mounts, target)
+		return nil
+	}
+	ts := httptest.NewServer(d.handler())
+	defer ts.Close()
+	call := func(name string, req request) response {
+		t.Helper()
+		b, _ := json.Marshal(req)
+		r, err := http.Post(ts.URL+"/"+name, "application/json", bytes.NewReader(b))
+		if err != nil {
+			t.Fatalf("%v: %v", name, err)
+		}
+		defer r.Body.Close()
+		var resp response
+		if err := json.NewDecoder(r.Body).Decode(&resp); err != nil {
+			t.Fatalf("%v: %v", name, err)
+		}
+		return resp
+	}
+
+	if r := call("Plugin.Activate", request{}); len(r.Implements) != 1 || r.Implements[0] != "VolumeDriver" {
+		t.Errorf("Plugin.Activate: got %+v, want VolumeDriver", r)
+	}
+	if r := call("VolumeDriver.Create", request{Name: "v", Opts: map[string]string{"addr": "/ufs.sock"}}); r.Err != "" {
+		t.Fatalf("Create: want no error, got %v", r.Err)
+	}
+	for _, req := range []request{
+		{Name: "v", Opts: map[string]string{"addr": "/ufs.sock"}},
+		{Name: "w", Opts: map[string]string{"addr": "/ufs.sock", "colour": "red"}},
+		{Name: "w"},
+		{Name: "../w", Opts: map[string]string{"addr": "/ufs.sock"}},
+	} {
+		if r := call("VolumeDriver.Create", req); r.Err == "" {
+			t.Errorf("Create(%+v): want error, got none", req)
+		}
+	}
+
+	mp := call("VolumeDriver.Mount", request{Name: "v", ID: "a"}).Mountpoint
+	if got, want := mounts[mp], "/ufs.sock trans=unix,msize=8192"; got != want {
+		t.Errorf("Mount: got %q, want %q", got, want)
+	}
+	call("VolumeDriver.Mount", request{Name: "v", ID: "b"})
+	if r := call("VolumeDriver.Remove", request{Name: "v"}); r.Err == "" {
+		t.Errorf("Remove of a mounted volume: want error, got none")
+	}
+	if r := call("VolumeDriver.Get", request{Name: "v"}); r.Volume == nil || r.Volume.Mountpoint != mp || r.Volume.Status["mounts"] != "2" {
+		t.Errorf("Get: got %+v, want mounted twice at %v", r.Volume, mp)
+	}
+	call("VolumeDriver.Unmount", request{Name: "v", ID: "a"})
+	if _, ok := mounts[mp]; !ok {
+		t.Errorf("Unmount of one of two: want still mounted, got unmounted")
+	}
+	call("VolumeDriver.Unmount", request{Name: "v", ID: "b"})
+	if _, ok := mounts[mp]; ok {
+		t.Errorf("Unmount of both: want unmounted, got mounted")
+	}
+
+	// The volumes outlive the driver.
+	d2, err := newDriver(tmpdir, nil)
+	if err != nil {
+		t.Fatalf("newDriver: want nil, got %v", err)
+	}
+	if r, _ := d2.list(nil); len(r.Volumes) != 1 || r.Volumes[0].Name != "v" || r.Volumes[0].Status["addr"] != "/ufs.sock" {
+		t.Errorf("List: got %+v, want v", r.Volumes)
+	}
+
+	if r := call("VolumeDriver.Remove", request{Name: "v"}); r.Err != "" {
+		t.Errorf("Remove: want no error, got %v", r.Err)
+	}
+	if r := call("VolumeDriver.List", request{}); len(r.Volumes) != 0 {
+		t.Errorf("List: got %+v, want none", r.Volumes)
+	}
+}
@@ -0,0 +1,13 @@
+// +build linux
+
+package main
+
+import "syscall"
+
+func mount(source, target, data string) error {
+	return syscall.Mount(source, target, "9p", 0, data)
+}
+
+func unmount(target string) error {
+	return syscall.Unmount(target, 0)
+}
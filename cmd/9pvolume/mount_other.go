@@ -0,0 +1,13 @@
+// +build !linux
+
+package main
+
+import "fmt"
+
+func mount(source, target, data string) error {
+	return fmt.Errorf("v9fs mounts need linux")
+}
+
+func unmount(target string) error {
+	return fmt.Errorf("v9fs mounts need linux")
+}
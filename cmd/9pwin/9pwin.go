@@ -0,0 +1,43 @@
+// 9pwin mounts a 9P export, such as one of ufs, as a Windows drive,
+// using WinFsp, since Windows has no 9P client of its own. Files are
+// served through the client library, so anything it dials, it mounts.
+//
+// Install WinFsp, with its developer files, and build with cgo, e.g.
+//
+//	go build harvey-os.org/cmd/9pwin
+//	9pwin -addr fileserver:5640 -aname /src -mount X:
+//
+// The drive goes when 9pwin exits. Where WinFsp is installed elsewhere
+// than C:\Program Files (x86)\WinFsp, set CGO_CFLAGS and CGO_LDFLAGS to
+// find its inc\fuse and lib directories.
+package main
+
+import (
+	"flag"
+	"log"
+
+	"harvey-os.org/pkg/ninep/client"
+)
+
+var (
+	ntype  = flag.String("net", "tcp", "Network type")
+	naddr  = flag.String("addr", "localhost:5640", "Server address")
+	user   = flag.String("user", "none", "User to attach as")
+	aname  = flag.String("aname", "", "Tree to attach to")
+	msize  = flag.Uint("msize", 65536, "Largest message, in bytes")
+	point  = flag.String("mount", "X:", "Drive letter, or directory, to mount on")
+	fsopts = flag.String("o", "", "Comma-separated WinFsp FUSE options, e.g. uid=-1,gid=-1")
+)
+
+func main() {
+	flag.Parse()
+	s, err := client.Dial(*ntype, *naddr, *user, *aname, client.Msize(uint32(*msize)))
+	if err != nil {
+		log.Fatal(err)
+	}
+	w := newWinFS(s)
+	defer w.close()
+	if err := mount(w, *point, *fsopts); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"io"
+	"path"
+	"sync"
+
+	"harvey-os.org/pkg/ninep/client"
+	"harvey-os.org/pkg/ninep/protocol"
+)
+
+// winFS is what the drive shows: the files of a Session, by name, as
+// FUSE asks for them. The files FUSE has open are kept by handle.
+type winFS struct {
+	s client.Session
+
+	// mu guards below
+	mu    sync.Mutex
+	files map[uint64]client.File
+	next  uint64
+}
+
+func newWinFS(s client.Session) *winFS {
+	return &winFS{s: s, files: make(map[uint64]client.File)}
+}
+
+// Errors of 9pwin's own, from protocol's catalog, which has the rest.
+var (
+	errCrossDir  = protocol.Errorf(protocol.ErrCrossDevice, "rename between directories")
+	errBadHandle = protocol.Errorf(protocol.ErrUnknownFID, "bad file handle")
+)
+
+// add keeps f, and returns its handle.
+func (w *winFS) add(f client.File) uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.next++
+	w.files[w.next] = f
+	return w.next
+}
+
+func (w *winFS) file(fh uint64) (client.File, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	f, ok := w.files[fh]
+	if !ok {
+		return nil, errBadHandle
+	}
+	return f, nil
+}
+
+func (w *winFS) getattr(name string) (protocol.Dir, error) {
+	d, err := w.s.Stat(name)
+	return d, err
+}
+
+func (w *winFS) readdir(name string) ([]protocol.Dir, error) {
+	d, err := w.s.OpenDir(name)
+	if err != nil {
+		return nil, err
+	}
+	defer d.Close()
+	ds, err := d.ReadDir(-1)
+	return ds, err
+}
+
+func (w *winFS) open(name string, mode protocol.Mode) (uint64, error) {
+	f, err := w.s.Open(name, mode)
+	if err != nil {
+		return 0, err
+	}
+	return w.add(f), nil
+}
+
+func (w *winFS) create(name string, perm protocol.Perm, mode protocol.Mode) (uint64, error) {
+	f, err := w.s.Create(name, perm, mode)
+	if err != nil {
+		return 0, err
+	}
+	return w.add(f), nil
+}
+
+// read reads at off, as much as it can: FUSE takes a short read as the
+// end of the file.
+func (w *winFS) read(fh uint64, b []byte, off int64) (int, error) {
+	f, err := w.file(fh)
+	if err != nil {
+		return 0, err
+	}
+	n, err := f.ReadAt(b, off)
+	if err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
+
+func (w *winFS) write(fh uint64, b []byte, off int64) (int, error) {
+	f, err := w.file(fh)
+	if err != nil {
+		return 0, err
+	}
+	n, err := f.WriteAt(b, off)
+	return n, err
+}
+
+func (w *winFS) release(fh uint64) error {
+	w.mu.Lock()
+	f, ok := w.files[fh]
+	delete(w.files, fh)
+	w.mu.Unlock()
+	if !ok {
+		return errBadHandle
+	}
+	return f.Close()
+}
+
+func (w *winFS) mkdir(name string, perm protocol.Perm) error {
+	f, err := w.s.Create(name, perm|protocol.DMDIR, protocol.OREAD)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// remove removes files, for unlink, and directories, for rmdir, which
+// are the same in 9P.
+func (w *winFS) remove(name string) error {
+	return w.s.Remove(name)
+}
+
+// rename renames from to, which must be in the same directory, as 9P
+// has no other rename. Windows copies a file to move it elsewhere.
+func (w *winFS) rename(from, to string) error {
+	if path.Dir(path.Clean("/"+from)) != path.Dir(path.Clean("/"+to)) {
+		return errCrossDir
+	}
+	d := client.NoChange()
+	d.Name = path.Base(to)
+	return w.s.Wstat(from, d)
+}
+
+func (w *winFS) truncate(name string, size int64) error {
+	d := client.NoChange()
+	d.Length = uint64(size)
+	return w.s.Wstat(name, d)
+}
+
+func (w *winFS) chmod(name string, perm uint32) error {
+	st, err := w.s.Stat(name)
+	if err != nil {
+		return err
+	}
+	d := client.NoChange()
+	d.Mode = st.Mode&^0777 | perm&0777
+	return w.s.Wstat(name, d)
+}
+
+// The nanoseconds of a utimens time which mean now, and no change, as
+// in Linux.
+const (
+	utimeNow  = 1<<30 - 1
+	utimeOmit = 1<<30 - 2
+)
+
+// timespec is a utimens time.
+type timespec struct {
+	sec, nsec int64
+}
+
+// wstatTimes returns the Twstat times for the utimens times ts, the
+// access time and then the modification time. No times at all, as for
+// touch, and UTIME_NOW are protocol.TimeNow, leaving now to the server;
+// UTIME_OMIT is no change.
+func wstatTimes(ts []timespec) (atime, mtime uint32) {
+	if ts == nil {
+		return protocol.TimeNow, protocol.TimeNow
+	}
+	t := func(ts timespec) uint32 {
+		switch ts.nsec {
+		case utimeNow:
+			return protocol.TimeNow
+		case utimeOmit:
+			return protocol.TimeNoChange
+		}
+		return uint32(ts.sec)
+	}
+	return t(ts[0]), t(ts[1])
+}
+
+// utimens sets the times of name, which are Twstat times.
+func (w *winFS) utimens(name string, atime, mtime uint32) error {
+	d := client.NoChange()
+	d.Atime, d.Mtime = atime, mtime
+	return w.s.Wstat(name, d)
+}
+
+// close releases every file still open, once the drive is gone.
+func (w *winFS) close() error {
+	w.mu.Lock()
+	files := w.files
+	w.files = make(map[uint64]client.File)
+	w.mu.Unlock()
+	for _, f := range files {
+		f.Close()
+	}
+	return w.s.Close()
+}
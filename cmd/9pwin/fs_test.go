@@ -0,0 +1,168 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"harvey-os.org/internal/ramfs"
+	"harvey-os.org/pkg/ninep/client"
+	"harvey-os.org/pkg/ninep/protocol"
+)
+
+func newTestWinFS(t *testing.T) *winFS {
+	fs, err := ramfs.New(ramfs.RootOwner("glenda", "glenda"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := ramfs.NewServer(fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, p2 := net.Pipe()
+	if err := l.Accept(p2); err != nil {
+		t.Fatal(err)
+	}
+	s, err := client.New(p, "glenda", "")
+	if err != nil {
+		t.Fatalf("New: want nil, got %v", err)
+	}
+	return newWinFS(s)
+}
+
+func TestWinFS(t *testing.T) {
+	w := newTestWinFS(t)
+	defer w.close()
+
+	if err := w.mkdir("/d", 0755); err != nil {
+		t.Fatalf("mkdir(/d): want nil, got %v", err)
+	}
+	fh, err := w.create("/d/f", 0644, protocol.ORDWR)
+	if err != nil {
+		t.Fatalf("create(/d/f): want nil, got %v", err)
+	}
+	if n, err := w.write(fh, []byte("hello, world"), 0); err != nil || n != 12 {
+		t.Fatalf("write: want 12, nil, got %d, %v", n, err)
+	}
+	b := make([]byte, 64)
+	n, err := w.read(fh, b, 7)
+	if err != nil || string(b[:n]) != "world" {
+		t.Fatalf("read: want world, nil, got %q, %v", b[:n], err)
+	}
+	if err := w.release(fh); err != nil {
+		t.Fatalf("release: want nil, got %v", err)
+	}
+	if err := w.release(fh); err != errBadHandle {
+		t.Errorf("release again: want %v, got %v", errBadHandle, err)
+	}
+
+	ds, err := w.readdir("/d")
+	if err != nil || len(ds) != 1 || ds[0].Name != "f" {
+		t.Fatalf("readdir(/d): want [f], nil, got %v, %v", ds, err)
+	}
+
+	if err := w.rename("/d/f", "/d/g"); err != nil {
+		t.Fatalf("rename(/d/f, /d/g): want nil, got %v", err)
+	}
+	if err := w.rename("/d/g", "/g"); err != errCrossDir {
+		t.Errorf("rename(/d/g, /g): want %v, got %v", errCrossDir, err)
+	}
+	if _, err := w.getattr("/d/f"); !errors.Is(err, protocol.ErrNotExist) {
+		t.Errorf("getattr(/d/f): want %v, got %v", protocol.ErrNotExist, err)
+	}
+
+	if err := w.truncate("/d/g", 5); err != nil {
+		t.Fatalf("truncate: want nil, got %v", err)
+	}
+	d, err := w.getattr("/d/g")
+	if err != nil || d.Length != 5 {
+		t.Fatalf("getattr(/d/g): want length 5, nil, got %d, %v", d.Length, err)
+	}
+	if err := w.chmod("/d/g", 0600); err != nil {
+		t.Fatalf("chmod: want nil, got %v", err)
+	}
+	if d, err := w.getattr("/d/g"); err != nil || d.Mode&0777 != 0600 {
+		t.Errorf("getattr(/d/g): want mode 0600, nil, got %o, %v", d.Mode, err)
+	}
+
+	if err := w.remove("/d/g"); err != nil {
+		t.Fatalf("remove(/d/g): want nil, got %v", err)
+	}
+	if err := w.remove("/d/g"); !errors.Is(err, protocol.ErrNotExist) {
+		t.Errorf("remove(/d/g) again: want %v, got %v", protocol.ErrNotExist, err)
+	}
+	if _, err := w.open("/nope", protocol.OREAD); !errors.Is(err, protocol.ErrNotExist) {
+		t.Errorf("open(/nope): want %v, got %v", protocol.ErrNotExist, err)
+	}
+}
+
+func TestUtimens(t *testing.T) {
+	w := newTestWinFS(t)
+	defer w.close()
+
+	fh, err := w.create("/f", 0644, protocol.ORDWR)
+	if err != nil {
+		t.Fatalf("create(/f): want nil, got %v", err)
+	}
+	w.release(fh)
+	for _, tc := range []struct {
+		name         string
+		ts           []timespec
+		atime, mtime uint32 // 0 is now
+	}{
+		{"times", []timespec{{sec: 100}, {sec: 200}}, 100, 200},
+		{"UTIME_OMIT", []timespec{{nsec: utimeOmit}, {sec: 300}}, 100, 300},
+		{"UTIME_NOW", []timespec{{sec: 1, nsec: utimeNow}, {nsec: utimeOmit}}, 0, 300},
+		{"NULL", nil, 0, 0},
+	} {
+		at, mt := wstatTimes(tc.ts)
+		if err := w.utimens("/f", at, mt); err != nil {
+			t.Fatalf("%v: utimens: want nil, got %v", tc.name, err)
+		}
+		d, err := w.getattr("/f")
+		if err != nil {
+			t.Fatalf("%v: getattr: want nil, got %v", tc.name, err)
+		}
+		// Now is long after 300.
+		for _, x := range []struct {
+			what      string
+			got, want uint32
+		}{{"atime", d.Atime, tc.atime}, {"mtime", d.Mtime, tc.mtime}} {
+			if (x.want == 0 && x.got <= 300) || (x.want != 0 && x.got != x.want) {
+				t.Errorf("%v: %v: got %d, want %d (0 is now)", tc.name, x.what, x.got, x.want)
+			}
+		}
+	}
+}
+
+// TestErrors checks that what servers say is found in protocol's catalog,
+// which errno goes by, whatever the words.
+func TestErrors(t *testing.T) {
+	w := newTestWinFS(t)
+	defer w.close()
+
+	if err := w.mkdir("/d", 0755); err != nil {
+		t.Fatalf("mkdir(/d): want nil, got %v", err)
+	}
+	fh, err := w.create("/d/f", 0644, protocol.ORDWR)
+	if err != nil {
+		t.Fatalf("create(/d/f): want nil, got %v", err)
+	}
+	w.release(fh)
+	for _, tc := range []struct {
+		name string
+		err  error
+		want *protocol.ErrorCode
+	}{
+		{"mkdir(/d) again", w.mkdir("/d", 0755), protocol.ErrExist},
+		{"remove(/d)", w.remove("/d"), protocol.ErrNotEmpty},
+		{"open(/d, OWRITE)", func() error { _, err := w.open("/d", protocol.OWRITE); return err }(), protocol.ErrIsDir},
+		{"rename(/d/f, /f)", w.rename("/d/f", "/f"), protocol.ErrCrossDevice},
+		{"release of a bad handle", w.release(fh), protocol.ErrUnknownFID},
+		{"getattr(/nope)", func() error { _, err := w.getattr("/nope"); return err }(), protocol.ErrNotExist},
+	} {
+		if got := protocol.Lookup(tc.err); got != tc.want {
+			t.Errorf("%v: got %v (%v), want %v", tc.name, got, tc.err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,9 @@
+// +build !windows !cgo
+
+package main
+
+import "fmt"
+
+func mount(w *winFS, point, opts string) error {
+	return fmt.Errorf("WinFsp mounts need windows, and cgo")
+}
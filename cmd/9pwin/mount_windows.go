@@ -0,0 +1,215 @@
+// +build windows,cgo
+
+package main
+
+/*
+#cgo CFLAGS: -I"C:/Program Files (x86)/WinFsp/inc/fuse"
+#cgo amd64 LDFLAGS: -L"C:/Program Files (x86)/WinFsp/lib" -lwinfsp-x64
+#cgo 386 LDFLAGS: -L"C:/Program Files (x86)/WinFsp/lib" -lwinfsp-x86
+#include "winfsp_windows.h"
+*/
+import "C"
+
+import (
+	"fmt"
+	"os"
+	"unsafe"
+
+	"harvey-os.org/pkg/ninep/protocol"
+)
+
+// fsys is the mounted winFS. FUSE callbacks can't carry Go pointers,
+// and there is only one mount.
+var fsys *winFS
+
+// mount mounts w on point, and serves it until it is unmounted.
+func mount(w *winFS, point, opts string) error {
+	fsys = w
+	args := []string{"9pwin", point}
+	if opts != "" {
+		args = append(args, "-o", opts)
+	}
+	argv := make([]*C.char, len(args))
+	for i, a := range args {
+		argv[i] = C.CString(a)
+		defer C.free(unsafe.Pointer(argv[i]))
+	}
+	if r := C.ninep_main(C.int(len(argv)), &argv[0]); r != 0 {
+		return fmt.Errorf("WinFsp: mount of %v failed: %d", point, int(r))
+	}
+	return nil
+}
+
+// errno returns the negated errno for err, as FUSE wants, by its code
+// in protocol's catalog. The catalog's Errno is Linux's, not C's here.
+func errno(err error) C.int {
+	if err == nil {
+		return 0
+	}
+	switch protocol.Lookup(err) {
+	case protocol.ErrNotExist:
+		return -C.ENOENT
+	case protocol.ErrExist:
+		return -C.EEXIST
+	case protocol.ErrPermission:
+		return -C.EACCES
+	case protocol.ErrNotPermitted:
+		return -C.EPERM
+	case protocol.ErrNotEmpty:
+		return -C.ENOTEMPTY
+	case protocol.ErrNotDir:
+		return -C.ENOTDIR
+	case protocol.ErrIsDir:
+		return -C.EISDIR
+	case protocol.ErrReadOnly:
+		return -C.EROFS
+	case protocol.ErrNoSpace:
+		return -C.ENOSPC
+	case protocol.ErrCrossDevice:
+		return -C.EXDEV
+	case protocol.ErrInvalid:
+		return -C.EINVAL
+	case protocol.ErrUnknownFID, protocol.ErrFIDInUse, protocol.ErrNotOpen:
+		return -C.EBADF
+	}
+	return -C.EIO
+}
+
+// fuseMode returns the FUSE file mode for d.
+func fuseMode(d protocol.Dir) C.uint {
+	m := C.uint(d.Mode & 0777)
+	if d.Mode&protocol.DMDIR != 0 {
+		return m | 0040000
+	}
+	return m | 0100000
+}
+
+// openMode returns the 9P open mode for FUSE open flags. They are C's,
+// whose values are not always those of package os, so they are turned
+// into os flags for protocol.FlagsToMode.
+func openMode(flags C.int) protocol.Mode {
+	var f int
+	switch flags & (C.O_RDONLY | C.O_WRONLY | C.O_RDWR) {
+	case C.O_WRONLY:
+		f = os.O_WRONLY
+	case C.O_RDWR:
+		f = os.O_RDWR
+	}
+	if flags&C.O_TRUNC != 0 {
+		f |= os.O_TRUNC
+	}
+	if flags&C.O_APPEND != 0 {
+		f |= os.O_APPEND
+	}
+	m, _, _ := protocol.FlagsToMode(f)
+	return m
+}
+
+//export goGetattr
+func goGetattr(path *C.char, mode *C.uint, size, atime, mtime *C.longlong) C.int {
+	d, err := fsys.getattr(C.GoString(path))
+	if err != nil {
+		return errno(err)
+	}
+	*mode, *size = fuseMode(d), C.longlong(d.Length)
+	*atime, *mtime = C.longlong(d.Atime), C.longlong(d.Mtime)
+	return 0
+}
+
+//export goReaddir
+func goReaddir(path *C.char, buf unsafe.Pointer, filler C.fuse_fill_dir_t) C.int {
+	ds, err := fsys.readdir(C.GoString(path))
+	if err != nil {
+		return errno(err)
+	}
+	for _, n := range []string{".", ".."} {
+		cn := C.CString(n)
+		C.ninep_fill(filler, buf, cn, 0040000|0755, 0, 0, 0)
+		C.free(unsafe.Pointer(cn))
+	}
+	for _, d := range ds {
+		cn := C.CString(d.Name)
+		full := C.ninep_fill(filler, buf, cn, fuseMode(d), C.longlong(d.Length), C.longlong(d.Atime), C.longlong(d.Mtime))
+		C.free(unsafe.Pointer(cn))
+		if full != 0 {
+			break
+		}
+	}
+	return 0
+}
+
+//export goOpen
+func goOpen(path *C.char, flags C.int, fh *C.uint64_t) C.int {
+	h, err := fsys.open(C.GoString(path), openMode(flags))
+	*fh = C.uint64_t(h)
+	return errno(err)
+}
+
+//export goCreate
+func goCreate(path *C.char, mode C.fuse_mode_t, flags C.int, fh *C.uint64_t) C.int {
+	h, err := fsys.create(C.GoString(path), protocol.Perm(mode&0777), openMode(flags))
+	*fh = C.uint64_t(h)
+	return errno(err)
+}
+
+//export goRead
+func goRead(fh C.uint64_t, buf *C.char, size C.size_t, off C.fuse_off_t) C.int {
+	b := (*[1 << 30]byte)(unsafe.Pointer(buf))[:size:size]
+	n, err := fsys.read(uint64(fh), b, int64(off))
+	if err != nil && n == 0 {
+		return errno(err)
+	}
+	return C.int(n)
+}
+
+//export goWrite
+func goWrite(fh C.uint64_t, buf *C.char, size C.size_t, off C.fuse_off_t) C.int {
+	b := C.GoBytes(unsafe.Pointer(buf), C.int(size))
+	n, err := fsys.write(uint64(fh), b, int64(off))
+	if err != nil && n == 0 {
+		return errno(err)
+	}
+	return C.int(n)
+}
+
+//export goRelease
+func goRelease(fh C.uint64_t) C.int {
+	return errno(fsys.release(uint64(fh)))
+}
+
+//export goMkdir
+func goMkdir(path *C.char, mode C.fuse_mode_t) C.int {
+	return errno(fsys.mkdir(C.GoString(path), protocol.Perm(mode&0777)))
+}
+
+//export goRemove
+func goRemove(path *C.char) C.int {
+	return errno(fsys.remove(C.GoString(path)))
+}
+
+//export goRename
+func goRename(from, to *C.char) C.int {
+	return errno(fsys.rename(C.GoString(from), C.GoString(to)))
+}
+
+//export goTruncate
+func goTruncate(path *C.char, size C.fuse_off_t) C.int {
+	return errno(fsys.truncate(C.GoString(path), int64(size)))
+}
+
+//export goChmod
+func goChmod(path *C.char, mode C.fuse_mode_t) C.int {
+	return errno(fsys.chmod(C.GoString(path), uint32(mode)))
+}
+
+//export goUtimens
+func goUtimens(path *C.char, tv *C.struct_fuse_timespec) C.int {
+	var ts []timespec
+	if tv != nil {
+		for _, t := range (*[2]C.struct_fuse_timespec)(unsafe.Pointer(tv)) {
+			ts = append(ts, timespec{sec: int64(t.tv_sec), nsec: int64(t.tv_nsec)})
+		}
+	}
+	at, mt := wstatTimes(ts)
+	return errno(fsys.utimens(C.GoString(path), at, mt))
+}
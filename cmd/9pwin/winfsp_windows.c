@@ -0,0 +1,119 @@
+// +build cgo
+
+// The FUSE operations, each of which calls the Go function of the same
+// name, in mount_windows.go, with plain arguments, but for utimens, which
+// passes its times on as they are.
+
+#include "winfsp_windows.h"
+#include "_cgo_export.h"
+
+static void setstat(struct fuse_stat *st, unsigned mode, long long size, long long atime, long long mtime)
+{
+	memset(st, 0, sizeof *st);
+	st->st_mode = mode;
+	st->st_nlink = 1;
+	st->st_size = size;
+	st->st_atim.tv_sec = atime;
+	st->st_mtim.tv_sec = mtime;
+	st->st_ctim.tv_sec = mtime;
+	st->st_birthtim.tv_sec = mtime;
+}
+
+static int ninep_getattr(const char *path, struct fuse_stat *st)
+{
+	unsigned mode;
+	long long size, atime, mtime;
+	int r = goGetattr((char *)path, &mode, &size, &atime, &mtime);
+	if (r == 0)
+		setstat(st, mode, size, atime, mtime);
+	return r;
+}
+
+int ninep_fill(fuse_fill_dir_t filler, void *buf, char *name, unsigned mode, long long size, long long atime, long long mtime)
+{
+	struct fuse_stat st;
+	setstat(&st, mode, size, atime, mtime);
+	return filler(buf, name, &st, 0);
+}
+
+static int ninep_readdir(const char *path, void *buf, fuse_fill_dir_t filler, fuse_off_t off, struct fuse_file_info *fi)
+{
+	return goReaddir((char *)path, buf, filler);
+}
+
+static int ninep_open(const char *path, struct fuse_file_info *fi)
+{
+	return goOpen((char *)path, fi->flags, &fi->fh);
+}
+
+static int ninep_create(const char *path, fuse_mode_t mode, struct fuse_file_info *fi)
+{
+	return goCreate((char *)path, mode, fi->flags, &fi->fh);
+}
+
+static int ninep_read(const char *path, char *buf, size_t size, fuse_off_t off, struct fuse_file_info *fi)
+{
+	return goRead(fi->fh, buf, size, off);
+}
+
+static int ninep_write(const char *path, const char *buf, size_t size, fuse_off_t off, struct fuse_file_info *fi)
+{
+	return goWrite(fi->fh, (char *)buf, size, off);
+}
+
+static int ninep_release(const char *path, struct fuse_file_info *fi)
+{
+	return goRelease(fi->fh);
+}
+
+static int ninep_mkdir(const char *path, fuse_mode_t mode)
+{
+	return goMkdir((char *)path, mode);
+}
+
+static int ninep_remove(const char *path)
+{
+	return goRemove((char *)path);
+}
+
+static int ninep_rename(const char *from, const char *to)
+{
+	return goRename((char *)from, (char *)to);
+}
+
+static int ninep_truncate(const char *path, fuse_off_t size)
+{
+	return goTruncate((char *)path, size);
+}
+
+static int ninep_chmod(const char *path, fuse_mode_t mode)
+{
+	return goChmod((char *)path, mode);
+}
+
+static int ninep_utimens(const char *path, const struct fuse_timespec tv[2])
+{
+	// A NULL tv, or UTIME_NOW, is now, which the server knows best.
+	return goUtimens((char *)path, (struct fuse_timespec *)tv);
+}
+
+int ninep_main(int argc, char **argv)
+{
+	static struct fuse_operations ops = {
+		.getattr = ninep_getattr,
+		.readdir = ninep_readdir,
+		.open = ninep_open,
+		.create = ninep_create,
+		.read = ninep_read,
+		.write = ninep_write,
+		.release = ninep_release,
+		.mkdir = ninep_mkdir,
+		.unlink = ninep_remove,
+		.rmdir = ninep_remove,
+		.rename = ninep_rename,
+		.truncate = ninep_truncate,
+		.chmod = ninep_chmod,
+		.utimens = ninep_utimens,
+	};
+	return fuse_main(argc, argv, &ops, NULL);
+}
@@ -0,0 +1,11 @@
+// The WinFsp FUSE API, for 9pwin.
+
+#define FUSE_USE_VERSION 28
+#include <errno.h>
+#include <fcntl.h>
+#include <stdlib.h>
+#include <string.h>
+#include <fuse.h>
+
+int ninep_fill(fuse_fill_dir_t filler, void *buf, char *name, unsigned mode, long long size, long long atime, long long mtime);
+int ninep_main(int argc, char **argv);
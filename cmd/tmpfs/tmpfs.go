@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"os"
@@ -21,15 +22,14 @@ var (
 	pkg     = flag.String("package", "", "tar.gz package to open")
 )
 
-// Constant error messages to match those found in the linux 9p source.
-// These strings are used by linux to map to particular error codes.
-// (See net/9p/error.c in the Linux code)
-const (
-	ErrorAuthFailed   = "authentication failed"
-	ErrorReadOnlyFs   = "Read-only file system"
-	ErrorFileNotFound = "file not found"
-	ErrorFidInUse     = "fid already in use"
-	ErrorFidNotFound  = "fid unknown or out of range"
+// The errors tmpfs sends, from protocol's catalog, whose messages Linux
+// maps to its error codes (see net/9p/error.c in the Linux code).
+var (
+	ErrorAuthFailed   = protocol.Errorf(protocol.ErrNotSupported, "auth attach")
+	ErrorReadOnlyFs   = protocol.ErrReadOnly
+	ErrorFileNotFound = protocol.ErrNotExist
+	ErrorFidInUse     = protocol.ErrFIDInUse
+	ErrorFidNotFound  = protocol.ErrUnknownFID
 )
 
 type fileServer struct {
@@ -49,23 +49,21 @@ type FidEntry struct {
 	// Username to be used for all entries in this hierarchy
 	uname string
 
-	// We can't know how big a serialized dentry is until we serialize it.
-	// At that point it might be too big. We save it here if that happens,
-	// and on the next directory read we start with that.
-	oflow []byte
+	// dirs packs directory entries into reads.
+	dirs protocol.DirPacker
 
 	// Index of next child to return when reading a directory
 	nextChildIdx int
 }
 
 func newFidEntry(entry tmpfs.Entry, uname string) *FidEntry {
-	return &FidEntry{Entry: entry, uname: uname, oflow: nil, nextChildIdx: -1}
+	return &FidEntry{Entry: entry, uname: uname, nextChildIdx: -1}
 }
 
 // Rversion initiates the session
 func (fs *fileServer) Rversion(msize protocol.MaxSize, version string) (protocol.MaxSize, string, error) {
 	if version != "9P2000" {
-		return 0, "", fmt.Errorf("%v not supported; only 9P2000", version)
+		return 0, "", protocol.Errorf(protocol.ErrNotSupported, "%v: only 9P2000", version)
 	}
 	return msize, version, nil
 }
@@ -73,7 +71,7 @@ func (fs *fileServer) Rversion(msize protocol.MaxSize, version string) (protocol
 // Rattach attaches a fid to the root for the given user.  aname and afid are not used.
 func (fs *fileServer) Rattach(fid protocol.FID, afid protocol.FID, uname string, aname string) (protocol.QID, error) {
 	if afid != protocol.NOFID {
-		return protocol.QID{}, fmt.Errorf(ErrorAuthFailed)
+		return protocol.QID{}, ErrorAuthFailed
 	}
 
 	root := fs.archive.Root()
@@ -92,7 +90,7 @@ func (fs *fileServer) Rwalk(fid protocol.FID, newfid protocol.FID, paths []strin
 	// Lookup the parent fid
 	parentEntry, err := fs.getFile(fid)
 	if err != nil {
-		return nil, fmt.Errorf(ErrorFileNotFound)
+		return nil, ErrorFileNotFound
 	}
 
 	if len(paths) == 0 {
@@ -127,7 +125,7 @@ func (fs *fileServer) Rwalk(fid protocol.FID, newfid protocol.FID, paths []strin
 					// to sum up: if any walks have succeeded, you return the QIDS for
 					// one more than the last successful walk
 					if i == 0 {
-						return nil, fmt.Errorf(ErrorFileNotFound)
+						return nil, ErrorFileNotFound
 					}
 					// we only get here if i is > 0 and less than nwname,
 					// so the i should be safe.
@@ -144,14 +142,14 @@ func (fs *fileServer) Rwalk(fid protocol.FID, newfid protocol.FID, paths []strin
 
 // Ropen opens the file associated with fid
 func (fs *fileServer) Ropen(fid protocol.FID, mode protocol.Mode) (protocol.QID, protocol.MaxSize, error) {
-	if mode&(protocol.OWRITE|protocol.ORDWR|protocol.OTRUNC|protocol.ORCLOSE|protocol.OAPPEND) != 0 {
-		return protocol.QID{}, 0, fmt.Errorf(ErrorReadOnlyFs)
+	if mode.Writes() {
+		return protocol.QID{}, 0, ErrorReadOnlyFs
 	}
 
 	// Lookup the parent fid
 	f, err := fs.getFile(fid)
 	if err != nil {
-		return protocol.QID{}, 0, fmt.Errorf(ErrorFileNotFound)
+		return protocol.QID{}, 0, ErrorFileNotFound
 	}
 
 	// TODO Check executable
@@ -161,7 +159,7 @@ func (fs *fileServer) Ropen(fid protocol.FID, mode protocol.Mode) (protocol.QID,
 
 // Rcreate not supported since it's a read-only filesystem
 func (fs *fileServer) Rcreate(fid protocol.FID, name string, perm protocol.Perm, mode protocol.Mode) (protocol.QID, protocol.MaxSize, error) {
-	return protocol.QID{}, 0, fmt.Errorf(ErrorReadOnlyFs)
+	return protocol.QID{}, 0, ErrorReadOnlyFs
 }
 
 // Rclunk drops the fid association in the file system
@@ -186,12 +184,12 @@ func (fs *fileServer) Rstat(fid protocol.FID) ([]byte, error) {
 
 // Rwstat not supported since it's a read-only filesystem
 func (fs *fileServer) Rwstat(fid protocol.FID, b []byte) error {
-	return fmt.Errorf(ErrorReadOnlyFs)
+	return ErrorReadOnlyFs
 }
 
 // Rremove not supported since it's a read-only filesystem
 func (fs *fileServer) Rremove(fid protocol.FID) error {
-	return fmt.Errorf(ErrorReadOnlyFs)
+	return ErrorReadOnlyFs
 }
 
 // Rread returns up to c bytes from file fid starting at offset o
@@ -203,49 +201,22 @@ func (fs *fileServer) Rread(fid protocol.FID, o protocol.Offset, c protocol.Coun
 
 	if dir, ok := f.Entry.(*tmpfs.Directory); ok {
 		if o == 0 {
-			f.oflow = nil
+			f.dirs.Reset()
+			f.nextChildIdx = -1
 		}
-
-		// We make the assumption that they can always fit at least one
-		// directory entry into a read. If that assumption does not hold
-		// so many things are broken that we can't fix them here.
-		// But we'll drop out of the loop below having returned nothing
-		// anyway.
-		b := bytes.NewBuffer(f.oflow)
-		f.oflow = nil
-		pos := 0
-
-		for {
-			if b.Len() > int(c) {
-				f.oflow = b.Bytes()[pos:]
-				return b.Bytes()[:pos], nil
+		return f.dirs.Pack(c, func() (protocol.Dir, error) {
+			if f.nextChildIdx+1 >= dir.NumChildren() {
+				return protocol.Dir{}, io.EOF
 			}
-
 			f.nextChildIdx++
-			pos += b.Len()
-
-			if f.nextChildIdx >= dir.NumChildren() {
-				return b.Bytes(), nil
-			}
-			d9p := dir.Child(f.nextChildIdx).P9Dir(f.uname)
-			protocol.Marshaldir(b, *d9p)
-
-			// Seen on linux clients: sometimes the math is wrong and
-			// they end up asking for the last element with not enough data.
-			// Linux bug or bug with this server? Not sure yet.
-			if b.Len() > int(c) {
-				log.Printf("Warning: Server bug? %v, need %d bytes;count is %d: skipping", d9p, b.Len(), c)
-				return nil, nil
-			}
-			// TODO handle more than one entry at a time
-			// We're not quite doing the array right.
-			// What does work is returning one thing so, for now, do that.
-			return b.Bytes(), nil
-		}
-
+			return *dir.Child(f.nextChildIdx).P9Dir(f.uname), nil
+		})
 	} else if file, ok := f.Entry.(*tmpfs.File); ok {
 		end := int(o) + int(c)
 		maxEnd := len(file.Data())
+		if int(o) >= maxEnd {
+			return nil, nil
+		}
 		if end > maxEnd {
 			end = maxEnd
 		}
@@ -257,7 +228,7 @@ func (fs *fileServer) Rread(fid protocol.FID, o protocol.Offset, c protocol.Coun
 
 // Rwrite not supported since it's a read-only filesystem
 func (fs *fileServer) Rwrite(fid protocol.FID, o protocol.Offset, b []byte) (protocol.Count, error) {
-	return -1, fmt.Errorf(ErrorReadOnlyFs)
+	return -1, ErrorReadOnlyFs
 }
 
 func (fs *fileServer) getFile(fid protocol.FID) (*FidEntry, error) {
@@ -266,7 +237,7 @@ func (fs *fileServer) getFile(fid protocol.FID) (*FidEntry, error) {
 
 	f, ok := fs.files[fid]
 	if !ok {
-		return nil, fmt.Errorf(ErrorFileNotFound)
+		return nil, ErrorFidNotFound
 	}
 	return f, nil
 }
@@ -276,7 +247,7 @@ func (fs *fileServer) setFile(newfid protocol.FID, entry tmpfs.Entry, uname stri
 	fs.Lock()
 	defer fs.Unlock()
 	if _, ok := fs.files[newfid]; ok {
-		return fmt.Errorf(ErrorFidInUse)
+		return ErrorFidInUse
 	}
 	fs.files[newfid] = newFidEntry(entry, uname)
 	return nil
@@ -288,7 +259,7 @@ func (fs *fileServer) clunk(fid protocol.FID) (tmpfs.Entry, error) {
 
 	f, ok := fs.files[fid]
 	if !ok {
-		return nil, fmt.Errorf(ErrorFidNotFound)
+		return nil, ErrorFidNotFound
 	}
 	delete(fs.files, fid)
 
@@ -307,7 +278,7 @@ func newTmpfs(arch *tmpfs.Archive, opts ...protocol.ListenerOpt) (*protocol.List
 
 		var ns protocol.NineServer = fs
 		if *debug != 0 {
-			ns = &ninep.DebugFileServer{FileServer: fs}
+			ns = ninep.NewDebugFileServer(fs)
 		}
 		return ns
 	}
@@ -5,10 +5,13 @@ import (
 	"bytes"
 	"compress/gzip"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"testing"
+	"testing/fstest"
 
+	"harvey-os.org/internal/ninetest"
 	"harvey-os.org/internal/tmpfs"
 	"harvey-os.org/pkg/ninep/protocol"
 )
@@ -233,3 +236,52 @@ func TestMount(t *testing.T) {
 
 	//t.Fail()
 }
+
+func TestEOF(t *testing.T) {
+	n, err := newTmpfs(tmpfs.ReadImage(createTestImage()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := ninetest.Pipe(t, n)
+	ninetest.Attach(t, c, "/")
+
+	if err := fstest.TestFS(c.FS(0), "readme.txt", "foo/gopher.txt", "bar/todo.txt", "abc/123/sean.txt", "foo/bar/hello.txt"); err != nil {
+		t.Errorf("TestFS: %v", err)
+	}
+
+	f, err := c.Open(0, []string{"readme.txt"}, protocol.OREAD)
+	if err != nil {
+		t.Fatalf("Open(readme.txt): want nil, got %v", err)
+	}
+	// Reads past the end are the end, not an error.
+	b := make([]byte, 8)
+	if n, err := f.ReadAt(b, 1000); n != 0 || err != io.EOF {
+		t.Errorf("ReadAt(8, 1000): want 0, EOF, got %d, %v", n, err)
+	}
+	f.Close()
+
+	f, err = c.Open(0, []string{"foo"}, protocol.OREAD)
+	if err != nil {
+		t.Fatalf("Open(foo): want nil, got %v", err)
+	}
+	if _, err := c.CallTread(f.FID(), 0, 8); err == nil || err == io.EOF {
+		t.Errorf("CallTread(foo, 0, 8): want an error, got %v", err)
+	}
+	var names []string
+	for {
+		d, err := f.Dirread()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Dirread(foo): want nil, got %v", err)
+		}
+		for _, e := range d {
+			names = append(names, e.Name)
+		}
+	}
+	if len(names) != 3 {
+		t.Errorf("Dirread(foo): want 3 entries, got %v", names)
+	}
+	f.Close()
+}
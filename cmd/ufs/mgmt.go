@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"harvey-os.org/internal/ufs"
+	"harvey-os.org/pkg/ninep"
+	"harvey-os.org/pkg/ninep/protocol"
+)
+
+// mgmt serves the management API, for programs which would rather not
+// mount anything to run the server. It is JSON over HTTP:
+//
+//	GET  /stats                 totals, whether the server is read-only, budget overruns, and usage by user
+//	GET  /conns                 the connections being served
+//	POST /conns/{id}/evict      close a connection
+//	GET  /readonly              whether the server is read-only
+//	PUT  /readonly              set it, from {"ReadOnly": bool}
+//	GET  /trace                 what is traced
+//	PUT  /trace                 trace what matches, from {"Types": ["Twrite"], "Prefix": "/logs", "Remote": ["10.0.0.5"], "Conns": [id]},
+//	                            sampled by "Every": n, "Errors": bool and "BytesPerSecond": n
+//	DELETE /trace               trace nothing
+type mgmt struct {
+	l   *protocol.Listener
+	ctl *ufs.Control
+
+	// budgets, if set, are the server's Budgets.
+	budgets *ninep.Budgets
+}
+
+type mgmtStats struct {
+	protocol.ListenerStats
+	ReadOnly bool
+	Budgets  *ninep.BudgetStats `json:",omitempty"`
+	// Usage is what each user has changed, by the name they
+	// attached as.
+	Usage map[string]ufs.Usage `json:",omitempty"`
+}
+
+type mgmtReadOnly struct {
+	ReadOnly bool
+}
+
+// mgmtTrace is a protocol.TraceFilter, with message types by name. On
+// is false when nothing is traced.
+type mgmtTrace struct {
+	On     bool
+	Types  []string `json:",omitempty"`
+	Prefix string   `json:",omitempty"`
+	Remote []string `json:",omitempty"`
+	Conns  []uint64 `json:",omitempty"`
+	// Every, Errors and BytesPerSecond sample what is traced.
+	Every          uint64 `json:",omitempty"`
+	Errors         bool   `json:",omitempty"`
+	BytesPerSecond int    `json:",omitempty"`
+}
+
+func (m *mgmt) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stats", m.stats)
+	mux.HandleFunc("/conns", m.conns)
+	mux.HandleFunc("/conns/", m.evict)
+	mux.HandleFunc("/readonly", m.readOnly)
+	mux.HandleFunc("/trace", m.trace)
+	return mux
+}
+
+func reply(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func (m *mgmt) stats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "GET only", http.StatusMethodNotAllowed)
+		return
+	}
+	st := mgmtStats{ListenerStats: m.l.Stats(), ReadOnly: m.ctl.ReadOnly(), Usage: m.ctl.Usage()}
+	if m.budgets != nil {
+		b := m.budgets.Stats()
+		st.Budgets = &b
+	}
+	reply(w, st)
+}
+
+func (m *mgmt) conns(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "GET only", http.StatusMethodNotAllowed)
+		return
+	}
+	cs := m.l.Conns()
+	if cs == nil {
+		cs = []protocol.ConnInfo{}
+	}
+	reply(w, cs)
+}
+
+func (m *mgmt) evict(w http.ResponseWriter, r *http.Request) {
+	f := strings.Split(strings.TrimPrefix(r.URL.Path, "/conns/"), "/")
+	if len(f) != 2 || f[1] != "evict" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST only", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.ParseUint(f[0], 10, 64)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("connection %q: %v", f[0], err), http.StatusBadRequest)
+		return
+	}
+	if err := m.l.Evict(id); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (m *mgmt) readOnly(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var ro mgmtReadOnly
+		if err := json.NewDecoder(r.Body).Decode(&ro); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		m.ctl.SetReadOnly(ro.ReadOnly)
+	default:
+		http.Error(w, "GET or PUT only", http.StatusMethodNotAllowed)
+		return
+	}
+	reply(w, mgmtReadOnly{ReadOnly: m.ctl.ReadOnly()})
+}
+
+func (m *mgmt) trace(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var t mgmtTrace
+		if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f := &protocol.TraceFilter{Prefix: t.Prefix, Remote: t.Remote, Conns: t.Conns, Every: t.Every, Errors: t.Errors, BytesPerSecond: t.BytesPerSecond}
+		for _, n := range t.Types {
+			mt, ok := mtypes[n]
+			if !ok {
+				http.Error(w, fmt.Sprintf("no message type %v", n), http.StatusBadRequest)
+				return
+			}
+			f.Types = append(f.Types, mt)
+		}
+		if err := m.l.SetTraceFilter(f); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	case http.MethodDelete:
+		m.l.SetTraceFilter(protocol.TraceNothing)
+	default:
+		http.Error(w, "GET, PUT or DELETE only", http.StatusMethodNotAllowed)
+		return
+	}
+	f := m.l.TraceFilter()
+	if f == protocol.TraceNothing {
+		reply(w, mgmtTrace{})
+		return
+	}
+	t := mgmtTrace{On: true}
+	if f != nil {
+		t.Prefix, t.Remote, t.Conns = f.Prefix, f.Remote, f.Conns
+		t.Every, t.Errors, t.BytesPerSecond = f.Every, f.Errors, f.BytesPerSecond
+		for _, mt := range f.Types {
+			t.Types = append(t.Types, protocol.RPCNames[mt])
+		}
+	}
+	reply(w, t)
+}
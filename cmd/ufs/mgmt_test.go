@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"harvey-os.org/internal/ufs"
+	"harvey-os.org/pkg/ninep/protocol"
+)
+
+func TestMgmt(t *testing.T) {
+	if protocol.Minimal {
+		t.Skip("no message counts or tracing in the minimal build")
+	}
+	tmpdir, err := ioutil.TempDir("", "mgmt")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+	var ctl ufs.Control
+	l, err := ufs.NewServer(tmpdir, 0, []ufs.Opt{ufs.Controlled(&ctl)})
+	if err != nil {
+		t.Fatalf("NewServer: want nil, got %v", err)
+	}
+	p, p2 := net.Pipe()
+	if err := l.Accept(p2); err != nil {
+		t.Fatalf("Accept: want nil, got %v", err)
+	}
+	var b bytes.Buffer
+	protocol.MarshalTversionPkt(&b, protocol.NOTAG, 8192, "9P2000")
+	p.Write(b.Bytes())
+	if _, err := p.Read(make([]byte, 8192)); err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+
+	ts := httptest.NewServer((&mgmt{l: l, ctl: &ctl}).handler())
+	defer ts.Close()
+	do := func(method, path, body string, want int, v interface{}) {
+		t.Helper()
+		req, err := http.NewRequest(method, ts.URL+path, bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatalf("%v %v: %v", method, path, err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%v %v: %v", method, path, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Fatalf("%v %v: got %v, want %v", method, path, resp.Status, want)
+		}
+		if v != nil {
+			if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+				t.Fatalf("%v %v: %v", method, path, err)
+			}
+		}
+	}
+
+	var cs []protocol.ConnInfo
+	do("GET", "/conns", "", http.StatusOK, &cs)
+	if len(cs) != 1 || cs[0].Messages != 1 {
+		t.Fatalf("GET /conns: got %+v, want 1 connection of 1 message", cs)
+	}
+
+	var ro mgmtReadOnly
+	do("PUT", "/readonly", `{"ReadOnly": true}`, http.StatusOK, &ro)
+	if !ro.ReadOnly || !ctl.ReadOnly() {
+		t.Errorf("PUT /readonly true: got %v, server %v, want true", ro.ReadOnly, ctl.ReadOnly())
+	}
+	var st mgmtStats
+	do("GET", "/stats", "", http.StatusOK, &st)
+	if st.Conns != 1 || st.Accepted != 1 || !st.ReadOnly {
+		t.Errorf("GET /stats: got %+v, want 1 connection, read-only", st)
+	}
+
+	var tr mgmtTrace
+	do("PUT", "/trace", `{"Types": ["Twrite"], "Prefix": "/logs", "Remote": ["10.0.0.0/8"]}`, http.StatusOK, &tr)
+	if !tr.On || len(tr.Types) != 1 || tr.Types[0] != "Twrite" || tr.Prefix != "/logs" || len(tr.Remote) != 1 {
+		t.Errorf("PUT /trace: got %+v, want Twrite under /logs from 10.0.0.0/8", tr)
+	}
+	if f := l.TraceFilter(); f == nil || f.Prefix != "/logs" {
+		t.Errorf("TraceFilter after PUT /trace: got %+v, want Prefix /logs", f)
+	}
+	do("PUT", "/trace", `{"Every": 10, "Errors": true, "BytesPerSecond": 4096}`, http.StatusOK, &tr)
+	if !tr.On || tr.Every != 10 || !tr.Errors || tr.BytesPerSecond != 4096 {
+		t.Errorf("PUT /trace: got %+v, want 1 in 10, errors, 4096 bytes a second", tr)
+	}
+	do("PUT", "/trace", `{"BytesPerSecond": -1}`, http.StatusBadRequest, nil)
+	do("PUT", "/trace", `{"Types": ["Rwrite"]}`, http.StatusBadRequest, nil)
+	do("PUT", "/trace", `{"Remote": ["10.0.0.0/99"]}`, http.StatusBadRequest, nil)
+	do("DELETE", "/trace", "", http.StatusOK, &tr)
+	if tr.On || l.TraceFilter() != protocol.TraceNothing {
+		t.Errorf("DELETE /trace: got %+v, filter %v, want nothing traced", tr, l.TraceFilter())
+	}
+
+	do("POST", fmt.Sprintf("/conns/%d/evict", cs[0].ID), "", http.StatusNoContent, nil)
+	if _, err := p.Read(make([]byte, 1)); err == nil {
+		t.Errorf("Read from evicted connection: want err, got nil")
+	}
+	do("POST", "/conns/99/evict", "", http.StatusNotFound, nil)
+	do("POST", "/conns/x/evict", "", http.StatusBadRequest, nil)
+	do("DELETE", "/readonly", "", http.StatusMethodNotAllowed, nil)
+}
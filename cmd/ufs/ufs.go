@@ -6,35 +6,234 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
 	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"harvey-os.org/internal/ufs"
+	"harvey-os.org/pkg/ninep"
 	"harvey-os.org/pkg/ninep/protocol"
 )
 
 var (
-	ntype = flag.String("net", "tcp4", "Default network type")
-	naddr = flag.String("addr", ":5640", "Network address")
-	debug = flag.Int("debug", 0, "print debug messages")
-	root  = flag.String("root", "/", "Set the root for all attaches")
+	ntype    = flag.String("net", "tcp4", "Default network type, or pipe for a Windows named pipe such as \\\\.\\pipe\\ufs")
+	naddr    = flag.String("addr", ":5640", "Network address")
+	debug    = flag.Int("debug", 0, "print debug messages")
+	root     = flag.String("root", "/", "Set the root for all attaches: a directory, or a single file to export only it")
+	qids     = flag.String("qidfile", "", "Keep QIDs in this file, so they survive a restart")
+	umask    = flag.String("umask", "", "Create files with the permissions clients ask for, less this octal umask")
+	force    = flag.String("forceperm", "", "Create files and directories with these octal permissions, as file,dir")
+	inherit  = flag.Bool("inheritperm", false, "Limit the permissions of created files to their directory's, as Plan 9 does")
+	muid     = flag.Bool("muidxattr", false, "Keep the last modifier of each file in an extended attribute")
+	atime    = flag.String("atime", "", "Update access times on read as the host does, or: strict, rel, or no")
+	mgmtAddr = flag.String("mgmt", "", "Serve the JSON management API over HTTP at this address, e.g. localhost:5641")
+	readOnly = flag.Bool("readonly", false, "Refuse to change anything")
+	appendTo = flag.Bool("append", false, "Make files append-only, for shipping logs: writes go to the end, and reads at the end wait for more, as tail -f does")
+	notify   = flag.Bool("notify", false, "Watch for changes made on the host, so that clients see them at once (Linux and Windows)")
+	session  = flag.Bool("session", false, "Accept resumable sessions, which survive dropped connections, instead of plain connections")
+	peer     = flag.Bool("peerauth", false, "Make clients on a Unix socket attach as the user they run as")
+	users    = flag.String("users", "", "Name file owners, and check permissions, with this user database: os, numeric, or a file of id:name:leader:members lines")
+	janitor  = flag.Duration("janitor", 0, "Remove temporary files, and files opened ORCLOSE, left alone this long, e.g. 24h")
+	temps    = flag.String("tempnames", "*.tmp,.#*", "Comma-separated patterns of temporary file names, for -janitor")
+	budget   = flag.String("budget", "", "Fail operations on host files which take longer than this, as a duration, and Tname=duration for each type, e.g. 30s,Tread=2m")
+	quotaB   = flag.Uint64("quotabytes", 0, "Warn when the clients attached as a user have written more than this many bytes")
+	quotaF   = flag.Uint64("quotafiles", 0, "Warn when the clients attached as a user have created more than this many files")
+	quotaLog = flag.String("quotastatus", "", "Append quota warnings to this file, named from the root, for clients to read, rather than logging them")
+	maxMsize = flag.Uint("maxmsize", protocol.MaxMsize, "Largest msize to agree to, in bytes; bigger ones move bulk data faster on fast networks")
 )
 
+// userDB returns the user database named by the -users flag.
+func userDB() (ninep.UserDB, error) {
+	switch *users {
+	case "os":
+		return ninep.OSUsers{}, nil
+	case "numeric":
+		return ninep.NumericUsers{}, nil
+	}
+	f, err := os.Open(*users)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ninep.ReadUsers(f)
+}
+
+// atimePolicy returns the access time policy named by the -atime flag.
+func atimePolicy() (ninep.AtimePolicy, error) {
+	switch *atime {
+	case "strict":
+		return ninep.StrictAtime, nil
+	case "rel":
+		return ninep.RelAtime, nil
+	case "no":
+		return ninep.NoAtime, nil
+	}
+	return nil, fmt.Errorf("atime %q: want strict, rel or no", *atime)
+}
+
+// budgets returns the Budgets set by the -budget flag.
+func budgets() (*ninep.Budgets, error) {
+	b := &ninep.Budgets{}
+	for _, f := range strings.Split(*budget, ",") {
+		name, v := "", f
+		if i := strings.Index(f, "="); i >= 0 {
+			name, v = f[:i], f[i+1:]
+		}
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("budget %q: %v", f, err)
+		}
+		if name == "" {
+			b.Default = d
+			continue
+		}
+		t, ok := mtypes[name]
+		if !ok {
+			return nil, fmt.Errorf("budget %q: no message type %v", f, name)
+		}
+		if b.ByType == nil {
+			b.ByType = make(map[protocol.MType]time.Duration)
+		}
+		b.ByType[t] = d
+	}
+	return b, nil
+}
+
+// mtypes are the T-message types, by name.
+var mtypes = func() map[string]protocol.MType {
+	m := make(map[string]protocol.MType)
+	for t, n := range protocol.RPCNames {
+		if strings.HasPrefix(n, "T") {
+			m[n] = t
+		}
+	}
+	return m
+}()
+
+// permPolicy returns the create permission policy set by the flags, if any.
+func permPolicy() ([]ufs.Opt, error) {
+	var opts []ufs.Opt
+	if *umask != "" {
+		m, err := strconv.ParseUint(*umask, 8, 32)
+		if err != nil {
+			return nil, fmt.Errorf("umask %q: %v", *umask, err)
+		}
+		opts = append(opts, ufs.CreatePerm(ufs.Umask(os.FileMode(m))))
+	}
+	if *force != "" {
+		var f, d uint32
+		if _, err := fmt.Sscanf(*force, "%o,%o", &f, &d); err != nil {
+			return nil, fmt.Errorf("forceperm %q: want file,dir in octal: %v", *force, err)
+		}
+		opts = append(opts, ufs.CreatePerm(ufs.ForcePerm(os.FileMode(f), os.FileMode(d))))
+	}
+	if *inherit {
+		opts = append(opts, ufs.CreatePerm(ufs.InheritPerm))
+	}
+	return opts, nil
+}
+
 func main() {
 	flag.Parse()
 
-	ln, err := net.Listen(*ntype, *naddr)
+	var ln net.Listener
+	var err error
+	if *ntype == "pipe" {
+		ln, err = protocol.ListenPipe(*naddr)
+	} else {
+		ln, err = net.Listen(*ntype, *naddr)
+	}
 	if err != nil {
 		log.Fatalf("Listen failed: %v", err)
 	}
+	if *session {
+		ln = protocol.SessionListener(ln, 0)
+	}
+
+	fsopts, err := permPolicy()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *qids != "" {
+		fsopts = append(fsopts, ufs.QIDFile(*qids))
+	}
+	if *muid {
+		fsopts = append(fsopts, ufs.MuidXattr())
+	}
+	if *atime != "" {
+		p, err := atimePolicy()
+		if err != nil {
+			log.Fatal(err)
+		}
+		fsopts = append(fsopts, ufs.Atime(p))
+	}
+	if *peer {
+		fsopts = append(fsopts, ufs.PeerAuth())
+	}
+	if *readOnly {
+		fsopts = append(fsopts, ufs.ReadOnly())
+	}
+	if *appendTo {
+		fsopts = append(fsopts, ufs.AppendOnly())
+	}
+	if *notify {
+		fsopts = append(fsopts, ufs.Notify())
+	}
+	if *janitor != 0 {
+		var pats []string
+		if *temps != "" {
+			pats = strings.Split(*temps, ",")
+		}
+		fsopts = append(fsopts, ufs.Janitor(*janitor, pats...))
+	}
+	if *quotaB != 0 || *quotaF != 0 {
+		fsopts = append(fsopts, ufs.SoftQuota(ufs.Quota{Written: *quotaB, Created: *quotaF}, *quotaLog))
+	}
+	var b *ninep.Budgets
+	if *budget != "" {
+		if b, err = budgets(); err != nil {
+			log.Fatal(err)
+		}
+		fsopts = append(fsopts, ufs.Budgets(b))
+	}
+	var ctl ufs.Control
+	fsopts = append(fsopts, ufs.Controlled(&ctl))
+	if *users != "" {
+		db, err := userDB()
+		if err != nil {
+			log.Fatal(err)
+		}
+		fsopts = append(fsopts, ufs.Users(db))
+	}
 
-	ufslistener, err := ufs.NewUFS(*root, *debug, func(l *protocol.Listener) error {
+	ufslistener, err := ufs.NewServer(*root, *debug, fsopts, func(l *protocol.Listener) error {
 		l.Trace = nil
-		if *debug > 1 {
+		switch {
+		case *debug > 1:
 			l.Trace = log.Printf
+		case *mgmtAddr != "":
+			// Quiet until the management API says what to trace.
+			l.Trace = log.Printf
+			l.SetTraceFilter(protocol.TraceNothing)
 		}
+		l.MaxMsize = uint32(*maxMsize)
 		return nil
 	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *mgmtAddr != "" {
+		m := &mgmt{l: ufslistener, ctl: &ctl, budgets: b}
+		go func() {
+			log.Fatal(http.ListenAndServe(*mgmtAddr, m.handler()))
+		}()
+	}
 
 	if err := ufslistener.Serve(ln); err != nil {
 		log.Fatal(err)
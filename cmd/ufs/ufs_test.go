@@ -0,0 +1,26 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"harvey-os.org/pkg/ninep/protocol"
+)
+
+func TestBudgetFlag(t *testing.T) {
+	*budget = "30s,Tread=2m,Twalk=1s"
+	b, err := budgets()
+	if err != nil {
+		t.Fatalf("budgets: want nil, got %v", err)
+	}
+	if b.Default != 30*time.Second || b.Budget(protocol.Tread) != 2*time.Minute || b.Budget(protocol.Twalk) != time.Second {
+		t.Errorf("budgets of %q: got %v default, %v", *budget, b.Default, b.ByType)
+	}
+	for _, s := range []string{"forever", "Rread=1s", "Tnope=1s"} {
+		*budget = s
+		if _, err := budgets(); err == nil {
+			t.Errorf("budgets of %q: want err, got nil", s)
+		}
+	}
+	*budget = ""
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"syscall"
+
+	"harvey-os.org/pkg/ninep/protocol"
+)
+
+// mount mounts what the server at d serves on dir, with the kernel's
+// v9fs. v9fs has no vsock transport, so for vsock uufs connects and hands
+// the kernel the connection, as it does standard input and output.
+func mount(d *dialString, dir, aname string) error {
+	var source, data string
+	switch d.net {
+	case "tcp":
+		// v9fs wants an address, not a name.
+		a, err := net.ResolveTCPAddr("tcp", net.JoinHostPort(d.host, d.port))
+		if err != nil {
+			return err
+		}
+		source, data = a.IP.String(), "trans=tcp,port="+strconv.Itoa(a.Port)
+	case "unix":
+		source, data = d.host, "trans=unix"
+	case "vsock":
+		cid, port, err := d.vsockPort()
+		if err != nil {
+			return err
+		}
+		c, err := protocol.DialVsock(cid, port)
+		if err != nil {
+			return err
+		}
+		// The mount holds its own reference to the connection.
+		defer c.Close()
+		fd := c.(interface{ Fd() uintptr }).Fd()
+		source, data = "vsock", fmt.Sprintf("trans=fd,rfdno=%d,wfdno=%d", fd, fd)
+	case "stdio":
+		source, data = "stdio", "trans=fd,rfdno=0,wfdno=1"
+	}
+	if aname != "" {
+		data += ",aname=" + aname
+	}
+	if err := syscall.Mount(source, dir, "9p", 0, data); err != nil {
+		return fmt.Errorf("mount %v on %v: %v", source, dir, err)
+	}
+	return nil
+}
@@ -0,0 +1,9 @@
+// +build !linux
+
+package main
+
+import "fmt"
+
+func mount(d *dialString, dir, aname string) error {
+	return fmt.Errorf("mount: v9fs mounts need linux")
+}
@@ -0,0 +1,209 @@
+// uufs is ufs cut down for u-root initramfs images: a static binary,
+// built with CGO_ENABLED=0, which needs no configuration files, and can
+// serve, or mount, over vsock and stdio as well as the network.
+//
+// It serves root, / by default, at the address given, or tcp!*!5640:
+//
+//	uufs [-root dir] [-ro] [addr]
+//
+// or, with -m, mounts what a server at addr serves on dir:
+//
+//	uufs -m dir [-aname name] addr
+//
+// Addresses are Plan 9 dial strings:
+//
+//	tcp!host!port    a TCP port; * as the host, for serving, is any
+//	unix!path        a Unix socket
+//	vsock!cid!port   a vsock port, of the virtual machine or host cid;
+//	                 the cid is ignored for serving
+//	stdio            standard input and output, for serving one client
+//	                 at the end of a pipe, or for mounting with an fd
+//	                 passed by whatever started uufs
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"harvey-os.org/internal/ufs"
+	"harvey-os.org/pkg/ninep/protocol"
+)
+
+var (
+	root  = flag.String("root", "/", "Serve this directory")
+	ro    = flag.Bool("ro", false, "Serve read-only")
+	mnt   = flag.String("m", "", "Mount addr on this directory, rather than serve")
+	aname = flag.String("aname", "", "Tree to attach to, for -m")
+)
+
+// dialString is a parsed dial string.
+type dialString struct {
+	net  string
+	host string
+	port string
+}
+
+func parseDial(s string) (*dialString, error) {
+	f := strings.Split(s, "!")
+	d := &dialString{net: f[0]}
+	switch {
+	case d.net == "stdio" && len(f) == 1:
+	case d.net == "unix" && len(f) == 2:
+		d.host = f[1]
+	case (d.net == "tcp" || d.net == "vsock") && len(f) == 3:
+		d.host, d.port = f[1], f[2]
+	default:
+		return nil, fmt.Errorf("%q: want tcp!host!port, unix!path, vsock!cid!port or stdio", s)
+	}
+	return d, nil
+}
+
+// vsockPort parses the cid and port of a vsock dial string.
+func (d *dialString) vsockPort() (uint32, uint32, error) {
+	var cid uint64
+	var err error
+	if d.host != "*" {
+		if cid, err = strconv.ParseUint(d.host, 10, 32); err != nil {
+			return 0, 0, fmt.Errorf("vsock cid %q: %v", d.host, err)
+		}
+	}
+	port, err := strconv.ParseUint(d.port, 10, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("vsock port %q: %v", d.port, err)
+	}
+	return uint32(cid), uint32(port), nil
+}
+
+// listen listens at d.
+func listen(d *dialString) (net.Listener, error) {
+	switch d.net {
+	case "tcp":
+		host := d.host
+		if host == "*" {
+			host = ""
+		}
+		return net.Listen("tcp", net.JoinHostPort(host, d.port))
+	case "unix":
+		return net.Listen("unix", d.host)
+	case "vsock":
+		_, port, err := d.vsockPort()
+		if err != nil {
+			return nil, err
+		}
+		return protocol.ListenVsock(port)
+	}
+	return newStdioListener(), nil
+}
+
+// stdioConn is a connection on standard input and output.
+type stdioConn struct {
+	once sync.Once
+	done chan struct{}
+}
+
+type stdioAddr struct{}
+
+func (stdioAddr) Network() string { return "stdio" }
+func (stdioAddr) String() string  { return "stdio" }
+
+func (c *stdioConn) Read(b []byte) (int, error)  { return os.Stdin.Read(b) }
+func (c *stdioConn) Write(b []byte) (int, error) { return os.Stdout.Write(b) }
+
+func (c *stdioConn) Close() error {
+	c.once.Do(func() { close(c.done) })
+	return nil
+}
+
+func (c *stdioConn) LocalAddr() net.Addr                { return stdioAddr{} }
+func (c *stdioConn) RemoteAddr() net.Addr               { return stdioAddr{} }
+func (c *stdioConn) SetDeadline(t time.Time) error      { return os.Stdin.SetDeadline(t) }
+func (c *stdioConn) SetReadDeadline(t time.Time) error  { return os.Stdin.SetReadDeadline(t) }
+func (c *stdioConn) SetWriteDeadline(t time.Time) error { return os.Stdout.SetWriteDeadline(t) }
+
+// stdioListener accepts the one connection on standard input and output,
+// and then, once it is closed, says it is done.
+type stdioListener struct {
+	c    chan net.Conn
+	conn *stdioConn
+}
+
+func newStdioListener() *stdioListener {
+	l := &stdioListener{c: make(chan net.Conn, 1), conn: &stdioConn{done: make(chan struct{})}}
+	l.c <- l.conn
+	return l
+}
+
+func (l *stdioListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.c:
+		return c, nil
+	case <-l.conn.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *stdioListener) Close() error {
+	return l.conn.Close()
+}
+
+func (l *stdioListener) Addr() net.Addr {
+	return stdioAddr{}
+}
+
+func serve(d *dialString) error {
+	ln, err := listen(d)
+	if err != nil {
+		return err
+	}
+	var opts []ufs.Opt
+	if *ro {
+		opts = append(opts, ufs.ReadOnly())
+	}
+	l, err := ufs.NewServer(*root, 0, opts, func(l *protocol.Listener) error {
+		l.Trace = nil
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	err = l.Serve(ln)
+	if d.net == "stdio" && err == net.ErrClosed {
+		return nil
+	}
+	return err
+}
+
+func main() {
+	log.SetPrefix("uufs: ")
+	log.SetFlags(0)
+	flag.Parse()
+
+	addr := "tcp!*!5640"
+	switch flag.NArg() {
+	case 0:
+	case 1:
+		addr = flag.Arg(0)
+	default:
+		flag.Usage()
+		os.Exit(1)
+	}
+	d, err := parseDial(addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *mnt != "" {
+		err = mount(d, *mnt, *aname)
+	} else {
+		err = serve(d)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
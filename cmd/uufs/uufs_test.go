@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseDial(t *testing.T) {
+	for _, tc := range []struct {
+		s    string
+		want *dialString
+	}{
+		{"tcp!*!5640", &dialString{net: "tcp", host: "*", port: "5640"}},
+		{"unix!/tmp/ufs", &dialString{net: "unix", host: "/tmp/ufs"}},
+		{"vsock!2!564", &dialString{net: "vsock", host: "2", port: "564"}},
+		{"stdio", &dialString{net: "stdio"}},
+		{"tcp!host", nil},
+		{"stdio!x", nil},
+		{"udp!host!53", nil},
+	} {
+		got, err := parseDial(tc.s)
+		if tc.want == nil {
+			if err == nil {
+				t.Errorf("parseDial(%q): want error, got %+v", tc.s, got)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("parseDial(%q): got %+v, %v, want %+v, nil", tc.s, got, err, tc.want)
+		}
+	}
+
+	d := &dialString{net: "vsock", host: "*", port: "564"}
+	if cid, port, err := d.vsockPort(); err != nil || cid != 0 || port != 564 {
+		t.Errorf("vsockPort(*!564): got %v, %v, %v, want 0, 564, nil", cid, port, err)
+	}
+	d.host = "vm"
+	if _, _, err := d.vsockPort(); err == nil {
+		t.Errorf("vsockPort(vm!564): want error, got nil")
+	}
+}
+
+// TestStdioListener checks that the stdio listener gives one connection,
+// and is done once that is closed, so that serving it ends.
+func TestStdioListener(t *testing.T) {
+	l := newStdioListener()
+	c, err := l.Accept()
+	if err != nil {
+		t.Fatalf("Accept: want nil, got %v", err)
+	}
+	done := make(chan error)
+	go func() {
+		_, err := l.Accept()
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("second Accept: want it to wait, got %v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+	c.Close()
+	if err := <-done; err != net.ErrClosed {
+		t.Errorf("Accept after Close: got %v, want %v", err, net.ErrClosed)
+	}
+}
@@ -1,3 +1,3 @@
 module harvey-os.org
 
-go 1.14
+go 1.16
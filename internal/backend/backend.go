@@ -0,0 +1,136 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package backend has what the small file servers, ramfs, iofs and kvfs,
+// share: the table of a connection's fids, the messages every one of
+// them answers the same way, and reading a directory in order.
+package backend
+
+import (
+	"io"
+
+	"harvey-os.org/pkg/ninep/protocol"
+)
+
+// Base answers Tversion, for 9P2000 only, and Tflush, which has nothing
+// to do in a server which answers each request before the next. A
+// backend embeds it in its NineServer.
+type Base struct{}
+
+func (Base) Rversion(msize protocol.MaxSize, version string) (protocol.MaxSize, string, error) {
+	if version != "9P2000" {
+		return 0, "", protocol.Errorf(protocol.ErrNotSupported, "%v: only 9P2000", version)
+	}
+	return msize, version, nil
+}
+
+func (Base) Rflush(o protocol.Tag) error {
+	return nil
+}
+
+// Fids are a connection's fids, each referring to whatever the backend
+// keeps for it. The backend guards them with its own lock. The zero
+// Fids has none.
+type Fids struct {
+	m map[protocol.FID]interface{}
+}
+
+// Get returns what f refers to.
+func (t *Fids) Get(f protocol.FID) (interface{}, error) {
+	v, ok := t.m[f]
+	if !ok {
+		return nil, protocol.Errorf(protocol.ErrUnknownFID, "fid %d", f)
+	}
+	return v, nil
+}
+
+// Set makes f refer to v, if it is not in use, or if it is old, as in
+// a walk of a fid to itself.
+func (t *Fids) Set(f, old protocol.FID, v interface{}) error {
+	if _, ok := t.m[f]; ok && f != old {
+		return protocol.Errorf(protocol.ErrFIDInUse, "fid %d", f)
+	}
+	if t.m == nil {
+		t.m = make(map[protocol.FID]interface{})
+	}
+	t.m[f] = v
+	return nil
+}
+
+// Attach makes f refer to v, the root, for a Tattach with afid, which
+// must be NOFID: backends need no authentication.
+func (t *Fids) Attach(f, afid protocol.FID, v interface{}) error {
+	if afid != protocol.NOFID {
+		return protocol.Errorf(protocol.ErrNotSupported, "auth attach")
+	}
+	return t.Set(f, protocol.NOFID, v)
+}
+
+// Clunk forgets f, returning what it referred to.
+func (t *Fids) Clunk(f protocol.FID) (interface{}, error) {
+	v, err := t.Get(f)
+	if err != nil {
+		return nil, err
+	}
+	delete(t.m, f)
+	return v, nil
+}
+
+// Walked returns the result of a Twalk of paths which got as far as q:
+// as in the RFC, it is an error if not even the first element could be
+// walked. done reports whether the whole walk was, so that newfid is
+// to be set.
+func Walked(q []protocol.QID, paths []string) (qids []protocol.QID, done bool, err error) {
+	if len(q) == len(paths) {
+		return q, true, nil
+	}
+	if len(q) == 0 {
+		return nil, false, protocol.Errorf(protocol.ErrNotExist, "%v", paths[0])
+	}
+	return q, false, nil
+}
+
+// Dirs reads a directory's entries, each read carrying on from the one
+// before. A read is at 0, which starts again, or where the last stopped.
+// A backend keeps one with each open directory.
+type Dirs struct {
+	off  protocol.Offset
+	next int
+	dirs protocol.DirPacker
+}
+
+// Read returns as many entries as fit in c bytes, read at o. At 0, it
+// calls start, if it is not nil, to read the directory again. entry
+// returns the nth entry, io.EOF after the last, or another error for an
+// entry to be skipped, such as one which went away since the directory
+// was read.
+func (d *Dirs) Read(o protocol.Offset, c protocol.Count, start func() error, entry func(n int) (protocol.Dir, error)) ([]byte, error) {
+	switch o {
+	case 0:
+		if start != nil {
+			if err := start(); err != nil {
+				return nil, err
+			}
+		}
+		d.off, d.next = 0, 0
+		d.dirs.Reset()
+	case d.off:
+	default:
+		return nil, protocol.Errorf(protocol.ErrInvalid, "directory offset %d, want 0 or %d", o, d.off)
+	}
+	b, err := d.dirs.Pack(c, func() (protocol.Dir, error) {
+		for {
+			e, err := entry(d.next)
+			if err == io.EOF {
+				return protocol.Dir{}, io.EOF
+			}
+			d.next++
+			if err == nil {
+				return e, nil
+			}
+		}
+	})
+	d.off += protocol.Offset(len(b))
+	return b, err
+}
@@ -0,0 +1,109 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+
+	"harvey-os.org/pkg/ninep/protocol"
+)
+
+func TestFids(t *testing.T) {
+	var fids Fids
+	if _, err := fids.Get(1); err == nil {
+		t.Errorf("Get(1) of no fids: want err, got nil")
+	}
+	if err := fids.Attach(1, 2, "root"); err == nil {
+		t.Errorf("Attach with afid 2: want err, got nil")
+	}
+	if err := fids.Attach(1, protocol.NOFID, "root"); err != nil {
+		t.Fatalf("Attach: want nil, got %v", err)
+	}
+	if err := fids.Set(1, protocol.NOFID, "other"); err == nil {
+		t.Errorf("Set(1) in use: want err, got nil")
+	}
+	if err := fids.Set(1, 1, "walked"); err != nil {
+		t.Errorf("Set(1) walking 1: want nil, got %v", err)
+	}
+	if v, err := fids.Clunk(1); err != nil || v != "walked" {
+		t.Errorf("Clunk(1): got %v, %v, want walked, nil", v, err)
+	}
+	if _, err := fids.Clunk(1); err == nil {
+		t.Errorf("Clunk(1) twice: want err, got nil")
+	}
+}
+
+func TestWalked(t *testing.T) {
+	q := []protocol.QID{{Path: 1}, {Path: 2}}
+	for _, tc := range []struct {
+		n          int
+		done, fail bool
+	}{
+		{n: 0, fail: true},
+		{n: 1},
+		{n: 2, done: true},
+	} {
+		got, done, err := Walked(q[:tc.n], []string{"a", "b"})
+		if done != tc.done || (err != nil) != tc.fail || (!tc.fail && len(got) != tc.n) {
+			t.Errorf("Walked of %d of 2: got %v, %v, %v, want %d qids, done %v, err %v", tc.n, got, done, err, tc.n, tc.done, tc.fail)
+		}
+	}
+}
+
+func TestDirs(t *testing.T) {
+	names := []string{"a", "gone", "b", "c"}
+	starts := 0
+	start := func() error {
+		starts++
+		return nil
+	}
+	entry := func(n int) (protocol.Dir, error) {
+		if n >= len(names) {
+			return protocol.Dir{}, io.EOF
+		}
+		if names[n] == "gone" {
+			return protocol.Dir{}, fmt.Errorf("gone")
+		}
+		return protocol.Dir{Name: names[n]}, nil
+	}
+	read := func(o protocol.Offset) []string {
+		var d Dirs
+		var got []string
+		for {
+			b, err := d.Read(o, 8192, start, entry)
+			if err != nil {
+				t.Fatalf("Read(%d): want nil, got %v", o, err)
+			}
+			if len(b) == 0 {
+				return got
+			}
+			o += protocol.Offset(len(b))
+			for r := bytes.NewBuffer(b); r.Len() > 0; {
+				e, err := protocol.Unmarshaldir(r)
+				if err != nil {
+					t.Fatalf("Unmarshaldir: %v", err)
+				}
+				got = append(got, e.Name)
+			}
+		}
+	}
+	if got := fmt.Sprint(read(0)); got != "[a b c]" {
+		t.Errorf("Read: got %v, want [a b c]", got)
+	}
+	if starts != 1 {
+		t.Errorf("Read: started %d times, want 1", starts)
+	}
+
+	var d Dirs
+	if _, err := d.Read(0, 8192, start, entry); err != nil {
+		t.Fatalf("Read(0): want nil, got %v", err)
+	}
+	if _, err := d.Read(1, 8192, start, entry); err == nil {
+		t.Errorf("Read(1) after a read at 0: want err, got nil")
+	}
+}
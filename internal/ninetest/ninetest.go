@@ -0,0 +1,63 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package ninetest connects test clients to 9P servers over net.Pipe,
+// so that tests of servers can get on with talking to them.
+package ninetest
+
+import (
+	"net"
+	"testing"
+
+	"harvey-os.org/pkg/ninep/protocol"
+)
+
+// An Acceptor serves 9P on the connections it is given, as a
+// protocol.Listener does.
+type Acceptor interface {
+	Accept(net.Conn) error
+}
+
+// Pipe has l serve one end of a net.Pipe, and returns a client of the
+// other end, with an msize of 8192 unless opts change it. The pipe is
+// closed when the test ends.
+func Pipe(t testing.TB, l Acceptor, opts ...protocol.ClientOpt) *protocol.Client {
+	t.Helper()
+	p, p2 := net.Pipe()
+	t.Cleanup(func() { p.Close() })
+	c, err := protocol.NewClient(append([]protocol.ClientOpt{func(c *protocol.Client) error {
+		c.FromNet, c.ToNet = p, p
+		c.Msize = 8192
+		return nil
+	}}, opts...)...)
+	if err != nil {
+		t.Fatalf("NewClient: want nil, got %v", err)
+	}
+	if err := l.Accept(p2); err != nil {
+		t.Fatalf("Accept: want nil, got %v", err)
+	}
+	return c
+}
+
+// Serve is Pipe, for a new Listener of ns.
+func Serve(t testing.TB, ns protocol.NineServer, opts ...protocol.ListenerOpt) *protocol.Client {
+	t.Helper()
+	l, err := protocol.NewListener(func() protocol.NineServer { return ns }, opts...)
+	if err != nil {
+		t.Fatalf("NewListener: want nil, got %v", err)
+	}
+	return Pipe(t, l)
+}
+
+// Attach does a 9P2000 Tversion on c, and attaches fid 0 to the root
+// as uname.
+func Attach(t testing.TB, c *protocol.Client, uname string) {
+	t.Helper()
+	if _, _, err := c.CallTversion(8192, "9P2000"); err != nil {
+		t.Fatalf("CallTversion: want nil, got %v", err)
+	}
+	if _, err := c.CallTattach(0, protocol.NOFID, uname, ""); err != nil {
+		t.Fatalf("CallTattach: want nil, got %v", err)
+	}
+}
@@ -0,0 +1,546 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package ramfs is a 9P file server which keeps its files in memory.
+// Every connection to a server sees the same files.
+package ramfs
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"harvey-os.org/internal/backend"
+	"harvey-os.org/pkg/ninep"
+	"harvey-os.org/pkg/ninep/protocol"
+)
+
+// node is a file or directory.
+type node struct {
+	protocol.Dir
+	data     []byte
+	parent   *node
+	children []*node
+}
+
+func (n *node) isDir() bool {
+	return n.Mode&protocol.DMDIR != 0
+}
+
+func (n *node) child(name string) (*node, int) {
+	for i, c := range n.children {
+		if c.Name == name {
+			return c, i
+		}
+	}
+	return nil, -1
+}
+
+// FS is a tree of files in memory.
+type FS struct {
+	// mu guards everything in the tree, and all fids.
+	mu   sync.Mutex
+	root *node
+	path uint64
+
+	// snaps are the snapshots, by name, each a copy of the tree.
+	snaps map[string]*node
+
+	user, group string
+
+	// atime decides whether reads update access times.
+	atime ninep.AtimePolicy
+
+	// clock is the time files are changed and read at.
+	clock protocol.Clock
+}
+
+// Opt is an option for New.
+type Opt func(*FS) error
+
+// RootOwner sets the owner and group of the root directory, which are
+// "none" by default.
+func RootOwner(user, group string) Opt {
+	return func(fs *FS) error {
+		fs.user, fs.group = user, group
+		return nil
+	}
+}
+
+// Atime sets the policy for updating access times on reads. By default,
+// every read updates them.
+func Atime(p ninep.AtimePolicy) Opt {
+	return func(fs *FS) error {
+		fs.atime = p
+		return nil
+	}
+}
+
+// Clock sets the clock which file times come from. By default, it is
+// protocol.SystemClock.
+func Clock(clock protocol.Clock) Opt {
+	return func(fs *FS) error {
+		fs.clock = clock
+		return nil
+	}
+}
+
+// New returns an empty FS, with a root directory anyone can write.
+func New(opts ...Opt) (*FS, error) {
+	fs := &FS{user: "none", group: "none", atime: ninep.StrictAtime, clock: protocol.SystemClock}
+	for _, o := range opts {
+		if err := o(fs); err != nil {
+			return nil, err
+		}
+	}
+	fs.root = fs.newNode(nil, "/", protocol.DMDIR|0777, fs.user, fs.group)
+	fs.root.parent = fs.root
+	return fs, nil
+}
+
+// newNode makes a node, owned and last modified by user.
+func (fs *FS) newNode(parent *node, name string, perm uint32, user, group string) *node {
+	fs.path++
+	now := uint32(fs.clock.Now().Unix())
+	n := &node{parent: parent}
+	n.QID = protocol.QID{Path: fs.path}
+	if perm&protocol.DMDIR != 0 {
+		n.QID.Type = protocol.QTDIR
+	}
+	if perm&protocol.DMAPPEND != 0 {
+		n.QID.Type |= protocol.QTAPPEND
+	}
+	n.Mode = perm
+	n.Atime, n.Mtime = now, now
+	n.Name = name
+	n.User, n.Group, n.ModUser = user, group, user
+	return n
+}
+
+// modified notes that user changed the contents of n.
+func (fs *FS) modified(n *node, user string) {
+	n.Length = uint64(len(n.data))
+	n.Mtime = uint32(fs.clock.Now().Unix())
+	n.ModUser = user
+	n.QID.Version++
+}
+
+// Snapshot saves the files as they are now, as a snapshot called name,
+// to which clients attach, read-only, with an aname of "@name".
+func (fs *FS) Snapshot(name string) error {
+	if name == "" || strings.Contains(name, "@") {
+		return protocol.Errorf(protocol.ErrInvalid, "snapshot name %q", name)
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if _, ok := fs.snaps[name]; ok {
+		return protocol.Errorf(protocol.ErrExist, "snapshot %v", name)
+	}
+	if fs.snaps == nil {
+		fs.snaps = make(map[string]*node)
+	}
+	r := fs.root.copy(nil)
+	r.parent = r
+	fs.snaps[name] = r
+	return nil
+}
+
+// copy returns a copy of n and everything under it, in parent.
+func (n *node) copy(parent *node) *node {
+	c := &node{Dir: n.Dir, parent: parent, data: append([]byte(nil), n.data...)}
+	for _, k := range n.children {
+		c.children = append(c.children, k.copy(c))
+	}
+	return c
+}
+
+// NewServer serves fs.
+func NewServer(fs *FS, opts ...protocol.ListenerOpt) (*protocol.Listener, error) {
+	return protocol.NewListener(func() protocol.NineServer {
+		return &fileServer{fs: fs}
+	}, opts...)
+}
+
+type fid struct {
+	n    *node
+	open bool
+	mode protocol.Mode
+	dirs backend.Dirs
+}
+
+// fileServer is the NineServer for one connection.
+type fileServer struct {
+	backend.Base
+	fs    *FS
+	uname string
+	fids  backend.Fids
+}
+
+// In ramfs, as in Plan 9, a group is a user, and has no other members.
+var users = ninep.NumericUsers{}
+
+func (s *fileServer) allowed(n *node, want uint32) error {
+	if !ninep.Allowed(users, s.uname, n.Dir, want) {
+		return protocol.Errorf(protocol.ErrPermission, "%v", n.Name)
+	}
+	return nil
+}
+
+func (s *fileServer) getFid(f protocol.FID) (*fid, error) {
+	v, err := s.fids.Get(f)
+	if err != nil {
+		return nil, err
+	}
+	return v.(*fid), nil
+}
+
+// clunk forgets f, returning its fid.
+func (s *fileServer) clunk(f protocol.FID) (*fid, error) {
+	v, err := s.fids.Clunk(f)
+	if err != nil {
+		return nil, err
+	}
+	return v.(*fid), nil
+}
+
+func (s *fileServer) Rattach(f protocol.FID, afid protocol.FID, uname string, aname string) (protocol.QID, error) {
+	s.fs.mu.Lock()
+	defer s.fs.mu.Unlock()
+	if err := s.fids.Attach(f, afid, &fid{n: s.fs.root}); err != nil {
+		return protocol.QID{}, err
+	}
+	s.uname = uname
+	return s.fs.root.QID, nil
+}
+
+// RattachSnapshot attaches to the snapshot snap. The protocol package
+// sees to it that nothing in it changes.
+func (s *fileServer) RattachSnapshot(f protocol.FID, afid protocol.FID, uname, aname, snap string) (protocol.QID, error) {
+	s.fs.mu.Lock()
+	defer s.fs.mu.Unlock()
+	r, ok := s.fs.snaps[snap]
+	if !ok {
+		return protocol.QID{}, protocol.Errorf(protocol.ErrNotExist, "snapshot %v", snap)
+	}
+	if err := s.fids.Attach(f, afid, &fid{n: r}); err != nil {
+		return protocol.QID{}, err
+	}
+	s.uname = uname
+	return r.QID, nil
+}
+
+func (s *fileServer) Rwalk(f protocol.FID, newfid protocol.FID, paths []string) ([]protocol.QID, error) {
+	s.fs.mu.Lock()
+	defer s.fs.mu.Unlock()
+	i, err := s.getFid(f)
+	if err != nil {
+		return nil, err
+	}
+	if i.open {
+		return nil, protocol.Errorf(protocol.ErrInvalid, "walk of open fid %d", f)
+	}
+	n := i.n
+	var q []protocol.QID
+	for _, p := range paths {
+		if !n.isDir() {
+			break
+		}
+		if err := s.allowed(n, 1); err != nil {
+			break
+		}
+		if p == ".." {
+			n = n.parent
+		} else if n, _ = n.child(p); n == nil {
+			break
+		}
+		q = append(q, n.QID)
+	}
+	q, done, err := backend.Walked(q, paths)
+	if !done {
+		return q, err
+	}
+	if err := s.fids.Set(newfid, f, &fid{n: n}); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+func (s *fileServer) Ropen(f protocol.FID, mode protocol.Mode) (protocol.QID, protocol.MaxSize, error) {
+	s.fs.mu.Lock()
+	defer s.fs.mu.Unlock()
+	i, err := s.getFid(f)
+	if err != nil {
+		return protocol.QID{}, 0, err
+	}
+	if err := s.open(i, mode); err != nil {
+		return protocol.QID{}, 0, err
+	}
+	return i.n.QID, 0, nil
+}
+
+// open opens i, if its permissions allow it.
+func (s *fileServer) open(i *fid, mode protocol.Mode) error {
+	if i.open {
+		return protocol.Errorf(protocol.ErrInvalid, "fid already open")
+	}
+	var want uint32
+	switch mode & 3 {
+	case protocol.OREAD:
+		want = 4
+	case protocol.OWRITE:
+		want = 2
+	case protocol.ORDWR:
+		want = 6
+	case protocol.OEXEC:
+		want = 1
+	}
+	if mode&protocol.OTRUNC != 0 {
+		want |= 2
+	}
+	if i.n.isDir() && want&2 != 0 {
+		return protocol.Errorf(protocol.ErrIsDir, "%v", i.n.Name)
+	}
+	if err := s.allowed(i.n, want); err != nil {
+		return err
+	}
+	if mode&protocol.ORCLOSE != 0 {
+		if err := s.allowed(i.n.parent, 2); err != nil {
+			return err
+		}
+	}
+	if mode&protocol.OTRUNC != 0 && i.n.Mode&protocol.DMAPPEND == 0 {
+		i.n.data = nil
+		s.fs.modified(i.n, s.uname)
+	}
+	i.open, i.mode = true, mode
+	return nil
+}
+
+func (s *fileServer) Rcreate(f protocol.FID, name string, perm protocol.Perm, mode protocol.Mode) (protocol.QID, protocol.MaxSize, error) {
+	s.fs.mu.Lock()
+	defer s.fs.mu.Unlock()
+	i, err := s.getFid(f)
+	if err != nil {
+		return protocol.QID{}, 0, err
+	}
+	dir := i.n
+	switch {
+	case i.open:
+		return protocol.QID{}, 0, protocol.Errorf(protocol.ErrInvalid, "fid already open")
+	case !dir.isDir():
+		return protocol.QID{}, 0, protocol.Errorf(protocol.ErrNotDir, "%v", dir.Name)
+	case name == "" || name == "." || name == ".." || strings.Contains(name, "/"):
+		return protocol.QID{}, 0, protocol.Errorf(protocol.ErrInvalid, "name %q", name)
+	}
+	if c, _ := dir.child(name); c != nil {
+		return protocol.QID{}, 0, protocol.Errorf(protocol.ErrExist, "%v", name)
+	}
+	if err := s.allowed(dir, 2); err != nil {
+		return protocol.QID{}, 0, err
+	}
+	// As in Plan 9, the directory limits the permissions.
+	p := uint32(perm)
+	if p&protocol.DMDIR != 0 {
+		p &= ^uint32(0777) | dir.Mode&0777
+	} else {
+		p &= ^uint32(0666) | dir.Mode&0666
+	}
+	n := s.fs.newNode(dir, name, p, s.uname, dir.Group)
+	dir.children = append(dir.children, n)
+	s.fs.modified(dir, s.uname)
+	i.n = n
+	if err := s.open(i, mode); err != nil {
+		return protocol.QID{}, 0, err
+	}
+	return n.QID, 0, nil
+}
+
+func (s *fileServer) Rclunk(f protocol.FID) error {
+	s.fs.mu.Lock()
+	defer s.fs.mu.Unlock()
+	i, err := s.clunk(f)
+	if err != nil {
+		return err
+	}
+	if i.open && i.mode&protocol.ORCLOSE != 0 {
+		return s.remove(i.n)
+	}
+	return nil
+}
+
+func (s *fileServer) Rstat(f protocol.FID) ([]byte, error) {
+	s.fs.mu.Lock()
+	defer s.fs.mu.Unlock()
+	i, err := s.getFid(f)
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	protocol.Marshaldir(&b, i.n.Dir)
+	return b.Bytes(), nil
+}
+
+func (s *fileServer) Rwstat(f protocol.FID, b []byte) error {
+	s.fs.mu.Lock()
+	defer s.fs.mu.Unlock()
+	i, err := s.getFid(f)
+	if err != nil {
+		return err
+	}
+	d, err := protocol.Unmarshaldir(bytes.NewBuffer(b))
+	if err != nil {
+		return err
+	}
+	n := i.n
+	// Check everything before changing anything, since a wstat
+	// must be done completely or not at all.
+	if d.Name != "" && d.Name != n.Name {
+		if n == s.fs.root || strings.Contains(d.Name, "/") || d.Name == "." || d.Name == ".." {
+			return protocol.Errorf(protocol.ErrInvalid, "name %q", d.Name)
+		}
+		if c, _ := n.parent.child(d.Name); c != nil {
+			return protocol.Errorf(protocol.ErrExist, "%v", d.Name)
+		}
+		if err := s.allowed(n.parent, 2); err != nil {
+			return err
+		}
+	}
+	if d.Mode != ^uint32(0) && (d.Mode^n.Mode)&protocol.DMDIR != 0 {
+		return protocol.Errorf(protocol.ErrNotPermitted, "%v: directory to file, or back", n.Name)
+	}
+	if d.Length != ^uint64(0) && d.Length != n.Length {
+		if n.isDir() {
+			return protocol.Errorf(protocol.ErrIsDir, "%v", n.Name)
+		}
+		if err := s.allowed(n, 2); err != nil {
+			return err
+		}
+	}
+	if (d.Mode != ^uint32(0) || d.Group != "") && s.uname != n.User {
+		return protocol.Errorf(protocol.ErrPermission, "%v", n.Name)
+	}
+	if (d.Atime != protocol.TimeNoChange || d.Mtime != protocol.TimeNoChange) && s.uname != n.User {
+		if err := s.allowed(n, 2); err != nil {
+			return err
+		}
+	}
+
+	if d.Name != "" {
+		n.Name = d.Name
+	}
+	if d.Length != ^uint64(0) && d.Length != n.Length {
+		if d.Length < uint64(len(n.data)) {
+			n.data = n.data[:d.Length]
+		} else {
+			n.data = append(n.data, make([]byte, int(d.Length)-len(n.data))...)
+		}
+		s.fs.modified(n, s.uname)
+	}
+	if d.Mode != ^uint32(0) {
+		n.Mode = d.Mode
+	}
+	s.fs.setTime(&n.Atime, d.Atime)
+	s.fs.setTime(&n.Mtime, d.Mtime)
+	if d.Group != "" {
+		n.Group = d.Group
+	}
+	return nil
+}
+
+// setTime sets t to the time v from a Twstat.
+func (fs *FS) setTime(t *uint32, v uint32) {
+	switch v {
+	case protocol.TimeNoChange:
+	case protocol.TimeNow:
+		*t = uint32(fs.clock.Now().Unix())
+	default:
+		*t = v
+	}
+}
+
+func (s *fileServer) Rremove(f protocol.FID) error {
+	s.fs.mu.Lock()
+	defer s.fs.mu.Unlock()
+	// The fid is clunked even if the remove fails.
+	i, err := s.clunk(f)
+	if err != nil {
+		return err
+	}
+	if err := s.allowed(i.n.parent, 2); err != nil {
+		return err
+	}
+	return s.remove(i.n)
+}
+
+func (s *fileServer) remove(n *node) error {
+	if n == s.fs.root {
+		return protocol.Errorf(protocol.ErrNotPermitted, "remove of the root")
+	}
+	if len(n.children) != 0 {
+		return protocol.Errorf(protocol.ErrNotEmpty, "%v", n.Name)
+	}
+	p := n.parent
+	if _, x := p.child(n.Name); x >= 0 {
+		p.children = append(p.children[:x], p.children[x+1:]...)
+		s.fs.modified(p, s.uname)
+	}
+	return nil
+}
+
+func (s *fileServer) Rread(f protocol.FID, o protocol.Offset, c protocol.Count) ([]byte, error) {
+	s.fs.mu.Lock()
+	defer s.fs.mu.Unlock()
+	i, err := s.getFid(f)
+	if err != nil {
+		return nil, err
+	}
+	if !i.open || i.mode&3 == protocol.OWRITE {
+		return nil, protocol.Errorf(protocol.ErrNotOpen, "fid %d for reading", f)
+	}
+	n := i.n
+	if now := s.fs.clock.Now(); s.fs.atime(time.Unix(int64(n.Atime), 0), time.Unix(int64(n.Mtime), 0), now) {
+		n.Atime = uint32(now.Unix())
+	}
+	if !n.isDir() {
+		if o >= protocol.Offset(len(n.data)) {
+			return nil, nil
+		}
+		d := n.data[o:]
+		if protocol.Count(len(d)) > c {
+			d = d[:c]
+		}
+		return append([]byte{}, d...), nil
+	}
+
+	return i.dirs.Read(o, c, nil, func(k int) (protocol.Dir, error) {
+		if k >= len(n.children) {
+			return protocol.Dir{}, io.EOF
+		}
+		return n.children[k].Dir, nil
+	})
+}
+
+func (s *fileServer) Rwrite(f protocol.FID, o protocol.Offset, b []byte) (protocol.Count, error) {
+	s.fs.mu.Lock()
+	defer s.fs.mu.Unlock()
+	i, err := s.getFid(f)
+	if err != nil {
+		return 0, err
+	}
+	if !i.open || i.mode&3 == protocol.OREAD || i.mode&3 == protocol.OEXEC {
+		return 0, protocol.Errorf(protocol.ErrNotOpen, "fid %d for writing", f)
+	}
+	n := i.n
+	if n.Mode&protocol.DMAPPEND != 0 {
+		o = protocol.Offset(len(n.data))
+	}
+	if e := int(o) + len(b); e > len(n.data) {
+		n.data = append(n.data, make([]byte, e-len(n.data))...)
+	}
+	copy(n.data[o:], b)
+	s.fs.modified(n, s.uname)
+	return protocol.Count(len(b)), nil
+}
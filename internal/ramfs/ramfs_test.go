@@ -0,0 +1,375 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ramfs
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"harvey-os.org/internal/ninetest"
+	"harvey-os.org/pkg/ninep"
+	"harvey-os.org/pkg/ninep/protocol"
+)
+
+// newTestClient returns a client of fs, attached as uname with fid 0.
+func newTestClient(t *testing.T, fs *FS, uname string) *protocol.Client {
+	n, err := NewServer(fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := ninetest.Pipe(t, n)
+	ninetest.Attach(t, c, uname)
+	return c
+}
+
+func stat(t *testing.T, c *protocol.Client, names ...string) protocol.Dir {
+	f := c.GetFID()
+	if _, err := c.CallTwalk(0, f, names); err != nil {
+		t.Fatalf("CallTwalk(%v): want nil, got %v", names, err)
+	}
+	defer c.CallTclunk(f)
+	st, err := c.CallTstat(f)
+	if err != nil {
+		t.Fatalf("CallTstat(%v): want nil, got %v", names, err)
+	}
+	d, err := protocol.Unmarshaldir(bytes.NewBuffer(st))
+	if err != nil {
+		t.Fatalf("Unmarshaldir: want nil, got %v", err)
+	}
+	return d
+}
+
+// noChange returns a Dir which changes nothing in a Twstat.
+func noChange() protocol.Dir {
+	d := protocol.Dir{Type: ^uint16(0), Dev: ^uint32(0), Mode: ^uint32(0), Atime: ^uint32(0), Mtime: ^uint32(0), Length: ^uint64(0)}
+	d.QID = protocol.QID{Type: ^uint8(0), Version: ^uint32(0), Path: ^uint64(0)}
+	return d
+}
+
+func wstat(t *testing.T, c *protocol.Client, d protocol.Dir, names ...string) {
+	f := c.GetFID()
+	if _, err := c.CallTwalk(0, f, names); err != nil {
+		t.Fatalf("CallTwalk(%v): want nil, got %v", names, err)
+	}
+	defer c.CallTclunk(f)
+	var b bytes.Buffer
+	protocol.Marshaldir(&b, d)
+	if err := c.CallTwstat(f, b.Bytes()); err != nil {
+		t.Fatalf("CallTwstat(%v): want nil, got %v", names, err)
+	}
+}
+
+func TestRamfs(t *testing.T) {
+	fs, err := New()
+	if err != nil {
+		t.Fatalf("New: want nil, got %v", err)
+	}
+	c := newTestClient(t, fs, "glenda")
+
+	d, err := c.Create(0, []string{"d"}, protocol.DMDIR|0775, protocol.OREAD)
+	if err != nil {
+		t.Fatalf("Create(d): want nil, got %v", err)
+	}
+	d.Close()
+	for _, n := range [][]string{{"a"}, {"d", "b"}} {
+		f, err := c.Create(0, n, 0664, protocol.OWRITE)
+		if err != nil {
+			t.Fatalf("Create(%v): want nil, got %v", n, err)
+		}
+		if _, err := f.Write([]byte("hello")); err != nil {
+			t.Fatalf("Write(%v): want nil, got %v", n, err)
+		}
+		f.Close()
+	}
+	if _, err := c.Create(0, []string{"a"}, 0664, protocol.OWRITE); err == nil {
+		t.Errorf("Create(a) again: want err, got nil")
+	}
+
+	if err := fstest.TestFS(c.FS(0), "a", "d/b"); err != nil {
+		t.Errorf("TestFS: %v", err)
+	}
+
+	f, err := c.Open(0, []string{"a"}, protocol.ORDWR|protocol.OTRUNC)
+	if err != nil {
+		t.Fatalf("Open(a, ORDWR|OTRUNC): want nil, got %v", err)
+	}
+	if _, err := f.WriteAt([]byte("bye"), 2); err != nil {
+		t.Fatalf("WriteAt(a): want nil, got %v", err)
+	}
+	b := make([]byte, 8)
+	if n, err := f.ReadAt(b, 0); n != 5 || err != io.EOF || string(b[:n]) != "\x00\x00bye" {
+		t.Errorf("ReadAt(a): got %d, %q, %v, want 5, \"\\x00\\x00bye\", EOF", n, b[:n], err)
+	}
+	f.Close()
+
+	// Removing a directory needs it to be empty.
+	f, err = c.Open(0, []string{"d"}, protocol.OREAD)
+	if err != nil {
+		t.Fatalf("Open(d): want nil, got %v", err)
+	}
+	if err := c.CallTremove(f.FID()); err == nil {
+		t.Errorf("Remove(d): want err, got nil")
+	}
+	f, err = c.Open(0, []string{"d", "b"}, protocol.OREAD|protocol.ORCLOSE)
+	if err != nil {
+		t.Fatalf("Open(d/b, ORCLOSE): want nil, got %v", err)
+	}
+	f.Close()
+	if _, err := c.Open(0, []string{"d", "b"}, protocol.OREAD); err == nil {
+		t.Errorf("Open(d/b) after ORCLOSE: want err, got nil")
+	}
+
+	// Others can't write glenda's files.
+	c2 := newTestClient(t, fs, "bob")
+	if _, err := c2.Open(0, []string{"a"}, protocol.OWRITE); err == nil {
+		t.Errorf("Open(a, OWRITE) as bob: want err, got nil")
+	}
+	if _, err := c2.Open(0, []string{"a"}, protocol.OREAD); err != nil {
+		t.Errorf("Open(a, OREAD) as bob: want nil, got %v", err)
+	}
+}
+
+func TestEOF(t *testing.T) {
+	fs, err := New()
+	if err != nil {
+		t.Fatalf("New: want nil, got %v", err)
+	}
+	c := newTestClient(t, fs, "glenda")
+	d, err := c.Create(0, []string{"d"}, protocol.DMDIR|0775, protocol.OREAD)
+	if err != nil {
+		t.Fatalf("Create(d): want nil, got %v", err)
+	}
+	d.Close()
+	for _, n := range [][]string{{"a"}, {"empty"}, {"d", "b"}} {
+		f, err := c.Create(0, n, 0664, protocol.OWRITE)
+		if err != nil {
+			t.Fatalf("Create(%v): want nil, got %v", n, err)
+		}
+		if n[0] != "empty" {
+			if _, err := f.Write([]byte("hello")); err != nil {
+				t.Fatalf("Write(%v): want nil, got %v", n, err)
+			}
+		}
+		f.Close()
+	}
+	if err := fstest.TestFS(c.FS(0), "a", "empty", "d/b"); err != nil {
+		t.Errorf("TestFS: %v", err)
+	}
+
+	f, err := c.Open(0, []string{"empty"}, protocol.OREAD)
+	if err != nil {
+		t.Fatalf("Open(empty): want nil, got %v", err)
+	}
+	if n, err := f.Read(make([]byte, 8)); n != 0 || err != io.EOF {
+		t.Errorf("Read of empty file: want 0, EOF, got %d, %v", n, err)
+	}
+	f.Close()
+
+	// Only a read of nothing is the end: one which is short is not.
+	f, err = c.Open(0, []string{"a"}, protocol.OREAD)
+	if err != nil {
+		t.Fatalf("Open(a): want nil, got %v", err)
+	}
+	if b, err := c.CallTread(f.FID(), 3, 8); string(b) != "lo" || err != nil {
+		t.Errorf("CallTread(a, 3, 8): want \"lo\", nil, got %q, %v", b, err)
+	}
+	if b, err := c.CallTread(f.FID(), 5, 8); len(b) != 0 || err != nil {
+		t.Errorf("CallTread(a, 5, 8): want nothing, nil, got %q, %v", b, err)
+	}
+	f.Close()
+
+	f, err = c.Open(0, []string{"d"}, protocol.OREAD)
+	if err != nil {
+		t.Fatalf("Open(d): want nil, got %v", err)
+	}
+	// A read too small for an entry is an error, not the end.
+	if _, err := c.CallTread(f.FID(), 0, 8); err == nil || err == io.EOF {
+		t.Errorf("CallTread(d, 0, 8): want an error, got %v", err)
+	}
+	if d, err := f.Dirread(); len(d) != 1 || d[0].Name != "b" || err != nil {
+		t.Errorf("Dirread(d): want [b], nil, got %v, %v", d, err)
+	}
+	for i := 0; i < 2; i++ {
+		if d, err := f.Dirread(); len(d) != 0 || err != io.EOF {
+			t.Errorf("Dirread(d) at end: want [], EOF, got %v, %v", d, err)
+		}
+	}
+	f.Close()
+}
+
+func TestMuid(t *testing.T) {
+	fs, err := New()
+	if err != nil {
+		t.Fatalf("New: want nil, got %v", err)
+	}
+	glenda := newTestClient(t, fs, "glenda")
+	f, err := glenda.Create(0, []string{"a"}, 0666, protocol.OWRITE)
+	if err != nil {
+		t.Fatalf("Create(a): want nil, got %v", err)
+	}
+	f.Close()
+	if d := stat(t, glenda, "a"); d.User != "glenda" || d.ModUser != "glenda" {
+		t.Errorf("Stat(a) after create: got user %q muid %q, want glenda glenda", d.User, d.ModUser)
+	}
+
+	bob := newTestClient(t, fs, "bob")
+	f, err = bob.Open(0, []string{"a"}, protocol.OWRITE)
+	if err != nil {
+		t.Fatalf("Open(a) as bob: want nil, got %v", err)
+	}
+	if _, err := f.Write([]byte("bob was here")); err != nil {
+		t.Fatalf("Write(a) as bob: want nil, got %v", err)
+	}
+	f.Close()
+	if d := stat(t, glenda, "a"); d.User != "glenda" || d.ModUser != "bob" {
+		t.Errorf("Stat(a) after bob's write: got user %q muid %q, want glenda bob", d.User, d.ModUser)
+	}
+	if d := stat(t, glenda); d.ModUser != "glenda" {
+		t.Errorf("Stat(/): got muid %q, want glenda, who created a", d.ModUser)
+	}
+
+	// Truncating is a modification too.
+	wd := noChange()
+	wd.Length = 0
+	wstat(t, glenda, wd, "a")
+	if d := stat(t, glenda, "a"); d.Length != 0 || d.ModUser != "glenda" {
+		t.Errorf("Stat(a) after truncate: got length %d muid %q, want 0 glenda", d.Length, d.ModUser)
+	}
+}
+
+func TestTimes(t *testing.T) {
+	fs, err := New(Atime(ninep.NoAtime))
+	if err != nil {
+		t.Fatalf("New: want nil, got %v", err)
+	}
+	c := newTestClient(t, fs, "glenda")
+	f, err := c.Create(0, []string{"src"}, 0644, protocol.OWRITE)
+	if err != nil {
+		t.Fatalf("Create(src): want nil, got %v", err)
+	}
+	f.Write([]byte("old news"))
+	f.Close()
+	wd := noChange()
+	wd.Atime, wd.Mtime = 1262304000, 1230768000
+	wstat(t, c, wd, "src")
+
+	// Copy src to dst, as cp -p would, without disturbing src.
+	f, err = c.Open(0, []string{"src"}, protocol.OREAD)
+	if err != nil {
+		t.Fatalf("Open(src): want nil, got %v", err)
+	}
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatalf("ReadAll(src): want nil, got %v", err)
+	}
+	f.Close()
+	g, err := c.Create(0, []string{"dst"}, 0644, protocol.OWRITE)
+	if err != nil {
+		t.Fatalf("Create(dst): want nil, got %v", err)
+	}
+	g.Write(b)
+	wstat(t, c, wd, "dst")
+	g.Close()
+	for _, n := range []string{"src", "dst"} {
+		if d := stat(t, c, n); d.Atime != wd.Atime || d.Mtime != wd.Mtime {
+			t.Errorf("Stat(%v): got atime %d mtime %d, want %d %d", n, d.Atime, d.Mtime, wd.Atime, wd.Mtime)
+		}
+	}
+
+	// Touch dst with the server's clock.
+	wd = noChange()
+	wd.Mtime = protocol.TimeNow
+	wstat(t, c, wd, "dst")
+	if d := stat(t, c, "dst"); time.Since(time.Unix(int64(d.Mtime), 0)) > time.Minute || d.Atime != 1262304000 {
+		t.Errorf("Stat(dst) after touch: got atime %d mtime %d, want 1262304000 and now", d.Atime, d.Mtime)
+	}
+
+	// Only those who may write a file may change its times.
+	bob := newTestClient(t, fs, "bob")
+	fid := bob.GetFID()
+	if _, err := bob.CallTwalk(0, fid, []string{"dst"}); err != nil {
+		t.Fatalf("CallTwalk(dst): want nil, got %v", err)
+	}
+	var w bytes.Buffer
+	protocol.Marshaldir(&w, wd)
+	if err := bob.CallTwstat(fid, w.Bytes()); err == nil {
+		t.Errorf("CallTwstat(dst) as bob: want err, got nil")
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	fs, err := New()
+	if err != nil {
+		t.Fatalf("New: want nil, got %v", err)
+	}
+	c := newTestClient(t, fs, "glenda")
+	f, err := c.Create(0, []string{"a"}, 0666, protocol.OWRITE)
+	if err != nil {
+		t.Fatalf("Create(a): want nil, got %v", err)
+	}
+	f.Write([]byte("before"))
+	f.Close()
+	if err := fs.Snapshot("then"); err != nil {
+		t.Fatalf("Snapshot(then): want nil, got %v", err)
+	}
+	if err := fs.Snapshot("then"); err == nil {
+		t.Errorf("Snapshot(then) again: want err, got nil")
+	}
+	f, err = c.Open(0, []string{"a"}, protocol.OWRITE|protocol.OTRUNC)
+	if err != nil {
+		t.Fatalf("Open(a): want nil, got %v", err)
+	}
+	f.Write([]byte("after"))
+	f.Close()
+
+	if _, err := c.CallTattach(1, protocol.NOFID, "glenda", "@now"); err == nil {
+		t.Errorf("attach to @now: want err, got nil")
+	}
+	if _, err := c.CallTattach(1, protocol.NOFID, "glenda", "@then"); err != nil {
+		t.Fatalf("attach to @then: want nil, got %v", err)
+	}
+	f, err = c.Open(1, []string{"a"}, protocol.OREAD)
+	if err != nil {
+		t.Fatalf("Open(a) in @then: want nil, got %v", err)
+	}
+	if b, err := ioutil.ReadAll(f); err != nil || string(b) != "before" {
+		t.Errorf("ReadAll(a) in @then: got %q, %v, want \"before\", nil", b, err)
+	}
+	f.Close()
+
+	// Nothing in a snapshot changes, and removing still clunks.
+	if _, err := c.Open(1, []string{"a"}, protocol.OWRITE); err == nil {
+		t.Errorf("Open(a, OWRITE) in @then: want err, got nil")
+	}
+	if _, err := c.Create(1, []string{"b"}, 0666, protocol.OWRITE); err == nil {
+		t.Errorf("Create(b) in @then: want err, got nil")
+	}
+	if _, err := c.CallTwalk(1, 2, []string{"a"}); err != nil {
+		t.Fatalf("CallTwalk(a) in @then: want nil, got %v", err)
+	}
+	var w bytes.Buffer
+	wd := noChange()
+	wd.Length = 0
+	protocol.Marshaldir(&w, wd)
+	if err := c.CallTwstat(2, w.Bytes()); err == nil {
+		t.Errorf("CallTwstat(a) in @then: want err, got nil")
+	}
+	if err := c.CallTremove(2); err == nil {
+		t.Errorf("CallTremove(a) in @then: want err, got nil")
+	}
+	if _, err := c.CallTstat(2); err == nil {
+		t.Errorf("CallTstat after CallTremove: want err, got nil, since remove clunks")
+	}
+
+	// The live tree is still writable over the same connection.
+	if d := stat(t, c, "a"); d.Length != 5 {
+		t.Errorf("Stat(a): got length %d, want 5", d.Length)
+	}
+	wstat(t, c, wd, "a")
+}
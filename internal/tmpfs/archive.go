@@ -70,6 +70,11 @@ func (f *File) P9Dir(uname string) *protocol.Dir {
 	d.Name = f.Name()
 	d.User = uname
 	d.Group = uname
+	// Whoever put the file in the archive last modified it.
+	d.ModUser = f.hdr.Uname
+	if d.ModUser == "" {
+		d.ModUser = uname
+	}
 	return d
 }
 
@@ -141,6 +146,7 @@ func (d *Directory) P9Dir(uname string) *protocol.Dir {
 	pd.Name = d.Name()
 	pd.User = uname
 	pd.Group = uname
+	pd.ModUser = uname
 	return pd
 }
 
@@ -0,0 +1,109 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ufs
+
+import (
+	"sync"
+	"time"
+
+	"harvey-os.org/pkg/ninep/protocol"
+)
+
+// appendPoll is how often a read waiting at the end of a file looks
+// again, for what was written other than through the server.
+const appendPoll = time.Second
+
+// errAppendOnly is the error for changes an append-only server refuses.
+var errAppendOnly = protocol.Errorf(protocol.ErrNotPermitted, "append-only file system")
+
+// AppendOnly makes the server's files append-only, for shipping logs.
+// Writes go to the end of the file, whatever their offset, and files
+// can't be truncated, by open, create or Twstat, or copied into. A read
+// at the end of a file waits, as tail -f does, until there is more, which
+// a write through the server brings at once, and one made any other way
+// within a second. A client which gives up on such a read flushes it.
+func AppendOnly() Opt {
+	return func(c *config) error {
+		c.appends = &appends{more: make(map[uint64]*more)}
+		return nil
+	}
+}
+
+// appends wakes the reads waiting at the end of files, by QID path.
+type appends struct {
+	// mu guards below
+	mu   sync.Mutex
+	more map[uint64]*more
+}
+
+// more is closed when a file may have more in it, or it is time to look.
+type more struct {
+	c     chan struct{}
+	timer protocol.Timer
+}
+
+// wait returns a channel which is closed when the file with QID path
+// may have more in it.
+func (a *appends) wait(path uint64, clock protocol.Clock) <-chan struct{} {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	m, ok := a.more[path]
+	if !ok {
+		m = &more{c: make(chan struct{})}
+		m.timer = clock.AfterFunc(appendPoll, func() { a.wake(path, m) })
+		a.more[path] = m
+	}
+	return m.c
+}
+
+// wake wakes the reads waiting on m, if it is still that of the file
+// with QID path.
+func (a *appends) wake(path uint64, m *more) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.more[path] == m {
+		close(m.c)
+		delete(a.more, path)
+	}
+}
+
+// appended wakes the reads waiting for the file with QID path.
+func (a *appends) appended(path uint64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if m, ok := a.more[path]; ok {
+		m.timer.Stop()
+		close(m.c)
+		delete(a.more, path)
+	}
+}
+
+// Wait implements protocol.Waiter: with AppendOnly, a read of an open
+// file at or past its end waits for more.
+func (e *FileServer) Wait(fid protocol.FID, o protocol.Offset) <-chan struct{} {
+	if e.appends == nil {
+		return nil
+	}
+	f, err := e.getFile(fid)
+	if err != nil || f.file == nil || f.QID.Type&protocol.QTDIR != 0 {
+		return nil
+	}
+	if !f.atEnd(o) {
+		return nil
+	}
+	// The length is looked at again once there is a channel, so that
+	// a write in between still wakes the read.
+	c := e.appends.wait(f.QID.Path, e.clock)
+	if !f.atEnd(o) {
+		return nil
+	}
+	return c
+}
+
+// atEnd reports whether o is at or past the end of f, a regular file.
+func (f *file) atEnd(o protocol.Offset) bool {
+	st, err := f.file.Stat()
+	return err == nil && st.Mode().IsRegular() && int64(o) >= st.Size()
+}
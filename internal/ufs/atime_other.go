@@ -0,0 +1,18 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !linux
+
+package ufs
+
+import (
+	"os"
+	"time"
+)
+
+// fileAtime returns the access time of a file. Where we don't know
+// how to find it, the modification time will do.
+func fileAtime(d os.FileInfo) time.Time {
+	return d.ModTime()
+}
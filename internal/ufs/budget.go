@@ -0,0 +1,258 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ufs
+
+import (
+	"fmt"
+	"log"
+	"sync"
+
+	"harvey-os.org/pkg/ninep"
+	"harvey-os.org/pkg/ninep/protocol"
+)
+
+// Budgets limits how long operations on the host's files may take, so
+// that a root on storage which hangs, such as NFS, fails them rather
+// than wedging the connections using it. A fid whose operation runs late
+// can't be used until it is done. Attaches, clunks, and the operations
+// of extensions, such as Tcopy, have no budget.
+func Budgets(b *ninep.Budgets) Opt {
+	return func(c *config) error {
+		c.budgets = b
+		return nil
+	}
+}
+
+// budgeted is a FileServer whose operations on host files are run
+// within the config's Budgets. The rest are the FileServer's own.
+type budgeted struct {
+	*FileServer
+
+	// mu guards below
+	mu sync.Mutex
+	// late are the fids of operations running late, and clunked
+	// those of them the client has clunked since.
+	late    map[protocol.FID]bool
+	clunked map[protocol.FID]bool
+}
+
+func newBudgeted(f *FileServer) *budgeted {
+	return &budgeted{FileServer: f, late: make(map[protocol.FID]bool), clunked: make(map[protocol.FID]bool)}
+}
+
+// busy returns an error if any of fids has an operation running late.
+func (e *budgeted) busy(fids ...protocol.FID) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, fid := range fids {
+		if e.late[fid] {
+			return fmt.Errorf("fid %d: %w: an operation on it is still running", fid, ninep.ErrOverBudget)
+		}
+	}
+	return nil
+}
+
+// run runs f, the work for a message of type t on fids, within its
+// budget. If f runs late, fids can't be used until it is done; then
+// done, if not nil, is called with its error, and the fids the client
+// clunked meanwhile are clunked.
+func (e *budgeted) run(t protocol.MType, f func() error, done func(error), fids ...protocol.FID) error {
+	if err := e.busy(fids...); err != nil {
+		return err
+	}
+	return e.budgets.RunLate(t, f, func() {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		for _, fid := range fids {
+			e.late[fid] = true
+		}
+	}, func(err error) {
+		if done != nil {
+			done(err)
+		}
+		e.mu.Lock()
+		var clunk []protocol.FID
+		for _, fid := range fids {
+			delete(e.late, fid)
+			if e.clunked[fid] {
+				delete(e.clunked, fid)
+				clunk = append(clunk, fid)
+			}
+		}
+		e.mu.Unlock()
+		for _, fid := range clunk {
+			if err := e.FileServer.Rclunk(fid); err != nil {
+				log.Printf("late clunk of fid %d: %v", fid, err)
+			}
+		}
+	})
+}
+
+// Rclunk clunks fid, or, if it has an operation running late, has it
+// clunked once that is done. Clunks never fail, as far as the client
+// knows.
+func (e *budgeted) Rclunk(fid protocol.FID) error {
+	e.mu.Lock()
+	if e.late[fid] {
+		e.clunked[fid] = true
+		e.mu.Unlock()
+		return nil
+	}
+	e.mu.Unlock()
+	return e.FileServer.Rclunk(fid)
+}
+
+// Hangup clunks the fids the client left behind, as FileServer's does,
+// but leaves those with operations running late until they are done.
+func (e *budgeted) Hangup() {
+	e.FileServer.mu.Lock()
+	var fids []protocol.FID
+	for fid := range e.files {
+		fids = append(fids, fid)
+	}
+	e.FileServer.mu.Unlock()
+	for _, fid := range fids {
+		if err := e.Rclunk(fid); err != nil {
+			log.Printf("Hangup: clunk of fid %d: %v", fid, err)
+		}
+	}
+}
+
+func (e *budgeted) Rwalk(fid protocol.FID, newfid protocol.FID, paths []string) ([]protocol.QID, error) {
+	var q []protocol.QID
+	err := e.run(protocol.Twalk, func() error {
+		var err error
+		q, err = e.FileServer.Rwalk(fid, newfid, paths)
+		return err
+	}, func(err error) {
+		// The client was told the walk failed, so newfid must not
+		// be left made.
+		if err == nil && newfid != fid && len(q) == len(paths) {
+			e.FileServer.Rclunk(newfid)
+		}
+	}, fid, newfid)
+	if err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+func (e *budgeted) Ropen(fid protocol.FID, mode protocol.Mode) (protocol.QID, protocol.MaxSize, error) {
+	var q protocol.QID
+	var iounit protocol.MaxSize
+	err := e.run(protocol.Topen, func() error {
+		var err error
+		q, iounit, err = e.FileServer.Ropen(fid, mode)
+		return err
+	}, nil, fid)
+	if err != nil {
+		return protocol.QID{}, 0, err
+	}
+	return q, iounit, nil
+}
+
+func (e *budgeted) Rcreate(fid protocol.FID, name string, perm protocol.Perm, mode protocol.Mode) (protocol.QID, protocol.MaxSize, error) {
+	var q protocol.QID
+	var iounit protocol.MaxSize
+	err := e.run(protocol.Tcreate, func() error {
+		var err error
+		q, iounit, err = e.FileServer.Rcreate(fid, name, perm, mode)
+		return err
+	}, nil, fid)
+	if err != nil {
+		return protocol.QID{}, 0, err
+	}
+	return q, iounit, nil
+}
+
+func (e *budgeted) Rstat(fid protocol.FID) ([]byte, error) {
+	var b []byte
+	err := e.run(protocol.Tstat, func() error {
+		var err error
+		b, err = e.FileServer.Rstat(fid)
+		return err
+	}, nil, fid)
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (e *budgeted) Rwstat(fid protocol.FID, b []byte) error {
+	// b is the server's, and is reused once Rwstat returns.
+	if e.budgets.Budget(protocol.Twstat) > 0 {
+		b = append([]byte(nil), b...)
+	}
+	return e.run(protocol.Twstat, func() error {
+		return e.FileServer.Rwstat(fid, b)
+	}, nil, fid)
+}
+
+// Rremove removes the file of fid. A remove clunks its fid, even when
+// it fails, so one running late clunks it once it is done.
+func (e *budgeted) Rremove(fid protocol.FID) error {
+	if err := e.busy(fid); err != nil {
+		e.Rclunk(fid)
+		return err
+	}
+	return e.run(protocol.Tremove, func() error {
+		return e.FileServer.Rremove(fid)
+	}, nil, fid)
+}
+
+func (e *budgeted) Rread(fid protocol.FID, o protocol.Offset, c protocol.Count) ([]byte, error) {
+	var b []byte
+	err := e.run(protocol.Tread, func() error {
+		var err error
+		b, err = e.FileServer.Rread(fid, o, c)
+		return err
+	}, nil, fid)
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (e *budgeted) Rwrite(fid protocol.FID, o protocol.Offset, b []byte) (protocol.Count, error) {
+	// b is the server's, and is reused once Rwrite returns.
+	if e.budgets.Budget(protocol.Twrite) > 0 {
+		b = append([]byte(nil), b...)
+	}
+	var n protocol.Count
+	err := e.run(protocol.Twrite, func() error {
+		var err error
+		n, err = e.FileServer.Rwrite(fid, o, b)
+		return err
+	}, nil, fid)
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// Rreadlink and Rcopy have no budget, but can't use fids which are busy.
+
+func (e *budgeted) Rreadlink(fid protocol.FID) (string, error) {
+	if err := e.busy(fid); err != nil {
+		return "", err
+	}
+	return e.FileServer.Rreadlink(fid)
+}
+
+func (e *budgeted) Rcopy(fid protocol.FID, o protocol.Offset, dfid protocol.FID, do protocol.Offset, count uint64) (uint64, error) {
+	if err := e.busy(fid, dfid); err != nil {
+		return 0, err
+	}
+	return e.FileServer.Rcopy(fid, o, dfid, do, count)
+}
+
+// Wait doesn't hold reads of fids which are busy, so that they fail at
+// once.
+func (e *budgeted) Wait(fid protocol.FID, o protocol.Offset) <-chan struct{} {
+	if e.busy(fid) != nil {
+		return nil
+	}
+	return e.FileServer.Wait(fid, o)
+}
@@ -0,0 +1,69 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !windows
+
+package ufs
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"harvey-os.org/pkg/ninep"
+	"harvey-os.org/pkg/ninep/protocol"
+)
+
+func TestBudgets(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "budgets")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+	if err := ioutil.WriteFile(path.Join(tmpdir, "f"), []byte("hi"), 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+	// Opening a fifo hangs until someone opens the other end, as an
+	// open on a hung mount does.
+	fifo := path.Join(tmpdir, "fifo")
+	if err := syscall.Mkfifo(fifo, 0644); err != nil {
+		t.Skipf("Mkfifo: %v", err)
+	}
+	b := &ninep.Budgets{Default: 100 * time.Millisecond}
+	c := newTestClient(t, tmpdir, Budgets(b))
+
+	if _, err := c.Open(0, []string{"fifo"}, protocol.OREAD); err == nil || !strings.Contains(err.Error(), ninep.ErrOverBudget.Error()) {
+		t.Fatalf("Open(fifo): want over budget, got %v", err)
+	}
+	if st := b.Stats(); st.Overruns["Topen"] != 1 || st.Late != 1 {
+		t.Errorf("Stats: got %+v, want 1 late Topen", st)
+	}
+
+	// The connection carries on.
+	f, err := c.Open(0, []string{"f"}, protocol.OREAD)
+	if err != nil {
+		t.Fatalf("Open(f): want nil, got %v", err)
+	}
+	if d, err := ioutil.ReadAll(f); err != nil || string(d) != "hi" {
+		t.Errorf("ReadAll(f): got %q, %v, want hi", d, err)
+	}
+	f.Close()
+
+	// Once the open is done, it is no longer late.
+	w, err := os.OpenFile(fifo, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer w.Close()
+	for i := 0; b.Stats().Late != 0; i++ {
+		if i == 100 {
+			t.Fatalf("late Topen did not finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
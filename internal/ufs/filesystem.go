@@ -12,8 +12,8 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"strings"
 	"sync"
-	"time"
 
 	"harvey-os.org/pkg/ninep"
 	"harvey-os.org/pkg/ninep/protocol"
@@ -23,10 +23,18 @@ type file struct {
 	protocol.QID
 	fullName string
 	file     *os.File
-	// We can't know how big a serialized dentry is until we serialize it.
-	// At that point it might be too big. We save it here if that happens,
-	// and on the next directory read we start with that.
-	oflow []byte
+	// dirs packs directory entries into reads.
+	dirs protocol.DirPacker
+	// written is set once a write to the open file has been recorded.
+	written bool
+	// read is how the open file was before it was first read, if it
+	// has been and there is an access time policy to apply on clunk.
+	read os.FileInfo
+	// rclose is set if the file is to be removed when clunked.
+	rclose bool
+	// openName is the name the file was opened by, which the
+	// janitor knows it by, even if it has since been renamed.
+	openName string
 }
 
 type FileServer struct {
@@ -35,28 +43,64 @@ type FileServer struct {
 	Versioned bool
 	IOunit    protocol.MaxSize
 
+	// uname is the user who attached.
+	uname string
+
+	// peer is who is at the other end of a Unix socket, if known.
+	peer *protocol.PeerCred
+
+	*config
+
 	// mu guards below
 	mu    sync.Mutex
 	files map[protocol.FID]*file
 }
 
-func stat(s string) (*protocol.Dir, protocol.QID, error) {
+// stat returns the FileInfo of f, from the open file if there is one,
+// since it may have been removed or renamed since it was opened.
+func (f *file) stat() (os.FileInfo, error) {
+	if f.file != nil {
+		return f.file.Stat()
+	}
+	return os.Stat(f.fullName)
+}
+
+// truncate sets the length of f, through the open file if there is one.
+// A file open only for reading can't be truncated that way, so then its
+// name is used, as long as it still names the same file.
+func (f *file) truncate(length int64) error {
+	if f.file == nil {
+		return os.Truncate(f.fullName, length)
+	}
+	err := f.file.Truncate(length)
+	if err == nil {
+		return nil
+	}
+	ofi, oerr := f.file.Stat()
+	fi, nerr := os.Stat(f.fullName)
+	if oerr != nil || nerr != nil || !os.SameFile(ofi, fi) {
+		return err
+	}
+	return os.Truncate(f.fullName, length)
+}
+
+func (e *FileServer) stat(s string) (*protocol.Dir, protocol.QID, error) {
 	var q protocol.QID
 	st, err := os.Lstat(s)
 	if err != nil {
-		return nil, q, fmt.Errorf("does not exist")
+		return nil, q, protocol.ErrNotExist
 	}
-	d, err := dirTo9p2000Dir(st)
+	d, err := e.dirTo9p2000Dir(st, s)
 	if err != nil {
 		return nil, q, nil
 	}
-	q = fileInfoToQID(st)
+	q = d.QID
 	return d, q, nil
 }
 
 func (e *FileServer) Rversion(msize protocol.MaxSize, version string) (protocol.MaxSize, string, error) {
 	if version != "9P2000" {
-		return 0, "", fmt.Errorf("%v not supported; only 9P2000", version)
+		return 0, "", protocol.Errorf(protocol.ErrNotSupported, "%v: only 9P2000", version)
 	}
 	e.Versioned = true
 	return msize, version, nil
@@ -67,7 +111,7 @@ func (e *FileServer) getFile(fid protocol.FID) (*file, error) {
 	defer e.mu.Unlock()
 	f, ok := e.files[fid]
 	if !ok {
-		return nil, fmt.Errorf("does not exist")
+		return nil, protocol.Errorf(protocol.ErrUnknownFID, "fid %d", fid)
 	}
 
 	return f, nil
@@ -75,7 +119,11 @@ func (e *FileServer) getFile(fid protocol.FID) (*file, error) {
 
 func (e *FileServer) Rattach(fid protocol.FID, afid protocol.FID, uname string, aname string) (protocol.QID, error) {
 	if afid != protocol.NOFID {
-		return protocol.QID{}, fmt.Errorf("We don't do auth attach")
+		return protocol.QID{}, protocol.Errorf(protocol.ErrNotSupported, "auth attach")
+	}
+	uname, err := e.peerUser(uname)
+	if err != nil {
+		return protocol.QID{}, err
 	}
 	// There should be no .. or other such junk in the Aname. Clean it up anyway.
 	aname = path.Join("/", aname)
@@ -84,13 +132,43 @@ func (e *FileServer) Rattach(fid protocol.FID, afid protocol.FID, uname string,
 	if err != nil {
 		return protocol.QID{}, err
 	}
+	e.changes.watch(aname)
 	r := &file{fullName: aname}
-	r.QID = fileInfoToQID(st)
+	r.QID = e.fileInfoToQID(st, aname)
 	e.files[fid] = r
 	e.root = r
+	e.uname = uname
 	return r.QID, nil
 }
 
+// SetPeer implements protocol.PeerServer.
+func (e *FileServer) SetPeer(p *protocol.PeerCred) {
+	e.peer = p
+}
+
+// peerUser returns the user a client attaching as uname attaches as,
+// which, with PeerAuth, must be the user its process runs as.
+func (e *FileServer) peerUser(uname string) (string, error) {
+	if !e.peerAuth || e.peer == nil {
+		return uname, nil
+	}
+	db := e.users
+	if db == nil {
+		db = ninep.OSUsers{}
+	}
+	name, err := db.User(e.peer.UID)
+	if err != nil {
+		return "", fmt.Errorf("peer uid %d: %v", e.peer.UID, err)
+	}
+	switch {
+	case uname == "":
+		return name, nil
+	case uname == name, e.peer.UID == 0:
+		return uname, nil
+	}
+	return "", fmt.Errorf("attach as %v: connected as %v", uname, name)
+}
+
 func (e *FileServer) Rflush(o protocol.Tag) error {
 	return nil
 }
@@ -100,14 +178,14 @@ func (e *FileServer) Rwalk(fid protocol.FID, newfid protocol.FID, paths []string
 	f, ok := e.files[fid]
 	e.mu.Unlock()
 	if !ok {
-		return nil, fmt.Errorf("does not exist")
+		return nil, protocol.Errorf(protocol.ErrUnknownFID, "fid %d", fid)
 	}
 	if len(paths) == 0 {
 		e.mu.Lock()
 		defer e.mu.Unlock()
 		_, ok := e.files[newfid]
 		if ok {
-			return nil, fmt.Errorf("FID in use: clone walk, fid %d newfid %d", fid, newfid)
+			return nil, protocol.Errorf(protocol.ErrFIDInUse, "clone walk, fid %d newfid %d", fid, newfid)
 		}
 		nf := *f
 		e.files[newfid] = &nf
@@ -118,8 +196,12 @@ func (e *FileServer) Rwalk(fid protocol.FID, newfid protocol.FID, paths []string
 
 	var i int
 	for i = range paths {
-		p = path.Join(p, paths[i])
-		st, err := os.Lstat(p)
+		var st os.FileInfo
+		var err error
+		p, err = e.within(path.Join(p, paths[i]))
+		if err == nil {
+			st, err = os.Lstat(p)
+		}
 		if err != nil {
 			// From the RFC: If the first element cannot be walked for any
 			// reason, Rerror is returned. Otherwise, the walk will return an
@@ -135,39 +217,81 @@ func (e *FileServer) Rwalk(fid protocol.FID, newfid protocol.FID, paths []string
 			// to sum up: if any walks have succeeded, you return the QIDS for
 			// one more than the last successful walk
 			if i == 0 {
-				return nil, fmt.Errorf("file does not exist")
+				return nil, protocol.ErrNotExist
 			}
 			// we only get here if i is > 0 and less than nwname,
 			// so the i should be safe.
 			return q[:i], nil
 		}
-		q[i] = fileInfoToQID(st)
+		if st.IsDir() {
+			e.changes.watch(p)
+		}
+		q[i] = e.fileInfoToQID(st, p)
 	}
 	e.mu.Lock()
 	defer e.mu.Unlock()
 	// this is quite unlikely, which is why we don't bother checking for it first.
 	if fid != newfid {
 		if _, ok := e.files[newfid]; ok {
-			return nil, fmt.Errorf("FID in use: walk to %v, fid %v, newfid %v", paths, fid, newfid)
+			return nil, protocol.Errorf(protocol.ErrFIDInUse, "walk to %v, fid %v, newfid %v", paths, fid, newfid)
 		}
 	}
 	e.files[newfid] = &file{fullName: p, QID: q[i]}
 	return q, nil
 }
 
+// within returns name, or, if walking .. has taken it out of the root,
+// the root: as in Plan 9, .. of the root is the root itself. That keeps
+// a root which is a single file from giving away its directory. A name
+// which can't be made relative to the root can't be walked to.
+func (e *FileServer) within(name string) (string, error) {
+	rel, err := filepath.Rel(e.rootPath, name)
+	if err != nil {
+		return "", err
+	}
+	if rel = filepath.ToSlash(rel); rel == ".." || strings.HasPrefix(rel, "../") {
+		return e.rootPath, nil
+	}
+	return name, nil
+}
+
 func (e *FileServer) Ropen(fid protocol.FID, mode protocol.Mode) (protocol.QID, protocol.MaxSize, error) {
 	e.mu.Lock()
 	f, ok := e.files[fid]
 	e.mu.Unlock()
 	if !ok {
-		return protocol.QID{}, 0, fmt.Errorf("does not exist")
+		return protocol.QID{}, 0, protocol.Errorf(protocol.ErrUnknownFID, "fid %d", fid)
 	}
 
+	if openPerm(mode)&2 != 0 || mode&protocol.ORCLOSE != 0 {
+		if err := e.writable(); err != nil {
+			return protocol.QID{}, 0, err
+		}
+	}
+	if err := e.allowed(f.fullName, openPerm(mode)); err != nil {
+		return protocol.QID{}, 0, err
+	}
+	if mode&protocol.ORCLOSE != 0 {
+		if err := e.allowed(path.Dir(f.fullName), 2); err != nil {
+			return protocol.QID{}, 0, err
+		}
+	}
+	flags := modeToUnixFlags(mode)
+	if e.appends != nil && openPerm(mode)&2 != 0 {
+		if mode&protocol.OTRUNC != 0 {
+			return protocol.QID{}, 0, errAppendOnly
+		}
+		flags |= os.O_APPEND
+	}
 	var err error
-	f.file, err = os.OpenFile(f.fullName, modeToUnixFlags(mode), 0)
+	f.file, err = os.OpenFile(f.fullName, flags, 0)
 	if err != nil {
 		return protocol.QID{}, 0, err
 	}
+	e.opened(f, mode)
+	if mode&protocol.OTRUNC != 0 {
+		e.modified(f.fullName)
+	}
 
 	return f.QID, e.IOunit, nil
 }
@@ -177,13 +301,35 @@ func (e *FileServer) Rcreate(fid protocol.FID, name string, perm protocol.Perm,
 		return protocol.QID{}, 0, err
 	}
 	if f.file != nil {
-		return protocol.QID{}, 0, fmt.Errorf("FID already open")
+		return protocol.QID{}, 0, protocol.Errorf(protocol.ErrInvalid, "fid %d already open", fid)
+	}
+	if f.Type&protocol.QTDIR == 0 {
+		return protocol.QID{}, 0, protocol.Errorf(protocol.ErrNotDir, "%v", name)
+	}
+	if name == "." || name == ".." || strings.Contains(name, "/") {
+		return protocol.QID{}, 0, fmt.Errorf("%v: bad name", name)
+	}
+	if err := e.writable(); err != nil {
+		return protocol.QID{}, 0, err
+	}
+	if err := e.allowed(f.fullName, 2); err != nil {
+		return protocol.QID{}, 0, err
 	}
 	n := path.Join(f.fullName, name)
+	p, err := e.permFor(f.fullName, perm)
+	if err != nil {
+		return protocol.QID{}, 0, err
+	}
 	if perm&protocol.Perm(protocol.DMDIR) != 0 {
-		p := os.FileMode(int(perm) & 0777)
-		err := os.Mkdir(n, p)
-		_, q, err := stat(n)
+		if err := os.Mkdir(n, p); err != nil {
+			return protocol.QID{}, 0, err
+		}
+		if err := e.chmodCreated(n, p); err != nil {
+			return protocol.QID{}, 0, err
+		}
+		e.modified(n)
+		e.add(e.uname, 0, 1)
+		_, q, err := e.stat(n)
 		if err != nil {
 			return protocol.QID{}, 0, err
 		}
@@ -193,23 +339,92 @@ func (e *FileServer) Rcreate(fid protocol.FID, name string, perm protocol.Perm,
 	}
 
 	m := modeToUnixFlags(mode) | os.O_CREATE | os.O_TRUNC
-	p := os.FileMode(perm) & 0777
+	if e.appends != nil {
+		// What is there already is not to be truncated.
+		m = m&^os.O_TRUNC | os.O_EXCL | os.O_APPEND
+	}
 	of, err := os.OpenFile(n, m, p)
 	if err != nil {
 		return protocol.QID{}, 0, err
 	}
-	_, q, err := stat(n)
+	if err := e.chmodCreated(n, p); err != nil {
+		of.Close()
+		return protocol.QID{}, 0, err
+	}
+	e.modified(n)
+	e.add(e.uname, 0, 1)
+	_, q, err := e.stat(n)
 	if err != nil {
 		return protocol.QID{}, 0, err
 	}
 	f.fullName = n
 	f.QID = q
 	f.file = of
+	e.opened(f, mode)
 	return q, 8000, err
 }
+
+// opened notes that f has been opened with mode, for ORCLOSE and the
+// janitor.
+func (e *FileServer) opened(f *file, mode protocol.Mode) {
+	f.rclose = mode&protocol.ORCLOSE != 0
+	f.openName = f.fullName
+	if e.janitor == nil {
+		return
+	}
+	e.janitor.opened(f.openName)
+	if f.rclose {
+		// Marked for the janitor, in case we crash before the
+		// clunk. If it can't be, it can't be helped.
+		setRclose(f.fullName)
+	}
+}
+
+// permFor returns the permissions for a file created in dir, which
+// the client asked to have perm.
+func (e *FileServer) permFor(dir string, perm protocol.Perm) (os.FileMode, error) {
+	p := os.FileMode(perm) & 0777
+	if e.createPerm == nil {
+		return p, nil
+	}
+	st, err := os.Stat(dir)
+	if err != nil {
+		return 0, err
+	}
+	return e.createPerm(p, st.Mode().Perm(), perm&protocol.Perm(protocol.DMDIR) != 0) & 0777, nil
+}
+
+// chmodCreated sets the permissions of a new file, if there is a policy,
+// since otherwise the server's umask would have the last word.
+func (e *FileServer) chmodCreated(name string, p os.FileMode) error {
+	if e.createPerm == nil {
+		return nil
+	}
+	return os.Chmod(name, p)
+}
+
 func (e *FileServer) Rclunk(fid protocol.FID) error {
-	_, err := e.clunk(fid)
-	return err
+	f, err := e.clunk(fid)
+	if err != nil || !f.rclose {
+		return err
+	}
+	return e.remove(f.fullName)
+}
+
+// Hangup clunks the fids the client left behind, so that their files
+// are closed, and those opened ORCLOSE removed, as if it had.
+func (e *FileServer) Hangup() {
+	e.mu.Lock()
+	var fids []protocol.FID
+	for fid := range e.files {
+		fids = append(fids, fid)
+	}
+	e.mu.Unlock()
+	for _, fid := range fids {
+		if err := e.Rclunk(fid); err != nil {
+			log.Printf("Hangup: clunk of fid %d: %v", fid, err)
+		}
+	}
 }
 
 func (e *FileServer) Rstat(fid protocol.FID) ([]byte, error) {
@@ -219,9 +434,9 @@ func (e *FileServer) Rstat(fid protocol.FID) ([]byte, error) {
 	}
 	st, err := os.Lstat(f.fullName)
 	if err != nil {
-		return []byte{}, fmt.Errorf("ENOENT")
+		return []byte{}, protocol.ErrNotExist
 	}
-	d, err := dirTo9p2000Dir(st)
+	d, err := e.dirTo9p2000Dir(st, f.fullName)
 	if err != nil {
 		return []byte{}, nil
 	}
@@ -229,12 +444,26 @@ func (e *FileServer) Rstat(fid protocol.FID) ([]byte, error) {
 	protocol.Marshaldir(&b, *d)
 	return b.Bytes(), nil
 }
+
+// Rreadlink returns where the symbolic link fid points, as the host has
+// it. Clients resolve it; the server does not check where it leads.
+func (e *FileServer) Rreadlink(fid protocol.FID) (string, error) {
+	f, err := e.getFile(fid)
+	if err != nil {
+		return "", err
+	}
+	return os.Readlink(f.fullName)
+}
+
 func (e *FileServer) Rwstat(fid protocol.FID, b []byte) error {
 	var changed bool
 	f, err := e.getFile(fid)
 	if err != nil {
 		return err
 	}
+	if err := e.writable(); err != nil {
+		return err
+	}
 	dir, err := protocol.Unmarshaldir(bytes.NewBuffer(b))
 	if err != nil {
 		return err
@@ -249,8 +478,7 @@ func (e *FileServer) Rwstat(fid protocol.FID, b []byte) error {
 
 	// Try to find local uid, gid by name.
 	if dir.User != "" || dir.Group != "" {
-		return fmt.Errorf("Permission denied")
-		changed = true
+		return protocol.ErrPermission
 	}
 
 	/*
@@ -266,6 +494,10 @@ func (e *FileServer) Rwstat(fid protocol.FID, b []byte) error {
 
 	if dir.Name != "" {
 		changed = true
+		// The root, be it a directory or a single file, stays put.
+		if f.fullName == e.rootPath {
+			return protocol.Errorf(protocol.ErrNotPermitted, "rename of the root")
+		}
 		// If we path.Join dir.Name to / before adding it to
 		// the fid path, that ensures nobody gets to walk out of the
 		// root of this server.
@@ -284,41 +516,48 @@ func (e *FileServer) Rwstat(fid protocol.FID, b []byte) error {
 
 		st, err := os.Stat(newname)
 		if err == nil && st.IsDir() {
-			return fmt.Errorf("is a directory")
+			return protocol.Errorf(protocol.ErrIsDir, "%v", dir.Name)
+		}
+		from, err := os.Lstat(f.fullName)
+		if err != nil {
+			return err
+		}
+		replaced, err := os.Lstat(newname)
+		if err != nil {
+			replaced = nil
 		}
 		if err := os.Rename(f.fullName, newname); err != nil {
 			return err
 		}
+		e.renamed(from, f.fullName, newname, replaced)
 		f.fullName = newname
 	}
 
 	if dir.Length != 0xFFFFFFFFFFFFFFFF {
 		changed = true
-		if err := os.Truncate(f.fullName, int64(dir.Length)); err != nil {
+		if e.appends != nil {
+			return errAppendOnly
+		}
+		if err := f.truncate(int64(dir.Length)); err != nil {
 			return err
 		}
+		e.modified(f.fullName)
 	}
 
 	// If either mtime or atime need to be changed, then
 	// we must change both.
-	if dir.Mtime != ^uint32(0) || dir.Atime != ^uint32(0) {
+	if dir.Mtime != protocol.TimeNoChange || dir.Atime != protocol.TimeNoChange {
 		changed = true
-		mt, at := time.Unix(int64(dir.Mtime), 0), time.Unix(int64(dir.Atime), 0)
-		if cmt, cat := (dir.Mtime == ^uint32(0)), (dir.Atime == ^uint32(0)); cmt || cat {
-			st, err := os.Stat(f.fullName)
-			if err != nil {
-				return err
-			}
-			switch cmt {
-			case true:
-				mt = st.ModTime()
-			default:
-				//at = atime(st.Sys().(*syscall.Stat_t))
-			}
+		st, err := f.stat()
+		if err != nil {
+			return err
 		}
-		if err := os.Chtimes(f.fullName, at, mt); err != nil {
+		at, mt := e.wstatTime(dir.Atime, fileAtime(st)), e.wstatTime(dir.Mtime, st.ModTime())
+		if err := f.chtimes(at, mt); err != nil {
 			return err
 		}
+		// The client's atime wins over the policy's.
+		f.read = nil
 	}
 
 	if !changed && f.file != nil {
@@ -332,7 +571,7 @@ func (e *FileServer) clunk(fid protocol.FID) (*file, error) {
 	defer e.mu.Unlock()
 	f, ok := e.files[fid]
 	if !ok {
-		return nil, fmt.Errorf("does not exist")
+		return nil, protocol.Errorf(protocol.ErrUnknownFID, "fid %d", fid)
 	}
 	delete(e.files, fid)
 	// What do we do if we can't close it?
@@ -341,6 +580,10 @@ func (e *FileServer) clunk(fid protocol.FID) (*file, error) {
 		if err := f.file.Close(); err != nil {
 			log.Printf("Close of %v failed: %v", f.fullName, err)
 		}
+		e.janitor.closed(f.openName)
+	}
+	if f.read != nil {
+		e.accessed(f.fullName, f.read)
 	}
 	return f, nil
 }
@@ -352,7 +595,22 @@ func (e *FileServer) Rremove(fid protocol.FID) error {
 	if err != nil {
 		return err
 	}
-	return os.Remove(f.fullName)
+	return e.remove(f.fullName)
+}
+
+func (e *FileServer) remove(name string) error {
+	if err := e.writable(); err != nil {
+		return err
+	}
+	st, err := os.Lstat(name)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(name); err != nil {
+		return err
+	}
+	e.forget(st, name)
+	return nil
 }
 
 func (e *FileServer) Rread(fid protocol.FID, o protocol.Offset, c protocol.Count) ([]byte, error) {
@@ -361,56 +619,32 @@ func (e *FileServer) Rread(fid protocol.FID, o protocol.Offset, c protocol.Count
 		return nil, err
 	}
 	if f.file == nil {
-		return nil, fmt.Errorf("FID not open")
+		return nil, protocol.Errorf(protocol.ErrNotOpen, "fid %d", fid)
 	}
 	if f.QID.Type&protocol.QTDIR != 0 {
 		if o == 0 {
-			f.oflow = nil
+			f.dirs.Reset()
 			if err := resetDir(f); err != nil {
 				return nil, err
 			}
 		}
-
-		// We make the assumption that they can always fit at least one
-		// directory entry into a read. If that assumption does not hold
-		// so many things are broken that we can't fix them here.
-		// But we'll drop out of the loop below having returned nothing
-		// anyway.
-		b := bytes.NewBuffer(f.oflow)
-		f.oflow = nil
-		pos := 0
-
-		for {
-			if b.Len() > int(c) {
-				f.oflow = b.Bytes()[pos:]
-				return b.Bytes()[:pos], nil
-			}
-			pos += b.Len()
+		return f.dirs.Pack(c, func() (protocol.Dir, error) {
 			st, err := f.file.Readdir(1)
-			if err == io.EOF {
-				return b.Bytes(), nil
-			}
 			if err != nil {
-				return nil, err
+				return protocol.Dir{}, err
 			}
-
-			d9p, err := dirTo9p2000Dir(st[0])
+			d, err := e.dirTo9p2000Dir(st[0], path.Join(f.fullName, st[0].Name()))
 			if err != nil {
-				return nil, err
-			}
-			protocol.Marshaldir(b, *d9p)
-			// Seen on linux clients: sometimes the math is wrong and
-			// they end up asking for the last element with not enough data.
-			// Linux bug or bug with this server? Not sure yet.
-			if b.Len() > int(c) {
-				log.Printf("Warning: Server bug? %v, need %d bytes;count is %d: skipping", d9p, b.Len(), c)
-				return nil, nil
+				return protocol.Dir{}, err
 			}
-			// We're not quite doing the array right.
-			// What does work is returning one thing so, for now, do that.
-			return b.Bytes(), nil
+			return *d, nil
+		})
+	}
+
+	if e.atime != nil && f.read == nil {
+		if f.read, err = f.file.Stat(); err != nil {
+			return nil, err
 		}
-		return b.Bytes(), nil
 	}
 
 	// N.B. even if they ask for 0 bytes on some file systems it is important to pass
@@ -429,35 +663,152 @@ func (e *FileServer) Rwrite(fid protocol.FID, o protocol.Offset, b []byte) (prot
 		return -1, err
 	}
 	if f.file == nil {
-		return -1, fmt.Errorf("FID not open")
+		return -1, protocol.Errorf(protocol.ErrNotOpen, "fid %d", fid)
+	}
+	if err := e.writable(); err != nil {
+		return -1, err
 	}
 
 	// N.B. even if they ask for 0 bytes on some file systems it is important to pass
 	// through a zero byte write (not Unix, of course). Also, let the underlying file system
 	// manage the error if the open mode was wrong. No need to duplicate the logic.
 
-	n, err := f.file.WriteAt(b, int64(o))
+	var n int
+	if e.appends != nil {
+		// Opened O_APPEND, so the write goes to the end.
+		n, err = f.file.Write(b)
+		e.appends.appended(f.QID.Path)
+	} else {
+		n, err = f.file.WriteAt(b, int64(o))
+	}
+	// Only a write which wrote something modified the file.
+	if n > 0 {
+		if !f.written {
+			f.written = true
+			e.modified(f.fullName)
+		}
+		e.add(e.uname, uint64(n), 0)
+	}
 	return protocol.Count(n), err
 }
 
+// Rcopy copies between two open files of the connection, for the copy
+// extension, with copy_file_range where the host has it, which some file
+// systems make a reflink, so that the data need not even be read.
+func (e *FileServer) Rcopy(fid protocol.FID, o protocol.Offset, dfid protocol.FID, do protocol.Offset, count uint64) (uint64, error) {
+	f, err := e.getFile(fid)
+	if err != nil {
+		return 0, err
+	}
+	df, err := e.getFile(dfid)
+	if err != nil {
+		return 0, err
+	}
+	if f.file == nil || df.file == nil {
+		return 0, protocol.ErrNotOpen
+	}
+	if f.QID.Type&protocol.QTDIR != 0 || df.QID.Type&protocol.QTDIR != 0 {
+		return 0, protocol.Errorf(protocol.ErrIsDir, "Tcopy of a directory")
+	}
+	if err := e.writable(); err != nil {
+		return 0, err
+	}
+	if e.appends != nil {
+		return 0, errAppendOnly
+	}
+	// ReadFrom uses copy_file_range from the files' offsets, which
+	// nothing else uses, since reads and writes give theirs.
+	if _, err := f.file.Seek(int64(o), io.SeekStart); err != nil {
+		return 0, err
+	}
+	if _, err := df.file.Seek(int64(do), io.SeekStart); err != nil {
+		return 0, err
+	}
+	n, err := df.file.ReadFrom(&io.LimitedReader{R: f.file, N: int64(count)})
+	if n > 0 {
+		if !df.written {
+			df.written = true
+			e.modified(df.fullName)
+		}
+		e.add(e.uname, uint64(n), 0)
+	}
+	return uint64(n), err
+}
+
 func NewUFS(root string, debug int, opts ...protocol.ListenerOpt) (*protocol.Listener, error) {
+	return NewServer(root, debug, nil, opts...)
+}
+
+// NewServer is like NewUFS, but also takes options for the file server
+// itself. They are applied once, and shared by all connections.
+func NewServer(root string, debug int, fsopts []Opt, opts ...protocol.ListenerOpt) (*protocol.Listener, error) {
+	cfg := &config{}
+	for _, o := range fsopts {
+		if err := o(cfg); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.setup(); err != nil {
+		return nil, err
+	}
+	// If the server doesn't get going, what setup opened is closed.
+	served := false
+	defer func() {
+		if !served {
+			cfg.close()
+		}
+	}()
+	// The root is made absolute, and clean, once, so that walks can be
+	// held within it. "" is the host's whole tree, as attaches are
+	// named from /.
+	if root == "" {
+		root = "/"
+	}
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	root = filepath.ToSlash(abs)
+	if cfg.changes != nil {
+		w, err := newWatcher(root, cfg.changed)
+		if err != nil {
+			return nil, err
+		}
+		cfg.changes.w = w
+	}
+	cfg.usage.root = root
+	if cfg.janitor != nil {
+		cfg.janitor.root = root
+		go cfg.sweeper()
+	}
 	nsCreator := func() protocol.NineServer {
-		f := &FileServer{}
+		f := &FileServer{config: cfg}
 		f.files = make(map[protocol.FID]*file)
-		f.rootPath = root // for now.
+		f.rootPath = root
 		f.IOunit = 8192
 
-		// any opts for the ufs layer can be added here too ...
 		var d protocol.NineServer = f
+		if cfg.budgets != nil {
+			d = newBudgeted(f)
+		}
 		if debug != 0 {
-			d = &ninep.DebugFileServer{FileServer: f}
+			d = ninep.NewDebugFileServer(d)
 		}
 		return d
 	}
+	// Whatever wraps the FileServer must still tell it who the peer
+	// is, or PeerAuth would let anyone in.
+	if cfg.peerAuth {
+		if _, ok := nsCreator().(protocol.PeerServer); !ok {
+			return nil, fmt.Errorf("PeerAuth: the server can't be told who its peers are")
+		}
+	}
 
 	l, err := protocol.NewListener(nsCreator, opts...)
 	if err != nil {
 		return nil, err
 	}
+	served = true
+	l.OnShutdown = cfg.close
 	return l, nil
 }
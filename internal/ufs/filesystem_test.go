@@ -3,13 +3,22 @@ package ufs
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"log"
 	"net"
 	"os"
 	"path"
+	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
 
+	"harvey-os.org/internal/ninetest"
+	"harvey-os.org/pkg/ninep"
 	"harvey-os.org/pkg/ninep/protocol"
 )
 
@@ -315,3 +324,1133 @@ func TestMount(t *testing.T) {
 		t.Fatalf("After remove(%v); stat returns nil, not err", yyy)
 	}
 }
+
+func TestQIDFile(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "qids")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+	qf := path.Join(tmpdir, "qids")
+
+	c := &config{}
+	if err := QIDFile(qf)(c); err != nil {
+		t.Fatalf("QIDFile: want nil, got %v", err)
+	}
+	if err := c.setup(); err != nil {
+		t.Fatalf("setup: want nil, got %v", err)
+	}
+	a := c.qids.PathHint(ninep.DevInoKey(1, 2), 2)
+	b := c.qids.PathHint(ninep.DevInoKey(3, 2), 2)
+	x := c.qids.Path(ninep.PathKey("/x"))
+	c.qids.Rename(ninep.PathKey("/x"), ninep.PathKey("/y"))
+	c.qids.Forget(ninep.DevInoKey(1, 2))
+	j := c.journal
+	if err := c.close(); err != nil {
+		t.Fatalf("close: want nil, got %v", err)
+	}
+	if _, err := j.WriteString("1 \"path:/after\"\n"); err == nil {
+		t.Errorf("writing the journal after close: want err, got nil")
+	}
+
+	// "Restart" the server.
+	c = &config{qidFile: qf}
+	if err := c.setup(); err != nil {
+		t.Fatalf("setup after restart: want nil, got %v", err)
+	}
+	if _, ok := c.qids.Lookup(ninep.DevInoKey(1, 2)); ok {
+		t.Errorf("dev 1 ino 2 (path %v) was forgotten, but came back", a)
+	}
+	if q, ok := c.qids.Lookup(ninep.DevInoKey(3, 2)); !ok || q != b {
+		t.Errorf("dev 3 ino 2 after restart: want (%v, true), got (%v, %v)", b, q, ok)
+	}
+	if q, ok := c.qids.Lookup(ninep.PathKey("/y")); !ok || q != x {
+		t.Errorf("/y after restart: want (%v, true), got (%v, %v)", x, q, ok)
+	}
+	if _, ok := c.qids.Lookup(ninep.PathKey("/x")); ok {
+		t.Errorf("/x was renamed, but is still in the pool after restart")
+	}
+
+	// A crash part way through a record leaves it torn. The server
+	// still starts, and the journal it goes on with takes new records
+	// whole.
+	c.close()
+	j, err = os.OpenFile(qf, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := j.WriteString(`99 "path:/to`); err != nil {
+		t.Fatal(err)
+	}
+	j.Close()
+	c = &config{qidFile: qf}
+	if err := c.setup(); err != nil {
+		t.Fatalf("setup after a torn record: want nil, got %v", err)
+	}
+	z := c.qids.Path(ninep.PathKey("/z"))
+	c.close()
+	c = &config{qidFile: qf}
+	if err := c.setup(); err != nil {
+		t.Fatalf("setup after a torn record, and a new one: want nil, got %v", err)
+	}
+	for k, want := range map[string]uint64{ninep.PathKey("/y"): x, ninep.PathKey("/z"): z} {
+		if q, ok := c.qids.Lookup(k); !ok || q != want {
+			t.Errorf("%v after a torn record: want (%v, true), got (%v, %v)", k, want, q, ok)
+		}
+	}
+	c.close()
+
+	// A server closes its journal when it is shut down.
+	n, err := NewServer(tmpdir, 0, []Opt{QIDFile(qf)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.OnShutdown == nil {
+		t.Fatalf("NewServer with a QIDFile: got no OnShutdown, want one closing the journal")
+	}
+	if err := n.Shutdown(); err != nil {
+		t.Errorf("Shutdown: want nil, got %v", err)
+	}
+}
+
+// TestRenameQIDs renames a file over another, and checks that the file
+// keeps its QID path, and the one it replaced loses its own.
+func TestRenameQIDs(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "renameqids")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+	a, b := path.Join(tmpdir, "a"), path.Join(tmpdir, "b")
+	for _, n := range []string{a, b} {
+		if err := ioutil.WriteFile(n, []byte(n), 0644); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+
+	c := &config{}
+	if err := c.setup(); err != nil {
+		t.Fatalf("setup: want nil, got %v", err)
+	}
+	e := &FileServer{config: c}
+	sa, err := os.Lstat(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sb, err := os.Lstat(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	qa, qb := e.qidPath(sa, a), e.qidPath(sb, b)
+	if err := os.Rename(a, b); err != nil {
+		t.Fatal(err)
+	}
+	c.renamed(sa, a, b, sb)
+
+	st, err := os.Lstat(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q, ok := c.lookup(st, b); !ok || q != qa {
+		t.Errorf("QID path of a renamed to b: got %#x, %v, want %#x, true", q, ok, qa)
+	}
+	if q, ok := c.lookup(sb, b); ok && q == qb {
+		t.Errorf("QID path of the replaced b: got %#x, want it forgotten", q)
+	}
+	if n := c.qids.Len(); n != 1 {
+		t.Errorf("QID pool after the rename: got %d keys, want 1", n)
+	}
+}
+
+// newTestClient serves root with ufs, and returns a client attached
+// to it with fid 0.
+func newTestClient(t *testing.T, root string, opts ...Opt) *protocol.Client {
+	n, err := NewServer(root, 0, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := ninetest.Pipe(t, n)
+	ninetest.Attach(t, c, "/")
+	return c
+}
+
+func TestEOF(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "eof")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+	if err := os.Mkdir(path.Join(tmpdir, "d"), 0755); err != nil {
+		t.Fatalf("%v", err)
+	}
+	for _, n := range []string{"a", "empty", "d/b"} {
+		if err := ioutil.WriteFile(path.Join(tmpdir, n), []byte(strings.Repeat(n, 3)), 0644); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+	if err := ioutil.WriteFile(path.Join(tmpdir, "empty"), nil, 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	c := newTestClient(t, tmpdir)
+	if err := fstest.TestFS(c.FS(0), "a", "empty", "d/b"); err != nil {
+		t.Errorf("TestFS: %v", err)
+	}
+
+	f, err := c.Open(0, []string{"empty"}, protocol.OREAD)
+	if err != nil {
+		t.Fatalf("Open(empty): want nil, got %v", err)
+	}
+	if n, err := f.Read(make([]byte, 8)); n != 0 || err != io.EOF {
+		t.Errorf("Read of empty file: want 0, EOF, got %d, %v", n, err)
+	}
+	f.Close()
+
+	f, err = c.Open(0, []string{"d"}, protocol.OREAD)
+	if err != nil {
+		t.Fatalf("Open(d): want nil, got %v", err)
+	}
+	// A read too small for an entry is an error, not the end.
+	if _, err := c.CallTread(f.FID(), 0, 8); err == nil || err == io.EOF {
+		t.Errorf("CallTread(d, 0, 8): want an error, got %v", err)
+	}
+	if d, err := f.Dirread(); len(d) != 1 || d[0].Name != "b" || err != nil {
+		t.Errorf("Dirread(d): want [b], nil, got %v, %v", d, err)
+	}
+	for i := 0; i < 2; i++ {
+		if d, err := f.Dirread(); len(d) != 0 || err != io.EOF {
+			t.Errorf("Dirread(d) at end: want [], EOF, got %v, %v", d, err)
+		}
+	}
+	f.Close()
+}
+
+func TestFileRoot(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "fileroot")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+	log := path.Join(tmpdir, "log")
+	for _, n := range []string{"log", "secret"} {
+		if err := ioutil.WriteFile(path.Join(tmpdir, n), []byte(n), 0644); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+
+	c := newTestClient(t, log)
+	st, err := c.CallTstat(0)
+	if err != nil {
+		t.Fatalf("CallTstat(root): want nil, got %v", err)
+	}
+	d, err := protocol.Unmarshaldir(bytes.NewBuffer(st))
+	if err != nil {
+		t.Fatalf("Unmarshaldir: want nil, got %v", err)
+	}
+	if d.QID.Type&protocol.QTDIR != 0 || d.Name != "log" {
+		t.Errorf("Stat(root): got %v, want the file log", d)
+	}
+
+	// It is read and written as any other file.
+	f, err := c.Open(0, nil, protocol.ORDWR)
+	if err != nil {
+		t.Fatalf("Open(root): want nil, got %v", err)
+	}
+	if _, err := f.WriteAt([]byte("LOG"), 0); err != nil {
+		t.Errorf("WriteAt(root): want nil, got %v", err)
+	}
+	f.Close()
+	if b, err := ioutil.ReadFile(log); err != nil || string(b) != "LOG" {
+		t.Errorf("log: got %q, %v, want LOG", b, err)
+	}
+
+	// Nothing else can be reached: not by walking, not by ..,
+	// which stays at the root, and not by attaching elsewhere.
+	for _, names := range [][]string{{"secret"}, {"..", "secret"}, {"..", "..", "secret"}} {
+		if q, err := c.CallTwalk(0, 1, names); err == nil && len(q) == len(names) {
+			t.Errorf("Walk(%v): want it to fail, got %v", names, q)
+			c.CallTclunk(1)
+		}
+	}
+	if q, err := c.CallTwalk(0, 1, []string{".."}); err != nil || len(q) != 1 || q[0].Type&protocol.QTDIR != 0 {
+		t.Errorf("Walk(..): want the root, got %v, %v", q, err)
+	}
+	c.CallTclunk(1)
+	if _, err := c.CallTattach(2, protocol.NOFID, "/", "../secret"); err == nil {
+		t.Errorf("Attach(../secret): want an error, got nil")
+	}
+	if _, err := c.Create(0, []string{"new"}, 0644, protocol.OWRITE); err == nil {
+		t.Errorf("Create(new): want an error, got nil")
+	}
+	d = protocol.Dir{Type: ^uint16(0), Dev: ^uint32(0), Mode: ^uint32(0), Atime: ^uint32(0), Mtime: ^uint32(0), Length: ^uint64(0), Name: "moved"}
+	d.QID = protocol.QID{Type: ^uint8(0), Version: ^uint32(0), Path: ^uint64(0)}
+	var b bytes.Buffer
+	protocol.Marshaldir(&b, d)
+	if err := c.CallTwstat(0, b.Bytes()); err == nil {
+		t.Errorf("Wstat(root, name moved): want an error, got nil")
+	}
+	if _, err := os.Stat(log); err != nil {
+		t.Errorf("log after rename: %v", err)
+	}
+}
+
+// TestRootNames checks that roots which are not absolute, "", the
+// host's whole tree, and one named from the current directory, hold
+// walks within them as an absolute one does.
+func TestRootNames(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "rootnames")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+	if err := ioutil.WriteFile(path.Join(tmpdir, "a"), []byte("a"), 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	rel, err := filepath.Rel(wd, tmpdir)
+	if err != nil {
+		t.Fatalf("no relative name for %v: %v", tmpdir, err)
+	}
+
+	for _, tt := range []struct {
+		root, aname string
+	}{
+		{"", tmpdir},
+		{rel, ""},
+	} {
+		c := newTestClient(t, tt.root)
+		if _, err := c.CallTattach(1, protocol.NOFID, "/", tt.aname); err != nil {
+			t.Fatalf("root %q: CallTattach(%q): want nil, got %v", tt.root, tt.aname, err)
+		}
+		if q, err := c.CallTwalk(1, 2, []string{"a"}); err != nil || len(q) != 1 {
+			t.Errorf("root %q: Walk(a): want nil, got %v, %v", tt.root, q, err)
+		}
+		c.CallTclunk(2)
+		if q, err := c.CallTwalk(1, 2, []string{"b"}); err == nil {
+			t.Errorf("root %q: Walk(b): want it to fail, got %v", tt.root, q)
+			c.CallTclunk(2)
+		}
+		if tt.root == "" {
+			continue
+		}
+		if q, err := c.CallTwalk(1, 2, []string{"..", "..", "a"}); err != nil || len(q) != 3 {
+			t.Errorf("root %q: Walk(.., .., a): want a, in the root, got %v, %v", tt.root, q, err)
+		}
+		c.CallTclunk(2)
+	}
+}
+
+func TestCreatePerm(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		p         PermPolicy
+		file, dir os.FileMode
+	}{
+		{"umask", Umask(027), 0640, 0750},
+		{"force", ForcePerm(0600, 0700), 0600, 0700},
+		// The parent is 0755.
+		{"inherit", InheritPerm, 0644, 0755},
+	} {
+		tmpdir, err := ioutil.TempDir(os.TempDir(), "perm")
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		defer os.RemoveAll(tmpdir)
+		if err := os.Chmod(tmpdir, 0755); err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		c := newTestClient(t, tmpdir, CreatePerm(tt.p))
+		f, err := c.Create(0, []string{"f"}, 0666, protocol.OWRITE)
+		if err != nil {
+			t.Fatalf("%s: Create(f): want nil, got %v", tt.name, err)
+		}
+		f.Close()
+		f, err = c.Create(0, []string{"d"}, protocol.DMDIR|0777, protocol.OREAD)
+		if err != nil {
+			t.Fatalf("%s: Create(d): want nil, got %v", tt.name, err)
+		}
+		f.Close()
+
+		for _, w := range []struct {
+			n string
+			m os.FileMode
+		}{{"f", tt.file}, {"d", tt.dir}} {
+			st, err := os.Stat(path.Join(tmpdir, w.n))
+			if err != nil {
+				t.Errorf("%s: %v", tt.name, err)
+				continue
+			}
+			if st.Mode().Perm() != w.m {
+				t.Errorf("%s: %s has mode %v, want %v", tt.name, w.n, st.Mode().Perm(), w.m)
+			}
+		}
+	}
+
+	if err := CreatePerm(InheritPerm)(&config{createPerm: InheritPerm}); err == nil {
+		t.Errorf("CreatePerm twice: want err, got nil")
+	}
+}
+
+func TestUsers(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "users")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+	if err := ioutil.WriteFile(path.Join(tmpdir, "f"), []byte("hi"), 0640); err != nil {
+		t.Fatalf("%v", err)
+	}
+	db := &ninep.UserFuncs{
+		UserFunc: func(uid uint32) (string, error) {
+			if int(uid) != os.Getuid() {
+				return "", fmt.Errorf("no user %d", uid)
+			}
+			return "glenda", nil
+		},
+		GroupFunc: func(gid uint32) (string, error) {
+			if int(gid) != os.Getgid() {
+				return "", fmt.Errorf("no group %d", gid)
+			}
+			return "sys", nil
+		},
+		InGroupFunc: func(user, group string) bool {
+			return user == "bob" && group == "sys"
+		},
+	}
+	c := newTestClient(t, tmpdir, Users(db))
+
+	for _, tt := range []struct {
+		user string
+		mode protocol.Mode
+		ok   bool
+	}{
+		{"glenda", protocol.ORDWR, true},
+		{"bob", protocol.OREAD, true},
+		{"bob", protocol.OWRITE, false},
+		{"eve", protocol.OREAD, false},
+	} {
+		if _, err := c.CallTattach(1, protocol.NOFID, tt.user, ""); err != nil {
+			t.Fatalf("CallTattach(%v): want nil, got %v", tt.user, err)
+		}
+		f, err := c.Open(1, []string{"f"}, tt.mode)
+		if (err == nil) != tt.ok {
+			t.Errorf("Open(f, %#x) as %v: got %v, want ok %v", tt.mode, tt.user, err, tt.ok)
+		}
+		if err == nil {
+			if st, err := c.CallTstat(f.FID()); err != nil {
+				t.Errorf("CallTstat(f): want nil, got %v", err)
+			} else if d, err := protocol.Unmarshaldir(bytes.NewBuffer(st)); err != nil || d.User != "glenda" || d.Group != "sys" {
+				t.Errorf("Stat(f): got %v, %v, want user glenda group sys", d, err)
+			}
+			f.Close()
+		}
+		c.CallTclunk(1)
+	}
+}
+
+func TestMuidXattr(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "muid")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+	if err := setMuid(tmpdir, "probe"); err != nil {
+		t.Skipf("no extended attributes in %v: %v", tmpdir, err)
+	}
+
+	c := newTestClient(t, tmpdir, MuidXattr())
+	muid := func() string {
+		st, err := c.CallTstat(1)
+		if err != nil {
+			t.Fatalf("CallTstat: want nil, got %v", err)
+		}
+		d, err := protocol.Unmarshaldir(bytes.NewBuffer(st))
+		if err != nil {
+			t.Fatalf("Unmarshaldir: want nil, got %v", err)
+		}
+		return d.ModUser
+	}
+
+	if _, err := c.CallTattach(1, protocol.NOFID, "bob", ""); err != nil {
+		t.Fatalf("CallTattach(bob): want nil, got %v", err)
+	}
+	if _, _, err := c.CallTcreate(1, "f", 0666, protocol.OWRITE); err != nil {
+		t.Fatalf("CallTcreate(f): want nil, got %v", err)
+	}
+	if m := muid(); m != "bob" {
+		t.Errorf("muid after create: got %q, want bob", m)
+	}
+	c.CallTclunk(1)
+
+	// A write which fails writes nothing, so modifies nothing.
+	if _, err := c.CallTattach(3, protocol.NOFID, "alice", ""); err != nil {
+		t.Fatalf("CallTattach(alice): want nil, got %v", err)
+	}
+	if _, err := c.CallTwalk(3, 1, []string{"f"}); err != nil {
+		t.Fatalf("CallTwalk(f): want nil, got %v", err)
+	}
+	if _, _, err := c.CallTopen(1, protocol.OREAD); err != nil {
+		t.Fatalf("CallTopen(f): want nil, got %v", err)
+	}
+	if _, err := c.CallTwrite(1, 0, []byte("hi")); err == nil {
+		t.Fatalf("CallTwrite(f) opened OREAD: want error, got nil")
+	}
+	if m := muid(); m != "bob" {
+		t.Errorf("muid after a failed write: got %q, want bob", m)
+	}
+	c.CallTclunk(1)
+
+	if _, err := c.CallTattach(2, protocol.NOFID, "glenda", ""); err != nil {
+		t.Fatalf("CallTattach(glenda): want nil, got %v", err)
+	}
+	if _, err := c.CallTwalk(2, 1, []string{"f"}); err != nil {
+		t.Fatalf("CallTwalk(f): want nil, got %v", err)
+	}
+	if _, _, err := c.CallTopen(1, protocol.OWRITE); err != nil {
+		t.Fatalf("CallTopen(f): want nil, got %v", err)
+	}
+	if _, err := c.CallTwrite(1, 0, []byte("hi")); err != nil {
+		t.Fatalf("CallTwrite(f): want nil, got %v", err)
+	}
+	if m := muid(); m != "glenda" {
+		t.Errorf("muid after write: got %q, want glenda", m)
+	}
+}
+
+// setTimes sends a Twstat of fid changing only its access and
+// modification times.
+func setTimes(t *testing.T, c *protocol.Client, fid protocol.FID, atime, mtime uint32) {
+	d := protocol.Dir{Type: ^uint16(0), Dev: ^uint32(0), Mode: ^uint32(0), Atime: atime, Mtime: mtime, Length: ^uint64(0)}
+	d.QID = protocol.QID{Type: ^uint8(0), Version: ^uint32(0), Path: ^uint64(0)}
+	var b bytes.Buffer
+	protocol.Marshaldir(&b, d)
+	if err := c.CallTwstat(fid, b.Bytes()); err != nil {
+		t.Fatalf("CallTwstat(atime %#x, mtime %#x): want nil, got %v", atime, mtime, err)
+	}
+}
+
+func TestTimes(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "times")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+	src := path.Join(tmpdir, "src")
+	if err := ioutil.WriteFile(src, []byte("old news"), 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+	at, mt := time.Unix(1262304000, 0), time.Unix(1230768000, 0)
+	if err := os.Chtimes(src, at, mt); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// Copy src to dst, as cp -p would, without disturbing src.
+	c := newTestClient(t, tmpdir, Atime(ninep.NoAtime))
+	f, err := c.Open(0, []string{"src"}, protocol.OREAD)
+	if err != nil {
+		t.Fatalf("Open(src): want nil, got %v", err)
+	}
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatalf("ReadAll(src): want nil, got %v", err)
+	}
+	f.Close()
+	g, err := c.Create(0, []string{"dst"}, 0644, protocol.OWRITE)
+	if err != nil {
+		t.Fatalf("Create(dst): want nil, got %v", err)
+	}
+	if _, err := g.Write(b); err != nil {
+		t.Fatalf("Write(dst): want nil, got %v", err)
+	}
+	setTimes(t, c, g.FID(), uint32(at.Unix()), uint32(mt.Unix()))
+	g.Close()
+	for _, n := range []string{src, path.Join(tmpdir, "dst")} {
+		fi, err := os.Stat(n)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if !fi.ModTime().Equal(mt) || !fileAtime(fi).Equal(at) {
+			t.Errorf("%v: got mtime %v atime %v, want %v %v", n, fi.ModTime(), fileAtime(fi), mt, at)
+		}
+	}
+
+	// Touch dst with the server's clock, leaving its atime alone.
+	g, err = c.Open(0, []string{"dst"}, protocol.OREAD)
+	if err != nil {
+		t.Fatalf("Open(dst): want nil, got %v", err)
+	}
+	setTimes(t, c, g.FID(), protocol.TimeNoChange, protocol.TimeNow)
+	g.Close()
+	fi, err := os.Stat(path.Join(tmpdir, "dst"))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if time.Since(fi.ModTime()) > time.Minute || !fileAtime(fi).Equal(at) {
+		t.Errorf("dst after touch: got mtime %v atime %v, want now and %v", fi.ModTime(), fileAtime(fi), at)
+	}
+
+	// With StrictAtime, reading src marks it as read.
+	if runtime.GOOS != "linux" {
+		return
+	}
+	c = newTestClient(t, tmpdir, Atime(ninep.StrictAtime))
+	if f, err = c.Open(0, []string{"src"}, protocol.OREAD); err != nil {
+		t.Fatalf("Open(src): want nil, got %v", err)
+	}
+	ioutil.ReadAll(f)
+	f.Close()
+	if fi, err = os.Stat(src); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if time.Since(fileAtime(fi)) > time.Minute || !fi.ModTime().Equal(mt) {
+		t.Errorf("src after read: got mtime %v atime %v, want %v and now", fi.ModTime(), fileAtime(fi), mt)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "truncate")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+	name := path.Join(tmpdir, "f")
+	if err := ioutil.WriteFile(name, []byte("0123456789"), 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+	c := newTestClient(t, tmpdir)
+	size := func() int64 {
+		fi, err := os.Stat(name)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		return fi.Size()
+	}
+
+	// A file open only for reading is truncated by name.
+	f, err := c.Open(0, []string{"f"}, protocol.OREAD)
+	if err != nil {
+		t.Fatalf("Open(f, OREAD): want nil, got %v", err)
+	}
+	if err := f.Truncate(8); err != nil {
+		t.Fatalf("Truncate(8): want nil, got %v", err)
+	}
+	f.Close()
+	if n := size(); n != 8 {
+		t.Errorf("size after Truncate(8): got %d, want 8", n)
+	}
+
+	// An open file which has been removed is still truncated, and a new
+	// file with its name is left alone.
+	f, err = c.Open(0, []string{"f"}, protocol.ORDWR)
+	if err != nil {
+		t.Fatalf("Open(f, ORDWR): want nil, got %v", err)
+	}
+	defer f.Close()
+	if err := os.Remove(name); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := ioutil.WriteFile(name, []byte("new"), 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := f.Truncate(4); err != nil {
+		t.Fatalf("Truncate(4) after remove: want nil, got %v", err)
+	}
+	b := make([]byte, 16)
+	if n, err := f.ReadAt(b, 0); n != 4 || err != io.EOF || string(b[:n]) != "0123" {
+		t.Errorf("ReadAt after Truncate(4): got %d, %q, %v, want 4, \"0123\", EOF", n, b[:n], err)
+	}
+	if n := size(); n != 3 {
+		t.Errorf("size of new f: got %d, want 3", n)
+	}
+
+	// Setting times goes through the open file too.
+	setTimes(t, c, f.FID(), protocol.TimeNoChange, protocol.TimeNow)
+}
+
+func TestPeerAuth(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skipf("no peer credentials on %v", runtime.GOOS)
+	}
+	// With -debug, the FileServer is wrapped, and must still be told
+	// who the peer is. What it logs is not wanted.
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(ioutil.Discard)
+	for _, debug := range []int{0, 1} {
+		testPeerAuth(t, debug)
+	}
+}
+
+func testPeerAuth(t *testing.T, debug int) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "peer")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+	n, err := NewServer(tmpdir, debug, []Opt{Users(ninep.NumericUsers{}), PeerAuth()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("unix", path.Join(tmpdir, "sock"))
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	go n.Serve(ln)
+	defer n.Shutdown()
+
+	me := strconv.Itoa(os.Getuid())
+	for _, tc := range []struct {
+		uname string
+		ok    bool
+	}{
+		{"", true},
+		{me, true},
+		{"12345", os.Getuid() == 0},
+	} {
+		conn, err := net.Dial("unix", path.Join(tmpdir, "sock"))
+		if err != nil {
+			t.Fatalf("Dial: %v", err)
+		}
+		c, err := protocol.NewClient(func(c *protocol.Client) error {
+			c.FromNet, c.ToNet = conn, conn
+			c.Msize = 8192
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if _, _, err := c.CallTversion(8192, "9P2000"); err != nil {
+			t.Fatalf("CallTversion: want nil, got %v", err)
+		}
+		_, err = c.CallTattach(0, protocol.NOFID, tc.uname, "")
+		if (err == nil) != tc.ok {
+			t.Errorf("debug %d: uid %v: attach as %q: got %v, want ok %v", debug, me, tc.uname, err, tc.ok)
+		}
+		conn.Close()
+	}
+
+	// Attaches over other transports are not checked.
+	c := newTestClient(t, tmpdir, Users(ninep.NumericUsers{}), PeerAuth())
+	if _, err := c.CallTattach(1, protocol.NOFID, "12345", ""); err != nil {
+		t.Errorf("debug %d: attach as 12345 over a pipe: want nil, got %v", debug, err)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "readonly")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+	if err := ioutil.WriteFile(path.Join(tmpdir, "f"), []byte("hi"), 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+	var ctl Control
+	c := newTestClient(t, tmpdir, Controlled(&ctl))
+	w, err := c.Open(0, []string{"f"}, protocol.OWRITE)
+	if err != nil {
+		t.Fatalf("Open(f, OWRITE): want nil, got %v", err)
+	}
+
+	ctl.SetReadOnly(true)
+	if !ctl.ReadOnly() {
+		t.Errorf("ReadOnly after SetReadOnly(true): got false")
+	}
+	if _, err := w.Write([]byte("ho")); err == nil {
+		t.Errorf("Write to a file opened before: want err, got nil")
+	}
+	if _, err := c.Open(0, []string{"f"}, protocol.OWRITE); err == nil {
+		t.Errorf("Open(f, OWRITE): want err, got nil")
+	}
+	if _, err := c.Create(0, []string{"g"}, 0644, protocol.OREAD); err == nil {
+		t.Errorf("Create(g): want err, got nil")
+	}
+	if err := c.Truncate(w.FID(), 0); err == nil {
+		t.Errorf("Truncate(f): want err, got nil")
+	}
+	r, err := c.Open(0, []string{"f"}, protocol.OREAD)
+	if err != nil {
+		t.Fatalf("Open(f, OREAD): want nil, got %v", err)
+	}
+	if b, err := ioutil.ReadAll(r); err != nil || string(b) != "hi" {
+		t.Errorf("ReadAll(f): got %q, %v, want hi", b, err)
+	}
+	if err := c.CallTremove(r.FID()); err == nil {
+		t.Errorf("Remove(f): want err, got nil")
+	}
+
+	ctl.SetReadOnly(false)
+	g, err := c.Create(0, []string{"g"}, 0644, protocol.OWRITE)
+	if err != nil {
+		t.Fatalf("Create(g) after SetReadOnly(false): want nil, got %v", err)
+	}
+	g.Close()
+	w.Close()
+}
+
+func TestCopy(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "copy")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+	data := bytes.Repeat([]byte("0123456789"), 100000)
+	if err := ioutil.WriteFile(path.Join(tmpdir, "f"), data, 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+	c := newTestClient(t, tmpdir)
+	// Again, with the extension, which starts the session again.
+	if _, _, got, err := c.Version(8192, "9P2000", protocol.CopyExtension); err != nil || len(got) != 1 {
+		t.Fatalf("Version(copy): got %v, %v, want [copy], nil", got, err)
+	}
+	if _, err := c.CallTattach(0, protocol.NOFID, "/", ""); err != nil {
+		t.Fatalf("CallTattach: want nil, got %v", err)
+	}
+	f, err := c.Open(0, []string{"f"}, protocol.OREAD)
+	if err != nil {
+		t.Fatalf("Open(f): want nil, got %v", err)
+	}
+	g, err := c.Create(0, []string{"g"}, 0644, protocol.OWRITE)
+	if err != nil {
+		t.Fatalf("Create(g): want nil, got %v", err)
+	}
+	if n, err := c.Copy(f.FID(), 5, g.FID(), 0, int64(len(data))); err != nil || n != int64(len(data)-5) {
+		t.Errorf("Copy: got %d, %v, want %d, nil", n, err, len(data)-5)
+	}
+	g.Close()
+	if b, err := ioutil.ReadFile(path.Join(tmpdir, "g")); err != nil || !bytes.Equal(b, data[5:]) {
+		t.Errorf("g: got %d bytes, %v, want %d bytes from f", len(b), err, len(data)-5)
+	}
+}
+
+func TestAppendOnly(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "append")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+	log := path.Join(tmpdir, "log")
+	if err := ioutil.WriteFile(log, []byte("one\n"), 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+	clock := protocol.NewFakeClock(time.Now())
+	c := newTestClient(t, tmpdir, AppendOnly(), Clock(clock))
+
+	// Writes go to the end, wherever they say.
+	w, err := c.Open(0, []string{"log"}, protocol.OWRITE)
+	if err != nil {
+		t.Fatalf("Open(log, OWRITE): want nil, got %v", err)
+	}
+	if _, err := w.WriteAt([]byte("two\n"), 0); err != nil {
+		t.Fatalf("WriteAt(0): want nil, got %v", err)
+	}
+	if b, err := ioutil.ReadFile(log); err != nil || string(b) != "one\ntwo\n" {
+		t.Errorf("log: got %q, %v, want one, two", b, err)
+	}
+
+	// Nothing truncates.
+	if _, err := c.Open(0, []string{"log"}, protocol.OWRITE|protocol.OTRUNC); err == nil {
+		t.Errorf("Open(log, OTRUNC): want an error, got nil")
+	}
+	if _, err := c.Create(0, []string{"log"}, 0644, protocol.OWRITE); err == nil {
+		t.Errorf("Create(log): want an error, got nil")
+	}
+	if err := c.Truncate(w.FID(), 0); err == nil {
+		t.Errorf("Truncate(log): want an error, got nil")
+	}
+
+	// A read at the end waits for a write through the server, and,
+	// for one made otherwise, the next look.
+	r, err := c.Open(0, []string{"log"}, protocol.OREAD)
+	if err != nil {
+		t.Fatalf("Open(log): want nil, got %v", err)
+	}
+	got := make(chan string)
+	tail := func(o protocol.Offset) {
+		b, err := c.CallTread(r.FID(), o, 100)
+		if err != nil {
+			t.Errorf("CallTread(%d): want nil, got %v", o, err)
+		}
+		got <- string(b)
+	}
+	go tail(8)
+	clock.Wait(1)
+	if _, err := w.WriteAt([]byte("three\n"), 0); err != nil {
+		t.Fatalf("WriteAt(0): want nil, got %v", err)
+	}
+	if s := <-got; s != "three\n" {
+		t.Errorf("CallTread(8) after write: got %q, want three", s)
+	}
+
+	go tail(14)
+	clock.Wait(1)
+	f, err := os.OpenFile(log, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	f.Write([]byte("four\n"))
+	f.Close()
+	select {
+	case s := <-got:
+		t.Fatalf("CallTread(14) before the next look: got %q, want it to wait", s)
+	case <-time.After(50 * time.Millisecond):
+	}
+	clock.Advance(appendPoll)
+	if s := <-got; s != "four\n" {
+		t.Errorf("CallTread(14) after the next look: got %q, want four", s)
+	}
+}
+
+func TestJanitor(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "janitor")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+	var ctl Control
+	c := newTestClient(t, tmpdir, Janitor(time.Hour, "*.tmp"), Controlled(&ctl))
+	held, err := c.Create(0, []string{"held.tmp"}, 0644, protocol.OWRITE)
+	if err != nil {
+		t.Fatalf("Create(held.tmp): want nil, got %v", err)
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	for _, n := range []string{"old.tmp", "new.tmp", "old", "held.tmp"} {
+		if n != "held.tmp" {
+			if err := ioutil.WriteFile(path.Join(tmpdir, n), nil, 0644); err != nil {
+				t.Fatalf("%v", err)
+			}
+		}
+		if n != "new.tmp" {
+			if err := os.Chtimes(path.Join(tmpdir, n), old, old); err != nil {
+				t.Fatalf("%v", err)
+			}
+		}
+	}
+	crashed := path.Join(tmpdir, "crashed")
+	if err := ioutil.WriteFile(crashed, nil, 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+	marked := setRclose(crashed) == nil
+	os.Chtimes(crashed, old, old)
+
+	if _, err := ctl.Sweep(); err != nil {
+		t.Fatalf("Sweep: want nil, got %v", err)
+	}
+	want := map[string]bool{"old.tmp": false, "new.tmp": true, "old": true, "held.tmp": true}
+	if marked {
+		want["crashed"] = false
+	}
+	for n, ok := range want {
+		if _, err := os.Stat(path.Join(tmpdir, n)); (err == nil) != ok {
+			t.Errorf("%v after Sweep: got %v, want it to exist: %v", n, err, ok)
+		}
+	}
+	held.Close()
+
+	// Files opened ORCLOSE go when clunked, or when the client does.
+	f, err := c.Create(0, []string{"o"}, 0644, protocol.OWRITE|protocol.ORCLOSE)
+	if err != nil {
+		t.Fatalf("Create(o, ORCLOSE): want nil, got %v", err)
+	}
+	f.Close()
+	if _, err := os.Stat(path.Join(tmpdir, "o")); err == nil {
+		t.Errorf("o after clunk: exists, want it removed")
+	}
+	if _, err := c.Create(0, []string{"p"}, 0644, protocol.OWRITE|protocol.ORCLOSE); err != nil {
+		t.Fatalf("Create(p, ORCLOSE): want nil, got %v", err)
+	}
+	c.ToNet.Close()
+	for i := 0; ; i++ {
+		if _, err := os.Stat(path.Join(tmpdir, "p")); err != nil {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("p after hangup: exists, want it removed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestJanitorClock has the janitor sweep on its own, by the clock.
+func TestJanitorClock(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "janitor")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+	if err := ioutil.WriteFile(path.Join(tmpdir, "a.tmp"), nil, 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+	clock := protocol.NewFakeClock(time.Now())
+	newTestClient(t, tmpdir, Janitor(time.Hour, "*.tmp"), Clock(clock))
+	// The first sweep is done once the next is waiting.
+	clock.Wait(1)
+	if _, err := os.Stat(path.Join(tmpdir, "a.tmp")); err != nil {
+		t.Fatalf("a.tmp after first sweep: want it to exist, got %v", err)
+	}
+	clock.Advance(2 * time.Hour)
+	clock.Wait(1)
+	if _, err := os.Stat(path.Join(tmpdir, "a.tmp")); err == nil {
+		t.Errorf("a.tmp two hours later: exists, want it removed")
+	}
+}
+
+func TestSymlinks(t *testing.T) {
+	if protocol.Minimal {
+		t.Skip("symbolic links need 9P2000.u or 9P2000.L, which the minimal build leaves out")
+	}
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "symlinks")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+	root := path.Join(tmpdir, "root")
+	for _, d := range []string{path.Join(root, "d"), path.Join(tmpdir, "outside")} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+	if err := ioutil.WriteFile(path.Join(root, "d", "f"), []byte("hello"), 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := ioutil.WriteFile(path.Join(tmpdir, "outside", "f"), []byte("secret"), 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+	for link, target := range map[string]string{
+		"in":     "d",
+		"d/back": "../d/f",
+		"out":    "../outside",
+		"abs":    "/d/f",
+		"loop":   "loop",
+	} {
+		if err := os.Symlink(target, path.Join(root, link)); err != nil {
+			t.Skipf("Symlink: %v", err)
+		}
+	}
+
+	follow := protocol.SymlinkPolicy{Follow: 40}
+	var tests = []struct {
+		policy *protocol.SymlinkPolicy
+		name   string
+		want   string
+	}{
+		{policy: &protocol.FollowWithin, name: "in/f", want: "hello"},
+		{policy: &protocol.FollowWithin, name: "d/back", want: "hello"},
+		{policy: &protocol.FollowWithin, name: "out/f"},
+		{policy: &protocol.FollowWithin, name: "abs"},
+		{policy: &protocol.FollowWithin, name: "loop"},
+		{policy: &follow, name: "abs", want: "hello"},
+		// From the root, ../outside is outside, which isn't there.
+		{policy: &follow, name: "out/f"},
+		{policy: &protocol.NoFollow, name: "in/f"},
+	}
+	for _, v := range []string{"9P2000.u", "9P2000.L"} {
+		n, err := NewServer(root, 0, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := ninetest.Pipe(t, n)
+		if _, got, _, err := c.Version(8192, v); got != v || err != nil {
+			t.Fatalf("Version(%v): got %v, %v", v, got, err)
+		}
+		fid, _, err := c.Attach("", "", nil)
+		if err != nil {
+			t.Fatalf("%v: Attach: want nil, got %v", v, err)
+		}
+		if target, err := c.Readlink(mustWalk(t, c, fid, "out")); target != "../outside" || err != nil {
+			t.Errorf("%v: Readlink(out): want ../outside, nil, got %q, %v", v, target, err)
+		}
+		for _, tt := range tests {
+			c.Symlinks = tt.policy
+			f, err := c.Open(fid, strings.Split(tt.name, "/"), protocol.OREAD)
+			if tt.want == "" {
+				if err == nil {
+					f.Close()
+					t.Errorf("%v: Open(%v) with %+v: want an error, got nil", v, tt.name, *tt.policy)
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("%v: Open(%v) with %+v: want nil, got %v", v, tt.name, *tt.policy, err)
+				continue
+			}
+			b, err := ioutil.ReadAll(f)
+			f.Close()
+			if string(b) != tt.want || err != nil {
+				t.Errorf("%v: reading %v with %+v: want %q, nil, got %q, %v", v, tt.name, *tt.policy, tt.want, b, err)
+			}
+		}
+	}
+}
+
+// mustWalk walks a new fid from fid to name, without resolving links.
+func mustWalk(t *testing.T, c *protocol.Client, fid protocol.FID, name string) protocol.FID {
+	t.Helper()
+	w, err := c.Walk(fid, []string{name})
+	if err != nil {
+		t.Fatalf("Walk(%v): want nil, got %v", name, err)
+	}
+	return w.FID
+}
+
+func TestSoftQuota(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "quota")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+	var ctl Control
+	c := newTestClient(t, tmpdir, Controlled(&ctl), SoftQuota(Quota{Written: 10, Created: 3}, "QUOTA"))
+
+	f, err := c.Create(0, []string{"f"}, 0644, protocol.OWRITE)
+	if err != nil {
+		t.Fatalf("Create(f): want nil, got %v", err)
+	}
+	if _, err := f.Write([]byte("0123456789")); err != nil {
+		t.Fatalf("Write: want nil, got %v", err)
+	}
+	if u := ctl.Usage()["/"]; u.Written != 10 || u.Created != 1 || u.Over {
+		t.Errorf("Usage after 10 bytes: got %+v, want 10 written, 1 created, not over", u)
+	}
+	if _, err := os.Stat(path.Join(tmpdir, "QUOTA")); !os.IsNotExist(err) {
+		t.Errorf("QUOTA under the quota: want none, got %v", err)
+	}
+
+	// Over, which is only a warning, given once.
+	for i := 0; i < 2; i++ {
+		if _, err := f.Write([]byte("x")); err != nil {
+			t.Fatalf("Write over the quota: want nil, got %v", err)
+		}
+	}
+	f.Close()
+	if u := ctl.Usage()["/"]; u.Written != 12 || !u.Over {
+		t.Errorf("Usage after 12 bytes: got %+v, want 12 written, over", u)
+	}
+	r, err := c.Open(0, []string{"QUOTA"}, protocol.OREAD)
+	if err != nil {
+		t.Fatalf("Open(QUOTA): want nil, got %v", err)
+	}
+	b, err := ioutil.ReadAll(r)
+	r.Close()
+	if err != nil || strings.Count(string(b), "\n") != 1 || !strings.Contains(string(b), " /: 11 bytes written of 10, 1 files created of 3: over the soft quota") {
+		t.Errorf("QUOTA: got %q, %v, want one warning", b, err)
+	}
+
+	for _, n := range []string{"g", "h"} {
+		g, err := c.Create(0, []string{n}, 0644, protocol.OWRITE)
+		if err != nil {
+			t.Fatalf("Create(%v): want nil, got %v", n, err)
+		}
+		g.Close()
+	}
+	if u := ctl.Usage()["/"]; u.Created != 3 {
+		t.Errorf("Usage after 3 creates: got %+v, want 3 created", u)
+	}
+
+	if err := SoftQuota(Quota{}, "/")(&config{}); err == nil {
+		t.Errorf("SoftQuota with status /: want err, got nil")
+	}
+}
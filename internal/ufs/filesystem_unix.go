@@ -8,7 +8,12 @@
 
 package ufs
 
-import "io"
+import (
+	"io"
+	"os"
+	"syscall"
+	"time"
+)
 
 // resetDir seeks to the beginning of the file so that the file list can be
 // read again.
@@ -16,3 +21,16 @@ func resetDir(f *file) error {
 	_, err := f.file.Seek(0, io.SeekStart)
 	return err
 }
+
+// chtimes sets the access and modification times of f, through the open
+// file if there is one, so that it works even if the file was removed.
+func (f *file) chtimes(atime, mtime time.Time) error {
+	if f.file == nil {
+		return os.Chtimes(f.fullName, atime, mtime)
+	}
+	tv := []syscall.Timeval{syscall.NsecToTimeval(atime.UnixNano()), syscall.NsecToTimeval(mtime.UnixNano())}
+	if err := syscall.Futimes(int(f.file.Fd()), tv); err != nil {
+		return &os.PathError{Op: "futimes", Path: f.fullName, Err: err}
+	}
+	return nil
+}
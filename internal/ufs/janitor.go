@@ -0,0 +1,129 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ufs
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"harvey-os.org/pkg/ninep/protocol"
+)
+
+// janitor removes temporary files which have been left behind: by
+// clients which went away without clunking them, or by a server which
+// crashed before it could remove them.
+type janitor struct {
+	// root is the tree swept.
+	root string
+
+	// age is how long a temporary file must have been left alone
+	// before it is removed.
+	age time.Duration
+
+	// patterns are the names, as for filepath.Match, of temporary
+	// files.
+	patterns []string
+
+	// mu guards below.
+	mu sync.Mutex
+	// open counts the fids which have each file open, by full name,
+	// on any connection, so that none is removed while in use.
+	open map[string]int
+}
+
+// temp reports whether name, a full name, is that of a temporary file.
+func (j *janitor) temp(name string) bool {
+	base := filepath.Base(name)
+	for _, p := range j.patterns {
+		if ok, _ := filepath.Match(p, base); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// opened notes that a fid has name open. It does nothing if there is
+// no janitor.
+func (j *janitor) opened(name string) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.open[name]++
+}
+
+// closed notes that a fid which had name open no longer has.
+func (j *janitor) closed(name string) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.open[name]--; j.open[name] <= 0 {
+		delete(j.open, name)
+	}
+}
+
+// remove removes name, unless it is open.
+func (j *janitor) remove(name string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.open[name] > 0 {
+		return errInUse
+	}
+	return os.Remove(name)
+}
+
+// errInUse is why remove didn't.
+var errInUse = protocol.Errorf(protocol.ErrInvalid, "file in use")
+
+// sweep removes the temporary files, and those opened ORCLOSE, which
+// nobody has open and which have not been changed for age. It returns
+// how many it removed. A read-only server removes nothing.
+func (c *config) sweep() (int, error) {
+	j := c.janitor
+	if j == nil {
+		return 0, fmt.Errorf("no janitor")
+	}
+	if err := c.writable(); err != nil {
+		return 0, err
+	}
+	var n int
+	old := c.clock.Now().Add(-j.age)
+	err := filepath.Walk(j.root, func(name string, st os.FileInfo, err error) error {
+		if err != nil {
+			// Whatever can't be read can't be swept, but
+			// the rest can.
+			return nil
+		}
+		if !st.Mode().IsRegular() || st.ModTime().After(old) || !(j.temp(name) || hasRclose(name)) {
+			return nil
+		}
+		switch err := j.remove(name); {
+		case err == errInUse:
+		case err != nil:
+			log.Printf("janitor: %v", err)
+		default:
+			c.forget(st, name)
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
+
+// sweeper sweeps now, for what a crash left, and then every age, for
+// good.
+func (c *config) sweeper() {
+	if _, err := c.sweep(); err != nil && err != errReadOnly {
+		log.Printf("janitor: %v", err)
+	}
+	c.clock.AfterFunc(c.janitor.age, c.sweeper)
+}
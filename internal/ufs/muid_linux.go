@@ -0,0 +1,25 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build linux
+
+package ufs
+
+import "syscall"
+
+// muidAttr is the extended attribute holding the last modifier.
+const muidAttr = "user.9p.muid"
+
+func getMuid(name string) (string, bool) {
+	b := make([]byte, 256)
+	n, err := syscall.Getxattr(name, muidAttr, b)
+	if err != nil {
+		return "", false
+	}
+	return string(b[:n]), true
+}
+
+func setMuid(name, uname string) error {
+	return syscall.Setxattr(name, muidAttr, []byte(uname), 0)
+}
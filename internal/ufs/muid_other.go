@@ -0,0 +1,19 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !linux
+
+package ufs
+
+import "fmt"
+
+// Only Linux has extended attributes we know how to use.
+
+func getMuid(name string) (string, bool) {
+	return "", false
+}
+
+func setMuid(name, uname string) error {
+	return fmt.Errorf("extended attributes not supported")
+}
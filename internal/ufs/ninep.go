@@ -9,7 +9,11 @@ package ufs
 import (
 	"flag"
 	"os"
+	"path"
+	"strconv"
+	"time"
 
+	"harvey-os.org/pkg/ninep"
 	"harvey-os.org/pkg/ninep/protocol"
 )
 
@@ -17,31 +21,104 @@ var (
 	user = flag.String("user", "harvey", "Default user name")
 )
 
+// modeToUnixFlags converts a 9P open mode to os flags. Writes are done
+// with WriteAt, which fails on files opened with O_APPEND, so that one
+// is left out.
 func modeToUnixFlags(mode protocol.Mode) int {
-	ret := int(0)
-	switch mode & 3 {
-	case protocol.OREAD:
-		ret = os.O_RDONLY
-		break
+	return protocol.ModeToFlags(mode) &^ os.O_APPEND
+}
 
-	case protocol.ORDWR:
-		ret = os.O_RDWR
-		break
+// owner returns the names of the user and group that own a file.
+func (e *FileServer) owner(fi os.FileInfo) (string, string) {
+	uid, gid, ok := fileOwner(fi)
+	if e.users == nil || !ok {
+		return *user, *user
+	}
+	u, err := e.users.User(uid)
+	if err != nil {
+		u = strconv.FormatUint(uint64(uid), 10)
+	}
+	g, err := e.users.Group(gid)
+	if err != nil {
+		g = strconv.FormatUint(uint64(gid), 10)
+	}
+	return u, g
+}
 
-	case protocol.OWRITE:
-		ret = os.O_WRONLY
-		break
+// modified records that the attaching user changed the file, if we keep
+// track. It does its best: a file system without extended attributes
+// just goes without.
+func (e *FileServer) modified(name string) {
+	if e.muidXattr {
+		setMuid(name, e.uname)
+	}
+}
 
-	case protocol.OEXEC:
-		ret = os.O_RDONLY
-		break
+// accessed applies the access time policy to a file which has been read,
+// given its FileInfo from before the first read. The host may or may not
+// have changed the access time itself, so it is always set: to now, or
+// back to what it was.
+func (e *FileServer) accessed(name string, read os.FileInfo) {
+	// As with a read-only mount, reads change nothing.
+	if e.writable() != nil {
+		return
+	}
+	fi, err := os.Stat(name)
+	if err != nil {
+		return
+	}
+	at, now := fileAtime(read), e.clock.Now()
+	if e.atime(at, read.ModTime(), now) {
+		at = now
 	}
+	os.Chtimes(name, at, fi.ModTime())
+}
+
+// wstatTime returns the time a Twstat asks for, given the time a file
+// has now.
+func (c *config) wstatTime(t uint32, old time.Time) time.Time {
+	switch t {
+	case protocol.TimeNoChange:
+		return old
+	case protocol.TimeNow:
+		return c.clock.Now()
+	}
+	return time.Unix(int64(t), 0)
+}
 
+// openPerm returns the permission bits needed to open a file in mode.
+func openPerm(mode protocol.Mode) uint32 {
+	var p uint32
+	switch mode & 3 {
+	case protocol.OREAD:
+		p = 4
+	case protocol.OWRITE:
+		p = 2
+	case protocol.ORDWR:
+		p = 6
+	case protocol.OEXEC:
+		p = 1
+	}
 	if mode&protocol.OTRUNC != 0 {
-		ret |= os.O_TRUNC
+		p |= 2
 	}
+	return p
+}
 
-	return ret
+// allowed checks that the attaching user may do what want asks of the
+// file called name, if there is a user database to say who they are.
+func (e *FileServer) allowed(name string, want uint32) error {
+	if e.users == nil {
+		return nil
+	}
+	d, _, err := e.stat(name)
+	if err != nil {
+		return err
+	}
+	if !ninep.Allowed(e.users, e.uname, *d, want) {
+		return protocol.Errorf(protocol.ErrPermission, "%v", path.Base(name))
+	}
+	return nil
 }
 
 func dirToQIDType(d os.FileInfo) uint8 {
@@ -62,20 +139,37 @@ func dirTo9p2000Mode(d os.FileInfo) uint32 {
 	if d.IsDir() {
 		ret |= protocol.DMDIR
 	}
+	if d.Mode()&os.ModeSymlink != 0 {
+		ret |= protocol.DMSYMLINK
+	}
 	return ret
 }
 
-func dirTo9p2000Dir(fi os.FileInfo) (*protocol.Dir, error) {
+func (e *FileServer) fileInfoToQID(d os.FileInfo, name string) protocol.QID {
+	var qid protocol.QID
+
+	qid.Path = e.qidPath(d, name)
+	qid.Version = uint32(d.ModTime().UnixNano()/1000000) + e.changes.version(name)
+	qid.Type = dirToQIDType(d)
+
+	return qid
+}
+
+func (e *FileServer) dirTo9p2000Dir(fi os.FileInfo, name string) (*protocol.Dir, error) {
 	d := &protocol.Dir{}
-	d.QID = fileInfoToQID(fi)
+	d.QID = e.fileInfoToQID(fi, name)
 	d.Mode = dirTo9p2000Mode(fi)
-	// TODO: use info on systems that have it.
-	d.Atime = uint32(fi.ModTime().Unix()) // uint32(atime(sysMode).Unix())
+	d.Atime = uint32(fileAtime(fi).Unix())
 	d.Mtime = uint32(fi.ModTime().Unix())
 	d.Length = uint64(fi.Size())
 	d.Name = fi.Name()
-	d.User = *user
-	d.Group = *user
+	d.User, d.Group = e.owner(fi)
+	d.ModUser = d.User
+	if e.muidXattr {
+		if m, ok := getMuid(name); ok {
+			d.ModUser = m
+		}
+	}
 
 	return d, nil
 }
@@ -12,23 +12,56 @@ import (
 	"os"
 	"syscall"
 
-	"harvey-os.org/pkg/ninep/protocol"
+	"harvey-os.org/pkg/ninep"
 )
 
-func fileInfoToQID(d os.FileInfo) protocol.QID {
-	var qid protocol.QID
-	sysif := d.Sys()
+// qidKey returns the QID pool key for a file, and the path we would
+// like it to have. On systems with inodes, use them.
+func qidKey(d os.FileInfo, name string) (string, uint64) {
+	if stat, ok := d.Sys().(*syscall.Stat_t); ok {
+		return ninep.DevInoKey(uint64(stat.Dev), uint64(stat.Ino)), uint64(stat.Ino)
+	}
+	k := ninep.PathKey(name)
+	return k, uint64(d.ModTime().UnixNano())
+}
 
-	// on systems with inodes, use it.
-	if sysif != nil {
-		stat := sysif.(*syscall.Stat_t)
-		qid.Path = uint64(stat.Ino)
-	} else {
-		qid.Path = uint64(d.ModTime().UnixNano())
+// fileOwner returns the numeric owner and group of a file.
+func fileOwner(d os.FileInfo) (uint32, uint32, bool) {
+	if stat, ok := d.Sys().(*syscall.Stat_t); ok {
+		return stat.Uid, stat.Gid, true
 	}
+	return 0, 0, false
+}
+
+func (e *FileServer) qidPath(d os.FileInfo, name string) uint64 {
+	return e.qids.PathHint(qidKey(d, name))
+}
 
-	qid.Version = uint32(d.ModTime().UnixNano() / 1000000)
-	qid.Type = dirToQIDType(d)
+// forget drops a removed file from the QID pool.
+func (c *config) forget(d os.FileInfo, name string) {
+	k, _ := qidKey(d, name)
+	c.qids.Forget(k)
+}
+
+// renamed follows a rename of d from one name to another in the QID
+// pool. A file known by its inode keeps its key; one known by its name
+// takes the new name's. Whatever the rename replaced is gone.
+func (c *config) renamed(d os.FileInfo, from, to string, replaced os.FileInfo) {
+	k, _ := qidKey(d, from)
+	if replaced != nil {
+		// A hard link of the same file is not replaced: rename(2)
+		// does nothing.
+		if rk, _ := qidKey(replaced, to); rk != k {
+			c.qids.Forget(rk)
+		}
+	}
+	if k == ninep.PathKey(from) {
+		c.qids.Rename(k, ninep.PathKey(to))
+	}
+}
 
-	return qid
+// lookup returns the QID path of a file, if it has one yet.
+func (c *config) lookup(d os.FileInfo, name string) (uint64, bool) {
+	k, _ := qidKey(d, name)
+	return c.qids.Lookup(k)
 }
@@ -11,15 +11,36 @@ package ufs
 import (
 	"os"
 
-	"harvey-os.org/pkg/ninep/protocol"
+	"harvey-os.org/pkg/ninep"
 )
 
-func fileInfoToQID(d os.FileInfo) protocol.QID {
-	var qid protocol.QID
+// There are no inodes we can get at cheaply, so files are
+// known by their path.
+func (e *FileServer) qidPath(d os.FileInfo, name string) uint64 {
+	return e.qids.Path(ninep.PathKey(name))
+}
+
+// forget drops a removed file from the QID pool.
+func (c *config) forget(d os.FileInfo, name string) {
+	c.qids.Forget(ninep.PathKey(name))
+}
 
-	qid.Path = uint64(d.ModTime().UnixNano())
-	qid.Version = uint32(d.ModTime().UnixNano() / 1000000)
-	qid.Type = dirToQIDType(d)
+// renamed follows a rename of d from one name to another in the QID
+// pool. Whatever the rename replaced is gone.
+func (c *config) renamed(d os.FileInfo, from, to string, replaced os.FileInfo) {
+	if replaced != nil {
+		c.qids.Forget(ninep.PathKey(to))
+	}
+	c.qids.Rename(ninep.PathKey(from), ninep.PathKey(to))
+}
+
+// fileOwner returns the numeric owner and group of a file, which
+// Windows does not have.
+func fileOwner(d os.FileInfo) (uint32, uint32, bool) {
+	return 0, 0, false
+}
 
-	return qid
+// lookup returns the QID path of a file, if it has one yet.
+func (c *config) lookup(d os.FileInfo, name string) (uint64, bool) {
+	return c.qids.Lookup(ninep.PathKey(name))
 }
@@ -0,0 +1,93 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ufs
+
+import (
+	"os"
+	"path"
+	"sync"
+)
+
+// Notify makes the server watch the files it exports for changes made
+// other than through it, such as by editing them on the host, so that
+// clients see them at once. Each change gives the file, and the
+// directory it is in, a new QID version, which clients cache by, even
+// within the resolution of the host's modification times, and wakes the
+// reads waiting at the end of the file with AppendOnly. Directories are
+// watched once a client walks to them. Only Linux and Windows can say
+// what has changed; elsewhere, NewServer fails.
+func Notify() Opt {
+	return func(c *config) error {
+		c.changes = &changes{bumps: make(map[string]uint32)}
+		return nil
+	}
+}
+
+// A watcher tells the server of changes to the files it exports.
+type watcher interface {
+	// add watches name, which is a directory, for changes to its
+	// entries, or, if it is the root, may be a file. It is a no-op
+	// if name is watched already.
+	add(name string)
+}
+
+// changes counts the changes to files, by name, which the host has told
+// of, and adds them to their QID versions.
+type changes struct {
+	w watcher
+
+	// mu guards below
+	mu    sync.Mutex
+	bumps map[string]uint32
+	// all counts the times the host lost track, when anything might
+	// have changed.
+	all uint32
+}
+
+// version returns what to add to the QID version of the file name. c
+// may be nil, when it is 0.
+func (c *changes) version(name string) uint32 {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.bumps[name] + c.all
+}
+
+// watch watches name, as watcher.add does. c may be nil.
+func (c *changes) watch(name string) {
+	if c != nil && c.w != nil {
+		c.w.add(name)
+	}
+}
+
+// changed notes that name has changed on the host, or is gone, and that
+// so has the directory it is in. If name is "", anything might have.
+func (c *config) changed(name string, gone bool) {
+	c.changes.mu.Lock()
+	switch {
+	case name == "":
+		c.changes.all++
+	case gone:
+		// A file made in its place has a QID version of its own.
+		delete(c.changes.bumps, name)
+		c.changes.bumps[path.Dir(name)]++
+	default:
+		c.changes.bumps[name]++
+		c.changes.bumps[path.Dir(name)]++
+	}
+	c.changes.mu.Unlock()
+	if c.appends == nil || name == "" || gone {
+		return
+	}
+	st, err := os.Stat(name)
+	if err != nil || !st.Mode().IsRegular() {
+		return
+	}
+	if q, ok := c.lookup(st, name); ok {
+		c.appends.appended(q)
+	}
+}
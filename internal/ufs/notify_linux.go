@@ -0,0 +1,114 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ufs
+
+import (
+	"bytes"
+	"log"
+	"path"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// inotifyMask is what is watched for: anything which changes a file, or
+// the entries of a directory.
+const inotifyMask = syscall.IN_MODIFY | syscall.IN_ATTRIB | syscall.IN_CLOSE_WRITE |
+	syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO |
+	syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF
+
+// inotify watches directories, one at a time, as clients walk to them,
+// since inotify can't watch a tree.
+type inotify struct {
+	fd      int
+	changed func(name string, gone bool)
+
+	// mu guards below
+	mu    sync.Mutex
+	names map[int32]string
+	wds   map[string]int32
+	// full is set once the host will watch no more.
+	full bool
+}
+
+func newWatcher(root string, changed func(name string, gone bool)) (watcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	w := &inotify{fd: fd, changed: changed, names: make(map[int32]string), wds: make(map[string]int32)}
+	go w.read()
+	return w, nil
+}
+
+func (w *inotify) add(name string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.wds[name]; ok || w.full {
+		return
+	}
+	wd, err := syscall.InotifyAddWatch(w.fd, name, inotifyMask)
+	if err == syscall.ENOSPC {
+		// Out of watches: what is watched stays so, but
+		// changes to the rest go unseen.
+		log.Printf("notify: %v: out of inotify watches; see fs.inotify.max_user_watches", name)
+		w.full = true
+		return
+	}
+	if err != nil {
+		return
+	}
+	w.names[int32(wd)] = name
+	w.wds[name] = int32(wd)
+}
+
+// read passes on the events of the watches, for good.
+func (w *inotify) read() {
+	b := make([]byte, 64*1024)
+	for {
+		n, err := syscall.Read(w.fd, b)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			log.Printf("notify: %v", err)
+			return
+		}
+		for o := 0; o+syscall.SizeofInotifyEvent <= n; {
+			ev := (*syscall.InotifyEvent)(unsafe.Pointer(&b[o]))
+			name := b[o+syscall.SizeofInotifyEvent : o+syscall.SizeofInotifyEvent+int(ev.Len)]
+			w.event(ev.Wd, ev.Mask, string(bytes.TrimRight(name, "\x00")))
+			o += syscall.SizeofInotifyEvent + int(ev.Len)
+		}
+	}
+}
+
+// event passes on one event, of watch wd, about the entry name, if
+// there is one, or the watched file itself.
+func (w *inotify) event(wd int32, mask uint32, name string) {
+	if mask&syscall.IN_Q_OVERFLOW != 0 {
+		w.changed("", false)
+		return
+	}
+	w.mu.Lock()
+	dir, ok := w.names[wd]
+	if mask&syscall.IN_IGNORED != 0 {
+		// The watched file is gone, or unmounted.
+		delete(w.names, wd)
+		if w.wds[dir] == wd {
+			delete(w.wds, dir)
+		}
+	}
+	w.mu.Unlock()
+	if !ok || mask&syscall.IN_IGNORED != 0 {
+		return
+	}
+	gone := mask&(syscall.IN_DELETE|syscall.IN_MOVED_FROM|syscall.IN_DELETE_SELF|syscall.IN_MOVE_SELF) != 0
+	if name == "" {
+		w.changed(dir, gone)
+		return
+	}
+	w.changed(path.Join(dir, name), gone)
+}
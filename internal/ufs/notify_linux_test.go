@@ -0,0 +1,121 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ufs
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"harvey-os.org/pkg/ninep/protocol"
+)
+
+// walkQID returns the QID of name, walked to from the root.
+func walkQID(t *testing.T, c *protocol.Client, names ...string) protocol.QID {
+	t.Helper()
+	q, err := c.CallTwalk(0, 9, names)
+	if err != nil || len(q) != len(names) {
+		t.Fatalf("Walk(%v): got %v, %v", names, q, err)
+	}
+	c.CallTclunk(9)
+	if len(q) == 0 {
+		return protocol.QID{}
+	}
+	return q[len(q)-1]
+}
+
+// waitVersion waits for the QID version of name to change from old.
+func waitVersion(t *testing.T, c *protocol.Client, old protocol.QID, names ...string) {
+	t.Helper()
+	for i := 0; walkQID(t, c, names...).Version == old.Version; i++ {
+		if i == 100 {
+			t.Fatalf("QID version of %v: still %d after a change on the host", names, old.Version)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestNotify(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "notify")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+	d := path.Join(tmpdir, "d")
+	if err := os.Mkdir(d, 0755); err != nil {
+		t.Fatalf("%v", err)
+	}
+	f := path.Join(d, "f")
+	if err := ioutil.WriteFile(f, []byte("one"), 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+	// The host's times are put back after each change, so that only
+	// the notification can tell.
+	then := time.Unix(1e9, 0)
+	for _, n := range []string{d, f} {
+		if err := os.Chtimes(n, then, then); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+	c := newTestClient(t, tmpdir, Notify())
+
+	df, fq := walkQID(t, c, "d"), walkQID(t, c, "d", "f")
+	if err := ioutil.WriteFile(f, []byte("two"), 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+	os.Chtimes(f, then, then)
+	waitVersion(t, c, fq, "d", "f")
+
+	df = walkQID(t, c, "d")
+	if err := ioutil.WriteFile(path.Join(d, "g"), nil, 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+	os.Chtimes(d, then, then)
+	waitVersion(t, c, df, "d")
+}
+
+func TestNotifyAppend(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "notify")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+	log := path.Join(tmpdir, "log")
+	if err := ioutil.WriteFile(log, []byte("one\n"), 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+	// The clock never moves, so only the notification can wake the read.
+	clock := protocol.NewFakeClock(time.Now())
+	c := newTestClient(t, tmpdir, Notify(), AppendOnly(), Clock(clock))
+	r, err := c.Open(0, []string{"log"}, protocol.OREAD)
+	if err != nil {
+		t.Fatalf("Open(log): want nil, got %v", err)
+	}
+	got := make(chan string)
+	go func() {
+		b, err := c.CallTread(r.FID(), 4, 100)
+		if err != nil {
+			t.Errorf("CallTread(4): want nil, got %v", err)
+		}
+		got <- string(b)
+	}()
+	clock.Wait(1)
+	w, err := os.OpenFile(log, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	w.Write([]byte("two\n"))
+	w.Close()
+	select {
+	case s := <-got:
+		if s != "two\n" {
+			t.Errorf("CallTread(4): got %q, want two", s)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("CallTread(4): not woken by a write on the host")
+	}
+}
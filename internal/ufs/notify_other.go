@@ -0,0 +1,16 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !linux,!windows
+
+package ufs
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func newWatcher(root string, changed func(name string, gone bool)) (watcher, error) {
+	return nil, fmt.Errorf("Notify: not supported on %v", runtime.GOOS)
+}
@@ -0,0 +1,76 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ufs
+
+import (
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+// changeFilter is what is watched for: anything which changes a file, or
+// the entries of a directory.
+const changeFilter = syscall.FILE_NOTIFY_CHANGE_FILE_NAME | syscall.FILE_NOTIFY_CHANGE_DIR_NAME |
+	syscall.FILE_NOTIFY_CHANGE_ATTRIBUTES | syscall.FILE_NOTIFY_CHANGE_SIZE |
+	syscall.FILE_NOTIFY_CHANGE_LAST_WRITE
+
+// dirChanges watches the whole tree under the root at once, as Windows
+// can, so there is nothing to add as clients walk.
+type dirChanges struct{}
+
+func newWatcher(root string, changed func(name string, gone bool)) (watcher, error) {
+	// A root which is a single file is watched through its directory.
+	dir, only, tree := root, "", true
+	if st, err := os.Stat(root); err == nil && !st.IsDir() {
+		dir, only, tree = filepath.Dir(root), filepath.Base(root), false
+	}
+	p, err := syscall.UTF16PtrFromString(dir)
+	if err != nil {
+		return nil, err
+	}
+	h, err := syscall.CreateFile(p, syscall.FILE_LIST_DIRECTORY,
+		syscall.FILE_SHARE_READ|syscall.FILE_SHARE_WRITE|syscall.FILE_SHARE_DELETE,
+		nil, syscall.OPEN_EXISTING, syscall.FILE_FLAG_BACKUP_SEMANTICS, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "watch", Path: dir, Err: err}
+	}
+	go func() {
+		b := make([]byte, 64*1024)
+		for {
+			var n uint32
+			if err := syscall.ReadDirectoryChanges(h, &b[0], uint32(len(b)), tree, changeFilter, &n, nil, 0); err != nil {
+				log.Printf("notify: %v", err)
+				return
+			}
+			if n == 0 {
+				// Too much changed to say what.
+				changed("", false)
+				continue
+			}
+			for o := uint32(0); ; {
+				ev := (*syscall.FileNotifyInformation)(unsafe.Pointer(&b[o]))
+				l := ev.FileNameLength / 2
+				name := syscall.UTF16ToString((*[1 << 15]uint16)(unsafe.Pointer(&ev.FileName))[:l:l])
+				gone := ev.Action == syscall.FILE_ACTION_REMOVED || ev.Action == syscall.FILE_ACTION_RENAMED_OLD_NAME
+				switch {
+				case tree:
+					changed(path.Join(root, filepath.ToSlash(name)), gone)
+				case name == only:
+					changed(root, gone)
+				}
+				if ev.NextEntryOffset == 0 {
+					break
+				}
+				o += ev.NextEntryOffset
+			}
+		}
+	}()
+	return dirChanges{}, nil
+}
+
+func (dirChanges) add(name string) {}
@@ -0,0 +1,356 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ufs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"harvey-os.org/pkg/ninep"
+	"harvey-os.org/pkg/ninep/protocol"
+)
+
+// config holds the settings shared by the FileServer of every
+// connection. It is set up once, by NewServer, from its Opts.
+type config struct {
+	// qids is shared by all connections, so that a file has the
+	// same QID no matter who asks.
+	qids *ninep.QIDPool
+
+	// qidFile, if set, is where qids is kept across restarts, and
+	// journal is it, open for this run.
+	qidFile string
+	journal *os.File
+
+	// createPerm, if set, decides the permissions of created files.
+	createPerm PermPolicy
+
+	// users, if set, names file owners and decides who is in which group.
+	users ninep.UserDB
+
+	// muidXattr is set if the last modifier is kept in an xattr.
+	muidXattr bool
+
+	// atime, if set, decides whether reads update access times.
+	atime ninep.AtimePolicy
+
+	// peerAuth is set if clients on Unix sockets must attach as the
+	// user they run as.
+	peerAuth bool
+
+	// readOnly is 1 if clients may not change anything. It is
+	// changed with a Control, so is read atomically.
+	readOnly int32
+
+	// janitor, if set, removes temporary files left behind.
+	janitor *janitor
+
+	// clock is the time, for access times, Twstats and the janitor.
+	clock protocol.Clock
+
+	// budgets, if set, limits how long operations on host files take.
+	budgets *ninep.Budgets
+
+	// appends, if set, makes files append-only, and wakes the reads
+	// waiting at their ends.
+	appends *appends
+
+	// changes, if set, counts the changes made to files on the host.
+	changes *changes
+
+	// usage counts what each user changes, and warns if they go over
+	// a SoftQuota.
+	usage *usage
+}
+
+// errReadOnly is the error for changes refused by a read-only server.
+var errReadOnly = protocol.ErrReadOnly
+
+// writable returns errReadOnly if the server is read-only.
+func (c *config) writable() error {
+	if atomic.LoadInt32(&c.readOnly) != 0 {
+		return errReadOnly
+	}
+	return nil
+}
+
+// Opt is an option for NewServer.
+type Opt func(*config) error
+
+// QIDFile makes the server keep its QID assignments in the named file,
+// so that QIDs survive a restart. Kernel clients cache inode numbers
+// derived from QIDs, and get very confused if they change under open
+// files.
+func QIDFile(name string) Opt {
+	return func(c *config) error {
+		c.qidFile = name
+		return nil
+	}
+}
+
+// Users sets the user database. With one, files are reported as owned by
+// the names it gives their owner and group, and the attaching user's
+// permissions are checked on open and create, as well as the server's.
+// Without one, every file belongs to the -user flag, and only the
+// server's permissions matter.
+func Users(db ninep.UserDB) Opt {
+	return func(c *config) error {
+		c.users = db
+		return nil
+	}
+}
+
+// MuidXattr keeps the user who last modified each file in an extended
+// attribute, so that Dir.ModUser means something, and survives a restart.
+// Files modified by other means keep the last 9P user. Where there are no
+// extended attributes, or without this option, ModUser is the owner.
+func MuidXattr() Opt {
+	return func(c *config) error {
+		c.muidXattr = true
+		return nil
+	}
+}
+
+// Atime sets the policy for updating access times when clients read
+// files, which is then the same whatever the host's mount options. It is
+// applied when a file which has been read is clunked. By default, access times are left to
+// the host.
+func Atime(p ninep.AtimePolicy) Opt {
+	return func(c *config) error {
+		c.atime = p
+		return nil
+	}
+}
+
+// PeerAuth makes clients which connect over a Unix socket attach as the
+// user their process runs as, named by the user database, or the
+// system's if there is none, so that local clients need no other
+// authentication. An attach as anyone else is refused, except from root,
+// and an empty user name is the process's user. Clients on other
+// transports, or where the system can't say who they are, attach as
+// they like.
+func PeerAuth() Opt {
+	return func(c *config) error {
+		c.peerAuth = true
+		return nil
+	}
+}
+
+// ReadOnly makes the server refuse to change anything: files can't be
+// opened for writing, created, removed or changed.
+func ReadOnly() Opt {
+	return func(c *config) error {
+		c.readOnly = 1
+		return nil
+	}
+}
+
+// Janitor makes the server remove temporary files which have been left
+// behind, once they have not been changed for age and nobody has them
+// open. Temporary files are those whose names match one of patterns, as
+// for filepath.Match, and those opened ORCLOSE, which are otherwise
+// removed when clunked, or when the client goes away. The server looks
+// for them when it starts, for what a crash left, and every age after.
+// Where there are no extended attributes, only names mark files opened
+// ORCLOSE before a crash.
+func Janitor(age time.Duration, patterns ...string) Opt {
+	return func(c *config) error {
+		if age <= 0 {
+			return fmt.Errorf("Janitor: age %v is not positive", age)
+		}
+		for _, p := range patterns {
+			if _, err := filepath.Match(p, ""); err != nil {
+				return fmt.Errorf("Janitor: %q: %v", p, err)
+			}
+		}
+		c.janitor = &janitor{age: age, patterns: patterns, open: make(map[string]int)}
+		return nil
+	}
+}
+
+// Clock sets the clock the server tells the time by, for access times,
+// times set to now, and the Janitor. By default, it is
+// protocol.SystemClock.
+func Clock(clock protocol.Clock) Opt {
+	return func(c *config) error {
+		c.clock = clock
+		return nil
+	}
+}
+
+// A Control changes the settings of a running server.
+type Control struct {
+	c *config
+}
+
+// Controlled makes ctl control the server, for programs which change
+// its settings as it runs.
+func Controlled(ctl *Control) Opt {
+	return func(c *config) error {
+		ctl.c = c
+		return nil
+	}
+}
+
+// SetReadOnly makes the server read-only, as with the ReadOnly option,
+// or not. Files already open for writing can't be written to either.
+func (ctl *Control) SetReadOnly(ro bool) {
+	var v int32
+	if ro {
+		v = 1
+	}
+	atomic.StoreInt32(&ctl.c.readOnly, v)
+}
+
+// ReadOnly reports whether the server is read-only.
+func (ctl *Control) ReadOnly() bool {
+	return ctl.c.writable() != nil
+}
+
+// Sweep removes the temporary files the Janitor would, now, and returns
+// how many it removed. It is an error if there is no Janitor.
+func (ctl *Control) Sweep() (int, error) {
+	return ctl.c.sweep()
+}
+
+// A PermPolicy returns the permissions to give a file or directory a
+// client creates, given the permissions the client asked for and those
+// of the directory it is created in.
+type PermPolicy func(perm, parent os.FileMode, dir bool) os.FileMode
+
+// CreatePerm sets the policy for the permissions of created files. By
+// default, they are what the client asks for, less the server's umask.
+func CreatePerm(p PermPolicy) Opt {
+	return func(c *config) error {
+		if c.createPerm != nil {
+			return fmt.Errorf("CreatePerm: policy already set")
+		}
+		c.createPerm = p
+		return nil
+	}
+}
+
+// Umask is a PermPolicy which honors the client's permissions, less
+// umask. It replaces the process umask, rather than adding to it.
+func Umask(umask os.FileMode) PermPolicy {
+	return func(perm, parent os.FileMode, dir bool) os.FileMode {
+		return perm &^ umask
+	}
+}
+
+// ForcePerm is a PermPolicy which ignores the client's permissions:
+// files get file, and directories dir.
+func ForcePerm(file, dir os.FileMode) PermPolicy {
+	return func(perm, parent os.FileMode, isDir bool) os.FileMode {
+		if isDir {
+			return dir
+		}
+		return file
+	}
+}
+
+// InheritPerm is a PermPolicy which limits the client's permissions to
+// those of the parent directory, as Plan 9 does: files get at most the
+// parent's read and write bits, and directories all of its bits.
+func InheritPerm(perm, parent os.FileMode, dir bool) os.FileMode {
+	if dir {
+		return perm & (^os.FileMode(0777) | parent&0777)
+	}
+	return perm & (^os.FileMode(0666) | parent&0666)
+}
+
+// setup finishes the config once all the Opts have been applied.
+func (c *config) setup() error {
+	if c.clock == nil {
+		c.clock = protocol.SystemClock
+	}
+	if c.usage == nil {
+		c.usage = &usage{users: make(map[string]*Usage)}
+	}
+	if c.qidFile == "" {
+		var err error
+		c.qids, err = ninep.NewQIDPool()
+		return err
+	}
+	return c.loadQIDs()
+}
+
+// loadQIDs reads the QID file, if there is one, and writes it back out
+// compacted, since the journal only ever grows. The new file is then
+// used as the journal for this run.
+func (c *config) loadQIDs() error {
+	p, err := ninep.NewQIDPool()
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(c.qidFile)
+	switch {
+	case err == nil:
+		err = p.Load(f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	case !os.IsNotExist(err):
+		return err
+	}
+
+	t, err := ioutil.TempFile(filepath.Dir(c.qidFile), filepath.Base(c.qidFile))
+	if err != nil {
+		return err
+	}
+	// The compacted file must be on disk before it replaces the
+	// journal, or a crash could leave an empty one.
+	if err := p.Save(t); err != nil {
+		t.Close()
+		os.Remove(t.Name())
+		return err
+	}
+	if err := t.Sync(); err != nil {
+		t.Close()
+		os.Remove(t.Name())
+		return err
+	}
+	if err := t.Close(); err != nil {
+		os.Remove(t.Name())
+		return err
+	}
+	if err := os.Rename(t.Name(), c.qidFile); err != nil {
+		os.Remove(t.Name())
+		return err
+	}
+	// So must the rename. Not every system can sync a directory, so
+	// this is done if it can be.
+	if d, err := os.Open(filepath.Dir(c.qidFile)); err == nil {
+		d.Sync()
+		d.Close()
+	}
+
+	// Reads start at the beginning, writes go to the end.
+	j, err := os.OpenFile(c.qidFile, os.O_RDWR|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+	if c.qids, err = ninep.NewQIDPool(ninep.QIDJournal(j)); err != nil {
+		j.Close()
+		return err
+	}
+	c.journal = j
+	return c.qids.Load(j)
+}
+
+// close lets go of what the server holds open for all its connections.
+func (c *config) close() error {
+	if c.journal == nil {
+		return nil
+	}
+	err := c.journal.Close()
+	c.journal = nil
+	return err
+}
@@ -0,0 +1,126 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ufs
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A Usage is what the clients which attached as one user have changed,
+// since the server started.
+type Usage struct {
+	// Written counts the bytes written, by Twrite and Tcopy.
+	Written uint64
+	// Created counts the files and directories created.
+	Created uint64
+	// Over is set once Written or Created is over the SoftQuota.
+	Over bool `json:",omitempty"`
+}
+
+// A Quota limits a Usage. A limit of 0 is none.
+type Quota struct {
+	Written uint64
+	Created uint64
+}
+
+// SoftQuota warns when the clients which attach as a user write more
+// than q.Written bytes, or create more than q.Created files, so that
+// those who run a shared server know before the disk fills, or a hard
+// quota on the host stops them. Nothing is refused. The warning is a
+// line appended to status, a file of the tree the server exports, named
+// from its root, which the clients can read, such as "QUOTA", and which
+// the server creates if need be; with no status, it is only logged. It
+// is given once for each user, the first time they go over.
+func SoftQuota(q Quota, status string) Opt {
+	return func(c *config) error {
+		if status != "" {
+			name := strings.TrimPrefix(path.Clean("/"+status), "/")
+			if name == "" {
+				return fmt.Errorf("SoftQuota: status %q is the root", status)
+			}
+			status = name
+		}
+		c.usage = &usage{quota: q, status: status, users: make(map[string]*Usage)}
+		return nil
+	}
+}
+
+// usage counts what each user has changed, and warns when they go over
+// the soft quota.
+type usage struct {
+	quota Quota
+	// status is the file warnings are appended to, named from root,
+	// or "" if there is none.
+	root, status string
+
+	// mu guards below
+	mu    sync.Mutex
+	users map[string]*Usage
+}
+
+// add adds the bytes written, and files created, to the usage of user.
+func (c *config) add(user string, written, created uint64) {
+	u := c.usage
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	n, ok := u.users[user]
+	if !ok {
+		n = &Usage{}
+		u.users[user] = n
+	}
+	n.Written += written
+	n.Created += created
+	if n.Over || !u.over(n) {
+		return
+	}
+	n.Over = true
+	c.warn(fmt.Sprintf("%v %v: %d bytes written of %d, %d files created of %d: over the soft quota",
+		c.clock.Now().UTC().Format(time.RFC3339), user, n.Written, u.quota.Written, n.Created, u.quota.Created))
+}
+
+// over reports whether n is over the quota.
+func (u *usage) over(n *Usage) bool {
+	return u.quota.Written != 0 && n.Written > u.quota.Written || u.quota.Created != 0 && n.Created > u.quota.Created
+}
+
+// warn appends the warning w to the status file, or logs it if there is
+// none, or it can't be written. u.mu is held.
+func (c *config) warn(w string) {
+	u := c.usage
+	if u.status == "" {
+		log.Printf("ufs: %v", w)
+		return
+	}
+	name := path.Join(u.root, u.status)
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err == nil {
+		_, err = f.WriteString(w + "\n")
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		log.Printf("ufs: %v: %v", w, err)
+	}
+}
+
+// Usage returns what each user who has changed anything has changed, by
+// the names they attached as.
+func (ctl *Control) Usage() map[string]Usage {
+	u := ctl.c.usage
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	m := make(map[string]Usage, len(u.users))
+	for user, n := range u.users {
+		m[user] = *n
+	}
+	return m
+}
@@ -0,0 +1,23 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build linux
+
+package ufs
+
+import "syscall"
+
+// rcloseAttr is the extended attribute marking a file opened ORCLOSE,
+// so that the janitor knows to remove it if the server crashes before
+// the fid is clunked.
+const rcloseAttr = "user.9p.rclose"
+
+func setRclose(name string) error {
+	return syscall.Setxattr(name, rcloseAttr, []byte{1}, 0)
+}
+
+func hasRclose(name string) bool {
+	_, err := syscall.Getxattr(name, rcloseAttr, nil)
+	return err == nil
+}
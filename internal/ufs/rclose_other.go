@@ -0,0 +1,20 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !linux
+
+package ufs
+
+import "fmt"
+
+// Without extended attributes, files opened ORCLOSE can't be marked, so
+// those left by a crash are only swept if their names are temporary.
+
+func setRclose(name string) error {
+	return fmt.Errorf("extended attributes not supported")
+}
+
+func hasRclose(name string) bool {
+	return false
+}
@@ -0,0 +1,30 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ninep
+
+import "time"
+
+// An AtimePolicy decides whether a read of a file at now updates its
+// access time, given the file's access and modification times. The
+// policies are named for the Linux mount options they follow.
+type AtimePolicy func(atime, mtime, now time.Time) bool
+
+// StrictAtime updates the access time on every read.
+func StrictAtime(atime, mtime, now time.Time) bool {
+	return true
+}
+
+// NoAtime never updates the access time, so that reading a file, to
+// copy or back it up, leaves it exactly as it was.
+func NoAtime(atime, mtime, now time.Time) bool {
+	return false
+}
+
+// RelAtime updates the access time if it is no later than the
+// modification time, or is a day old, which is enough for programs that
+// want to know if a file was read since it was written.
+func RelAtime(atime, mtime, now time.Time) bool {
+	return !atime.After(mtime) || now.Sub(atime) >= 24*time.Hour
+}
@@ -0,0 +1,27 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ninep
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRelAtime(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	for _, tt := range []struct {
+		atime, mtime time.Time
+		want         bool
+	}{
+		{now.Add(-time.Hour), now.Add(-2 * time.Hour), false},
+		{now.Add(-time.Hour), now.Add(-time.Minute), true},
+		{now.Add(-time.Hour), now.Add(-time.Hour), true},
+		{now.Add(-25 * time.Hour), now.Add(-48 * time.Hour), true},
+	} {
+		if got := RelAtime(tt.atime, tt.mtime, now); got != tt.want {
+			t.Errorf("RelAtime(%v, %v, %v): got %v, want %v", tt.atime, tt.mtime, now, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ninep
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"harvey-os.org/pkg/ninep/protocol"
+)
+
+// DefaultMaxLate is the default Budgets.MaxLate.
+const DefaultMaxLate = 64
+
+// ErrOverBudget is the error of an operation which took longer than its
+// budget, or was refused since too many before it had.
+var ErrOverBudget = errors.New("over budget")
+
+// Budgets limit how long the operations of a file server may take, so
+// that one stuck on its storage, such as a hung NFS mount, fails with an
+// error rather than holding up the connection for good. A server calls
+// Run, or RunLate, with the work of each operation. One way is to embed
+// the file server in a type which overrides the methods to limit, so
+// that the rest, and the interfaces they make up, stay as they are.
+//
+// An operation over its budget can't be stopped: it carries on in the
+// background, and what it does still happens, but the client is told it
+// failed. Only MaxLate may be carrying on at once; after that, every
+// operation with a budget fails at once, until some finish.
+type Budgets struct {
+	// Default is the budget of the message types not in ByType. 0 is
+	// no budget.
+	Default time.Duration
+
+	// ByType are the budgets of message types, such as protocol.Tread.
+	// 0 is no budget.
+	ByType map[protocol.MType]time.Duration
+
+	// MaxLate is how many operations may carry on past their budget at
+	// once. If 0, it is DefaultMaxLate.
+	MaxLate int
+
+	// Clock times the budgets. If nil, it is protocol.SystemClock.
+	Clock protocol.Clock
+
+	// mu guards below
+	mu       sync.Mutex
+	overruns map[protocol.MType]uint64
+	refused  uint64
+	late     int
+}
+
+// BudgetStats are the totals of a Budgets.
+type BudgetStats struct {
+	// Overruns counts the operations which ran over budget, by the
+	// name of their message type, such as Tread.
+	Overruns map[string]uint64
+	// Refused counts the operations which failed since MaxLate were
+	// carrying on.
+	Refused uint64
+	// Late is how many are carrying on now.
+	Late int
+}
+
+// Budget returns the budget for messages of type t, or 0 if there is
+// none. b may be nil, which sets no budgets.
+func (b *Budgets) Budget(t protocol.MType) time.Duration {
+	if b == nil {
+		return 0
+	}
+	if d, ok := b.ByType[t]; ok {
+		return d
+	}
+	return b.Default
+}
+
+// Run calls f, the work of an operation for a message of type t, and
+// returns its error, or, if f takes longer than the budget, an error
+// wrapping ErrOverBudget. f runs in a goroutine of its own if there is a
+// budget, and, once Run has given up on it, must not use anything its
+// caller might change or read, such as the data of a Twrite.
+func (b *Budgets) Run(t protocol.MType, f func() error) error {
+	return b.RunLate(t, f, nil, nil)
+}
+
+// RunLate is like Run, but if f goes over its budget, late, if not nil,
+// is called before RunLate returns, and done, if not nil, once f returns,
+// with its error. Between them, the server can keep what f uses, such as
+// its fid, from being used, and done can put back what f did that the
+// client was told didn't happen, such as a fid made by a Twalk.
+func (b *Budgets) RunLate(t protocol.MType, f func() error, late func(), done func(error)) error {
+	d := b.Budget(t)
+	if d <= 0 {
+		return f()
+	}
+	max := b.MaxLate
+	if max == 0 {
+		max = DefaultMaxLate
+	}
+	b.mu.Lock()
+	if b.late >= max {
+		b.refused++
+		b.mu.Unlock()
+		return fmt.Errorf("%v: %w: %d operations are still running late", protocol.RPCNames[t], ErrOverBudget, max)
+	}
+	b.mu.Unlock()
+
+	finished := make(chan error, 1)
+	go func() {
+		finished <- f()
+	}()
+	clock := b.Clock
+	if clock == nil {
+		clock = protocol.SystemClock
+	}
+	timeout := make(chan struct{})
+	timer := clock.AfterFunc(d, func() { close(timeout) })
+	select {
+	case err := <-finished:
+		timer.Stop()
+		return err
+	case <-timeout:
+	}
+
+	b.mu.Lock()
+	if b.overruns == nil {
+		b.overruns = make(map[protocol.MType]uint64)
+	}
+	b.overruns[t]++
+	b.late++
+	b.mu.Unlock()
+	if late != nil {
+		late()
+	}
+	go func() {
+		err := <-finished
+		if done != nil {
+			done(err)
+		}
+		b.mu.Lock()
+		b.late--
+		b.mu.Unlock()
+	}()
+	return fmt.Errorf("%v: %w of %v", protocol.RPCNames[t], ErrOverBudget, d)
+}
+
+// Stats returns the totals of b.
+func (b *Budgets) Stats() BudgetStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	st := BudgetStats{Overruns: make(map[string]uint64), Refused: b.refused, Late: b.late}
+	for t, n := range b.overruns {
+		st.Overruns[protocol.RPCNames[t]] = n
+	}
+	return st
+}
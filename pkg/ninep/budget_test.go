@@ -0,0 +1,96 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ninep
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"harvey-os.org/pkg/ninep/protocol"
+)
+
+// late runs f, which must go over budget, in Run, and returns its
+// error, once the clock has been moved on past the budget.
+func late(b *Budgets, clock *protocol.FakeClock, t protocol.MType, f func() error, done func(error)) error {
+	got := make(chan error)
+	go func() {
+		got <- b.RunLate(t, f, nil, done)
+	}()
+	clock.Wait(1)
+	clock.Advance(time.Minute)
+	return <-got
+}
+
+// waitLate waits until b has n operations running late.
+func waitLate(t *testing.T, b *Budgets, n int) {
+	for i := 0; b.Stats().Late != n; i++ {
+		if i == 100 {
+			t.Fatalf("Late: got %d, want %d", b.Stats().Late, n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestBudgets(t *testing.T) {
+	clock := protocol.NewFakeClock(time.Unix(0, 0))
+	b := &Budgets{
+		ByType:  map[protocol.MType]time.Duration{protocol.Tread: time.Second, protocol.Tstat: 0},
+		Default: time.Hour,
+		MaxLate: 1,
+		Clock:   clock,
+	}
+	if d := b.Budget(protocol.Twalk); d != time.Hour {
+		t.Errorf("Budget(Twalk): got %v, want the default, 1h", d)
+	}
+	var none *Budgets
+	if err := none.Run(protocol.Tread, func() error { return nil }); err != nil {
+		t.Errorf("Run with nil Budgets: want nil, got %v", err)
+	}
+
+	// Operations within budget, or with none, return their own errors.
+	want := errors.New("no such file")
+	if err := b.Run(protocol.Tread, func() error { return want }); err != want {
+		t.Errorf("Run(Tread) within budget: want %v, got %v", want, err)
+	}
+	if err := b.Run(protocol.Tstat, func() error { return nil }); err != nil {
+		t.Errorf("Run(Tstat) with no budget: want nil, got %v", err)
+	}
+
+	// One over budget fails, and carries on; when it is done, the
+	// server is told how it went.
+	release := make(chan bool)
+	done := make(chan error, 1)
+	err := late(b, clock, protocol.Tread, func() error {
+		<-release
+		return want
+	}, func(err error) { done <- err })
+	if !errors.Is(err, ErrOverBudget) {
+		t.Fatalf("Run(Tread) over budget: want ErrOverBudget, got %v", err)
+	}
+	if st := b.Stats(); st.Overruns["Tread"] != 1 || st.Late != 1 {
+		t.Errorf("Stats: got %+v, want 1 Tread overrun, 1 late", st)
+	}
+
+	// With MaxLate carrying on, the rest fail at once.
+	if err := b.Run(protocol.Twalk, func() error { return nil }); !errors.Is(err, ErrOverBudget) {
+		t.Errorf("Run(Twalk) with MaxLate late: want ErrOverBudget, got %v", err)
+	}
+	if err := b.Run(protocol.Tstat, func() error { return nil }); err != nil {
+		t.Errorf("Run(Tstat), with no budget, with MaxLate late: want nil, got %v", err)
+	}
+	if st := b.Stats(); st.Refused != 1 {
+		t.Errorf("Stats: got %d refused, want 1", st.Refused)
+	}
+
+	release <- true
+	if err := <-done; err != want {
+		t.Errorf("late Tread done: want %v, got %v", want, err)
+	}
+	waitLate(t, b, 0)
+	if err := b.Run(protocol.Twalk, func() error { return nil }); err != nil {
+		t.Errorf("Run(Twalk) once none are late: want nil, got %v", err)
+	}
+}
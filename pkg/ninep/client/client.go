@@ -0,0 +1,504 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package client is the stable way to use a 9P server: a Session names
+// files by path, and hands back Files and Dirs, which are opened files
+// and directories. Fids, tags and the like are its business.
+//
+// protocol.Client, which it is built on, is the protocol itself: its
+// fields and Call methods may change as the package does. Programs which
+// only want files should use this package instead.
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"harvey-os.org/pkg/ninep/protocol"
+)
+
+// A Session is an attach to a server: a root, from which its names are
+// paths, slash-separated; "" and "/" are the root. Sessions from New
+// and Dial have connections of their own, and Attach makes more over
+// the same one, for other trees or users. A Session may be used by
+// several goroutines.
+type Session interface {
+	// Open opens the file name in mode.
+	Open(name string, mode protocol.Mode) (File, error)
+	// Create creates the file name with perm, opened in mode.
+	Create(name string, perm protocol.Perm, mode protocol.Mode) (File, error)
+	// OpenDir opens the directory name, to read.
+	OpenDir(name string) (Dir, error)
+	// Stat returns the Dir describing the file name.
+	Stat(name string) (protocol.Dir, error)
+	// Wstat changes the file name as d says. Fields of d which are
+	// not to change must be "don't touch" values, as NoChange has.
+	Wstat(name string, d protocol.Dir) error
+	// Remove removes the file name.
+	Remove(name string) error
+
+	// Attach attaches to the tree aname as user, over the session's
+	// connection, authenticating as the session did.
+	Attach(user, aname string) (Session, error)
+
+	// Msize is the largest message the session sends or receives.
+	Msize() uint32
+	// Extensions are the protocol extensions the server agreed to.
+	Extensions() []string
+
+	// Close ends the session, and closes its connection if no other
+	// Session uses it. Files and Dirs from it can't be used after.
+	Close() error
+}
+
+// A Session whose connection is lost makes a new one, if it knows how,
+// and attaches all the connection's Sessions again, before its next
+// request. The request which found
+// the connection gone fails, as do Files and Dirs opened before, since
+// their fids went with it.
+
+// A File is an open file. Reads and writes without offsets carry on from
+// the last, and go as far as they can, as ClientFile's do.
+type File interface {
+	io.Reader
+	io.ReaderAt
+	io.Writer
+	io.WriterAt
+	io.Seeker
+	io.Closer
+
+	// Stat returns the Dir describing the file.
+	Stat() (protocol.Dir, error)
+	// Truncate sets the length of the file.
+	Truncate(size int64) error
+}
+
+// A Dir is an open directory.
+type Dir interface {
+	// ReadDir returns the next n entries, or at most n if the
+	// directory ends first, with io.EOF if there are none. If n <= 0,
+	// it returns the rest, and a nil error if there are none.
+	ReadDir(n int) ([]protocol.Dir, error)
+	// Stat returns the Dir describing the directory.
+	Stat() (protocol.Dir, error)
+	io.Closer
+}
+
+// NoChange returns a Dir which changes nothing in a Wstat, to have only
+// some of its fields set.
+func NoChange() protocol.Dir {
+	d := protocol.Dir{Type: ^uint16(0), Dev: ^uint32(0), Mode: ^uint32(0), Atime: ^uint32(0), Mtime: ^uint32(0), Length: ^uint64(0)}
+	d.QID = protocol.QID{Type: ^uint8(0), Version: ^uint32(0), Path: ^uint64(0)}
+	return d
+}
+
+type config struct {
+	msize   uint32
+	exts    []string
+	codec   protocol.Codec
+	auth    protocol.Authenticator
+	redial  func() (io.ReadWriteCloser, error)
+	timeout time.Duration
+	clock   protocol.Clock
+	tune    time.Duration
+
+	checkOwners bool
+	owners      func(error)
+}
+
+// Opt is an option for New and Dial.
+type Opt func(*config) error
+
+// Msize sets the largest message, which is 8192 bytes by default. The
+// server may choose a smaller one: servers of package protocol agree to
+// no more than their Listener's MaxMsize. Bulk transfers over fast
+// networks go faster with a megabyte or more.
+func Msize(n uint32) Opt {
+	return func(c *config) error {
+		if n <= protocol.IOHDRSZ {
+			return fmt.Errorf("msize %d is too small", n)
+		}
+		c.msize = n
+		return nil
+	}
+}
+
+// autoSizes are the msizes AutoMsize tries, along with the one agreed.
+var autoSizes = []uint32{
+	64<<10 + protocol.IOHDRSZ,
+	256<<10 + protocol.IOHDRSZ,
+	1<<20 + protocol.IOHDRSZ,
+	4<<20 + protocol.IOHDRSZ,
+}
+
+// AutoMsize has the session find the msize which moves data fastest,
+// since the best depends on the network, and is rarely known. It asks
+// for protocol.MaxMsize, or as much as a later Msize says, and then
+// takes turns at the sizes from 64KiB up to what the server agrees to,
+// timing the messages of its first big reads and writes, for up to
+// window, before it settles on the fastest. See
+// protocol.Client.TuneMsize.
+func AutoMsize(window time.Duration) Opt {
+	return func(c *config) error {
+		if window <= 0 {
+			return fmt.Errorf("AutoMsize window %v is not positive", window)
+		}
+		c.msize = protocol.MaxMsize
+		c.tune = window
+		return nil
+	}
+}
+
+// Extensions asks the server for protocol extensions, such as
+// protocol.CopyExtension.
+func Extensions(names ...string) Opt {
+	return func(c *config) error {
+		c.exts = append(c.exts, names...)
+		return nil
+	}
+}
+
+// Codec puts messages on the wire with codec, e.g. protocol.SerialCodec.
+func Codec(codec protocol.Codec) Opt {
+	return func(c *config) error {
+		c.codec = codec
+		return nil
+	}
+}
+
+// Auth has the session authenticate with auth, if the server wants it.
+func Auth(auth protocol.Authenticator) Opt {
+	return func(c *config) error {
+		c.auth = auth
+		return nil
+	}
+}
+
+// Redial has the session call dial for a new connection when it loses
+// its connection. Sessions from Dial redial by default.
+func Redial(dial func() (io.ReadWriteCloser, error)) Opt {
+	return func(c *config) error {
+		c.redial = dial
+		return nil
+	}
+}
+
+// CheckOwners has the session check that each File is used by one
+// goroutine at a time, for debugging: see protocol.Client.CheckOwners.
+// A goroutine which hands a File to another must first call its
+// Release method, which Files of sessions have:
+//
+//	f.(interface{ Release() }).Release()
+//
+// Misuse is reported to report, or panics if report is nil.
+func CheckOwners(report func(error)) Opt {
+	return func(c *config) error {
+		c.checkOwners = true
+		c.owners = report
+		return nil
+	}
+}
+
+// Dial connects to the server at addr on network, and attaches to its
+// tree aname as user. It dials again if the connection is lost.
+func Dial(network, addr, user, aname string, opts ...Opt) (Session, error) {
+	dial := func() (io.ReadWriteCloser, error) {
+		return net.Dial(network, addr)
+	}
+	conn, err := dial()
+	if err != nil {
+		return nil, err
+	}
+	s, err := New(conn, user, aname, append([]Opt{Redial(dial)}, opts...)...)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// New attaches to the tree aname of the server on conn, as user.
+func New(conn io.ReadWriteCloser, user, aname string, opts ...Opt) (Session, error) {
+	cfg := &config{msize: 8192, timeout: DefaultTimeout}
+	for _, o := range opts {
+		if err := o(cfg); err != nil {
+			return nil, err
+		}
+	}
+	n := &connection{cfg: cfg, rwc: conn, roots: map[*session]bool{}}
+	var root protocol.FID
+	c, err := n.start(conn, func(c *protocol.Client) error {
+		var err error
+		root, _, err = c.Attach(user, aname, cfg.auth)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	n.c = c
+	s := &session{conn: n, user: user, aname: aname, root: root}
+	n.roots[s] = true
+	return s, nil
+}
+
+// A connection is what the Sessions over one connection share.
+type connection struct {
+	cfg *config
+
+	// mu guards below, and the roots of the sessions, all of which
+	// change when the connection is remade.
+	mu    sync.Mutex
+	c     *protocol.Client
+	rwc   io.Closer
+	roots map[*session]bool
+}
+
+// A session is one root of a connection.
+type session struct {
+	conn        *connection
+	user, aname string
+
+	root   protocol.FID
+	closed bool
+}
+
+// live returns the client, first making a new connection if the old one
+// is lost and the session can redial. n.mu must be held.
+func (n *connection) live() (*protocol.Client, error) {
+	if !n.c.IsDead() || n.cfg.redial == nil {
+		return n.c, nil
+	}
+	rwc, err := n.cfg.redial()
+	if err != nil {
+		return nil, fmt.Errorf("reconnecting: %v", err)
+	}
+	roots := map[*session]protocol.FID{}
+	c, err := n.start(rwc, func(c *protocol.Client) error {
+		for s := range n.roots {
+			root, _, err := c.Attach(s.user, s.aname, n.cfg.auth)
+			if err != nil {
+				return fmt.Errorf("attaching %q as %v: %v", s.aname, s.user, err)
+			}
+			roots[s] = root
+		}
+		return nil
+	})
+	if err != nil {
+		rwc.Close()
+		return nil, fmt.Errorf("reconnecting: %v", err)
+	}
+	for s, root := range roots {
+		s.root = root
+	}
+	n.rwc.Close()
+	n.c, n.rwc = c, rwc
+	return c, nil
+}
+
+// client returns the client and root for a request.
+func (s *session) client() (*protocol.Client, protocol.FID, error) {
+	n := s.conn
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if s.closed {
+		return nil, 0, fmt.Errorf("session closed")
+	}
+	c, err := n.live()
+	if err != nil {
+		return nil, 0, err
+	}
+	return c, s.root, nil
+}
+
+// names splits name into the names to walk to it.
+func names(name string) []string {
+	name = strings.Trim(path.Clean("/"+name), "/")
+	if name == "" {
+		return nil
+	}
+	return strings.Split(name, "/")
+}
+
+func (s *session) Open(name string, mode protocol.Mode) (File, error) {
+	c, root, err := s.client()
+	if err != nil {
+		return nil, err
+	}
+	f, err := c.Open(root, names(name), mode)
+	if err != nil {
+		return nil, err
+	}
+	return &file{ClientFile: f, c: c}, nil
+}
+
+func (s *session) Create(name string, perm protocol.Perm, mode protocol.Mode) (File, error) {
+	c, root, err := s.client()
+	if err != nil {
+		return nil, err
+	}
+	f, err := c.Create(root, names(name), perm, mode)
+	if err != nil {
+		return nil, err
+	}
+	return &file{ClientFile: f, c: c}, nil
+}
+
+func (s *session) OpenDir(name string) (Dir, error) {
+	c, root, err := s.client()
+	if err != nil {
+		return nil, err
+	}
+	f, err := c.Open(root, names(name), protocol.OREAD)
+	if err != nil {
+		return nil, err
+	}
+	if f.QID().Type&protocol.QTDIR == 0 {
+		f.Close()
+		return nil, protocol.Errorf(protocol.ErrNotDir, "%v", name)
+	}
+	return &dir{file: file{ClientFile: f, c: c}}, nil
+}
+
+// walk walks a new fid to name, for f, which must clunk it.
+func (s *session) walk(name string, f func(*protocol.Client, protocol.FID) error) error {
+	c, root, err := s.client()
+	if err != nil {
+		return err
+	}
+	w, err := c.Walk(root, names(name))
+	if err != nil {
+		return err
+	}
+	return f(c, w.FID)
+}
+
+func (s *session) Stat(name string) (protocol.Dir, error) {
+	var d protocol.Dir
+	err := s.walk(name, func(c *protocol.Client, fid protocol.FID) error {
+		defer c.CallTclunk(fid)
+		var err error
+		d, err = stat(c, fid)
+		return err
+	})
+	return d, err
+}
+
+func (s *session) Wstat(name string, d protocol.Dir) error {
+	return s.walk(name, func(c *protocol.Client, fid protocol.FID) error {
+		defer c.CallTclunk(fid)
+		var b bytes.Buffer
+		protocol.Marshaldir(&b, d)
+		return c.CallTwstat(fid, b.Bytes())
+	})
+}
+
+func (s *session) Remove(name string) error {
+	return s.walk(name, func(c *protocol.Client, fid protocol.FID) error {
+		return c.CallTremove(fid)
+	})
+}
+
+func (s *session) Attach(user, aname string) (Session, error) {
+	n := s.conn
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if s.closed {
+		return nil, fmt.Errorf("session closed")
+	}
+	c, err := n.live()
+	if err != nil {
+		return nil, err
+	}
+	root, _, err := c.Attach(user, aname, n.cfg.auth)
+	if err != nil {
+		return nil, err
+	}
+	ns := &session{conn: n, user: user, aname: aname, root: root}
+	n.roots[ns] = true
+	return ns, nil
+}
+
+func (s *session) Msize() uint32 {
+	s.conn.mu.Lock()
+	defer s.conn.mu.Unlock()
+	return s.conn.c.Msize
+}
+
+func (s *session) Extensions() []string {
+	s.conn.mu.Lock()
+	defer s.conn.mu.Unlock()
+	return append([]string(nil), s.conn.c.Extensions...)
+}
+
+func (s *session) Close() error {
+	n := s.conn
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if s.closed {
+		return fmt.Errorf("session already closed")
+	}
+	s.closed = true
+	delete(n.roots, s)
+	if !n.c.IsDead() {
+		n.c.CallTclunk(s.root)
+	}
+	if len(n.roots) > 0 {
+		return nil
+	}
+	return n.rwc.Close()
+}
+
+func stat(c *protocol.Client, fid protocol.FID) (protocol.Dir, error) {
+	b, err := c.CallTstat(fid)
+	if err != nil {
+		return protocol.Dir{}, err
+	}
+	return protocol.Unmarshaldir(bytes.NewBuffer(b))
+}
+
+type file struct {
+	*protocol.ClientFile
+	c *protocol.Client
+}
+
+func (f *file) Stat() (protocol.Dir, error) {
+	return stat(f.c, f.FID())
+}
+
+type dir struct {
+	file
+	// ents holds entries read but not yet returned.
+	ents []protocol.Dir
+	eof  bool
+}
+
+func (d *dir) ReadDir(n int) ([]protocol.Dir, error) {
+	for !d.eof && (n <= 0 || len(d.ents) < n) {
+		ents, err := d.Dirread()
+		d.ents = append(d.ents, ents...)
+		if err == io.EOF {
+			d.eof = true
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	all := n <= 0
+	if all || n > len(d.ents) {
+		n = len(d.ents)
+	}
+	ents := d.ents[:n:n]
+	d.ents = d.ents[n:]
+	if !all && len(ents) == 0 {
+		return nil, io.EOF
+	}
+	return ents, nil
+}
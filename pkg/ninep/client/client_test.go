@@ -0,0 +1,376 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"harvey-os.org/internal/ramfs"
+	"harvey-os.org/internal/ufs"
+	"harvey-os.org/pkg/ninep/protocol"
+)
+
+// newTestDialer returns a function which makes connections to a new
+// ramfs.
+func newTestDialer(t *testing.T) func() (io.ReadWriteCloser, error) {
+	fs, err := ramfs.New(ramfs.RootOwner("glenda", "glenda"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := ramfs.NewServer(fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return func() (io.ReadWriteCloser, error) {
+		p, p2 := net.Pipe()
+		if err := l.Accept(p2); err != nil {
+			return nil, err
+		}
+		return p, nil
+	}
+}
+
+func newTestSession(t *testing.T, opts ...Opt) Session {
+	p, err := newTestDialer(t)()
+	if err != nil {
+		t.Fatalf("dial: want nil, got %v", err)
+	}
+	s, err := New(p, "glenda", "", opts...)
+	if err != nil {
+		t.Fatalf("New: want nil, got %v", err)
+	}
+	return s
+}
+
+func TestSession(t *testing.T) {
+	s := newTestSession(t, Msize(4096), Extensions(protocol.BatchExtension))
+	defer s.Close()
+	if s.Msize() != 4096 {
+		t.Errorf("Msize: got %d, want 4096", s.Msize())
+	}
+
+	if _, err := s.Create("d", protocol.DMDIR|0755, protocol.OREAD); err != nil {
+		t.Fatalf("Create(d): want nil, got %v", err)
+	}
+	f, err := s.Create("/d/f", 0644, protocol.ORDWR)
+	if err != nil {
+		t.Fatalf("Create(d/f): want nil, got %v", err)
+	}
+	if _, err := f.Write([]byte("hello, world")); err != nil {
+		t.Fatalf("Write: want nil, got %v", err)
+	}
+	if err := f.Truncate(5); err != nil {
+		t.Fatalf("Truncate: want nil, got %v", err)
+	}
+	if st, err := f.Stat(); err != nil || st.Length != 5 || st.Name != "f" {
+		t.Errorf("Stat: got %+v, %v, want f of 5 bytes", st, err)
+	}
+	f.Close()
+
+	f, err = s.Open("d/../d/f", protocol.OREAD)
+	if err != nil {
+		t.Fatalf("Open(d/../d/f): want nil, got %v", err)
+	}
+	if b, err := ioutil.ReadAll(f); err != nil || string(b) != "hello" {
+		t.Errorf("ReadAll: got %q, %v, want hello", b, err)
+	}
+	f.Close()
+
+	for _, n := range []string{"g", "h"} {
+		f, err := s.Create("d/"+n, 0644, protocol.OWRITE)
+		if err != nil {
+			t.Fatalf("Create(d/%v): want nil, got %v", n, err)
+		}
+		f.Close()
+	}
+	d, err := s.OpenDir("d")
+	if err != nil {
+		t.Fatalf("OpenDir(d): want nil, got %v", err)
+	}
+	ents, err := d.ReadDir(2)
+	if err != nil || len(ents) != 2 {
+		t.Fatalf("ReadDir(2): got %d entries, %v, want 2", len(ents), err)
+	}
+	if ents, err = d.ReadDir(0); err != nil || len(ents) != 1 || ents[0].Name != "h" {
+		t.Errorf("ReadDir(0): got %v, %v, want h", ents, err)
+	}
+	if _, err = d.ReadDir(1); err != io.EOF {
+		t.Errorf("ReadDir(1) at the end: got %v, want io.EOF", err)
+	}
+	d.Close()
+	if _, err := s.OpenDir("d/f"); err == nil {
+		t.Errorf("OpenDir(d/f): want error, got nil")
+	}
+
+	w := NoChange()
+	w.Name = "renamed"
+	if err := s.Wstat("d/g", w); err != nil {
+		t.Errorf("Wstat(d/g): want nil, got %v", err)
+	}
+	if st, err := s.Stat("d/renamed"); err != nil || st.Name != "renamed" {
+		t.Errorf("Stat(d/renamed): got %+v, %v", st, err)
+	}
+	if err := s.Remove("d/renamed"); err != nil {
+		t.Errorf("Remove(d/renamed): want nil, got %v", err)
+	}
+	if _, err := s.Stat("d/renamed"); err == nil {
+		t.Errorf("Stat(d/renamed) after Remove: want error, got nil")
+	}
+	if err := s.Close(); err != nil {
+		t.Errorf("Close: want nil, got %v", err)
+	}
+	if err := s.Close(); err == nil {
+		t.Errorf("second Close: want error, got nil")
+	}
+}
+
+func TestRedial(t *testing.T) {
+	dial := newTestDialer(t)
+	conn, err := dial()
+	if err != nil {
+		t.Fatalf("dial: want nil, got %v", err)
+	}
+	authed := false
+	s, err := New(conn, "glenda", "", Redial(dial), Auth(func(protocol.AuthFile) error {
+		authed = true
+		return nil
+	}))
+	if err != nil {
+		t.Fatalf("New: want nil, got %v", err)
+	}
+	defer s.Close()
+	if authed {
+		t.Errorf("Auth: called, but ramfs needs no authentication")
+	}
+	f, err := s.Create("f", 0644, protocol.OWRITE)
+	if err != nil {
+		t.Fatalf("Create(f): want nil, got %v", err)
+	}
+	if _, err := f.Write([]byte("hi")); err != nil {
+		t.Fatalf("Write: want nil, got %v", err)
+	}
+
+	c := s.(*session).conn.c
+	conn.Close()
+	for !c.IsDead() {
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := f.Write([]byte("there")); err == nil {
+		t.Errorf("Write after the connection is lost: want error, got nil")
+	}
+	if st, err := s.Stat("f"); err != nil || st.Length != 2 {
+		t.Errorf("Stat(f) after redialing: got %+v, %v, want 2 bytes", st, err)
+	}
+	if s.(*session).conn.c == c {
+		t.Errorf("session has the same client after redialing")
+	}
+}
+
+func TestAttach(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	for _, n := range []string{"a/x", "b/y"} {
+		if err := os.MkdirAll(filepath.Join(tmp, n), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l, err := ufs.NewUFS(tmp, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dial := func() (io.ReadWriteCloser, error) {
+		p, p2 := net.Pipe()
+		if err := l.Accept(p2); err != nil {
+			return nil, err
+		}
+		return p, nil
+	}
+	conn, err := dial()
+	if err != nil {
+		t.Fatalf("dial: want nil, got %v", err)
+	}
+	a, err := New(conn, "glenda", "a", Redial(dial))
+	if err != nil {
+		t.Fatalf("New(a): want nil, got %v", err)
+	}
+	b, err := a.Attach("glenda", "b")
+	if err != nil {
+		t.Fatalf("Attach(b): want nil, got %v", err)
+	}
+	check := func(when string) {
+		t.Helper()
+		if _, err := a.Stat("x"); err != nil {
+			t.Errorf("%v: a.Stat(x): want nil, got %v", when, err)
+		}
+		if _, err := a.Stat("y"); err == nil {
+			t.Errorf("%v: a.Stat(y): want error, got nil", when)
+		}
+		if _, err := b.Stat("y"); err != nil {
+			t.Errorf("%v: b.Stat(y): want nil, got %v", when, err)
+		}
+	}
+	check("attached")
+
+	// Both roots come back with a new connection.
+	c := a.(*session).conn.c
+	conn.Close()
+	for !c.IsDead() {
+		time.Sleep(time.Millisecond)
+	}
+	check("redialed")
+
+	if err := a.Close(); err != nil {
+		t.Errorf("a.Close: want nil, got %v", err)
+	}
+	if _, err := b.Stat("y"); err != nil {
+		t.Errorf("b.Stat(y) after a.Close: want nil, got %v", err)
+	}
+	if _, err := a.Stat("x"); err == nil {
+		t.Errorf("a.Stat(x) after a.Close: want error, got nil")
+	}
+	if err := b.Close(); err != nil {
+		t.Errorf("b.Close: want nil, got %v", err)
+	}
+}
+
+func TestLargeMsize(t *testing.T) {
+	s := newTestSession(t, Msize(protocol.MaxMsize))
+	defer s.Close()
+	if s.Msize() != protocol.MaxMsize {
+		t.Errorf("Msize: got %d, want %d", s.Msize(), protocol.MaxMsize)
+	}
+
+	// Bigger than a message, and not a multiple of one.
+	data := make([]byte, 2*protocol.MaxMsize+12345)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	f, err := s.Create("big", 0644, protocol.ORDWR)
+	if err != nil {
+		t.Fatalf("Create(big): want nil, got %v", err)
+	}
+	if n, err := f.WriteAt(data, 0); err != nil || n != len(data) {
+		t.Fatalf("WriteAt: got %d, %v, want %d, nil", n, err, len(data))
+	}
+	got := make([]byte, len(data))
+	if n, err := f.ReadAt(got, 0); (err != nil && err != io.EOF) || n != len(data) {
+		t.Fatalf("ReadAt: got %d, %v, want %d", n, err, len(data))
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("ReadAt: data does not match what was written")
+	}
+	f.Close()
+}
+
+// BenchmarkBulk copies a file to and from ramfs over TCP on the loopback
+// interface, at several msizes, to show what big messages save: the
+// bigger the msize, the fewer round trips a transfer takes.
+func BenchmarkBulk(b *testing.B) {
+	fs, err := ramfs.New(ramfs.RootOwner("glenda", "glenda"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	l, err := ramfs.NewServer(fs)
+	if err != nil {
+		b.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer ln.Close()
+	go l.Serve(ln)
+
+	data := make([]byte, 16<<20)
+	for _, msize := range []uint32{8192, 64 << 10, 1<<20 + protocol.IOHDRSZ, 4<<20 + protocol.IOHDRSZ, protocol.MaxMsize} {
+		s, err := Dial("tcp", ln.Addr().String(), "glenda", "", Msize(msize))
+		if err != nil {
+			b.Fatalf("Dial: want nil, got %v", err)
+		}
+		f, err := s.Create(fmt.Sprintf("bulk%d", msize), 0644, protocol.ORDWR)
+		if err != nil {
+			b.Fatalf("Create: want nil, got %v", err)
+		}
+		b.Run(fmt.Sprintf("write/msize=%d", msize), func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				if _, err := f.WriteAt(data, 0); err != nil {
+					b.Fatalf("WriteAt: want nil, got %v", err)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("read/msize=%d", msize), func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				if _, err := f.ReadAt(data, 0); err != nil && err != io.EOF {
+					b.Fatalf("ReadAt: want nil, got %v", err)
+				}
+			}
+		})
+		f.Close()
+		s.Close()
+	}
+}
+
+func TestAutoMsize(t *testing.T) {
+	if _, err := New(nil, "glenda", "", AutoMsize(0)); err == nil {
+		t.Errorf("AutoMsize(0): want err, got nil")
+	}
+
+	fs, err := ramfs.New(ramfs.RootOwner("glenda", "glenda"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const max = 256<<10 + protocol.IOHDRSZ
+	l, err := ramfs.NewServer(fs, func(l *protocol.Listener) error {
+		l.MaxMsize = max
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, p2 := net.Pipe()
+	if err := l.Accept(p2); err != nil {
+		t.Fatal(err)
+	}
+	s, err := New(p, "glenda", "", AutoMsize(time.Hour))
+	if err != nil {
+		t.Fatalf("New: want nil, got %v", err)
+	}
+	defer s.Close()
+	if s.Msize() != max {
+		t.Errorf("Msize: got %d, want %d", s.Msize(), max)
+	}
+
+	f, err := s.Create("f", 0644, protocol.ORDWR)
+	if err != nil {
+		t.Fatalf("Create(f): want nil, got %v", err)
+	}
+	defer f.Close()
+	c := s.(*session).conn.c
+	data := make([]byte, 1<<20)
+	for i := 0; c.TunedMsize() == 0; i++ {
+		if i == 10 {
+			t.Fatalf("not settled after %d writes of %d", i, len(data))
+		}
+		if _, err := f.WriteAt(data, 0); err != nil {
+			t.Fatalf("WriteAt: want nil, got %v", err)
+		}
+	}
+	if got := c.TunedMsize(); got != autoSizes[0] && got != max {
+		t.Errorf("TunedMsize: got %d, want %d or %d", got, autoSizes[0], max)
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"harvey-os.org/pkg/ninep/protocol"
+)
+
+// DefaultTimeout is how long the handshake may take.
+const DefaultTimeout = 30 * time.Second
+
+// Timeout sets how long the handshake, the version and attach of New,
+// Dial or a redial, may take, so that a server which is hung, or isn't
+// a 9P server at all, fails instead of hanging. 0 is no limit.
+func Timeout(d time.Duration) Opt {
+	return func(c *config) error {
+		if d < 0 {
+			return fmt.Errorf("timeout %v is negative", d)
+		}
+		c.timeout = d
+		return nil
+	}
+}
+
+// Clock sets the clock which times the handshake. By default, it is
+// protocol.SystemClock.
+func Clock(clock protocol.Clock) Opt {
+	return func(c *config) error {
+		c.clock = clock
+		return nil
+	}
+}
+
+// start starts the connection over rwc: the version, and then attach,
+// which attaches what it must, all within the timeout.
+func (n *connection) start(rwc io.ReadWriteCloser, attach func(*protocol.Client) error) (*protocol.Client, error) {
+	var timer protocol.Timer
+	if n.cfg.timeout > 0 {
+		clock := n.cfg.clock
+		if clock == nil {
+			clock = protocol.SystemClock
+		}
+		timer = clock.AfterFunc(n.cfg.timeout, func() { rwc.Close() })
+	}
+
+	c, err := protocol.NewClient(func(c *protocol.Client) error {
+		c.FromNet, c.ToNet = rwc, rwc
+		c.Msize = n.cfg.msize
+		c.Codec = n.cfg.codec
+		if n.cfg.checkOwners {
+			c.CheckOwners(n.cfg.owners)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	step := "Tversion"
+	msize, _, _, err := c.Version(protocol.MaxSize(n.cfg.msize), "9P2000", n.cfg.exts...)
+	if err == nil {
+		if uint32(msize) < c.Msize {
+			c.Msize = uint32(msize)
+		}
+		step = "Tattach"
+		err = attach(c)
+	}
+	switch {
+	case timer != nil && !timer.Stop():
+		// The connection was closed, even if the handshake got
+		// done first.
+		return nil, fmt.Errorf("no answer to %v within %v: is the server hung, or not a 9P server?", step, n.cfg.timeout)
+	case err != nil:
+		return nil, err
+	}
+	if n.cfg.tune > 0 {
+		c.TuneMsize(n.cfg.clock, n.cfg.tune, autoSizes...)
+	}
+	return c, nil
+}
@@ -0,0 +1,71 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"harvey-os.org/pkg/ninep/protocol"
+)
+
+func TestHandshake(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		// server closes stuck once the client waits for what
+		// it will never get, for the timeout to go off.
+		server func(conn net.Conn, stuck chan struct{})
+		want   string
+	}{
+		{"hung", func(conn net.Conn, stuck chan struct{}) {
+			close(stuck)
+			io.Copy(ioutil.Discard, conn)
+		}, "no answer to Tversion"},
+		{"HTTP", func(conn net.Conn, stuck chan struct{}) {
+			conn.Read(make([]byte, 512))
+			conn.Write([]byte("HTTP/1.1 400 Bad Request\r\n\r\n"))
+			io.Copy(ioutil.Discard, conn)
+		}, "first bytes look like HTTP"},
+		{"no attach", func(conn net.Conn, stuck chan struct{}) {
+			conn.Read(make([]byte, 512))
+			var b bytes.Buffer
+			protocol.MarshalRversionPkt(&b, protocol.NOTAG, 8192, "9P2000")
+			conn.Write(b.Bytes())
+			conn.Read(make([]byte, 512))
+			close(stuck)
+			io.Copy(ioutil.Discard, conn)
+		}, "no answer to Tattach"},
+	} {
+		p, p2 := net.Pipe()
+		stuck := make(chan struct{})
+		go tc.server(p2, stuck)
+		clock := protocol.NewFakeClock(time.Now())
+		done := make(chan error)
+		go func() {
+			_, err := New(p, "glenda", "", Timeout(time.Minute), Clock(clock))
+			done <- err
+		}()
+		var err error
+		select {
+		case <-stuck:
+			clock.Wait(1)
+			clock.Advance(time.Minute)
+			err = <-done
+		case err = <-done:
+		}
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%v: New: got %v, want an error with %q", tc.name, err, tc.want)
+		}
+		p2.Close()
+	}
+	if _, err := New(nil, "glenda", "", Timeout(-time.Second)); err == nil {
+		t.Errorf("Timeout(-1s): want error, got nil")
+	}
+}
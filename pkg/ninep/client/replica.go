@@ -0,0 +1,396 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+
+	"harvey-os.org/pkg/ninep/protocol"
+)
+
+// errReadOnly is the error for changing the files of replicas.
+var errReadOnly = protocol.Errorf(protocol.ErrReadOnly, "replicas")
+
+// Replicated returns a Session which reads from replicas, Sessions of
+// read-only copies of the same tree, such as mirrors kept by rsync -t.
+// Each Open, OpenDir and Stat goes to the next replica in turn, so that
+// the load is spread across them, and, if its connection dies, to the
+// next, so that a dead replica is only a slower request. Files and Dirs
+// opened on a replica which dies carry on, from where they were, on
+// another.
+//
+// A File carries on only on a replica which has the same version of it,
+// as its QID says, since another might have different contents: to
+// compare them, replicas should be of servers which give versions from
+// the files themselves, as ufs does from their modification times. A
+// replica which is dead is one whose connection is gone: other errors,
+// such as a file not found, are the file's, and are returned.
+//
+// Replicated sessions can't Create, Wstat or Remove, nor open files to
+// write. Close closes the replicas.
+func Replicated(replicas ...Session) (Session, error) {
+	if len(replicas) == 0 {
+		return nil, fmt.Errorf("no replicas")
+	}
+	return &replicated{replicas: replicas}, nil
+}
+
+type replicated struct {
+	replicas []Session
+	// next is the replica to try first for the next request.
+	next uint32
+}
+
+// dead reports whether the connection of s is gone, which makes s one to
+// pass over for another replica.
+func dead(s Session) bool {
+	switch s := s.(type) {
+	case *session:
+		s.conn.mu.Lock()
+		defer s.conn.mu.Unlock()
+		return s.closed || s.conn.c.IsDead()
+	case *replicated:
+		for _, r := range s.replicas {
+			if !dead(r) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// try calls f with each replica, from the next in turn, until one which
+// is not dead, or f succeeds.
+func (r *replicated) try(f func(i int, s Session) error) error {
+	n := len(r.replicas)
+	first := int(atomic.AddUint32(&r.next, 1)-1) % n
+	var err error
+	for j := 0; j < n; j++ {
+		i := (first + j) % n
+		s := r.replicas[i]
+		if err = f(i, s); err == nil || !dead(s) {
+			return err
+		}
+	}
+	return fmt.Errorf("all replicas dead: %v", err)
+}
+
+// version returns the QID of the open file f.
+func version(f interface{ Stat() (protocol.Dir, error) }) (protocol.QID, error) {
+	if q, ok := f.(interface{ QID() protocol.QID }); ok {
+		return q.QID(), nil
+	}
+	d, err := f.Stat()
+	return d.QID, err
+}
+
+// sameVersion reports whether a and b, from different replicas, are the
+// QIDs of the same version of a file. Their paths are the replicas' own.
+func sameVersion(a, b protocol.QID) bool {
+	return a.Type == b.Type && a.Version == b.Version
+}
+
+func (r *replicated) Open(name string, mode protocol.Mode) (File, error) {
+	if mode.Writes() {
+		return nil, errReadOnly
+	}
+	rf := &replicaFile{r: r, name: name, mode: mode}
+	err := r.try(func(i int, s Session) error {
+		f, err := s.Open(name, mode)
+		if err != nil {
+			return err
+		}
+		q, err := version(f)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		rf.i, rf.f, rf.qid = i, f, q
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (r *replicated) Create(name string, perm protocol.Perm, mode protocol.Mode) (File, error) {
+	return nil, errReadOnly
+}
+
+func (r *replicated) OpenDir(name string) (Dir, error) {
+	rd := &replicaDir{r: r, name: name, seen: map[string]bool{}}
+	err := r.try(func(i int, s Session) error {
+		d, err := s.OpenDir(name)
+		if err != nil {
+			return err
+		}
+		rd.i, rd.d = i, d
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rd, nil
+}
+
+func (r *replicated) Stat(name string) (protocol.Dir, error) {
+	var d protocol.Dir
+	err := r.try(func(i int, s Session) error {
+		var err error
+		d, err = s.Stat(name)
+		return err
+	})
+	return d, err
+}
+
+func (r *replicated) Wstat(name string, d protocol.Dir) error {
+	return errReadOnly
+}
+
+func (r *replicated) Remove(name string) error {
+	return errReadOnly
+}
+
+// Attach attaches to aname as user on each replica which is not dead.
+func (r *replicated) Attach(user, aname string) (Session, error) {
+	var ss []Session
+	var err error
+	for _, s := range r.replicas {
+		a, aerr := s.Attach(user, aname)
+		if aerr != nil {
+			if !dead(s) {
+				err = aerr
+				break
+			}
+			continue
+		}
+		ss = append(ss, a)
+	}
+	if err == nil && len(ss) == 0 {
+		err = fmt.Errorf("all replicas dead")
+	}
+	if err != nil {
+		for _, a := range ss {
+			a.Close()
+		}
+		return nil, err
+	}
+	return Replicated(ss...)
+}
+
+// Msize is the smallest of the replicas'.
+func (r *replicated) Msize() uint32 {
+	m := r.replicas[0].Msize()
+	for _, s := range r.replicas[1:] {
+		if s.Msize() < m {
+			m = s.Msize()
+		}
+	}
+	return m
+}
+
+// Extensions are those every replica agreed to.
+func (r *replicated) Extensions() []string {
+	n := map[string]int{}
+	for _, s := range r.replicas {
+		for _, e := range s.Extensions() {
+			n[e]++
+		}
+	}
+	var exts []string
+	for _, e := range r.replicas[0].Extensions() {
+		if n[e] == len(r.replicas) {
+			exts = append(exts, e)
+		}
+	}
+	return exts
+}
+
+func (r *replicated) Close() error {
+	var err error
+	for _, s := range r.replicas {
+		if cerr := s.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// A replicaFile is a File open on one replica, i, which moves to another
+// if that one dies.
+type replicaFile struct {
+	r    *replicated
+	name string
+	mode protocol.Mode
+	qid  protocol.QID
+
+	i   int
+	f   File
+	off int64
+}
+
+// failover opens the file again on a replica other than the dead one it
+// was open on, which has the same version of it.
+func (rf *replicaFile) failover(err error) error {
+	rf.f.Close()
+	from := rf.i
+	n := len(rf.r.replicas)
+	for j := 1; j < n; j++ {
+		i := (from + j) % n
+		s := rf.r.replicas[i]
+		f, oerr := s.Open(rf.name, rf.mode)
+		if oerr != nil {
+			continue
+		}
+		if q, verr := version(f); verr != nil || !sameVersion(q, rf.qid) {
+			f.Close()
+			continue
+		}
+		rf.i, rf.f = i, f
+		return nil
+	}
+	return fmt.Errorf("%v: no other replica has version %d: %v", rf.name, rf.qid.Version, err)
+}
+
+func (rf *replicaFile) ReadAt(p []byte, off int64) (int, error) {
+	var n int
+	for {
+		m, err := rf.f.ReadAt(p[n:], off+int64(n))
+		n += m
+		if err == nil || err == io.EOF || !dead(rf.r.replicas[rf.i]) {
+			return n, err
+		}
+		if err := rf.failover(err); err != nil {
+			return n, err
+		}
+	}
+}
+
+func (rf *replicaFile) Read(p []byte) (int, error) {
+	n, err := rf.ReadAt(p, rf.off)
+	rf.off += int64(n)
+	return n, err
+}
+
+func (rf *replicaFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += rf.off
+	case io.SeekEnd:
+		d, err := rf.Stat()
+		if err != nil {
+			return rf.off, err
+		}
+		offset += int64(d.Length)
+	default:
+		return rf.off, fmt.Errorf("Seek: invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return rf.off, fmt.Errorf("Seek: negative offset %d", offset)
+	}
+	rf.off = offset
+	return offset, nil
+}
+
+func (rf *replicaFile) Stat() (protocol.Dir, error) {
+	for {
+		d, err := rf.f.Stat()
+		if err == nil || !dead(rf.r.replicas[rf.i]) {
+			return d, err
+		}
+		if err := rf.failover(err); err != nil {
+			return d, err
+		}
+	}
+}
+
+func (rf *replicaFile) Write(p []byte) (int, error) {
+	return 0, errReadOnly
+}
+
+func (rf *replicaFile) WriteAt(p []byte, off int64) (int, error) {
+	return 0, errReadOnly
+}
+
+func (rf *replicaFile) Truncate(size int64) error {
+	return errReadOnly
+}
+
+func (rf *replicaFile) Close() error {
+	return rf.f.Close()
+}
+
+// A replicaDir is a Dir open on one replica, i, which moves to another
+// if that one dies, reading it from the start again, and passing over
+// the entries it has already returned.
+type replicaDir struct {
+	r    *replicated
+	name string
+
+	i    int
+	d    Dir
+	seen map[string]bool
+}
+
+func (rd *replicaDir) ReadDir(n int) ([]protocol.Dir, error) {
+	for {
+		ents, err := rd.d.ReadDir(n)
+		if err != nil && err != io.EOF && dead(rd.r.replicas[rd.i]) {
+			if err := rd.failover(err); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		fresh := ents[:0]
+		for _, e := range ents {
+			if !rd.seen[e.Name] {
+				rd.seen[e.Name] = true
+				fresh = append(fresh, e)
+			}
+		}
+		if len(fresh) == 0 && len(ents) > 0 {
+			// All were returned before the failover.
+			continue
+		}
+		return fresh, err
+	}
+}
+
+// failover opens the directory again on a replica other than the dead
+// one it was open on.
+func (rd *replicaDir) failover(err error) error {
+	rd.d.Close()
+	from := rd.i
+	n := len(rd.r.replicas)
+	for j := 1; j < n; j++ {
+		i := (from + j) % n
+		if d, oerr := rd.r.replicas[i].OpenDir(rd.name); oerr == nil {
+			rd.i, rd.d = i, d
+			return nil
+		}
+	}
+	return fmt.Errorf("%v: no other replica: %v", rd.name, err)
+}
+
+func (rd *replicaDir) Stat() (protocol.Dir, error) {
+	for {
+		d, err := rd.d.Stat()
+		if err == nil || !dead(rd.r.replicas[rd.i]) {
+			return d, err
+		}
+		if err := rd.failover(err); err != nil {
+			return d, err
+		}
+	}
+}
+
+func (rd *replicaDir) Close() error {
+	return rd.d.Close()
+}
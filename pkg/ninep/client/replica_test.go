@@ -0,0 +1,150 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"harvey-os.org/pkg/ninep/protocol"
+)
+
+// newTestReplica returns a session of a new ramfs with the files given,
+// each written once, so that replicas made alike have the same versions,
+// and its connection, to kill it.
+func newTestReplica(t *testing.T, files map[string]string) (Session, io.Closer) {
+	conn, err := newTestDialer(t)()
+	if err != nil {
+		t.Fatalf("dial: want nil, got %v", err)
+	}
+	s, err := New(conn, "glenda", "")
+	if err != nil {
+		t.Fatalf("New: want nil, got %v", err)
+	}
+	for name, data := range files {
+		f, err := s.Create(name, 0644, protocol.OWRITE)
+		if err != nil {
+			t.Fatalf("Create(%v): want nil, got %v", name, err)
+		}
+		if _, err := f.Write([]byte(data)); err != nil {
+			t.Fatalf("Write(%v): want nil, got %v", name, err)
+		}
+		f.Close()
+	}
+	return s, conn
+}
+
+func kill(t *testing.T, s Session, conn io.Closer) {
+	conn.Close()
+	for !dead(s) {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestReplicated(t *testing.T) {
+	long := strings.Repeat("0123456789", 1000)
+	s0, c0 := newTestReplica(t, map[string]string{"who": "0", "f": long, "g": "same"})
+	s1, c1 := newTestReplica(t, map[string]string{"who": "1", "f": long, "g": "same"})
+	// g on replica 1 is written again, so it is another version.
+	if f, err := s1.Open("g", protocol.OWRITE); err != nil {
+		t.Fatalf("Open(g): want nil, got %v", err)
+	} else {
+		f.Write([]byte("SAME"))
+		f.Close()
+	}
+	s, err := Replicated(s0, s1)
+	if err != nil {
+		t.Fatalf("Replicated: want nil, got %v", err)
+	}
+	defer s.Close()
+
+	// Reads take turns.
+	who := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		f, err := s.Open("who", protocol.OREAD)
+		if err != nil {
+			t.Fatalf("Open(who): want nil, got %v", err)
+		}
+		b, err := ioutil.ReadAll(f)
+		if err != nil {
+			t.Fatalf("ReadAll(who): want nil, got %v", err)
+		}
+		who[string(b)] = true
+		f.Close()
+	}
+	if !who["0"] || !who["1"] {
+		t.Errorf("two reads of who: got %v, want one from each replica", who)
+	}
+
+	if _, err := s.Open("who", protocol.OWRITE); err != errReadOnly {
+		t.Errorf("Open(who, OWRITE): got %v, want %v", err, errReadOnly)
+	}
+	if err := s.Remove("who"); err != errReadOnly {
+		t.Errorf("Remove(who): got %v, want %v", err, errReadOnly)
+	}
+
+	// A file carries on, from where it was, on another replica with
+	// the same version of it.
+	f, err := s.Open("f", protocol.OREAD)
+	if err != nil {
+		t.Fatalf("Open(f): want nil, got %v", err)
+	}
+	b := make([]byte, 10)
+	if _, err := io.ReadFull(f, b); err != nil {
+		t.Fatalf("Read(f): want nil, got %v", err)
+	}
+	on := f.(*replicaFile).i
+	g, err := s.Open("g", protocol.OREAD)
+	if err != nil {
+		t.Fatalf("Open(g): want nil, got %v", err)
+	}
+	if g.(*replicaFile).i != on {
+		// g is on the other replica: get it on this one.
+		g.Close()
+		if g, err = s.Open("g", protocol.OREAD); err != nil {
+			t.Fatalf("Open(g): want nil, got %v", err)
+		}
+	}
+	if on == 0 {
+		kill(t, s0, c0)
+	} else {
+		kill(t, s1, c1)
+	}
+	rest, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatalf("ReadAll(f) after its replica died: want nil, got %v", err)
+	}
+	if got := string(b) + string(rest); got != long {
+		t.Errorf("f after its replica died: got %d bytes, want %d, the same", len(got), len(long))
+	}
+	if f.(*replicaFile).i == on {
+		t.Errorf("f after its replica died: still on replica %d", on)
+	}
+	f.Close()
+
+	// But not on one with another version.
+	if _, err := ioutil.ReadAll(g); err == nil || !strings.Contains(err.Error(), "no other replica has version") {
+		t.Errorf("ReadAll(g) after its replica died: got %v, want no other replica with its version", err)
+	}
+	g.Close()
+
+	// Every request goes to the replica left.
+	for i := 0; i < 2; i++ {
+		if d, err := s.Stat("f"); err != nil || d.Length != uint64(len(long)) {
+			t.Errorf("Stat(f) with a replica dead: got %+v, %v, want %d bytes", d, err, len(long))
+		}
+	}
+	d, err := s.OpenDir("/")
+	if err != nil {
+		t.Fatalf("OpenDir(/) with a replica dead: want nil, got %v", err)
+	}
+	if ents, err := d.ReadDir(-1); err != nil || len(ents) != 3 {
+		t.Errorf("ReadDir(-1) with a replica dead: got %v, %v, want 3 entries", ents, err)
+	}
+	d.Close()
+}
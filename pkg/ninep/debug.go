@@ -15,6 +15,36 @@ type DebugFileServer struct {
 	FileServer protocol.NineServer
 }
 
+// NewDebugFileServer wraps ns in a DebugFileServer. If ns is a
+// protocol.Snapshotter, so is what it returns, so that snapshots are
+// still served.
+func NewDebugFileServer(ns protocol.NineServer) protocol.NineServer {
+	d := &DebugFileServer{FileServer: ns}
+	if s, ok := ns.(protocol.Snapshotter); ok {
+		return &debugSnapshotter{DebugFileServer: d, snap: s}
+	}
+	return d
+}
+
+// debugSnapshotter is a DebugFileServer of a Snapshotter. A
+// DebugFileServer can't be one itself: any server it wrapped would
+// then look like a Snapshotter, and have its anames split.
+type debugSnapshotter struct {
+	*DebugFileServer
+	snap protocol.Snapshotter
+}
+
+func (d *debugSnapshotter) RattachSnapshot(fid, afid protocol.FID, uname, aname, snap string) (protocol.QID, error) {
+	log.Printf(">>> Tattach fid %v,  afid %v, uname %v, aname %v, snapshot %v\n", fid, afid, uname, aname, snap)
+	qid, err := d.snap.RattachSnapshot(fid, afid, uname, aname, snap)
+	if err == nil {
+		log.Printf("<<< Rattach %v\n", qid)
+	} else {
+		log.Printf("<<< Error %v\n", err)
+	}
+	return qid, err
+}
+
 func (dfs *DebugFileServer) Rversion(msize protocol.MaxSize, version string) (protocol.MaxSize, string, error) {
 	log.Printf(">>> Tversion %v %v\n", msize, version)
 	msize, version, err := dfs.FileServer.Rversion(msize, version)
@@ -150,3 +180,72 @@ func (dfs *DebugFileServer) Rwrite(fid protocol.FID, o protocol.Offset, b []byte
 	}
 	return c, err
 }
+
+// The rest pass on the optional interfaces of protocol, so that wrapping
+// a NineServer to debug it doesn't change what it does. Where the
+// FileServer doesn't implement one, they do as the protocol package
+// does for a server which doesn't.
+
+// SetPeer tells the FileServer who the peer is, if it wants to know.
+func (dfs *DebugFileServer) SetPeer(p *protocol.PeerCred) {
+	log.Printf(">>> peer %+v\n", *p)
+	if ps, ok := dfs.FileServer.(protocol.PeerServer); ok {
+		ps.SetPeer(p)
+	}
+}
+
+func (dfs *DebugFileServer) Rcopy(fid protocol.FID, o protocol.Offset, dfid protocol.FID, do protocol.Offset, count uint64) (uint64, error) {
+	log.Printf(">>> Tcopy fid %v, off %v, dfid %v, doff %v, count %v\n", fid, o, dfid, do, count)
+	var n uint64
+	var err error
+	if c, ok := dfs.FileServer.(protocol.Copier); ok {
+		n, err = c.Rcopy(fid, o, dfid, do, count)
+	} else {
+		n, err = protocol.CopyByReads(dfs.FileServer, fid, o, dfid, do, count)
+	}
+	if err == nil {
+		log.Printf("<<< Rcopy %v\n", n)
+	} else {
+		log.Printf("<<< Error %v (copied %v)\n", err, n)
+	}
+	return n, err
+}
+
+// Hangup tells the FileServer the connection has ended, if it wants to
+// know.
+func (dfs *DebugFileServer) Hangup() {
+	log.Printf(">>> hangup\n")
+	if h, ok := dfs.FileServer.(protocol.HangupServer); ok {
+		h.Hangup()
+	}
+}
+
+func (dfs *DebugFileServer) Rreadlink(fid protocol.FID) (string, error) {
+	log.Printf(">>> Treadlink fid %v\n", fid)
+	r, ok := dfs.FileServer.(protocol.Readlinker)
+	if !ok {
+		err := protocol.Errorf(protocol.ErrNotSupported, "Treadlink")
+		log.Printf("<<< Error %v\n", err)
+		return "", err
+	}
+	target, err := r.Rreadlink(fid)
+	if err == nil {
+		log.Printf("<<< Rreadlink %v\n", target)
+	} else {
+		log.Printf("<<< Error %v\n", err)
+	}
+	return target, err
+}
+
+// Wait holds reads as the FileServer's Wait does, if it has one.
+func (dfs *DebugFileServer) Wait(fid protocol.FID, o protocol.Offset) <-chan struct{} {
+	w, ok := dfs.FileServer.(protocol.Waiter)
+	if !ok {
+		return nil
+	}
+	ready := w.Wait(fid, o)
+	if ready != nil {
+		log.Printf("=== Tread fid %v, off %v waits\n", fid, o)
+	}
+	return ready
+}
@@ -0,0 +1,150 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ninep
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"reflect"
+	"testing"
+
+	"harvey-os.org/pkg/ninep/protocol"
+)
+
+// plainServer implements none of protocol's optional interfaces. Of
+// its NineServer methods, only those a Tcopy by reads needs are called.
+type plainServer struct {
+	protocol.NineServer
+	data []byte
+}
+
+func (s *plainServer) Rread(fid protocol.FID, o protocol.Offset, c protocol.Count) ([]byte, error) {
+	if int(o) >= len(s.data) {
+		return nil, nil
+	}
+	return s.data[o:], nil
+}
+
+func (s *plainServer) Rwrite(fid protocol.FID, o protocol.Offset, b []byte) (protocol.Count, error) {
+	s.data = append(s.data[:o], b...)
+	return protocol.Count(len(b)), nil
+}
+
+// optServer implements protocol's optional interfaces, and records which
+// were called.
+type optServer struct {
+	plainServer
+	called []string
+}
+
+func (s *optServer) SetPeer(*protocol.PeerCred) { s.called = append(s.called, "SetPeer") }
+func (s *optServer) Hangup()                    { s.called = append(s.called, "Hangup") }
+
+func (s *optServer) RattachSnapshot(fid, afid protocol.FID, uname, aname, snap string) (protocol.QID, error) {
+	s.called = append(s.called, "RattachSnapshot")
+	return protocol.QID{}, nil
+}
+
+func (s *optServer) Rcopy(fid protocol.FID, o protocol.Offset, dfid protocol.FID, do protocol.Offset, count uint64) (uint64, error) {
+	s.called = append(s.called, "Rcopy")
+	return count, nil
+}
+
+func (s *optServer) Rreadlink(fid protocol.FID) (string, error) {
+	s.called = append(s.called, "Rreadlink")
+	return "target", nil
+}
+
+func (s *optServer) Wait(fid protocol.FID, o protocol.Offset) <-chan struct{} {
+	s.called = append(s.called, "Wait")
+	return make(chan struct{})
+}
+
+// quiet discards what DebugFileServer logs until the test ends.
+func quiet(t *testing.T) {
+	w := log.Writer()
+	log.SetOutput(ioutil.Discard)
+	t.Cleanup(func() { log.SetOutput(w) })
+}
+
+func TestDebugSetPeer(t *testing.T) {
+	quiet(t)
+	opt := &optServer{}
+	(&DebugFileServer{FileServer: opt}).SetPeer(&protocol.PeerCred{UID: 1})
+	if want := []string{"SetPeer"}; !reflect.DeepEqual(opt.called, want) {
+		t.Errorf("SetPeer through DebugFileServer: got calls %v, want %v", opt.called, want)
+	}
+	// A FileServer which doesn't want to know isn't told.
+	(&DebugFileServer{FileServer: &plainServer{}}).SetPeer(&protocol.PeerCred{UID: 1})
+}
+
+func TestDebugRcopy(t *testing.T) {
+	quiet(t)
+	opt := &optServer{}
+	n, err := (&DebugFileServer{FileServer: opt}).Rcopy(1, 0, 1, 3, 3)
+	if want := []string{"Rcopy"}; !reflect.DeepEqual(opt.called, want) || n != 3 || err != nil {
+		t.Errorf("Rcopy through DebugFileServer: got %v, %v, calls %v; want 3, nil, calls %v", n, err, opt.called, want)
+	}
+	// Without a Copier, the copy is done by reads and writes.
+	plain := &plainServer{data: []byte("abc")}
+	n, err = (&DebugFileServer{FileServer: plain}).Rcopy(1, 0, 1, 3, 3)
+	if n != 3 || err != nil || string(plain.data) != "abcabc" {
+		t.Errorf("Rcopy without a Copier: got %v, %v, data %q; want 3 bytes copied by reads", n, err, plain.data)
+	}
+}
+
+func TestDebugSnapshot(t *testing.T) {
+	quiet(t)
+	opt := &optServer{}
+	snap, ok := NewDebugFileServer(opt).(protocol.Snapshotter)
+	if !ok {
+		t.Fatalf("NewDebugFileServer of a Snapshotter: got no Snapshotter")
+	}
+	if _, err := snap.RattachSnapshot(1, protocol.NOFID, "glenda", "", "then"); err != nil {
+		t.Errorf("RattachSnapshot through DebugFileServer: want nil, got %v", err)
+	}
+	if want := []string{"RattachSnapshot"}; !reflect.DeepEqual(opt.called, want) {
+		t.Errorf("RattachSnapshot through DebugFileServer: got calls %v, want %v", opt.called, want)
+	}
+	if _, ok := NewDebugFileServer(&plainServer{}).(protocol.Snapshotter); ok {
+		t.Errorf("NewDebugFileServer of a plain server: got a Snapshotter, want none")
+	}
+}
+
+func TestDebugHangup(t *testing.T) {
+	quiet(t)
+	opt := &optServer{}
+	(&DebugFileServer{FileServer: opt}).Hangup()
+	if want := []string{"Hangup"}; !reflect.DeepEqual(opt.called, want) {
+		t.Errorf("Hangup through DebugFileServer: got calls %v, want %v", opt.called, want)
+	}
+	(&DebugFileServer{FileServer: &plainServer{}}).Hangup()
+}
+
+func TestDebugRreadlink(t *testing.T) {
+	quiet(t)
+	opt := &optServer{}
+	target, err := (&DebugFileServer{FileServer: opt}).Rreadlink(1)
+	if want := []string{"Rreadlink"}; !reflect.DeepEqual(opt.called, want) || target != "target" || err != nil {
+		t.Errorf("Rreadlink through DebugFileServer: got %q, %v, calls %v; want target, nil, calls %v", target, err, opt.called, want)
+	}
+	target, err = (&DebugFileServer{FileServer: &plainServer{}}).Rreadlink(1)
+	if !errors.Is(err, protocol.ErrNotSupported) || target != "" {
+		t.Errorf("Rreadlink without a Readlinker: got %q, %v; want ErrNotSupported", target, err)
+	}
+}
+
+func TestDebugWait(t *testing.T) {
+	quiet(t)
+	opt := &optServer{}
+	ready := (&DebugFileServer{FileServer: opt}).Wait(1, 0)
+	if want := []string{"Wait"}; !reflect.DeepEqual(opt.called, want) || ready == nil {
+		t.Errorf("Wait through DebugFileServer: got %v, calls %v; want a channel, calls %v", ready, opt.called, want)
+	}
+	if ready := (&DebugFileServer{FileServer: &plainServer{}}).Wait(1, 0); ready != nil {
+		t.Errorf("Wait without a Waiter: got a channel, want nil")
+	}
+}
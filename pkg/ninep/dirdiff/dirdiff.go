@@ -0,0 +1,247 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package dirdiff compares two trees of files, each an fs.FS: a local
+// directory, with os.DirFS, the files of a 9P server, with
+// protocol.Client.FS, or anything else. Files are compared by what they
+// stat as and, optionally, by a hash of their contents.
+package dirdiff
+
+import (
+	"bytes"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+)
+
+// A Change is how a file differs between trees a and b.
+type Change int
+
+const (
+	// Added files are only in b.
+	Added Change = iota
+	// Removed files are only in a.
+	Removed
+	// Modified files are in both, but differ.
+	Modified
+)
+
+func (c Change) String() string {
+	switch c {
+	case Added:
+		return "added"
+	case Removed:
+		return "removed"
+	case Modified:
+		return "modified"
+	}
+	return fmt.Sprintf("Change(%d)", int(c))
+}
+
+// What says what differs about a Modified file.
+type What uint
+
+const (
+	// Type is set when one is a directory, or some other kind of
+	// file, and the other is not. Nothing else is compared.
+	Type What = 1 << iota
+	// Size is set when the lengths differ.
+	Size
+	// Mode is set when the permissions differ.
+	Mode
+	// ModTime is set when the modification times differ by a second
+	// or more, since that is all 9P keeps.
+	ModTime
+	// Content is set when the hashes of the contents differ. They
+	// are only compared with the Contents option, and only for
+	// regular files the same size.
+	Content
+)
+
+var whatNames = []string{"type", "size", "mode", "mtime", "content"}
+
+func (w What) String() string {
+	var s []string
+	for i, n := range whatNames {
+		if w&(1<<uint(i)) != 0 {
+			s = append(s, n)
+		}
+	}
+	return strings.Join(s, ",")
+}
+
+// An Entry is one file which differs.
+type Entry struct {
+	// Path is the file's name in both trees, as for fs.FS.
+	Path   string
+	Change Change
+	// What is what differs, for a Modified file.
+	What What
+	// A and B are the file in a and in b. One is nil for an Added or
+	// Removed file.
+	A, B fs.FileInfo
+}
+
+func (e Entry) String() string {
+	if e.Change == Modified {
+		return fmt.Sprintf("%v %v (%v)", e.Change, e.Path, e.What)
+	}
+	return fmt.Sprintf("%v %v", e.Change, e.Path)
+}
+
+// config is the settings for one Diff.
+type config struct {
+	// hash, if set, makes a hash of each file compared by content.
+	hash func() hash.Hash
+
+	// ignore is what is not compared.
+	ignore What
+}
+
+// Opt is an option for Diff.
+type Opt func(*config) error
+
+// Contents compares regular files which are the same size by hashing
+// their contents with hashes from h. Without it, only what files stat
+// as is compared, which is much cheaper over 9P.
+func Contents(h func() hash.Hash) Opt {
+	return func(c *config) error {
+		c.hash = h
+		return nil
+	}
+}
+
+// Ignore leaves out of the comparison the things in w, such as ModTime
+// between trees which were copied without keeping it, or Mode where
+// one of them has no permissions to speak of. Type can't be ignored.
+func Ignore(w What) Opt {
+	return func(c *config) error {
+		if w&Type != 0 {
+			return fmt.Errorf("Ignore: can't ignore type")
+		}
+		c.ignore |= w
+		return nil
+	}
+}
+
+// Diff compares the trees a and b, and returns the files which differ,
+// in the order of fs.WalkDir. A directory which is only in one tree is
+// one Entry, without what is in it, as is a file which is a directory
+// in one tree and not the other. It stops at the first file which can't
+// be read.
+func Diff(a, b fs.FS, opts ...Opt) ([]Entry, error) {
+	var c config
+	for _, o := range opts {
+		if err := o(&c); err != nil {
+			return nil, err
+		}
+	}
+	var d []Entry
+	err := c.diff(a, b, ".", &d)
+	return d, err
+}
+
+// diff compares the directory dir in a and b, adding what differs to d.
+func (c *config) diff(a, b fs.FS, dir string, d *[]Entry) error {
+	ae, err := fs.ReadDir(a, dir)
+	if err != nil {
+		return err
+	}
+	be, err := fs.ReadDir(b, dir)
+	if err != nil {
+		return err
+	}
+	// ReadDir sorts by name, so the two can be merged.
+	for len(ae) > 0 || len(be) > 0 {
+		var e Entry
+		switch {
+		case len(be) == 0 || len(ae) > 0 && ae[0].Name() < be[0].Name():
+			e.Path, e.Change = path.Join(dir, ae[0].Name()), Removed
+			if e.A, err = ae[0].Info(); err != nil {
+				return err
+			}
+			ae = ae[1:]
+		case len(ae) == 0 || be[0].Name() < ae[0].Name():
+			e.Path, e.Change = path.Join(dir, be[0].Name()), Added
+			if e.B, err = be[0].Info(); err != nil {
+				return err
+			}
+			be = be[1:]
+		default:
+			e.Path, e.Change = path.Join(dir, ae[0].Name()), Modified
+			if e.A, err = ae[0].Info(); err != nil {
+				return err
+			}
+			if e.B, err = be[0].Info(); err != nil {
+				return err
+			}
+			ae, be = ae[1:], be[1:]
+			if e.What, err = c.compare(a, b, e.Path, e.A, e.B); err != nil {
+				return err
+			}
+			if e.What != 0 {
+				*d = append(*d, e)
+			}
+			if e.What&Type == 0 && e.A.IsDir() {
+				if err := c.diff(a, b, e.Path, d); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		*d = append(*d, e)
+	}
+	return nil
+}
+
+// compare returns what differs about name, which is ai in a and bi in b.
+func (c *config) compare(a, b fs.FS, name string, ai, bi fs.FileInfo) (What, error) {
+	if ai.Mode().Type() != bi.Mode().Type() {
+		return Type, nil
+	}
+	var w What
+	if !ai.IsDir() && ai.Size() != bi.Size() {
+		w |= Size
+	}
+	if ai.Mode().Perm() != bi.Mode().Perm() {
+		w |= Mode
+	}
+	if !ai.IsDir() && !ai.ModTime().Truncate(time.Second).Equal(bi.ModTime().Truncate(time.Second)) {
+		w |= ModTime
+	}
+	w &^= c.ignore
+	if c.hash == nil || c.ignore&Content != 0 || !ai.Mode().IsRegular() || ai.Size() != bi.Size() {
+		return w, nil
+	}
+	ah, err := c.sum(a, name)
+	if err != nil {
+		return 0, err
+	}
+	bh, err := c.sum(b, name)
+	if err != nil {
+		return 0, err
+	}
+	if !bytes.Equal(ah, bh) {
+		w |= Content
+	}
+	return w, nil
+}
+
+// sum returns the hash of the contents of name in fsys.
+func (c *config) sum(fsys fs.FS, name string) ([]byte, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := c.hash()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, fmt.Errorf("%v: %v", name, err)
+	}
+	return h.Sum(nil), nil
+}
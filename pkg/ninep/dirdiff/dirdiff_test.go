@@ -0,0 +1,105 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dirdiff
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"harvey-os.org/internal/ninetest"
+	"harvey-os.org/pkg/ninep/iofs"
+)
+
+var when = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+func tree() fstest.MapFS {
+	return fstest.MapFS{
+		"same":    {Data: []byte("same"), Mode: 0644, ModTime: when},
+		"gone":    {Data: []byte("gone"), Mode: 0644, ModTime: when},
+		"d/inner": {Data: []byte("abc"), Mode: 0644, ModTime: when},
+		"d/mode":  {Data: []byte("mode"), Mode: 0644, ModTime: when},
+		"only/a":  {Data: []byte("a"), Mode: 0644, ModTime: when},
+		"kind":    {Data: []byte("kind"), Mode: 0644, ModTime: when},
+		"content": {Data: []byte("old"), Mode: 0644, ModTime: when},
+	}
+}
+
+func changed() fstest.MapFS {
+	b := tree()
+	delete(b, "gone")
+	delete(b, "only/a")
+	delete(b, "kind")
+	b["new"] = &fstest.MapFile{Data: []byte("new"), Mode: 0644, ModTime: when}
+	b["d/inner"] = &fstest.MapFile{Data: []byte("abcd"), Mode: 0644, ModTime: when.Add(time.Hour)}
+	b["d/mode"] = &fstest.MapFile{Data: []byte("mode"), Mode: 0600, ModTime: when}
+	b["kind/x"] = &fstest.MapFile{Data: []byte("x"), Mode: 0644, ModTime: when}
+	b["content"] = &fstest.MapFile{Data: []byte("new"), Mode: 0644, ModTime: when.Add(time.Millisecond)}
+	return b
+}
+
+func diffs(t *testing.T, a, b fs.FS, opts ...Opt) []string {
+	t.Helper()
+	d, err := Diff(a, b, opts...)
+	if err != nil {
+		t.Fatalf("Diff: want nil, got %v", err)
+	}
+	var s []string
+	for _, e := range d {
+		s = append(s, e.String())
+	}
+	return s
+}
+
+func TestDiff(t *testing.T) {
+	var tests = []struct {
+		opts []Opt
+		want string
+	}{
+		{want: "[modified d/inner (size,mtime) modified d/mode (mode) removed gone modified kind (type) added new removed only]"},
+		{opts: []Opt{Contents(sha256.New)}, want: "[modified content (content) modified d/inner (size,mtime) modified d/mode (mode) removed gone modified kind (type) added new removed only]"},
+		{opts: []Opt{Contents(sha256.New), Ignore(ModTime | Mode)}, want: "[modified content (content) modified d/inner (size) removed gone modified kind (type) added new removed only]"},
+	}
+	for _, tt := range tests {
+		if got := fmt.Sprint(diffs(t, tree(), changed(), tt.opts...)); got != tt.want {
+			t.Errorf("Diff: want\n\t%v\ngot\n\t%v", tt.want, got)
+		}
+	}
+	if d := diffs(t, tree(), tree(), Contents(sha256.New)); len(d) != 0 {
+		t.Errorf("Diff of the same tree: want none, got %v", d)
+	}
+	if _, err := Diff(tree(), tree(), Ignore(Type)); err == nil {
+		t.Errorf("Diff with Ignore(Type): want an error, got nil")
+	}
+}
+
+// TestDiff9P compares a tree with the same tree over 9P, and then with
+// a different one.
+func TestDiff9P(t *testing.T) {
+	n, err := iofs.NewServer(changed(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := ninetest.Pipe(t, n)
+	if _, _, err := c.CallTversion(8192, "9P2000"); err != nil {
+		t.Fatalf("CallTversion: want nil, got %v", err)
+	}
+	fid, _, err := c.Attach("glenda", "", nil)
+	if err != nil {
+		t.Fatalf("Attach: want nil, got %v", err)
+	}
+
+	// iofs serves files without write permission, so modes differ.
+	if d := diffs(t, changed(), c.FS(fid), Contents(sha256.New), Ignore(Mode)); len(d) != 0 {
+		t.Errorf("Diff with itself over 9P: want none, got %v", d)
+	}
+	want := "[modified content (content) modified d/inner (size,mtime) removed gone modified kind (type) added new removed only]"
+	if got := fmt.Sprint(diffs(t, tree(), c.FS(fid), Contents(sha256.New), Ignore(Mode))); got != want {
+		t.Errorf("Diff over 9P: want\n\t%v\ngot\n\t%v", want, got)
+	}
+}
@@ -0,0 +1,318 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package iofs serves an fs.FS over 9P, read-only: an embed.FS, a
+// zip.Reader, an os.DirFS, or anything else implementing fs.FS. Every
+// connection to a server sees the same files.
+package iofs
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+
+	"harvey-os.org/internal/backend"
+	"harvey-os.org/pkg/ninep"
+	"harvey-os.org/pkg/ninep/protocol"
+)
+
+// errReadOnly is the error for anything which would change a file.
+var errReadOnly = protocol.ErrReadOnly
+
+// server is what every connection to a server shares.
+type server struct {
+	fsys        fs.FS
+	user, group string
+	qids        *ninep.QIDPool
+}
+
+// Opt is an option for NewServer.
+type Opt func(*server) error
+
+// Owner sets the owner and group of every file, which are "none" by
+// default, as fs.FS has no owners.
+func Owner(user, group string) Opt {
+	return func(s *server) error {
+		s.user, s.group = user, group
+		return nil
+	}
+}
+
+// NewServer serves fsys.
+func NewServer(fsys fs.FS, fsopts []Opt, opts ...protocol.ListenerOpt) (*protocol.Listener, error) {
+	qids, err := ninep.NewQIDPool()
+	if err != nil {
+		return nil, err
+	}
+	s := &server{fsys: fsys, user: "none", group: "none", qids: qids}
+	for _, o := range fsopts {
+		if err := o(s); err != nil {
+			return nil, err
+		}
+	}
+	return protocol.NewListener(func() protocol.NineServer {
+		return &fileServer{server: s}
+	}, opts...)
+}
+
+// dir returns the Dir of the file name, described by fi. Nothing can be
+// written, so the permissions say so.
+func (s *server) dir(name string, fi fs.FileInfo) protocol.Dir {
+	var d protocol.Dir
+	d.QID = s.qid(name, fi)
+	d.Mode = uint32(fi.Mode().Perm() &^ 0222)
+	if fi.IsDir() {
+		d.Mode |= protocol.DMDIR
+	} else {
+		d.Length = uint64(fi.Size())
+	}
+	t := uint32(fi.ModTime().Unix())
+	d.Atime, d.Mtime = t, t
+	d.Name = fi.Name()
+	if name == "." {
+		d.Name = "/"
+	}
+	d.User, d.Group, d.ModUser = s.user, s.group, s.user
+	return d
+}
+
+// qid returns the QID of the file name, described by fi. Its version is
+// its modification time, which is all an fs.FS says about changes.
+func (s *server) qid(name string, fi fs.FileInfo) protocol.QID {
+	q := protocol.QID{Path: s.qids.Path(ninep.PathKey(name)), Version: uint32(fi.ModTime().Unix())}
+	if fi.IsDir() {
+		q.Type = protocol.QTDIR
+	}
+	return q
+}
+
+type fid struct {
+	// name is the file's name in the fs.FS: "." for the root.
+	name string
+	fi   fs.FileInfo
+
+	// f is the open file, read from off.
+	f   fs.File
+	off int64
+
+	// ents are the directory's entries, as of the last read at 0.
+	ents []fs.DirEntry
+	dirs backend.Dirs
+}
+
+// fileServer is the NineServer for one connection.
+type fileServer struct {
+	*server
+	backend.Base
+
+	// mu guards below
+	mu   sync.Mutex
+	fids backend.Fids
+}
+
+func (s *fileServer) getFid(f protocol.FID) (*fid, error) {
+	v, err := s.fids.Get(f)
+	if err != nil {
+		return nil, err
+	}
+	return v.(*fid), nil
+}
+
+func (s *fileServer) Rattach(f protocol.FID, afid protocol.FID, uname string, aname string) (protocol.QID, error) {
+	fi, err := fs.Stat(s.fsys, ".")
+	if err != nil {
+		return protocol.QID{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.fids.Attach(f, afid, &fid{name: ".", fi: fi}); err != nil {
+		return protocol.QID{}, err
+	}
+	return s.qid(".", fi), nil
+}
+
+func (s *fileServer) Rwalk(f protocol.FID, newfid protocol.FID, paths []string) ([]protocol.QID, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, err := s.getFid(f)
+	if err != nil {
+		return nil, err
+	}
+	if i.f != nil {
+		return nil, protocol.Errorf(protocol.ErrInvalid, "walk of open fid %d", f)
+	}
+	name, fi := i.name, i.fi
+	var q []protocol.QID
+	for _, p := range paths {
+		if p == "" || strings.ContainsRune(p, '/') {
+			if len(q) == 0 {
+				return nil, protocol.Errorf(protocol.ErrInvalid, "walk to %q", p)
+			}
+			break
+		}
+		if !fi.IsDir() {
+			break
+		}
+		n := path.Join(name, p)
+		if p == ".." {
+			// path.Join has done it, but not above the root.
+			if n == ".." {
+				n = "."
+			}
+		} else if p == "." || !fs.ValidPath(n) {
+			break
+		}
+		if fi, err = fs.Stat(s.fsys, n); err != nil {
+			break
+		}
+		name = n
+		q = append(q, s.qid(name, fi))
+	}
+	q, done, err := backend.Walked(q, paths)
+	if !done {
+		return q, err
+	}
+	if err := s.fids.Set(newfid, f, &fid{name: name, fi: fi}); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+func (s *fileServer) Ropen(f protocol.FID, mode protocol.Mode) (protocol.QID, protocol.MaxSize, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, err := s.getFid(f)
+	if err != nil {
+		return protocol.QID{}, 0, err
+	}
+	if i.f != nil {
+		return protocol.QID{}, 0, protocol.Errorf(protocol.ErrInvalid, "fid already open")
+	}
+	if mode.Writes() {
+		return protocol.QID{}, 0, errReadOnly
+	}
+	if i.f, err = s.fsys.Open(i.name); err != nil {
+		return protocol.QID{}, 0, err
+	}
+	i.off = 0
+	return s.qid(i.name, i.fi), 0, nil
+}
+
+func (s *fileServer) Rcreate(f protocol.FID, name string, perm protocol.Perm, mode protocol.Mode) (protocol.QID, protocol.MaxSize, error) {
+	return protocol.QID{}, 0, errReadOnly
+}
+
+func (s *fileServer) Rclunk(f protocol.FID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, err := s.fids.Clunk(f)
+	if err != nil {
+		return err
+	}
+	if i := v.(*fid); i.f != nil {
+		i.f.Close()
+	}
+	return nil
+}
+
+func (s *fileServer) Rstat(f protocol.FID) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, err := s.getFid(f)
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	protocol.Marshaldir(&b, s.dir(i.name, i.fi))
+	return b.Bytes(), nil
+}
+
+func (s *fileServer) Rwstat(f protocol.FID, b []byte) error {
+	return errReadOnly
+}
+
+func (s *fileServer) Rremove(f protocol.FID) error {
+	// The fid is clunked even if the remove fails.
+	s.Rclunk(f)
+	return errReadOnly
+}
+
+func (s *fileServer) Rread(f protocol.FID, o protocol.Offset, c protocol.Count) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, err := s.getFid(f)
+	if err != nil {
+		return nil, err
+	}
+	if i.f == nil {
+		return nil, protocol.Errorf(protocol.ErrNotOpen, "fid %d for reading", f)
+	}
+	if !i.fi.IsDir() {
+		return i.read(s.fsys, int64(o), int(c))
+	}
+
+	return i.dirs.Read(o, c, func() (err error) {
+		i.ents, err = fs.ReadDir(s.fsys, i.name)
+		return err
+	}, func(n int) (protocol.Dir, error) {
+		if n >= len(i.ents) {
+			return protocol.Dir{}, io.EOF
+		}
+		// A file which went away since the directory was read is
+		// skipped.
+		e := i.ents[n]
+		fi, err := e.Info()
+		if err != nil {
+			return protocol.Dir{}, err
+		}
+		return s.dir(path.Join(i.name, e.Name()), fi), nil
+	})
+}
+
+// read reads up to c bytes at o. Files which can't read at an offset,
+// or seek, as those in a zip.Reader can't, are read in order: reads
+// behind the last one start again from the beginning.
+func (i *fid) read(fsys fs.FS, o int64, c int) ([]byte, error) {
+	b := make([]byte, c)
+	if r, ok := i.f.(io.ReaderAt); ok {
+		n, err := r.ReadAt(b, o)
+		if err == io.EOF {
+			err = nil
+		}
+		return b[:n], err
+	}
+	if o != i.off {
+		if sk, ok := i.f.(io.Seeker); ok {
+			if _, err := sk.Seek(o, io.SeekStart); err != nil {
+				return nil, err
+			}
+		} else {
+			if o < i.off {
+				f, err := fsys.Open(i.name)
+				if err != nil {
+					return nil, err
+				}
+				i.f.Close()
+				i.f, i.off = f, 0
+			}
+			if _, err := io.CopyN(io.Discard, i.f, o-i.off); err != nil && err != io.EOF {
+				return nil, err
+			}
+		}
+		i.off = o
+	}
+	n, err := io.ReadFull(i.f, b)
+	i.off += int64(n)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	return b[:n], err
+}
+
+func (s *fileServer) Rwrite(f protocol.FID, o protocol.Offset, b []byte) (protocol.Count, error) {
+	return 0, errReadOnly
+}
@@ -0,0 +1,137 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package iofs
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"harvey-os.org/internal/ninetest"
+	"harvey-os.org/pkg/ninep/protocol"
+)
+
+// newTestClient returns a client of fsys, attached with fid 0.
+func newTestClient(t *testing.T, fsys fs.FS) *protocol.Client {
+	n, err := NewServer(fsys, []Opt{Owner("glenda", "sys")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := ninetest.Pipe(t, n)
+	ninetest.Attach(t, c, "glenda")
+	return c
+}
+
+var testFiles = map[string]string{
+	"readme.txt":        "This archive contains some text files.",
+	"foo/gopher.txt":    "Gopher names:\nGeorge\nGeoffrey\nGonzo",
+	"foo/bar/hello.txt": "hello world!",
+}
+
+func testFS(t *testing.T, fsys fs.FS) {
+	c := newTestClient(t, fsys)
+
+	for name, want := range testFiles {
+		f, err := c.Open(0, strings.Split(name, "/"), protocol.OREAD)
+		if err != nil {
+			t.Fatalf("Open(%v): want nil, got %v", name, err)
+		}
+		got, err := io.ReadAll(f)
+		if err != nil || string(got) != want {
+			t.Errorf("ReadAll(%v): got %q, %v, want %q, nil", name, got, err, want)
+		}
+		// Back to the start, and then a skip forward.
+		b := make([]byte, 5)
+		if n, err := f.ReadAt(b, 0); err != nil || string(b[:n]) != want[:5] {
+			t.Errorf("ReadAt(%v, 0): got %q, %v, want %q", name, b[:n], err, want[:5])
+		}
+		if n, err := f.ReadAt(b, 7); err != nil || string(b[:n]) != want[7:12] {
+			t.Errorf("ReadAt(%v, 7): got %q, %v, want %q", name, b[:n], err, want[7:12])
+		}
+		f.Close()
+	}
+
+	d, err := c.Open(0, []string{"foo"}, protocol.OREAD)
+	if err != nil {
+		t.Fatalf("Open(foo): want nil, got %v", err)
+	}
+	dirs, err := d.Dirread()
+	if err != nil && err != io.EOF {
+		t.Fatalf("Dirread(foo): want nil, got %v", err)
+	}
+	var names []string
+	for _, e := range dirs {
+		names = append(names, e.Name)
+		if e.User != "glenda" || e.Mode&0222 != 0 {
+			t.Errorf("%v: got user %v, mode %o, want glenda, not writable", e.Name, e.User, e.Mode)
+		}
+	}
+	if got, want := strings.Join(names, " "), "bar gopher.txt"; got != want {
+		t.Errorf("Dirread(foo): got %v, want %v", got, want)
+	}
+	d.Close()
+
+	if _, err := c.CallTwalk(0, 1, []string{"foo", "..", "..", "readme.txt"}); err != nil {
+		t.Errorf("CallTwalk(foo/../../readme.txt): want nil, got %v", err)
+	}
+	if _, err := c.CallTwalk(0, 2, []string{"nope"}); err == nil {
+		t.Errorf("CallTwalk(nope): want error, got nil")
+	}
+	// Each name is one step, so one with a / in it, or none at all, is
+	// not a name.
+	for _, p := range [][]string{{"foo/bar"}, {""}, {"foo", "bar/hello.txt"}} {
+		q, err := c.CallTwalk(0, 3, p)
+		if len(p) == 1 && !errors.Is(err, protocol.ErrInvalid) {
+			t.Errorf("CallTwalk(%q): got %v, want %v", p, err, protocol.ErrInvalid)
+		}
+		if len(q) == len(p) {
+			t.Errorf("CallTwalk(%q): got %d qids, want fewer", p, len(q))
+		}
+	}
+	if _, _, err := c.CallTopen(1, protocol.OWRITE); !errors.Is(err, protocol.ErrReadOnly) {
+		t.Errorf("CallTopen(readme.txt, OWRITE): got %v, want %v", err, protocol.ErrReadOnly)
+	}
+	if _, _, err := c.CallTcreate(0, "new", 0666, protocol.OWRITE); !errors.Is(err, protocol.ErrReadOnly) {
+		t.Errorf("CallTcreate(new): got %v, want %v", err, protocol.ErrReadOnly)
+	}
+	if err := c.CallTremove(1); !errors.Is(err, protocol.ErrReadOnly) {
+		t.Errorf("CallTremove(readme.txt): got %v, want %v", err, protocol.ErrReadOnly)
+	}
+}
+
+func TestMapFS(t *testing.T) {
+	m := fstest.MapFS{}
+	for name, data := range testFiles {
+		m[name] = &fstest.MapFile{Data: []byte(data), Mode: 0644, ModTime: time.Unix(1e9, 0)}
+	}
+	testFS(t, m)
+}
+
+// TestZip serves a zip.Reader, whose files can only be read in order.
+func TestZip(t *testing.T) {
+	var b bytes.Buffer
+	w := zip.NewWriter(&b)
+	for name, data := range testFiles {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatalf("Create(%v): %v", name, err)
+		}
+		f.Write([]byte(data))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	r, err := zip.NewReader(bytes.NewReader(b.Bytes()), int64(b.Len()))
+	if err != nil {
+		t.Fatalf("NewReader: %v", err)
+	}
+	testFS(t, r)
+}
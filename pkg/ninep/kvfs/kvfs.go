@@ -0,0 +1,677 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package kvfs serves a key/value store over 9P, with each key a file
+// whose contents are its value, so that a store such as etcd or redis
+// can be given a 9P front end by writing the four methods of KV.
+//
+// By default the keys are the files of the root directory, and keys
+// which are not file names, such as those with a / in them, are not
+// seen. With Dirs, keys are paths instead: a/b/c is the file c in the
+// directory b in the directory a. Directories exist while there are keys
+// in them; empty ones made with create last only as long as the server.
+//
+// A file opened for writing is read into memory, and is written back to
+// the store when it is clunked, as a whole, so values change all at once.
+// The store keeps no times, owners or permissions, so files have none of
+// their own.
+package kvfs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"harvey-os.org/internal/backend"
+	"harvey-os.org/pkg/ninep"
+	"harvey-os.org/pkg/ninep/protocol"
+)
+
+// KV is a key/value store. Its methods may be called concurrently.
+type KV interface {
+	// Get returns the value of key, or an error wrapping ErrNotFound.
+	Get(key string) ([]byte, error)
+	// Put sets the value of key, creating it if need be.
+	Put(key string, value []byte) error
+	// Delete removes key, or returns an error wrapping ErrNotFound.
+	Delete(key string) error
+	// List returns the keys which start with prefix, in any order.
+	List(prefix string) ([]string, error)
+}
+
+// ErrNotFound is the error for a key which is not in the store.
+var ErrNotFound = protocol.Errorf(protocol.ErrNotExist, "key not found")
+
+// server is what every connection to a server shares.
+type server struct {
+	kv          KV
+	sep         string
+	user, group string
+	start       uint32
+	qids        *ninep.QIDPool
+	clock       protocol.Clock
+
+	// mu guards below
+	mu sync.Mutex
+	// meta has the version and modification time of keys which have
+	// been changed through the server.
+	meta map[string]meta
+	// made has the directories made with create, which may be
+	// empty.
+	made map[string]bool
+}
+
+type meta struct {
+	version uint32
+	mtime   uint32
+}
+
+// Opt is an option for NewServer.
+type Opt func(*server) error
+
+// Owner sets the owner and group of every file, which are "none" by
+// default.
+func Owner(user, group string) Opt {
+	return func(s *server) error {
+		s.user, s.group = user, group
+		return nil
+	}
+}
+
+// Dirs makes keys paths, of names separated by sep, usually "/".
+func Dirs(sep string) Opt {
+	return func(s *server) error {
+		if sep == "" {
+			return fmt.Errorf("empty directory separator")
+		}
+		s.sep = sep
+		return nil
+	}
+}
+
+// Clock sets the clock which modification times come from. By default,
+// it is protocol.SystemClock.
+func Clock(clock protocol.Clock) Opt {
+	return func(s *server) error {
+		s.clock = clock
+		return nil
+	}
+}
+
+// NewServer serves kv.
+func NewServer(kv KV, fsopts []Opt, opts ...protocol.ListenerOpt) (*protocol.Listener, error) {
+	qids, err := ninep.NewQIDPool()
+	if err != nil {
+		return nil, err
+	}
+	s := &server{
+		kv:    kv,
+		user:  "none",
+		group: "none",
+		qids:  qids,
+		meta:  map[string]meta{},
+		made:  map[string]bool{},
+		clock: protocol.SystemClock,
+	}
+	for _, o := range fsopts {
+		if err := o(s); err != nil {
+			return nil, err
+		}
+	}
+	s.start = uint32(s.clock.Now().Unix())
+	return protocol.NewListener(func() protocol.NineServer {
+		return &fileServer{server: s}
+	}, opts...)
+}
+
+// child returns the key of name in the directory dir, "" being the root.
+func (s *server) child(dir, name string) string {
+	if dir == "" {
+		return name
+	}
+	return dir + s.sep + name
+}
+
+// prefix returns the prefix of the keys in the directory dir.
+func (s *server) prefix(dir string) string {
+	if dir == "" {
+		return ""
+	}
+	return dir + s.sep
+}
+
+// parent returns the directory holding key.
+func (s *server) parent(key string) string {
+	if s.sep == "" {
+		return ""
+	}
+	if i := strings.LastIndex(key, s.sep); i >= 0 {
+		return key[:i]
+	}
+	return ""
+}
+
+// base returns the file name of key.
+func (s *server) base(key string) string {
+	if key == "" {
+		return "/"
+	}
+	return key[len(s.prefix(s.parent(key))):]
+}
+
+// validName reports whether name can be a file name.
+func (s *server) validName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.Contains(name, "/") &&
+		(s.sep == "" || !strings.Contains(name, s.sep))
+}
+
+// entry is a file in a directory.
+type entry struct {
+	name string
+	dir  bool
+}
+
+// entries returns the files in the directory dir, sorted.
+func (s *server) entries(dir string) ([]entry, error) {
+	p := s.prefix(dir)
+	keys, err := s.kv.List(p)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	var es []entry
+	add := func(name string, isDir bool) {
+		if !seen[name] && s.validName(name) {
+			seen[name] = true
+			es = append(es, entry{name: name, dir: isDir})
+		}
+	}
+	for _, k := range keys {
+		name := strings.TrimPrefix(k, p)
+		if s.sep != "" {
+			if i := strings.Index(name, s.sep); i >= 0 {
+				add(name[:i], true)
+				continue
+			}
+		}
+		add(name, false)
+	}
+	s.mu.Lock()
+	for d := range s.made {
+		if d != "" && s.parent(d) == dir {
+			add(s.base(d), true)
+		}
+	}
+	s.mu.Unlock()
+	sort.Slice(es, func(i, j int) bool { return es[i].name < es[j].name })
+	return es, nil
+}
+
+// isDir reports whether key is a directory.
+func (s *server) isDir(key string) (bool, error) {
+	if key == "" {
+		return true, nil
+	}
+	if s.sep == "" {
+		return false, nil
+	}
+	s.mu.Lock()
+	made := s.made[key]
+	s.mu.Unlock()
+	if made {
+		return true, nil
+	}
+	keys, err := s.kv.List(key + s.sep)
+	return len(keys) != 0, err
+}
+
+// exists reports whether key is a file, or a directory.
+func (s *server) exists(key string) (bool, bool, error) {
+	if _, err := s.kv.Get(key); err == nil {
+		return true, false, nil
+	} else if !errors.Is(err, ErrNotFound) {
+		return false, false, err
+	}
+	d, err := s.isDir(key)
+	return d, d, err
+}
+
+func (s *server) qid(key string, isDir bool) protocol.QID {
+	if isDir {
+		return protocol.QID{Type: protocol.QTDIR, Path: s.qids.Path(ninep.PathKey(key))}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return protocol.QID{Path: s.qids.Path(ninep.ObjectKey(key)), Version: s.meta[key].version}
+}
+
+// changed notes that the value of key has changed.
+func (s *server) changed(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := s.meta[key]
+	m.version++
+	m.mtime = uint32(s.clock.Now().Unix())
+	s.meta[key] = m
+}
+
+// put sets the value of key, noting the change.
+func (s *server) put(key string, value []byte) error {
+	if err := s.kv.Put(key, value); err != nil {
+		return err
+	}
+	s.changed(key)
+	return nil
+}
+
+// dir returns the Dir of key.
+func (s *server) dir(key string, isDir bool) (protocol.Dir, error) {
+	var d protocol.Dir
+	d.QID = s.qid(key, isDir)
+	d.Mode = 0666
+	if isDir {
+		d.Mode = protocol.DMDIR | 0777
+	} else {
+		v, err := s.kv.Get(key)
+		if err != nil {
+			return d, err
+		}
+		d.Length = uint64(len(v))
+	}
+	s.mu.Lock()
+	t := s.meta[key].mtime
+	s.mu.Unlock()
+	if t == 0 {
+		t = s.start
+	}
+	d.Atime, d.Mtime = t, t
+	d.Name = s.base(key)
+	d.User, d.Group, d.ModUser = s.user, s.group, s.user
+	return d, nil
+}
+
+type fid struct {
+	key   string
+	isDir bool
+
+	open bool
+	mode protocol.Mode
+
+	// data is the value of an open file, which is put back if dirty.
+	data  []byte
+	dirty bool
+
+	// ents are the directory's entries, as of the last read at 0.
+	ents []entry
+	dirs backend.Dirs
+}
+
+// fileServer is the NineServer for one connection.
+type fileServer struct {
+	*server
+	backend.Base
+
+	// fmu guards the fids, and is held for each request, so that
+	// requests to the same fid do not race.
+	fmu  sync.Mutex
+	fids backend.Fids
+}
+
+func (s *fileServer) getFid(f protocol.FID) (*fid, error) {
+	v, err := s.fids.Get(f)
+	if err != nil {
+		return nil, err
+	}
+	return v.(*fid), nil
+}
+
+// clunk forgets f, returning its fid.
+func (s *fileServer) clunk(f protocol.FID) (*fid, error) {
+	v, err := s.fids.Clunk(f)
+	if err != nil {
+		return nil, err
+	}
+	return v.(*fid), nil
+}
+
+func (s *fileServer) Rattach(f protocol.FID, afid protocol.FID, uname string, aname string) (protocol.QID, error) {
+	s.fmu.Lock()
+	defer s.fmu.Unlock()
+	if err := s.fids.Attach(f, afid, &fid{isDir: true}); err != nil {
+		return protocol.QID{}, err
+	}
+	return s.qid("", true), nil
+}
+
+func (s *fileServer) Rwalk(f protocol.FID, newfid protocol.FID, paths []string) ([]protocol.QID, error) {
+	s.fmu.Lock()
+	defer s.fmu.Unlock()
+	i, err := s.getFid(f)
+	if err != nil {
+		return nil, err
+	}
+	if i.open {
+		return nil, protocol.Errorf(protocol.ErrInvalid, "walk of open fid %d", f)
+	}
+	key, isDir := i.key, i.isDir
+	var q []protocol.QID
+	for _, p := range paths {
+		if !isDir {
+			break
+		}
+		if p == ".." {
+			key = s.parent(key)
+		} else {
+			if !s.validName(p) {
+				break
+			}
+			k := s.child(key, p)
+			ok, d, err := s.exists(k)
+			if err != nil || !ok {
+				break
+			}
+			key, isDir = k, d
+		}
+		q = append(q, s.qid(key, isDir))
+	}
+	q, done, err := backend.Walked(q, paths)
+	if !done {
+		return q, err
+	}
+	if err := s.fids.Set(newfid, f, &fid{key: key, isDir: isDir}); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+func (s *fileServer) Ropen(f protocol.FID, mode protocol.Mode) (protocol.QID, protocol.MaxSize, error) {
+	s.fmu.Lock()
+	defer s.fmu.Unlock()
+	i, err := s.getFid(f)
+	if err != nil {
+		return protocol.QID{}, 0, err
+	}
+	if err := s.open(i, mode); err != nil {
+		return protocol.QID{}, 0, err
+	}
+	return s.qid(i.key, i.isDir), 0, nil
+}
+
+// open opens i, reading its value, or emptying it for OTRUNC.
+func (s *fileServer) open(i *fid, mode protocol.Mode) error {
+	if i.open {
+		return protocol.Errorf(protocol.ErrInvalid, "fid already open")
+	}
+	if i.isDir {
+		// A directory may be removed on clunk, but not written.
+		if (mode &^ protocol.ORCLOSE).Writes() {
+			return protocol.Errorf(protocol.ErrIsDir, "%v", s.base(i.key))
+		}
+		i.open, i.mode = true, mode
+		return nil
+	}
+	if mode&protocol.OTRUNC != 0 {
+		i.data, i.dirty = nil, true
+	} else {
+		v, err := s.kv.Get(i.key)
+		if err != nil {
+			return err
+		}
+		i.data = append([]byte(nil), v...)
+	}
+	i.open, i.mode = true, mode
+	return nil
+}
+
+func (s *fileServer) Rcreate(f protocol.FID, name string, perm protocol.Perm, mode protocol.Mode) (protocol.QID, protocol.MaxSize, error) {
+	s.fmu.Lock()
+	defer s.fmu.Unlock()
+	i, err := s.getFid(f)
+	if err != nil {
+		return protocol.QID{}, 0, err
+	}
+	switch {
+	case i.open:
+		return protocol.QID{}, 0, protocol.Errorf(protocol.ErrInvalid, "fid already open")
+	case !i.isDir:
+		return protocol.QID{}, 0, protocol.Errorf(protocol.ErrNotDir, "%v", s.base(i.key))
+	case !s.validName(name):
+		return protocol.QID{}, 0, protocol.Errorf(protocol.ErrInvalid, "name %q", name)
+	}
+	key := s.child(i.key, name)
+	if ok, _, err := s.exists(key); err != nil {
+		return protocol.QID{}, 0, err
+	} else if ok {
+		return protocol.QID{}, 0, protocol.Errorf(protocol.ErrExist, "%v", name)
+	}
+	if uint32(perm)&protocol.DMDIR != 0 {
+		if s.sep == "" {
+			return protocol.QID{}, 0, protocol.Errorf(protocol.ErrNotSupported, "%v: directory in a flat store", name)
+		}
+		s.mu.Lock()
+		s.made[key] = true
+		s.mu.Unlock()
+		i.key = key
+	} else {
+		// The key is made now, so that it can be seen while it is
+		// being written.
+		if err := s.put(key, nil); err != nil {
+			return protocol.QID{}, 0, err
+		}
+		i.key, i.isDir = key, false
+	}
+	if err := s.open(i, mode); err != nil {
+		return protocol.QID{}, 0, err
+	}
+	return s.qid(i.key, i.isDir), 0, nil
+}
+
+// flush puts back the value of i, if it has been changed.
+func (s *fileServer) flush(i *fid) error {
+	if !i.dirty {
+		return nil
+	}
+	i.dirty = false
+	// The store may keep what it is given, and the fid writes on.
+	return s.put(i.key, append([]byte(nil), i.data...))
+}
+
+func (s *fileServer) Rclunk(f protocol.FID) error {
+	s.fmu.Lock()
+	defer s.fmu.Unlock()
+	i, err := s.clunk(f)
+	if err != nil {
+		return err
+	}
+	if i.open && i.mode&protocol.ORCLOSE != 0 {
+		return s.remove(i)
+	}
+	return s.flush(i)
+}
+
+func (s *fileServer) Rstat(f protocol.FID) ([]byte, error) {
+	s.fmu.Lock()
+	defer s.fmu.Unlock()
+	i, err := s.getFid(f)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.flush(i); err != nil {
+		return nil, err
+	}
+	d, err := s.dir(i.key, i.isDir)
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	protocol.Marshaldir(&b, d)
+	return b.Bytes(), nil
+}
+
+func (s *fileServer) Rwstat(f protocol.FID, b []byte) error {
+	s.fmu.Lock()
+	defer s.fmu.Unlock()
+	i, err := s.getFid(f)
+	if err != nil {
+		return err
+	}
+	d, err := protocol.Unmarshaldir(bytes.NewBuffer(b))
+	if err != nil {
+		return err
+	}
+	if err := s.flush(i); err != nil {
+		return err
+	}
+	cur, err := s.dir(i.key, i.isDir)
+	if err != nil {
+		return err
+	}
+	// Check everything before changing anything, since a wstat
+	// must be done completely or not at all. The store keeps no
+	// times, so changes to them are ignored, rather than failing the
+	// programs which set them, such as cp.
+	rename := d.Name != "" && d.Name != cur.Name
+	if rename {
+		if i.isDir || i.open {
+			return protocol.Errorf(protocol.ErrNotSupported, "%v: rename of a directory, or of an open file", cur.Name)
+		}
+		if !s.validName(d.Name) {
+			return protocol.Errorf(protocol.ErrInvalid, "name %q", d.Name)
+		}
+		if ok, _, err := s.exists(s.child(s.parent(i.key), d.Name)); err != nil {
+			return err
+		} else if ok {
+			return protocol.Errorf(protocol.ErrExist, "%v", d.Name)
+		}
+	}
+	if d.Mode != ^uint32(0) && d.Mode != cur.Mode {
+		return protocol.Errorf(protocol.ErrNotSupported, "%v: change of permissions", cur.Name)
+	}
+	if (d.User != "" && d.User != cur.User) || (d.Group != "" && d.Group != cur.Group) {
+		return protocol.Errorf(protocol.ErrNotSupported, "%v: change of owner", cur.Name)
+	}
+	if d.Length != ^uint64(0) && d.Length != cur.Length && i.isDir {
+		return protocol.Errorf(protocol.ErrIsDir, "%v", cur.Name)
+	}
+
+	if d.Length != ^uint64(0) && d.Length != cur.Length {
+		v, err := s.kv.Get(i.key)
+		if err != nil {
+			return err
+		}
+		v = append([]byte(nil), v...)
+		if d.Length < uint64(len(v)) {
+			v = v[:d.Length]
+		} else {
+			v = append(v, make([]byte, int(d.Length)-len(v))...)
+		}
+		if err := s.put(i.key, v); err != nil {
+			return err
+		}
+	}
+	if rename {
+		v, err := s.kv.Get(i.key)
+		if err != nil {
+			return err
+		}
+		key := s.child(s.parent(i.key), d.Name)
+		if err := s.put(key, v); err != nil {
+			return err
+		}
+		if err := s.kv.Delete(i.key); err != nil {
+			return err
+		}
+		s.qids.Rename(ninep.ObjectKey(i.key), ninep.ObjectKey(key))
+		i.key = key
+	}
+	return nil
+}
+
+func (s *fileServer) Rremove(f protocol.FID) error {
+	s.fmu.Lock()
+	defer s.fmu.Unlock()
+	// The fid is clunked even if the remove fails.
+	i, err := s.clunk(f)
+	if err != nil {
+		return err
+	}
+	return s.remove(i)
+}
+
+func (s *fileServer) remove(i *fid) error {
+	if i.key == "" {
+		return protocol.Errorf(protocol.ErrNotPermitted, "remove of the root")
+	}
+	if !i.isDir {
+		return s.kv.Delete(i.key)
+	}
+	es, err := s.entries(i.key)
+	if err != nil {
+		return err
+	}
+	if len(es) != 0 {
+		return protocol.Errorf(protocol.ErrNotEmpty, "%v", s.base(i.key))
+	}
+	s.mu.Lock()
+	delete(s.made, i.key)
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *fileServer) Rread(f protocol.FID, o protocol.Offset, c protocol.Count) ([]byte, error) {
+	s.fmu.Lock()
+	defer s.fmu.Unlock()
+	i, err := s.getFid(f)
+	if err != nil {
+		return nil, err
+	}
+	if !i.open || i.mode&3 == protocol.OWRITE {
+		return nil, protocol.Errorf(protocol.ErrNotOpen, "fid %d for reading", f)
+	}
+	if !i.isDir {
+		if o >= protocol.Offset(len(i.data)) {
+			return nil, nil
+		}
+		d := i.data[o:]
+		if protocol.Count(len(d)) > c {
+			d = d[:c]
+		}
+		return append([]byte{}, d...), nil
+	}
+
+	return i.dirs.Read(o, c, func() (err error) {
+		i.ents, err = s.entries(i.key)
+		return err
+	}, func(n int) (protocol.Dir, error) {
+		if n >= len(i.ents) {
+			return protocol.Dir{}, io.EOF
+		}
+		// A key which went away since the directory was read is
+		// skipped.
+		e := i.ents[n]
+		return s.dir(s.child(i.key, e.name), e.dir)
+	})
+}
+
+func (s *fileServer) Rwrite(f protocol.FID, o protocol.Offset, b []byte) (protocol.Count, error) {
+	s.fmu.Lock()
+	defer s.fmu.Unlock()
+	i, err := s.getFid(f)
+	if err != nil {
+		return 0, err
+	}
+	if !i.open || i.mode&3 == protocol.OREAD || i.mode&3 == protocol.OEXEC {
+		return 0, protocol.Errorf(protocol.ErrNotOpen, "fid %d for writing", f)
+	}
+	if e := int(o) + len(b); e > len(i.data) {
+		i.data = append(i.data, make([]byte, e-len(i.data))...)
+	}
+	copy(i.data[o:], b)
+	i.dirty = true
+	return protocol.Count(len(b)), nil
+}
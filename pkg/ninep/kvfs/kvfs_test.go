@@ -0,0 +1,222 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package kvfs
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"harvey-os.org/internal/ninetest"
+	"harvey-os.org/pkg/ninep/protocol"
+)
+
+// mapKV is a KV in memory.
+type mapKV struct {
+	mu sync.Mutex
+	m  map[string][]byte
+}
+
+func (kv *mapKV) Get(key string) ([]byte, error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	v, ok := kv.m[key]
+	if !ok {
+		return nil, fmt.Errorf("%v: %w", key, ErrNotFound)
+	}
+	return v, nil
+}
+
+func (kv *mapKV) Put(key string, value []byte) error {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	kv.m[key] = value
+	return nil
+}
+
+func (kv *mapKV) Delete(key string) error {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	if _, ok := kv.m[key]; !ok {
+		return fmt.Errorf("%v: %w", key, ErrNotFound)
+	}
+	delete(kv.m, key)
+	return nil
+}
+
+func (kv *mapKV) List(prefix string) ([]string, error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	var keys []string
+	for k := range kv.m {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	return keys, nil
+}
+
+// newTestClient returns a client of kv, attached with fid 0.
+func newTestClient(t *testing.T, kv KV, fsopts ...Opt) *protocol.Client {
+	n, err := NewServer(kv, fsopts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := ninetest.Pipe(t, n)
+	ninetest.Attach(t, c, "glenda")
+	return c
+}
+
+func readAll(t *testing.T, c *protocol.Client, name string) string {
+	f, err := c.Open(0, strings.Split(name, "/"), protocol.OREAD)
+	if err != nil {
+		t.Fatalf("Open(%v): want nil, got %v", name, err)
+	}
+	defer f.Close()
+	b, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("ReadAll(%v): want nil, got %v", name, err)
+	}
+	return string(b)
+}
+
+func dirNames(t *testing.T, c *protocol.Client, name string) string {
+	var names []string
+	f, err := c.Open(0, strings.Split(name, "/"), protocol.OREAD)
+	if name == "" {
+		f, err = c.Open(0, nil, protocol.OREAD)
+	}
+	if err != nil {
+		t.Fatalf("Open(%v): want nil, got %v", name, err)
+	}
+	defer f.Close()
+	for {
+		dirs, err := f.Dirread()
+		for _, d := range dirs {
+			if d.Mode&protocol.DMDIR != 0 {
+				d.Name += "/"
+			}
+			names = append(names, d.Name)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Dirread(%v): want nil, got %v", name, err)
+		}
+	}
+	sort.Strings(names)
+	return strings.Join(names, " ")
+}
+
+func TestFlat(t *testing.T) {
+	kv := &mapKV{m: map[string][]byte{"a": []byte("apple"), "b": []byte("banana"), "c/d": []byte("hidden")}}
+	c := newTestClient(t, kv)
+
+	if got, want := dirNames(t, c, ""), "a b"; got != want {
+		t.Errorf("root: got %q, want %q", got, want)
+	}
+	if got := readAll(t, c, "b"); got != "banana" {
+		t.Errorf("b: got %q, want banana", got)
+	}
+
+	f, err := c.Create(0, []string{"new"}, 0666, protocol.OWRITE)
+	if err != nil {
+		t.Fatalf("Create(new): want nil, got %v", err)
+	}
+	if v, ok := kv.m["new"]; !ok || len(v) != 0 {
+		t.Errorf("new after create: got %q, %v, want empty", v, ok)
+	}
+	f.Write([]byte("hello, "))
+	f.Write([]byte("world"))
+	if v := kv.m["new"]; len(v) != 0 {
+		t.Errorf("new before clunk: got %q, want empty", v)
+	}
+	f.Close()
+	if v := string(kv.m["new"]); v != "hello, world" {
+		t.Errorf("new after clunk: got %q, want hello, world", v)
+	}
+
+	if _, _, err := c.CallTcreate(0, "dir", protocol.DMDIR|0777, protocol.OREAD); err == nil {
+		t.Errorf("create of a directory in a flat store: want error, got nil")
+	}
+}
+
+func TestDirs(t *testing.T) {
+	kv := &mapKV{m: map[string][]byte{"a/b/c": []byte("abc"), "a/d": []byte("ad"), "e": []byte("e")}}
+	c := newTestClient(t, kv, Dirs("/"))
+
+	if got, want := dirNames(t, c, ""), "a/ e"; got != want {
+		t.Errorf("root: got %q, want %q", got, want)
+	}
+	if got, want := dirNames(t, c, "a"), "b/ d"; got != want {
+		t.Errorf("a: got %q, want %q", got, want)
+	}
+	if got := readAll(t, c, "a/b/c"); got != "abc" {
+		t.Errorf("a/b/c: got %q, want abc", got)
+	}
+	if _, err := c.CallTwalk(0, 1, []string{"a", "b", "..", "..", "e"}); err != nil {
+		t.Errorf("CallTwalk(a/b/../../e): want nil, got %v", err)
+	}
+	c.CallTclunk(1)
+
+	// An empty directory, and a file in it.
+	if _, err := c.CallTwalk(0, 1, nil); err != nil {
+		t.Fatalf("CallTwalk: want nil, got %v", err)
+	}
+	if _, _, err := c.CallTcreate(1, "x", protocol.DMDIR|0777, protocol.OREAD); err != nil {
+		t.Fatalf("CallTcreate(x): want nil, got %v", err)
+	}
+	c.CallTclunk(1)
+	if got, want := dirNames(t, c, "x"), ""; got != want {
+		t.Errorf("x: got %q, want %q", got, want)
+	}
+	f, err := c.Create(0, []string{"x", "y"}, 0666, protocol.OWRITE)
+	if err != nil {
+		t.Fatalf("Create(x/y): want nil, got %v", err)
+	}
+	f.Write([]byte("xy"))
+	f.Close()
+	if v := string(kv.m["x/y"]); v != "xy" {
+		t.Errorf("x/y: got %q, want xy", v)
+	}
+
+	// A directory with files in it can't be removed.
+	if _, err := c.CallTwalk(0, 1, []string{"a"}); err != nil {
+		t.Fatalf("CallTwalk(a): want nil, got %v", err)
+	}
+	if err := c.CallTremove(1); err == nil {
+		t.Errorf("CallTremove(a): want error, got nil")
+	}
+
+	// Rename, and truncate.
+	if _, err := c.CallTwalk(0, 1, []string{"a", "d"}); err != nil {
+		t.Fatalf("CallTwalk(a/d): want nil, got %v", err)
+	}
+	d := protocol.Dir{Type: ^uint16(0), Dev: ^uint32(0), Mode: ^uint32(0), Atime: ^uint32(0), Mtime: ^uint32(0), Length: 1}
+	d.QID = protocol.QID{Type: ^uint8(0), Version: ^uint32(0), Path: ^uint64(0)}
+	d.Name = "z"
+	var b bytes.Buffer
+	protocol.Marshaldir(&b, d)
+	if err := c.CallTwstat(1, b.Bytes()); err != nil {
+		t.Fatalf("CallTwstat(a/d): want nil, got %v", err)
+	}
+	if _, ok := kv.m["a/d"]; ok {
+		t.Errorf("a/d after rename: still there")
+	}
+	if v := string(kv.m["a/z"]); v != "a" {
+		t.Errorf("a/z after rename: got %q, want a", v)
+	}
+	if err := c.CallTremove(1); err != nil {
+		t.Errorf("CallTremove(a/z): want nil, got %v", err)
+	}
+	if len(kv.m) != 3 {
+		t.Errorf("store: got %v, want a/b/c, e, x/y", kv.m)
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// MarshalTauthPkt puts a Tauth in b.
+func MarshalTauthPkt(b *bytes.Buffer, t Tag, afid FID, uname, aname string) {
+	b.Reset()
+	b.Write([]byte{0, 0, 0, 0, uint8(Tauth), byte(t), byte(t >> 8)})
+	put32(b, uint32(afid))
+	for _, s := range []string{uname, aname} {
+		b.Write([]byte{byte(len(s)), byte(len(s) >> 8)})
+		b.WriteString(s)
+	}
+	putSize(b)
+}
+
+// CallTauth asks for afid to be an auth file, over which to prove that
+// uname may attach to aname. It returns the QID of the auth file.
+func (c *Client) CallTauth(afid FID, uname, aname string) (QID, error) {
+	var b bytes.Buffer
+	MarshalTauthPkt(&b, 0, afid, uname, aname)
+	r := make(chan []byte)
+	c.FromClient <- &RPCCall{b: b.Bytes(), Reply: r}
+	bb := <-r
+	if err := replyErr(bb); err != nil {
+		return QID{}, err
+	}
+	// size[4] Rauth tag[2] aqid[13]
+	if MType(bb[4]) != Rauth || len(bb) != 20 {
+		return QID{}, fmt.Errorf("Tauth: got %v of %d bytes, want Rauth", RPCNames[MType(bb[4])], len(bb))
+	}
+	d := bb[7:]
+	return QID{
+		Type:    d[0],
+		Version: uint32(d[1]) | uint32(d[2])<<8 | uint32(d[3])<<16 | uint32(d[4])<<24,
+		Path:    get64(d[5:]),
+	}, nil
+}
+
+// An Authenticator proves who the user is, by running an authentication
+// protocol with the server over the auth file of a Tauth. Each Read and
+// Write is one 9P message.
+type Authenticator func(afile AuthFile) error
+
+// An AuthFile is the auth file an Authenticator talks over.
+type AuthFile interface {
+	Read(p []byte) (int, error)
+	Write(p []byte) (int, error)
+	QID() QID
+}
+
+type authFile struct {
+	c   *Client
+	fid FID
+	qid QID
+}
+
+func (a *authFile) Read(p []byte) (int, error) {
+	if max := int(a.c.Msize) - IOHDRSZ; len(p) > max {
+		p = p[:max]
+	}
+	d, err := a.c.CallTread(a.fid, 0, Count(len(p)))
+	return copy(p, d), err
+}
+
+func (a *authFile) Write(p []byte) (int, error) {
+	if max := int(a.c.Msize) - IOHDRSZ; len(p) > max {
+		p = p[:max]
+	}
+	n, err := a.c.CallTwrite(a.fid, 0, p)
+	return int(n), err
+}
+
+func (a *authFile) QID() QID {
+	return a.qid
+}
+
+// Attach attaches to the tree aname as user, on a new fid, and returns
+// it and its QID: the root, from which to walk. The client must have
+// done a Version; if that agreed to 9P2000.u or 9P2000.L, the attach is
+// theirs, with no numeric id for user.
+//
+// If auth is not nil, it is run over the auth file of a Tauth first,
+// and the attach uses that. A server which refuses the Tauth needs no
+// authentication, as in Plan 9, and the attach goes ahead without it.
+func (c *Client) Attach(user, aname string, auth Authenticator) (FID, QID, error) {
+	afid := NOFID
+	if auth != nil {
+		f := c.GetFID()
+		if q, err := c.CallTauth(f, user, aname); err == nil {
+			defer c.CallTclunk(f)
+			if err := auth(&authFile{c: c, fid: f, qid: q}); err != nil {
+				return 0, QID{}, fmt.Errorf("authenticating %v: %v", user, err)
+			}
+			afid = f
+		}
+	}
+	root := c.GetFID()
+	var q QID
+	var err error
+	switch c.dialect {
+	case "9P2000.u", "9P2000.L":
+		q, err = c.attachDotu(root, afid, user, aname)
+	default:
+		q, err = c.CallTattach(root, afid, user, aname)
+	}
+	if err != nil {
+		return 0, QID{}, err
+	}
+	return root, q, nil
+}
+
+// attachDotu does a 9P2000.u Tattach, which 9P2000.L shares.
+func (c *Client) attachDotu(fid, afid FID, user, aname string) (QID, error) {
+	var b bytes.Buffer
+	MarshalTattachDotuPkt(&b, 0, fid, afid, user, aname, NONUNAME)
+	r := make(chan []byte)
+	c.FromClient <- &RPCCall{b: b.Bytes(), Reply: r}
+	bb := <-r
+	if err := replyErr(bb); err != nil {
+		return QID{}, err
+	}
+	if MType(bb[4]) != Rattach {
+		return QID{}, fmt.Errorf("Tattach: got %v, want Rattach", RPCNames[MType(bb[4])])
+	}
+	q, _, err := UnmarshalRattachPkt(bytes.NewBuffer(bb[5:]))
+	return q, err
+}
@@ -0,0 +1,154 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+)
+
+// authServer serves conn as far as an attach goes, wanting the answer
+// "glenda" to its challenge, written on the auth file, before it allows
+// one.
+func authServer(conn net.Conn) {
+	var (
+		b      bytes.Buffer
+		afid   = NOFID
+		authed bool
+	)
+	for {
+		var sz [4]byte
+		if _, err := io.ReadFull(conn, sz[:]); err != nil {
+			return
+		}
+		m := make([]byte, int(sz[0])|int(sz[1])<<8|int(sz[2])<<16|int(sz[3])<<24-4)
+		if _, err := io.ReadFull(conn, m); err != nil {
+			return
+		}
+		tag := Tag(m[1]) | Tag(m[2])<<8
+		r := bytes.NewBuffer(m[1:])
+		switch MType(m[0]) {
+		case Tversion:
+			MarshalRversionPkt(&b, tag, 8192, "9P2000")
+		case Tauth:
+			afid = FID(m[3]) | FID(m[4])<<8 | FID(m[5])<<16 | FID(m[6])<<24
+			b.Reset()
+			b.Write([]byte{0, 0, 0, 0, uint8(Rauth), byte(tag), byte(tag >> 8), QTAUTH})
+			put32(&b, 0)
+			put64(&b, 1)
+			putSize(&b)
+		case Tread:
+			MarshalRreadPkt(&b, tag, []byte("who are you?"))
+		case Twrite:
+			_, _, d, _, _ := UnmarshalTwritePkt(r)
+			authed = string(d) == "glenda"
+			MarshalRwritePkt(&b, tag, Count(len(d)))
+		case Tclunk:
+			MarshalRclunkPkt(&b, tag)
+		case Tattach:
+			_, a, _, _, _, _ := UnmarshalTattachPkt(r)
+			if a != afid || !authed {
+				MarshalRerrorPkt(&b, tag, "authentication required")
+				break
+			}
+			MarshalRattachPkt(&b, tag, QID{Type: QTDIR, Path: 2})
+		default:
+			MarshalRerrorPkt(&b, tag, "not supported")
+		}
+		conn.Write(b.Bytes())
+	}
+}
+
+func TestAttachAuth(t *testing.T) {
+	for _, answer := range []string{"glenda", "boyd"} {
+		p, p2 := net.Pipe()
+		go authServer(p2)
+		c, err := NewClient(func(c *Client) error {
+			c.FromNet, c.ToNet = p, p
+			c.Msize = 8192
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if _, _, _, err := c.Version(8192, "9P2000"); err != nil {
+			t.Fatalf("Version: want nil, got %v", err)
+		}
+		root, q, err := c.Attach("glenda", "", func(a AuthFile) error {
+			if a.QID().Type != QTAUTH {
+				return fmt.Errorf("auth file QID %v is not QTAUTH", a.QID())
+			}
+			var d [64]byte
+			n, err := a.Read(d[:])
+			if err != nil || string(d[:n]) != "who are you?" {
+				return fmt.Errorf("challenge: got %q, %v", d[:n], err)
+			}
+			_, err = a.Write([]byte(answer))
+			return err
+		})
+		if answer != "glenda" {
+			if err == nil {
+				t.Errorf("Attach with answer %q: want error, got nil", answer)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Attach with answer %q: want nil, got %v", answer, err)
+		}
+		if root == NOFID || q.Path != 2 {
+			t.Errorf("Attach: got fid %v, QID %v, want a fid and path 2", root, q)
+		}
+	}
+}
+
+func TestAttachNoAuth(t *testing.T) {
+	c := newExtClient(t)
+	if _, _, _, err := c.Version(8192, "9P2000"); err != nil {
+		t.Fatalf("Version: want nil, got %v", err)
+	}
+	// The server refuses Tauth, so the authenticator is never run.
+	root, _, err := c.Attach("glenda", "", func(AuthFile) error {
+		return fmt.Errorf("called")
+	})
+	if err != nil {
+		t.Fatalf("Attach: want nil, got %v", err)
+	}
+	if _, err := c.Open(root, []string{"a"}, OREAD); err != nil {
+		t.Errorf("Open(a) from the root: want nil, got %v", err)
+	}
+}
+
+func TestClientConnLost(t *testing.T) {
+	p, p2 := net.Pipe()
+	c, err := NewClient(func(c *Client) error {
+		c.FromNet, c.ToNet = p, p
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	done := make(chan error)
+	go func() {
+		done <- c.CallTclunk(1)
+	}()
+	// Let the request arrive, then lose the connection.
+	var sz [4]byte
+	if _, err := io.ReadFull(p2, sz[:]); err != nil {
+		t.Fatalf("reading request: %v", err)
+	}
+	p2.Close()
+	if err := <-done; err == nil {
+		t.Errorf("CallTclunk when the connection is lost: want error, got nil")
+	}
+	if err := c.CallTclunk(2); err == nil {
+		t.Errorf("CallTclunk after the connection is lost: want error, got nil")
+	}
+	if !c.IsDead() {
+		t.Errorf("IsDead: got false, want true")
+	}
+}
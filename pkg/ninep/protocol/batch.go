@@ -0,0 +1,208 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"bytes"
+	"fmt"
+	"syscall"
+)
+
+// The batch extension sends several requests in one message, so that
+// e.g. a walk, open and read, or a create, write and clunk, cost one
+// round trip instead of three:
+//
+//	size[4] Tbatch tag[2] count[2] count*(message)
+//	size[4] Rbatch tag[2] count[2] count*(message)
+//
+// The server does the requests in order, each as if it had come alone,
+// and stops at the first which fails, whose reply, an Rerror or Rlerror,
+// is the last. Requests after it are not done, so that a batch is all or
+// nothing in that a later request never sees the effects of an earlier
+// one which failed; what the earlier ones did stays done. The tags of
+// the requests in a batch are not used. Tversion, Tflush and Tbatch
+// can't be batched.
+const (
+	Tbatch MType = 160 + iota
+	Rbatch
+)
+
+// BatchExtension is the name of the batch extension.
+const BatchExtension = "batch"
+
+func init() {
+	if err := RegisterExtension(&Extension{
+		Name:     BatchExtension,
+		Handlers: map[MType]Dispatcher{Tbatch: dispatchBatch},
+		Names:    map[MType]string{Tbatch: "Tbatch", Rbatch: "Rbatch"},
+	}); err != nil {
+		panic(err)
+	}
+}
+
+// isError reports whether the message m is an Rerror or Rlerror.
+func isError(m []byte) bool {
+	return len(m) < 5 || MType(m[4]) == Rerror || MType(m[4]) == Rlerror
+}
+
+// putBatch puts the messages ms in b, as a Tbatch or Rbatch.
+func putBatch(b *bytes.Buffer, t MType, tag Tag, ms [][]byte) {
+	b.Reset()
+	b.Write([]byte{0, 0, 0, 0, uint8(t), byte(tag), byte(tag >> 8), byte(len(ms)), byte(len(ms) >> 8)})
+	for _, m := range ms {
+		b.Write(m)
+	}
+	d := b.Bytes()
+	l := len(d)
+	d[0], d[1], d[2], d[3] = byte(l), byte(l>>8), byte(l>>16), byte(l>>24)
+}
+
+// splitBatch splits d, the count and messages of a Tbatch or Rbatch.
+func splitBatch(d []byte) ([][]byte, error) {
+	if len(d) < 2 {
+		return nil, fmt.Errorf("short batch")
+	}
+	n := int(d[0]) | int(d[1])<<8
+	d = d[2:]
+	var ms [][]byte
+	for i := 0; i < n; i++ {
+		if len(d) < 7 {
+			return nil, fmt.Errorf("batch message %d: short message", i)
+		}
+		l := int(d[0]) | int(d[1])<<8 | int(d[2])<<16 | int(d[3])<<24
+		if l < 7 || l > len(d) {
+			return nil, fmt.Errorf("batch message %d: size %d, but %d bytes left", i, l, len(d))
+		}
+		ms = append(ms, d[:l])
+		d = d[l:]
+	}
+	if len(d) != 0 {
+		return nil, fmt.Errorf("%d bytes after the batch", len(d))
+	}
+	return ms, nil
+}
+
+// dispatchBatch does the requests of a Tbatch, with the connection's
+// dispatcher.
+func dispatchBatch(s *Server, b *bytes.Buffer, t MType) error {
+	d := b.Bytes()
+	if len(d) < 2 {
+		err := Errorf(ErrInvalid, "short Tbatch")
+		MarshalRerrorPkt(b, 0, err.Error())
+		return err
+	}
+	tag := Tag(d[0]) | Tag(d[1])<<8
+	ms, err := splitBatch(d[2:])
+	if err != nil {
+		MarshalRerrorPkt(b, tag, fmt.Sprintf("Tbatch: %v", err))
+		return err
+	}
+	// derr is the dispatcher's last error, for the connection to log.
+	var derr error
+	var replies [][]byte
+	for _, m := range ms {
+		mt := MType(m[4])
+		var r bytes.Buffer
+		switch mt {
+		case Tversion, Tflush, Tbatch:
+			MarshalRerrorPkt(&r, tag, fmt.Sprintf("%v can't be batched", RPCNames[mt]))
+		default:
+			// The tags in a batch are not used, so only fids are
+			// checked.
+			if e := badFID(mt, m[5:]); e != "" {
+				s.rerror(&r, tag, e)
+				break
+			}
+			r.Write(m[5:])
+			if err := s.D(s, &r, mt); err != nil {
+				derr = fmt.Errorf("%v: %v", RPCNames[mt], err)
+			}
+		}
+		replies = append(replies, r.Bytes())
+		if isError(r.Bytes()) {
+			break
+		}
+	}
+	putBatch(b, Rbatch, tag, replies)
+	return derr
+}
+
+// replyErr returns the error in m, an Rerror or Rlerror, or nil.
+func replyErr(m []byte) error {
+	if len(m) < 5 {
+		return fmt.Errorf("short reply")
+	}
+	switch MType(m[4]) {
+	case Rerror:
+		s, _, err := UnmarshalRerrorPkt(bytes.NewBuffer(m[5:]))
+		if err != nil {
+			// 9P2000.u adds an errno.
+			if u, _, _, uerr := UnmarshalRerrorDotuPkt(bytes.NewBuffer(m[5:])); uerr == nil {
+				return remoteError(u)
+			}
+			return err
+		}
+		return remoteError(s)
+	case Rlerror:
+		e, _, err := UnmarshalRlerrorPkt(bytes.NewBuffer(m[5:]))
+		if err != nil {
+			return err
+		}
+		return syscall.Errno(e)
+	}
+	return nil
+}
+
+// Batch sends the requests ms, each made by a Marshal*Pkt function with
+// any tag, and returns the replies, in order: in one round trip, if the
+// server agreed to the batch extension in Version and the batch fits in
+// Msize, or one at a time if not, so that callers need not care which.
+// It stops at the first request which fails, returning the replies up to
+// it and its error. The replies must fit in Msize too, which the caller
+// must see to, by keeping the counts of reads small enough.
+func (c *Client) Batch(ms ...[]byte) ([][]byte, error) {
+	size := 9
+	for _, m := range ms {
+		size += len(m)
+	}
+	if !c.HasExtension(BatchExtension) || size > int(c.Msize) {
+		var replies [][]byte
+		for _, m := range ms {
+			r := make(chan []byte)
+			c.FromClient <- &RPCCall{b: append([]byte{}, m...), Reply: r}
+			bb := <-r
+			replies = append(replies, bb)
+			if err := replyErr(bb); err != nil {
+				return replies, err
+			}
+		}
+		return replies, nil
+	}
+
+	var b bytes.Buffer
+	putBatch(&b, Tbatch, 0, ms)
+	r := make(chan []byte)
+	c.FromClient <- &RPCCall{b: b.Bytes(), Reply: r}
+	bb := <-r
+	if MType(bb[4]) != Rbatch {
+		if err := replyErr(bb); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("Tbatch: got %v, want Rbatch", RPCNames[MType(bb[4])])
+	}
+	replies, err := splitBatch(bb[7:])
+	if err != nil {
+		return nil, err
+	}
+	if len(replies) != 0 {
+		if err := replyErr(replies[len(replies)-1]); err != nil {
+			return replies, err
+		}
+	}
+	if len(replies) != len(ms) {
+		return replies, fmt.Errorf("Tbatch: %d replies to %d requests", len(replies), len(ms))
+	}
+	return replies, nil
+}
@@ -0,0 +1,99 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"bytes"
+	"testing"
+)
+
+func marshal(f func(b *bytes.Buffer)) []byte {
+	var b bytes.Buffer
+	f(&b)
+	return b.Bytes()
+}
+
+func TestBatch(t *testing.T) {
+	skipMinimal(t, "9P2000.L")
+	for _, tc := range []struct {
+		version string
+		exts    []string
+	}{
+		{"9P2000", []string{BatchExtension}},
+		{"9P2000.L", []string{BatchExtension}},
+		// Without the extension, the requests go one at a time.
+		{"9P2000", nil},
+	} {
+		c := newExtClient(t)
+		if _, _, got, err := c.Version(8192, tc.version, tc.exts...); err != nil || len(got) != len(tc.exts) {
+			t.Fatalf("Version(%v, %v): got %v, %v, want %v", tc.version, tc.exts, got, err, tc.exts)
+		}
+		open, attach := Topen, marshal(func(b *bytes.Buffer) { MarshalTattachPkt(b, 0, 0, NOFID, "glenda", "") })
+		if tc.version == "9P2000.L" {
+			open, attach = Tlopen, marshal(func(b *bytes.Buffer) { MarshalTattachDotuPkt(b, 0, 0, NOFID, "glenda", "", 1000) })
+		}
+		if _, err := c.Batch(attach); err != nil {
+			t.Fatalf("%v %v: Tattach: want nil, got %v", tc.version, tc.exts, err)
+		}
+		replies, err := c.Batch(
+			marshal(func(b *bytes.Buffer) { MarshalTwalkPkt(b, 0, 0, 1, []string{"a"}) }),
+			marshal(func(b *bytes.Buffer) {
+				if open == Tlopen {
+					MarshalTlopenPkt(b, 0, 1, 0)
+				} else {
+					MarshalTopenPkt(b, 0, 1, OREAD)
+				}
+			}),
+			marshal(func(b *bytes.Buffer) { MarshalTreadPkt(b, 0, 1, 1, 3) }),
+			marshal(func(b *bytes.Buffer) { MarshalTclunkPkt(b, 0, 1) }),
+		)
+		if err != nil || len(replies) != 4 {
+			t.Fatalf("%v %v: Batch: got %d replies, %v, want 4, nil", tc.version, tc.exts, len(replies), err)
+		}
+		want := []MType{Rwalk, open + 1, Rread, Rclunk}
+		for i, r := range replies {
+			if MType(r[4]) != want[i] {
+				t.Errorf("%v %v: reply %d: got %v, want %v", tc.version, tc.exts, i, RPCNames[MType(r[4])], RPCNames[want[i]])
+			}
+		}
+		if d, _, err := UnmarshalRreadPkt(bytes.NewBuffer(replies[2][5:])); err != nil || string(d) != "ell" {
+			t.Errorf("%v %v: Rread: got %q, %v, want ell, nil", tc.version, tc.exts, d, err)
+		}
+
+		// A failure stops the batch.
+		replies, err = c.Batch(
+			marshal(func(b *bytes.Buffer) { MarshalTwalkPkt(b, 0, 0, 2, []string{"nope"}) }),
+			marshal(func(b *bytes.Buffer) { MarshalTclunkPkt(b, 0, 0) }),
+		)
+		if err == nil || len(replies) != 1 {
+			t.Errorf("%v %v: Batch with a failure: got %d replies, %v, want 1, an error", tc.version, tc.exts, len(replies), err)
+		}
+		if _, err := c.CallTwalk(0, 3, nil); err != nil {
+			t.Errorf("%v %v: fid 0 after the failed batch: want it, got %v", tc.version, tc.exts, err)
+		}
+	}
+}
+
+func TestBatchNotAllowed(t *testing.T) {
+	c := newExtClient(t)
+	if _, _, _, err := c.Version(8192, "9P2000", BatchExtension); err != nil {
+		t.Fatalf("Version: want nil, got %v", err)
+	}
+	for _, m := range [][]byte{
+		marshal(func(b *bytes.Buffer) { MarshalTversionPkt(b, 0, 8192, "9P2000") }),
+		marshal(func(b *bytes.Buffer) { MarshalTflushPkt(b, 0, 1) }),
+		marshal(func(b *bytes.Buffer) { putBatch(b, Tbatch, 0, nil) }),
+	} {
+		if _, err := c.Batch(m); err == nil {
+			t.Errorf("Batch(%v): want error, got nil", RPCNames[MType(m[4])])
+		}
+	}
+
+	// A bad batch gets an Rerror.
+	b := bytes.NewBuffer([]byte{11, 0, 0, 0, uint8(Tbatch), 0, 0, 1, 0, 9, 0})
+	if typ, _ := rpc(c, b); typ != Rerror {
+		t.Errorf("bad Tbatch: got %v, want Rerror", RPCNames[typ])
+	}
+}
@@ -8,10 +8,12 @@ package protocol
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"runtime"
+	"sync"
 	"sync/atomic"
 )
 
@@ -22,19 +24,77 @@ import (
 // pushed and another from which RPCReplys return.
 // Once a client is marked Dead all further requests to it will fail.
 // The ToNet/FromNet are separate so we can use io.Pipe for testing.
+//
+// Client is the protocol, and changes with it. Programs which just want
+// files should use package client, whose Session hides all this.
+//
+// Any number of goroutines may make calls on a Client at once. FID is
+// only changed atomically, by GetFID, and the RPC slots and Dead only
+// with mu held.
 type Client struct {
+	// Deprecated: Tags, FID, RPC, FromClient and FromServer are how
+	// the client works, and will change. Use GetTag and GetFID.
 	Tags       chan Tag
 	FID        uint64
 	RPC        []*RPCCall
-	ToNet      io.WriteCloser
-	FromNet    io.ReadCloser
 	FromClient chan *RPCCall
 	FromServer chan *RPCReply
-	Msize      uint32
-	Dead       bool
-	Trace      Tracer
+
+	// ToNet and FromNet are the connection, set by a ClientOpt.
+	ToNet   io.WriteCloser
+	FromNet io.ReadCloser
+	// Msize is the msize the client asks for, set by a ClientOpt, and
+	// no reply may be bigger. If 0, the limit is MaxMsize.
+	Msize uint32
+	// Deprecated: reading Dead races with the client's goroutines.
+	// Use IsDead.
+	Dead bool
+	// Trace, if set, is called with what the client is doing. The
+	// last call says that the client is dead, and why; after it,
+	// there are no more, so whatever Trace writes to may be closed.
+	Trace Tracer
+
+	// mu guards RPC, version, Dead and err.
+	mu sync.Mutex
+	// err is why the client is dead.
+	err error
+
+	// version is the outstanding Tversion, which has tag NOTAG, and
+	// notag is full while there is one.
+	version *RPCCall
+	notag   chan struct{}
+
+	// Extensions are the extensions the server agreed to in Version.
+	Extensions []string
+
+	// Codec puts messages on the wire. If nil, it is BinaryCodec.
+	Codec Codec
+
+	// Symlinks, if set, is how Open, Create and FS resolve symbolic
+	// links. If nil, they leave it to the server.
+	Symlinks *SymlinkPolicy
+
+	// dialect is the version agreed to in Version.
+	dialect string
+
+	// tune, if set by TuneMsize, picks how much ClientFiles move in
+	// each message.
+	tune *msizeTuner
+
+	// owners, if set by CheckOwners, is where ClientFiles report
+	// misuse.
+	owners func(error)
+
+	// traceMu serializes calls to Trace, and guards traced, which is
+	// set once the last has been made.
+	traceMu sync.Mutex
+	traced  bool
 }
 
+// errConnLost is the error of requests which the server will never
+// answer, since the connection to it is gone, if there is no better.
+var errConnLost = fmt.Errorf("connection to server lost")
+
 func NewClient(opts ...ClientOpt) (*Client, error) {
 	var c = &Client{}
 
@@ -44,15 +104,23 @@ func NewClient(opts ...ClientOpt) (*Client, error) {
 	}
 	c.FID = 1
 	c.RPC = make([]*RPCCall, NumTags)
+	c.notag = make(chan struct{}, 1)
 	for _, o := range opts {
 		if err := o(c); err != nil {
 			return nil, err
 		}
 	}
+	if c.Codec == nil {
+		c.Codec = BinaryCodec
+	}
 	c.FromClient = make(chan *RPCCall, NumTags)
 	c.FromServer = make(chan *RPCReply)
+	max := int64(c.Msize)
+	if max == 0 {
+		max = MaxMsize
+	}
 	go c.IO()
-	go c.readNetPackets()
+	go c.readNetPackets(max)
 	return c, nil
 }
 
@@ -69,107 +137,245 @@ func (c *Client) GetTag() Tag {
 
 // GetFID gets a fid to be used to identify a resource for a 9p client.
 // For a given lifetime of a 9p client, FIDS are unique (i.e. not reused as in
-// many 9p client libraries).
+// many 9p client libraries), until they wrap. It never returns NOFID.
 func (c *Client) GetFID() FID {
-	return FID(atomic.AddUint64(&c.FID, 1))
+	for {
+		if f := FID(atomic.AddUint64(&c.FID, 1)); f != NOFID {
+			return f
+		}
+	}
 }
 
-func (c *Client) readNetPackets() {
-	if c.FromNet == nil {
-		if c.Trace != nil {
-			c.Trace("c.FromNet is nil, marking dead")
-		}
-		c.Dead = true
+// IsDead reports whether the client has lost its connection, after
+// which all calls to it fail.
+func (c *Client) IsDead() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Dead
+}
+
+// Err returns why the client is dead, or nil if it isn't.
+func (c *Client) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// setDead marks the client dead, for err, unless it already is.
+func (c *Client) setDead(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.Dead {
+		c.Dead, c.err = true, err
+	}
+}
+
+// trace calls Trace, if it is set and the client is not yet known to be
+// dead.
+func (c *Client) trace(format string, args ...interface{}) {
+	if !Minimal && c.Trace != nil {
+		c.tracef(format, args...)
+	}
+}
+
+// tracef is trace, for a Client with a Trace.
+func (c *Client) tracef(format string, args ...interface{}) {
+	c.traceMu.Lock()
+	defer c.traceMu.Unlock()
+	if c.Trace != nil && !c.traced {
+		c.Trace(format, args...)
+	}
+}
+
+// traceDead makes the last call to Trace, with why the client is dead,
+// once it is, and nothing is left to trace.
+func (c *Client) traceDead() {
+	c.traceMu.Lock()
+	defer c.traceMu.Unlock()
+	if c.Trace != nil && !c.traced {
+		c.Trace("client dead: %v", c.lost())
+	}
+	c.traced = true
+}
+
+// setRPC puts r in the slot for tag t, for its reply to find.
+func (c *Client) setRPC(t Tag, r *RPCCall) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t == NOTAG {
+		c.version = r
 		return
 	}
-	defer c.FromNet.Close()
-	defer close(c.FromServer)
-	if c.Trace != nil {
-		c.Trace("Starting readNetPackets")
+	c.RPC[int(t)-1] = r
+}
+
+// takeRPC empties the slot for tag t, returning what was in it, which
+// is nil if no request with that tag is outstanding.
+func (c *Client) takeRPC(t Tag) *RPCCall {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var r *RPCCall
+	switch {
+	case t == NOTAG:
+		r, c.version = c.version, nil
+	case t >= 1 && int(t) <= len(c.RPC):
+		r, c.RPC[int(t)-1] = c.RPC[int(t)-1], nil
 	}
-	for !c.Dead {
-		l := make([]byte, 7)
-		if c.Trace != nil {
-			c.Trace("Before read")
-		}
+	return r
+}
 
-		if n, err := c.FromNet.Read(l); err != nil || n < 7 {
-			log.Printf("readNetPackets: short read: %v", err)
-			c.Dead = true
+// readNetPackets reads replies from the server, none of which can be
+// bigger than max. The first is checked for being 9P, if the codec is
+// framed as 9P is, so that a server which isn't says so.
+func (c *Client) readNetPackets(max int64) {
+	// Closing FromServer tells IO that the connection is gone, for
+	// good, so it must be last.
+	defer close(c.FromServer)
+	if c.FromNet == nil {
+		c.trace("c.FromNet is nil, marking dead")
+		c.setDead(fmt.Errorf("no connection"))
+		return
+	}
+	defer c.FromNet.Close()
+	c.trace("Starting readNetPackets")
+	r := newFrameReader(c.FromNet)
+	if c.Codec == BinaryCodec || c.Codec == CRCCodec {
+		err := checkFirst(r, max, func(t MType) bool {
+			return t == Rversion || t == Rerror || t == Rlerror
+		})
+		if errors.Is(err, ErrNot9P) {
+			log.Printf("readNetPackets: %v", err)
+			c.setDead(err)
 			return
 		}
-		if c.Trace != nil {
-			c.Trace("Server reads %v", l)
-		}
-		s := int64(l[0]) + int64(l[1])<<8 + int64(l[2])<<16 + int64(l[3])<<24
-		b := bytes.NewBuffer(l)
-		r := io.LimitReader(c.FromNet, s-7)
-		if _, err := io.Copy(b, r); err != nil {
+	}
+	for !c.IsDead() {
+		b, err := readLimit(c.Codec, r.Reader, max)
+		if err != nil {
 			log.Printf("readNetPackets: short read: %v", err)
-			c.Dead = true
+			c.setDead(fmt.Errorf("%v: %v", errConnLost, err))
 			return
 		}
-		if c.Trace != nil {
-			c.Trace("readNetPackets: got %v, len %d, sending to IO", RPCNames[MType(l[4])], b.Len())
-		}
-		c.FromServer <- &RPCReply{b: b.Bytes()}
+		c.trace("readNetPackets: got %v, len %d, sending to IO", RPCNames[MType(b[4])], len(b))
+		c.FromServer <- &RPCReply{b: b}
 	}
-	if c.Trace != nil {
-		c.Trace("Client %v is all done", c)
+	c.trace("readNetPackets: client is dead: %v", c.Err())
+}
+
+// reply hands b, the reply with tag t, to the request waiting for it,
+// and frees the tag. It reports whether a request was waiting.
+func (c *Client) reply(t Tag, b []byte) bool {
+	r := c.takeRPC(t)
+	if r == nil {
+		return false
 	}
+	c.trace("RPC %v ", r)
+	r.Reply <- b
+	if t == NOTAG {
+		<-c.notag
+	} else {
+		c.Tags <- t
+	}
+	return true
+}
+
+// lost returns the error for requests the server will never answer.
+func (c *Client) lost() error {
+	if err := c.Err(); err != nil {
+		return err
+	}
+	return errConnLost
+}
 
+// fail replies to the request with tag t, if it is outstanding, with
+// err, for when the server never will.
+func (c *Client) fail(t Tag, err error) {
+	var b bytes.Buffer
+	MarshalRerrorPkt(&b, t, err.Error())
+	c.reply(t, b.Bytes())
 }
 
+// failAll fails every outstanding request, once the connection is gone.
+func (c *Client) failAll(err error) {
+	c.mu.Lock()
+	var tags []Tag
+	for i, r := range c.RPC {
+		if r != nil {
+			tags = append(tags, Tag(i+1))
+		}
+	}
+	if c.version != nil {
+		tags = append(tags, NOTAG)
+	}
+	c.mu.Unlock()
+	for _, t := range tags {
+		c.fail(t, err)
+	}
+}
+
+// IO sends requests and hands out replies. Once the connection is
+// gone, outstanding requests, and any made after, fail.
 func (c *Client) IO() {
 	go func() {
 		for {
 			r := <-c.FromClient
-			t := <-c.Tags
-			if c.Trace != nil {
-				c.Trace(fmt.Sprintf("Tag for request is %v", t))
+			if c.IsDead() {
+				var b bytes.Buffer
+				MarshalRerrorPkt(&b, 0, c.lost().Error())
+				r.Reply <- b.Bytes()
+				continue
+			}
+			var t Tag
+			if MType(r.b[4]) == Tversion {
+				// Only one can be outstanding, since they
+				// all have NOTAG.
+				c.notag <- struct{}{}
+				t = NOTAG
+			} else {
+				t = <-c.Tags
 			}
 			r.b[5] = uint8(t)
 			r.b[6] = uint8(t >> 8)
-			if c.Trace != nil {
-				c.Trace(fmt.Sprintf("Tag for request is %v", t))
-			}
-			c.RPC[int(t)-1] = r
-			if c.Trace != nil {
-				c.Trace("Write %v to ToNet", r.b)
+			c.trace("Tag for request is %v", t)
+			c.setRPC(t, r)
+			// If the connection went since the check above,
+			// failAll may have missed r.
+			if c.IsDead() {
+				c.fail(t, c.lost())
+				continue
 			}
-			if _, err := c.ToNet.Write(r.b); err != nil {
-				c.Dead = true
-				log.Fatalf("Write to server: %v", err)
-				return
+			c.trace("Write %v to ToNet", r.b)
+			if err := c.Codec.Write(c.ToNet, r.b); err != nil {
+				log.Printf("Write to server: %v", err)
+				c.setDead(fmt.Errorf("%v: %v", errConnLost, err))
+				c.fail(t, c.lost())
 			}
 		}
 	}()
 
 	for {
-		r := <-c.FromServer
-		if c.Trace != nil {
-			c.Trace("Read %v FromServer", r.b)
+		r, ok := <-c.FromServer
+		if !ok {
+			c.setDead(errConnLost)
+			c.failAll(c.lost())
+			c.traceDead()
+			return
 		}
+		c.trace("Read %v FromServer", r.b)
 		t := Tag(r.b[5]) | Tag(r.b[6])<<8
-		if c.Trace != nil {
-			c.Trace(fmt.Sprintf("Tag for reply is %v", t))
-		}
-		if t < 1 {
-			panic(fmt.Sprintf("tag %d < 1", t))
+		c.trace("Tag for reply is %v", t)
+		if !c.reply(t, r.b) {
+			// A reply to nothing: the tag is still in use, or
+			// was never, so it must not go back in Tags.
+			log.Printf("reply with tag %d, which is not outstanding", t)
 		}
-		if int(t-1) >= len(c.RPC) {
-			panic(fmt.Sprintf("tag %d >= len(c.RPC) %d", t, len(c.RPC)))
-		}
-		c.Trace("RPC %v ", c.RPC[t-1])
-		rrr := c.RPC[t-1]
-		c.Trace("rrr %v ", rrr)
-		rrr.Reply <- r.b
-		c.Tags <- t
 	}
 }
 
+// String describes c. It gives only the types of FromNet and ToNet,
+// since formatting their values would read them while they are in use.
 func (c *Client) String() string {
 	z := map[bool]string{false: "Alive", true: "Dead"}
-	return fmt.Sprintf("%v tags available, Msize %v, %v FromNet %v ToNet %v", len(c.Tags), c.Msize, z[c.Dead],
+	return fmt.Sprintf("%v tags available, Msize %v, %v FromNet %T ToNet %T", len(c.Tags), c.Msize, z[c.IsDead()],
 		c.FromNet, c.ToNet)
 }
@@ -0,0 +1,270 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// A ClientFile is an open file on a 9P server, with the usual io methods.
+// They hide how 9P moves data: transfers are split to fit the iounit,
+// and since a server may return fewer bytes than asked for, even in the
+// middle of a file, each transfer carries on from where the last stopped
+// until it is done, the file ends, or there is an error.
+//
+// The end of a file, or a directory, is a read of zero bytes, and
+// nothing else: it is always reported as io.EOF, and never as any
+// other error.
+type ClientFile struct {
+	c      *Client
+	fid    FID
+	qid    QID
+	iounit Count
+
+	// mu guards offset.
+	mu     sync.Mutex
+	offset int64
+
+	// owner, if the Client checks owners, is who may use the file.
+	owner *owner
+}
+
+// Open walks from fid to the file named by names, and opens it in mode.
+// Symbolic links are resolved as c.Symlinks says.
+func (c *Client) Open(fid FID, names []string, mode Mode) (*ClientFile, error) {
+	w, err := c.resolve(fid, names)
+	if err != nil {
+		return nil, err
+	}
+	f := w.FID
+	q, iounit, err := c.CallTopen(f, mode)
+	if err != nil {
+		c.CallTclunk(f)
+		return nil, err
+	}
+	return c.newClientFile(f, q, iounit), nil
+}
+
+// Create walks from fid to the directory holding the file named by
+// names, and creates the file with perm, opened in mode.
+func (c *Client) Create(fid FID, names []string, perm Perm, mode Mode) (*ClientFile, error) {
+	if len(names) == 0 {
+		return nil, fmt.Errorf("Create: no name")
+	}
+	dir, name := names[:len(names)-1], names[len(names)-1]
+	w, err := c.resolve(fid, dir)
+	if err != nil {
+		return nil, err
+	}
+	f := w.FID
+	q, iounit, err := c.CallTcreate(f, name, perm, mode)
+	if err != nil {
+		c.CallTclunk(f)
+		return nil, err
+	}
+	return c.newClientFile(f, q, iounit), nil
+}
+
+// OpenFile is like os.OpenFile. It opens the file named by names,
+// starting at fid, or with os.O_CREATE creates it with perm.
+func (c *Client) OpenFile(fid FID, names []string, flag int, perm os.FileMode) (*ClientFile, error) {
+	m, create, excl := FlagsToMode(flag)
+	if !create {
+		return c.Open(fid, names, m)
+	}
+	if !excl {
+		f, err := c.Open(fid, names, m)
+		if err == nil || Lookup(err) != ErrNotExist {
+			return f, err
+		}
+	}
+	return c.Create(fid, names, FileModeToPerm(perm), m)
+}
+
+// Truncate sets the length of the file fid to length, with a Twstat
+// which changes nothing else.
+func (c *Client) Truncate(fid FID, length uint64) error {
+	d := noChange()
+	d.Length = length
+	var b bytes.Buffer
+	Marshaldir(&b, d)
+	return c.CallTwstat(fid, b.Bytes())
+}
+
+func (c *Client) newClientFile(fid FID, q QID, iounit MaxSize) *ClientFile {
+	// No iounit means the most that fits in a message.
+	msize := c.Msize
+	if msize <= IOHDRSZ {
+		msize = MSIZE
+	}
+	if iounit == 0 || uint32(iounit) > msize-IOHDRSZ {
+		iounit = MaxSize(msize - IOHDRSZ)
+	}
+	f := &ClientFile{c: c, fid: fid, qid: q, iounit: Count(iounit)}
+	if c.owners != nil {
+		f.owner = &owner{report: c.owners, g: goid()}
+	}
+	return f
+}
+
+// FID returns the fid of the file.
+func (f *ClientFile) FID() FID {
+	return f.fid
+}
+
+// QID returns the QID of the file, as it was when it was opened.
+func (f *ClientFile) QID() QID {
+	return f.qid
+}
+
+// ReadAt reads len(p) bytes at offset off. As with io.ReaderAt, it only
+// returns fewer bytes if it also returns an error, which is io.EOF at
+// the end of the file.
+func (f *ClientFile) ReadAt(p []byte, off int64) (int, error) {
+	defer f.use("ReadAt", true)()
+	var n int
+	for n < len(p) {
+		c, i := f.c.tune.chunk(f.iounit, len(p)-n)
+		start := f.c.tune.start(i)
+		data, err := f.c.CallTread(f.fid, Offset(off+int64(n)), c)
+		if err != nil {
+			return n, err
+		}
+		if len(data) == 0 {
+			return n, io.EOF
+		}
+		if len(data) == int(c) {
+			f.c.tune.done(i, start)
+		}
+		n += copy(p[n:], data)
+	}
+	return n, nil
+}
+
+// Read reads up to len(p) bytes at the current offset, and advances it.
+func (f *ClientFile) Read(p []byte) (int, error) {
+	defer f.use("Read", false)()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n, err := f.ReadAt(p, f.offset)
+	f.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// Dirread reads the next entries of a directory, as many as the server
+// returns in one read. At the end of the directory it returns io.EOF.
+func (f *ClientFile) Dirread() ([]Dir, error) {
+	defer f.use("Dirread", false)()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data, err := f.c.CallTread(f.fid, Offset(f.offset), f.iounit)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, io.EOF
+	}
+	var dirs []Dir
+	for b := bytes.NewBuffer(data); b.Len() > 0; {
+		d, err := nextDir(b)
+		if err != nil {
+			return dirs, err
+		}
+		dirs = append(dirs, d)
+	}
+	f.offset += int64(len(data))
+	return dirs, nil
+}
+
+// WriteAt writes len(p) bytes at offset off.
+func (f *ClientFile) WriteAt(p []byte, off int64) (int, error) {
+	defer f.use("WriteAt", true)()
+	var n int
+	for n < len(p) {
+		m, i := f.c.tune.chunk(f.iounit, len(p)-n)
+		start := f.c.tune.start(i)
+		c, err := f.c.CallTwrite(f.fid, Offset(off+int64(n)), p[n:n+int(m)])
+		if err != nil {
+			return n, err
+		}
+		// A server that writes nothing will not write more if asked again.
+		if c == 0 {
+			return n, io.ErrShortWrite
+		}
+		if c == m {
+			f.c.tune.done(i, start)
+		}
+		n += int(c)
+	}
+	return n, nil
+}
+
+// Write writes p at the current offset, and advances it.
+func (f *ClientFile) Write(p []byte) (int, error) {
+	defer f.use("Write", false)()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n, err := f.WriteAt(p, f.offset)
+	f.offset += int64(n)
+	return n, err
+}
+
+// Seek sets the offset for the next Read, Write or Dirread. 9P only lets
+// a directory read carry on from where the last one stopped, or start
+// again at 0, so for a directory those are the only offsets Seek allows.
+func (f *ClientFile) Seek(offset int64, whence int) (int64, error) {
+	defer f.use("Seek", false)()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var o int64
+	switch whence {
+	case io.SeekStart:
+		o = offset
+	case io.SeekCurrent:
+		o = f.offset + offset
+	case io.SeekEnd:
+		st, err := f.c.CallTstat(f.fid)
+		if err != nil {
+			return f.offset, err
+		}
+		d, err := Unmarshaldir(bytes.NewBuffer(st))
+		if err != nil {
+			return f.offset, err
+		}
+		o = int64(d.Length) + offset
+	default:
+		return f.offset, fmt.Errorf("Seek: invalid whence %d", whence)
+	}
+	if o < 0 {
+		return f.offset, fmt.Errorf("Seek: negative offset %d", o)
+	}
+	if f.qid.Type&QTDIR != 0 && o != 0 && o != f.offset {
+		return f.offset, fmt.Errorf("Seek: directory offset must be 0 or %d, not %d", f.offset, o)
+	}
+	f.offset = o
+	return o, nil
+}
+
+// Truncate changes the length of the file. It does not change the offset.
+func (f *ClientFile) Truncate(size int64) error {
+	defer f.use("Truncate", false)()
+	if size < 0 {
+		return fmt.Errorf("Truncate: negative size %d", size)
+	}
+	return f.c.Truncate(f.fid, uint64(size))
+}
+
+// Close clunks the fid.
+func (f *ClientFile) Close() error {
+	defer f.use("Close", false)()
+	return f.c.CallTclunk(f.fid)
+}
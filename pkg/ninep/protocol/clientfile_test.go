@@ -0,0 +1,208 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestClientFileShort(t *testing.T) {
+	c, ds := newDialectClient(t, "9P2000")
+	ds.short = 2
+	if _, err := c.CallTattach(0, NOFID, "glenda", ""); err != nil {
+		t.Fatalf("CallTattach: want nil, got %v", err)
+	}
+
+	f, err := c.Open(0, []string{"a"}, ORDWR)
+	if err != nil {
+		t.Fatalf("Open(a): want nil, got %v", err)
+	}
+	b := make([]byte, 4)
+	if n, err := f.ReadAt(b, 0); n != 4 || err != nil || string(b) != "hell" {
+		t.Errorf("ReadAt(4, 0): got %d, %q, %v, want 4, hell, nil", n, b[:n], err)
+	}
+	if n, err := f.ReadAt(b, 3); n != 2 || err != io.EOF || string(b[:n]) != "lo" {
+		t.Errorf("ReadAt(4, 3): got %d, %q, %v, want 2, lo, EOF", n, b[:n], err)
+	}
+	if n, err := f.Write([]byte("HELLO, WORLD")); n != 12 || err != nil {
+		t.Errorf("Write: got %d, %v, want 12, nil", n, err)
+	}
+	if n, err := f.WriteAt([]byte("w"), 7); n != 1 || err != nil {
+		t.Errorf("WriteAt: got %d, %v, want 1, nil", n, err)
+	}
+	if err := f.Close(); err != nil {
+		t.Errorf("Close: want nil, got %v", err)
+	}
+
+	f, err = c.Open(0, []string{"a"}, OREAD)
+	if err != nil {
+		t.Fatalf("Open(a): want nil, got %v", err)
+	}
+	d, err := ioutil.ReadAll(f)
+	if err != nil || string(d) != "HELLO, wORLD" {
+		t.Errorf("ReadAll: got %q, %v, want \"HELLO, wORLD\", nil", d, err)
+	}
+	if err := f.Close(); err != nil {
+		t.Errorf("Close: want nil, got %v", err)
+	}
+
+	if _, err := c.Open(0, []string{"nope"}, OREAD); err == nil {
+		t.Errorf("Open(nope): want err, got nil")
+	}
+}
+
+func TestClientFileSeek(t *testing.T) {
+	c, _ := newDialectClient(t, "9P2000")
+	if _, err := c.CallTattach(0, NOFID, "glenda", ""); err != nil {
+		t.Fatalf("CallTattach: want nil, got %v", err)
+	}
+
+	f, err := c.Open(0, []string{"a"}, OREAD)
+	if err != nil {
+		t.Fatalf("Open(a): want nil, got %v", err)
+	}
+	for _, tt := range []struct {
+		o      int64
+		whence int
+		want   int64
+		ok     bool
+	}{
+		{2, io.SeekStart, 2, true},
+		{1, io.SeekCurrent, 3, true},
+		{-1, io.SeekEnd, 4, true},
+		{-5, io.SeekCurrent, 4, false},
+		{0, 3, 4, false},
+	} {
+		o, err := f.Seek(tt.o, tt.whence)
+		if o != tt.want || (err == nil) != tt.ok {
+			t.Errorf("Seek(%d, %d): got %d, %v, want %d, ok %v", tt.o, tt.whence, o, err, tt.want, tt.ok)
+		}
+	}
+	b := make([]byte, 4)
+	if n, err := f.Read(b); n != 1 || err != nil || b[0] != 'o' {
+		t.Errorf("Read after Seek: got %d, %q, %v, want 1, o, nil", n, b[:n], err)
+	}
+	f.Close()
+
+	f, err = c.Open(0, nil, OREAD)
+	if err != nil {
+		t.Fatalf("Open(/): want nil, got %v", err)
+	}
+	d, err := f.Dirread()
+	if err != nil || len(d) != 1 {
+		t.Fatalf("Dirread(/): got %v, %v, want [a], nil", d, err)
+	}
+	end, err := f.Seek(0, io.SeekCurrent)
+	if err != nil || end == 0 {
+		t.Errorf("Seek(0, SeekCurrent): got %d, %v, want the end of a, nil", end, err)
+	}
+	if _, err := f.Seek(1, io.SeekStart); err == nil {
+		t.Errorf("Seek(1, SeekStart) in a directory: want err, got nil")
+	}
+	if _, err := f.Seek(end, io.SeekStart); err != nil {
+		t.Errorf("Seek(%d, SeekStart) in a directory: want nil, got %v", end, err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		t.Errorf("Seek(0, SeekStart) in a directory: want nil, got %v", err)
+	}
+	if d, err := f.Dirread(); err != nil || len(d) != 1 || d[0].Name != "a" {
+		t.Errorf("Dirread(/) after Seek(0): got %v, %v, want [a], nil", d, err)
+	}
+	f.Close()
+}
+
+func TestClientFileTruncate(t *testing.T) {
+	c, ds := newDialectClient(t, "9P2000")
+	if _, err := c.CallTattach(0, NOFID, "glenda", ""); err != nil {
+		t.Fatalf("CallTattach: want nil, got %v", err)
+	}
+	f, err := c.Open(0, []string{"a"}, OWRITE)
+	if err != nil {
+		t.Fatalf("Open(a): want nil, got %v", err)
+	}
+	defer f.Close()
+	if err := f.Truncate(-1); err == nil {
+		t.Errorf("Truncate(-1): want err, got nil")
+	}
+	if err := f.Truncate(3); err != nil {
+		t.Fatalf("Truncate(3): want nil, got %v", err)
+	}
+	if want := noChange(); ds.wstat.Length != 3 || ds.wstat.Mode != want.Mode || ds.wstat.Mtime != want.Mtime || ds.wstat.Name != "" {
+		t.Errorf("Truncate(3): got Twstat %v, want only length 3", ds.wstat)
+	}
+}
+
+func TestOpenModes(t *testing.T) {
+	for _, tt := range []struct {
+		flag   int
+		m      Mode
+		create bool
+		excl   bool
+		l      uint32
+	}{
+		{os.O_RDONLY, OREAD, false, false, 0},
+		{os.O_WRONLY | os.O_TRUNC, OWRITE | OTRUNC, false, false, 01001},
+		{os.O_RDWR | os.O_APPEND, ORDWR | OAPPEND, false, false, 02002},
+		{os.O_RDWR | os.O_CREATE, ORDWR, true, false, 02},
+		{os.O_WRONLY | os.O_CREATE | os.O_EXCL, OWRITE, true, true, 01},
+	} {
+		m, create, excl := FlagsToMode(tt.flag)
+		if m != tt.m || create != tt.create || excl != tt.excl {
+			t.Errorf("FlagsToMode(%#o): got %#x, %v, %v, want %#x, %v, %v", tt.flag, m, create, excl, tt.m, tt.create, tt.excl)
+		}
+		if f := ModeToFlags(m); f != tt.flag&^(os.O_CREATE|os.O_EXCL) {
+			t.Errorf("ModeToFlags(%#x): got %#o, want %#o", m, f, tt.flag&^(os.O_CREATE|os.O_EXCL))
+		}
+		if l := ModeToLinuxFlags(m); l != tt.l {
+			t.Errorf("ModeToLinuxFlags(%#x): got %#o, want %#o", m, l, tt.l)
+		}
+		if m2 := LinuxFlagsToMode(tt.l); m2 != m {
+			t.Errorf("LinuxFlagsToMode(%#o): got %#x, want %#x", tt.l, m2, m)
+		}
+	}
+	for m, want := range map[Mode]bool{
+		OREAD: false, OEXEC: false, OREAD | OAPPEND: false,
+		OWRITE: true, ORDWR: true, OREAD | OTRUNC: true, OREAD | ORCLOSE: true, OWRITE | OAPPEND: true,
+	} {
+		if got := m.Writes(); got != want {
+			t.Errorf("Mode(%#x).Writes(): got %v, want %v", m, got, want)
+		}
+	}
+	if f := ModeToFlags(OEXEC); f != os.O_RDONLY {
+		t.Errorf("ModeToFlags(OEXEC): got %#o, want O_RDONLY", f)
+	}
+	for _, m := range []os.FileMode{0644, os.ModeDir | 0755, os.ModeAppend | os.ModeExclusive | 0600, os.ModeSymlink | 0777} {
+		if m2 := PermToFileMode(uint32(FileModeToPerm(m))); m2 != m {
+			t.Errorf("PermToFileMode(FileModeToPerm(%v)): got %v", m, m2)
+		}
+	}
+
+	c, ds := newDialectClient(t, "9P2000")
+	if _, err := c.CallTattach(0, NOFID, "glenda", ""); err != nil {
+		t.Fatalf("CallTattach: want nil, got %v", err)
+	}
+	if _, err := c.OpenFile(0, []string{"b"}, os.O_RDWR, 0); err == nil {
+		t.Errorf("OpenFile(b, O_RDWR): want err, got nil")
+	}
+	f, err := c.OpenFile(0, []string{"b"}, os.O_RDWR|os.O_CREATE, 0640)
+	if err != nil {
+		t.Fatalf("OpenFile(b, O_RDWR|O_CREATE): want nil, got %v", err)
+	}
+	f.Close()
+	if d := ds.files["b"]; d == nil || d.Mode != 0640 {
+		t.Errorf("OpenFile(b, O_RDWR|O_CREATE, 0640): got %v, want a file with mode 0640", d)
+	}
+	f, err = c.OpenFile(0, []string{"b"}, os.O_RDWR|os.O_CREATE, 0640)
+	if err != nil {
+		t.Fatalf("OpenFile(b, O_RDWR|O_CREATE) of existing file: want nil, got %v", err)
+	}
+	f.Close()
+	if _, err := c.OpenFile(0, []string{"b"}, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0640); err == nil {
+		t.Errorf("OpenFile(b, O_RDWR|O_CREATE|O_EXCL) of existing file: want err, got nil")
+	}
+}
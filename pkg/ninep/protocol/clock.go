@@ -0,0 +1,124 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// A Clock tells the time and runs functions once time has passed. The
+// timeouts, caches and file times of clients and servers come from one,
+// which is SystemClock unless set otherwise, so that tests can use a
+// FakeClock rather than sleep.
+type Clock interface {
+	Now() time.Time
+	// AfterFunc calls f, in its own goroutine, once d has passed.
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// A Timer is a function waiting on a Clock.
+type Timer interface {
+	// Stop stops the function being called, and reports whether it
+	// did, which it didn't if it has been called.
+	Stop() bool
+}
+
+// SystemClock is the real time.
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+// clockOrSystem returns c, or SystemClock if it is nil.
+func clockOrSystem(c Clock) Clock {
+	if c == nil {
+		return SystemClock
+	}
+	return c
+}
+
+// A FakeClock is a Clock whose time only passes when Advance says so.
+type FakeClock struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	c    *FakeClock
+	when time.Time
+	f    func()
+}
+
+// NewFakeClock returns a FakeClock, set to now.
+func NewFakeClock(now time.Time) *FakeClock {
+	c := &FakeClock{now: now}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *FakeClock) AfterFunc(d time.Duration, f func()) Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{c: c, when: c.now.Add(d), f: f}
+	c.timers = append(c.timers, t)
+	c.cond.Broadcast()
+	return t
+}
+
+func (t *fakeTimer) Stop() bool {
+	c := t.c
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, o := range c.timers {
+		if o == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Advance moves the time on by d, and starts the functions which are
+// then due, each in its own goroutine, as time.AfterFunc does.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	sort.SliceStable(c.timers, func(i, j int) bool {
+		return c.timers[i].when.Before(c.timers[j].when)
+	})
+	for len(c.timers) > 0 && !c.timers[0].when.After(c.now) {
+		go c.timers[0].f()
+		c.timers = c.timers[1:]
+	}
+}
+
+// Wait waits until at least n functions are waiting on the clock, so
+// that a test knows that what it is testing has started its timer
+// before it calls Advance.
+func (c *FakeClock) Wait(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.timers) < n {
+		c.cond.Wait()
+	}
+}
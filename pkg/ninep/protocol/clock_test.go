@@ -0,0 +1,42 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewFakeClock(start)
+	fired := make(chan string, 3)
+	c.AfterFunc(time.Second, func() { fired <- "1s" })
+	stopped := c.AfterFunc(2*time.Second, func() { fired <- "2s" })
+	c.AfterFunc(3*time.Second, func() { fired <- "3s" })
+
+	c.Advance(time.Second)
+	if got := <-fired; got != "1s" {
+		t.Errorf("after 1s: got %v, want 1s", got)
+	}
+	if !stopped.Stop() {
+		t.Errorf("Stop of waiting timer: got false, want true")
+	}
+	c.Advance(2 * time.Second)
+	if got := <-fired; got != "3s" {
+		t.Errorf("after 3s: got %v, want 3s", got)
+	}
+	if stopped.Stop() {
+		t.Errorf("second Stop: got true, want false")
+	}
+	if got, want := c.Now(), start.Add(3*time.Second); !got.Equal(want) {
+		t.Errorf("Now: got %v, want %v", got, want)
+	}
+	select {
+	case got := <-fired:
+		t.Errorf("stopped timer fired: %v", got)
+	default:
+	}
+}
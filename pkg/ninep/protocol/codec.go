@@ -0,0 +1,203 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strings"
+)
+
+// A Codec puts 9P messages on the wire. Client and Listener use the
+// 9P encoding unless given another, so that encodings for odd links, or
+// for debugging, can be used without touching anything else. Both ends
+// must of course agree.
+type Codec interface {
+	// Read reads one message from r, and returns it in 9P form:
+	// size[4] type[1] tag[2] and the rest.
+	Read(r *bufio.Reader) ([]byte, error)
+
+	// Write writes the 9P message m to w.
+	Write(w io.Writer, m []byte) error
+}
+
+// A wholeCodec can tell whether b, what has been read so far, holds a
+// whole message. A server holds replies back, to send several at once,
+// only while it can tell that another request is waiting.
+type wholeCodec interface {
+	whole(b []byte) bool
+}
+
+// A limitedCodec can refuse a message bigger than max before reading
+// it, for codecs which say how big a message is up front.
+type limitedCodec interface {
+	readLimit(r *bufio.Reader, max int64) ([]byte, error)
+}
+
+// readLimit reads a message of at most max bytes with codec, refusing a
+// bigger one before it is read if the codec can, and after if not.
+func readLimit(codec Codec, r *bufio.Reader, max int64) ([]byte, error) {
+	if l, ok := codec.(limitedCodec); ok {
+		return l.readLimit(r, max)
+	}
+	m, err := codec.Read(r)
+	if err == nil && int64(len(m)) > max {
+		return nil, tooBig(int64(len(m)), max)
+	}
+	return m, err
+}
+
+// tooBig is the error for a message of sz bytes, over the limit of max.
+func tooBig(sz, max int64) error {
+	return fmt.Errorf("message of %d bytes is over the limit of %d", sz, max)
+}
+
+// BinaryCodec is the 9P encoding itself.
+var BinaryCodec Codec = binaryCodec{}
+
+type binaryCodec struct{}
+
+// Read refuses messages bigger than MaxMsize. Clients and servers read
+// with the msize they agreed to instead.
+func (binaryCodec) Read(r *bufio.Reader) ([]byte, error) {
+	return frameReader{r}.frame(MaxMsize)
+}
+
+func (binaryCodec) readLimit(r *bufio.Reader, max int64) ([]byte, error) {
+	return frameReader{r}.frame(max)
+}
+
+func (binaryCodec) whole(b []byte) bool {
+	return framed(b, 0)
+}
+
+// framed reports whether b holds a 9P message followed by extra bytes.
+// A header which is too short to be one counts, so that it is read, and
+// refused, at once.
+func framed(b []byte, extra int) bool {
+	if len(b) < 7 {
+		return false
+	}
+	sz := int(b[0]) | int(b[1])<<8 | int(b[2])<<16 | int(b[3])<<24
+	return sz < 7 || len(b) >= sz+extra
+}
+
+func (binaryCodec) Write(w io.Writer, m []byte) error {
+	_, err := w.Write(m)
+	return err
+}
+
+// CRCCodec follows each 9P message with its CRC-32, little endian, for
+// links which can corrupt data without noticing. A message which fails
+// the check is an error, which ends the connection: there is no way to
+// know which request it was.
+var CRCCodec Codec = crcCodec{}
+
+type crcCodec struct{}
+
+// Read refuses messages bigger than MaxMsize, as BinaryCodec's does.
+func (c crcCodec) Read(r *bufio.Reader) ([]byte, error) {
+	return c.readLimit(r, MaxMsize)
+}
+
+func (crcCodec) readLimit(r *bufio.Reader, max int64) ([]byte, error) {
+	m, err := frameReader{r}.frame(max)
+	if err != nil {
+		return nil, err
+	}
+	var c [4]byte
+	if _, err := io.ReadFull(r, c[:]); err != nil {
+		return nil, err
+	}
+	want := uint32(c[0]) | uint32(c[1])<<8 | uint32(c[2])<<16 | uint32(c[3])<<24
+	if got := crc32.ChecksumIEEE(m); got != want {
+		return nil, fmt.Errorf("%v: CRC %#08x, want %#08x", RPCNames[MType(m[4])], got, want)
+	}
+	return m, nil
+}
+
+func (crcCodec) whole(b []byte) bool {
+	return framed(b, 4)
+}
+
+func (crcCodec) Write(w io.Writer, m []byte) error {
+	c := crc32.ChecksumIEEE(m)
+	_, err := w.Write(append(m[:len(m):len(m)], byte(c), byte(c>>8), byte(c>>16), byte(c>>24)))
+	return err
+}
+
+// HexCodec writes each message on a line of its own, as the name of its
+// type and the message in hex, e.g.
+//
+//	Tclunk 0b000000780100ff000000
+//
+// which is easy to read, and to type into a debugging session.
+var HexCodec Codec = hexCodec{}
+
+type hexCodec struct{}
+
+func (hexCodec) whole(b []byte) bool {
+	return bytes.IndexByte(b, '\n') >= 0
+}
+
+func (h hexCodec) Read(r *bufio.Reader) ([]byte, error) {
+	return h.readLimit(r, MaxMsize)
+}
+
+func (hexCodec) readLimit(r *bufio.Reader, max int64) ([]byte, error) {
+	// The line is the type's name, a space, the message in hex, and
+	// a newline.
+	l, err := readLine(r, 2*max+32)
+	if err != nil {
+		return nil, err
+	}
+	f := strings.Fields(l)
+	if len(f) != 2 {
+		return nil, fmt.Errorf("%q: want a type and hex", l)
+	}
+	m, err := hex.DecodeString(f[1])
+	if err != nil {
+		return nil, err
+	}
+	if len(m) < 7 {
+		return nil, fmt.Errorf("%q: message of %d bytes is too short", l, len(m))
+	}
+	if sz := int(m[0]) | int(m[1])<<8 | int(m[2])<<16 | int(m[3])<<24; sz != len(m) {
+		return nil, fmt.Errorf("%q: size %d, but %d bytes", l, sz, len(m))
+	}
+	if int64(len(m)) > max {
+		return nil, tooBig(int64(len(m)), max)
+	}
+	return m, nil
+}
+
+// readLine reads a line, newline and all, refusing one of more than max
+// bytes before it has all arrived.
+func readLine(r *bufio.Reader, max int64) (string, error) {
+	var b []byte
+	for {
+		s, err := r.ReadSlice('\n')
+		if int64(len(b)+len(s)) > max {
+			return "", fmt.Errorf("line of over %d bytes", max)
+		}
+		b = append(b, s...)
+		if err != bufio.ErrBufferFull {
+			return string(b), err
+		}
+	}
+}
+
+func (hexCodec) Write(w io.Writer, m []byte) error {
+	n, ok := RPCNames[MType(m[4])]
+	if !ok {
+		n = fmt.Sprintf("type%d", m[4])
+	}
+	_, err := fmt.Fprintf(w, "%v %x\n", n, m)
+	return err
+}
@@ -0,0 +1,149 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestCodecs(t *testing.T) {
+	for _, codec := range []Codec{BinaryCodec, CRCCodec, HexCodec} {
+		s, err := NewListener(func() NineServer { return newDirServer() }, func(l *Listener) error {
+			l.Codec = codec
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("NewListener: want nil, got %v", err)
+		}
+		c := pipeClient(t, s, func(c *Client) error {
+			c.Codec = codec
+			return nil
+		})
+		if _, _, err := c.CallTversion(8192, "9P2000"); err != nil {
+			t.Fatalf("%T: CallTversion: want nil, got %v", codec, err)
+		}
+		if _, err := c.CallTattach(0, NOFID, "glenda", ""); err != nil {
+			t.Fatalf("%T: CallTattach: want nil, got %v", codec, err)
+		}
+		f, err := c.Open(0, []string{"a"}, OREAD)
+		if err != nil {
+			t.Fatalf("%T: Open(a): want nil, got %v", codec, err)
+		}
+		if b, err := ioutil.ReadAll(f); err != nil || string(b) != "hello" {
+			t.Errorf("%T: ReadAll(a): got %q, %v, want hello, nil", codec, b, err)
+		}
+		f.Close()
+	}
+}
+
+func TestCRCCodec(t *testing.T) {
+	var m, w bytes.Buffer
+	MarshalTclunkPkt(&m, 1, 42)
+	if err := CRCCodec.Write(&w, m.Bytes()); err != nil {
+		t.Fatalf("Write: want nil, got %v", err)
+	}
+	good := append([]byte{}, w.Bytes()...)
+	if b, err := CRCCodec.Read(bufio.NewReader(bytes.NewReader(good))); err != nil || !bytes.Equal(b, m.Bytes()) {
+		t.Errorf("Read: got %v, %v, want %v, nil", b, err, m.Bytes())
+	}
+	bad := append([]byte{}, good...)
+	bad[8] ^= 1
+	if _, err := CRCCodec.Read(bufio.NewReader(bytes.NewReader(bad))); err == nil {
+		t.Errorf("Read of a corrupt message: want err, got nil")
+	}
+}
+
+func TestHexCodec(t *testing.T) {
+	var m, w bytes.Buffer
+	MarshalTclunkPkt(&m, 1, 42)
+	if err := HexCodec.Write(&w, m.Bytes()); err != nil {
+		t.Fatalf("Write: want nil, got %v", err)
+	}
+	if want := "Tclunk 0b0000007801002a000000\n"; w.String() != want {
+		t.Errorf("Write: got %q, want %q", w.String(), want)
+	}
+	for _, l := range []string{"Tclunk\n", "Tclunk 0b00\n", "Tclunk 0c000000780100ff000000\n", "Tclunk zz\n"} {
+		if _, err := HexCodec.Read(bufio.NewReader(bytes.NewBufferString(l))); err == nil {
+			t.Errorf("Read(%q): want err, got nil", l)
+		}
+	}
+
+	// A line too long for the limit is refused before it has all
+	// arrived.
+	r := io.MultiReader(strings.NewReader("Tclunk "), neverEnds{})
+	if _, err := readLimit(HexCodec, bufio.NewReader(r), 1000); err == nil {
+		t.Errorf("readLimit of an endless line: want err, got nil")
+	}
+	if _, err := readLimit(HexCodec, bufio.NewReader(bytes.NewReader(w.Bytes())), 10); err == nil {
+		t.Errorf("readLimit(%q, 10): want err, got nil", w.String())
+	}
+}
+
+// neverEnds is an endless stream of hex digits.
+type neverEnds struct{}
+
+func (neverEnds) Read(b []byte) (int, error) {
+	for i := range b {
+		b[i] = '0'
+	}
+	return len(b), nil
+}
+
+func TestCodecWhole(t *testing.T) {
+	var m bytes.Buffer
+	MarshalTclunkPkt(&m, 1, 42)
+	for _, codec := range []Codec{BinaryCodec, CRCCodec, HexCodec} {
+		var w bytes.Buffer
+		if err := codec.Write(&w, m.Bytes()); err != nil {
+			t.Fatalf("%T: Write: want nil, got %v", codec, err)
+		}
+		b := w.Bytes()
+		for _, tc := range []struct {
+			b    []byte
+			want bool
+		}{
+			{nil, false},
+			{b[:len(b)-1], false},
+			{b, true},
+		} {
+			if got := codec.(wholeCodec).whole(tc.b); got != tc.want {
+				t.Errorf("%T: whole(%q): got %v, want %v", codec, tc.b, got, tc.want)
+			}
+		}
+	}
+}
+
+// opaqueCodec is a codec which can't tell whether a message is whole.
+type opaqueCodec struct {
+	Codec
+}
+
+func TestPending(t *testing.T) {
+	var m bytes.Buffer
+	MarshalTclunkPkt(&m, 1, 42)
+	for _, tc := range []struct {
+		codec Codec
+		b     []byte
+		want  bool
+	}{
+		{nil, m.Bytes()[:m.Len()-1], false},
+		{nil, m.Bytes(), true},
+		{BinaryCodec, m.Bytes()[:m.Len()-1], false},
+		{BinaryCodec, m.Bytes(), true},
+		{opaqueCodec{BinaryCodec}, m.Bytes()[:m.Len()-1], false},
+		{opaqueCodec{BinaryCodec}, m.Bytes(), false},
+	} {
+		c := &conn{codec: tc.codec, r: newFrameReader(bytes.NewReader(tc.b))}
+		c.r.Peek(1)
+		if got := c.pending(); got != tc.want {
+			t.Errorf("pending() with %T and %d of %d bytes: got %v, want %v", tc.codec, len(tc.b), m.Len(), got, tc.want)
+		}
+	}
+}
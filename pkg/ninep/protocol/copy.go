@@ -0,0 +1,200 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// The copy extension has the server copy bytes from one of a client's
+// open fids to another, so that copying a file on a server does not
+// send it to the client and back:
+//
+//	size[4] Tcopy tag[2] fid[4] offset[8] dfid[4] doffset[8] count[8]
+//	size[4] Rcopy tag[2] count[8]
+//
+// As with Twrite, the count in Rcopy may be short, and a client wanting
+// all of it should carry on from there; 0 is the end of the source.
+// Servers whose NineServer is a Copier copy as the backend sees fit,
+// e.g. with copy_file_range; the rest copy by reads and writes on the
+// server. The connection does nothing else while a Tcopy is being done,
+// so clients with other work for it should copy in pieces.
+const (
+	Tcopy MType = 162 + iota
+	Rcopy
+)
+
+// CopyExtension is the name of the copy extension.
+const CopyExtension = "copy"
+
+// A Copier is a NineServer which can copy count bytes from fid, at
+// offset, to dfid, at doffset, itself. It returns how many it copied.
+type Copier interface {
+	Rcopy(fid FID, offset Offset, dfid FID, doffset Offset, count uint64) (uint64, error)
+}
+
+func init() {
+	if err := RegisterExtension(&Extension{
+		Name:     CopyExtension,
+		Handlers: map[MType]Dispatcher{Tcopy: dispatchCopy},
+		Names:    map[MType]string{Tcopy: "Tcopy", Rcopy: "Rcopy"},
+	}); err != nil {
+		panic(err)
+	}
+}
+
+func get64(d []byte) uint64 {
+	return uint64(d[0]) | uint64(d[1])<<8 | uint64(d[2])<<16 | uint64(d[3])<<24 |
+		uint64(d[4])<<32 | uint64(d[5])<<40 | uint64(d[6])<<48 | uint64(d[7])<<56
+}
+
+func put64(b *bytes.Buffer, v uint64) {
+	b.Write([]byte{byte(v), byte(v >> 8), byte(v >> 16), byte(v >> 24), byte(v >> 32), byte(v >> 40), byte(v >> 48), byte(v >> 56)})
+}
+
+func put32(b *bytes.Buffer, v uint32) {
+	b.Write([]byte{byte(v), byte(v >> 8), byte(v >> 16), byte(v >> 24)})
+}
+
+// putSize sets the size of the message in b.
+func putSize(b *bytes.Buffer) {
+	d := b.Bytes()
+	l := len(d)
+	d[0], d[1], d[2], d[3] = byte(l), byte(l>>8), byte(l>>16), byte(l>>24)
+}
+
+// MarshalTcopyPkt puts a Tcopy in b.
+func MarshalTcopyPkt(b *bytes.Buffer, t Tag, fid FID, offset Offset, dfid FID, doffset Offset, count uint64) {
+	b.Reset()
+	b.Write([]byte{0, 0, 0, 0, uint8(Tcopy), byte(t), byte(t >> 8)})
+	put32(b, uint32(fid))
+	put64(b, uint64(offset))
+	put32(b, uint32(dfid))
+	put64(b, uint64(doffset))
+	put64(b, count)
+	putSize(b)
+}
+
+// MarshalRcopyPkt puts an Rcopy in b.
+func MarshalRcopyPkt(b *bytes.Buffer, t Tag, count uint64) {
+	b.Reset()
+	b.Write([]byte{0, 0, 0, 0, uint8(Rcopy), byte(t), byte(t >> 8)})
+	put64(b, count)
+	putSize(b)
+}
+
+func dispatchCopy(s *Server, b *bytes.Buffer, t MType) error {
+	// tag[2] fid[4] offset[8] dfid[4] doffset[8] count[8]
+	d := b.Bytes()
+	if len(d) != 34 {
+		var tag Tag
+		if len(d) >= 2 {
+			tag = Tag(d[0]) | Tag(d[1])<<8
+		}
+		err := Errorf(ErrInvalid, "Tcopy: %d bytes, want 34", len(d))
+		MarshalRerrorPkt(b, tag, err.Error())
+		return err
+	}
+	tag := Tag(d[0]) | Tag(d[1])<<8
+	fid := FID(d[2]) | FID(d[3])<<8 | FID(d[4])<<16 | FID(d[5])<<24
+	o := Offset(get64(d[6:]))
+	dfid := FID(d[14]) | FID(d[15])<<8 | FID(d[16])<<16 | FID(d[17])<<24
+	do := Offset(get64(d[18:]))
+	count := get64(d[26:])
+
+	var n uint64
+	var err error
+	if c, ok := s.NS.(Copier); ok {
+		n, err = c.Rcopy(fid, o, dfid, do, count)
+	} else {
+		n, err = CopyByReads(s.NS, fid, o, dfid, do, count)
+	}
+	// As with a short write, what was copied counts for more than
+	// why the rest wasn't.
+	if err != nil && n == 0 {
+		MarshalRerrorPkt(b, tag, err.Error())
+		return nil
+	}
+	MarshalRcopyPkt(b, tag, n)
+	return nil
+}
+
+// CopyByReads copies with reads and writes of ns, as a Tcopy is done for
+// a NineServer which is not a Copier. It is for those which wrap another
+// NineServer, and must have an Rcopy of their own to pass it on with.
+func CopyByReads(ns NineServer, fid FID, o Offset, dfid FID, do Offset, count uint64) (uint64, error) {
+	var n uint64
+	for n < count {
+		c := count - n
+		if c > writeChunk {
+			c = writeChunk
+		}
+		d, err := ns.Rread(fid, o+Offset(n), Count(c))
+		if err != nil || len(d) == 0 {
+			return n, err
+		}
+		w, err := ns.Rwrite(dfid, do+Offset(n), d)
+		if w > 0 {
+			n += uint64(w)
+		}
+		if err != nil || int(w) < len(d) {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// Copy copies count bytes from fid, at offset, to dfid, at doffset, both
+// open, and returns how many it copied, which is short only at the end
+// of fid, or with an error. If the server agreed to the copy extension
+// the server copies them; otherwise they are read and written here.
+func (c *Client) Copy(fid FID, offset int64, dfid FID, doffset int64, count int64) (int64, error) {
+	var n int64
+	for n < count {
+		var w int64
+		if c.HasExtension(CopyExtension) {
+			var b bytes.Buffer
+			MarshalTcopyPkt(&b, 0, fid, Offset(offset+n), dfid, Offset(doffset+n), uint64(count-n))
+			r := make(chan []byte)
+			c.FromClient <- &RPCCall{b: b.Bytes(), Reply: r}
+			bb := <-r
+			if err := replyErr(bb); err != nil {
+				return n, err
+			}
+			if MType(bb[4]) != Rcopy || len(bb) != 15 {
+				return n, fmt.Errorf("Tcopy: got %v of %d bytes, want Rcopy", RPCNames[MType(bb[4])], len(bb))
+			}
+			w = int64(get64(bb[7:]))
+		} else {
+			m := count - n
+			if max := int64(c.Msize) - IOHDRSZ; m > max {
+				m = max
+			}
+			d, err := c.CallTread(fid, Offset(offset+n), Count(m))
+			if err != nil {
+				return n, err
+			}
+			if len(d) == 0 {
+				break
+			}
+			wc, err := c.CallTwrite(dfid, Offset(doffset+n), d)
+			if wc > 0 {
+				w = int64(wc)
+			}
+			if err == nil && int(wc) < len(d) {
+				err = fmt.Errorf("short write")
+			}
+			if err != nil {
+				return n + w, err
+			}
+		}
+		if w == 0 {
+			break
+		}
+		n += w
+	}
+	return n, nil
+}
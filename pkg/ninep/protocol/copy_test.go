@@ -0,0 +1,42 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"testing"
+)
+
+func TestCopy(t *testing.T) {
+	for _, exts := range [][]string{{CopyExtension}, nil} {
+		ds := newDirServer()
+		ds.files["b"] = &Dir{QID: QID{Path: 3}, Mode: 0644, Name: "b", User: "1000", Group: "1000", ModUser: "glenda"}
+		ds.data["b"] = []byte("world")
+		c := newPackClient(t, "9P2000", ds)
+		if _, _, got, err := c.Version(8192, "9P2000", exts...); err != nil || len(got) != len(exts) {
+			t.Fatalf("Version(%v): got %v, %v, want %v", exts, got, err, exts)
+		}
+		if _, err := c.CallTattach(0, NOFID, "glenda", ""); err != nil {
+			t.Fatalf("CallTattach: want nil, got %v", err)
+		}
+		a, err := c.Open(0, []string{"a"}, OREAD)
+		if err != nil {
+			t.Fatalf("Open(a): want nil, got %v", err)
+		}
+		b, err := c.Open(0, []string{"b"}, ORDWR)
+		if err != nil {
+			t.Fatalf("Open(b): want nil, got %v", err)
+		}
+		// Past the end of a, the copy is short.
+		if n, err := c.Copy(a.FID(), 1, b.FID(), 2, 10); err != nil || n != 4 {
+			t.Errorf("%v: Copy: got %d, %v, want 4, nil", exts, n, err)
+		}
+		if got := string(ds.data["b"]); got != "woello" {
+			t.Errorf("%v: b after Copy: got %q, want woello", exts, got)
+		}
+		if _, err := c.Copy(a.FID(), 0, 99, 0, 1); err == nil {
+			t.Errorf("%v: Copy to a bad fid: want error, got nil", exts)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A Dialect is a version of 9P, as named in Tversion. Each connection
+// speaks one dialect, chosen by the client's Tversion, so one server can
+// have Plan 9 and Linux clients at the same time.
+//
+// Backends only ever see 9P2000: a NineServer is asked for "9P2000" in
+// Rversion no matter what the client asked for, and the dialect
+// translates its own messages into the NineServer calls.
+type Dialect struct {
+	// Version is the version string the client sends and we return.
+	Version string
+
+	// D dispatches messages once the dialect is chosen.
+	D Dispatcher
+}
+
+// The dialects a server knows. This is filled in by init, since the
+// dispatchers refer to it. The minimal build tag leaves only 9P2000.
+var dialects map[string]*Dialect
+
+func init() {
+	dialects = map[string]*Dialect{
+		"9P2000": {Version: "9P2000", D: Dispatch},
+	}
+	if !Minimal {
+		dialects["9P2000.u"] = &Dialect{Version: "9P2000.u", D: dispatchDotu}
+		dialects["9P2000.L"] = &Dialect{Version: "9P2000.L", D: dispatchDotl}
+	}
+}
+
+// scratchFID is used by dialects which must walk to a file to
+// do an operation that has no fid of its own, e.g. 9P2000.L's Tmkdir.
+// Dispatch is serial, so one is enough. Clients allocate fids from the
+// bottom up; we use the top.
+const scratchFID = NOFID - 1
+
+// dirOffset tracks a directory read in a dialect whose Dirs are a
+// different size than 9P2000's. The client's offsets count its bytes;
+// the NineServer's count 9P2000 bytes. held is Dirs read from the
+// NineServer which did not fit in the client's read, starting at the
+// client's offset.
+type dirOffset struct {
+	client Offset
+	server Offset
+	held   []byte
+}
+
+// version handles Tversion for all dialects. It picks the dialect and
+// extensions for the rest of the connection. Extensions are only
+// negotiated for dialects we know. Any other 9P2000 variant falls back
+// to plain 9P2000, as version(5) allows; other versions are passed to the
+// NineServer unchanged, so it gets to decide what to do.
+func (s *Server) version(b *bytes.Buffer) error {
+	msize, v, t, err := UnmarshalTversionPkt(b)
+	if err != nil {
+		MarshalRerrorPkt(b, t, fmt.Sprintf("%v", err))
+		return err
+	}
+	base, want := ParseVersion(v)
+	d, ok := dialects[base]
+	if !ok && strings.HasPrefix(base, "9P2000.") {
+		d, ok = dialects["9P2000"], true
+	}
+	if ok {
+		v = "9P2000"
+	}
+	max := s.maxMsize
+	if max == 0 {
+		max = MaxMsize
+	}
+	if msize > max {
+		msize = max
+	}
+	msize, v, err = s.NS.Rversion(msize, v)
+	if err != nil {
+		MarshalRerrorPkt(b, t, fmt.Sprintf("%v", err))
+		return nil
+	}
+	if msize > max {
+		msize = max
+	}
+	s.msize = msize
+	// A new Tversion starts a new session.
+	s.dirs = nil
+	s.exts = nil
+	s.D = Dispatch
+	s.lerrors = false
+	if ok && v == "9P2000" {
+		s.D = d.D
+		s.lerrors = d.Version == "9P2000.L"
+		v = FormatVersion(d.Version, s.negotiate(want))
+	}
+	MarshalRversionPkt(b, t, msize, v)
+	return nil
+}
+
+// peekFID returns the fid in a T message which starts with one, without
+// consuming it. b starts at the tag.
+func peekFID(b *bytes.Buffer) FID {
+	d := b.Bytes()
+	if len(d) < 6 {
+		return NOFID
+	}
+	return FID(d[2]) | FID(d[3])<<8 | FID(d[4])<<16 | FID(d[5])<<24
+}
+
+// replyType returns the type of the reply a dispatcher left in b.
+func replyType(b *bytes.Buffer) MType {
+	if b.Len() < 5 {
+		return Rerror
+	}
+	return MType(b.Bytes()[4])
+}
+
+// replyError returns the error string of an Rerror left in b.
+func replyError(b *bytes.Buffer) (string, Tag) {
+	s, t, _ := UnmarshalRerrorPkt(bytes.NewBuffer(b.Bytes()[5:]))
+	return s, t
+}
+
+// nextDir reads one Dir from a buffer of them, as returned by a
+// directory read. It uses the size, so anything after the fields it
+// knows about is skipped.
+func nextDir(r *bytes.Buffer) (Dir, error) {
+	d := r.Bytes()
+	if len(d) < 2 {
+		return Dir{}, fmt.Errorf("short Dir: %d bytes", len(d))
+	}
+	l := 2 + int(d[0]) + int(d[1])<<8
+	if len(d) < l {
+		return Dir{}, fmt.Errorf("short Dir: need %d bytes, have %d", l, len(d))
+	}
+	r.Next(l)
+	return Unmarshaldir(bytes.NewBuffer(d[:l]))
+}
+
+// numericID returns the numeric form of a user or group name, for
+// dialects which have them. Names which are not numbers have none.
+func numericID(s string) uint32 {
+	n, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return NONUNAME
+	}
+	return uint32(n)
+}
+
+// noChange returns a Dir which, sent in a Twstat, changes nothing.
+// A Twstat of such a Dir asks the server to sync the file to disk.
+func noChange() Dir {
+	return Dir{
+		Type:   ^uint16(0),
+		Dev:    ^uint32(0),
+		QID:    QID{Type: ^uint8(0), Version: ^uint32(0), Path: ^uint64(0)},
+		Mode:   ^uint32(0),
+		Atime:  ^uint32(0),
+		Mtime:  ^uint32(0),
+		Length: ^uint64(0),
+	}
+}
@@ -0,0 +1,413 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"testing"
+)
+
+// dirServer is a NineServer with a root directory holding one file,
+// "a", and whatever is created in it. It's just enough to see that
+// dialects translate correctly.
+type dirServer struct {
+	files map[string]*Dir
+	fids  map[FID]string
+	data  map[string][]byte
+	// wstat records the last Twstat.
+	wstat Dir
+	// If short is set, reads and writes of files move at most
+	// that many bytes.
+	short int
+}
+
+func newDirServer() *dirServer {
+	s := &dirServer{
+		files: map[string]*Dir{},
+		fids:  map[FID]string{},
+		data:  map[string][]byte{},
+	}
+	s.files["/"] = &Dir{QID: QID{Type: QTDIR, Path: 1}, Mode: DMDIR | 0755, Name: "/", User: "1000", Group: "1000", ModUser: "glenda"}
+	s.files["a"] = &Dir{QID: QID{Path: 2}, Mode: 0644, Length: 5, Name: "a", User: "1000", Group: "1000", ModUser: "glenda"}
+	s.data["a"] = []byte("hello")
+	return s
+}
+
+func (s *dirServer) Rversion(msize MaxSize, version string) (MaxSize, string, error) {
+	if version != "9P2000" {
+		return 0, "", fmt.Errorf("%v not supported; only 9P2000", version)
+	}
+	return msize, version, nil
+}
+
+func (s *dirServer) Rattach(fid FID, afid FID, uname string, aname string) (QID, error) {
+	s.fids[fid] = "/"
+	return s.files["/"].QID, nil
+}
+
+func (s *dirServer) Rflush(o Tag) error {
+	return nil
+}
+
+func (s *dirServer) Rwalk(fid FID, newfid FID, paths []string) ([]QID, error) {
+	n, ok := s.fids[fid]
+	if !ok {
+		return nil, fmt.Errorf("fid unknown")
+	}
+	if len(paths) == 0 {
+		s.fids[newfid] = n
+		return nil, nil
+	}
+	d, ok := s.files[paths[0]]
+	if n != "/" || !ok || len(paths) > 1 {
+		return nil, fmt.Errorf("file does not exist")
+	}
+	s.fids[newfid] = paths[0]
+	return []QID{d.QID}, nil
+}
+
+func (s *dirServer) Ropen(fid FID, mode Mode) (QID, MaxSize, error) {
+	n, ok := s.fids[fid]
+	if !ok {
+		return QID{}, 0, fmt.Errorf("fid unknown")
+	}
+	return s.files[n].QID, 8192, nil
+}
+
+func (s *dirServer) Rcreate(fid FID, name string, perm Perm, mode Mode) (QID, MaxSize, error) {
+	if _, ok := s.files[name]; ok {
+		return QID{}, 0, fmt.Errorf("file exists")
+	}
+	d := &Dir{QID: QID{Path: uint64(len(s.files) + 1)}, Mode: uint32(perm), Name: name, User: "1000", Group: "1000"}
+	if perm&DMDIR != 0 {
+		d.QID.Type = QTDIR
+	}
+	s.files[name] = d
+	s.fids[fid] = name
+	return d.QID, 8192, nil
+}
+
+func (s *dirServer) Rclunk(fid FID) error {
+	if _, ok := s.fids[fid]; !ok {
+		return fmt.Errorf("fid unknown")
+	}
+	delete(s.fids, fid)
+	return nil
+}
+
+func (s *dirServer) Rstat(fid FID) ([]byte, error) {
+	n, ok := s.fids[fid]
+	if !ok {
+		return nil, fmt.Errorf("fid unknown")
+	}
+	var b bytes.Buffer
+	Marshaldir(&b, *s.files[n])
+	return b.Bytes(), nil
+}
+
+func (s *dirServer) Rwstat(fid FID, b []byte) error {
+	d, err := Unmarshaldir(bytes.NewBuffer(b))
+	if err != nil {
+		return err
+	}
+	s.wstat = d
+	n := s.fids[fid]
+	if d.Name != "" {
+		f := s.files[n]
+		delete(s.files, n)
+		f.Name = d.Name
+		s.files[d.Name] = f
+		s.fids[fid] = d.Name
+	}
+	return nil
+}
+
+func (s *dirServer) Rremove(fid FID) error {
+	n := s.fids[fid]
+	delete(s.fids, fid)
+	if _, ok := s.files[n]; !ok {
+		return fmt.Errorf("file does not exist")
+	}
+	delete(s.files, n)
+	return nil
+}
+
+// Rread of the directory returns one Dir per read, in name order,
+// starting at the one whose offset is o.
+func (s *dirServer) Rread(fid FID, o Offset, c Count) ([]byte, error) {
+	n, ok := s.fids[fid]
+	if !ok {
+		return nil, fmt.Errorf("fid unknown")
+	}
+	if n != "/" {
+		d := s.data[n]
+		if int(o) >= len(d) {
+			return nil, nil
+		}
+		d = d[o:]
+		if len(d) > int(c) {
+			d = d[:c]
+		}
+		if s.short > 0 && len(d) > s.short {
+			d = d[:s.short]
+		}
+		return d, nil
+	}
+	var all bytes.Buffer
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		if d, ok := s.files[name]; ok {
+			var b bytes.Buffer
+			Marshaldir(&b, *d)
+			if all.Len() == int(o) {
+				if b.Len() > int(c) {
+					return nil, fmt.Errorf("count %d too small", c)
+				}
+				return b.Bytes(), nil
+			}
+			all.Write(b.Bytes())
+		}
+	}
+	return nil, nil
+}
+
+func (s *dirServer) Rwrite(fid FID, o Offset, b []byte) (Count, error) {
+	n, ok := s.fids[fid]
+	if !ok {
+		return 0, fmt.Errorf("fid unknown")
+	}
+	if s.short > 0 && len(b) > s.short {
+		b = b[:s.short]
+	}
+	d := s.data[n]
+	for len(d) < int(o) {
+		d = append(d, 0)
+	}
+	d = append(d[:o], b...)
+	if len(d) < len(s.data[n]) {
+		d = append(d, s.data[n][len(d):]...)
+	}
+	s.data[n] = d
+	s.files[n].Length = uint64(len(d))
+	s.files[n].QID.Version++
+	return Count(len(b)), nil
+}
+
+// rpc sends a raw T message, and returns the type and body of the reply.
+func rpc(c *Client, b *bytes.Buffer) (MType, *bytes.Buffer) {
+	r := make(chan []byte)
+	c.FromClient <- &RPCCall{b: b.Bytes(), Reply: r}
+	bb := <-r
+	return MType(bb[4]), bytes.NewBuffer(bb[5:])
+}
+
+func newDialectClient(t *testing.T, version string) (*Client, *dirServer) {
+	ds := newDirServer()
+	return newPackClient(t, version, ds), ds
+}
+
+// pipeClient has l serve one end of a net.Pipe, and returns a client
+// of the other end, with an msize of 8192 unless opts change it. It is
+// internal/ninetest's Pipe, which this package's tests can't import.
+func pipeClient(t testing.TB, l *Listener, opts ...ClientOpt) *Client {
+	t.Helper()
+	p, p2 := net.Pipe()
+	t.Cleanup(func() { p.Close() })
+	c, err := NewClient(append([]ClientOpt{func(c *Client) error {
+		c.FromNet, c.ToNet = p, p
+		c.Msize = 8192
+		return nil
+	}}, opts...)...)
+	if err != nil {
+		t.Fatalf("NewClient: want nil, got %v", err)
+	}
+	if err := l.Accept(p2); err != nil {
+		t.Fatalf("Accept: want nil, got %v", err)
+	}
+	return c
+}
+
+// serveClient is pipeClient, for a new Listener of ns.
+func serveClient(t testing.TB, ns NineServer, opts ...ListenerOpt) *Client {
+	t.Helper()
+	l, err := NewListener(func() NineServer { return ns }, opts...)
+	if err != nil {
+		t.Fatalf("NewListener: want nil, got %v", err)
+	}
+	return pipeClient(t, l)
+}
+
+// newPackClient returns a client of ns which has agreed on version.
+func newPackClient(t *testing.T, version string, ns NineServer, opts ...ListenerOpt) *Client {
+	c := serveClient(t, ns, opts...)
+	_, v, err := c.CallTversion(8192, version)
+	if err != nil {
+		t.Fatalf("CallTversion(%q): want nil, got %v", version, err)
+	}
+	if v != version {
+		t.Fatalf("CallTversion(%q): got version %q", version, v)
+	}
+	return c
+}
+
+func TestDotu(t *testing.T) {
+	skipMinimal(t, "9P2000.u")
+	c, ds := newDialectClient(t, "9P2000.u")
+
+	var b bytes.Buffer
+	MarshalTattachDotuPkt(&b, 0, 1, NOFID, "glenda", "", 1000)
+	if typ, _ := rpc(c, &b); typ != Rattach {
+		t.Fatalf("Tattach: want Rattach, got %v", RPCNames[typ])
+	}
+
+	MarshalTstatPkt(&b, 0, 1)
+	typ, r := rpc(c, &b)
+	if typ != Rstat {
+		t.Fatalf("Tstat: want Rstat, got %v", RPCNames[typ])
+	}
+	st, _, err := UnmarshalRstatPkt(r)
+	if err != nil {
+		t.Fatalf("UnmarshalRstatPkt: want nil, got %v", err)
+	}
+	d, ext, uid, gid, muid, err := UnmarshaldirDotu(bytes.NewBuffer(st))
+	if err != nil {
+		t.Fatalf("UnmarshaldirDotu: want nil, got %v", err)
+	}
+	if d.Name != "/" || ext != "" || uid != 1000 || gid != 1000 || muid != NONUNAME {
+		t.Errorf("Tstat: got %v %q %v %v %v, want / \"\" 1000 1000 %v", d, ext, uid, gid, muid, NONUNAME)
+	}
+
+	// Directory reads are translated, and their offsets count
+	// 9P2000.u bytes.
+	MarshalTopenPkt(&b, 0, 1, OREAD)
+	if typ, _ := rpc(c, &b); typ != Ropen {
+		t.Fatalf("Topen: want Ropen, got %v", RPCNames[typ])
+	}
+	MarshalTreadPkt(&b, 0, 1, 0, 1000)
+	typ, r = rpc(c, &b)
+	if typ != Rread {
+		t.Fatalf("Tread: want Rread, got %v", RPCNames[typ])
+	}
+	data, _, _ := UnmarshalRreadPkt(r)
+	if d, _, _, _, _, err := UnmarshaldirDotu(bytes.NewBuffer(data)); err != nil || d.Name != "a" {
+		t.Errorf("Tread of /: want a, nil, got %v, %v", d.Name, err)
+	}
+	MarshalTreadPkt(&b, 0, 1, 7, 1000)
+	if typ, r = rpc(c, &b); typ != Rerror {
+		t.Fatalf("Tread at offset 7: want Rerror, got %v", RPCNames[typ])
+	}
+	MarshalTreadPkt(&b, 0, 1, Offset(len(data)), 1000)
+	typ, r = rpc(c, &b)
+	if data, _, _ := UnmarshalRreadPkt(r); typ != Rread || len(data) != 0 {
+		t.Errorf("Tread at end: want Rread of 0 bytes, got %v %v", RPCNames[typ], data)
+	}
+
+	// Errors carry an errno.
+	MarshalTwalkPkt(&b, 0, 1, 2, []string{"nope"})
+	if typ, r = rpc(c, &b); typ != Rerror {
+		t.Fatalf("Twalk to nope: want Rerror, got %v", RPCNames[typ])
+	}
+	if s, e, _, err := UnmarshalRerrorDotuPkt(r); err != nil || e != ENOENT {
+		t.Errorf("Twalk to nope: want ENOENT, got %q %v %v", s, e, err)
+	}
+
+	var w bytes.Buffer
+	nd := noChange()
+	nd.Mode = 0600
+	MarshaldirDotu(&w, nd, "", NONUNAME, NONUNAME, NONUNAME)
+	MarshalTwstatPkt(&b, 0, 1, w.Bytes())
+	if typ, _ = rpc(c, &b); typ != Rwstat {
+		t.Fatalf("Twstat: want Rwstat, got %v", RPCNames[typ])
+	}
+	if ds.wstat.Mode != 0600 {
+		t.Errorf("Twstat: want mode 0600, got %o", ds.wstat.Mode)
+	}
+}
+
+func TestDotl(t *testing.T) {
+	skipMinimal(t, "9P2000.L")
+	c, ds := newDialectClient(t, "9P2000.L")
+
+	var b bytes.Buffer
+	MarshalTattachDotuPkt(&b, 0, 1, NOFID, "glenda", "", 1000)
+	if typ, _ := rpc(c, &b); typ != Rattach {
+		t.Fatalf("Tattach: want Rattach, got %v", RPCNames[typ])
+	}
+
+	MarshalTgetattrPkt(&b, 0, 1, getattrBasic)
+	typ, r := rpc(c, &b)
+	if typ != Rgetattr {
+		t.Fatalf("Tgetattr: want Rgetattr, got %v", RPCNames[typ])
+	}
+	_, q, mode, uid, _, _, _, _, _, _, _, _, _, _, _, _, _, _, _, _, _, err := UnmarshalRgetattrPkt(r)
+	if err != nil || q.Path != 1 || mode != sIFDIR|0755 || uid != 1000 {
+		t.Errorf("Tgetattr: got qid %v mode %o uid %v err %v, want path 1 mode %o uid 1000", q, mode, uid, err, sIFDIR|0755)
+	}
+
+	MarshalTmkdirPkt(&b, 0, 1, "d", 0700, 1000)
+	if typ, _ = rpc(c, &b); typ != Rmkdir {
+		t.Fatalf("Tmkdir: want Rmkdir, got %v", RPCNames[typ])
+	}
+	if d, ok := ds.files["d"]; !ok || d.Mode != DMDIR|0700 {
+		t.Errorf("Tmkdir: got %v, want a directory with mode 0700", d)
+	}
+	if _, ok := ds.fids[1]; !ok {
+		t.Errorf("Tmkdir: the directory fid has gone")
+	}
+
+	MarshalTlopenPkt(&b, 0, 1, 0)
+	if typ, _ = rpc(c, &b); typ != Rlopen {
+		t.Fatalf("Tlopen: want Rlopen, got %v", RPCNames[typ])
+	}
+	var names []string
+	var o Offset
+	for i := 0; i < 10; i++ {
+		MarshalTreaddirPkt(&b, 0, 1, o, 1000)
+		typ, r = rpc(c, &b)
+		if typ != Rreaddir {
+			t.Fatalf("Treaddir: want Rreaddir, got %v", RPCNames[typ])
+		}
+		data, _, _ := UnmarshalRreaddirPkt(r)
+		if len(data) == 0 {
+			break
+		}
+		// qid[13] offset[8] type[1] name[s]
+		off := data[13:21]
+		o = Offset(off[0]) | Offset(off[1])<<8 | Offset(off[2])<<16 | Offset(off[3])<<24
+		names = append(names, string(data[24:]))
+	}
+	if fmt.Sprint(names) != "[a d]" {
+		t.Errorf("Treaddir: want [a d], got %v", names)
+	}
+
+	MarshalTrenameatPkt(&b, 0, 1, "a", 1, "b")
+	if typ, _ = rpc(c, &b); typ != Rrenameat {
+		t.Fatalf("Trenameat: want Rrenameat, got %v", RPCNames[typ])
+	}
+	if _, ok := ds.files["b"]; !ok {
+		t.Errorf("Trenameat: b does not exist")
+	}
+
+	MarshalTunlinkatPkt(&b, 0, 1, "b", 0)
+	if typ, _ = rpc(c, &b); typ != Runlinkat {
+		t.Fatalf("Tunlinkat: want Runlinkat, got %v", RPCNames[typ])
+	}
+	MarshalTunlinkatPkt(&b, 0, 1, "b", 0)
+	if typ, r = rpc(c, &b); typ != Rlerror {
+		t.Fatalf("Tunlinkat of missing file: want Rlerror, got %v", RPCNames[typ])
+	}
+	if e, _, err := UnmarshalRlerrorPkt(r); err != nil || e != ENOENT {
+		t.Errorf("Tunlinkat of missing file: want ENOENT, got %v %v", e, err)
+	}
+
+	MarshalTsetattrPkt(&b, 0, 1, setattrSize|setattrMTime|setattrMTimeSet, 0, 0, 0, 42, 0, 0, 1234, 0)
+	if typ, _ = rpc(c, &b); typ != Rsetattr {
+		t.Fatalf("Tsetattr: want Rsetattr, got %v", RPCNames[typ])
+	}
+	if ds.wstat.Length != 42 || ds.wstat.Mtime != 1234 || ds.wstat.Mode != ^uint32(0) {
+		t.Errorf("Tsetattr: got %v, want length 42, mtime 1234, mode unchanged", ds.wstat)
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// A DirTooBigError is returned by a directory read whose count is too
+// small for the next entry. An empty read is the end of a directory, so
+// this is the only way to say so. A read of Need bytes will get it.
+type DirTooBigError struct {
+	Count Count
+	Need  int
+}
+
+func (e *DirTooBigError) Error() string {
+	return fmt.Sprintf("read count %d too small for a directory entry of %d bytes", e.Count, e.Need)
+}
+
+// A DirPacker packs the entries of a directory into reads. 9P does not
+// let an entry be split between reads, so one which doesn't fit in a read
+// is held for the next. Backends keep one with each open directory.
+type DirPacker struct {
+	held []byte
+}
+
+// Reset drops any held entry, for a read which starts again at offset 0.
+func (p *DirPacker) Reset() {
+	p.held = nil
+}
+
+// Pack returns as many entries as fit in count bytes, starting with the
+// held one, if any. next returns each entry in turn, and io.EOF after the
+// last. If not even one entry fits, Pack returns a *DirTooBigError and
+// keeps the entry for the next read. If next fails after some entries
+// were packed, Pack returns them, and the next read will call next again.
+func (p *DirPacker) Pack(count Count, next func() (Dir, error)) ([]byte, error) {
+	var b bytes.Buffer
+	for {
+		e := p.held
+		p.held = nil
+		if e == nil {
+			d, err := next()
+			if err == io.EOF {
+				return b.Bytes(), nil
+			}
+			if err != nil {
+				if b.Len() > 0 {
+					return b.Bytes(), nil
+				}
+				return nil, err
+			}
+			var m bytes.Buffer
+			Marshaldir(&m, d)
+			e = m.Bytes()
+		}
+		if b.Len()+len(e) > int(count) {
+			p.held = e
+			if b.Len() == 0 {
+				return nil, &DirTooBigError{Count: count, Need: len(e)}
+			}
+			return b.Bytes(), nil
+		}
+		b.Write(e)
+	}
+}
@@ -0,0 +1,211 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// randomDirs returns n Dirs with names of random lengths, some long
+// enough to need most of a read.
+func randomDirs(r *rand.Rand, n int) []Dir {
+	var dirs []Dir
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("%d-%s", i, strings.Repeat("x", r.Intn(400)))
+		dirs = append(dirs, Dir{QID: QID{Path: uint64(i + 10)}, Mode: 0644, Name: name, User: "glenda", Group: "sys", ModUser: "glenda"})
+	}
+	return dirs
+}
+
+// dirLister returns the next function for a DirPacker over dirs.
+func dirLister(dirs []Dir) func() (Dir, error) {
+	return func() (Dir, error) {
+		if len(dirs) == 0 {
+			return Dir{}, io.EOF
+		}
+		d := dirs[0]
+		dirs = dirs[1:]
+		return d, nil
+	}
+}
+
+// TestDirPacker reads random directories with random counts, and checks
+// that a reader which retries a too small read with the count it is told
+// always gets every entry, once, in order.
+func TestDirPacker(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 200; i++ {
+		dirs := randomDirs(r, r.Intn(20))
+		next := dirLister(dirs)
+		var p DirPacker
+		var got []string
+		for reads := 0; ; reads++ {
+			if reads > 2*len(dirs)+1 {
+				t.Fatalf("dirs %d: no progress after %d reads, got %d entries", i, reads, len(got))
+			}
+			c := Count(r.Intn(600))
+			b, err := p.Pack(c, next)
+			var big *DirTooBigError
+			if errors.As(err, &big) {
+				if big.Count != c || big.Need <= int(c) {
+					t.Fatalf("dirs %d: Pack(%d): got %v", i, c, err)
+				}
+				c = Count(big.Need + r.Intn(100))
+				b, err = p.Pack(c, next)
+			}
+			if err != nil {
+				t.Fatalf("dirs %d: Pack(%d): want nil, got %v", i, c, err)
+			}
+			if len(b) > int(c) {
+				t.Fatalf("dirs %d: Pack(%d): got %d bytes", i, c, len(b))
+			}
+			if len(b) == 0 {
+				break
+			}
+			for rb := bytes.NewBuffer(b); rb.Len() > 0; {
+				d, err := nextDir(rb)
+				if err != nil {
+					t.Fatalf("dirs %d: Pack(%d): %v", i, c, err)
+				}
+				got = append(got, d.Name)
+			}
+		}
+		if len(got) != len(dirs) {
+			t.Fatalf("dirs %d: got %d entries, want %d", i, len(got), len(dirs))
+		}
+		for j := range got {
+			if got[j] != dirs[j].Name {
+				t.Fatalf("dirs %d: entry %d: got %q, want %q", i, j, got[j], dirs[j].Name)
+			}
+		}
+	}
+}
+
+// packServer is a dirServer whose root holds dirs, read with a DirPacker.
+type packServer struct {
+	*dirServer
+	dirs []Dir
+	next func() (Dir, error)
+	p    DirPacker
+}
+
+func (s *packServer) Rread(fid FID, o Offset, c Count) ([]byte, error) {
+	if s.fids[fid] != "/" {
+		return s.dirServer.Rread(fid, o, c)
+	}
+	if o == 0 {
+		s.p.Reset()
+		s.next = dirLister(s.dirs)
+	}
+	return s.p.Pack(c, s.next)
+}
+
+// TestDirReadProgress reads a directory of entries with long names in
+// every dialect, starting with small counts and doubling them when a
+// read fails, as a careful client would, and checks that every entry
+// is read.
+func TestDirReadProgress(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	for _, v := range []string{"9P2000", "9P2000.u", "9P2000.L"} {
+		if Minimal && v != "9P2000" {
+			continue
+		}
+		ps := &packServer{dirServer: newDirServer(), dirs: randomDirs(r, 50)}
+		c := newPackClient(t, v, ps)
+		var b bytes.Buffer
+		switch v {
+		case "9P2000":
+			MarshalTattachPkt(&b, 0, 1, NOFID, "glenda", "")
+		default:
+			MarshalTattachDotuPkt(&b, 0, 1, NOFID, "glenda", "", 1000)
+		}
+		if typ, _ := rpc(c, &b); typ != Rattach {
+			t.Fatalf("%v: Tattach: want Rattach, got %v", v, RPCNames[typ])
+		}
+		if v == "9P2000.L" {
+			MarshalTlopenPkt(&b, 0, 1, 0)
+		} else {
+			MarshalTopenPkt(&b, 0, 1, OREAD)
+		}
+		if typ, _ := rpc(c, &b); typ != Ropen && typ != Rlopen {
+			t.Fatalf("%v: open: got %v", v, RPCNames[typ])
+		}
+
+		var names []string
+		var o Offset
+		count := Count(1 + r.Intn(100))
+		for reads := 0; reads < 1000; reads++ {
+			if v == "9P2000.L" {
+				MarshalTreaddirPkt(&b, 0, 1, o, count)
+			} else {
+				MarshalTreadPkt(&b, 0, 1, o, count)
+			}
+			typ, rb := rpc(c, &b)
+			if typ == Rerror || typ == Rlerror {
+				if count >= 8192-IOHDRSZ {
+					t.Fatalf("%v: read of %d at %d: no progress", v, count, o)
+				}
+				count *= 2
+				if count > 8192-IOHDRSZ {
+					count = 8192 - IOHDRSZ
+				}
+				continue
+			}
+			var data []byte
+			if typ == Rreaddir {
+				data, _, _ = UnmarshalRreaddirPkt(rb)
+			} else {
+				data, _, _ = UnmarshalRreadPkt(rb)
+			}
+			if len(data) > int(count) {
+				t.Fatalf("%v: read of %d: got %d bytes", v, count, len(data))
+			}
+			if len(data) == 0 {
+				break
+			}
+			if v == "9P2000.L" {
+				// qid[13] offset[8] type[1] name[s]
+				for len(data) > 0 {
+					l := int(data[22]) | int(data[23])<<8
+					names = append(names, string(data[24:24+l]))
+					off := data[13:21]
+					o = Offset(off[0]) | Offset(off[1])<<8 | Offset(off[2])<<16 | Offset(off[3])<<24
+					data = data[24+l:]
+				}
+				continue
+			}
+			o += Offset(len(data))
+			for len(data) > 0 {
+				l := 2 + int(data[0]) | int(data[1])<<8
+				var d Dir
+				var err error
+				if v == "9P2000.u" {
+					d, _, _, _, _, err = UnmarshaldirDotu(bytes.NewBuffer(data[:l]))
+				} else {
+					d, err = Unmarshaldir(bytes.NewBuffer(data[:l]))
+				}
+				if err != nil {
+					t.Fatalf("%v: %v", v, err)
+				}
+				names = append(names, d.Name)
+				data = data[l:]
+			}
+		}
+		if len(names) != len(ps.dirs) {
+			t.Fatalf("%v: got %d entries, want %d", v, len(names), len(ps.dirs))
+		}
+		for i := range names {
+			if names[i] != ps.dirs[i].Name {
+				t.Errorf("%v: entry %d: got %q, want %q", v, i, names[i], ps.dirs[i].Name)
+			}
+		}
+	}
+}
@@ -0,0 +1,210 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// A DiskCache keeps copies of files read from a server in a directory on
+// local disk, so that they can still be read when the server can't be:
+// a read-mostly mirror, for e.g. build machines reading a toolchain tree
+// over a network which is sometimes down.
+//
+// While the server can be reached, a copy is used only if the file's
+// QID, length and modification time are as they were when it was made,
+// and directories are always read again. When it can't, which is when
+// the Client is nil or Dead, the copies are used as they are, and the
+// reads say so.
+type DiskCache struct {
+	dir string
+}
+
+// NewDiskCache returns a DiskCache which keeps its copies in dir,
+// which it creates if need be. Copies made by an earlier DiskCache in
+// the same dir are used.
+func NewDiskCache(dir string) (*DiskCache, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &DiskCache{dir: dir}, nil
+}
+
+// ErrNotCached is the error for a file which is not in the cache when
+// the server can't be reached.
+var ErrNotCached = fmt.Errorf("server unreachable, and file not cached")
+
+func online(c *Client) bool {
+	return c != nil && !c.IsDead()
+}
+
+// ReadFile returns the contents of the file names, from fid, and
+// whether they are stale, because the server could not be reached. The
+// names should always be from the same root.
+func (d *DiskCache) ReadFile(c *Client, fid FID, names []string) ([]byte, bool, error) {
+	_, b, stale, err := d.read(c, fid, names, false)
+	return b, stale, err
+}
+
+// ReadDir is like ReadFile, for the entries of a directory.
+func (d *DiskCache) ReadDir(c *Client, fid FID, names []string) ([]Dir, bool, error) {
+	_, b, stale, err := d.read(c, fid, names, true)
+	if err != nil {
+		return nil, stale, err
+	}
+	var dirs []Dir
+	for r := bytes.NewBuffer(b); r.Len() > 0; {
+		e, err := Unmarshaldir(r)
+		if err != nil {
+			return nil, stale, err
+		}
+		dirs = append(dirs, e)
+	}
+	return dirs, stale, nil
+}
+
+// Stat returns the Dir of the file names, from fid, and whether it is
+// stale. Only files which have been read are cached.
+func (d *DiskCache) Stat(c *Client, fid FID, names []string) (Dir, bool, error) {
+	if !online(c) {
+		dir, _, err := d.load(names)
+		return dir, true, err
+	}
+	dir, err := statNames(c, fid, names)
+	return dir, false, err
+}
+
+func (d *DiskCache) read(c *Client, fid FID, names []string, isDir bool) (Dir, []byte, bool, error) {
+	if !online(c) {
+		dir, b, err := d.load(names)
+		if err == nil && (dir.QID.Type&QTDIR != 0) != isDir {
+			err = fmt.Errorf("%v: cached as the wrong kind of file", path.Join(names...))
+		}
+		return dir, b, true, err
+	}
+
+	f, err := c.Open(fid, names, OREAD)
+	if err != nil {
+		return Dir{}, nil, false, err
+	}
+	defer f.Close()
+	st, err := c.CallTstat(f.FID())
+	if err != nil {
+		return Dir{}, nil, false, err
+	}
+	dir, err := Unmarshaldir(bytes.NewBuffer(st))
+	if err != nil {
+		return Dir{}, nil, false, err
+	}
+	if (dir.QID.Type&QTDIR != 0) != isDir {
+		return Dir{}, nil, false, fmt.Errorf("%v: wrong kind of file", path.Join(names...))
+	}
+	if !isDir {
+		if old, b, err := d.load(names); err == nil && sameFile(old, dir) {
+			return dir, b, false, nil
+		}
+	}
+
+	var b []byte
+	if isDir {
+		var buf bytes.Buffer
+		for {
+			dirs, err := f.Dirread()
+			for _, e := range dirs {
+				Marshaldir(&buf, e)
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return Dir{}, nil, false, err
+			}
+		}
+		b = buf.Bytes()
+	} else if b, err = ioutil.ReadAll(f); err != nil {
+		return Dir{}, nil, false, err
+	}
+	// The copy is only a copy: failing to keep it does not fail the read.
+	d.store(names, dir, b)
+	return dir, b, false, nil
+}
+
+// sameFile reports whether the file whose copy was made when it was old
+// is the same now, as far as a Dir can say.
+func sameFile(old, now Dir) bool {
+	return old.QID == now.QID && old.Length == now.Length && old.Mtime == now.Mtime
+}
+
+// statNames returns the Dir of the file names, from fid.
+func statNames(c *Client, fid FID, names []string) (Dir, error) {
+	w, err := c.Walk(fid, names)
+	if err != nil {
+		return Dir{}, err
+	}
+	defer c.CallTclunk(w.FID)
+	st, err := c.CallTstat(w.FID)
+	if err != nil {
+		return Dir{}, err
+	}
+	return Unmarshaldir(bytes.NewBuffer(st))
+}
+
+// file returns the name of the copy of the file names, which is named
+// for a hash of its path, so that the cache is flat.
+func (d *DiskCache) file(names []string) string {
+	return filepath.Join(d.dir, fmt.Sprintf("%x", sha256.Sum256([]byte(path.Join(names...)))))
+}
+
+// load returns the cached copy of the file names: its Dir, and then its
+// contents.
+func (d *DiskCache) load(names []string) (Dir, []byte, error) {
+	b, err := ioutil.ReadFile(d.file(names))
+	if os.IsNotExist(err) {
+		return Dir{}, nil, fmt.Errorf("%v: %w", path.Join(names...), ErrNotCached)
+	}
+	if err != nil {
+		return Dir{}, nil, err
+	}
+	r := bytes.NewBuffer(b)
+	dir, err := Unmarshaldir(r)
+	if err != nil {
+		return Dir{}, nil, fmt.Errorf("%v: bad cache entry: %v", path.Join(names...), err)
+	}
+	return dir, r.Bytes(), nil
+}
+
+// store keeps a copy of the file names. The copy is written under
+// another name and renamed into place, so that a crash never leaves a
+// partial copy to be trusted later.
+func (d *DiskCache) store(names []string, dir Dir, b []byte) error {
+	t, err := ioutil.TempFile(d.dir, "tmp")
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	Marshaldir(&buf, dir)
+	buf.Write(b)
+	if _, err := t.Write(buf.Bytes()); err != nil {
+		t.Close()
+		os.Remove(t.Name())
+		return err
+	}
+	if err := t.Close(); err != nil {
+		os.Remove(t.Name())
+		return err
+	}
+	if err := os.Rename(t.Name(), d.file(names)); err != nil {
+		os.Remove(t.Name())
+		return err
+	}
+	return nil
+}
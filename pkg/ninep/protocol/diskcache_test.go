@@ -0,0 +1,72 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestDiskCache(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "diskcache")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+	dc, err := NewDiskCache(tmpdir)
+	if err != nil {
+		t.Fatalf("NewDiskCache: want nil, got %v", err)
+	}
+
+	c, ds := newDialectClient(t, "9P2000")
+	if _, err := c.CallTattach(0, NOFID, "glenda", ""); err != nil {
+		t.Fatalf("CallTattach: want nil, got %v", err)
+	}
+	b, stale, err := dc.ReadFile(c, 0, []string{"a"})
+	if err != nil || stale || string(b) != "hello" {
+		t.Fatalf("ReadFile(a): got %q, %v, %v, want hello, fresh", b, stale, err)
+	}
+	dirs, stale, err := dc.ReadDir(c, 0, nil)
+	if err != nil || stale || len(dirs) != 1 || dirs[0].Name != "a" {
+		t.Fatalf("ReadDir(/): got %v, %v, %v, want a, fresh", dirs, stale, err)
+	}
+
+	// A copy of a file which has not changed is used, and one which has
+	// is not.
+	ds.data["a"] = []byte("HELLO")
+	if b, _, _ := dc.ReadFile(c, 0, []string{"a"}); string(b) != "hello" {
+		t.Errorf("ReadFile(a) with a good copy: got %q, want the copy", b)
+	}
+	ds.files["a"].QID.Version++
+	if b, _, _ := dc.ReadFile(c, 0, []string{"a"}); string(b) != "HELLO" {
+		t.Errorf("ReadFile(a) after a change: got %q, want HELLO", b)
+	}
+
+	// With the server gone, a new cache in the same place still has
+	// the copies, marked stale.
+	dc, err = NewDiskCache(tmpdir)
+	if err != nil {
+		t.Fatalf("NewDiskCache: want nil, got %v", err)
+	}
+	b, stale, err = dc.ReadFile(nil, 0, []string{"a"})
+	if err != nil || !stale || string(b) != "HELLO" {
+		t.Errorf("ReadFile(a) offline: got %q, %v, %v, want HELLO, stale", b, stale, err)
+	}
+	dirs, stale, err = dc.ReadDir(nil, 0, nil)
+	if err != nil || !stale || len(dirs) != 1 {
+		t.Errorf("ReadDir(/) offline: got %v, %v, %v, want a, stale", dirs, stale, err)
+	}
+	if d, stale, err := dc.Stat(nil, 0, []string{"a"}); err != nil || !stale || d.Length != 5 {
+		t.Errorf("Stat(a) offline: got %v, %v, %v, want a of 5 bytes, stale", d, stale, err)
+	}
+	if _, _, err := dc.ReadFile(nil, 0, []string{"b"}); !errors.Is(err, ErrNotCached) {
+		t.Errorf("ReadFile(b) offline: want ErrNotCached, got %v", err)
+	}
+	if _, _, err := dc.ReadDir(nil, 0, []string{"a"}); err == nil {
+		t.Errorf("ReadDir(a) offline: want err, got nil")
+	}
+}
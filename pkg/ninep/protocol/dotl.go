@@ -0,0 +1,388 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// Bits in Tsetattr's valid field.
+const (
+	setattrMode     = 0x1
+	setattrUID      = 0x2
+	setattrGID      = 0x4
+	setattrSize     = 0x8
+	setattrATime    = 0x10
+	setattrMTime    = 0x20
+	setattrATimeSet = 0x80
+	setattrMTimeSet = 0x100
+)
+
+// getattrBasic is what we fill in for Rgetattr: everything but btime,
+// gen and data version, which we don't know.
+const getattrBasic = 0x7ff
+
+// v9fsMagic is the file system type Linux reports for 9p mounts.
+const v9fsMagic = 0x01021997
+
+// Linux file type bits, for Rgetattr's mode and Rreaddir's type.
+const (
+	sIFDIR = 0040000
+	sIFREG = 0100000
+	sIFLNK = 0120000
+	dtDIR  = 4
+	dtREG  = 8
+	dtLNK  = 10
+)
+
+// dispatchDotl is the Dispatcher for 9P2000.L. The messages shared with
+// 9P2000 are passed to Dispatch; the rest are built from NineServer
+// calls. Errors are returned as Rlerror.
+func dispatchDotl(s *Server, b *bytes.Buffer, t MType) error {
+	var err error
+	switch t {
+	case Tversion:
+		return s.version(b)
+	case Tattach:
+		// Same wire format as 9P2000.u.
+		err = s.attachDotu(b)
+	case Tstatfs:
+		err = s.statfs(b)
+	case Tlopen:
+		err = s.lopen(b)
+	case Tlcreate:
+		err = s.lcreate(b)
+	case Tgetattr:
+		err = s.getattr(b)
+	case Tsetattr:
+		err = s.setattr(b)
+	case Treaddir:
+		err = s.readdir(b)
+	case Tfsync:
+		err = s.fsync(b)
+	case Tmkdir:
+		err = s.mkdir(b)
+	case Trenameat:
+		err = s.renameat(b)
+	case Tunlinkat:
+		err = s.unlinkat(b)
+	case Treadlink:
+		err = s.readlink(b)
+	case Tclunk, Tremove:
+		delete(s.dirs, peekFID(b))
+		err = Dispatch(s, b, t)
+	default:
+		// Anything not supported gets "not supported", i.e. ENOTSUP,
+		// which Linux handles gracefully for xattrs, locks and so on.
+		err = Dispatch(s, b, t)
+	}
+	if replyType(b) == Rerror {
+		e, tag := replyError(b)
+		MarshalRlerrorPkt(b, tag, errno(e))
+	}
+	return err
+}
+
+func (s *Server) statfs(b *bytes.Buffer) error {
+	_, t, err := UnmarshalTstatfsPkt(b)
+	if err != nil {
+		MarshalRerrorPkt(b, t, fmt.Sprintf("%v", err))
+		return err
+	}
+	// NineServer has no way to ask, so we can't say how full it is.
+	MarshalRstatfsPkt(b, t, v9fsMagic, 4096, 0, 0, 0, 0, 0, 0, 255)
+	return nil
+}
+
+func (s *Server) lopen(b *bytes.Buffer) error {
+	fid, flags, t, err := UnmarshalTlopenPkt(b)
+	if err != nil {
+		MarshalRerrorPkt(b, t, fmt.Sprintf("%v", err))
+		return err
+	}
+	q, iounit, err := s.NS.Ropen(fid, LinuxFlagsToMode(flags))
+	if err != nil {
+		MarshalRerrorPkt(b, t, fmt.Sprintf("%v", err))
+		return nil
+	}
+	MarshalRlopenPkt(b, t, q, iounit)
+	return nil
+}
+
+func (s *Server) lcreate(b *bytes.Buffer) error {
+	fid, name, flags, mode, _, t, err := UnmarshalTlcreatePkt(b)
+	if err != nil {
+		MarshalRerrorPkt(b, t, fmt.Sprintf("%v", err))
+		return err
+	}
+	q, iounit, err := s.NS.Rcreate(fid, name, Perm(mode&0777), LinuxFlagsToMode(flags))
+	if err != nil {
+		MarshalRerrorPkt(b, t, fmt.Sprintf("%v", err))
+		return nil
+	}
+	MarshalRlcreatePkt(b, t, q, iounit)
+	return nil
+}
+
+// stat returns the Dir for fid.
+func (s *Server) stat(fid FID) (Dir, error) {
+	st, err := s.NS.Rstat(fid)
+	if err != nil {
+		return Dir{}, err
+	}
+	return Unmarshaldir(bytes.NewBuffer(st))
+}
+
+func (s *Server) getattr(b *bytes.Buffer) error {
+	fid, _, t, err := UnmarshalTgetattrPkt(b)
+	if err != nil {
+		MarshalRerrorPkt(b, t, fmt.Sprintf("%v", err))
+		return err
+	}
+	d, err := s.stat(fid)
+	if err != nil {
+		MarshalRerrorPkt(b, t, fmt.Sprintf("%v", err))
+		return nil
+	}
+	mode := d.Mode & 0777
+	switch {
+	case d.Mode&DMDIR != 0:
+		mode |= sIFDIR
+	case d.Mode&DMSYMLINK != 0:
+		mode |= sIFLNK
+	default:
+		mode |= sIFREG
+	}
+	MarshalRgetattrPkt(b, t, getattrBasic, d.QID, mode, numericID(d.User), numericID(d.Group),
+		1, 0, d.Length, 4096, (d.Length+511)/512,
+		uint64(d.Atime), 0, uint64(d.Mtime), 0, uint64(d.Mtime), 0,
+		0, 0, 0, 0)
+	return nil
+}
+
+func (s *Server) setattr(b *bytes.Buffer) error {
+	fid, valid, mode, _, _, size, atime, _, mtime, _, t, err := UnmarshalTsetattrPkt(b)
+	if err != nil {
+		MarshalRerrorPkt(b, t, fmt.Sprintf("%v", err))
+		return err
+	}
+	if valid&(setattrUID|setattrGID) != 0 {
+		MarshalRerrorPkt(b, t, Errorf(ErrNotPermitted, "setattr: chown").Error())
+		return nil
+	}
+	d := noChange()
+	if valid&setattrMode != 0 {
+		d.Mode = mode & 0777
+		// 9P2000 wants the directory bit to stay as it is.
+		if od, err := s.stat(fid); err == nil {
+			d.Mode |= od.Mode & DMDIR
+		}
+	}
+	if valid&setattrSize != 0 {
+		d.Length = size
+	}
+	if valid&setattrATime != 0 {
+		d.Atime = TimeNow
+		if valid&setattrATimeSet != 0 {
+			d.Atime = uint32(atime)
+		}
+	}
+	if valid&setattrMTime != 0 {
+		d.Mtime = TimeNow
+		if valid&setattrMTimeSet != 0 {
+			d.Mtime = uint32(mtime)
+		}
+	}
+	var w bytes.Buffer
+	Marshaldir(&w, d)
+	if err := s.NS.Rwstat(fid, w.Bytes()); err != nil {
+		MarshalRerrorPkt(b, t, fmt.Sprintf("%v", err))
+		return nil
+	}
+	MarshalRsetattrPkt(b, t)
+	return nil
+}
+
+// readdir reads Dirs from the NineServer and returns them as Linux
+// dirents. Each dirent's offset is the 9P2000 offset just past its Dir,
+// which is where the client's next Treaddir will ask to start.
+func (s *Server) readdir(b *bytes.Buffer) error {
+	fid, o, c, t, err := UnmarshalTreaddirPkt(b)
+	if err != nil {
+		MarshalRerrorPkt(b, t, fmt.Sprintf("%v", err))
+		return err
+	}
+	if s.dirs == nil {
+		s.dirs = make(map[FID]*dirOffset)
+	}
+	off, ok := s.dirs[fid]
+	if !ok {
+		off = &dirOffset{}
+		s.dirs[fid] = off
+	}
+	// Dirs left over from the last read come first.
+	data := off.held
+	off.held = nil
+	if data == nil || o != off.client {
+		// A dirent is always smaller than the Dir it comes from,
+		// so we can ask for the full count. If the next Dir doesn't
+		// fit, its dirent still may.
+		data, err = s.NS.Rread(fid, o, c)
+		var big *DirTooBigError
+		if errors.As(err, &big) {
+			data, err = s.NS.Rread(fid, o, Count(big.Need))
+		}
+		if err != nil {
+			MarshalRerrorPkt(b, t, fmt.Sprintf("%v", err))
+			return nil
+		}
+	}
+	var ents bytes.Buffer
+	for r := bytes.NewBuffer(data); r.Len() > 0; {
+		rest := r.Bytes()
+		d, err := nextDir(r)
+		if err != nil {
+			MarshalRerrorPkt(b, t, fmt.Sprintf("%v", err))
+			return err
+		}
+		next := uint64(o) + uint64(len(data)-r.Len())
+		if ents.Len()+direntLen(d) > int(c) {
+			// Keep the rest for the next read, which will start here.
+			off.held = rest
+			off.client = o + Offset(len(data)-len(rest))
+			if ents.Len() == 0 {
+				MarshalRerrorPkt(b, t, (&DirTooBigError{Count: c, Need: direntLen(d)}).Error())
+				return nil
+			}
+			break
+		}
+		typ := uint8(dtREG)
+		switch {
+		case d.QID.Type&QTDIR != 0:
+			typ = dtDIR
+		case d.QID.Type&QTSYMLINK != 0:
+			typ = dtLNK
+		}
+		q := d.QID
+		ents.Write([]byte{q.Type,
+			uint8(q.Version), uint8(q.Version >> 8), uint8(q.Version >> 16), uint8(q.Version >> 24),
+			uint8(q.Path), uint8(q.Path >> 8), uint8(q.Path >> 16), uint8(q.Path >> 24),
+			uint8(q.Path >> 32), uint8(q.Path >> 40), uint8(q.Path >> 48), uint8(q.Path >> 56),
+			uint8(next), uint8(next >> 8), uint8(next >> 16), uint8(next >> 24),
+			uint8(next >> 32), uint8(next >> 40), uint8(next >> 48), uint8(next >> 56),
+			typ,
+			uint8(len(d.Name)), uint8(len(d.Name) >> 8)})
+		ents.WriteString(d.Name)
+	}
+	MarshalRreaddirPkt(b, t, ents.Bytes())
+	return nil
+}
+
+// direntLen is the size of d as a Linux dirent: qid[13] offset[8]
+// type[1] name[s].
+func direntLen(d Dir) int {
+	return QIDLen + 8 + 1 + 2 + len(d.Name)
+}
+
+func (s *Server) fsync(b *bytes.Buffer) error {
+	fid, _, t, err := UnmarshalTfsyncPkt(b)
+	if err != nil {
+		MarshalRerrorPkt(b, t, fmt.Sprintf("%v", err))
+		return err
+	}
+	var w bytes.Buffer
+	Marshaldir(&w, noChange())
+	if err := s.NS.Rwstat(fid, w.Bytes()); err != nil {
+		MarshalRerrorPkt(b, t, fmt.Sprintf("%v", err))
+		return nil
+	}
+	MarshalRfsyncPkt(b, t)
+	return nil
+}
+
+// walkScratch walks scratchFID from dfid to name.
+func (s *Server) walkScratch(dfid FID, name ...string) error {
+	q, err := s.NS.Rwalk(dfid, scratchFID, name)
+	if err != nil {
+		return err
+	}
+	if len(q) != len(name) {
+		s.NS.Rclunk(scratchFID)
+		return Errorf(ErrNotExist, "%v", name)
+	}
+	return nil
+}
+
+func (s *Server) mkdir(b *bytes.Buffer) error {
+	dfid, name, mode, _, t, err := UnmarshalTmkdirPkt(b)
+	if err != nil {
+		MarshalRerrorPkt(b, t, fmt.Sprintf("%v", err))
+		return err
+	}
+	// Tcreate would move dfid to the new directory; use a copy.
+	if err := s.walkScratch(dfid); err != nil {
+		MarshalRerrorPkt(b, t, fmt.Sprintf("%v", err))
+		return nil
+	}
+	q, _, err := s.NS.Rcreate(scratchFID, name, Perm(DMDIR|mode&0777), OREAD)
+	s.NS.Rclunk(scratchFID)
+	if err != nil {
+		MarshalRerrorPkt(b, t, fmt.Sprintf("%v", err))
+		return nil
+	}
+	MarshalRmkdirPkt(b, t, q)
+	return nil
+}
+
+func (s *Server) renameat(b *bytes.Buffer) error {
+	odfid, oname, ndfid, nname, t, err := UnmarshalTrenameatPkt(b)
+	if err != nil {
+		MarshalRerrorPkt(b, t, fmt.Sprintf("%v", err))
+		return err
+	}
+	// Twstat can only rename within a directory. Linux copies
+	// when it sees EXDEV.
+	if odfid != ndfid {
+		MarshalRerrorPkt(b, t, Errorf(ErrCrossDevice, "renameat").Error())
+		return nil
+	}
+	if err := s.walkScratch(odfid, oname); err != nil {
+		MarshalRerrorPkt(b, t, fmt.Sprintf("%v", err))
+		return nil
+	}
+	d := noChange()
+	d.Name = nname
+	var w bytes.Buffer
+	Marshaldir(&w, d)
+	err = s.NS.Rwstat(scratchFID, w.Bytes())
+	s.NS.Rclunk(scratchFID)
+	if err != nil {
+		MarshalRerrorPkt(b, t, fmt.Sprintf("%v", err))
+		return nil
+	}
+	MarshalRrenameatPkt(b, t)
+	return nil
+}
+
+func (s *Server) unlinkat(b *bytes.Buffer) error {
+	dfid, name, _, t, err := UnmarshalTunlinkatPkt(b)
+	if err != nil {
+		MarshalRerrorPkt(b, t, fmt.Sprintf("%v", err))
+		return err
+	}
+	if err := s.walkScratch(dfid, name); err != nil {
+		MarshalRerrorPkt(b, t, fmt.Sprintf("%v", err))
+		return nil
+	}
+	// Tremove clunks, even if it fails.
+	if err := s.NS.Rremove(scratchFID); err != nil {
+		MarshalRerrorPkt(b, t, fmt.Sprintf("%v", err))
+		return nil
+	}
+	MarshalRunlinkatPkt(b, t)
+	return nil
+}
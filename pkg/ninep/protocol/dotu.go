@@ -0,0 +1,221 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// dotuExtra is how many more bytes a 9P2000.u Dir takes than a 9P2000 one:
+// an empty extension string and three numeric ids.
+const dotuExtra = 2 + 4 + 4 + 4
+
+// minDirLen is the size of the smallest 9P2000 Dir, including its size.
+const minDirLen = 2 + 2 + 4 + QIDLen + 4 + 4 + 4 + 8 + 2 + 2 + 2 + 2
+
+// dispatchDotu is the Dispatcher for 9P2000.u. Most messages are the same
+// as in 9P2000; the ones that carry Dirs or numeric ids are translated.
+func dispatchDotu(s *Server, b *bytes.Buffer, t MType) error {
+	var err error
+	switch t {
+	case Tversion:
+		return s.version(b)
+	case Tattach:
+		err = s.attachDotu(b)
+	case Topen:
+		fid := peekFID(b)
+		err = s.SrvRopen(b)
+		s.trackDir(b, fid)
+	case Tcreate:
+		err = s.createDotu(b)
+	case Tstat:
+		fid := peekFID(b)
+		err = s.SrvRstat(b)
+		if replyType(b) == Rstat {
+			err = s.statDotu(b, fid)
+		}
+	case Twstat:
+		err = s.wstatDotu(b)
+	case Tread:
+		err = s.readDotu(b)
+	case Tclunk, Tremove:
+		delete(s.dirs, peekFID(b))
+		err = Dispatch(s, b, t)
+	default:
+		err = Dispatch(s, b, t)
+	}
+	if replyType(b) == Rerror {
+		e, tag := replyError(b)
+		MarshalRerrorDotuPkt(b, tag, e, errno(e))
+	}
+	return err
+}
+
+// trackDir notes that fid is an open directory, if the reply in b says
+// so, since reads of it must have their Dirs translated.
+func (s *Server) trackDir(b *bytes.Buffer, fid FID) {
+	var q QID
+	var err error
+	switch replyType(b) {
+	case Ropen:
+		q, _, _, err = UnmarshalRopenPkt(bytes.NewBuffer(b.Bytes()[5:]))
+	case Rcreate:
+		q, _, _, err = UnmarshalRcreatePkt(bytes.NewBuffer(b.Bytes()[5:]))
+	default:
+		return
+	}
+	if err != nil || q.Type&QTDIR == 0 {
+		return
+	}
+	if s.dirs == nil {
+		s.dirs = make(map[FID]*dirOffset)
+	}
+	s.dirs[fid] = &dirOffset{}
+}
+
+func (s *Server) attachDotu(b *bytes.Buffer) error {
+	fid, afid, uname, aname, _, t, err := UnmarshalTattachDotuPkt(b)
+	if err != nil {
+		MarshalRerrorPkt(b, t, fmt.Sprintf("%v", err))
+		return err
+	}
+	q, err := s.NS.Rattach(fid, afid, uname, aname)
+	if err != nil {
+		MarshalRerrorPkt(b, t, fmt.Sprintf("%v", err))
+		return nil
+	}
+	MarshalRattachPkt(b, t, q)
+	return nil
+}
+
+func (s *Server) createDotu(b *bytes.Buffer) error {
+	fid, name, perm, mode, _, t, err := UnmarshalTcreateDotuPkt(b)
+	if err != nil {
+		MarshalRerrorPkt(b, t, fmt.Sprintf("%v", err))
+		return err
+	}
+	// NineServers can't make these; the extension says what they are.
+	if perm&(DMSYMLINK|DMDEVICE|DMNAMEDPIPE|DMSOCKET) != 0 {
+		MarshalRerrorPkt(b, t, Errorf(ErrNotSupported, "Tcreate: special files").Error())
+		return nil
+	}
+	q, iounit, err := s.NS.Rcreate(fid, name, perm, mode)
+	if err != nil {
+		MarshalRerrorPkt(b, t, fmt.Sprintf("%v", err))
+		return nil
+	}
+	MarshalRcreatePkt(b, t, q, iounit)
+	s.trackDir(b, fid)
+	return nil
+}
+
+// toDotu converts a 9P2000 Dir to a 9P2000.u one.
+func toDotu(b *bytes.Buffer, d Dir) {
+	MarshaldirDotu(b, d, "", numericID(d.User), numericID(d.Group), numericID(d.ModUser))
+}
+
+// statDotu converts the Rstat in b, of fid, to 9P2000.u. The extension
+// of a symbolic link is its target, if the NineServer is a Readlinker.
+func (s *Server) statDotu(b *bytes.Buffer, fid FID) error {
+	st, t, err := UnmarshalRstatPkt(bytes.NewBuffer(b.Bytes()[5:]))
+	if err != nil {
+		MarshalRerrorPkt(b, t, fmt.Sprintf("%v", err))
+		return err
+	}
+	d, err := Unmarshaldir(bytes.NewBuffer(st))
+	if err != nil {
+		MarshalRerrorPkt(b, t, fmt.Sprintf("%v", err))
+		return err
+	}
+	var ext string
+	if r, ok := s.NS.(Readlinker); ok && d.Mode&DMSYMLINK != 0 {
+		// A link which can't be read is still there to stat.
+		ext, _ = r.Rreadlink(fid)
+	}
+	var u bytes.Buffer
+	MarshaldirDotu(&u, d, ext, numericID(d.User), numericID(d.Group), numericID(d.ModUser))
+	MarshalRstatPkt(b, t, u.Bytes())
+	return nil
+}
+
+func (s *Server) wstatDotu(b *bytes.Buffer) error {
+	fid, st, t, err := UnmarshalTwstatPkt(b)
+	if err != nil {
+		MarshalRerrorPkt(b, t, fmt.Sprintf("%v", err))
+		return err
+	}
+	// The numeric ids can't be passed on; the names have to do.
+	d, _, _, _, _, err := UnmarshaldirDotu(bytes.NewBuffer(st))
+	if err != nil {
+		MarshalRerrorPkt(b, t, fmt.Sprintf("%v", err))
+		return err
+	}
+	var w bytes.Buffer
+	Marshaldir(&w, d)
+	if err := s.NS.Rwstat(fid, w.Bytes()); err != nil {
+		MarshalRerrorPkt(b, t, fmt.Sprintf("%v", err))
+		return nil
+	}
+	MarshalRwstatPkt(b, t)
+	return nil
+}
+
+// readDotu reads from fid. Directory reads have their Dirs translated.
+func (s *Server) readDotu(b *bytes.Buffer) error {
+	fid, o, c, t, err := UnmarshalTreadPkt(b)
+	if err != nil {
+		MarshalRerrorPkt(b, t, fmt.Sprintf("%v", err))
+		return err
+	}
+	off, ok := s.dirs[fid]
+	if !ok {
+		data, err := s.NS.Rread(fid, o, c)
+		if err != nil {
+			MarshalRerrorPkt(b, t, fmt.Sprintf("%v", err))
+			return nil
+		}
+		MarshalRreadPkt(b, t, data)
+		return nil
+	}
+
+	// Per the spec, a directory read continues where the last one
+	// left off or starts again at 0.
+	switch o {
+	case 0:
+		*off = dirOffset{}
+	case off.client:
+	default:
+		MarshalRerrorPkt(b, t, Errorf(ErrInvalid, "directory read: offset %d, want 0 or %d", o, off.client).Error())
+		return nil
+	}
+	// Ask for few enough 9P2000 bytes that the 9P2000.u Dirs fit in c.
+	data, err := s.NS.Rread(fid, off.server, c*minDirLen/(minDirLen+dotuExtra))
+	// An entry with long names may not fit in that, though it fits in c.
+	var big *DirTooBigError
+	if errors.As(err, &big) && big.Need+dotuExtra <= int(c) {
+		data, err = s.NS.Rread(fid, off.server, Count(big.Need))
+	}
+	if err != nil {
+		MarshalRerrorPkt(b, t, fmt.Sprintf("%v", err))
+		return nil
+	}
+	var u bytes.Buffer
+	for r := bytes.NewBuffer(data); r.Len() > 0; {
+		d, err := nextDir(r)
+		if err != nil {
+			MarshalRerrorPkt(b, t, fmt.Sprintf("%v", err))
+			return err
+		}
+		var e bytes.Buffer
+		toDotu(&e, d)
+		u.Write(e.Bytes())
+	}
+	off.server += Offset(len(data))
+	off.client += Offset(u.Len())
+	MarshalRreadPkt(b, t, u.Bytes())
+	return nil
+}
@@ -0,0 +1,127 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"syscall"
+)
+
+// An ErrorCode is an error of the catalog: one which servers send, and
+// clients can match, with errors.Is or Lookup, whatever words the
+// server used for it, so that programs need not look for strings in
+// error messages. A 9P2000 error is only a string, so the catalog knows
+// each ErrorCode by the strings which Plan 9 and Linux servers use for
+// it, and servers of this package use its Msg.
+type ErrorCode struct {
+	// ID names the error, and does not change.
+	ID string
+	// Msg is what servers of this package say.
+	Msg string
+	// Errno is the Linux errno, for 9P2000.u and 9P2000.L.
+	Errno uint32
+	// matches are what else other servers say, in lower case.
+	matches []string
+}
+
+func (e *ErrorCode) Error() string {
+	return e.Msg
+}
+
+// The catalog.
+var (
+	ErrPermission   = &ErrorCode{ID: "permission", Msg: "permission denied", Errno: EACCES}
+	ErrNotPermitted = &ErrorCode{ID: "not-permitted", Msg: "operation not permitted", Errno: EPERM, matches: []string{"not permitted"}}
+	ErrNotExist     = &ErrorCode{ID: "not-exist", Msg: "file does not exist", Errno: ENOENT, matches: []string{"does not exist", "no such file", "not found"}}
+	ErrExist        = &ErrorCode{ID: "exist", Msg: "file already exists", Errno: EEXIST, matches: []string{"file exists", "already exists"}}
+	ErrNotEmpty     = &ErrorCode{ID: "not-empty", Msg: "directory not empty", Errno: ENOTEMPTY, matches: []string{"not empty"}}
+	ErrNotDir       = &ErrorCode{ID: "not-dir", Msg: "not a directory", Errno: ENOTDIR}
+	ErrIsDir        = &ErrorCode{ID: "is-dir", Msg: "is a directory", Errno: EISDIR}
+	ErrReadOnly     = &ErrorCode{ID: "read-only", Msg: "read-only file system", Errno: EROFS, matches: []string{"read only"}}
+	ErrNoSpace      = &ErrorCode{ID: "no-space", Msg: "no space left on device", Errno: ENOSPC, matches: []string{"no space"}}
+	ErrCrossDevice  = &ErrorCode{ID: "cross-device", Msg: "cross-device link", Errno: EXDEV, matches: []string{"cross-device"}}
+	ErrUnknownFID   = &ErrorCode{ID: "unknown-fid", Msg: "fid unknown", Errno: EBADF, matches: []string{"unknown fid", "bad file descriptor"}}
+	ErrFIDInUse     = &ErrorCode{ID: "fid-in-use", Msg: "fid in use", Errno: EBADF, matches: []string{"fid already in use", "duplicate fid"}}
+	ErrNotOpen      = &ErrorCode{ID: "not-open", Msg: "file not open", Errno: EBADF, matches: []string{"not open"}}
+	ErrNotSupported = &ErrorCode{ID: "not-supported", Msg: "not supported", Errno: ENOTSUP}
+	ErrInvalid      = &ErrorCode{ID: "invalid", Msg: "invalid argument", Errno: EINVAL, matches: []string{"invalid"}}
+	// ErrIO is every error the catalog does not know.
+	ErrIO = &ErrorCode{ID: "io", Msg: "i/o error", Errno: EIO}
+)
+
+// catalog is the ErrorCodes, in the order they are looked for in error
+// strings: the first match wins, so more specific strings go first.
+var catalog = []*ErrorCode{
+	ErrPermission, ErrNotPermitted, ErrNotExist, ErrExist, ErrNotEmpty,
+	ErrNotDir, ErrIsDir, ErrReadOnly, ErrNoSpace, ErrCrossDevice,
+	ErrUnknownFID, ErrFIDInUse, ErrNotOpen, ErrNotSupported, ErrInvalid,
+}
+
+// Errorf returns an error, for a server to send, which says what the
+// format says, and then e's Msg, and which is e, to errors.Is, on both
+// ends of the connection.
+func Errorf(e *ErrorCode, format string, args ...interface{}) error {
+	return fmt.Errorf("%s: %w", fmt.Sprintf(format, args...), e)
+}
+
+// Lookup returns the ErrorCode of the catalog which err is, or ErrIO if it
+// is none of them, or nil if err is nil. Errors are found by what they
+// wrap, their errno, if they are a syscall.Errno, as from an Rlerror,
+// or their strings.
+func Lookup(err error) *ErrorCode {
+	if err == nil {
+		return nil
+	}
+	var e *ErrorCode
+	if errors.As(err, &e) {
+		return e
+	}
+	var n syscall.Errno
+	if errors.As(err, &n) {
+		for _, e := range catalog {
+			if e.Errno == uint32(n) {
+				return e
+			}
+		}
+	}
+	return lookup(err.Error())
+}
+
+// lookup returns the ErrorCode of the catalog which the error string s is.
+func lookup(s string) *ErrorCode {
+	l := strings.ToLower(s)
+	for _, e := range catalog {
+		if strings.Contains(l, e.Msg) {
+			return e
+		}
+		for _, m := range e.matches {
+			if strings.Contains(l, m) {
+				return e
+			}
+		}
+	}
+	return ErrIO
+}
+
+// errno finds the Linux errno for an error string. Anything we don't
+// recognize is EIO.
+func errno(s string) uint32 {
+	return lookup(s).Errno
+}
+
+// A remoteError is the error of an Rerror, which is the ErrorCode of
+// the catalog it says, to errors.Is.
+type remoteError string
+
+func (r remoteError) Error() string {
+	return string(r)
+}
+
+func (r remoteError) Is(target error) bool {
+	e, ok := target.(*ErrorCode)
+	return ok && lookup(string(r)) == e
+}
@@ -0,0 +1,88 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestLookup(t *testing.T) {
+	for _, tt := range []struct {
+		err  error
+		want *ErrorCode
+	}{
+		{nil, nil},
+		// Plan 9.
+		{fmt.Errorf("file does not exist"), ErrNotExist},
+		{fmt.Errorf("'x' file does not exist"), ErrNotExist},
+		{fmt.Errorf("file already exists"), ErrExist},
+		{fmt.Errorf("permission denied"), ErrPermission},
+		{fmt.Errorf("fid unknown or out of range"), ErrUnknownFID},
+		{fmt.Errorf("fid already in use"), ErrFIDInUse},
+		{fmt.Errorf("directory not empty"), ErrNotEmpty},
+		{fmt.Errorf("file system read only"), ErrReadOnly},
+		// Linux, and Go.
+		{fmt.Errorf("No such file or directory"), ErrNotExist},
+		{fmt.Errorf("File exists"), ErrExist},
+		{fmt.Errorf("Operation not permitted"), ErrNotPermitted},
+		{fmt.Errorf("Read-only file system"), ErrReadOnly},
+		{fmt.Errorf("Invalid cross-device link"), ErrCrossDevice},
+		{fmt.Errorf("Directory not empty"), ErrNotEmpty},
+		{fmt.Errorf("Not a directory"), ErrNotDir},
+		{&os.PathError{Op: "open", Path: "x", Err: os.ErrNotExist}, ErrNotExist},
+		{syscall.Errno(ENOSPC), ErrNoSpace},
+		{fmt.Errorf("write: %w", syscall.Errno(EROFS)), ErrReadOnly},
+		// The catalog's own, whatever they say.
+		{Errorf(ErrNotOpen, "fid %d does not exist", 3), ErrNotOpen},
+		{ErrIsDir, ErrIsDir},
+		{fmt.Errorf("something else"), ErrIO},
+	} {
+		if got := Lookup(tt.err); got != tt.want {
+			t.Errorf("Lookup(%v): got %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestErrorf(t *testing.T) {
+	err := Errorf(ErrNotDir, "%v", "a/b")
+	if got, want := err.Error(), "a/b: not a directory"; got != want {
+		t.Errorf("Errorf: got %q, want %q", got, want)
+	}
+	if !errors.Is(err, ErrNotDir) || errors.Is(err, ErrIsDir) {
+		t.Errorf("Errorf(ErrNotDir): errors.Is is wrong")
+	}
+	// The errno, for the other dialects, is the catalog's.
+	if got := errno(err.Error()); got != ENOTDIR {
+		t.Errorf("errno(%q): got %d, want %d", err, got, ENOTDIR)
+	}
+	for _, e := range catalog {
+		if got := lookup(e.Msg); got != e {
+			t.Errorf("lookup(%q): got %v, want %v", e.Msg, got.ID, e.ID)
+		}
+	}
+}
+
+func TestRemoteError(t *testing.T) {
+	ds := &deepServer{dirServer: newDirServer(), depth: map[FID]int{}}
+	c := newPackClient(t, "9P2000", ds)
+	if _, err := c.CallTattach(0, NOFID, "glenda", ""); err != nil {
+		t.Fatalf("CallTattach: want nil, got %v", err)
+	}
+	_, err := c.CallTwalk(0, 1, []string{"x"})
+	if err == nil {
+		t.Fatalf("CallTwalk(x): want error, got nil")
+	}
+	if !errors.Is(err, ErrNotExist) || errors.Is(err, ErrExist) || Lookup(err) != ErrNotExist {
+		t.Errorf("CallTwalk(x): got %v, want ErrNotExist", err)
+	}
+	_, err = c.CallTwalk(7, 1, nil)
+	if !errors.Is(err, ErrUnknownFID) {
+		t.Errorf("CallTwalk from fid 7: got %v, want ErrUnknownFID", err)
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// An Extension is an optional addition to 9P, usually a few new messages.
+// A client asks for extensions by appending them to the Tversion version
+// string, as in "9P2000.L+batch+copy". The server replies with the ones
+// it has as well, in the client's order; a server with none of them
+// replies with the plain version, and a client that asked for none is
+// never sent anything new. So clients and servers of any age can talk to
+// each other, and an extension is only used when both sides know it.
+type Extension struct {
+	// Name is what goes in the version string. It can't contain a '+'.
+	Name string
+
+	// Handlers dispatch the extension's T messages. They are only
+	// called on connections that negotiated the extension.
+	Handlers map[MType]Dispatcher
+
+	// Names names the extension's messages, for RPCNames.
+	Names map[MType]string
+}
+
+var (
+	extMu      sync.Mutex
+	extensions = map[string]*Extension{}
+)
+
+// RegisterExtension makes an extension known to clients and servers.
+// It must be called from an init function: it adds the extension's
+// message names to RPCNames, which is read without locking once
+// clients and servers are running. Registering the same name twice, or
+// a message type or name that is already in use, is an error.
+func RegisterExtension(e *Extension) error {
+	if e.Name == "" || strings.ContainsAny(e.Name, "+ ") {
+		return fmt.Errorf("RegisterExtension: bad name %q", e.Name)
+	}
+	extMu.Lock()
+	defer extMu.Unlock()
+	if _, ok := extensions[e.Name]; ok {
+		return fmt.Errorf("RegisterExtension: %q already registered", e.Name)
+	}
+	for t := range e.Handlers {
+		if n, ok := RPCNames[t]; ok {
+			return fmt.Errorf("RegisterExtension: %q: message type %d is already %v", e.Name, t, n)
+		}
+		for _, o := range extensions {
+			if _, ok := o.Handlers[t]; ok {
+				return fmt.Errorf("RegisterExtension: %q: message type %d is already used by %q", e.Name, t, o.Name)
+			}
+		}
+	}
+	for t := range e.Names {
+		if n, ok := RPCNames[t]; ok {
+			return fmt.Errorf("RegisterExtension: %q: message type %d is already named %v", e.Name, t, n)
+		}
+	}
+	for t, n := range e.Names {
+		RPCNames[t] = n
+	}
+	extensions[e.Name] = e
+	return nil
+}
+
+// lookupExtension returns the registered extension called name.
+func lookupExtension(name string) (*Extension, bool) {
+	extMu.Lock()
+	defer extMu.Unlock()
+	e, ok := extensions[name]
+	return e, ok
+}
+
+// ParseVersion splits a version string into the base version and the
+// extensions appended to it.
+func ParseVersion(v string) (string, []string) {
+	f := strings.Split(v, "+")
+	var exts []string
+	for _, e := range f[1:] {
+		if e != "" {
+			exts = append(exts, e)
+		}
+	}
+	return f[0], exts
+}
+
+// FormatVersion is the inverse of ParseVersion.
+func FormatVersion(base string, exts []string) string {
+	return strings.Join(append([]string{base}, exts...), "+")
+}
+
+// negotiate picks the extensions that were asked for and that this
+// server offers, and arranges for their messages to be dispatched.
+// The server's dispatcher must already be set for the dialect.
+func (s *Server) negotiate(want []string) []string {
+	var got []string
+	handlers := map[MType]Dispatcher{}
+	for _, n := range want {
+		if s.offer != nil && !s.offer[n] {
+			continue
+		}
+		e, ok := lookupExtension(n)
+		if !ok || s.exts[n] {
+			continue
+		}
+		if s.exts == nil {
+			s.exts = make(map[string]bool)
+		}
+		s.exts[n] = true
+		got = append(got, n)
+		for t, h := range e.Handlers {
+			handlers[t] = h
+		}
+	}
+	if len(handlers) == 0 {
+		return got
+	}
+	base := s.D
+	s.D = func(s *Server, b *bytes.Buffer, t MType) error {
+		if h, ok := handlers[t]; ok {
+			return h(s, b, t)
+		}
+		return base(s, b, t)
+	}
+	return got
+}
+
+// HasExtension reports whether the connection negotiated the extension.
+func (s *Server) HasExtension(name string) bool {
+	return s.exts[name]
+}
+
+// Version does a Tversion, asking for the base version and the extensions
+// in want. It returns the version the server chose, without extensions,
+// and the extensions it agreed to, which are remembered for HasExtension.
+func (c *Client) Version(msize MaxSize, base string, want ...string) (MaxSize, string, []string, error) {
+	msize, v, err := c.CallTversion(msize, FormatVersion(base, want))
+	if err != nil {
+		return 0, "", nil, err
+	}
+	v, got := ParseVersion(v)
+	asked := map[string]bool{}
+	for _, n := range want {
+		asked[n] = true
+	}
+	for _, n := range got {
+		if !asked[n] {
+			return 0, "", nil, fmt.Errorf("Version: server agreed to %q, which was not asked for", n)
+		}
+	}
+	c.Extensions = got
+	c.dialect = v
+	return msize, v, got, nil
+}
+
+// HasExtension reports whether the server agreed to the extension.
+func (c *Client) HasExtension(name string) bool {
+	for _, n := range c.Extensions {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,126 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+const (
+	tEcho MType = 240 + iota
+	rEcho
+)
+
+// echoExt replies to a tEcho with an rEcho with the same body.
+func echoExt(s *Server, b *bytes.Buffer, t MType) error {
+	d := append([]byte{}, b.Bytes()...)
+	l := uint32(len(d) + 5)
+	b.Reset()
+	b.Write([]byte{uint8(l), uint8(l >> 8), uint8(l >> 16), uint8(l >> 24), uint8(rEcho)})
+	b.Write(d)
+	return nil
+}
+
+func init() {
+	if err := RegisterExtension(&Extension{
+		Name:     "echo",
+		Handlers: map[MType]Dispatcher{tEcho: echoExt},
+		Names:    map[MType]string{tEcho: "Techo", rEcho: "Recho"},
+	}); err != nil {
+		panic(err)
+	}
+}
+
+func newExtClient(t *testing.T, opts ...ListenerOpt) *Client {
+	return serveClient(t, newDirServer(), opts...)
+}
+
+func TestVersionStrings(t *testing.T) {
+	for _, tt := range []struct {
+		v      string
+		base   string
+		exts   []string
+		format string
+	}{
+		{"9P2000", "9P2000", nil, "9P2000"},
+		{"9P2000.L+batch", "9P2000.L", []string{"batch"}, "9P2000.L+batch"},
+		{"9P2000.harvey+compress++batchstat", "9P2000.harvey", []string{"compress", "batchstat"}, "9P2000.harvey+compress+batchstat"},
+	} {
+		base, exts := ParseVersion(tt.v)
+		if base != tt.base || fmt.Sprint(exts) != fmt.Sprint(tt.exts) {
+			t.Errorf("ParseVersion(%q): got %q %q, want %q %q", tt.v, base, exts, tt.base, tt.exts)
+		}
+		if v := FormatVersion(tt.base, tt.exts); v != tt.format {
+			t.Errorf("FormatVersion(%q, %q): got %q, want %q", tt.base, tt.exts, v, tt.format)
+		}
+	}
+}
+
+func TestRegisterExtension(t *testing.T) {
+	for _, e := range []*Extension{
+		{Name: ""},
+		{Name: "a+b"},
+		{Name: "echo"},
+		{Name: "read", Handlers: map[MType]Dispatcher{Tread: echoExt}},
+		{Name: "echo2", Handlers: map[MType]Dispatcher{tEcho: echoExt}},
+		{Name: "rename", Names: map[MType]string{Rread: "Rrename"}},
+	} {
+		if err := RegisterExtension(e); err == nil {
+			t.Errorf("RegisterExtension(%q): want error, got nil", e.Name)
+		}
+	}
+	if n := RPCNames[Rread]; n != "Rread" {
+		t.Errorf("RPCNames[Rread]: got %q, want %q", n, "Rread")
+	}
+}
+
+func TestExtensions(t *testing.T) {
+	var b bytes.Buffer
+	// tag[2] data
+	techo := []byte{13, 0, 0, 0, uint8(tEcho), 0, 0, 'h', 'e', 'l', 'l', 'o', '!'}
+
+	c := newExtClient(t)
+	_, v, got, err := c.Version(8192, "9P2000.harvey", "echo", "nope")
+	if err != nil {
+		t.Fatalf("Version: want nil, got %v", err)
+	}
+	if v != "9P2000" || fmt.Sprint(got) != "[echo]" || !c.HasExtension("echo") {
+		t.Fatalf("Version: got %q %q, want 9P2000 [echo]", v, got)
+	}
+	b.Write(techo)
+	typ, r := rpc(c, &b)
+	if typ != rEcho || r.String()[2:] != "hello!" {
+		t.Errorf("Techo: got %v %q, want Recho hello!", RPCNames[typ], r.Bytes())
+	}
+
+	// A later Tversion without the extension turns it off.
+	skipMinimal(t, "9P2000.L")
+	_, v, got, err = c.Version(8192, "9P2000.L")
+	if err != nil || v != "9P2000.L" || len(got) != 0 {
+		t.Fatalf("Version: got %q %q %v, want 9P2000.L [] nil", v, got, err)
+	}
+	b.Reset()
+	b.Write(techo)
+	if typ, _ := rpc(c, &b); typ != Rlerror {
+		t.Errorf("Techo without echo: want Rlerror, got %v", RPCNames[typ])
+	}
+
+	// Servers may choose not to offer extensions.
+	c = newExtClient(t, func(l *Listener) error {
+		l.Extensions = []string{}
+		return nil
+	})
+	_, v, got, err = c.Version(8192, "9P2000", "echo")
+	if err != nil || v != "9P2000" || len(got) != 0 {
+		t.Fatalf("Version: got %q %q %v, want 9P2000 [] nil", v, got, err)
+	}
+	b.Reset()
+	b.Write(techo)
+	if typ, _ := rpc(c, &b); typ != Rerror {
+		t.Errorf("Techo without echo: want Rerror, got %v", RPCNames[typ])
+	}
+}
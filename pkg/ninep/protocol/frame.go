@@ -0,0 +1,190 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// frameReader reads 9P messages from a connection. The bufio.Reader lets
+// it look at a message's header before deciding where the rest goes, and
+// saves a system call per message when they are small and many.
+type frameReader struct {
+	*bufio.Reader
+}
+
+func newFrameReader(r io.Reader) frameReader {
+	return frameReader{bufio.NewReader(r)}
+}
+
+// header returns the size, type and tag of the next message, which is
+// left to be read.
+func (f frameReader) header() (int64, MType, Tag, error) {
+	h, err := f.Peek(7)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	sz := int64(h[0]) | int64(h[1])<<8 | int64(h[2])<<16 | int64(h[3])<<24
+	if sz < 7 {
+		return 0, 0, 0, fmt.Errorf("message of %d bytes is too short", sz)
+	}
+	return sz, MType(h[4]), Tag(h[5]) | Tag(h[6])<<8, nil
+}
+
+// frame reads the next message, size and all, into a slice of its own.
+// A message of more than max bytes is refused before any of it is read,
+// so that a peer can't have a slice made of whatever size it likes.
+func (f frameReader) frame(max int64) ([]byte, error) {
+	sz, _, _, err := f.header()
+	if err != nil {
+		return nil, err
+	}
+	if sz > max {
+		return nil, tooBig(sz, max)
+	}
+	b := make([]byte, sz)
+	if _, err := io.ReadFull(f, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// body reads the next message into a buffer from framePool, without its
+// size and type, which is how Dispatchers want it. The buffer should be
+// given back with putFrame once the reply in it has been sent.
+func (f frameReader) body() (*bytes.Buffer, MType, error) {
+	sz, t, _, err := f.header()
+	if err != nil {
+		return nil, 0, err
+	}
+	if _, err := f.Discard(5); err != nil {
+		return nil, 0, err
+	}
+	b := framePool.Get().(*bytes.Buffer)
+	b.Reset()
+	b.Grow(int(sz - 5))
+	if _, err := io.CopyN(b, f, sz-5); err != nil {
+		putFrame(b)
+		return nil, 0, err
+	}
+	return b, t, nil
+}
+
+// framePool holds the buffers the server reads messages and builds
+// replies in.
+var framePool = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+
+// maxPooledFrame is the biggest buffer kept in framePool, so that one
+// big message doesn't keep its memory for good.
+const maxPooledFrame = 64 * 1024
+
+func putFrame(b *bytes.Buffer) {
+	if b.Cap() <= maxPooledFrame {
+		framePool.Put(b)
+	}
+}
+
+// maxCoalesce is how many bytes of replies a frameWriter collects
+// before sending them. Replies as big as that go out on their own.
+const maxCoalesce = 64 * 1024
+
+// frameWriter collects replies, so that when a client sends several
+// requests at once, as it does when many files are being stat'd or
+// opened, the replies go out in one write rather than one each. They are
+// sent once the server has nothing more to do, or after a window, if
+// there is one, to catch requests which are on their way.
+type frameWriter struct {
+	w      io.Writer
+	window time.Duration
+	clock  Clock
+
+	// mu guards below.
+	mu    sync.Mutex
+	buf   bytes.Buffer
+	timer Timer
+	err   error
+	// n counts the replies in buf.
+	n int
+}
+
+// Write adds a reply to those waiting to be sent. Errors are those of
+// earlier writes to the connection.
+func (f *frameWriter) Write(m []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(m) >= maxCoalesce {
+		f.flushLocked()
+		if f.err == nil {
+			_, f.err = f.w.Write(m)
+		}
+	} else {
+		f.buf.Write(m)
+		if f.buf.Len() >= maxCoalesce {
+			f.flushLocked()
+		}
+	}
+	if f.err != nil {
+		return 0, f.err
+	}
+	return len(m), nil
+}
+
+// idle says there are no more requests to hand. Waiting replies are
+// sent, after the window if there is one.
+func (f *frameWriter) idle() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case f.window == 0:
+		f.flushLocked()
+	case f.timer == nil && f.buf.Len() > 0:
+		f.timer = f.clock.AfterFunc(f.window, f.flush)
+	}
+	return f.err
+}
+
+// replied says a whole reply has been written, and returns how many
+// are waiting to be sent.
+func (f *frameWriter) replied() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.buf.Len() > 0 {
+		f.n++
+	}
+	return f.n
+}
+
+// flush sends the waiting replies.
+func (f *frameWriter) flush() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.flushLocked()
+}
+
+// flushErr is flush, returning the error of this or an earlier write.
+func (f *frameWriter) flushErr() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.flushLocked()
+	return f.err
+}
+
+func (f *frameWriter) flushLocked() {
+	if f.timer != nil {
+		f.timer.Stop()
+		f.timer = nil
+	}
+	f.n = 0
+	if f.buf.Len() == 0 || f.err != nil {
+		return
+	}
+	_, f.err = f.w.Write(f.buf.Bytes())
+	f.buf.Reset()
+}
@@ -0,0 +1,110 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestFrameReader(t *testing.T) {
+	var stream, b bytes.Buffer
+	MarshalTclunkPkt(&b, 3, 42)
+	stream.Write(b.Bytes())
+	MarshalTwritePkt(&b, 4, 42, 0, bytes.Repeat([]byte("x"), 10000))
+	stream.Write(b.Bytes())
+	MarshalTclunkPkt(&b, 5, 43)
+	stream.Write(b.Bytes())
+	stream.Write([]byte{6, 0, 0, 0, 0, 0, 0})
+
+	// Messages come out whole, however the bytes arrive.
+	r := newFrameReader(iotest.OneByteReader(&stream))
+	f, err := r.frame(MaxMsize)
+	if err != nil || len(f) != 11 || MType(f[4]) != Tclunk {
+		t.Fatalf("frame: got %v, %v, want a Tclunk", f, err)
+	}
+	body, typ, err := r.body()
+	if err != nil || typ != Twrite {
+		t.Fatalf("body: got %v, %v, want Twrite", typ, err)
+	}
+	if _, o, d, tag, err := UnmarshalTwritePkt(body); err != nil || tag != 4 || o != 0 || len(d) != 10000 {
+		t.Errorf("body: got tag %d offset %d %d bytes, %v, want 4, 0, 10000, nil", tag, o, len(d), err)
+	}
+	putFrame(body)
+	if sz, typ, tag, err := r.header(); sz != 11 || typ != Tclunk || tag != 5 || err != nil {
+		t.Errorf("header: got %d %v %d %v, want 11 Tclunk 5 nil", sz, typ, tag, err)
+	}
+	if _, err := r.frame(10); err == nil {
+		t.Errorf("frame of 11 bytes, with a limit of 10: want err, got nil")
+	}
+	if _, err := r.frame(MaxMsize); err != nil {
+		t.Errorf("frame after header: want nil, got %v", err)
+	}
+	if _, err := r.frame(MaxMsize); err == nil || err == io.EOF {
+		t.Errorf("message claiming 6 bytes: want err, got %v", err)
+	}
+
+	// A message claiming to be huge is refused from its header, not
+	// read, or made room for.
+	r = newFrameReader(bytes.NewReader([]byte{0xff, 0xff, 0xff, 0xff, byte(Rread), 1, 0}))
+	if _, err := r.frame(8192); err == nil || !strings.Contains(err.Error(), "over the limit") {
+		t.Errorf("message claiming 4GiB: got %v, want it over the limit", err)
+	}
+}
+
+func BenchmarkFrameBody(b *testing.B) {
+	var m bytes.Buffer
+	MarshalTwalkPkt(&m, 1, 1, 2, []string{"usr", "glenda", "lib", "profile"})
+	stream := bytes.Repeat(m.Bytes(), b.N)
+	r := newFrameReader(bytes.NewReader(stream))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		f, _, err := r.body()
+		if err != nil {
+			b.Fatal(err)
+		}
+		putFrame(f)
+	}
+}
+
+// TestHugeReply checks that a client refuses a reply bigger than its
+// msize from its header, after the first, which is checked anyway.
+func TestHugeReply(t *testing.T) {
+	p, p2 := net.Pipe()
+	defer p2.Close()
+	go func() {
+		var b bytes.Buffer
+		p2.Read(make([]byte, 512))
+		MarshalRversionPkt(&b, NOTAG, 8192, "9P2000")
+		p2.Write(b.Bytes())
+		// Whatever comes next gets a reply claiming 4GiB.
+		p2.Read(make([]byte, 512))
+		p2.Write([]byte{0xff, 0xff, 0xff, 0xff, byte(Rclunk), 1, 0})
+		io.Copy(ioutil.Discard, p2)
+	}()
+	c, err := NewClient(func(c *Client) error {
+		c.FromNet, c.ToNet = p, p
+		c.Msize = 8192
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, _, err := c.CallTversion(8192, "9P2000"); err != nil {
+		t.Fatalf("CallTversion: want nil, got %v", err)
+	}
+	if err := c.CallTclunk(1); err == nil {
+		t.Errorf("CallTclunk with a reply claiming 4GiB: want error, got nil")
+	}
+	if err := c.Err(); err == nil || !strings.Contains(err.Error(), "over the limit of 8192") {
+		t.Errorf("Err: got %v, want the reply over the limit", err)
+	}
+}
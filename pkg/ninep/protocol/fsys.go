@@ -0,0 +1,165 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// FS returns an fs.FS of the files under fid, which is usually the fid
+// of an attach. Files are opened for reading. Directories implement
+// fs.ReadDirFile, and files io.ReaderAt.
+func (c *Client) FS(fid FID) fs.FS {
+	return &clientFS{c: c, root: fid}
+}
+
+type clientFS struct {
+	c    *Client
+	root FID
+}
+
+func (c *clientFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	var names []string
+	if name != "." {
+		names = strings.Split(name, "/")
+	}
+	f, err := c.c.OpenFile(c.root, names, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fsError(err)}
+	}
+	st, err := c.c.CallTstat(f.fid)
+	if err == nil {
+		var d Dir
+		if d, err = Unmarshaldir(bytes.NewBuffer(st)); err == nil {
+			d.Name = path.Base(name)
+			return &fsFile{ClientFile: f, info: dirInfo{d}}, nil
+		}
+	}
+	f.Close()
+	return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+}
+
+// fsError returns the fs error for an error from a server, if there is
+// one, so callers can use errors.Is.
+func fsError(err error) error {
+	switch Lookup(err) {
+	case ErrNotExist:
+		return fs.ErrNotExist
+	case ErrExist:
+		return fs.ErrExist
+	case ErrPermission, ErrNotPermitted:
+		return fs.ErrPermission
+	}
+	return err
+}
+
+type fsFile struct {
+	*ClientFile
+	info dirInfo
+
+	// Entries read from the server but not yet returned by ReadDir.
+	dirs []Dir
+	eof  bool
+}
+
+func (f *fsFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *fsFile) Read(p []byte) (int, error) {
+	if f.info.IsDir() {
+		return 0, &fs.PathError{Op: "read", Path: f.info.Name(), Err: errors.New("is a directory")}
+	}
+	return f.ClientFile.Read(p)
+}
+
+// Seek seeks the ClientFile. When a directory starts again at 0, the
+// entries ReadDir has not returned yet are dropped.
+func (f *fsFile) Seek(offset int64, whence int) (int64, error) {
+	o, err := f.ClientFile.Seek(offset, whence)
+	if err == nil && o == 0 && f.info.IsDir() {
+		f.dirs, f.eof = nil, false
+	}
+	return o, err
+}
+
+// ReadDir follows fs.ReadDirFile: with n > 0, it returns io.EOF only
+// when there are no more entries; otherwise it returns them all.
+func (f *fsFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !f.info.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: f.info.Name(), Err: errors.New("not a directory")}
+	}
+	for !f.eof && (n <= 0 || len(f.dirs) < n) {
+		d, err := f.Dirread()
+		if err == io.EOF {
+			f.eof = true
+			break
+		}
+		if err != nil {
+			return nil, &fs.PathError{Op: "readdir", Path: f.info.Name(), Err: err}
+		}
+		f.dirs = append(f.dirs, d...)
+	}
+	if n > 0 && len(f.dirs) == 0 {
+		return nil, io.EOF
+	}
+	if n <= 0 || n > len(f.dirs) {
+		n = len(f.dirs)
+	}
+	e := make([]fs.DirEntry, n)
+	for i := range e {
+		e[i] = dirInfo{f.dirs[i]}
+	}
+	f.dirs = f.dirs[n:]
+	return e, nil
+}
+
+// dirInfo is a Dir as an fs.FileInfo and an fs.DirEntry.
+type dirInfo struct {
+	d Dir
+}
+
+func (i dirInfo) Name() string {
+	return i.d.Name
+}
+
+func (i dirInfo) Size() int64 {
+	return int64(i.d.Length)
+}
+
+func (i dirInfo) Mode() fs.FileMode {
+	return PermToFileMode(i.d.Mode)
+}
+
+func (i dirInfo) ModTime() time.Time {
+	return time.Unix(int64(i.d.Mtime), 0)
+}
+
+func (i dirInfo) IsDir() bool {
+	return i.d.Mode&DMDIR != 0
+}
+
+// Sys returns the Dir.
+func (i dirInfo) Sys() interface{} {
+	return i.d
+}
+
+func (i dirInfo) Type() fs.FileMode {
+	return i.Mode().Type()
+}
+
+func (i dirInfo) Info() (fs.FileInfo, error) {
+	return i, nil
+}
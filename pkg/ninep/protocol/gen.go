@@ -42,6 +42,7 @@ import (
 "bytes"
 "fmt"
 _ "log"
+"sync/atomic"
 )
 `
 )
@@ -74,6 +75,10 @@ type pack struct {
 	tn string
 	r  interface{}
 	rn string
+	// codec packets are not part of the NineServer interface, so we only
+	// generate their marshal and unmarshal functions; the dialect code
+	// does the rest. A codec packet may have only a T or only an R.
+	codec bool
 }
 
 const (
@@ -106,6 +111,25 @@ var (
 		{n: "remove", t: protocol.TremovePkt{}, tn: "Tremove", r: protocol.RremovePkt{}, rn: "Rremove"},
 		{n: "read", t: protocol.TreadPkt{}, tn: "Tread", r: protocol.RreadPkt{}, rn: "Rread"},
 		{n: "write", t: protocol.TwritePkt{}, tn: "Twrite", r: protocol.RwritePkt{}, rn: "Rwrite"},
+
+		// 9P2000.u
+		{n: "error", r: protocol.RerrorDotuPkt{}, rn: "RerrorDotu", codec: true},
+		{n: "attach", t: protocol.TattachDotuPkt{}, tn: "TattachDotu", codec: true},
+		{n: "create", t: protocol.TcreateDotuPkt{}, tn: "TcreateDotu", codec: true},
+
+		// 9P2000.L
+		{n: "lerror", r: protocol.RlerrorPkt{}, rn: "Rlerror", codec: true},
+		{n: "statfs", t: protocol.TstatfsPkt{}, tn: "Tstatfs", r: protocol.RstatfsPkt{}, rn: "Rstatfs", codec: true},
+		{n: "lopen", t: protocol.TlopenPkt{}, tn: "Tlopen", r: protocol.RlopenPkt{}, rn: "Rlopen", codec: true},
+		{n: "lcreate", t: protocol.TlcreatePkt{}, tn: "Tlcreate", r: protocol.RlcreatePkt{}, rn: "Rlcreate", codec: true},
+		{n: "getattr", t: protocol.TgetattrPkt{}, tn: "Tgetattr", r: protocol.RgetattrPkt{}, rn: "Rgetattr", codec: true},
+		{n: "setattr", t: protocol.TsetattrPkt{}, tn: "Tsetattr", r: protocol.RsetattrPkt{}, rn: "Rsetattr", codec: true},
+		{n: "readdir", t: protocol.TreaddirPkt{}, tn: "Treaddir", r: protocol.RreaddirPkt{}, rn: "Rreaddir", codec: true},
+		{n: "fsync", t: protocol.TfsyncPkt{}, tn: "Tfsync", r: protocol.RfsyncPkt{}, rn: "Rfsync", codec: true},
+		{n: "mkdir", t: protocol.TmkdirPkt{}, tn: "Tmkdir", r: protocol.RmkdirPkt{}, rn: "Rmkdir", codec: true},
+		{n: "renameat", t: protocol.TrenameatPkt{}, tn: "Trenameat", r: protocol.RrenameatPkt{}, rn: "Rrenameat", codec: true},
+		{n: "unlinkat", t: protocol.TunlinkatPkt{}, tn: "Tunlinkat", r: protocol.RunlinkatPkt{}, rn: "Runlinkat", codec: true},
+		{n: "readlink", t: protocol.TreadlinkPkt{}, tn: "Treadlink", r: protocol.RreadlinkPkt{}, rn: "Rreadlink", codec: true},
 	}
 	msfunc = template.Must(template.New("ms").Parse(`func Marshal{{.MFunc}} (b *bytes.Buffer, {{.MParms}}) {
 var l uint64
@@ -171,19 +195,15 @@ return
 	cfunc = template.Must(template.New("s").Parse(`
 func (c *Client)Call{{.T.MFunc}} ({{.T.MParms}}) ({{.R.URet}} err error) {
 var b = bytes.Buffer{}
-if c.Trace != nil {c.Trace("%v", {{.T.MFunc}})}
+c.trace("%v", {{.T.MFunc}})
 t := Tag(0)
 r := make (chan []byte)
-if c.Trace != nil { c.Trace(":tag %v, FID %v", t, c.FID)}
+c.trace(":tag %v, FID %v", t, atomic.LoadUint64(&c.FID))
 Marshal{{.T.MFunc}}Pkt(&b, t, {{.T.MList}})
 c.FromClient <- &RPCCall{b: b.Bytes(), Reply: r}
 bb := <-r
-if MType(bb[4]) == Rerror {
-	s, _, err := UnmarshalRerrorPkt(bytes.NewBuffer(bb[5:]))
-	if err != nil {
-		return {{.R.UList}} err
-	}
-	return {{.R.UList}} fmt.Errorf("%v", s)
+if err := replyErr(bb); err != nil {
+	return {{.R.UList}} err
 } else {
 	{{.R.MList}}{{.R.MLsep}} _, err = Unmarshal{{.R.UFunc}}Pkt(bytes.NewBuffer(bb[5:]))
 }
@@ -458,58 +478,71 @@ func genMsgRPC(b io.Writer, p *pack) (*call, error) {
 
 	c := newCall(p)
 
-	if err := genEncodeStruct(p.r, "", c.R); err != nil {
-		log.Fatalf("%v", err)
-	}
-	if c.R.inBWrite {
-		c.R.MCode.WriteString("\t})\n")
-		c.R.inBWrite = false
-	}
-	if err := genDecodeStruct(p.r, "", c.R); err != nil {
-		log.Fatalf("%v", err)
+	if p.r != nil {
+		if err := genEncodeStruct(p.r, "", c.R); err != nil {
+			log.Fatalf("%v", err)
+		}
+		if c.R.inBWrite {
+			c.R.MCode.WriteString("\t})\n")
+			c.R.inBWrite = false
+		}
+		if err := genDecodeStruct(p.r, "", c.R); err != nil {
+			log.Fatalf("%v", err)
+		}
 	}
 
-	if err := genEncodeStruct(p.t, "", c.T); err != nil {
-		log.Fatalf("%v", err)
-	}
-	if c.T.inBWrite {
-		c.T.MCode.WriteString("\t})\n")
-		c.T.inBWrite = false
-	}
+	if p.t != nil {
+		if err := genEncodeStruct(p.t, "", c.T); err != nil {
+			log.Fatalf("%v", err)
+		}
+		if c.T.inBWrite {
+			c.T.MCode.WriteString("\t})\n")
+			c.T.inBWrite = false
+		}
 
-	if err := genDecodeStruct(p.t, "", c.T); err != nil {
-		log.Fatalf("%v", err)
-	}
+		if err := genDecodeStruct(p.t, "", c.T); err != nil {
+			log.Fatalf("%v", err)
+		}
 
-	if err := genParms(p.t, p.tn, c.T); err != nil {
-		log.Fatalf("%v", err)
-	}
+		if err := genParms(p.t, p.tn, c.T); err != nil {
+			log.Fatalf("%v", err)
+		}
 
-	if err := genRets(p.t, p.tn, c.T); err != nil {
-		log.Fatalf("%v", err)
+		if err := genRets(p.t, p.tn, c.T); err != nil {
+			log.Fatalf("%v", err)
+		}
 	}
 
-	if err := genParms(p.r, p.rn, c.R); err != nil {
-		log.Fatalf("%v", err)
-	}
+	if p.r != nil {
+		if err := genParms(p.r, p.rn, c.R); err != nil {
+			log.Fatalf("%v", err)
+		}
 
-	if err := genRets(p.r, p.rn, c.R); err != nil {
-		log.Fatalf("%v", err)
+		if err := genRets(p.r, p.rn, c.R); err != nil {
+			log.Fatalf("%v", err)
+		}
 	}
 
 	//log.Print("e %v d %v", c.T, c.R)
 
 	//	log.Print("------------------", c.T.MParms, "0", c.T.MList, "1", c.R.URet, "2", c.R.UList)
 	//	log.Print("------------------", c.T.MCode)
-	mfunc.Execute(b, c.R)
-	ufunc.Execute(b, c.R)
+	if p.r != nil {
+		mfunc.Execute(b, c.R)
+		ufunc.Execute(b, c.R)
+	}
 
-	if p.n == "error" {
+	if p.n == "error" && !p.codec {
 		return c, nil
 	}
 
-	mfunc.Execute(b, c.T)
-	ufunc.Execute(b, c.T)
+	if p.t != nil {
+		mfunc.Execute(b, c.T)
+		ufunc.Execute(b, c.T)
+	}
+	if p.codec {
+		return nil, nil
+	}
 	sfunc.Execute(b, c)
 	cfunc.Execute(b, c)
 	return nil, nil
@@ -549,6 +582,29 @@ func main() {
 	msfunc.Execute(b, dir)
 	usfunc.Execute(b, dir)
 
+	dir = &emitter{"dirDotu", "dirDotu", &bytes.Buffer{}, &bytes.Buffer{}, "", &bytes.Buffer{}, "dirDotu", &bytes.Buffer{}, &bytes.Buffer{}, &bytes.Buffer{}, false}
+	if err := genEncodeStruct(protocol.DirDotuPkt{}, "", dir); err != nil {
+		log.Fatalf("%v", err)
+	}
+	// Unlike Dir, this ends with integers.
+	if dir.inBWrite {
+		dir.MCode.WriteString("\t})\n")
+		dir.inBWrite = false
+	}
+	if err := genDecodeStruct(protocol.DirDotuPkt{}, "", dir); err != nil {
+		log.Fatalf("%v", err)
+	}
+	if err := genParms(protocol.DirDotuPkt{}, "dirDotu", dir); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	if err := genRets(protocol.DirDotuPkt{}, "dirDotu", dir); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	msfunc.Execute(b, dir)
+	usfunc.Execute(b, dir)
+
 	if err := ioutil.WriteFile("genout.go", b.Bytes(), 0600); err != nil {
 		log.Fatalf("%v", err)
 	}
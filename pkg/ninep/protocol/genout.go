@@ -4,6 +4,7 @@ import (
 "bytes"
 "fmt"
 _ "log"
+"sync/atomic"
 )
 func MarshalRerrorPkt (b *bytes.Buffer, t Tag, Error string) {
 var l uint64
@@ -172,19 +173,15 @@ func (s *Server) SrvRversion(b*bytes.Buffer) (err error) {
 
 func (c *Client)CallTversion (TMsize MaxSize, TVersion string) (RMsize MaxSize, RVersion string,  err error) {
 var b = bytes.Buffer{}
-if c.Trace != nil {c.Trace("%v", Tversion)}
+c.trace("%v", Tversion)
 t := Tag(0)
 r := make (chan []byte)
-if c.Trace != nil { c.Trace(":tag %v, FID %v", t, c.FID)}
+c.trace(":tag %v, FID %v", t, atomic.LoadUint64(&c.FID))
 MarshalTversionPkt(&b, t, TMsize, TVersion)
 c.FromClient <- &RPCCall{b: b.Bytes(), Reply: r}
 bb := <-r
-if MType(bb[4]) == Rerror {
-	s, _, err := UnmarshalRerrorPkt(bytes.NewBuffer(bb[5:]))
-	if err != nil {
-		return RMsize, RVersion,  err
-	}
-	return RMsize, RVersion,  fmt.Errorf("%v", s)
+if err := replyErr(bb); err != nil {
+	return RMsize, RVersion,  err
 } else {
 	RMsize, RVersion,  _, err = UnmarshalRversionPkt(bytes.NewBuffer(bb[5:]))
 }
@@ -353,19 +350,15 @@ func (s *Server) SrvRattach(b*bytes.Buffer) (err error) {
 
 func (c *Client)CallTattach (SFID FID, AFID FID, Uname string, Aname string) (QID QID,  err error) {
 var b = bytes.Buffer{}
-if c.Trace != nil {c.Trace("%v", Tattach)}
+c.trace("%v", Tattach)
 t := Tag(0)
 r := make (chan []byte)
-if c.Trace != nil { c.Trace(":tag %v, FID %v", t, c.FID)}
+c.trace(":tag %v, FID %v", t, atomic.LoadUint64(&c.FID))
 MarshalTattachPkt(&b, t, SFID, AFID, Uname, Aname)
 c.FromClient <- &RPCCall{b: b.Bytes(), Reply: r}
 bb := <-r
-if MType(bb[4]) == Rerror {
-	s, _, err := UnmarshalRerrorPkt(bytes.NewBuffer(bb[5:]))
-	if err != nil {
-		return QID,  err
-	}
-	return QID,  fmt.Errorf("%v", s)
+if err := replyErr(bb); err != nil {
+	return QID,  err
 } else {
 	QID,  _, err = UnmarshalRattachPkt(bytes.NewBuffer(bb[5:]))
 }
@@ -451,19 +444,15 @@ func (s *Server) SrvRflush(b*bytes.Buffer) (err error) {
 
 func (c *Client)CallTflush (OTag Tag) ( err error) {
 var b = bytes.Buffer{}
-if c.Trace != nil {c.Trace("%v", Tflush)}
+c.trace("%v", Tflush)
 t := Tag(0)
 r := make (chan []byte)
-if c.Trace != nil { c.Trace(":tag %v, FID %v", t, c.FID)}
+c.trace(":tag %v, FID %v", t, atomic.LoadUint64(&c.FID))
 MarshalTflushPkt(&b, t, OTag)
 c.FromClient <- &RPCCall{b: b.Bytes(), Reply: r}
 bb := <-r
-if MType(bb[4]) == Rerror {
-	s, _, err := UnmarshalRerrorPkt(bytes.NewBuffer(bb[5:]))
-	if err != nil {
-		return  err
-	}
-	return  fmt.Errorf("%v", s)
+if err := replyErr(bb); err != nil {
+	return  err
 } else {
 	 _, err = UnmarshalRflushPkt(bytes.NewBuffer(bb[5:]))
 }
@@ -645,19 +634,15 @@ func (s *Server) SrvRwalk(b*bytes.Buffer) (err error) {
 
 func (c *Client)CallTwalk (SFID FID, NewFID FID, Paths []string) (QIDs []QID,  err error) {
 var b = bytes.Buffer{}
-if c.Trace != nil {c.Trace("%v", Twalk)}
+c.trace("%v", Twalk)
 t := Tag(0)
 r := make (chan []byte)
-if c.Trace != nil { c.Trace(":tag %v, FID %v", t, c.FID)}
+c.trace(":tag %v, FID %v", t, atomic.LoadUint64(&c.FID))
 MarshalTwalkPkt(&b, t, SFID, NewFID, Paths)
 c.FromClient <- &RPCCall{b: b.Bytes(), Reply: r}
 bb := <-r
-if MType(bb[4]) == Rerror {
-	s, _, err := UnmarshalRerrorPkt(bytes.NewBuffer(bb[5:]))
-	if err != nil {
-		return QIDs,  err
-	}
-	return QIDs,  fmt.Errorf("%v", s)
+if err := replyErr(bb); err != nil {
+	return QIDs,  err
 } else {
 	QIDs,  _, err = UnmarshalRwalkPkt(bytes.NewBuffer(bb[5:]))
 }
@@ -803,19 +788,15 @@ func (s *Server) SrvRopen(b*bytes.Buffer) (err error) {
 
 func (c *Client)CallTopen (OFID FID, Omode Mode) (OQID QID, IOUnit MaxSize,  err error) {
 var b = bytes.Buffer{}
-if c.Trace != nil {c.Trace("%v", Topen)}
+c.trace("%v", Topen)
 t := Tag(0)
 r := make (chan []byte)
-if c.Trace != nil { c.Trace(":tag %v, FID %v", t, c.FID)}
+c.trace(":tag %v, FID %v", t, atomic.LoadUint64(&c.FID))
 MarshalTopenPkt(&b, t, OFID, Omode)
 c.FromClient <- &RPCCall{b: b.Bytes(), Reply: r}
 bb := <-r
-if MType(bb[4]) == Rerror {
-	s, _, err := UnmarshalRerrorPkt(bytes.NewBuffer(bb[5:]))
-	if err != nil {
-		return OQID, IOUnit,  err
-	}
-	return OQID, IOUnit,  fmt.Errorf("%v", s)
+if err := replyErr(bb); err != nil {
+	return OQID, IOUnit,  err
 } else {
 	OQID, IOUnit,  _, err = UnmarshalRopenPkt(bytes.NewBuffer(bb[5:]))
 }
@@ -988,19 +969,15 @@ func (s *Server) SrvRcreate(b*bytes.Buffer) (err error) {
 
 func (c *Client)CallTcreate (OFID FID, Name string, CreatePerm Perm, Omode Mode) (OQID QID, IOUnit MaxSize,  err error) {
 var b = bytes.Buffer{}
-if c.Trace != nil {c.Trace("%v", Tcreate)}
+c.trace("%v", Tcreate)
 t := Tag(0)
 r := make (chan []byte)
-if c.Trace != nil { c.Trace(":tag %v, FID %v", t, c.FID)}
+c.trace(":tag %v, FID %v", t, atomic.LoadUint64(&c.FID))
 MarshalTcreatePkt(&b, t, OFID, Name, CreatePerm, Omode)
 c.FromClient <- &RPCCall{b: b.Bytes(), Reply: r}
 bb := <-r
-if MType(bb[4]) == Rerror {
-	s, _, err := UnmarshalRerrorPkt(bytes.NewBuffer(bb[5:]))
-	if err != nil {
-		return OQID, IOUnit,  err
-	}
-	return OQID, IOUnit,  fmt.Errorf("%v", s)
+if err := replyErr(bb); err != nil {
+	return OQID, IOUnit,  err
 } else {
 	OQID, IOUnit,  _, err = UnmarshalRcreatePkt(bytes.NewBuffer(bb[5:]))
 }
@@ -1101,19 +1078,15 @@ func (s *Server) SrvRstat(b*bytes.Buffer) (err error) {
 
 func (c *Client)CallTstat (OFID FID) (B []byte,  err error) {
 var b = bytes.Buffer{}
-if c.Trace != nil {c.Trace("%v", Tstat)}
+c.trace("%v", Tstat)
 t := Tag(0)
 r := make (chan []byte)
-if c.Trace != nil { c.Trace(":tag %v, FID %v", t, c.FID)}
+c.trace(":tag %v, FID %v", t, atomic.LoadUint64(&c.FID))
 MarshalTstatPkt(&b, t, OFID)
 c.FromClient <- &RPCCall{b: b.Bytes(), Reply: r}
 bb := <-r
-if MType(bb[4]) == Rerror {
-	s, _, err := UnmarshalRerrorPkt(bytes.NewBuffer(bb[5:]))
-	if err != nil {
-		return B,  err
-	}
-	return B,  fmt.Errorf("%v", s)
+if err := replyErr(bb); err != nil {
+	return B,  err
 } else {
 	B,  _, err = UnmarshalRstatPkt(bytes.NewBuffer(bb[5:]))
 }
@@ -1214,19 +1187,15 @@ func (s *Server) SrvRwstat(b*bytes.Buffer) (err error) {
 
 func (c *Client)CallTwstat (OFID FID, B []byte) ( err error) {
 var b = bytes.Buffer{}
-if c.Trace != nil {c.Trace("%v", Twstat)}
+c.trace("%v", Twstat)
 t := Tag(0)
 r := make (chan []byte)
-if c.Trace != nil { c.Trace(":tag %v, FID %v", t, c.FID)}
+c.trace(":tag %v, FID %v", t, atomic.LoadUint64(&c.FID))
 MarshalTwstatPkt(&b, t, OFID, B)
 c.FromClient <- &RPCCall{b: b.Bytes(), Reply: r}
 bb := <-r
-if MType(bb[4]) == Rerror {
-	s, _, err := UnmarshalRerrorPkt(bytes.NewBuffer(bb[5:]))
-	if err != nil {
-		return  err
-	}
-	return  fmt.Errorf("%v", s)
+if err := replyErr(bb); err != nil {
+	return  err
 } else {
 	 _, err = UnmarshalRwstatPkt(bytes.NewBuffer(bb[5:]))
 }
@@ -1316,19 +1285,15 @@ func (s *Server) SrvRclunk(b*bytes.Buffer) (err error) {
 
 func (c *Client)CallTclunk (OFID FID) ( err error) {
 var b = bytes.Buffer{}
-if c.Trace != nil {c.Trace("%v", Tclunk)}
+c.trace("%v", Tclunk)
 t := Tag(0)
 r := make (chan []byte)
-if c.Trace != nil { c.Trace(":tag %v, FID %v", t, c.FID)}
+c.trace(":tag %v, FID %v", t, atomic.LoadUint64(&c.FID))
 MarshalTclunkPkt(&b, t, OFID)
 c.FromClient <- &RPCCall{b: b.Bytes(), Reply: r}
 bb := <-r
-if MType(bb[4]) == Rerror {
-	s, _, err := UnmarshalRerrorPkt(bytes.NewBuffer(bb[5:]))
-	if err != nil {
-		return  err
-	}
-	return  fmt.Errorf("%v", s)
+if err := replyErr(bb); err != nil {
+	return  err
 } else {
 	 _, err = UnmarshalRclunkPkt(bytes.NewBuffer(bb[5:]))
 }
@@ -1418,19 +1383,15 @@ func (s *Server) SrvRremove(b*bytes.Buffer) (err error) {
 
 func (c *Client)CallTremove (OFID FID) ( err error) {
 var b = bytes.Buffer{}
-if c.Trace != nil {c.Trace("%v", Tremove)}
+c.trace("%v", Tremove)
 t := Tag(0)
 r := make (chan []byte)
-if c.Trace != nil { c.Trace(":tag %v, FID %v", t, c.FID)}
+c.trace(":tag %v, FID %v", t, atomic.LoadUint64(&c.FID))
 MarshalTremovePkt(&b, t, OFID)
 c.FromClient <- &RPCCall{b: b.Bytes(), Reply: r}
 bb := <-r
-if MType(bb[4]) == Rerror {
-	s, _, err := UnmarshalRerrorPkt(bytes.NewBuffer(bb[5:]))
-	if err != nil {
-		return  err
-	}
-	return  fmt.Errorf("%v", s)
+if err := replyErr(bb); err != nil {
+	return  err
 } else {
 	 _, err = UnmarshalRremovePkt(bytes.NewBuffer(bb[5:]))
 }
@@ -1567,19 +1528,15 @@ func (s *Server) SrvRread(b*bytes.Buffer) (err error) {
 
 func (c *Client)CallTread (OFID FID, Off Offset, Len Count) (Data []uint8,  err error) {
 var b = bytes.Buffer{}
-if c.Trace != nil {c.Trace("%v", Tread)}
+c.trace("%v", Tread)
 t := Tag(0)
 r := make (chan []byte)
-if c.Trace != nil { c.Trace(":tag %v, FID %v", t, c.FID)}
+c.trace(":tag %v, FID %v", t, atomic.LoadUint64(&c.FID))
 MarshalTreadPkt(&b, t, OFID, Off, Len)
 c.FromClient <- &RPCCall{b: b.Bytes(), Reply: r}
 bb := <-r
-if MType(bb[4]) == Rerror {
-	s, _, err := UnmarshalRerrorPkt(bytes.NewBuffer(bb[5:]))
-	if err != nil {
-		return Data,  err
-	}
-	return Data,  fmt.Errorf("%v", s)
+if err := replyErr(bb); err != nil {
+	return Data,  err
 } else {
 	Data,  _, err = UnmarshalRreadPkt(bytes.NewBuffer(bb[5:]))
 }
@@ -1716,172 +1673,133 @@ func (s *Server) SrvRwrite(b*bytes.Buffer) (err error) {
 
 func (c *Client)CallTwrite (OFID FID, Off Offset, Data []uint8) (RLen Count,  err error) {
 var b = bytes.Buffer{}
-if c.Trace != nil {c.Trace("%v", Twrite)}
+c.trace("%v", Twrite)
 t := Tag(0)
 r := make (chan []byte)
-if c.Trace != nil { c.Trace(":tag %v, FID %v", t, c.FID)}
+c.trace(":tag %v, FID %v", t, atomic.LoadUint64(&c.FID))
 MarshalTwritePkt(&b, t, OFID, Off, Data)
 c.FromClient <- &RPCCall{b: b.Bytes(), Reply: r}
 bb := <-r
-if MType(bb[4]) == Rerror {
-	s, _, err := UnmarshalRerrorPkt(bytes.NewBuffer(bb[5:]))
-	if err != nil {
-		return RLen,  err
-	}
-	return RLen,  fmt.Errorf("%v", s)
+if err := replyErr(bb); err != nil {
+	return RLen,  err
 } else {
 	RLen,  _, err = UnmarshalRwritePkt(bytes.NewBuffer(bb[5:]))
 }
 return RLen,  err
 }
-func ServerError (b *bytes.Buffer, s string) {
-	var u [8]byte
-	// This can't really happen. 
-	if _, err := b.Read(u[:2]); err != nil {
-		return
-	}
-	t := Tag(uint16(u[0])|uint16(u[1])<<8)
-	MarshalRerrorPkt (b, t, s)
-}
-func Marshaldir (b *bytes.Buffer, D Dir) {
+func MarshalRerrorDotuPkt (b *bytes.Buffer, t Tag, Error string, Errno uint32) {
 var l uint64
 b.Reset()
-b.Write([]byte{0,0,})
-	b.Write([]byte{	uint8(D.Type>>0),
-	uint8(D.Type>>8),
-	uint8(D.Dev>>0),
-	uint8(D.Dev>>8),
-	uint8(D.Dev>>16),
-	uint8(D.Dev>>24),
-	uint8(D.QID.Type>>0),
-	uint8(D.QID.Version>>0),
-	uint8(D.QID.Version>>8),
-	uint8(D.QID.Version>>16),
-	uint8(D.QID.Version>>24),
-	uint8(D.QID.Path>>0),
-	uint8(D.QID.Path>>8),
-	uint8(D.QID.Path>>16),
-	uint8(D.QID.Path>>24),
-	uint8(D.QID.Path>>32),
-	uint8(D.QID.Path>>40),
-	uint8(D.QID.Path>>48),
-	uint8(D.QID.Path>>56),
-	uint8(D.Mode>>0),
-	uint8(D.Mode>>8),
-	uint8(D.Mode>>16),
-	uint8(D.Mode>>24),
-	uint8(D.Atime>>0),
-	uint8(D.Atime>>8),
-	uint8(D.Atime>>16),
-	uint8(D.Atime>>24),
-	uint8(D.Mtime>>0),
-	uint8(D.Mtime>>8),
-	uint8(D.Mtime>>16),
-	uint8(D.Mtime>>24),
-	uint8(D.Length>>0),
-	uint8(D.Length>>8),
-	uint8(D.Length>>16),
-	uint8(D.Length>>24),
-	uint8(D.Length>>32),
-	uint8(D.Length>>40),
-	uint8(D.Length>>48),
-	uint8(D.Length>>56),
-	uint8(len(D.Name)),uint8(len(D.Name)>>8),
-	})
-	b.Write([]byte(D.Name))
-	b.Write([]byte{	uint8(len(D.User)),uint8(len(D.User)>>8),
-	})
-	b.Write([]byte(D.User))
-	b.Write([]byte{	uint8(len(D.Group)),uint8(len(D.Group)>>8),
+b.Write([]byte{0,0,0,0,
+uint8(Rerror),
+byte(t), byte(t>>8),
+	uint8(len(Error)),uint8(len(Error)>>8),
 	})
-	b.Write([]byte(D.Group))
-	b.Write([]byte{	uint8(len(D.ModUser)),uint8(len(D.ModUser)>>8),
+	b.Write([]byte(Error))
+	b.Write([]byte{	uint8(Errno>>0),
+	uint8(Errno>>8),
+	uint8(Errno>>16),
+	uint8(Errno>>24),
 	})
-	b.Write([]byte(D.ModUser))
 
-l = uint64(b.Len()) - 2
-copy(b.Bytes(), []byte{uint8(l), uint8(l>>8)})
+{
+l = uint64(b.Len())
+copy(b.Bytes(), []byte{uint8(l), uint8(l>>8), uint8(l>>16), uint8(l>>24)})
+}
 return
 }
-func Unmarshaldir (b *bytes.Buffer) (D Dir,  err error) {
+func UnmarshalRerrorDotuPkt (b *bytes.Buffer) (Error string, Errno uint32,  t Tag, err error) {
 var u [8]uint8
 var l uint64
-_ = b.Next(2) // eat the length too
+if _, err = b.Read(u[:2]); err != nil {
+err = fmt.Errorf("pkt too short for Tag; need 2, have %d", b.Len())
+return
+}
+l = uint64(u[0]) | uint64(u[1])<<8
+t = Tag(l)
 	if _, err = b.Read(u[:2]); err != nil {
 		err = fmt.Errorf("pkt too short for uint16: need 2, have %d", b.Len())
 	return
 	}
-	D.Type = uint16(u[0])
-	D.Type |= uint16(u[1])<<8
-	if _, err = b.Read(u[:4]); err != nil {
-		err = fmt.Errorf("pkt too short for uint32: need 4, have %d", b.Len())
-	return
-	}
-	D.Dev = uint32(u[0])
-	D.Dev |= uint32(u[1])<<8
-	D.Dev |= uint32(u[2])<<16
-	D.Dev |= uint32(u[3])<<24
-	if _, err = b.Read(u[:1]); err != nil {
-		err = fmt.Errorf("pkt too short for uint8: need 1, have %d", b.Len())
-	return
-	}
-	D.QID.Type = uint8(u[0])
-	if _, err = b.Read(u[:4]); err != nil {
-		err = fmt.Errorf("pkt too short for uint32: need 4, have %d", b.Len())
-	return
-	}
-	D.QID.Version = uint32(u[0])
-	D.QID.Version |= uint32(u[1])<<8
-	D.QID.Version |= uint32(u[2])<<16
-	D.QID.Version |= uint32(u[3])<<24
-	if _, err = b.Read(u[:8]); err != nil {
-		err = fmt.Errorf("pkt too short for uint64: need 8, have %d", b.Len())
+	l = uint64(u[0])
+	l |= uint64(u[1])<<8
+	if b.Len() < int(l) {
+		err = fmt.Errorf("pkt too short for string: need %d, have %d", l, b.Len())
 	return
 	}
-	D.QID.Path = uint64(u[0])
-	D.QID.Path |= uint64(u[1])<<8
-	D.QID.Path |= uint64(u[2])<<16
-	D.QID.Path |= uint64(u[3])<<24
-	D.QID.Path |= uint64(u[4])<<32
-	D.QID.Path |= uint64(u[5])<<40
-	D.QID.Path |= uint64(u[6])<<48
-	D.QID.Path |= uint64(u[7])<<56
+	Error = string(b.Bytes()[:l])
+	_ = b.Next(int(l))
 	if _, err = b.Read(u[:4]); err != nil {
 		err = fmt.Errorf("pkt too short for uint32: need 4, have %d", b.Len())
 	return
 	}
-	D.Mode = uint32(u[0])
-	D.Mode |= uint32(u[1])<<8
-	D.Mode |= uint32(u[2])<<16
-	D.Mode |= uint32(u[3])<<24
+	Errno = uint32(u[0])
+	Errno |= uint32(u[1])<<8
+	Errno |= uint32(u[2])<<16
+	Errno |= uint32(u[3])<<24
+
+if b.Len() > 0 {
+err = fmt.Errorf("Packet too long: %d bytes left over after decode", b.Len())
+}
+return
+}
+func MarshalTattachDotuPkt (b *bytes.Buffer, t Tag, SFID FID, AFID FID, Uname string, Aname string, NUname uint32) {
+var l uint64
+b.Reset()
+b.Write([]byte{0,0,0,0,
+uint8(Tattach),
+byte(t), byte(t>>8),
+	uint8(SFID>>0),
+	uint8(SFID>>8),
+	uint8(SFID>>16),
+	uint8(SFID>>24),
+	uint8(AFID>>0),
+	uint8(AFID>>8),
+	uint8(AFID>>16),
+	uint8(AFID>>24),
+	uint8(len(Uname)),uint8(len(Uname)>>8),
+	})
+	b.Write([]byte(Uname))
+	b.Write([]byte{	uint8(len(Aname)),uint8(len(Aname)>>8),
+	})
+	b.Write([]byte(Aname))
+	b.Write([]byte{	uint8(NUname>>0),
+	uint8(NUname>>8),
+	uint8(NUname>>16),
+	uint8(NUname>>24),
+	})
+
+{
+l = uint64(b.Len())
+copy(b.Bytes(), []byte{uint8(l), uint8(l>>8), uint8(l>>16), uint8(l>>24)})
+}
+return
+}
+func UnmarshalTattachDotuPkt (b *bytes.Buffer) (SFID FID, AFID FID, Uname string, Aname string, NUname uint32,  t Tag, err error) {
+var u [8]uint8
+var l uint64
+if _, err = b.Read(u[:2]); err != nil {
+err = fmt.Errorf("pkt too short for Tag; need 2, have %d", b.Len())
+return
+}
+l = uint64(u[0]) | uint64(u[1])<<8
+t = Tag(l)
 	if _, err = b.Read(u[:4]); err != nil {
 		err = fmt.Errorf("pkt too short for uint32: need 4, have %d", b.Len())
 	return
 	}
-	D.Atime = uint32(u[0])
-	D.Atime |= uint32(u[1])<<8
-	D.Atime |= uint32(u[2])<<16
-	D.Atime |= uint32(u[3])<<24
+	SFID = FID(u[0])
+	SFID |= FID(u[1])<<8
+	SFID |= FID(u[2])<<16
+	SFID |= FID(u[3])<<24
 	if _, err = b.Read(u[:4]); err != nil {
 		err = fmt.Errorf("pkt too short for uint32: need 4, have %d", b.Len())
 	return
 	}
-	D.Mtime = uint32(u[0])
-	D.Mtime |= uint32(u[1])<<8
-	D.Mtime |= uint32(u[2])<<16
-	D.Mtime |= uint32(u[3])<<24
-	if _, err = b.Read(u[:8]); err != nil {
-		err = fmt.Errorf("pkt too short for uint64: need 8, have %d", b.Len())
-	return
-	}
-	D.Length = uint64(u[0])
-	D.Length |= uint64(u[1])<<8
-	D.Length |= uint64(u[2])<<16
-	D.Length |= uint64(u[3])<<24
-	D.Length |= uint64(u[4])<<32
-	D.Length |= uint64(u[5])<<40
-	D.Length |= uint64(u[6])<<48
-	D.Length |= uint64(u[7])<<56
+	AFID = FID(u[0])
+	AFID |= FID(u[1])<<8
+	AFID |= FID(u[2])<<16
+	AFID |= FID(u[3])<<24
 	if _, err = b.Read(u[:2]); err != nil {
 		err = fmt.Errorf("pkt too short for uint16: need 2, have %d", b.Len())
 	return
@@ -1892,7 +1810,7 @@ _ = b.Next(2) // eat the length too
 		err = fmt.Errorf("pkt too short for string: need %d, have %d", l, b.Len())
 	return
 	}
-	D.Name = string(b.Bytes()[:l])
+	Uname = string(b.Bytes()[:l])
 	_ = b.Next(int(l))
 	if _, err = b.Read(u[:2]); err != nil {
 		err = fmt.Errorf("pkt too short for uint16: need 2, have %d", b.Len())
@@ -1904,16 +1822,2406 @@ _ = b.Next(2) // eat the length too
 		err = fmt.Errorf("pkt too short for string: need %d, have %d", l, b.Len())
 	return
 	}
-	D.User = string(b.Bytes()[:l])
+	Aname = string(b.Bytes()[:l])
 	_ = b.Next(int(l))
-	if _, err = b.Read(u[:2]); err != nil {
-		err = fmt.Errorf("pkt too short for uint16: need 2, have %d", b.Len())
+	if _, err = b.Read(u[:4]); err != nil {
+		err = fmt.Errorf("pkt too short for uint32: need 4, have %d", b.Len())
 	return
 	}
-	l = uint64(u[0])
-	l |= uint64(u[1])<<8
-	if b.Len() < int(l) {
-		err = fmt.Errorf("pkt too short for string: need %d, have %d", l, b.Len())
+	NUname = uint32(u[0])
+	NUname |= uint32(u[1])<<8
+	NUname |= uint32(u[2])<<16
+	NUname |= uint32(u[3])<<24
+
+if b.Len() > 0 {
+err = fmt.Errorf("Packet too long: %d bytes left over after decode", b.Len())
+}
+return
+}
+func MarshalTcreateDotuPkt (b *bytes.Buffer, t Tag, OFID FID, Name string, CreatePerm Perm, Omode Mode, Extension string) {
+var l uint64
+b.Reset()
+b.Write([]byte{0,0,0,0,
+uint8(Tcreate),
+byte(t), byte(t>>8),
+	uint8(OFID>>0),
+	uint8(OFID>>8),
+	uint8(OFID>>16),
+	uint8(OFID>>24),
+	uint8(len(Name)),uint8(len(Name)>>8),
+	})
+	b.Write([]byte(Name))
+	b.Write([]byte{	uint8(CreatePerm>>0),
+	uint8(CreatePerm>>8),
+	uint8(CreatePerm>>16),
+	uint8(CreatePerm>>24),
+	uint8(Omode>>0),
+	uint8(len(Extension)),uint8(len(Extension)>>8),
+	})
+	b.Write([]byte(Extension))
+
+{
+l = uint64(b.Len())
+copy(b.Bytes(), []byte{uint8(l), uint8(l>>8), uint8(l>>16), uint8(l>>24)})
+}
+return
+}
+func UnmarshalTcreateDotuPkt (b *bytes.Buffer) (OFID FID, Name string, CreatePerm Perm, Omode Mode, Extension string,  t Tag, err error) {
+var u [8]uint8
+var l uint64
+if _, err = b.Read(u[:2]); err != nil {
+err = fmt.Errorf("pkt too short for Tag; need 2, have %d", b.Len())
+return
+}
+l = uint64(u[0]) | uint64(u[1])<<8
+t = Tag(l)
+	if _, err = b.Read(u[:4]); err != nil {
+		err = fmt.Errorf("pkt too short for uint32: need 4, have %d", b.Len())
+	return
+	}
+	OFID = FID(u[0])
+	OFID |= FID(u[1])<<8
+	OFID |= FID(u[2])<<16
+	OFID |= FID(u[3])<<24
+	if _, err = b.Read(u[:2]); err != nil {
+		err = fmt.Errorf("pkt too short for uint16: need 2, have %d", b.Len())
+	return
+	}
+	l = uint64(u[0])
+	l |= uint64(u[1])<<8
+	if b.Len() < int(l) {
+		err = fmt.Errorf("pkt too short for string: need %d, have %d", l, b.Len())
+	return
+	}
+	Name = string(b.Bytes()[:l])
+	_ = b.Next(int(l))
+	if _, err = b.Read(u[:4]); err != nil {
+		err = fmt.Errorf("pkt too short for uint32: need 4, have %d", b.Len())
+	return
+	}
+	CreatePerm = Perm(u[0])
+	CreatePerm |= Perm(u[1])<<8
+	CreatePerm |= Perm(u[2])<<16
+	CreatePerm |= Perm(u[3])<<24
+	if _, err = b.Read(u[:1]); err != nil {
+		err = fmt.Errorf("pkt too short for uint8: need 1, have %d", b.Len())
+	return
+	}
+	Omode = Mode(u[0])
+	if _, err = b.Read(u[:2]); err != nil {
+		err = fmt.Errorf("pkt too short for uint16: need 2, have %d", b.Len())
+	return
+	}
+	l = uint64(u[0])
+	l |= uint64(u[1])<<8
+	if b.Len() < int(l) {
+		err = fmt.Errorf("pkt too short for string: need %d, have %d", l, b.Len())
+	return
+	}
+	Extension = string(b.Bytes()[:l])
+	_ = b.Next(int(l))
+
+if b.Len() > 0 {
+err = fmt.Errorf("Packet too long: %d bytes left over after decode", b.Len())
+}
+return
+}
+func MarshalRlerrorPkt (b *bytes.Buffer, t Tag, Ecode uint32) {
+var l uint64
+b.Reset()
+b.Write([]byte{0,0,0,0,
+uint8(Rlerror),
+byte(t), byte(t>>8),
+	uint8(Ecode>>0),
+	uint8(Ecode>>8),
+	uint8(Ecode>>16),
+	uint8(Ecode>>24),
+	})
+
+{
+l = uint64(b.Len())
+copy(b.Bytes(), []byte{uint8(l), uint8(l>>8), uint8(l>>16), uint8(l>>24)})
+}
+return
+}
+func UnmarshalRlerrorPkt (b *bytes.Buffer) (Ecode uint32,  t Tag, err error) {
+var u [8]uint8
+var l uint64
+if _, err = b.Read(u[:2]); err != nil {
+err = fmt.Errorf("pkt too short for Tag; need 2, have %d", b.Len())
+return
+}
+l = uint64(u[0]) | uint64(u[1])<<8
+t = Tag(l)
+	if _, err = b.Read(u[:4]); err != nil {
+		err = fmt.Errorf("pkt too short for uint32: need 4, have %d", b.Len())
+	return
+	}
+	Ecode = uint32(u[0])
+	Ecode |= uint32(u[1])<<8
+	Ecode |= uint32(u[2])<<16
+	Ecode |= uint32(u[3])<<24
+
+if b.Len() > 0 {
+err = fmt.Errorf("Packet too long: %d bytes left over after decode", b.Len())
+}
+return
+}
+func MarshalRstatfsPkt (b *bytes.Buffer, t Tag, FSType uint32, BSize uint32, Blocks uint64, BFree uint64, BAvail uint64, Files uint64, FFree uint64, FSID uint64, NameLen uint32) {
+var l uint64
+b.Reset()
+b.Write([]byte{0,0,0,0,
+uint8(Rstatfs),
+byte(t), byte(t>>8),
+	uint8(FSType>>0),
+	uint8(FSType>>8),
+	uint8(FSType>>16),
+	uint8(FSType>>24),
+	uint8(BSize>>0),
+	uint8(BSize>>8),
+	uint8(BSize>>16),
+	uint8(BSize>>24),
+	uint8(Blocks>>0),
+	uint8(Blocks>>8),
+	uint8(Blocks>>16),
+	uint8(Blocks>>24),
+	uint8(Blocks>>32),
+	uint8(Blocks>>40),
+	uint8(Blocks>>48),
+	uint8(Blocks>>56),
+	uint8(BFree>>0),
+	uint8(BFree>>8),
+	uint8(BFree>>16),
+	uint8(BFree>>24),
+	uint8(BFree>>32),
+	uint8(BFree>>40),
+	uint8(BFree>>48),
+	uint8(BFree>>56),
+	uint8(BAvail>>0),
+	uint8(BAvail>>8),
+	uint8(BAvail>>16),
+	uint8(BAvail>>24),
+	uint8(BAvail>>32),
+	uint8(BAvail>>40),
+	uint8(BAvail>>48),
+	uint8(BAvail>>56),
+	uint8(Files>>0),
+	uint8(Files>>8),
+	uint8(Files>>16),
+	uint8(Files>>24),
+	uint8(Files>>32),
+	uint8(Files>>40),
+	uint8(Files>>48),
+	uint8(Files>>56),
+	uint8(FFree>>0),
+	uint8(FFree>>8),
+	uint8(FFree>>16),
+	uint8(FFree>>24),
+	uint8(FFree>>32),
+	uint8(FFree>>40),
+	uint8(FFree>>48),
+	uint8(FFree>>56),
+	uint8(FSID>>0),
+	uint8(FSID>>8),
+	uint8(FSID>>16),
+	uint8(FSID>>24),
+	uint8(FSID>>32),
+	uint8(FSID>>40),
+	uint8(FSID>>48),
+	uint8(FSID>>56),
+	uint8(NameLen>>0),
+	uint8(NameLen>>8),
+	uint8(NameLen>>16),
+	uint8(NameLen>>24),
+	})
+
+{
+l = uint64(b.Len())
+copy(b.Bytes(), []byte{uint8(l), uint8(l>>8), uint8(l>>16), uint8(l>>24)})
+}
+return
+}
+func UnmarshalRstatfsPkt (b *bytes.Buffer) (FSType uint32, BSize uint32, Blocks uint64, BFree uint64, BAvail uint64, Files uint64, FFree uint64, FSID uint64, NameLen uint32,  t Tag, err error) {
+var u [8]uint8
+var l uint64
+if _, err = b.Read(u[:2]); err != nil {
+err = fmt.Errorf("pkt too short for Tag; need 2, have %d", b.Len())
+return
+}
+l = uint64(u[0]) | uint64(u[1])<<8
+t = Tag(l)
+	if _, err = b.Read(u[:4]); err != nil {
+		err = fmt.Errorf("pkt too short for uint32: need 4, have %d", b.Len())
+	return
+	}
+	FSType = uint32(u[0])
+	FSType |= uint32(u[1])<<8
+	FSType |= uint32(u[2])<<16
+	FSType |= uint32(u[3])<<24
+	if _, err = b.Read(u[:4]); err != nil {
+		err = fmt.Errorf("pkt too short for uint32: need 4, have %d", b.Len())
+	return
+	}
+	BSize = uint32(u[0])
+	BSize |= uint32(u[1])<<8
+	BSize |= uint32(u[2])<<16
+	BSize |= uint32(u[3])<<24
+	if _, err = b.Read(u[:8]); err != nil {
+		err = fmt.Errorf("pkt too short for uint64: need 8, have %d", b.Len())
+	return
+	}
+	Blocks = uint64(u[0])
+	Blocks |= uint64(u[1])<<8
+	Blocks |= uint64(u[2])<<16
+	Blocks |= uint64(u[3])<<24
+	Blocks |= uint64(u[4])<<32
+	Blocks |= uint64(u[5])<<40
+	Blocks |= uint64(u[6])<<48
+	Blocks |= uint64(u[7])<<56
+	if _, err = b.Read(u[:8]); err != nil {
+		err = fmt.Errorf("pkt too short for uint64: need 8, have %d", b.Len())
+	return
+	}
+	BFree = uint64(u[0])
+	BFree |= uint64(u[1])<<8
+	BFree |= uint64(u[2])<<16
+	BFree |= uint64(u[3])<<24
+	BFree |= uint64(u[4])<<32
+	BFree |= uint64(u[5])<<40
+	BFree |= uint64(u[6])<<48
+	BFree |= uint64(u[7])<<56
+	if _, err = b.Read(u[:8]); err != nil {
+		err = fmt.Errorf("pkt too short for uint64: need 8, have %d", b.Len())
+	return
+	}
+	BAvail = uint64(u[0])
+	BAvail |= uint64(u[1])<<8
+	BAvail |= uint64(u[2])<<16
+	BAvail |= uint64(u[3])<<24
+	BAvail |= uint64(u[4])<<32
+	BAvail |= uint64(u[5])<<40
+	BAvail |= uint64(u[6])<<48
+	BAvail |= uint64(u[7])<<56
+	if _, err = b.Read(u[:8]); err != nil {
+		err = fmt.Errorf("pkt too short for uint64: need 8, have %d", b.Len())
+	return
+	}
+	Files = uint64(u[0])
+	Files |= uint64(u[1])<<8
+	Files |= uint64(u[2])<<16
+	Files |= uint64(u[3])<<24
+	Files |= uint64(u[4])<<32
+	Files |= uint64(u[5])<<40
+	Files |= uint64(u[6])<<48
+	Files |= uint64(u[7])<<56
+	if _, err = b.Read(u[:8]); err != nil {
+		err = fmt.Errorf("pkt too short for uint64: need 8, have %d", b.Len())
+	return
+	}
+	FFree = uint64(u[0])
+	FFree |= uint64(u[1])<<8
+	FFree |= uint64(u[2])<<16
+	FFree |= uint64(u[3])<<24
+	FFree |= uint64(u[4])<<32
+	FFree |= uint64(u[5])<<40
+	FFree |= uint64(u[6])<<48
+	FFree |= uint64(u[7])<<56
+	if _, err = b.Read(u[:8]); err != nil {
+		err = fmt.Errorf("pkt too short for uint64: need 8, have %d", b.Len())
+	return
+	}
+	FSID = uint64(u[0])
+	FSID |= uint64(u[1])<<8
+	FSID |= uint64(u[2])<<16
+	FSID |= uint64(u[3])<<24
+	FSID |= uint64(u[4])<<32
+	FSID |= uint64(u[5])<<40
+	FSID |= uint64(u[6])<<48
+	FSID |= uint64(u[7])<<56
+	if _, err = b.Read(u[:4]); err != nil {
+		err = fmt.Errorf("pkt too short for uint32: need 4, have %d", b.Len())
+	return
+	}
+	NameLen = uint32(u[0])
+	NameLen |= uint32(u[1])<<8
+	NameLen |= uint32(u[2])<<16
+	NameLen |= uint32(u[3])<<24
+
+if b.Len() > 0 {
+err = fmt.Errorf("Packet too long: %d bytes left over after decode", b.Len())
+}
+return
+}
+func MarshalTstatfsPkt (b *bytes.Buffer, t Tag, OFID FID) {
+var l uint64
+b.Reset()
+b.Write([]byte{0,0,0,0,
+uint8(Tstatfs),
+byte(t), byte(t>>8),
+	uint8(OFID>>0),
+	uint8(OFID>>8),
+	uint8(OFID>>16),
+	uint8(OFID>>24),
+	})
+
+{
+l = uint64(b.Len())
+copy(b.Bytes(), []byte{uint8(l), uint8(l>>8), uint8(l>>16), uint8(l>>24)})
+}
+return
+}
+func UnmarshalTstatfsPkt (b *bytes.Buffer) (OFID FID,  t Tag, err error) {
+var u [8]uint8
+var l uint64
+if _, err = b.Read(u[:2]); err != nil {
+err = fmt.Errorf("pkt too short for Tag; need 2, have %d", b.Len())
+return
+}
+l = uint64(u[0]) | uint64(u[1])<<8
+t = Tag(l)
+	if _, err = b.Read(u[:4]); err != nil {
+		err = fmt.Errorf("pkt too short for uint32: need 4, have %d", b.Len())
+	return
+	}
+	OFID = FID(u[0])
+	OFID |= FID(u[1])<<8
+	OFID |= FID(u[2])<<16
+	OFID |= FID(u[3])<<24
+
+if b.Len() > 0 {
+err = fmt.Errorf("Packet too long: %d bytes left over after decode", b.Len())
+}
+return
+}
+func MarshalRlopenPkt (b *bytes.Buffer, t Tag, OQID QID, IOUnit MaxSize) {
+var l uint64
+b.Reset()
+b.Write([]byte{0,0,0,0,
+uint8(Rlopen),
+byte(t), byte(t>>8),
+	uint8(OQID.Type>>0),
+	uint8(OQID.Version>>0),
+	uint8(OQID.Version>>8),
+	uint8(OQID.Version>>16),
+	uint8(OQID.Version>>24),
+	uint8(OQID.Path>>0),
+	uint8(OQID.Path>>8),
+	uint8(OQID.Path>>16),
+	uint8(OQID.Path>>24),
+	uint8(OQID.Path>>32),
+	uint8(OQID.Path>>40),
+	uint8(OQID.Path>>48),
+	uint8(OQID.Path>>56),
+	uint8(IOUnit>>0),
+	uint8(IOUnit>>8),
+	uint8(IOUnit>>16),
+	uint8(IOUnit>>24),
+	})
+
+{
+l = uint64(b.Len())
+copy(b.Bytes(), []byte{uint8(l), uint8(l>>8), uint8(l>>16), uint8(l>>24)})
+}
+return
+}
+func UnmarshalRlopenPkt (b *bytes.Buffer) (OQID QID, IOUnit MaxSize,  t Tag, err error) {
+var u [8]uint8
+var l uint64
+if _, err = b.Read(u[:2]); err != nil {
+err = fmt.Errorf("pkt too short for Tag; need 2, have %d", b.Len())
+return
+}
+l = uint64(u[0]) | uint64(u[1])<<8
+t = Tag(l)
+	if _, err = b.Read(u[:1]); err != nil {
+		err = fmt.Errorf("pkt too short for uint8: need 1, have %d", b.Len())
+	return
+	}
+	OQID.Type = uint8(u[0])
+	if _, err = b.Read(u[:4]); err != nil {
+		err = fmt.Errorf("pkt too short for uint32: need 4, have %d", b.Len())
+	return
+	}
+	OQID.Version = uint32(u[0])
+	OQID.Version |= uint32(u[1])<<8
+	OQID.Version |= uint32(u[2])<<16
+	OQID.Version |= uint32(u[3])<<24
+	if _, err = b.Read(u[:8]); err != nil {
+		err = fmt.Errorf("pkt too short for uint64: need 8, have %d", b.Len())
+	return
+	}
+	OQID.Path = uint64(u[0])
+	OQID.Path |= uint64(u[1])<<8
+	OQID.Path |= uint64(u[2])<<16
+	OQID.Path |= uint64(u[3])<<24
+	OQID.Path |= uint64(u[4])<<32
+	OQID.Path |= uint64(u[5])<<40
+	OQID.Path |= uint64(u[6])<<48
+	OQID.Path |= uint64(u[7])<<56
+	if _, err = b.Read(u[:4]); err != nil {
+		err = fmt.Errorf("pkt too short for uint32: need 4, have %d", b.Len())
+	return
+	}
+	IOUnit = MaxSize(u[0])
+	IOUnit |= MaxSize(u[1])<<8
+	IOUnit |= MaxSize(u[2])<<16
+	IOUnit |= MaxSize(u[3])<<24
+
+if b.Len() > 0 {
+err = fmt.Errorf("Packet too long: %d bytes left over after decode", b.Len())
+}
+return
+}
+func MarshalTlopenPkt (b *bytes.Buffer, t Tag, OFID FID, LFlags uint32) {
+var l uint64
+b.Reset()
+b.Write([]byte{0,0,0,0,
+uint8(Tlopen),
+byte(t), byte(t>>8),
+	uint8(OFID>>0),
+	uint8(OFID>>8),
+	uint8(OFID>>16),
+	uint8(OFID>>24),
+	uint8(LFlags>>0),
+	uint8(LFlags>>8),
+	uint8(LFlags>>16),
+	uint8(LFlags>>24),
+	})
+
+{
+l = uint64(b.Len())
+copy(b.Bytes(), []byte{uint8(l), uint8(l>>8), uint8(l>>16), uint8(l>>24)})
+}
+return
+}
+func UnmarshalTlopenPkt (b *bytes.Buffer) (OFID FID, LFlags uint32,  t Tag, err error) {
+var u [8]uint8
+var l uint64
+if _, err = b.Read(u[:2]); err != nil {
+err = fmt.Errorf("pkt too short for Tag; need 2, have %d", b.Len())
+return
+}
+l = uint64(u[0]) | uint64(u[1])<<8
+t = Tag(l)
+	if _, err = b.Read(u[:4]); err != nil {
+		err = fmt.Errorf("pkt too short for uint32: need 4, have %d", b.Len())
+	return
+	}
+	OFID = FID(u[0])
+	OFID |= FID(u[1])<<8
+	OFID |= FID(u[2])<<16
+	OFID |= FID(u[3])<<24
+	if _, err = b.Read(u[:4]); err != nil {
+		err = fmt.Errorf("pkt too short for uint32: need 4, have %d", b.Len())
+	return
+	}
+	LFlags = uint32(u[0])
+	LFlags |= uint32(u[1])<<8
+	LFlags |= uint32(u[2])<<16
+	LFlags |= uint32(u[3])<<24
+
+if b.Len() > 0 {
+err = fmt.Errorf("Packet too long: %d bytes left over after decode", b.Len())
+}
+return
+}
+func MarshalRlcreatePkt (b *bytes.Buffer, t Tag, OQID QID, IOUnit MaxSize) {
+var l uint64
+b.Reset()
+b.Write([]byte{0,0,0,0,
+uint8(Rlcreate),
+byte(t), byte(t>>8),
+	uint8(OQID.Type>>0),
+	uint8(OQID.Version>>0),
+	uint8(OQID.Version>>8),
+	uint8(OQID.Version>>16),
+	uint8(OQID.Version>>24),
+	uint8(OQID.Path>>0),
+	uint8(OQID.Path>>8),
+	uint8(OQID.Path>>16),
+	uint8(OQID.Path>>24),
+	uint8(OQID.Path>>32),
+	uint8(OQID.Path>>40),
+	uint8(OQID.Path>>48),
+	uint8(OQID.Path>>56),
+	uint8(IOUnit>>0),
+	uint8(IOUnit>>8),
+	uint8(IOUnit>>16),
+	uint8(IOUnit>>24),
+	})
+
+{
+l = uint64(b.Len())
+copy(b.Bytes(), []byte{uint8(l), uint8(l>>8), uint8(l>>16), uint8(l>>24)})
+}
+return
+}
+func UnmarshalRlcreatePkt (b *bytes.Buffer) (OQID QID, IOUnit MaxSize,  t Tag, err error) {
+var u [8]uint8
+var l uint64
+if _, err = b.Read(u[:2]); err != nil {
+err = fmt.Errorf("pkt too short for Tag; need 2, have %d", b.Len())
+return
+}
+l = uint64(u[0]) | uint64(u[1])<<8
+t = Tag(l)
+	if _, err = b.Read(u[:1]); err != nil {
+		err = fmt.Errorf("pkt too short for uint8: need 1, have %d", b.Len())
+	return
+	}
+	OQID.Type = uint8(u[0])
+	if _, err = b.Read(u[:4]); err != nil {
+		err = fmt.Errorf("pkt too short for uint32: need 4, have %d", b.Len())
+	return
+	}
+	OQID.Version = uint32(u[0])
+	OQID.Version |= uint32(u[1])<<8
+	OQID.Version |= uint32(u[2])<<16
+	OQID.Version |= uint32(u[3])<<24
+	if _, err = b.Read(u[:8]); err != nil {
+		err = fmt.Errorf("pkt too short for uint64: need 8, have %d", b.Len())
+	return
+	}
+	OQID.Path = uint64(u[0])
+	OQID.Path |= uint64(u[1])<<8
+	OQID.Path |= uint64(u[2])<<16
+	OQID.Path |= uint64(u[3])<<24
+	OQID.Path |= uint64(u[4])<<32
+	OQID.Path |= uint64(u[5])<<40
+	OQID.Path |= uint64(u[6])<<48
+	OQID.Path |= uint64(u[7])<<56
+	if _, err = b.Read(u[:4]); err != nil {
+		err = fmt.Errorf("pkt too short for uint32: need 4, have %d", b.Len())
+	return
+	}
+	IOUnit = MaxSize(u[0])
+	IOUnit |= MaxSize(u[1])<<8
+	IOUnit |= MaxSize(u[2])<<16
+	IOUnit |= MaxSize(u[3])<<24
+
+if b.Len() > 0 {
+err = fmt.Errorf("Packet too long: %d bytes left over after decode", b.Len())
+}
+return
+}
+func MarshalTlcreatePkt (b *bytes.Buffer, t Tag, OFID FID, Name string, LFlags uint32, LMode uint32, Gid uint32) {
+var l uint64
+b.Reset()
+b.Write([]byte{0,0,0,0,
+uint8(Tlcreate),
+byte(t), byte(t>>8),
+	uint8(OFID>>0),
+	uint8(OFID>>8),
+	uint8(OFID>>16),
+	uint8(OFID>>24),
+	uint8(len(Name)),uint8(len(Name)>>8),
+	})
+	b.Write([]byte(Name))
+	b.Write([]byte{	uint8(LFlags>>0),
+	uint8(LFlags>>8),
+	uint8(LFlags>>16),
+	uint8(LFlags>>24),
+	uint8(LMode>>0),
+	uint8(LMode>>8),
+	uint8(LMode>>16),
+	uint8(LMode>>24),
+	uint8(Gid>>0),
+	uint8(Gid>>8),
+	uint8(Gid>>16),
+	uint8(Gid>>24),
+	})
+
+{
+l = uint64(b.Len())
+copy(b.Bytes(), []byte{uint8(l), uint8(l>>8), uint8(l>>16), uint8(l>>24)})
+}
+return
+}
+func UnmarshalTlcreatePkt (b *bytes.Buffer) (OFID FID, Name string, LFlags uint32, LMode uint32, Gid uint32,  t Tag, err error) {
+var u [8]uint8
+var l uint64
+if _, err = b.Read(u[:2]); err != nil {
+err = fmt.Errorf("pkt too short for Tag; need 2, have %d", b.Len())
+return
+}
+l = uint64(u[0]) | uint64(u[1])<<8
+t = Tag(l)
+	if _, err = b.Read(u[:4]); err != nil {
+		err = fmt.Errorf("pkt too short for uint32: need 4, have %d", b.Len())
+	return
+	}
+	OFID = FID(u[0])
+	OFID |= FID(u[1])<<8
+	OFID |= FID(u[2])<<16
+	OFID |= FID(u[3])<<24
+	if _, err = b.Read(u[:2]); err != nil {
+		err = fmt.Errorf("pkt too short for uint16: need 2, have %d", b.Len())
+	return
+	}
+	l = uint64(u[0])
+	l |= uint64(u[1])<<8
+	if b.Len() < int(l) {
+		err = fmt.Errorf("pkt too short for string: need %d, have %d", l, b.Len())
+	return
+	}
+	Name = string(b.Bytes()[:l])
+	_ = b.Next(int(l))
+	if _, err = b.Read(u[:4]); err != nil {
+		err = fmt.Errorf("pkt too short for uint32: need 4, have %d", b.Len())
+	return
+	}
+	LFlags = uint32(u[0])
+	LFlags |= uint32(u[1])<<8
+	LFlags |= uint32(u[2])<<16
+	LFlags |= uint32(u[3])<<24
+	if _, err = b.Read(u[:4]); err != nil {
+		err = fmt.Errorf("pkt too short for uint32: need 4, have %d", b.Len())
+	return
+	}
+	LMode = uint32(u[0])
+	LMode |= uint32(u[1])<<8
+	LMode |= uint32(u[2])<<16
+	LMode |= uint32(u[3])<<24
+	if _, err = b.Read(u[:4]); err != nil {
+		err = fmt.Errorf("pkt too short for uint32: need 4, have %d", b.Len())
+	return
+	}
+	Gid = uint32(u[0])
+	Gid |= uint32(u[1])<<8
+	Gid |= uint32(u[2])<<16
+	Gid |= uint32(u[3])<<24
+
+if b.Len() > 0 {
+err = fmt.Errorf("Packet too long: %d bytes left over after decode", b.Len())
+}
+return
+}
+func MarshalRgetattrPkt (b *bytes.Buffer, t Tag, Valid uint64, GQID QID, GMode uint32, UID uint32, GID uint32, NLink uint64, RDev uint64, Size uint64, BlkSize uint64, Blocks uint64, ATimeSec uint64, ATimeNsec uint64, MTimeSec uint64, MTimeNsec uint64, CTimeSec uint64, CTimeNsec uint64, BTimeSec uint64, BTimeNsec uint64, Gen uint64, DataVersion uint64) {
+var l uint64
+b.Reset()
+b.Write([]byte{0,0,0,0,
+uint8(Rgetattr),
+byte(t), byte(t>>8),
+	uint8(Valid>>0),
+	uint8(Valid>>8),
+	uint8(Valid>>16),
+	uint8(Valid>>24),
+	uint8(Valid>>32),
+	uint8(Valid>>40),
+	uint8(Valid>>48),
+	uint8(Valid>>56),
+	uint8(GQID.Type>>0),
+	uint8(GQID.Version>>0),
+	uint8(GQID.Version>>8),
+	uint8(GQID.Version>>16),
+	uint8(GQID.Version>>24),
+	uint8(GQID.Path>>0),
+	uint8(GQID.Path>>8),
+	uint8(GQID.Path>>16),
+	uint8(GQID.Path>>24),
+	uint8(GQID.Path>>32),
+	uint8(GQID.Path>>40),
+	uint8(GQID.Path>>48),
+	uint8(GQID.Path>>56),
+	uint8(GMode>>0),
+	uint8(GMode>>8),
+	uint8(GMode>>16),
+	uint8(GMode>>24),
+	uint8(UID>>0),
+	uint8(UID>>8),
+	uint8(UID>>16),
+	uint8(UID>>24),
+	uint8(GID>>0),
+	uint8(GID>>8),
+	uint8(GID>>16),
+	uint8(GID>>24),
+	uint8(NLink>>0),
+	uint8(NLink>>8),
+	uint8(NLink>>16),
+	uint8(NLink>>24),
+	uint8(NLink>>32),
+	uint8(NLink>>40),
+	uint8(NLink>>48),
+	uint8(NLink>>56),
+	uint8(RDev>>0),
+	uint8(RDev>>8),
+	uint8(RDev>>16),
+	uint8(RDev>>24),
+	uint8(RDev>>32),
+	uint8(RDev>>40),
+	uint8(RDev>>48),
+	uint8(RDev>>56),
+	uint8(Size>>0),
+	uint8(Size>>8),
+	uint8(Size>>16),
+	uint8(Size>>24),
+	uint8(Size>>32),
+	uint8(Size>>40),
+	uint8(Size>>48),
+	uint8(Size>>56),
+	uint8(BlkSize>>0),
+	uint8(BlkSize>>8),
+	uint8(BlkSize>>16),
+	uint8(BlkSize>>24),
+	uint8(BlkSize>>32),
+	uint8(BlkSize>>40),
+	uint8(BlkSize>>48),
+	uint8(BlkSize>>56),
+	uint8(Blocks>>0),
+	uint8(Blocks>>8),
+	uint8(Blocks>>16),
+	uint8(Blocks>>24),
+	uint8(Blocks>>32),
+	uint8(Blocks>>40),
+	uint8(Blocks>>48),
+	uint8(Blocks>>56),
+	uint8(ATimeSec>>0),
+	uint8(ATimeSec>>8),
+	uint8(ATimeSec>>16),
+	uint8(ATimeSec>>24),
+	uint8(ATimeSec>>32),
+	uint8(ATimeSec>>40),
+	uint8(ATimeSec>>48),
+	uint8(ATimeSec>>56),
+	uint8(ATimeNsec>>0),
+	uint8(ATimeNsec>>8),
+	uint8(ATimeNsec>>16),
+	uint8(ATimeNsec>>24),
+	uint8(ATimeNsec>>32),
+	uint8(ATimeNsec>>40),
+	uint8(ATimeNsec>>48),
+	uint8(ATimeNsec>>56),
+	uint8(MTimeSec>>0),
+	uint8(MTimeSec>>8),
+	uint8(MTimeSec>>16),
+	uint8(MTimeSec>>24),
+	uint8(MTimeSec>>32),
+	uint8(MTimeSec>>40),
+	uint8(MTimeSec>>48),
+	uint8(MTimeSec>>56),
+	uint8(MTimeNsec>>0),
+	uint8(MTimeNsec>>8),
+	uint8(MTimeNsec>>16),
+	uint8(MTimeNsec>>24),
+	uint8(MTimeNsec>>32),
+	uint8(MTimeNsec>>40),
+	uint8(MTimeNsec>>48),
+	uint8(MTimeNsec>>56),
+	uint8(CTimeSec>>0),
+	uint8(CTimeSec>>8),
+	uint8(CTimeSec>>16),
+	uint8(CTimeSec>>24),
+	uint8(CTimeSec>>32),
+	uint8(CTimeSec>>40),
+	uint8(CTimeSec>>48),
+	uint8(CTimeSec>>56),
+	uint8(CTimeNsec>>0),
+	uint8(CTimeNsec>>8),
+	uint8(CTimeNsec>>16),
+	uint8(CTimeNsec>>24),
+	uint8(CTimeNsec>>32),
+	uint8(CTimeNsec>>40),
+	uint8(CTimeNsec>>48),
+	uint8(CTimeNsec>>56),
+	uint8(BTimeSec>>0),
+	uint8(BTimeSec>>8),
+	uint8(BTimeSec>>16),
+	uint8(BTimeSec>>24),
+	uint8(BTimeSec>>32),
+	uint8(BTimeSec>>40),
+	uint8(BTimeSec>>48),
+	uint8(BTimeSec>>56),
+	uint8(BTimeNsec>>0),
+	uint8(BTimeNsec>>8),
+	uint8(BTimeNsec>>16),
+	uint8(BTimeNsec>>24),
+	uint8(BTimeNsec>>32),
+	uint8(BTimeNsec>>40),
+	uint8(BTimeNsec>>48),
+	uint8(BTimeNsec>>56),
+	uint8(Gen>>0),
+	uint8(Gen>>8),
+	uint8(Gen>>16),
+	uint8(Gen>>24),
+	uint8(Gen>>32),
+	uint8(Gen>>40),
+	uint8(Gen>>48),
+	uint8(Gen>>56),
+	uint8(DataVersion>>0),
+	uint8(DataVersion>>8),
+	uint8(DataVersion>>16),
+	uint8(DataVersion>>24),
+	uint8(DataVersion>>32),
+	uint8(DataVersion>>40),
+	uint8(DataVersion>>48),
+	uint8(DataVersion>>56),
+	})
+
+{
+l = uint64(b.Len())
+copy(b.Bytes(), []byte{uint8(l), uint8(l>>8), uint8(l>>16), uint8(l>>24)})
+}
+return
+}
+func UnmarshalRgetattrPkt (b *bytes.Buffer) (Valid uint64, GQID QID, GMode uint32, UID uint32, GID uint32, NLink uint64, RDev uint64, Size uint64, BlkSize uint64, Blocks uint64, ATimeSec uint64, ATimeNsec uint64, MTimeSec uint64, MTimeNsec uint64, CTimeSec uint64, CTimeNsec uint64, BTimeSec uint64, BTimeNsec uint64, Gen uint64, DataVersion uint64,  t Tag, err error) {
+var u [8]uint8
+var l uint64
+if _, err = b.Read(u[:2]); err != nil {
+err = fmt.Errorf("pkt too short for Tag; need 2, have %d", b.Len())
+return
+}
+l = uint64(u[0]) | uint64(u[1])<<8
+t = Tag(l)
+	if _, err = b.Read(u[:8]); err != nil {
+		err = fmt.Errorf("pkt too short for uint64: need 8, have %d", b.Len())
+	return
+	}
+	Valid = uint64(u[0])
+	Valid |= uint64(u[1])<<8
+	Valid |= uint64(u[2])<<16
+	Valid |= uint64(u[3])<<24
+	Valid |= uint64(u[4])<<32
+	Valid |= uint64(u[5])<<40
+	Valid |= uint64(u[6])<<48
+	Valid |= uint64(u[7])<<56
+	if _, err = b.Read(u[:1]); err != nil {
+		err = fmt.Errorf("pkt too short for uint8: need 1, have %d", b.Len())
+	return
+	}
+	GQID.Type = uint8(u[0])
+	if _, err = b.Read(u[:4]); err != nil {
+		err = fmt.Errorf("pkt too short for uint32: need 4, have %d", b.Len())
+	return
+	}
+	GQID.Version = uint32(u[0])
+	GQID.Version |= uint32(u[1])<<8
+	GQID.Version |= uint32(u[2])<<16
+	GQID.Version |= uint32(u[3])<<24
+	if _, err = b.Read(u[:8]); err != nil {
+		err = fmt.Errorf("pkt too short for uint64: need 8, have %d", b.Len())
+	return
+	}
+	GQID.Path = uint64(u[0])
+	GQID.Path |= uint64(u[1])<<8
+	GQID.Path |= uint64(u[2])<<16
+	GQID.Path |= uint64(u[3])<<24
+	GQID.Path |= uint64(u[4])<<32
+	GQID.Path |= uint64(u[5])<<40
+	GQID.Path |= uint64(u[6])<<48
+	GQID.Path |= uint64(u[7])<<56
+	if _, err = b.Read(u[:4]); err != nil {
+		err = fmt.Errorf("pkt too short for uint32: need 4, have %d", b.Len())
+	return
+	}
+	GMode = uint32(u[0])
+	GMode |= uint32(u[1])<<8
+	GMode |= uint32(u[2])<<16
+	GMode |= uint32(u[3])<<24
+	if _, err = b.Read(u[:4]); err != nil {
+		err = fmt.Errorf("pkt too short for uint32: need 4, have %d", b.Len())
+	return
+	}
+	UID = uint32(u[0])
+	UID |= uint32(u[1])<<8
+	UID |= uint32(u[2])<<16
+	UID |= uint32(u[3])<<24
+	if _, err = b.Read(u[:4]); err != nil {
+		err = fmt.Errorf("pkt too short for uint32: need 4, have %d", b.Len())
+	return
+	}
+	GID = uint32(u[0])
+	GID |= uint32(u[1])<<8
+	GID |= uint32(u[2])<<16
+	GID |= uint32(u[3])<<24
+	if _, err = b.Read(u[:8]); err != nil {
+		err = fmt.Errorf("pkt too short for uint64: need 8, have %d", b.Len())
+	return
+	}
+	NLink = uint64(u[0])
+	NLink |= uint64(u[1])<<8
+	NLink |= uint64(u[2])<<16
+	NLink |= uint64(u[3])<<24
+	NLink |= uint64(u[4])<<32
+	NLink |= uint64(u[5])<<40
+	NLink |= uint64(u[6])<<48
+	NLink |= uint64(u[7])<<56
+	if _, err = b.Read(u[:8]); err != nil {
+		err = fmt.Errorf("pkt too short for uint64: need 8, have %d", b.Len())
+	return
+	}
+	RDev = uint64(u[0])
+	RDev |= uint64(u[1])<<8
+	RDev |= uint64(u[2])<<16
+	RDev |= uint64(u[3])<<24
+	RDev |= uint64(u[4])<<32
+	RDev |= uint64(u[5])<<40
+	RDev |= uint64(u[6])<<48
+	RDev |= uint64(u[7])<<56
+	if _, err = b.Read(u[:8]); err != nil {
+		err = fmt.Errorf("pkt too short for uint64: need 8, have %d", b.Len())
+	return
+	}
+	Size = uint64(u[0])
+	Size |= uint64(u[1])<<8
+	Size |= uint64(u[2])<<16
+	Size |= uint64(u[3])<<24
+	Size |= uint64(u[4])<<32
+	Size |= uint64(u[5])<<40
+	Size |= uint64(u[6])<<48
+	Size |= uint64(u[7])<<56
+	if _, err = b.Read(u[:8]); err != nil {
+		err = fmt.Errorf("pkt too short for uint64: need 8, have %d", b.Len())
+	return
+	}
+	BlkSize = uint64(u[0])
+	BlkSize |= uint64(u[1])<<8
+	BlkSize |= uint64(u[2])<<16
+	BlkSize |= uint64(u[3])<<24
+	BlkSize |= uint64(u[4])<<32
+	BlkSize |= uint64(u[5])<<40
+	BlkSize |= uint64(u[6])<<48
+	BlkSize |= uint64(u[7])<<56
+	if _, err = b.Read(u[:8]); err != nil {
+		err = fmt.Errorf("pkt too short for uint64: need 8, have %d", b.Len())
+	return
+	}
+	Blocks = uint64(u[0])
+	Blocks |= uint64(u[1])<<8
+	Blocks |= uint64(u[2])<<16
+	Blocks |= uint64(u[3])<<24
+	Blocks |= uint64(u[4])<<32
+	Blocks |= uint64(u[5])<<40
+	Blocks |= uint64(u[6])<<48
+	Blocks |= uint64(u[7])<<56
+	if _, err = b.Read(u[:8]); err != nil {
+		err = fmt.Errorf("pkt too short for uint64: need 8, have %d", b.Len())
+	return
+	}
+	ATimeSec = uint64(u[0])
+	ATimeSec |= uint64(u[1])<<8
+	ATimeSec |= uint64(u[2])<<16
+	ATimeSec |= uint64(u[3])<<24
+	ATimeSec |= uint64(u[4])<<32
+	ATimeSec |= uint64(u[5])<<40
+	ATimeSec |= uint64(u[6])<<48
+	ATimeSec |= uint64(u[7])<<56
+	if _, err = b.Read(u[:8]); err != nil {
+		err = fmt.Errorf("pkt too short for uint64: need 8, have %d", b.Len())
+	return
+	}
+	ATimeNsec = uint64(u[0])
+	ATimeNsec |= uint64(u[1])<<8
+	ATimeNsec |= uint64(u[2])<<16
+	ATimeNsec |= uint64(u[3])<<24
+	ATimeNsec |= uint64(u[4])<<32
+	ATimeNsec |= uint64(u[5])<<40
+	ATimeNsec |= uint64(u[6])<<48
+	ATimeNsec |= uint64(u[7])<<56
+	if _, err = b.Read(u[:8]); err != nil {
+		err = fmt.Errorf("pkt too short for uint64: need 8, have %d", b.Len())
+	return
+	}
+	MTimeSec = uint64(u[0])
+	MTimeSec |= uint64(u[1])<<8
+	MTimeSec |= uint64(u[2])<<16
+	MTimeSec |= uint64(u[3])<<24
+	MTimeSec |= uint64(u[4])<<32
+	MTimeSec |= uint64(u[5])<<40
+	MTimeSec |= uint64(u[6])<<48
+	MTimeSec |= uint64(u[7])<<56
+	if _, err = b.Read(u[:8]); err != nil {
+		err = fmt.Errorf("pkt too short for uint64: need 8, have %d", b.Len())
+	return
+	}
+	MTimeNsec = uint64(u[0])
+	MTimeNsec |= uint64(u[1])<<8
+	MTimeNsec |= uint64(u[2])<<16
+	MTimeNsec |= uint64(u[3])<<24
+	MTimeNsec |= uint64(u[4])<<32
+	MTimeNsec |= uint64(u[5])<<40
+	MTimeNsec |= uint64(u[6])<<48
+	MTimeNsec |= uint64(u[7])<<56
+	if _, err = b.Read(u[:8]); err != nil {
+		err = fmt.Errorf("pkt too short for uint64: need 8, have %d", b.Len())
+	return
+	}
+	CTimeSec = uint64(u[0])
+	CTimeSec |= uint64(u[1])<<8
+	CTimeSec |= uint64(u[2])<<16
+	CTimeSec |= uint64(u[3])<<24
+	CTimeSec |= uint64(u[4])<<32
+	CTimeSec |= uint64(u[5])<<40
+	CTimeSec |= uint64(u[6])<<48
+	CTimeSec |= uint64(u[7])<<56
+	if _, err = b.Read(u[:8]); err != nil {
+		err = fmt.Errorf("pkt too short for uint64: need 8, have %d", b.Len())
+	return
+	}
+	CTimeNsec = uint64(u[0])
+	CTimeNsec |= uint64(u[1])<<8
+	CTimeNsec |= uint64(u[2])<<16
+	CTimeNsec |= uint64(u[3])<<24
+	CTimeNsec |= uint64(u[4])<<32
+	CTimeNsec |= uint64(u[5])<<40
+	CTimeNsec |= uint64(u[6])<<48
+	CTimeNsec |= uint64(u[7])<<56
+	if _, err = b.Read(u[:8]); err != nil {
+		err = fmt.Errorf("pkt too short for uint64: need 8, have %d", b.Len())
+	return
+	}
+	BTimeSec = uint64(u[0])
+	BTimeSec |= uint64(u[1])<<8
+	BTimeSec |= uint64(u[2])<<16
+	BTimeSec |= uint64(u[3])<<24
+	BTimeSec |= uint64(u[4])<<32
+	BTimeSec |= uint64(u[5])<<40
+	BTimeSec |= uint64(u[6])<<48
+	BTimeSec |= uint64(u[7])<<56
+	if _, err = b.Read(u[:8]); err != nil {
+		err = fmt.Errorf("pkt too short for uint64: need 8, have %d", b.Len())
+	return
+	}
+	BTimeNsec = uint64(u[0])
+	BTimeNsec |= uint64(u[1])<<8
+	BTimeNsec |= uint64(u[2])<<16
+	BTimeNsec |= uint64(u[3])<<24
+	BTimeNsec |= uint64(u[4])<<32
+	BTimeNsec |= uint64(u[5])<<40
+	BTimeNsec |= uint64(u[6])<<48
+	BTimeNsec |= uint64(u[7])<<56
+	if _, err = b.Read(u[:8]); err != nil {
+		err = fmt.Errorf("pkt too short for uint64: need 8, have %d", b.Len())
+	return
+	}
+	Gen = uint64(u[0])
+	Gen |= uint64(u[1])<<8
+	Gen |= uint64(u[2])<<16
+	Gen |= uint64(u[3])<<24
+	Gen |= uint64(u[4])<<32
+	Gen |= uint64(u[5])<<40
+	Gen |= uint64(u[6])<<48
+	Gen |= uint64(u[7])<<56
+	if _, err = b.Read(u[:8]); err != nil {
+		err = fmt.Errorf("pkt too short for uint64: need 8, have %d", b.Len())
+	return
+	}
+	DataVersion = uint64(u[0])
+	DataVersion |= uint64(u[1])<<8
+	DataVersion |= uint64(u[2])<<16
+	DataVersion |= uint64(u[3])<<24
+	DataVersion |= uint64(u[4])<<32
+	DataVersion |= uint64(u[5])<<40
+	DataVersion |= uint64(u[6])<<48
+	DataVersion |= uint64(u[7])<<56
+
+if b.Len() > 0 {
+err = fmt.Errorf("Packet too long: %d bytes left over after decode", b.Len())
+}
+return
+}
+func MarshalTgetattrPkt (b *bytes.Buffer, t Tag, OFID FID, Mask uint64) {
+var l uint64
+b.Reset()
+b.Write([]byte{0,0,0,0,
+uint8(Tgetattr),
+byte(t), byte(t>>8),
+	uint8(OFID>>0),
+	uint8(OFID>>8),
+	uint8(OFID>>16),
+	uint8(OFID>>24),
+	uint8(Mask>>0),
+	uint8(Mask>>8),
+	uint8(Mask>>16),
+	uint8(Mask>>24),
+	uint8(Mask>>32),
+	uint8(Mask>>40),
+	uint8(Mask>>48),
+	uint8(Mask>>56),
+	})
+
+{
+l = uint64(b.Len())
+copy(b.Bytes(), []byte{uint8(l), uint8(l>>8), uint8(l>>16), uint8(l>>24)})
+}
+return
+}
+func UnmarshalTgetattrPkt (b *bytes.Buffer) (OFID FID, Mask uint64,  t Tag, err error) {
+var u [8]uint8
+var l uint64
+if _, err = b.Read(u[:2]); err != nil {
+err = fmt.Errorf("pkt too short for Tag; need 2, have %d", b.Len())
+return
+}
+l = uint64(u[0]) | uint64(u[1])<<8
+t = Tag(l)
+	if _, err = b.Read(u[:4]); err != nil {
+		err = fmt.Errorf("pkt too short for uint32: need 4, have %d", b.Len())
+	return
+	}
+	OFID = FID(u[0])
+	OFID |= FID(u[1])<<8
+	OFID |= FID(u[2])<<16
+	OFID |= FID(u[3])<<24
+	if _, err = b.Read(u[:8]); err != nil {
+		err = fmt.Errorf("pkt too short for uint64: need 8, have %d", b.Len())
+	return
+	}
+	Mask = uint64(u[0])
+	Mask |= uint64(u[1])<<8
+	Mask |= uint64(u[2])<<16
+	Mask |= uint64(u[3])<<24
+	Mask |= uint64(u[4])<<32
+	Mask |= uint64(u[5])<<40
+	Mask |= uint64(u[6])<<48
+	Mask |= uint64(u[7])<<56
+
+if b.Len() > 0 {
+err = fmt.Errorf("Packet too long: %d bytes left over after decode", b.Len())
+}
+return
+}
+func MarshalRsetattrPkt (b *bytes.Buffer, t Tag, ) {
+var l uint64
+b.Reset()
+b.Write([]byte{0,0,0,0,
+uint8(Rsetattr),
+byte(t), byte(t>>8),
+	})
+
+{
+l = uint64(b.Len())
+copy(b.Bytes(), []byte{uint8(l), uint8(l>>8), uint8(l>>16), uint8(l>>24)})
+}
+return
+}
+func UnmarshalRsetattrPkt (b *bytes.Buffer) ( t Tag, err error) {
+var u [8]uint8
+var l uint64
+if _, err = b.Read(u[:2]); err != nil {
+err = fmt.Errorf("pkt too short for Tag; need 2, have %d", b.Len())
+return
+}
+l = uint64(u[0]) | uint64(u[1])<<8
+t = Tag(l)
+
+if b.Len() > 0 {
+err = fmt.Errorf("Packet too long: %d bytes left over after decode", b.Len())
+}
+return
+}
+func MarshalTsetattrPkt (b *bytes.Buffer, t Tag, OFID FID, SValid uint32, SMode uint32, SUID uint32, SGID uint32, SSize uint64, SATimeSec uint64, SATimeNsec uint64, SMTimeSec uint64, SMTimeNsec uint64) {
+var l uint64
+b.Reset()
+b.Write([]byte{0,0,0,0,
+uint8(Tsetattr),
+byte(t), byte(t>>8),
+	uint8(OFID>>0),
+	uint8(OFID>>8),
+	uint8(OFID>>16),
+	uint8(OFID>>24),
+	uint8(SValid>>0),
+	uint8(SValid>>8),
+	uint8(SValid>>16),
+	uint8(SValid>>24),
+	uint8(SMode>>0),
+	uint8(SMode>>8),
+	uint8(SMode>>16),
+	uint8(SMode>>24),
+	uint8(SUID>>0),
+	uint8(SUID>>8),
+	uint8(SUID>>16),
+	uint8(SUID>>24),
+	uint8(SGID>>0),
+	uint8(SGID>>8),
+	uint8(SGID>>16),
+	uint8(SGID>>24),
+	uint8(SSize>>0),
+	uint8(SSize>>8),
+	uint8(SSize>>16),
+	uint8(SSize>>24),
+	uint8(SSize>>32),
+	uint8(SSize>>40),
+	uint8(SSize>>48),
+	uint8(SSize>>56),
+	uint8(SATimeSec>>0),
+	uint8(SATimeSec>>8),
+	uint8(SATimeSec>>16),
+	uint8(SATimeSec>>24),
+	uint8(SATimeSec>>32),
+	uint8(SATimeSec>>40),
+	uint8(SATimeSec>>48),
+	uint8(SATimeSec>>56),
+	uint8(SATimeNsec>>0),
+	uint8(SATimeNsec>>8),
+	uint8(SATimeNsec>>16),
+	uint8(SATimeNsec>>24),
+	uint8(SATimeNsec>>32),
+	uint8(SATimeNsec>>40),
+	uint8(SATimeNsec>>48),
+	uint8(SATimeNsec>>56),
+	uint8(SMTimeSec>>0),
+	uint8(SMTimeSec>>8),
+	uint8(SMTimeSec>>16),
+	uint8(SMTimeSec>>24),
+	uint8(SMTimeSec>>32),
+	uint8(SMTimeSec>>40),
+	uint8(SMTimeSec>>48),
+	uint8(SMTimeSec>>56),
+	uint8(SMTimeNsec>>0),
+	uint8(SMTimeNsec>>8),
+	uint8(SMTimeNsec>>16),
+	uint8(SMTimeNsec>>24),
+	uint8(SMTimeNsec>>32),
+	uint8(SMTimeNsec>>40),
+	uint8(SMTimeNsec>>48),
+	uint8(SMTimeNsec>>56),
+	})
+
+{
+l = uint64(b.Len())
+copy(b.Bytes(), []byte{uint8(l), uint8(l>>8), uint8(l>>16), uint8(l>>24)})
+}
+return
+}
+func UnmarshalTsetattrPkt (b *bytes.Buffer) (OFID FID, SValid uint32, SMode uint32, SUID uint32, SGID uint32, SSize uint64, SATimeSec uint64, SATimeNsec uint64, SMTimeSec uint64, SMTimeNsec uint64,  t Tag, err error) {
+var u [8]uint8
+var l uint64
+if _, err = b.Read(u[:2]); err != nil {
+err = fmt.Errorf("pkt too short for Tag; need 2, have %d", b.Len())
+return
+}
+l = uint64(u[0]) | uint64(u[1])<<8
+t = Tag(l)
+	if _, err = b.Read(u[:4]); err != nil {
+		err = fmt.Errorf("pkt too short for uint32: need 4, have %d", b.Len())
+	return
+	}
+	OFID = FID(u[0])
+	OFID |= FID(u[1])<<8
+	OFID |= FID(u[2])<<16
+	OFID |= FID(u[3])<<24
+	if _, err = b.Read(u[:4]); err != nil {
+		err = fmt.Errorf("pkt too short for uint32: need 4, have %d", b.Len())
+	return
+	}
+	SValid = uint32(u[0])
+	SValid |= uint32(u[1])<<8
+	SValid |= uint32(u[2])<<16
+	SValid |= uint32(u[3])<<24
+	if _, err = b.Read(u[:4]); err != nil {
+		err = fmt.Errorf("pkt too short for uint32: need 4, have %d", b.Len())
+	return
+	}
+	SMode = uint32(u[0])
+	SMode |= uint32(u[1])<<8
+	SMode |= uint32(u[2])<<16
+	SMode |= uint32(u[3])<<24
+	if _, err = b.Read(u[:4]); err != nil {
+		err = fmt.Errorf("pkt too short for uint32: need 4, have %d", b.Len())
+	return
+	}
+	SUID = uint32(u[0])
+	SUID |= uint32(u[1])<<8
+	SUID |= uint32(u[2])<<16
+	SUID |= uint32(u[3])<<24
+	if _, err = b.Read(u[:4]); err != nil {
+		err = fmt.Errorf("pkt too short for uint32: need 4, have %d", b.Len())
+	return
+	}
+	SGID = uint32(u[0])
+	SGID |= uint32(u[1])<<8
+	SGID |= uint32(u[2])<<16
+	SGID |= uint32(u[3])<<24
+	if _, err = b.Read(u[:8]); err != nil {
+		err = fmt.Errorf("pkt too short for uint64: need 8, have %d", b.Len())
+	return
+	}
+	SSize = uint64(u[0])
+	SSize |= uint64(u[1])<<8
+	SSize |= uint64(u[2])<<16
+	SSize |= uint64(u[3])<<24
+	SSize |= uint64(u[4])<<32
+	SSize |= uint64(u[5])<<40
+	SSize |= uint64(u[6])<<48
+	SSize |= uint64(u[7])<<56
+	if _, err = b.Read(u[:8]); err != nil {
+		err = fmt.Errorf("pkt too short for uint64: need 8, have %d", b.Len())
+	return
+	}
+	SATimeSec = uint64(u[0])
+	SATimeSec |= uint64(u[1])<<8
+	SATimeSec |= uint64(u[2])<<16
+	SATimeSec |= uint64(u[3])<<24
+	SATimeSec |= uint64(u[4])<<32
+	SATimeSec |= uint64(u[5])<<40
+	SATimeSec |= uint64(u[6])<<48
+	SATimeSec |= uint64(u[7])<<56
+	if _, err = b.Read(u[:8]); err != nil {
+		err = fmt.Errorf("pkt too short for uint64: need 8, have %d", b.Len())
+	return
+	}
+	SATimeNsec = uint64(u[0])
+	SATimeNsec |= uint64(u[1])<<8
+	SATimeNsec |= uint64(u[2])<<16
+	SATimeNsec |= uint64(u[3])<<24
+	SATimeNsec |= uint64(u[4])<<32
+	SATimeNsec |= uint64(u[5])<<40
+	SATimeNsec |= uint64(u[6])<<48
+	SATimeNsec |= uint64(u[7])<<56
+	if _, err = b.Read(u[:8]); err != nil {
+		err = fmt.Errorf("pkt too short for uint64: need 8, have %d", b.Len())
+	return
+	}
+	SMTimeSec = uint64(u[0])
+	SMTimeSec |= uint64(u[1])<<8
+	SMTimeSec |= uint64(u[2])<<16
+	SMTimeSec |= uint64(u[3])<<24
+	SMTimeSec |= uint64(u[4])<<32
+	SMTimeSec |= uint64(u[5])<<40
+	SMTimeSec |= uint64(u[6])<<48
+	SMTimeSec |= uint64(u[7])<<56
+	if _, err = b.Read(u[:8]); err != nil {
+		err = fmt.Errorf("pkt too short for uint64: need 8, have %d", b.Len())
+	return
+	}
+	SMTimeNsec = uint64(u[0])
+	SMTimeNsec |= uint64(u[1])<<8
+	SMTimeNsec |= uint64(u[2])<<16
+	SMTimeNsec |= uint64(u[3])<<24
+	SMTimeNsec |= uint64(u[4])<<32
+	SMTimeNsec |= uint64(u[5])<<40
+	SMTimeNsec |= uint64(u[6])<<48
+	SMTimeNsec |= uint64(u[7])<<56
+
+if b.Len() > 0 {
+err = fmt.Errorf("Packet too long: %d bytes left over after decode", b.Len())
+}
+return
+}
+func MarshalRreaddirPkt (b *bytes.Buffer, t Tag, Data []uint8) {
+var l uint64
+b.Reset()
+b.Write([]byte{0,0,0,0,
+uint8(Rreaddir),
+byte(t), byte(t>>8),
+	uint8(len(Data)>>0),
+	uint8(len(Data)>>8),
+	uint8(len(Data)>>16),
+	uint8(len(Data)>>24),
+	})
+	b.Write(Data)
+
+{
+l = uint64(b.Len())
+copy(b.Bytes(), []byte{uint8(l), uint8(l>>8), uint8(l>>16), uint8(l>>24)})
+}
+return
+}
+func UnmarshalRreaddirPkt (b *bytes.Buffer) (Data []uint8,  t Tag, err error) {
+var u [8]uint8
+var l uint64
+if _, err = b.Read(u[:2]); err != nil {
+err = fmt.Errorf("pkt too short for Tag; need 2, have %d", b.Len())
+return
+}
+l = uint64(u[0]) | uint64(u[1])<<8
+t = Tag(l)
+	if _, err = b.Read(u[:4]); err != nil {
+		err = fmt.Errorf("pkt too short for uint32: need 4, have %d", b.Len())
+	return
+	}
+	l = uint64(u[0])
+	l |= uint64(u[1])<<8
+	l |= uint64(u[2])<<16
+	l |= uint64(u[3])<<24
+	Data = b.Bytes()[:l]
+	_ = b.Next(int(l))
+
+if b.Len() > 0 {
+err = fmt.Errorf("Packet too long: %d bytes left over after decode", b.Len())
+}
+return
+}
+func MarshalTreaddirPkt (b *bytes.Buffer, t Tag, OFID FID, Off Offset, Len Count) {
+var l uint64
+b.Reset()
+b.Write([]byte{0,0,0,0,
+uint8(Treaddir),
+byte(t), byte(t>>8),
+	uint8(OFID>>0),
+	uint8(OFID>>8),
+	uint8(OFID>>16),
+	uint8(OFID>>24),
+	uint8(Off>>0),
+	uint8(Off>>8),
+	uint8(Off>>16),
+	uint8(Off>>24),
+	uint8(Off>>32),
+	uint8(Off>>40),
+	uint8(Off>>48),
+	uint8(Off>>56),
+	uint8(Len>>0),
+	uint8(Len>>8),
+	uint8(Len>>16),
+	uint8(Len>>24),
+	})
+
+{
+l = uint64(b.Len())
+copy(b.Bytes(), []byte{uint8(l), uint8(l>>8), uint8(l>>16), uint8(l>>24)})
+}
+return
+}
+func UnmarshalTreaddirPkt (b *bytes.Buffer) (OFID FID, Off Offset, Len Count,  t Tag, err error) {
+var u [8]uint8
+var l uint64
+if _, err = b.Read(u[:2]); err != nil {
+err = fmt.Errorf("pkt too short for Tag; need 2, have %d", b.Len())
+return
+}
+l = uint64(u[0]) | uint64(u[1])<<8
+t = Tag(l)
+	if _, err = b.Read(u[:4]); err != nil {
+		err = fmt.Errorf("pkt too short for uint32: need 4, have %d", b.Len())
+	return
+	}
+	OFID = FID(u[0])
+	OFID |= FID(u[1])<<8
+	OFID |= FID(u[2])<<16
+	OFID |= FID(u[3])<<24
+	if _, err = b.Read(u[:8]); err != nil {
+		err = fmt.Errorf("pkt too short for uint64: need 8, have %d", b.Len())
+	return
+	}
+	Off = Offset(u[0])
+	Off |= Offset(u[1])<<8
+	Off |= Offset(u[2])<<16
+	Off |= Offset(u[3])<<24
+	Off |= Offset(u[4])<<32
+	Off |= Offset(u[5])<<40
+	Off |= Offset(u[6])<<48
+	Off |= Offset(u[7])<<56
+	if _, err = b.Read(u[:4]); err != nil {
+		err = fmt.Errorf("pkt too short for uint32: need 4, have %d", b.Len())
+	return
+	}
+	Len = Count(u[0])
+	Len |= Count(u[1])<<8
+	Len |= Count(u[2])<<16
+	Len |= Count(u[3])<<24
+
+if b.Len() > 0 {
+err = fmt.Errorf("Packet too long: %d bytes left over after decode", b.Len())
+}
+return
+}
+func MarshalRfsyncPkt (b *bytes.Buffer, t Tag, ) {
+var l uint64
+b.Reset()
+b.Write([]byte{0,0,0,0,
+uint8(Rfsync),
+byte(t), byte(t>>8),
+	})
+
+{
+l = uint64(b.Len())
+copy(b.Bytes(), []byte{uint8(l), uint8(l>>8), uint8(l>>16), uint8(l>>24)})
+}
+return
+}
+func UnmarshalRfsyncPkt (b *bytes.Buffer) ( t Tag, err error) {
+var u [8]uint8
+var l uint64
+if _, err = b.Read(u[:2]); err != nil {
+err = fmt.Errorf("pkt too short for Tag; need 2, have %d", b.Len())
+return
+}
+l = uint64(u[0]) | uint64(u[1])<<8
+t = Tag(l)
+
+if b.Len() > 0 {
+err = fmt.Errorf("Packet too long: %d bytes left over after decode", b.Len())
+}
+return
+}
+func MarshalTfsyncPkt (b *bytes.Buffer, t Tag, OFID FID, DataSync uint32) {
+var l uint64
+b.Reset()
+b.Write([]byte{0,0,0,0,
+uint8(Tfsync),
+byte(t), byte(t>>8),
+	uint8(OFID>>0),
+	uint8(OFID>>8),
+	uint8(OFID>>16),
+	uint8(OFID>>24),
+	uint8(DataSync>>0),
+	uint8(DataSync>>8),
+	uint8(DataSync>>16),
+	uint8(DataSync>>24),
+	})
+
+{
+l = uint64(b.Len())
+copy(b.Bytes(), []byte{uint8(l), uint8(l>>8), uint8(l>>16), uint8(l>>24)})
+}
+return
+}
+func UnmarshalTfsyncPkt (b *bytes.Buffer) (OFID FID, DataSync uint32,  t Tag, err error) {
+var u [8]uint8
+var l uint64
+if _, err = b.Read(u[:2]); err != nil {
+err = fmt.Errorf("pkt too short for Tag; need 2, have %d", b.Len())
+return
+}
+l = uint64(u[0]) | uint64(u[1])<<8
+t = Tag(l)
+	if _, err = b.Read(u[:4]); err != nil {
+		err = fmt.Errorf("pkt too short for uint32: need 4, have %d", b.Len())
+	return
+	}
+	OFID = FID(u[0])
+	OFID |= FID(u[1])<<8
+	OFID |= FID(u[2])<<16
+	OFID |= FID(u[3])<<24
+	if _, err = b.Read(u[:4]); err != nil {
+		err = fmt.Errorf("pkt too short for uint32: need 4, have %d", b.Len())
+	return
+	}
+	DataSync = uint32(u[0])
+	DataSync |= uint32(u[1])<<8
+	DataSync |= uint32(u[2])<<16
+	DataSync |= uint32(u[3])<<24
+
+if b.Len() > 0 {
+err = fmt.Errorf("Packet too long: %d bytes left over after decode", b.Len())
+}
+return
+}
+func MarshalRmkdirPkt (b *bytes.Buffer, t Tag, OQID QID) {
+var l uint64
+b.Reset()
+b.Write([]byte{0,0,0,0,
+uint8(Rmkdir),
+byte(t), byte(t>>8),
+	uint8(OQID.Type>>0),
+	uint8(OQID.Version>>0),
+	uint8(OQID.Version>>8),
+	uint8(OQID.Version>>16),
+	uint8(OQID.Version>>24),
+	uint8(OQID.Path>>0),
+	uint8(OQID.Path>>8),
+	uint8(OQID.Path>>16),
+	uint8(OQID.Path>>24),
+	uint8(OQID.Path>>32),
+	uint8(OQID.Path>>40),
+	uint8(OQID.Path>>48),
+	uint8(OQID.Path>>56),
+	})
+
+{
+l = uint64(b.Len())
+copy(b.Bytes(), []byte{uint8(l), uint8(l>>8), uint8(l>>16), uint8(l>>24)})
+}
+return
+}
+func UnmarshalRmkdirPkt (b *bytes.Buffer) (OQID QID,  t Tag, err error) {
+var u [8]uint8
+var l uint64
+if _, err = b.Read(u[:2]); err != nil {
+err = fmt.Errorf("pkt too short for Tag; need 2, have %d", b.Len())
+return
+}
+l = uint64(u[0]) | uint64(u[1])<<8
+t = Tag(l)
+	if _, err = b.Read(u[:1]); err != nil {
+		err = fmt.Errorf("pkt too short for uint8: need 1, have %d", b.Len())
+	return
+	}
+	OQID.Type = uint8(u[0])
+	if _, err = b.Read(u[:4]); err != nil {
+		err = fmt.Errorf("pkt too short for uint32: need 4, have %d", b.Len())
+	return
+	}
+	OQID.Version = uint32(u[0])
+	OQID.Version |= uint32(u[1])<<8
+	OQID.Version |= uint32(u[2])<<16
+	OQID.Version |= uint32(u[3])<<24
+	if _, err = b.Read(u[:8]); err != nil {
+		err = fmt.Errorf("pkt too short for uint64: need 8, have %d", b.Len())
+	return
+	}
+	OQID.Path = uint64(u[0])
+	OQID.Path |= uint64(u[1])<<8
+	OQID.Path |= uint64(u[2])<<16
+	OQID.Path |= uint64(u[3])<<24
+	OQID.Path |= uint64(u[4])<<32
+	OQID.Path |= uint64(u[5])<<40
+	OQID.Path |= uint64(u[6])<<48
+	OQID.Path |= uint64(u[7])<<56
+
+if b.Len() > 0 {
+err = fmt.Errorf("Packet too long: %d bytes left over after decode", b.Len())
+}
+return
+}
+func MarshalTmkdirPkt (b *bytes.Buffer, t Tag, DFID FID, Name string, LMode uint32, Gid uint32) {
+var l uint64
+b.Reset()
+b.Write([]byte{0,0,0,0,
+uint8(Tmkdir),
+byte(t), byte(t>>8),
+	uint8(DFID>>0),
+	uint8(DFID>>8),
+	uint8(DFID>>16),
+	uint8(DFID>>24),
+	uint8(len(Name)),uint8(len(Name)>>8),
+	})
+	b.Write([]byte(Name))
+	b.Write([]byte{	uint8(LMode>>0),
+	uint8(LMode>>8),
+	uint8(LMode>>16),
+	uint8(LMode>>24),
+	uint8(Gid>>0),
+	uint8(Gid>>8),
+	uint8(Gid>>16),
+	uint8(Gid>>24),
+	})
+
+{
+l = uint64(b.Len())
+copy(b.Bytes(), []byte{uint8(l), uint8(l>>8), uint8(l>>16), uint8(l>>24)})
+}
+return
+}
+func UnmarshalTmkdirPkt (b *bytes.Buffer) (DFID FID, Name string, LMode uint32, Gid uint32,  t Tag, err error) {
+var u [8]uint8
+var l uint64
+if _, err = b.Read(u[:2]); err != nil {
+err = fmt.Errorf("pkt too short for Tag; need 2, have %d", b.Len())
+return
+}
+l = uint64(u[0]) | uint64(u[1])<<8
+t = Tag(l)
+	if _, err = b.Read(u[:4]); err != nil {
+		err = fmt.Errorf("pkt too short for uint32: need 4, have %d", b.Len())
+	return
+	}
+	DFID = FID(u[0])
+	DFID |= FID(u[1])<<8
+	DFID |= FID(u[2])<<16
+	DFID |= FID(u[3])<<24
+	if _, err = b.Read(u[:2]); err != nil {
+		err = fmt.Errorf("pkt too short for uint16: need 2, have %d", b.Len())
+	return
+	}
+	l = uint64(u[0])
+	l |= uint64(u[1])<<8
+	if b.Len() < int(l) {
+		err = fmt.Errorf("pkt too short for string: need %d, have %d", l, b.Len())
+	return
+	}
+	Name = string(b.Bytes()[:l])
+	_ = b.Next(int(l))
+	if _, err = b.Read(u[:4]); err != nil {
+		err = fmt.Errorf("pkt too short for uint32: need 4, have %d", b.Len())
+	return
+	}
+	LMode = uint32(u[0])
+	LMode |= uint32(u[1])<<8
+	LMode |= uint32(u[2])<<16
+	LMode |= uint32(u[3])<<24
+	if _, err = b.Read(u[:4]); err != nil {
+		err = fmt.Errorf("pkt too short for uint32: need 4, have %d", b.Len())
+	return
+	}
+	Gid = uint32(u[0])
+	Gid |= uint32(u[1])<<8
+	Gid |= uint32(u[2])<<16
+	Gid |= uint32(u[3])<<24
+
+if b.Len() > 0 {
+err = fmt.Errorf("Packet too long: %d bytes left over after decode", b.Len())
+}
+return
+}
+func MarshalRrenameatPkt (b *bytes.Buffer, t Tag, ) {
+var l uint64
+b.Reset()
+b.Write([]byte{0,0,0,0,
+uint8(Rrenameat),
+byte(t), byte(t>>8),
+	})
+
+{
+l = uint64(b.Len())
+copy(b.Bytes(), []byte{uint8(l), uint8(l>>8), uint8(l>>16), uint8(l>>24)})
+}
+return
+}
+func UnmarshalRrenameatPkt (b *bytes.Buffer) ( t Tag, err error) {
+var u [8]uint8
+var l uint64
+if _, err = b.Read(u[:2]); err != nil {
+err = fmt.Errorf("pkt too short for Tag; need 2, have %d", b.Len())
+return
+}
+l = uint64(u[0]) | uint64(u[1])<<8
+t = Tag(l)
+
+if b.Len() > 0 {
+err = fmt.Errorf("Packet too long: %d bytes left over after decode", b.Len())
+}
+return
+}
+func MarshalTrenameatPkt (b *bytes.Buffer, t Tag, OldDFID FID, OldName string, NewDFID FID, NewName string) {
+var l uint64
+b.Reset()
+b.Write([]byte{0,0,0,0,
+uint8(Trenameat),
+byte(t), byte(t>>8),
+	uint8(OldDFID>>0),
+	uint8(OldDFID>>8),
+	uint8(OldDFID>>16),
+	uint8(OldDFID>>24),
+	uint8(len(OldName)),uint8(len(OldName)>>8),
+	})
+	b.Write([]byte(OldName))
+	b.Write([]byte{	uint8(NewDFID>>0),
+	uint8(NewDFID>>8),
+	uint8(NewDFID>>16),
+	uint8(NewDFID>>24),
+	uint8(len(NewName)),uint8(len(NewName)>>8),
+	})
+	b.Write([]byte(NewName))
+
+{
+l = uint64(b.Len())
+copy(b.Bytes(), []byte{uint8(l), uint8(l>>8), uint8(l>>16), uint8(l>>24)})
+}
+return
+}
+func UnmarshalTrenameatPkt (b *bytes.Buffer) (OldDFID FID, OldName string, NewDFID FID, NewName string,  t Tag, err error) {
+var u [8]uint8
+var l uint64
+if _, err = b.Read(u[:2]); err != nil {
+err = fmt.Errorf("pkt too short for Tag; need 2, have %d", b.Len())
+return
+}
+l = uint64(u[0]) | uint64(u[1])<<8
+t = Tag(l)
+	if _, err = b.Read(u[:4]); err != nil {
+		err = fmt.Errorf("pkt too short for uint32: need 4, have %d", b.Len())
+	return
+	}
+	OldDFID = FID(u[0])
+	OldDFID |= FID(u[1])<<8
+	OldDFID |= FID(u[2])<<16
+	OldDFID |= FID(u[3])<<24
+	if _, err = b.Read(u[:2]); err != nil {
+		err = fmt.Errorf("pkt too short for uint16: need 2, have %d", b.Len())
+	return
+	}
+	l = uint64(u[0])
+	l |= uint64(u[1])<<8
+	if b.Len() < int(l) {
+		err = fmt.Errorf("pkt too short for string: need %d, have %d", l, b.Len())
+	return
+	}
+	OldName = string(b.Bytes()[:l])
+	_ = b.Next(int(l))
+	if _, err = b.Read(u[:4]); err != nil {
+		err = fmt.Errorf("pkt too short for uint32: need 4, have %d", b.Len())
+	return
+	}
+	NewDFID = FID(u[0])
+	NewDFID |= FID(u[1])<<8
+	NewDFID |= FID(u[2])<<16
+	NewDFID |= FID(u[3])<<24
+	if _, err = b.Read(u[:2]); err != nil {
+		err = fmt.Errorf("pkt too short for uint16: need 2, have %d", b.Len())
+	return
+	}
+	l = uint64(u[0])
+	l |= uint64(u[1])<<8
+	if b.Len() < int(l) {
+		err = fmt.Errorf("pkt too short for string: need %d, have %d", l, b.Len())
+	return
+	}
+	NewName = string(b.Bytes()[:l])
+	_ = b.Next(int(l))
+
+if b.Len() > 0 {
+err = fmt.Errorf("Packet too long: %d bytes left over after decode", b.Len())
+}
+return
+}
+func MarshalRunlinkatPkt (b *bytes.Buffer, t Tag, ) {
+var l uint64
+b.Reset()
+b.Write([]byte{0,0,0,0,
+uint8(Runlinkat),
+byte(t), byte(t>>8),
+	})
+
+{
+l = uint64(b.Len())
+copy(b.Bytes(), []byte{uint8(l), uint8(l>>8), uint8(l>>16), uint8(l>>24)})
+}
+return
+}
+func UnmarshalRunlinkatPkt (b *bytes.Buffer) ( t Tag, err error) {
+var u [8]uint8
+var l uint64
+if _, err = b.Read(u[:2]); err != nil {
+err = fmt.Errorf("pkt too short for Tag; need 2, have %d", b.Len())
+return
+}
+l = uint64(u[0]) | uint64(u[1])<<8
+t = Tag(l)
+
+if b.Len() > 0 {
+err = fmt.Errorf("Packet too long: %d bytes left over after decode", b.Len())
+}
+return
+}
+func MarshalTunlinkatPkt (b *bytes.Buffer, t Tag, DFID FID, Name string, UFlags uint32) {
+var l uint64
+b.Reset()
+b.Write([]byte{0,0,0,0,
+uint8(Tunlinkat),
+byte(t), byte(t>>8),
+	uint8(DFID>>0),
+	uint8(DFID>>8),
+	uint8(DFID>>16),
+	uint8(DFID>>24),
+	uint8(len(Name)),uint8(len(Name)>>8),
+	})
+	b.Write([]byte(Name))
+	b.Write([]byte{	uint8(UFlags>>0),
+	uint8(UFlags>>8),
+	uint8(UFlags>>16),
+	uint8(UFlags>>24),
+	})
+
+{
+l = uint64(b.Len())
+copy(b.Bytes(), []byte{uint8(l), uint8(l>>8), uint8(l>>16), uint8(l>>24)})
+}
+return
+}
+func UnmarshalTunlinkatPkt (b *bytes.Buffer) (DFID FID, Name string, UFlags uint32,  t Tag, err error) {
+var u [8]uint8
+var l uint64
+if _, err = b.Read(u[:2]); err != nil {
+err = fmt.Errorf("pkt too short for Tag; need 2, have %d", b.Len())
+return
+}
+l = uint64(u[0]) | uint64(u[1])<<8
+t = Tag(l)
+	if _, err = b.Read(u[:4]); err != nil {
+		err = fmt.Errorf("pkt too short for uint32: need 4, have %d", b.Len())
+	return
+	}
+	DFID = FID(u[0])
+	DFID |= FID(u[1])<<8
+	DFID |= FID(u[2])<<16
+	DFID |= FID(u[3])<<24
+	if _, err = b.Read(u[:2]); err != nil {
+		err = fmt.Errorf("pkt too short for uint16: need 2, have %d", b.Len())
+	return
+	}
+	l = uint64(u[0])
+	l |= uint64(u[1])<<8
+	if b.Len() < int(l) {
+		err = fmt.Errorf("pkt too short for string: need %d, have %d", l, b.Len())
+	return
+	}
+	Name = string(b.Bytes()[:l])
+	_ = b.Next(int(l))
+	if _, err = b.Read(u[:4]); err != nil {
+		err = fmt.Errorf("pkt too short for uint32: need 4, have %d", b.Len())
+	return
+	}
+	UFlags = uint32(u[0])
+	UFlags |= uint32(u[1])<<8
+	UFlags |= uint32(u[2])<<16
+	UFlags |= uint32(u[3])<<24
+
+if b.Len() > 0 {
+err = fmt.Errorf("Packet too long: %d bytes left over after decode", b.Len())
+}
+return
+}
+func MarshalRreadlinkPkt (b *bytes.Buffer, t Tag, Target string) {
+var l uint64
+b.Reset()
+b.Write([]byte{0,0,0,0,
+uint8(Rreadlink),
+byte(t), byte(t>>8),
+	uint8(len(Target)),uint8(len(Target)>>8),
+	})
+	b.Write([]byte(Target))
+
+{
+l = uint64(b.Len())
+copy(b.Bytes(), []byte{uint8(l), uint8(l>>8), uint8(l>>16), uint8(l>>24)})
+}
+return
+}
+func UnmarshalRreadlinkPkt (b *bytes.Buffer) (Target string,  t Tag, err error) {
+var u [8]uint8
+var l uint64
+if _, err = b.Read(u[:2]); err != nil {
+err = fmt.Errorf("pkt too short for Tag; need 2, have %d", b.Len())
+return
+}
+l = uint64(u[0]) | uint64(u[1])<<8
+t = Tag(l)
+	if _, err = b.Read(u[:2]); err != nil {
+		err = fmt.Errorf("pkt too short for uint16: need 2, have %d", b.Len())
+	return
+	}
+	l = uint64(u[0])
+	l |= uint64(u[1])<<8
+	if b.Len() < int(l) {
+		err = fmt.Errorf("pkt too short for string: need %d, have %d", l, b.Len())
+	return
+	}
+	Target = string(b.Bytes()[:l])
+	_ = b.Next(int(l))
+
+if b.Len() > 0 {
+err = fmt.Errorf("Packet too long: %d bytes left over after decode", b.Len())
+}
+return
+}
+func MarshalTreadlinkPkt (b *bytes.Buffer, t Tag, OFID FID) {
+var l uint64
+b.Reset()
+b.Write([]byte{0,0,0,0,
+uint8(Treadlink),
+byte(t), byte(t>>8),
+	uint8(OFID>>0),
+	uint8(OFID>>8),
+	uint8(OFID>>16),
+	uint8(OFID>>24),
+	})
+
+{
+l = uint64(b.Len())
+copy(b.Bytes(), []byte{uint8(l), uint8(l>>8), uint8(l>>16), uint8(l>>24)})
+}
+return
+}
+func UnmarshalTreadlinkPkt (b *bytes.Buffer) (OFID FID,  t Tag, err error) {
+var u [8]uint8
+var l uint64
+if _, err = b.Read(u[:2]); err != nil {
+err = fmt.Errorf("pkt too short for Tag; need 2, have %d", b.Len())
+return
+}
+l = uint64(u[0]) | uint64(u[1])<<8
+t = Tag(l)
+	if _, err = b.Read(u[:4]); err != nil {
+		err = fmt.Errorf("pkt too short for uint32: need 4, have %d", b.Len())
+	return
+	}
+	OFID = FID(u[0])
+	OFID |= FID(u[1])<<8
+	OFID |= FID(u[2])<<16
+	OFID |= FID(u[3])<<24
+
+if b.Len() > 0 {
+err = fmt.Errorf("Packet too long: %d bytes left over after decode", b.Len())
+}
+return
+}
+func ServerError (b *bytes.Buffer, s string) {
+	var u [8]byte
+	// This can't really happen. 
+	if _, err := b.Read(u[:2]); err != nil {
+		return
+	}
+	t := Tag(uint16(u[0])|uint16(u[1])<<8)
+	MarshalRerrorPkt (b, t, s)
+}
+func Marshaldir (b *bytes.Buffer, D Dir) {
+var l uint64
+b.Reset()
+b.Write([]byte{0,0,})
+	b.Write([]byte{	uint8(D.Type>>0),
+	uint8(D.Type>>8),
+	uint8(D.Dev>>0),
+	uint8(D.Dev>>8),
+	uint8(D.Dev>>16),
+	uint8(D.Dev>>24),
+	uint8(D.QID.Type>>0),
+	uint8(D.QID.Version>>0),
+	uint8(D.QID.Version>>8),
+	uint8(D.QID.Version>>16),
+	uint8(D.QID.Version>>24),
+	uint8(D.QID.Path>>0),
+	uint8(D.QID.Path>>8),
+	uint8(D.QID.Path>>16),
+	uint8(D.QID.Path>>24),
+	uint8(D.QID.Path>>32),
+	uint8(D.QID.Path>>40),
+	uint8(D.QID.Path>>48),
+	uint8(D.QID.Path>>56),
+	uint8(D.Mode>>0),
+	uint8(D.Mode>>8),
+	uint8(D.Mode>>16),
+	uint8(D.Mode>>24),
+	uint8(D.Atime>>0),
+	uint8(D.Atime>>8),
+	uint8(D.Atime>>16),
+	uint8(D.Atime>>24),
+	uint8(D.Mtime>>0),
+	uint8(D.Mtime>>8),
+	uint8(D.Mtime>>16),
+	uint8(D.Mtime>>24),
+	uint8(D.Length>>0),
+	uint8(D.Length>>8),
+	uint8(D.Length>>16),
+	uint8(D.Length>>24),
+	uint8(D.Length>>32),
+	uint8(D.Length>>40),
+	uint8(D.Length>>48),
+	uint8(D.Length>>56),
+	uint8(len(D.Name)),uint8(len(D.Name)>>8),
+	})
+	b.Write([]byte(D.Name))
+	b.Write([]byte{	uint8(len(D.User)),uint8(len(D.User)>>8),
+	})
+	b.Write([]byte(D.User))
+	b.Write([]byte{	uint8(len(D.Group)),uint8(len(D.Group)>>8),
+	})
+	b.Write([]byte(D.Group))
+	b.Write([]byte{	uint8(len(D.ModUser)),uint8(len(D.ModUser)>>8),
+	})
+	b.Write([]byte(D.ModUser))
+
+l = uint64(b.Len()) - 2
+copy(b.Bytes(), []byte{uint8(l), uint8(l>>8)})
+return
+}
+func Unmarshaldir (b *bytes.Buffer) (D Dir,  err error) {
+var u [8]uint8
+var l uint64
+_ = b.Next(2) // eat the length too
+	if _, err = b.Read(u[:2]); err != nil {
+		err = fmt.Errorf("pkt too short for uint16: need 2, have %d", b.Len())
+	return
+	}
+	D.Type = uint16(u[0])
+	D.Type |= uint16(u[1])<<8
+	if _, err = b.Read(u[:4]); err != nil {
+		err = fmt.Errorf("pkt too short for uint32: need 4, have %d", b.Len())
+	return
+	}
+	D.Dev = uint32(u[0])
+	D.Dev |= uint32(u[1])<<8
+	D.Dev |= uint32(u[2])<<16
+	D.Dev |= uint32(u[3])<<24
+	if _, err = b.Read(u[:1]); err != nil {
+		err = fmt.Errorf("pkt too short for uint8: need 1, have %d", b.Len())
+	return
+	}
+	D.QID.Type = uint8(u[0])
+	if _, err = b.Read(u[:4]); err != nil {
+		err = fmt.Errorf("pkt too short for uint32: need 4, have %d", b.Len())
+	return
+	}
+	D.QID.Version = uint32(u[0])
+	D.QID.Version |= uint32(u[1])<<8
+	D.QID.Version |= uint32(u[2])<<16
+	D.QID.Version |= uint32(u[3])<<24
+	if _, err = b.Read(u[:8]); err != nil {
+		err = fmt.Errorf("pkt too short for uint64: need 8, have %d", b.Len())
+	return
+	}
+	D.QID.Path = uint64(u[0])
+	D.QID.Path |= uint64(u[1])<<8
+	D.QID.Path |= uint64(u[2])<<16
+	D.QID.Path |= uint64(u[3])<<24
+	D.QID.Path |= uint64(u[4])<<32
+	D.QID.Path |= uint64(u[5])<<40
+	D.QID.Path |= uint64(u[6])<<48
+	D.QID.Path |= uint64(u[7])<<56
+	if _, err = b.Read(u[:4]); err != nil {
+		err = fmt.Errorf("pkt too short for uint32: need 4, have %d", b.Len())
+	return
+	}
+	D.Mode = uint32(u[0])
+	D.Mode |= uint32(u[1])<<8
+	D.Mode |= uint32(u[2])<<16
+	D.Mode |= uint32(u[3])<<24
+	if _, err = b.Read(u[:4]); err != nil {
+		err = fmt.Errorf("pkt too short for uint32: need 4, have %d", b.Len())
+	return
+	}
+	D.Atime = uint32(u[0])
+	D.Atime |= uint32(u[1])<<8
+	D.Atime |= uint32(u[2])<<16
+	D.Atime |= uint32(u[3])<<24
+	if _, err = b.Read(u[:4]); err != nil {
+		err = fmt.Errorf("pkt too short for uint32: need 4, have %d", b.Len())
+	return
+	}
+	D.Mtime = uint32(u[0])
+	D.Mtime |= uint32(u[1])<<8
+	D.Mtime |= uint32(u[2])<<16
+	D.Mtime |= uint32(u[3])<<24
+	if _, err = b.Read(u[:8]); err != nil {
+		err = fmt.Errorf("pkt too short for uint64: need 8, have %d", b.Len())
+	return
+	}
+	D.Length = uint64(u[0])
+	D.Length |= uint64(u[1])<<8
+	D.Length |= uint64(u[2])<<16
+	D.Length |= uint64(u[3])<<24
+	D.Length |= uint64(u[4])<<32
+	D.Length |= uint64(u[5])<<40
+	D.Length |= uint64(u[6])<<48
+	D.Length |= uint64(u[7])<<56
+	if _, err = b.Read(u[:2]); err != nil {
+		err = fmt.Errorf("pkt too short for uint16: need 2, have %d", b.Len())
+	return
+	}
+	l = uint64(u[0])
+	l |= uint64(u[1])<<8
+	if b.Len() < int(l) {
+		err = fmt.Errorf("pkt too short for string: need %d, have %d", l, b.Len())
+	return
+	}
+	D.Name = string(b.Bytes()[:l])
+	_ = b.Next(int(l))
+	if _, err = b.Read(u[:2]); err != nil {
+		err = fmt.Errorf("pkt too short for uint16: need 2, have %d", b.Len())
+	return
+	}
+	l = uint64(u[0])
+	l |= uint64(u[1])<<8
+	if b.Len() < int(l) {
+		err = fmt.Errorf("pkt too short for string: need %d, have %d", l, b.Len())
+	return
+	}
+	D.User = string(b.Bytes()[:l])
+	_ = b.Next(int(l))
+	if _, err = b.Read(u[:2]); err != nil {
+		err = fmt.Errorf("pkt too short for uint16: need 2, have %d", b.Len())
+	return
+	}
+	l = uint64(u[0])
+	l |= uint64(u[1])<<8
+	if b.Len() < int(l) {
+		err = fmt.Errorf("pkt too short for string: need %d, have %d", l, b.Len())
+	return
+	}
+	D.Group = string(b.Bytes()[:l])
+	_ = b.Next(int(l))
+	if _, err = b.Read(u[:2]); err != nil {
+		err = fmt.Errorf("pkt too short for uint16: need 2, have %d", b.Len())
+	return
+	}
+	l = uint64(u[0])
+	l |= uint64(u[1])<<8
+	if b.Len() < int(l) {
+		err = fmt.Errorf("pkt too short for string: need %d, have %d", l, b.Len())
+	return
+	}
+	D.ModUser = string(b.Bytes()[:l])
+	_ = b.Next(int(l))
+
+return
+}
+func MarshaldirDotu (b *bytes.Buffer, D Dir, Extension string, NUid uint32, NGid uint32, NMuid uint32) {
+var l uint64
+b.Reset()
+b.Write([]byte{0,0,})
+	b.Write([]byte{	uint8(D.Type>>0),
+	uint8(D.Type>>8),
+	uint8(D.Dev>>0),
+	uint8(D.Dev>>8),
+	uint8(D.Dev>>16),
+	uint8(D.Dev>>24),
+	uint8(D.QID.Type>>0),
+	uint8(D.QID.Version>>0),
+	uint8(D.QID.Version>>8),
+	uint8(D.QID.Version>>16),
+	uint8(D.QID.Version>>24),
+	uint8(D.QID.Path>>0),
+	uint8(D.QID.Path>>8),
+	uint8(D.QID.Path>>16),
+	uint8(D.QID.Path>>24),
+	uint8(D.QID.Path>>32),
+	uint8(D.QID.Path>>40),
+	uint8(D.QID.Path>>48),
+	uint8(D.QID.Path>>56),
+	uint8(D.Mode>>0),
+	uint8(D.Mode>>8),
+	uint8(D.Mode>>16),
+	uint8(D.Mode>>24),
+	uint8(D.Atime>>0),
+	uint8(D.Atime>>8),
+	uint8(D.Atime>>16),
+	uint8(D.Atime>>24),
+	uint8(D.Mtime>>0),
+	uint8(D.Mtime>>8),
+	uint8(D.Mtime>>16),
+	uint8(D.Mtime>>24),
+	uint8(D.Length>>0),
+	uint8(D.Length>>8),
+	uint8(D.Length>>16),
+	uint8(D.Length>>24),
+	uint8(D.Length>>32),
+	uint8(D.Length>>40),
+	uint8(D.Length>>48),
+	uint8(D.Length>>56),
+	uint8(len(D.Name)),uint8(len(D.Name)>>8),
+	})
+	b.Write([]byte(D.Name))
+	b.Write([]byte{	uint8(len(D.User)),uint8(len(D.User)>>8),
+	})
+	b.Write([]byte(D.User))
+	b.Write([]byte{	uint8(len(D.Group)),uint8(len(D.Group)>>8),
+	})
+	b.Write([]byte(D.Group))
+	b.Write([]byte{	uint8(len(D.ModUser)),uint8(len(D.ModUser)>>8),
+	})
+	b.Write([]byte(D.ModUser))
+	b.Write([]byte{	uint8(len(Extension)),uint8(len(Extension)>>8),
+	})
+	b.Write([]byte(Extension))
+	b.Write([]byte{	uint8(NUid>>0),
+	uint8(NUid>>8),
+	uint8(NUid>>16),
+	uint8(NUid>>24),
+	uint8(NGid>>0),
+	uint8(NGid>>8),
+	uint8(NGid>>16),
+	uint8(NGid>>24),
+	uint8(NMuid>>0),
+	uint8(NMuid>>8),
+	uint8(NMuid>>16),
+	uint8(NMuid>>24),
+	})
+
+l = uint64(b.Len()) - 2
+copy(b.Bytes(), []byte{uint8(l), uint8(l>>8)})
+return
+}
+func UnmarshaldirDotu (b *bytes.Buffer) (D Dir, Extension string, NUid uint32, NGid uint32, NMuid uint32,  err error) {
+var u [8]uint8
+var l uint64
+_ = b.Next(2) // eat the length too
+	if _, err = b.Read(u[:2]); err != nil {
+		err = fmt.Errorf("pkt too short for uint16: need 2, have %d", b.Len())
+	return
+	}
+	D.Type = uint16(u[0])
+	D.Type |= uint16(u[1])<<8
+	if _, err = b.Read(u[:4]); err != nil {
+		err = fmt.Errorf("pkt too short for uint32: need 4, have %d", b.Len())
+	return
+	}
+	D.Dev = uint32(u[0])
+	D.Dev |= uint32(u[1])<<8
+	D.Dev |= uint32(u[2])<<16
+	D.Dev |= uint32(u[3])<<24
+	if _, err = b.Read(u[:1]); err != nil {
+		err = fmt.Errorf("pkt too short for uint8: need 1, have %d", b.Len())
+	return
+	}
+	D.QID.Type = uint8(u[0])
+	if _, err = b.Read(u[:4]); err != nil {
+		err = fmt.Errorf("pkt too short for uint32: need 4, have %d", b.Len())
+	return
+	}
+	D.QID.Version = uint32(u[0])
+	D.QID.Version |= uint32(u[1])<<8
+	D.QID.Version |= uint32(u[2])<<16
+	D.QID.Version |= uint32(u[3])<<24
+	if _, err = b.Read(u[:8]); err != nil {
+		err = fmt.Errorf("pkt too short for uint64: need 8, have %d", b.Len())
+	return
+	}
+	D.QID.Path = uint64(u[0])
+	D.QID.Path |= uint64(u[1])<<8
+	D.QID.Path |= uint64(u[2])<<16
+	D.QID.Path |= uint64(u[3])<<24
+	D.QID.Path |= uint64(u[4])<<32
+	D.QID.Path |= uint64(u[5])<<40
+	D.QID.Path |= uint64(u[6])<<48
+	D.QID.Path |= uint64(u[7])<<56
+	if _, err = b.Read(u[:4]); err != nil {
+		err = fmt.Errorf("pkt too short for uint32: need 4, have %d", b.Len())
+	return
+	}
+	D.Mode = uint32(u[0])
+	D.Mode |= uint32(u[1])<<8
+	D.Mode |= uint32(u[2])<<16
+	D.Mode |= uint32(u[3])<<24
+	if _, err = b.Read(u[:4]); err != nil {
+		err = fmt.Errorf("pkt too short for uint32: need 4, have %d", b.Len())
+	return
+	}
+	D.Atime = uint32(u[0])
+	D.Atime |= uint32(u[1])<<8
+	D.Atime |= uint32(u[2])<<16
+	D.Atime |= uint32(u[3])<<24
+	if _, err = b.Read(u[:4]); err != nil {
+		err = fmt.Errorf("pkt too short for uint32: need 4, have %d", b.Len())
+	return
+	}
+	D.Mtime = uint32(u[0])
+	D.Mtime |= uint32(u[1])<<8
+	D.Mtime |= uint32(u[2])<<16
+	D.Mtime |= uint32(u[3])<<24
+	if _, err = b.Read(u[:8]); err != nil {
+		err = fmt.Errorf("pkt too short for uint64: need 8, have %d", b.Len())
+	return
+	}
+	D.Length = uint64(u[0])
+	D.Length |= uint64(u[1])<<8
+	D.Length |= uint64(u[2])<<16
+	D.Length |= uint64(u[3])<<24
+	D.Length |= uint64(u[4])<<32
+	D.Length |= uint64(u[5])<<40
+	D.Length |= uint64(u[6])<<48
+	D.Length |= uint64(u[7])<<56
+	if _, err = b.Read(u[:2]); err != nil {
+		err = fmt.Errorf("pkt too short for uint16: need 2, have %d", b.Len())
+	return
+	}
+	l = uint64(u[0])
+	l |= uint64(u[1])<<8
+	if b.Len() < int(l) {
+		err = fmt.Errorf("pkt too short for string: need %d, have %d", l, b.Len())
+	return
+	}
+	D.Name = string(b.Bytes()[:l])
+	_ = b.Next(int(l))
+	if _, err = b.Read(u[:2]); err != nil {
+		err = fmt.Errorf("pkt too short for uint16: need 2, have %d", b.Len())
+	return
+	}
+	l = uint64(u[0])
+	l |= uint64(u[1])<<8
+	if b.Len() < int(l) {
+		err = fmt.Errorf("pkt too short for string: need %d, have %d", l, b.Len())
+	return
+	}
+	D.User = string(b.Bytes()[:l])
+	_ = b.Next(int(l))
+	if _, err = b.Read(u[:2]); err != nil {
+		err = fmt.Errorf("pkt too short for uint16: need 2, have %d", b.Len())
+	return
+	}
+	l = uint64(u[0])
+	l |= uint64(u[1])<<8
+	if b.Len() < int(l) {
+		err = fmt.Errorf("pkt too short for string: need %d, have %d", l, b.Len())
 	return
 	}
 	D.Group = string(b.Bytes()[:l])
@@ -1930,6 +4238,42 @@ _ = b.Next(2) // eat the length too
 	}
 	D.ModUser = string(b.Bytes()[:l])
 	_ = b.Next(int(l))
+	if _, err = b.Read(u[:2]); err != nil {
+		err = fmt.Errorf("pkt too short for uint16: need 2, have %d", b.Len())
+	return
+	}
+	l = uint64(u[0])
+	l |= uint64(u[1])<<8
+	if b.Len() < int(l) {
+		err = fmt.Errorf("pkt too short for string: need %d, have %d", l, b.Len())
+	return
+	}
+	Extension = string(b.Bytes()[:l])
+	_ = b.Next(int(l))
+	if _, err = b.Read(u[:4]); err != nil {
+		err = fmt.Errorf("pkt too short for uint32: need 4, have %d", b.Len())
+	return
+	}
+	NUid = uint32(u[0])
+	NUid |= uint32(u[1])<<8
+	NUid |= uint32(u[2])<<16
+	NUid |= uint32(u[3])<<24
+	if _, err = b.Read(u[:4]); err != nil {
+		err = fmt.Errorf("pkt too short for uint32: need 4, have %d", b.Len())
+	return
+	}
+	NGid = uint32(u[0])
+	NGid |= uint32(u[1])<<8
+	NGid |= uint32(u[2])<<16
+	NGid |= uint32(u[3])<<24
+	if _, err = b.Read(u[:4]); err != nil {
+		err = fmt.Errorf("pkt too short for uint32: need 4, have %d", b.Len())
+	return
+	}
+	NMuid = uint32(u[0])
+	NMuid |= uint32(u[1])<<8
+	NMuid |= uint32(u[2])<<16
+	NMuid |= uint32(u[3])<<24
 
 return
 }
@@ -0,0 +1,243 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"sync"
+)
+
+// JournalOpType is the kind of change a JournalOp makes.
+type JournalOpType uint8
+
+const (
+	JournalWrite JournalOpType = iota
+	JournalCreate
+	JournalRemove
+	JournalWstat
+)
+
+var journalOpNames = map[JournalOpType]string{
+	JournalWrite:  "write",
+	JournalCreate: "create",
+	JournalRemove: "remove",
+	JournalWstat:  "wstat",
+}
+
+func (t JournalOpType) String() string {
+	if n, ok := journalOpNames[t]; ok {
+		return n
+	}
+	return fmt.Sprintf("op%d", t)
+}
+
+// A JournalOp is a change to a file, recorded in a Journal.
+type JournalOp struct {
+	Type JournalOpType
+
+	// Names is the path of the file, from the fid given to Replay.
+	Names []string
+
+	// QID is the file as it was last seen on the server, for the
+	// first change to a file. Later changes to it depend on that one.
+	QID QID
+
+	// Offset and Data are what a JournalWrite writes.
+	Offset int64
+	Data   []byte
+
+	// Perm is the permissions a JournalCreate creates the file with.
+	Perm Perm
+
+	// Dir is the change a JournalWstat makes.
+	Dir Dir
+}
+
+func (o JournalOp) String() string {
+	return fmt.Sprintf("%v %v", o.Type, path.Join(o.Names...))
+}
+
+// A Journal records changes to the files of a server which can't be
+// reached, to be made by Replay once it can be, for simple programs
+// which can carry on without the server for a while. Changes are made
+// by name, since fids do not outlive a connection.
+//
+// Each file's changes depend on the file being as it was when it was
+// last seen. If someone else has changed it since, which Replay finds
+// from the version of its QID, its changes are a conflict, and are not
+// made. Files are created only if they still don't exist.
+type Journal struct {
+	// mu guards below
+	mu  sync.Mutex
+	ops []JournalOp
+}
+
+func (j *Journal) add(o JournalOp) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	o.Names = append([]string(nil), o.Names...)
+	j.ops = append(j.ops, o)
+}
+
+// Write records a write of data at off to the file names, which was q
+// when last seen.
+func (j *Journal) Write(names []string, q QID, off int64, data []byte) {
+	j.add(JournalOp{Type: JournalWrite, Names: names, QID: q, Offset: off, Data: append([]byte(nil), data...)})
+}
+
+// Create records the creation of the file names with perm.
+func (j *Journal) Create(names []string, perm Perm) {
+	j.add(JournalOp{Type: JournalCreate, Names: names, Perm: perm})
+}
+
+// Remove records the removal of the file names, which was q when last
+// seen.
+func (j *Journal) Remove(names []string, q QID) {
+	j.add(JournalOp{Type: JournalRemove, Names: names, QID: q})
+}
+
+// Wstat records the change d to the file names, which was q when last
+// seen. As with Twstat, fields of d which are not to change must be set
+// to "don't touch" values.
+func (j *Journal) Wstat(names []string, q QID, d Dir) {
+	j.add(JournalOp{Type: JournalWstat, Names: names, QID: q, Dir: d})
+}
+
+// Ops returns the changes recorded, in order.
+func (j *Journal) Ops() []JournalOp {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return append([]JournalOp(nil), j.ops...)
+}
+
+// A JournalError is a change Replay did not make.
+type JournalError struct {
+	Op JournalOp
+
+	// QID is the file as it is on the server, if it exists.
+	QID QID
+
+	// Err is why: ErrJournalConflict if the file changed, or was
+	// created, on the server; ErrJournalSkipped if an earlier change
+	// to the file was not made; or the server's error.
+	Err error
+}
+
+var (
+	ErrJournalConflict = fmt.Errorf("file changed on the server")
+	ErrJournalSkipped  = fmt.Errorf("earlier change to the file not made")
+)
+
+func (e *JournalError) Error() string {
+	return fmt.Sprintf("%v: %v", e.Op, e.Err)
+}
+
+func (e *JournalError) Unwrap() error {
+	return e.Err
+}
+
+// Replay makes the changes recorded in the journal on the server of c,
+// finding files from fid, which is usually the fid of an attach. It
+// empties the journal, and returns the changes it did not make.
+func (j *Journal) Replay(c *Client, fid FID) []*JournalError {
+	j.mu.Lock()
+	ops := j.ops
+	j.ops = nil
+	j.mu.Unlock()
+
+	var errs []*JournalError
+	// ok records, for each file with a change made or not, whether
+	// later changes to it may be made.
+	ok := map[string]bool{}
+	for _, o := range ops {
+		name := path.Join(o.Names...)
+		made, seen := ok[name]
+		if seen && !made {
+			errs = append(errs, &JournalError{Op: o, Err: ErrJournalSkipped})
+			continue
+		}
+		var q QID
+		var err error
+		if !seen {
+			var exists bool
+			q, exists, err = journalStat(c, fid, o.Names)
+			switch {
+			case err != nil:
+			case o.Type == JournalCreate && exists:
+				err = ErrJournalConflict
+			case o.Type != JournalCreate && (!exists || q.Path != o.QID.Path || q.Version != o.QID.Version):
+				err = ErrJournalConflict
+			}
+		}
+		if err == nil {
+			err = o.apply(c, fid)
+		}
+		ok[name] = err == nil
+		if err != nil {
+			errs = append(errs, &JournalError{Op: o, QID: q, Err: err})
+		}
+	}
+	return errs
+}
+
+// journalStat returns the QID of the file names, if it exists.
+func journalStat(c *Client, fid FID, names []string) (QID, bool, error) {
+	w, err := c.Walk(fid, names)
+	if err != nil {
+		// Not being able to walk there is not existing.
+		return QID{}, false, nil
+	}
+	defer c.CallTclunk(w.FID)
+	st, err := c.CallTstat(w.FID)
+	if err != nil {
+		return QID{}, false, err
+	}
+	d, err := Unmarshaldir(bytes.NewBuffer(st))
+	if err != nil {
+		return QID{}, false, err
+	}
+	return d.QID, true, nil
+}
+
+// apply makes the change o.
+func (o JournalOp) apply(c *Client, fid FID) error {
+	switch o.Type {
+	case JournalWrite:
+		f, err := c.Open(fid, o.Names, OWRITE)
+		if err != nil {
+			return err
+		}
+		_, err = f.WriteAt(o.Data, o.Offset)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		return err
+	case JournalCreate:
+		f, err := c.Create(fid, o.Names, o.Perm, OREAD)
+		if err != nil {
+			return err
+		}
+		return f.Close()
+	}
+
+	w, err := c.Walk(fid, o.Names)
+	if err != nil {
+		return err
+	}
+	f := w.FID
+	switch o.Type {
+	case JournalRemove:
+		return c.CallTremove(f)
+	case JournalWstat:
+		defer c.CallTclunk(f)
+		var b bytes.Buffer
+		Marshaldir(&b, o.Dir)
+		return c.CallTwstat(f, b.Bytes())
+	}
+	c.CallTclunk(f)
+	return fmt.Errorf("%v: unknown journal op", o)
+}
@@ -0,0 +1,69 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestJournal(t *testing.T) {
+	c, ds := newDialectClient(t, "9P2000")
+	if _, err := c.CallTattach(0, NOFID, "glenda", ""); err != nil {
+		t.Fatalf("CallTattach: want nil, got %v", err)
+	}
+	a := ds.files["a"].QID
+
+	// While the server is away, a is written and b and c created,
+	// and meanwhile someone else creates c.
+	var j Journal
+	j.Write([]string{"a"}, a, 5, []byte(", world"))
+	j.Write([]string{"a"}, a, 0, []byte("H"))
+	j.Create([]string{"b"}, 0644)
+	j.Write([]string{"b"}, QID{}, 0, []byte("new"))
+	j.Create([]string{"c"}, 0644)
+	j.Write([]string{"c"}, QID{}, 0, []byte("mine"))
+	ds.files["c"] = &Dir{QID: QID{Path: 99}, Mode: 0644, Name: "c"}
+	if n := len(j.Ops()); n != 6 {
+		t.Fatalf("Ops: got %d, want 6", n)
+	}
+
+	errs := j.Replay(c, 0)
+	if len(errs) != 2 {
+		t.Fatalf("Replay: got %v, want conflicts for c", errs)
+	}
+	if !errors.Is(errs[0], ErrJournalConflict) || errs[0].Op.Type != JournalCreate || errs[0].QID.Path != 99 {
+		t.Errorf("Replay: got %v, want the create of c to conflict", errs[0])
+	}
+	if !errors.Is(errs[1], ErrJournalSkipped) {
+		t.Errorf("Replay: got %v, want the write of c skipped", errs[1])
+	}
+	if got := string(ds.data["a"]); got != "Hello, world" {
+		t.Errorf("a: got %q, want %q", got, "Hello, world")
+	}
+	if got := string(ds.data["b"]); got != "new" {
+		t.Errorf("b: got %q, want %q", got, "new")
+	}
+	if got := string(ds.data["c"]); got != "" {
+		t.Errorf("c: got %q, want nothing", got)
+	}
+	if n := len(j.Ops()); n != 0 {
+		t.Errorf("Ops after Replay: got %d, want 0", n)
+	}
+
+	// a has been written since, so an old change to it conflicts.
+	j.Write([]string{"a"}, a, 0, []byte("J"))
+	j.Remove([]string{"b"}, ds.files["b"].QID)
+	errs = j.Replay(c, 0)
+	if len(errs) != 1 || !errors.Is(errs[0], ErrJournalConflict) || errs[0].Op.Type != JournalWrite {
+		t.Fatalf("Replay: got %v, want the write of a to conflict", errs)
+	}
+	if got := string(ds.data["a"]); got != "Hello, world" {
+		t.Errorf("a: got %q, want %q", got, "Hello, world")
+	}
+	if _, ok := ds.files["b"]; ok {
+		t.Errorf("b: not removed")
+	}
+}
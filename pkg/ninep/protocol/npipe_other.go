@@ -0,0 +1,22 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !windows
+
+package protocol
+
+import (
+	"fmt"
+	"net"
+)
+
+// DialPipe connects to a Windows named pipe, which only Windows has.
+func DialPipe(name string) (net.Conn, error) {
+	return nil, fmt.Errorf("%v: named pipes are only on Windows", name)
+}
+
+// ListenPipe creates a Windows named pipe, which only Windows has.
+func ListenPipe(name string) (net.Listener, error) {
+	return nil, fmt.Errorf("%v: named pipes are only on Windows", name)
+}
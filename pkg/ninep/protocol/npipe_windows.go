@@ -0,0 +1,323 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build windows
+
+package protocol
+
+import (
+	"io"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+var (
+	kernel32                = syscall.NewLazyDLL("kernel32.dll")
+	procCreateNamedPipeW    = kernel32.NewProc("CreateNamedPipeW")
+	procConnectNamedPipe    = kernel32.NewProc("ConnectNamedPipe")
+	procWaitNamedPipeW      = kernel32.NewProc("WaitNamedPipeW")
+	procCreateEventW        = kernel32.NewProc("CreateEventW")
+	procGetOverlappedResult = kernel32.NewProc("GetOverlappedResult")
+)
+
+const (
+	pipeAccessDuplex       = 0x3
+	pipeTypeByte           = 0x0
+	fileFlagFirstInstance  = 0x80000
+	pipeUnlimitedInstances = 255
+	pipeBufferSize         = 64 * 1024
+	pipeBusyWait           = 5000 // milliseconds
+	errorInvalidHandle     = syscall.Errno(6)
+	errorPipeBusy          = syscall.Errno(231)
+	errorNoData            = syscall.Errno(232)
+	errorPipeNotConnected  = syscall.Errno(233)
+	errorPipeConnected     = syscall.Errno(535)
+)
+
+// pipeAddr is the name of a pipe, e.g. \\.\pipe\ufs.
+type pipeAddr string
+
+func (a pipeAddr) Network() string { return "pipe" }
+func (a pipeAddr) String() string  { return string(a) }
+
+// overlapped starts the I/O f on h, and waits for it to finish, or for
+// deadline, if it is set, after which the I/O is cancelled. The handles
+// are opened for overlapped I/O since a read on a synchronous handle
+// holds up any write until it is done, and a 9P connection is always
+// waiting for a read.
+func overlapped(h syscall.Handle, deadline time.Time, f func(*syscall.Overlapped) error) (uint32, error) {
+	ev, _, err := procCreateEventW.Call(0, 1, 0, 0)
+	if ev == 0 {
+		return 0, err
+	}
+	defer syscall.CloseHandle(syscall.Handle(ev))
+	o := &syscall.Overlapped{HEvent: syscall.Handle(ev)}
+	if err := f(o); err != nil && err != syscall.ERROR_IO_PENDING {
+		return 0, err
+	}
+	timedOut := false
+	if !deadline.IsZero() {
+		ms := time.Until(deadline) / time.Millisecond
+		if ms < 0 {
+			ms = 0
+		}
+		if e, _ := syscall.WaitForSingleObject(syscall.Handle(ev), uint32(ms)); e == syscall.WAIT_TIMEOUT {
+			syscall.CancelIoEx(h, o)
+			timedOut = true
+		}
+	}
+	var n uint32
+	r, _, err := procGetOverlappedResult.Call(uintptr(h), uintptr(unsafe.Pointer(o)), uintptr(unsafe.Pointer(&n)), 1)
+	switch {
+	case timedOut && err == syscall.ERROR_OPERATION_ABORTED:
+		return n, os.ErrDeadlineExceeded
+	case r == 0:
+		return n, err
+	}
+	return n, nil
+}
+
+// pipeConn is one end of a connected pipe.
+type pipeConn struct {
+	h    syscall.Handle
+	addr pipeAddr
+
+	// mu guards below
+	mu     sync.Mutex
+	rd, wd time.Time
+	closed bool
+}
+
+func (c *pipeConn) deadlines() (time.Time, time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rd, c.wd
+}
+
+func (c *pipeConn) Read(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	rd, _ := c.deadlines()
+	n, err := overlapped(c.h, rd, func(o *syscall.Overlapped) error {
+		var done uint32
+		return syscall.ReadFile(c.h, b, &done, o)
+	})
+	switch err {
+	case nil:
+		return int(n), nil
+	case syscall.ERROR_BROKEN_PIPE, errorPipeNotConnected:
+		return int(n), io.EOF
+	case syscall.ERROR_OPERATION_ABORTED, errorInvalidHandle:
+		return int(n), net.ErrClosed
+	}
+	return int(n), &net.OpError{Op: "read", Net: "pipe", Addr: c.addr, Err: err}
+}
+
+func (c *pipeConn) Write(b []byte) (int, error) {
+	_, wd := c.deadlines()
+	var tot int
+	for tot < len(b) {
+		n, err := overlapped(c.h, wd, func(o *syscall.Overlapped) error {
+			var done uint32
+			return syscall.WriteFile(c.h, b[tot:], &done, o)
+		})
+		tot += int(n)
+		switch err {
+		case nil:
+			continue
+		case syscall.ERROR_OPERATION_ABORTED, errorInvalidHandle:
+			return tot, net.ErrClosed
+		case syscall.ERROR_BROKEN_PIPE, errorNoData:
+			err = io.ErrClosedPipe
+		}
+		return tot, &net.OpError{Op: "write", Net: "pipe", Addr: c.addr, Err: err}
+	}
+	return tot, nil
+}
+
+// Close closes the pipe, cancelling any read or write in progress.
+func (c *pipeConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return net.ErrClosed
+	}
+	c.closed = true
+	syscall.CancelIoEx(c.h, nil)
+	return syscall.CloseHandle(c.h)
+}
+
+func (c *pipeConn) LocalAddr() net.Addr  { return c.addr }
+func (c *pipeConn) RemoteAddr() net.Addr { return c.addr }
+
+func (c *pipeConn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rd, c.wd = t, t
+	return nil
+}
+
+func (c *pipeConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rd = t
+	return nil
+}
+
+func (c *pipeConn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.wd = t
+	return nil
+}
+
+// DialPipe connects to the named pipe name, e.g. \\.\pipe\ufs, waiting
+// a while for an instance if they are all busy.
+func DialPipe(name string) (net.Conn, error) {
+	p, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		h, err := syscall.CreateFile(p, syscall.GENERIC_READ|syscall.GENERIC_WRITE, 0, nil, syscall.OPEN_EXISTING, syscall.FILE_FLAG_OVERLAPPED, 0)
+		if err == nil {
+			return &pipeConn{h: h, addr: pipeAddr(name)}, nil
+		}
+		if err != errorPipeBusy {
+			return nil, &net.OpError{Op: "dial", Net: "pipe", Addr: pipeAddr(name), Err: err}
+		}
+		if r, _, err := procWaitNamedPipeW.Call(uintptr(unsafe.Pointer(p)), pipeBusyWait); r == 0 {
+			return nil, &net.OpError{Op: "dial", Net: "pipe", Addr: pipeAddr(name), Err: err}
+		}
+	}
+}
+
+// pipeListener makes an instance of a named pipe for each client. There
+// is always one waiting, so that the name does not go away between
+// clients.
+type pipeListener struct {
+	name pipeAddr
+
+	// mu guards below
+	mu sync.Mutex
+	// h is the instance waiting for the next client.
+	h         syscall.Handle
+	accepting bool
+	closed    bool
+}
+
+// ListenPipe creates the named pipe name, e.g. \\.\pipe\ufs, which is an
+// error if it already exists.
+func ListenPipe(name string) (net.Listener, error) {
+	l := &pipeListener{name: pipeAddr(name)}
+	h, err := l.instance(true)
+	if err != nil {
+		return nil, &net.OpError{Op: "listen", Net: "pipe", Addr: l.name, Err: err}
+	}
+	l.h = h
+	return l, nil
+}
+
+// instance creates an instance of the pipe.
+func (l *pipeListener) instance(first bool) (syscall.Handle, error) {
+	p, err := syscall.UTF16PtrFromString(string(l.name))
+	if err != nil {
+		return syscall.InvalidHandle, err
+	}
+	mode := uintptr(pipeAccessDuplex | syscall.FILE_FLAG_OVERLAPPED)
+	if first {
+		mode |= fileFlagFirstInstance
+	}
+	h, _, err := procCreateNamedPipeW.Call(uintptr(unsafe.Pointer(p)), mode, pipeTypeByte,
+		pipeUnlimitedInstances, pipeBufferSize, pipeBufferSize, 0, 0)
+	if syscall.Handle(h) == syscall.InvalidHandle {
+		return syscall.InvalidHandle, err
+	}
+	return syscall.Handle(h), nil
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	for {
+		l.mu.Lock()
+		if l.closed {
+			l.mu.Unlock()
+			return nil, net.ErrClosed
+		}
+		h := l.h
+		l.accepting = true
+		l.mu.Unlock()
+
+		_, err := overlapped(h, time.Time{}, func(o *syscall.Overlapped) error {
+			if r, _, err := procConnectNamedPipe.Call(uintptr(h), uintptr(unsafe.Pointer(o))); r == 0 {
+				return err
+			}
+			return nil
+		})
+		if err == errorPipeConnected {
+			err = nil
+		}
+		c, err := l.accepted(h, err)
+		// A client which came and went before we got to it leaves
+		// the instance unusable, but is no reason to stop.
+		if err == errorNoData {
+			continue
+		}
+		return c, err
+	}
+}
+
+// accepted finishes an Accept on the instance h, which ended with err,
+// and makes a new instance to wait for the next client.
+func (l *pipeListener) accepted(h syscall.Handle, err error) (net.Conn, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.accepting = false
+	if l.closed {
+		syscall.CloseHandle(h)
+		return nil, net.ErrClosed
+	}
+	next, nerr := l.instance(false)
+	if nerr != nil {
+		// Keep h, so that the name stays.
+		if err == nil {
+			syscall.CloseHandle(h)
+		}
+		return nil, &net.OpError{Op: "accept", Net: "pipe", Addr: l.name, Err: nerr}
+	}
+	l.h = next
+	if err != nil {
+		syscall.CloseHandle(h)
+		if err == errorNoData {
+			return nil, err
+		}
+		return nil, &net.OpError{Op: "accept", Net: "pipe", Addr: l.name, Err: err}
+	}
+	return &pipeConn{h: h, addr: l.name}, nil
+}
+
+// Close removes the pipe's name, and ends any Accept. Connected clients
+// are not affected.
+func (l *pipeListener) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return net.ErrClosed
+	}
+	l.closed = true
+	if l.accepting {
+		// Accept closes it once the cancel gets to it.
+		syscall.CancelIoEx(l.h, nil)
+		return nil
+	}
+	return syscall.CloseHandle(l.h)
+}
+
+func (l *pipeListener) Addr() net.Addr {
+	return l.name
+}
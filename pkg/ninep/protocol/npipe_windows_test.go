@@ -0,0 +1,63 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build windows
+
+package protocol
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestNamedPipe(t *testing.T) {
+	name := fmt.Sprintf(`\\.\pipe\ninep-test-%d`, os.Getpid())
+	ln, err := ListenPipe(name)
+	if err != nil {
+		t.Fatalf("ListenPipe: want nil, got %v", err)
+	}
+	if _, err := ListenPipe(name); err == nil {
+		t.Errorf("ListenPipe of an existing pipe: want err, got nil")
+	}
+	s, err := NewListener(func() NineServer { return newDirServer() })
+	if err != nil {
+		t.Fatalf("NewListener: want nil, got %v", err)
+	}
+	go s.Serve(ln)
+	defer s.Shutdown()
+
+	// Each client gets an instance of its own.
+	for i := 0; i < 3; i++ {
+		conn, err := DialPipe(name)
+		if err != nil {
+			t.Fatalf("DialPipe: want nil, got %v", err)
+		}
+		c, err := NewClient(func(c *Client) error {
+			c.FromNet, c.ToNet = conn, conn
+			c.Msize = 8192
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("NewClient: want nil, got %v", err)
+		}
+		if _, v, err := c.CallTversion(8192, "9P2000"); err != nil || v != "9P2000" {
+			t.Fatalf("client %d: CallTversion: got %q, %v, want 9P2000, nil", i, v, err)
+		}
+		if _, err := c.CallTattach(0, NOFID, "glenda", ""); err != nil {
+			t.Errorf("client %d: CallTattach: want nil, got %v", i, err)
+		}
+	}
+
+	conn, err := DialPipe(name)
+	if err != nil {
+		t.Fatalf("DialPipe: want nil, got %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, err := conn.Read(make([]byte, 1)); !os.IsTimeout(err) {
+		t.Errorf("Read past the deadline: want timeout, got %v", err)
+	}
+}
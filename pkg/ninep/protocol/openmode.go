@@ -0,0 +1,142 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"io/fs"
+	"os"
+)
+
+// These are the conversions between 9P open modes and permissions, and
+// the os package's flags and modes, and Linux's open flags for 9P2000.L.
+// Anything converting between them should use these.
+
+// Linux open flags, as used in 9P2000.L. They are not the os package's
+// flags, which differ between systems.
+const (
+	lOTRUNC  = 01000
+	lOAPPEND = 02000
+)
+
+// FlagsToMode converts os.OpenFile flags to a 9P open mode. 9P creates
+// files with Tcreate, not with a mode bit, and Tcreate fails if the file
+// exists, so FlagsToMode returns os.O_CREATE and os.O_EXCL separately:
+// with create, a file that does not exist should be made with Tcreate;
+// with excl too, it should be made with Tcreate whether or not it exists.
+func FlagsToMode(flag int) (m Mode, create, excl bool) {
+	switch {
+	case flag&os.O_RDWR != 0:
+		m = ORDWR
+	case flag&os.O_WRONLY != 0:
+		m = OWRITE
+	default:
+		m = OREAD
+	}
+	if flag&os.O_TRUNC != 0 {
+		m |= OTRUNC
+	}
+	if flag&os.O_APPEND != 0 {
+		m |= OAPPEND
+	}
+	return m, flag&os.O_CREATE != 0, flag&os.O_EXCL != 0
+}
+
+// Writes reports whether a file opened with mode m can be changed
+// through the fid: it is opened to write, or truncated, or removed when
+// the fid is clunked. Read-only servers refuse such opens.
+func (m Mode) Writes() bool {
+	return m&3 == OWRITE || m&3 == ORDWR || m&(OTRUNC|ORCLOSE) != 0
+}
+
+// ModeToFlags converts a 9P open mode to os.OpenFile flags. The bits
+// with no os flag, such as ORCLOSE, are for the caller to deal with.
+func ModeToFlags(m Mode) int {
+	var flag int
+	switch m & 3 {
+	case OWRITE:
+		flag = os.O_WRONLY
+	case ORDWR:
+		flag = os.O_RDWR
+	default:
+		// OREAD, and OEXEC, which is a read with a different
+		// permission check.
+		flag = os.O_RDONLY
+	}
+	if m&OTRUNC != 0 {
+		flag |= os.O_TRUNC
+	}
+	if m&OAPPEND != 0 {
+		flag |= os.O_APPEND
+	}
+	return flag
+}
+
+// LinuxFlagsToMode converts 9P2000.L open flags to a 9P2000 mode.
+// The access mode bits are the same. Creation is a separate message in
+// both, so O_CREAT and O_EXCL are ignored.
+func LinuxFlagsToMode(f uint32) Mode {
+	m := Mode(f & 3)
+	if f&lOTRUNC != 0 {
+		m |= OTRUNC
+	}
+	if f&lOAPPEND != 0 {
+		m |= OAPPEND
+	}
+	return m
+}
+
+// ModeToLinuxFlags converts a 9P2000 mode to 9P2000.L open flags.
+func ModeToLinuxFlags(m Mode) uint32 {
+	f := uint32(m & 3)
+	if f == OEXEC {
+		f = OREAD
+	}
+	if m&OTRUNC != 0 {
+		f |= lOTRUNC
+	}
+	if m&OAPPEND != 0 {
+		f |= lOAPPEND
+	}
+	return f
+}
+
+// permBits pairs the 9P permission bits with the fs ones.
+var permBits = []struct {
+	p uint32
+	m fs.FileMode
+}{
+	{DMDIR, fs.ModeDir},
+	{DMAPPEND, fs.ModeAppend},
+	{DMEXCL, fs.ModeExclusive},
+	{DMTMP, fs.ModeTemporary},
+	{DMSYMLINK, fs.ModeSymlink},
+	{DMDEVICE, fs.ModeDevice},
+	{DMNAMEDPIPE, fs.ModeNamedPipe},
+	{DMSOCKET, fs.ModeSocket},
+	{DMSETUID, fs.ModeSetuid},
+	{DMSETGID, fs.ModeSetgid},
+}
+
+// PermToFileMode converts 9P permissions, as in Dir.Mode, to an fs.FileMode.
+func PermToFileMode(p uint32) fs.FileMode {
+	m := fs.FileMode(p & 0777)
+	for _, b := range permBits {
+		if p&b.p != 0 {
+			m |= b.m
+		}
+	}
+	return m
+}
+
+// FileModeToPerm converts an fs.FileMode to 9P permissions.
+func FileModeToPerm(m fs.FileMode) Perm {
+	p := uint32(m.Perm())
+	for _, b := range permBits {
+		if m&b.m != 0 {
+			p |= b.p
+		}
+	}
+	return Perm(p)
+}
@@ -0,0 +1,119 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"strconv"
+	"sync"
+)
+
+// CheckOwners has c check how its ClientFiles are used, to catch the
+// bugs which let two goroutines share one without locking, such as one
+// reading from a file as another seeks it, or closing it while another
+// reads, after which its fid, clunked, may be that of another file.
+// It is for debugging, as the race detector is, since it slows each
+// call down. CheckOwners must be called before c is used.
+//
+// Each ClientFile belongs to a goroutine: the one which opens it, to
+// start with. A goroutine which hands it to another, such as by sending
+// it down a channel, must Release it first, and the next to use it owns
+// it. It is misuse, reported to report, or a panic if report is nil,
+// for a goroutine to use a file another owns, or to use it after Close,
+// or to Close it while a call is in progress. ReadAt and WriteAt may be
+// called by any goroutine, and at once, as io.ReaderAt allows, since
+// they leave the file as it was.
+func (c *Client) CheckOwners(report func(error)) {
+	if report == nil {
+		report = func(err error) { panic(err) }
+	}
+	c.owners = report
+}
+
+// An owner is what CheckOwners knows of a ClientFile.
+type owner struct {
+	report func(error)
+
+	mu sync.Mutex
+	// g is the goroutine which owns the file, or 0 if none does.
+	g uint64
+	// busy counts the calls in progress.
+	busy   int
+	closed bool
+}
+
+// goid returns the ID of the calling goroutine, which Go only tells in
+// stack traces.
+func goid() uint64 {
+	var b [64]byte
+	s := bytes.TrimPrefix(b[:runtime.Stack(b[:], false)], []byte("goroutine "))
+	if i := bytes.IndexByte(s, ' '); i > 0 {
+		s = s[:i]
+	}
+	g, _ := strconv.ParseUint(string(s), 10, 64)
+	return g
+}
+
+// noUse is what use returns when the file is not checked.
+func noUse() {}
+
+// use checks that the call op may be made on f, by the calling
+// goroutine, which takes it if it is not owned, unless shared, as
+// ReadAt and WriteAt are. It returns a func to call when op is done.
+func (f *ClientFile) use(op string, shared bool) func() {
+	o := f.owner
+	if o == nil {
+		return noUse
+	}
+	g := goid()
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	switch {
+	case o.closed:
+		f.misuse("%v by goroutine %d after Close", op, g)
+	case shared:
+	case o.g == 0:
+		o.g = g
+	case o.g != g:
+		f.misuse("%v by goroutine %d, but goroutine %d owns it, and has not called Release", op, g, o.g)
+	}
+	if op == "Close" {
+		if o.busy > 0 {
+			f.misuse("Close by goroutine %d while %d calls are in progress", g, o.busy)
+		}
+		o.closed = true
+	}
+	o.busy++
+	return func() {
+		o.mu.Lock()
+		o.busy--
+		o.mu.Unlock()
+	}
+}
+
+// misuse reports misuse of f.
+func (f *ClientFile) misuse(format string, args ...interface{}) {
+	f.owner.report(fmt.Errorf("ClientFile fid %d: %v", f.fid, fmt.Sprintf(format, args...)))
+}
+
+// Release gives up the calling goroutine's ownership of f, so that
+// another can use it, when the Client checks owners. Otherwise it does
+// nothing.
+func (f *ClientFile) Release() {
+	o := f.owner
+	if o == nil {
+		return
+	}
+	g := goid()
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.g != 0 && o.g != g {
+		f.misuse("Release by goroutine %d, but goroutine %d owns it", g, o.g)
+		return
+	}
+	o.g = 0
+}
@@ -0,0 +1,103 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"io"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestCheckOwners(t *testing.T) {
+	c := newPackClient(t, "9P2000", newDirServer())
+	var mu sync.Mutex
+	var misuse []string
+	c.CheckOwners(func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		misuse = append(misuse, err.Error())
+	})
+	took := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		m := misuse
+		misuse = nil
+		return m
+	}
+	if _, err := c.CallTattach(0, NOFID, "glenda", ""); err != nil {
+		t.Fatalf("CallTattach: want nil, got %v", err)
+	}
+	f, err := c.Open(0, []string{"a"}, ORDWR)
+	if err != nil {
+		t.Fatalf("Open(a): want nil, got %v", err)
+	}
+	// in runs fn on another goroutine, and waits for it.
+	in := func(fn func()) {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			fn()
+		}()
+		<-done
+	}
+
+	if _, err := f.Write([]byte("x")); err != nil {
+		t.Fatalf("Write: want nil, got %v", err)
+	}
+	f.Seek(0, io.SeekStart)
+	f.Read(make([]byte, 1))
+	if m := took(); len(m) != 0 {
+		t.Errorf("use by the opener: got %q, want no misuse", m)
+	}
+
+	in(func() { f.Read(make([]byte, 1)) })
+	if m := took(); len(m) != 1 || !strings.Contains(m[0], "Read by goroutine") || !strings.Contains(m[0], "Release") {
+		t.Errorf("Read by another goroutine: got %q, want it reported", m)
+	}
+
+	// Handed over, and back.
+	f.Release()
+	in(func() {
+		f.Seek(0, io.SeekStart)
+		f.Read(make([]byte, 1))
+		f.Release()
+	})
+	f.Seek(0, io.SeekStart)
+	if m := took(); len(m) != 0 {
+		t.Errorf("use after Release: got %q, want no misuse", m)
+	}
+
+	// Anyone may ReadAt.
+	in(func() { f.ReadAt(make([]byte, 1), 0) })
+	if m := took(); len(m) != 0 {
+		t.Errorf("ReadAt by another goroutine: got %q, want no misuse", m)
+	}
+
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close: want nil, got %v", err)
+	}
+	f.ReadAt(make([]byte, 1), 0)
+	f.Close()
+	if m := took(); len(m) != 2 || !strings.Contains(m[0], "ReadAt by goroutine") || !strings.Contains(m[0], "after Close") || !strings.Contains(m[1], "Close") {
+		t.Errorf("use after Close: got %q, want ReadAt and Close reported", m)
+	}
+
+	// By default, misuse panics.
+	c.CheckOwners(nil)
+	g, err := c.Open(0, []string{"a"}, OREAD)
+	if err != nil {
+		t.Fatalf("Open(a): want nil, got %v", err)
+	}
+	var p interface{}
+	in(func() {
+		defer func() { p = recover() }()
+		g.Read(make([]byte, 1))
+	})
+	if p == nil {
+		t.Errorf("Read by another goroutine with CheckOwners(nil): want a panic, got none")
+	}
+	g.Close()
+}
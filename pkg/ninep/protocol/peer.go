@@ -0,0 +1,22 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+// PeerCred is who is at the other end of a Unix socket: the process,
+// and the user and group it ran as when it connected.
+type PeerCred struct {
+	PID int32
+	UID uint32
+	GID uint32
+}
+
+// A PeerServer is a NineServer which wants to know who is at the other
+// end of its connection. If the connection is a Unix socket, and the
+// system can say, SetPeer is called before any message arrives, so that
+// attaches can be checked against it, or a user be named after it,
+// without any other authentication.
+type PeerServer interface {
+	SetPeer(*PeerCred)
+}
@@ -0,0 +1,32 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build linux
+
+package protocol
+
+import (
+	"net"
+	"syscall"
+)
+
+// PeerCredentials returns the credentials of the process at the other
+// end of the Unix socket c, using SO_PEERCRED.
+func PeerCredentials(c *net.UnixConn) (*PeerCred, error) {
+	rc, err := c.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var u *syscall.Ucred
+	var uerr error
+	if err := rc.Control(func(fd uintptr) {
+		u, uerr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	}); err != nil {
+		return nil, err
+	}
+	if uerr != nil {
+		return nil, uerr
+	}
+	return &PeerCred{PID: u.Pid, UID: u.Uid, GID: u.Gid}, nil
+}
@@ -0,0 +1,18 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !linux
+
+package protocol
+
+import (
+	"fmt"
+	"net"
+)
+
+// PeerCredentials returns the credentials of the process at the other
+// end of the Unix socket c. Only Linux can say.
+func PeerCredentials(c *net.UnixConn) (*PeerCred, error) {
+	return nil, fmt.Errorf("peer credentials are not supported here")
+}
@@ -0,0 +1,16 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !minimal
+
+package protocol
+
+// Minimal is set by the minimal build tag, which leaves out what small
+// programs, such as those in firmware or u-root, can do without:
+// tracing, the counts of messages in Stats, and the 9P2000.u and
+// 9P2000.L dialects, so that servers speak only 9P2000. What it leaves
+// out is behind this constant, so the compiler drops the code, and the
+// allocations it made for each message. cmd/9pmini, a ramfs server and
+// client, is built with it, and its tests hold it to a size.
+const Minimal = false
@@ -0,0 +1,9 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build minimal
+
+package protocol
+
+const Minimal = true
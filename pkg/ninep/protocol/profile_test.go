@@ -0,0 +1,44 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import "testing"
+
+// skipMinimal skips a test of what, which the minimal build tag leaves
+// out.
+func skipMinimal(t *testing.T, what string) {
+	t.Helper()
+	if Minimal {
+		t.Skipf("no %v in the minimal build", what)
+	}
+}
+
+// TestAllocs holds a Tread, from client to server and back, to a budget
+// of allocations, which the minimal build tag makes smaller.
+func TestAllocs(t *testing.T) {
+	c := newPackClient(t, "9P2000", newDirServer())
+	if _, err := c.CallTattach(0, NOFID, "glenda", ""); err != nil {
+		t.Fatalf("CallTattach: want nil, got %v", err)
+	}
+	if _, err := c.CallTwalk(0, 1, []string{"a"}); err != nil {
+		t.Fatalf("CallTwalk(a): want nil, got %v", err)
+	}
+	if _, _, err := c.CallTopen(1, OREAD); err != nil {
+		t.Fatalf("CallTopen(a): want nil, got %v", err)
+	}
+	n := testing.AllocsPerRun(100, func() {
+		if _, err := c.CallTread(1, 0, 5); err != nil {
+			t.Fatalf("CallTread: want nil, got %v", err)
+		}
+	})
+	// Measured at 18, and 6 with the minimal tag.
+	budget := 24.0
+	if Minimal {
+		budget = 8
+	}
+	if n > budget {
+		t.Errorf("Tread: %v allocations, over the budget of %v", n, budget)
+	}
+}
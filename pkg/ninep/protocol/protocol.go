@@ -39,12 +39,58 @@ const (
 	Tlast
 )
 
+// 9P2000.L message types. These share the type space with 9P2000,
+// below the 9P2000 types.
 const (
-	MSIZE   = 2*1048576 + IOHDRSZ // default message size (1048576+IOHdrSz)
-	IOHDRSZ = 24                  // the non-data size of the Twrite messages
-	PORT    = 564                 // default port for 9P file servers
-	NumFID  = 1 << 16
-	QIDLen  = 13
+	Tlerror      MType = 6
+	Rlerror      MType = 7
+	Tstatfs      MType = 8
+	Rstatfs      MType = 9
+	Tlopen       MType = 12
+	Rlopen       MType = 13
+	Tlcreate     MType = 14
+	Rlcreate     MType = 15
+	Tsymlink     MType = 16
+	Rsymlink     MType = 17
+	Tmknod       MType = 18
+	Rmknod       MType = 19
+	Trename      MType = 20
+	Rrename      MType = 21
+	Treadlink    MType = 22
+	Rreadlink    MType = 23
+	Tgetattr     MType = 24
+	Rgetattr     MType = 25
+	Tsetattr     MType = 26
+	Rsetattr     MType = 27
+	Txattrwalk   MType = 30
+	Rxattrwalk   MType = 31
+	Txattrcreate MType = 32
+	Rxattrcreate MType = 33
+	Treaddir     MType = 40
+	Rreaddir     MType = 41
+	Tfsync       MType = 50
+	Rfsync       MType = 51
+	Tlock        MType = 52
+	Rlock        MType = 53
+	Tgetlock     MType = 54
+	Rgetlock     MType = 55
+	Tlink        MType = 70
+	Rlink        MType = 71
+	Tmkdir       MType = 72
+	Rmkdir       MType = 73
+	Trenameat    MType = 74
+	Rrenameat    MType = 75
+	Tunlinkat    MType = 76
+	Runlinkat    MType = 77
+)
+
+const (
+	MSIZE    = 2*1048576 + IOHDRSZ // default message size (1048576+IOHdrSz)
+	MaxMsize = 8*1048576 + IOHDRSZ // default largest msize a server agrees to
+	IOHDRSZ  = 24                  // the non-data size of the Twrite messages
+	PORT     = 564                 // default port for 9P file servers
+	NumFID   = 1 << 16
+	QIDLen   = 13
 )
 
 // QID types
@@ -86,6 +132,15 @@ const (
 	DMEXEC   = 0x1        // mode bit for execute permission
 )
 
+// Times in a Twstat. ^uint32(0), as for every other field, leaves the
+// time alone. TimeNow is this package's convention for asking the server
+// to use its own clock, for clients, like the kernel's, whose idea of now
+// may differ from the server's; a touch is a Twstat of Mtime TimeNow.
+const (
+	TimeNoChange = ^uint32(0)
+	TimeNow      = ^uint32(0) - 1
+)
+
 const (
 	NOTAG Tag = 0xFFFF     // no tag specified
 	NOFID FID = 0xFFFFFFFF // no fid specified
@@ -95,15 +150,36 @@ const (
 	NumTags = 1<<16 - 2
 )
 
-// Error values
+// Error values. These are the Linux numbers, which is what
+// 9P2000.u and 9P2000.L clients expect.
 const (
-	EPERM   = 1
-	ENOENT  = 2
-	EIO     = 5
-	EACCES  = 13
-	EEXIST  = 17
-	ENOTDIR = 20
-	EINVAL  = 22
+	EPERM     = 1
+	ENOENT    = 2
+	EIO       = 5
+	EBADF     = 9
+	EACCES    = 13
+	EEXIST    = 17
+	EXDEV     = 18
+	ENOTDIR   = 20
+	EISDIR    = 21
+	EINVAL    = 22
+	ENOSPC    = 28
+	EROFS     = 30
+	ENOTEMPTY = 39
+	ENOTSUP   = 95
+)
+
+// File modes for 9P2000.u
+const (
+	DMSYMLINK   = 0x02000000 // mode bit for symbolic links
+	DMDEVICE    = 0x00800000 // mode bit for device files
+	DMNAMEDPIPE = 0x00200000 // mode bit for named pipes
+	DMSOCKET    = 0x00100000 // mode bit for sockets
+	DMSETUID    = 0x00080000 // mode bit for setuid
+	DMSETGID    = 0x00040000 // mode bit for setgid
+
+	// NONUNAME is the numeric id used when there is none.
+	NONUNAME = 0xFFFFFFFF
 )
 
 // Types contained in 9p messages.
@@ -280,6 +356,187 @@ type DirPkt struct {
 	D Dir
 }
 
+// 9P2000.u adds numeric ids and an extension string to the end of a Dir.
+type DirDotuPkt struct {
+	D         Dir
+	Extension string
+	NUid      uint32
+	NGid      uint32
+	NMuid     uint32
+}
+
+// 9P2000.u packets which differ from their 9P2000 versions.
+// These are not part of NineServer; the 9P2000.u dialect translates
+// them to and from the 9P2000 calls.
+
+type TattachDotuPkt struct {
+	SFID   FID
+	AFID   FID
+	Uname  string
+	Aname  string
+	NUname uint32
+}
+
+type TcreateDotuPkt struct {
+	OFID       FID
+	Name       string
+	CreatePerm Perm
+	Omode      Mode
+	Extension  string
+}
+
+type RerrorDotuPkt struct {
+	Error string
+	Errno uint32
+}
+
+// 9P2000.L packets. Only the ones Linux needs to mount and do ordinary
+// file I/O are here. As with 9P2000.u, the 9P2000.L dialect translates
+// them to and from the 9P2000 calls.
+
+type RlerrorPkt struct {
+	Ecode uint32
+}
+
+type TstatfsPkt struct {
+	OFID FID
+}
+
+type RstatfsPkt struct {
+	FSType  uint32
+	BSize   uint32
+	Blocks  uint64
+	BFree   uint64
+	BAvail  uint64
+	Files   uint64
+	FFree   uint64
+	FSID    uint64
+	NameLen uint32
+}
+
+type TlopenPkt struct {
+	OFID   FID
+	LFlags uint32
+}
+
+type RlopenPkt struct {
+	OQID   QID
+	IOUnit MaxSize
+}
+
+type TlcreatePkt struct {
+	OFID   FID
+	Name   string
+	LFlags uint32
+	LMode  uint32
+	Gid    uint32
+}
+
+type RlcreatePkt struct {
+	OQID   QID
+	IOUnit MaxSize
+}
+
+type TgetattrPkt struct {
+	OFID FID
+	Mask uint64
+}
+
+type RgetattrPkt struct {
+	Valid       uint64
+	GQID        QID
+	GMode       uint32
+	UID         uint32
+	GID         uint32
+	NLink       uint64
+	RDev        uint64
+	Size        uint64
+	BlkSize     uint64
+	Blocks      uint64
+	ATimeSec    uint64
+	ATimeNsec   uint64
+	MTimeSec    uint64
+	MTimeNsec   uint64
+	CTimeSec    uint64
+	CTimeNsec   uint64
+	BTimeSec    uint64
+	BTimeNsec   uint64
+	Gen         uint64
+	DataVersion uint64
+}
+
+type TsetattrPkt struct {
+	OFID       FID
+	SValid     uint32
+	SMode      uint32
+	SUID       uint32
+	SGID       uint32
+	SSize      uint64
+	SATimeSec  uint64
+	SATimeNsec uint64
+	SMTimeSec  uint64
+	SMTimeNsec uint64
+}
+
+type RsetattrPkt struct {
+}
+
+type TreaddirPkt struct {
+	OFID FID
+	Off  Offset
+	Len  Count
+}
+
+type RreaddirPkt struct {
+	Data []byte
+}
+
+type TfsyncPkt struct {
+	OFID     FID
+	DataSync uint32
+}
+
+type RfsyncPkt struct {
+}
+
+type TmkdirPkt struct {
+	DFID  FID
+	Name  string
+	LMode uint32
+	Gid   uint32
+}
+
+type RmkdirPkt struct {
+	OQID QID
+}
+
+type TrenameatPkt struct {
+	OldDFID FID
+	OldName string
+	NewDFID FID
+	NewName string
+}
+
+type RrenameatPkt struct {
+}
+
+type TunlinkatPkt struct {
+	DFID   FID
+	Name   string
+	UFlags uint32
+}
+
+type RunlinkatPkt struct {
+}
+
+type TreadlinkPkt struct {
+	OFID FID
+}
+
+type RreadlinkPkt struct {
+	Target string
+}
+
 type RPCCall struct {
 	b     []byte
 	Reply chan []byte
@@ -339,5 +596,46 @@ var (
 		Rstat:    "Rstat",
 		Twstat:   "Twstat",
 		Rwstat:   "Rwstat",
+
+		Tlerror:      "Tlerror",
+		Rlerror:      "Rlerror",
+		Tstatfs:      "Tstatfs",
+		Rstatfs:      "Rstatfs",
+		Tlopen:       "Tlopen",
+		Rlopen:       "Rlopen",
+		Tlcreate:     "Tlcreate",
+		Rlcreate:     "Rlcreate",
+		Tsymlink:     "Tsymlink",
+		Rsymlink:     "Rsymlink",
+		Tmknod:       "Tmknod",
+		Rmknod:       "Rmknod",
+		Trename:      "Trename",
+		Rrename:      "Rrename",
+		Treadlink:    "Treadlink",
+		Rreadlink:    "Rreadlink",
+		Tgetattr:     "Tgetattr",
+		Rgetattr:     "Rgetattr",
+		Tsetattr:     "Tsetattr",
+		Rsetattr:     "Rsetattr",
+		Txattrwalk:   "Txattrwalk",
+		Rxattrwalk:   "Rxattrwalk",
+		Txattrcreate: "Txattrcreate",
+		Rxattrcreate: "Rxattrcreate",
+		Treaddir:     "Treaddir",
+		Rreaddir:     "Rreaddir",
+		Tfsync:       "Tfsync",
+		Rfsync:       "Rfsync",
+		Tlock:        "Tlock",
+		Rlock:        "Rlock",
+		Tgetlock:     "Tgetlock",
+		Rgetlock:     "Rgetlock",
+		Tlink:        "Tlink",
+		Rlink:        "Rlink",
+		Tmkdir:       "Tmkdir",
+		Rmkdir:       "Rmkdir",
+		Trenameat:    "Trenameat",
+		Rrenameat:    "Rrenameat",
+		Tunlinkat:    "Tunlinkat",
+		Runlinkat:    "Runlinkat",
 	}
 )
@@ -7,9 +7,12 @@ package protocol
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"net"
 	"os"
 	"reflect"
+	"strings"
+	"sync"
 	"testing"
 )
 
@@ -351,6 +354,194 @@ func TestTManyRPCs(t *testing.T) {
 	}
 }
 
+// TestConcurrentRPCs is for -race: many goroutines sharing a client.
+func TestConcurrentRPCs(t *testing.T) {
+	p, p2 := net.Pipe()
+	c, err := NewClient(func(c *Client) error {
+		c.FromNet, c.ToNet = p, p
+		c.Msize = 8192
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	s, err := NewListener(func() NineServer { return newEcho() })
+	if err != nil {
+		t.Fatalf("NewServer: want nil, got %v", err)
+	}
+	if err := s.Accept(p2); err != nil {
+		t.Fatalf("Accept: want nil, got %v", err)
+	}
+	if _, _, err := c.CallTversion(8000, "9P2000"); err != nil {
+		t.Fatalf("CallTversion: want nil, got %v", err)
+	}
+
+	const n, calls = 32, 200
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		fids = map[FID]bool{}
+	)
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < calls; j++ {
+				f := c.GetFID()
+				mu.Lock()
+				dup := fids[f]
+				fids[f] = true
+				mu.Unlock()
+				if dup {
+					errs <- fmt.Errorf("GetFID: %v given out twice", f)
+					return
+				}
+				b, err := c.CallTread(2, 0, 2)
+				if err != nil || string(b) != "HI" {
+					errs <- fmt.Errorf("CallTread: got %q, %v, want HI, nil", b, err)
+					return
+				}
+				// Only fid 2 exists in echo.
+				if _, err := c.CallTstat(f); (err == nil) != (f == 2) {
+					errs <- fmt.Errorf("CallTstat(%v): got %v", f, err)
+					return
+				}
+				// String is safe while the connection is in use.
+				if s := c.String(); !strings.Contains(s, "FromNet *net.pipe") {
+					errs <- fmt.Errorf("String: got %q, want the type of FromNet", s)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if c.IsDead() {
+		t.Errorf("IsDead: got true, want false")
+	}
+	if len(c.Tags) != NumTags {
+		t.Errorf("len(Tags): got %d, want %d, all returned", len(c.Tags), NumTags)
+	}
+}
+
+// TestStrayReply checks that a reply to no request is dropped, and
+// the client carries on.
+func TestStrayReply(t *testing.T) {
+	p, p2 := net.Pipe()
+	c, err := NewClient(func(c *Client) error {
+		c.FromNet, c.ToNet = p, p
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	go func() {
+		var b bytes.Buffer
+		for {
+			var sz [4]byte
+			if _, err := io.ReadFull(p2, sz[:]); err != nil {
+				return
+			}
+			m := make([]byte, int(sz[0])|int(sz[1])<<8|int(sz[2])<<16|int(sz[3])<<24-4)
+			if _, err := io.ReadFull(p2, m); err != nil {
+				return
+			}
+			tag := Tag(m[1]) | Tag(m[2])<<8
+			if MType(m[0]) == Tversion {
+				MarshalRversionPkt(&b, tag, 8192, "9P2000")
+			} else {
+				MarshalRclunkPkt(&b, 77)
+				p2.Write(b.Bytes())
+				MarshalRclunkPkt(&b, tag)
+			}
+			p2.Write(b.Bytes())
+		}
+	}()
+	if _, _, err := c.CallTversion(8192, "9P2000"); err != nil {
+		t.Fatalf("CallTversion: want nil, got %v", err)
+	}
+	if err := c.CallTclunk(1); err != nil {
+		t.Fatalf("CallTclunk: want nil, got %v", err)
+	}
+}
+
+// deadTracer returns a Tracer, which sends what it is given on the
+// channel it returns, and closes it when given last, so any later call
+// panics.
+func deadTracer(last string) (Tracer, chan string) {
+	events := make(chan string, 1000)
+	return func(f string, args ...interface{}) {
+		s := fmt.Sprintf(f, args...)
+		events <- s
+		if strings.Contains(s, last) {
+			close(events)
+		}
+	}, events
+}
+
+func TestTraceDead(t *testing.T) {
+	skipMinimal(t, "tracing")
+	p, p2 := net.Pipe()
+	trace, events := deadTracer("client dead")
+	c, err := NewClient(func(c *Client) error {
+		c.FromNet, c.ToNet = p, p
+		c.Trace = trace
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	go func() {
+		m := make([]byte, 64)
+		n, _ := p2.Read(m)
+		var b bytes.Buffer
+		MarshalRversionPkt(&b, Tag(m[5])|Tag(m[6])<<8, 8192, "9P2000")
+		if n > 0 {
+			p2.Write(b.Bytes())
+		}
+		p2.Close()
+	}()
+	if _, _, err := c.CallTversion(8192, "9P2000"); err != nil {
+		t.Fatalf("CallTversion: want nil, got %v", err)
+	}
+	var last string
+	for e := range events {
+		last = e
+	}
+	if !strings.HasPrefix(last, "client dead: ") || !strings.Contains(last, "EOF") {
+		t.Errorf("last trace: want client dead, with EOF, got %q", last)
+	}
+	// Tracing now would panic.
+	if err := c.CallTclunk(1); err == nil {
+		t.Errorf("CallTclunk of dead client: want an error, got nil")
+	}
+
+	p, p2 = net.Pipe()
+	trace, events = deadTracer("connection dead")
+	l, err := NewListener(func() NineServer { return newDirServer() }, func(l *Listener) error {
+		l.Trace = trace
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("NewListener: want nil, got %v", err)
+	}
+	if err := l.Accept(p2); err != nil {
+		t.Fatalf("Accept: want nil, got %v", err)
+	}
+	p.Write(marshal(func(b *bytes.Buffer) { MarshalTversionPkt(b, NOTAG, 8192, "9P2000") }))
+	p.Close()
+	for e := range events {
+		last = e
+	}
+	if !strings.Contains(last, "connection dead: ") || !strings.Contains(last, "closed pipe") {
+		t.Errorf("last trace: want connection dead, with closed pipe, got %q", last)
+	}
+}
+
 func TestTMessages(t *testing.T) {
 	p, p2 := net.Pipe()
 
@@ -0,0 +1,82 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// NOTAG and NOFID are sentinels, not tags and fids. NOTAG is the tag of
+// a Tversion, and of nothing else. NOFID is the afid of a Tattach
+// which is not authenticated, and never names a file: a message whose
+// fid, or newfid, is NOFID is an error, whatever the NineServer would
+// make of it.
+
+// fidFirst are the T messages whose body starts, after the tag, with a
+// fid.
+var fidFirst = map[MType]bool{
+	Tauth: true, Tattach: true, Twalk: true, Topen: true, Tcreate: true,
+	Tread: true, Twrite: true, Tclunk: true, Tremove: true, Tstat: true,
+	Twstat: true,
+
+	Tstatfs: true, Tlopen: true, Tlcreate: true, Tsymlink: true,
+	Tmknod: true, Trename: true, Treadlink: true, Tgetattr: true,
+	Tsetattr: true, Txattrwalk: true, Txattrcreate: true, Treaddir: true,
+	Tfsync: true, Tlock: true, Tgetlock: true, Tlink: true, Tmkdir: true,
+	Trenameat: true, Tunlinkat: true,
+
+	Tcopy: true, Tsum: true,
+}
+
+// newFIDSecond are the T messages whose second fid is a new one.
+var newFIDSecond = map[MType]bool{
+	Twalk:      true,
+	Txattrwalk: true,
+}
+
+// badFID returns why the T message of type t in b, which starts at the
+// tag, uses NOFID as a fid, or "" if it doesn't.
+func badFID(t MType, b []byte) string {
+	fid := func(i int) FID {
+		return FID(b[i]) | FID(b[i+1])<<8 | FID(b[i+2])<<16 | FID(b[i+3])<<24
+	}
+	if fidFirst[t] && len(b) >= 6 && fid(2) == NOFID {
+		return Errorf(ErrInvalid, "%v: fid NOFID", RPCNames[t]).Error()
+	}
+	if newFIDSecond[t] && len(b) >= 10 && fid(6) == NOFID {
+		return Errorf(ErrInvalid, "%v: newfid NOFID", RPCNames[t]).Error()
+	}
+	return ""
+}
+
+// badSentinel is badFID, and also finds a NOTAG on anything but a
+// Tversion.
+func badSentinel(t MType, b []byte) string {
+	if t != Tversion && len(b) >= 2 && Tag(b[0])|Tag(b[1])<<8 == NOTAG {
+		return Errorf(ErrInvalid, "%v: tag NOTAG", RPCNames[t]).Error()
+	}
+	return badFID(t, b)
+}
+
+// dispatch dispatches the T message of type t in b, with D, unless it
+// misuses NOTAG or NOFID.
+func (s *Server) dispatch(b *bytes.Buffer, t MType) error {
+	if m := badSentinel(t, b.Bytes()); m != "" {
+		d := b.Bytes()
+		s.rerror(b, Tag(d[0])|Tag(d[1])<<8, m)
+		return fmt.Errorf("%v", m)
+	}
+	return s.D(s, b, t)
+}
+
+// rerror puts the error m in b, as the connection's dialect has them.
+func (s *Server) rerror(b *bytes.Buffer, t Tag, m string) {
+	if s.lerrors {
+		MarshalRlerrorPkt(b, t, errno(m))
+		return
+	}
+	MarshalRerrorPkt(b, t, m)
+}
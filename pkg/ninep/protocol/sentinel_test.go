@@ -0,0 +1,142 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+// rawRPC sends the message made by f on conn, and returns the reply.
+func rawRPC(t *testing.T, conn net.Conn, f func(b *bytes.Buffer)) []byte {
+	t.Helper()
+	if _, err := conn.Write(marshal(f)); err != nil {
+		t.Fatalf("Write: want nil, got %v", err)
+	}
+	var sz [4]byte
+	if _, err := io.ReadFull(conn, sz[:]); err != nil {
+		t.Fatalf("reading reply size: want nil, got %v", err)
+	}
+	m := make([]byte, int(sz[0])|int(sz[1])<<8|int(sz[2])<<16|int(sz[3])<<24)
+	copy(m, sz[:])
+	if _, err := io.ReadFull(conn, m[4:]); err != nil {
+		t.Fatalf("reading reply: want nil, got %v", err)
+	}
+	return m
+}
+
+func TestSentinelServer(t *testing.T) {
+	p, p2 := net.Pipe()
+	defer p.Close()
+	s, err := NewListener(func() NineServer { return newDirServer() })
+	if err != nil {
+		t.Fatalf("NewListener: want nil, got %v", err)
+	}
+	if err := s.Accept(p2); err != nil {
+		t.Fatalf("Accept: want nil, got %v", err)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		f     func(b *bytes.Buffer)
+		want  MType
+		tag   Tag
+		error string
+	}{
+		{"Tversion with NOTAG", func(b *bytes.Buffer) { MarshalTversionPkt(b, NOTAG, 8192, "9P2000") }, Rversion, NOTAG, ""},
+		{"Tattach of NOFID", func(b *bytes.Buffer) { MarshalTattachPkt(b, 1, NOFID, NOFID, "glenda", "") }, Rerror, 1, "fid NOFID"},
+		{"Tattach with no afid", func(b *bytes.Buffer) { MarshalTattachPkt(b, 1, 1, NOFID, "glenda", "") }, Rattach, 1, ""},
+		{"Twalk to NOFID", func(b *bytes.Buffer) { MarshalTwalkPkt(b, 2, 1, NOFID, nil) }, Rerror, 2, "newfid NOFID"},
+		{"Tstat of NOFID", func(b *bytes.Buffer) { MarshalTstatPkt(b, 3, NOFID) }, Rerror, 3, "fid NOFID"},
+		{"Tstat with NOTAG", func(b *bytes.Buffer) { MarshalTstatPkt(b, NOTAG, 1) }, Rerror, NOTAG, "tag NOTAG"},
+		{"Tstat", func(b *bytes.Buffer) { MarshalTstatPkt(b, 4, 1) }, Rstat, 4, ""},
+		{"9P2000.L", func(b *bytes.Buffer) { MarshalTversionPkt(b, NOTAG, 8192, "9P2000.L") }, Rversion, NOTAG, ""},
+		{"Tclunk of NOFID in 9P2000.L", func(b *bytes.Buffer) { MarshalTclunkPkt(b, 5, NOFID) }, Rlerror, 5, ""},
+	} {
+		if Minimal && strings.Contains(tc.name, "9P2000.L") {
+			continue
+		}
+		r := rawRPC(t, p, tc.f)
+		if MType(r[4]) != tc.want {
+			t.Errorf("%v: got %v, want %v", tc.name, RPCNames[MType(r[4])], RPCNames[tc.want])
+			continue
+		}
+		if tag := Tag(r[5]) | Tag(r[6])<<8; tag != tc.tag {
+			t.Errorf("%v: got tag %#x, want %#x", tc.name, tag, tc.tag)
+		}
+		switch tc.want {
+		case Rerror:
+			if e, _, err := UnmarshalRerrorPkt(bytes.NewBuffer(r[5:])); err != nil || !strings.Contains(e, tc.error) {
+				t.Errorf("%v: got %q, %v, want %q", tc.name, e, err, tc.error)
+			}
+		case Rlerror:
+			if e, _, err := UnmarshalRlerrorPkt(bytes.NewBuffer(r[5:])); err != nil || e != EINVAL {
+				t.Errorf("%v: got errno %d, %v, want EINVAL", tc.name, e, err)
+			}
+		}
+	}
+}
+
+func TestSentinelBatch(t *testing.T) {
+	c := newExtClient(t)
+	if _, _, _, err := c.Version(8192, "9P2000", BatchExtension); err != nil {
+		t.Fatalf("Version: want nil, got %v", err)
+	}
+	replies, err := c.Batch(
+		marshal(func(b *bytes.Buffer) { MarshalTattachPkt(b, 0, 0, NOFID, "glenda", "") }),
+		marshal(func(b *bytes.Buffer) { MarshalTwalkPkt(b, 0, 0, NOFID, []string{"a"}) }),
+	)
+	if err == nil || !strings.Contains(err.Error(), "NOFID") || len(replies) != 2 {
+		t.Errorf("Batch with a walk to NOFID: got %d replies, %v, want 2, an error about NOFID", len(replies), err)
+	}
+}
+
+func TestSentinelClient(t *testing.T) {
+	p, p2 := net.Pipe()
+	c, err := NewClient(func(c *Client) error {
+		c.FromNet, c.ToNet = p, p
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	tags := make(chan Tag, 1)
+	go func() {
+		var b bytes.Buffer
+		for {
+			var sz [4]byte
+			if _, err := io.ReadFull(p2, sz[:]); err != nil {
+				return
+			}
+			m := make([]byte, int(sz[0])|int(sz[1])<<8|int(sz[2])<<16|int(sz[3])<<24-4)
+			if _, err := io.ReadFull(p2, m); err != nil {
+				return
+			}
+			tag := Tag(m[1]) | Tag(m[2])<<8
+			tags <- tag
+			MarshalRversionPkt(&b, tag, 8192, "9P2000")
+			p2.Write(b.Bytes())
+		}
+	}()
+	for i := 0; i < 3; i++ {
+		if _, _, err := c.CallTversion(8192, "9P2000"); err != nil {
+			t.Fatalf("CallTversion: want nil, got %v", err)
+		}
+		if tag := <-tags; tag != NOTAG {
+			t.Errorf("CallTversion: sent tag %#x, want NOTAG", tag)
+		}
+	}
+	if len(c.Tags) != NumTags {
+		t.Errorf("len(Tags): got %d, want %d, since Tversion uses none", len(c.Tags), NumTags)
+	}
+
+	c.FID = uint64(NOFID) - 1
+	if f := c.GetFID(); f == NOFID {
+		t.Errorf("GetFID: got NOFID")
+	}
+}
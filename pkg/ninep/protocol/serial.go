@@ -0,0 +1,192 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"time"
+)
+
+const (
+	serialFlag   = 0x7e
+	serialEscape = 0x7d
+	serialXor    = 0x20
+
+	// maxSerialFrame bounds a frame, so that a line with no flags
+	// on it can't use up memory.
+	maxSerialFrame = 1 << 24
+)
+
+// SerialCodec frames messages for serial lines, as HDLC does: each
+// message, followed by its CRC-32, little endian, goes between flag bytes
+// of 0x7e, with any 0x7e or 0x7d in it sent as 0x7d and the byte xor
+// 0x20. A frame which is corrupt is dropped, and reading goes on with the
+// next, so that noise on the line, or plugging in halfway through a
+// message, costs one message rather than the connection. The request or
+// reply in it is lost, so a client on such a line should time out and
+// flush requests which go unanswered.
+var SerialCodec Codec = serialCodec{}
+
+type serialCodec struct{}
+
+func (serialCodec) Read(r *bufio.Reader) ([]byte, error) {
+	for {
+		f, err := serialFrame(r)
+		if err != nil {
+			return nil, err
+		}
+		if m, err := unstuff(f); err == nil {
+			return m, nil
+		}
+	}
+}
+
+func (serialCodec) Write(w io.Writer, m []byte) error {
+	c := crc32.ChecksumIEEE(m)
+	b := make([]byte, 0, 2*len(m)+10)
+	b = append(b, serialFlag)
+	for _, v := range append(m[:len(m):len(m)], byte(c), byte(c>>8), byte(c>>16), byte(c>>24)) {
+		if v == serialFlag || v == serialEscape {
+			b = append(b, serialEscape, v^serialXor)
+			continue
+		}
+		b = append(b, v)
+	}
+	b = append(b, serialFlag)
+	_, err := w.Write(b)
+	return err
+}
+
+// whole reports whether b starts with a good frame, so that the server
+// does not hold replies back waiting for a message which is not there.
+func (serialCodec) whole(b []byte) bool {
+	f, err := serialFrame(bufio.NewReader(bytes.NewReader(b)))
+	if err != nil {
+		return false
+	}
+	_, err = unstuff(f)
+	return err == nil
+}
+
+// serialFrame returns the bytes of the next frame, skipping anything
+// before its opening flag. Since a flag both ends a frame and may start
+// the next, empty frames are skipped too.
+func serialFrame(r *bufio.Reader) ([]byte, error) {
+	if err := skipFlag(r); err != nil {
+		return nil, err
+	}
+	var f []byte
+	for {
+		b, err := r.ReadSlice(serialFlag)
+		switch err {
+		case nil:
+			f = append(f, b[:len(b)-1]...)
+			if len(f) == 0 {
+				continue
+			}
+			// Leave the flag for the next frame.
+			r.UnreadByte()
+			return f, nil
+		case bufio.ErrBufferFull:
+			f = append(f, b...)
+			if len(f) > maxSerialFrame {
+				// Junk, or a lost flag: start again at the next one.
+				f = f[:0]
+				if err := skipFlag(r); err != nil {
+					return nil, err
+				}
+			}
+		default:
+			return nil, err
+		}
+	}
+}
+
+// skipFlag skips past the next flag.
+func skipFlag(r *bufio.Reader) error {
+	for {
+		_, err := r.ReadSlice(serialFlag)
+		if err != bufio.ErrBufferFull {
+			return err
+		}
+	}
+}
+
+// unstuff undoes the byte stuffing of the frame f, and checks its CRC and
+// size.
+func unstuff(f []byte) ([]byte, error) {
+	m := make([]byte, 0, len(f))
+	for i := 0; i < len(f); i++ {
+		v := f[i]
+		if v == serialEscape {
+			if i++; i == len(f) {
+				return nil, fmt.Errorf("frame ends in an escape")
+			}
+			v = f[i] ^ serialXor
+		}
+		m = append(m, v)
+	}
+	if len(m) < 7+4 {
+		return nil, fmt.Errorf("frame of %d bytes is too short", len(m))
+	}
+	c := m[len(m)-4:]
+	m = m[:len(m)-4]
+	want := uint32(c[0]) | uint32(c[1])<<8 | uint32(c[2])<<16 | uint32(c[3])<<24
+	if got := crc32.ChecksumIEEE(m); got != want {
+		return nil, fmt.Errorf("CRC %#08x, want %#08x", got, want)
+	}
+	if sz := int(m[0]) | int(m[1])<<8 | int(m[2])<<16 | int(m[3])<<24; sz != len(m) {
+		return nil, fmt.Errorf("size %d, but %d bytes", sz, len(m))
+	}
+	return m, nil
+}
+
+// serialConn makes a serial line look like a network connection.
+type serialConn struct {
+	io.ReadWriteCloser
+	name serialAddr
+}
+
+// serialAddr is the name of a serial line.
+type serialAddr string
+
+func (a serialAddr) Network() string { return "serial" }
+func (a serialAddr) String() string  { return string(a) }
+
+// SerialConn makes rwc, a serial line such as an open /dev/ttyS0, into a
+// net.Conn named name, for Listener.Accept or a Client. Use SerialCodec
+// with it. Deadlines work if rwc supports them, as an *os.File does.
+func SerialConn(rwc io.ReadWriteCloser, name string) net.Conn {
+	return &serialConn{ReadWriteCloser: rwc, name: serialAddr(name)}
+}
+
+func (c *serialConn) LocalAddr() net.Addr  { return c.name }
+func (c *serialConn) RemoteAddr() net.Addr { return c.name }
+
+func (c *serialConn) SetDeadline(t time.Time) error {
+	if d, ok := c.ReadWriteCloser.(interface{ SetDeadline(time.Time) error }); ok {
+		return d.SetDeadline(t)
+	}
+	return fmt.Errorf("%v: deadlines not supported", c.name)
+}
+
+func (c *serialConn) SetReadDeadline(t time.Time) error {
+	if d, ok := c.ReadWriteCloser.(interface{ SetReadDeadline(time.Time) error }); ok {
+		return d.SetReadDeadline(t)
+	}
+	return fmt.Errorf("%v: deadlines not supported", c.name)
+}
+
+func (c *serialConn) SetWriteDeadline(t time.Time) error {
+	if d, ok := c.ReadWriteCloser.(interface{ SetWriteDeadline(time.Time) error }); ok {
+		return d.SetWriteDeadline(t)
+	}
+	return fmt.Errorf("%v: deadlines not supported", c.name)
+}
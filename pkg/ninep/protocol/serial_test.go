@@ -0,0 +1,122 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"math/rand"
+	"net"
+	"testing"
+)
+
+// TestSerialResync writes messages with noise between them, and some of
+// them corrupted, and checks that every good message is read, in order,
+// and nothing else.
+func TestSerialResync(t *testing.T) {
+	r := rand.New(rand.NewSource(3))
+	for i := 0; i < 100; i++ {
+		var line bytes.Buffer
+		var want []string
+		for j := 0; j < 20; j++ {
+			if r.Intn(4) == 0 {
+				noise := make([]byte, r.Intn(50))
+				r.Read(noise)
+				line.Write(noise)
+			}
+			var b bytes.Buffer
+			// Names full of flags and escapes, which must be stuffed.
+			name := string(bytes.Repeat([]byte{serialFlag, 'a', serialEscape}, r.Intn(10)))
+			MarshalTwalkPkt(&b, Tag(j), FID(j), FID(j+1), []string{name})
+			var f bytes.Buffer
+			if err := SerialCodec.Write(&f, b.Bytes()); err != nil {
+				t.Fatalf("Write: %v", err)
+			}
+			if r.Intn(5) == 0 {
+				// Corrupt anything but the flags around it.
+				f.Bytes()[1+r.Intn(f.Len()-2)] ^= byte(1 + r.Intn(255))
+			} else {
+				want = append(want, b.String())
+			}
+			line.Write(f.Bytes())
+		}
+
+		br := bufio.NewReader(&line)
+		var got []string
+		for {
+			m, err := SerialCodec.Read(br)
+			if err != nil {
+				break
+			}
+			got = append(got, string(m))
+		}
+		if len(got) != len(want) {
+			t.Fatalf("line %d: got %d messages, want %d", i, len(got), len(want))
+		}
+		for j := range got {
+			if got[j] != want[j] {
+				t.Errorf("line %d: message %d: got %x, want %x", i, j, got[j], want[j])
+			}
+		}
+	}
+}
+
+func TestSerialWhole(t *testing.T) {
+	var b, f bytes.Buffer
+	MarshalTclunkPkt(&b, 1, 2)
+	SerialCodec.Write(&f, b.Bytes())
+	w := SerialCodec.(wholeCodec)
+	for _, tc := range []struct {
+		b    []byte
+		want bool
+	}{
+		{[]byte("junk"), false},
+		{[]byte("junk\x7ejunk\x7e"), false},
+		{f.Bytes()[:f.Len()-1], false},
+		{f.Bytes(), true},
+		{append([]byte("junk"), f.Bytes()...), true},
+	} {
+		if got := w.whole(tc.b); got != tc.want {
+			t.Errorf("whole(%q): got %v, want %v", tc.b, got, tc.want)
+		}
+	}
+}
+
+func TestSerialConn(t *testing.T) {
+	s, err := NewListener(func() NineServer { return newDirServer() }, func(l *Listener) error {
+		l.Codec = SerialCodec
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("NewListener: want nil, got %v", err)
+	}
+	p, p2 := net.Pipe()
+	if err := s.Accept(SerialConn(p2, "ttyS0")); err != nil {
+		t.Fatalf("Accept: want nil, got %v", err)
+	}
+
+	// Junk on the line before a request does not hold back its reply.
+	if _, err := p.Write([]byte("\x00\xff junk \x7e\x7d more junk")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	c, err := NewClient(func(c *Client) error {
+		c.FromNet, c.ToNet = p, p
+		c.Msize = 8192
+		c.Codec = SerialCodec
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("NewClient: want nil, got %v", err)
+	}
+	if _, v, err := c.CallTversion(8192, "9P2000"); err != nil || v != "9P2000" {
+		t.Fatalf("CallTversion: got %q, %v, want 9P2000, nil", v, err)
+	}
+	if _, err := c.CallTattach(0, NOFID, "glenda", ""); err != nil {
+		t.Errorf("CallTattach: want nil, got %v", err)
+	}
+	if _, err := c.CallTwalk(0, 1, []string{"a"}); err != nil {
+		t.Errorf("CallTwalk(a): want nil, got %v", err)
+	}
+}
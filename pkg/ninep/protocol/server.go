@@ -8,15 +8,31 @@ package protocol
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"math"
 	"net"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 const DefaultAddr = ":5640"
 
+// DefaultConnMemory is the default Listener.MaxConnMemory, which is
+// plenty for a message of MaxMsize and its reply.
+const DefaultConnMemory = 2 * MaxMsize
+
+// DefaultMaxInFlight is the default Listener.MaxInFlight.
+const DefaultMaxInFlight = 256
+
+// minConnMemory is the smallest Listener.MaxConnMemory, which must at
+// least hold the pieces streamed Twrites are taken in.
+const minConnMemory = 2 * writeChunk
+
 type NsCreator func() NineServer
 
 type Listener struct {
@@ -28,10 +44,98 @@ type Listener struct {
 	// Trace function for logging
 	Trace Tracer
 
+	// Extensions are the names of the extensions offered to clients.
+	// If nil, every registered extension is offered.
+	Extensions []string
+
+	// Codec puts messages on the wire. If nil, it is BinaryCodec.
+	Codec Codec
+
+	// TCP, if set, tunes the TCP connections accepted.
+	TCP *TCPOptions
+
+	// ReplyWindow is how long a connection may hold replies, waiting
+	// for more requests, so that their replies can be sent together.
+	// Replies to requests which arrive together are always sent
+	// together.
+	ReplyWindow time.Duration
+
+	// MaxConnMemory is the most memory a connection may use for a
+	// message and its reply. A message bigger than that ends the
+	// connection, except for a Twrite, which is taken in pieces, and
+	// reads are cut short to fit. If 0, it is DefaultConnMemory; if
+	// negative, there is no limit.
+	MaxConnMemory int64
+
+	// MaxInFlight is the most replies a connection holds back, with
+	// ReplyWindow, before it sends them, and it reads no more
+	// requests until they are sent. Requests are done one at a time,
+	// so these are all the requests in flight: a client which sends
+	// faster than it reads is slowed to the pace it reads at. If 0,
+	// it is DefaultMaxInFlight; if negative, only the space they are
+	// held in limits them.
+	MaxInFlight int
+
+	// MaxMsize is the largest msize the server agrees to: clients
+	// which ask for more are given this. Reads are cut to fit the
+	// msize agreed. If 0, it is MaxMsize.
+	MaxMsize uint32
+
+	// Clock times the ReplyWindow and the start of connections. If
+	// nil, it is SystemClock.
+	Clock Clock
+
+	// OnShutdown, if set, is called once, by the first Shutdown, after
+	// the listeners are closed, so that the server can let go of what
+	// it holds for all its connections.
+	OnShutdown func() error
+
 	// mu guards below
 	mu sync.Mutex
 
 	listeners map[net.Listener]struct{}
+
+	// conns are the connections being served, by id. accepted
+	// counts them all, and msgs the messages of those now gone.
+	conns    map[uint64]*conn
+	accepted uint64
+	msgs     uint64
+
+	// filter, if set, picks what is traced, traced counts the
+	// messages it has matched, by type, and dropped the lines it
+	// sampled out or had no budget for.
+	filter  *TraceFilter
+	traced  map[MType]uint64
+	dropped uint64
+}
+
+// ConnInfo describes a connection being served.
+type ConnInfo struct {
+	ID       uint64
+	Remote   string
+	Start    time.Time
+	Messages uint64
+}
+
+// ListenerStats are the totals of a Listener.
+type ListenerStats struct {
+	Conns    int
+	Accepted uint64
+	Messages uint64
+	// Traced counts the messages the TraceFilter has matched, by
+	// type, if there is one.
+	Traced map[string]uint64 `json:",omitempty"`
+	// TraceDropped counts what the TraceFilter matched, or what the
+	// connections had to say, which was not traced, for its sampling
+	// or budget.
+	TraceDropped uint64 `json:",omitempty"`
+}
+
+// A HangupServer is a NineServer which wants to know when its
+// connection ends. Hangup is called once, after the last message, so
+// that it can let go of the fids the client never clunked.
+type HangupServer interface {
+	Hangup()
 }
 
 // Server is a 9p server.
@@ -43,6 +147,23 @@ type Server struct {
 
 	// Versioned is set to true on the first call to Tversion
 	Versioned bool
+
+	// dirs holds the open directories of dialects which must
+	// translate directory reads.
+	dirs map[FID]*dirOffset
+
+	// offer is the extensions this server may agree to, nil for all,
+	// and exts the ones it did.
+	offer map[string]bool
+	exts  map[string]bool
+
+	// lerrors is set when errors are Rlerror, as in 9P2000.L.
+	lerrors bool
+
+	// maxMsize is the largest msize agreed to, MaxMsize if 0, and
+	// msize the one agreed, 0 until Tversion.
+	maxMsize MaxSize
+	msize    MaxSize
 }
 
 type conn struct {
@@ -54,6 +175,19 @@ type conn struct {
 	// rwc is the underlying network connection.
 	rwc net.Conn
 
+	// r reads messages from rwc, and w writes replies to it.
+	r frameReader
+	w *frameWriter
+
+	// codec, if set, is used instead of the 9P encoding.
+	codec Codec
+
+	// maxMem is the most memory a message and its reply may use.
+	maxMem int64
+
+	// maxInFlight is the most replies held back, if positive.
+	maxInFlight int
+
 	// remoteAddr is rwc.RemoteAddr().String(). See note in net/http/server.go.
 	remoteAddr string
 
@@ -62,6 +196,23 @@ type conn struct {
 
 	// dead is set to true when we finish reading packets.
 	dead bool
+
+	// dmu is held while a message is dispatched, since held reads
+	// are dispatched by goroutines of their own, and guards held, the
+	// Treads held for a Waiter, by tag, with the channels which drop
+	// them.
+	dmu  sync.Mutex
+	held map[Tag]chan struct{}
+
+	// paths are the paths of fids, from the root attached to, for a
+	// TraceFilter, kept if there is a Trace. c.dmu guards them.
+	paths map[FID]string
+
+	// id is the connection's, for Listener.Conns, start when it
+	// was accepted, and msgs counts its messages.
+	id    uint64
+	start time.Time
+	msgs  uint64
 }
 
 func NewListener(nsCreator NsCreator, opts ...ListenerOpt) (*Listener, error) {
@@ -74,13 +225,35 @@ func NewListener(nsCreator NsCreator, opts ...ListenerOpt) (*Listener, error) {
 			return nil, err
 		}
 	}
+	if l.MaxConnMemory > 0 && l.MaxConnMemory < minConnMemory {
+		return nil, fmt.Errorf("MaxConnMemory %d is less than the minimum of %d", l.MaxConnMemory, minConnMemory)
+	}
+	if l.MaxMsize != 0 && l.MaxMsize <= IOHDRSZ {
+		return nil, fmt.Errorf("MaxMsize %d is too small", l.MaxMsize)
+	}
 
 	return l, nil
 }
 
 func (l *Listener) newConn(rwc net.Conn) (*conn, error) {
 	ns := l.nsCreator()
-	server := &Server{NS: ns, D: Dispatch}
+	if ps, ok := ns.(PeerServer); ok {
+		if u, ok := rwc.(*net.UnixConn); ok {
+			p, err := PeerCredentials(u)
+			if err != nil {
+				l.logf("%v: no peer credentials: %v", rwc.RemoteAddr(), err)
+			} else {
+				ps.SetPeer(p)
+			}
+		}
+	}
+	server := &Server{NS: newSnapServer(ns), D: Dispatch, maxMsize: MaxSize(l.MaxMsize)}
+	if l.Extensions != nil {
+		server.offer = make(map[string]bool)
+		for _, n := range l.Extensions {
+			server.offer[n] = true
+		}
+	}
 
 	c := &conn{
 		server:   server,
@@ -88,6 +261,21 @@ func (l *Listener) newConn(rwc net.Conn) (*conn, error) {
 		rwc:      rwc,
 		replies:  make(chan RPCReply, NumTags),
 	}
+	if l.Codec != BinaryCodec {
+		c.codec = l.Codec
+	}
+	switch {
+	case l.MaxConnMemory == 0:
+		c.maxMem = DefaultConnMemory
+	case l.MaxConnMemory < 0:
+		c.maxMem = math.MaxInt64
+	default:
+		c.maxMem = l.MaxConnMemory
+	}
+	c.maxInFlight = l.MaxInFlight
+	if c.maxInFlight == 0 {
+		c.maxInFlight = DefaultMaxInFlight
+	}
 
 	return c, nil
 }
@@ -160,22 +348,98 @@ func (l *Listener) Serve(ln net.Listener) error {
 // Accept a new connection, typically called via Serve but may be called
 // directly if there's a connection from an exotic listener.
 func (l *Listener) Accept(conn net.Conn) error {
+	if err := l.TCP.Apply(conn); err != nil {
+		conn.Close()
+		return err
+	}
 	c, err := l.newConn(conn)
 	if err != nil {
 		return err
 	}
+	l.track(c, true)
 
 	go c.serve()
 	return nil
 }
 
+// track adds c to, or removes it from, the connections being served.
+func (l *Listener) track(c *conn, add bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.conns == nil {
+		l.conns = make(map[uint64]*conn)
+	}
+	if add {
+		l.accepted++
+		c.id = l.accepted
+		c.start = clockOrSystem(l.Clock).Now()
+		if c.rwc != nil {
+			c.remoteAddr = c.rwc.RemoteAddr().String()
+		}
+		l.conns[c.id] = c
+		return
+	}
+	delete(l.conns, c.id)
+	l.msgs += atomic.LoadUint64(&c.msgs)
+}
+
+// Conns returns the connections being served, oldest first.
+func (l *Listener) Conns() []ConnInfo {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var cs []ConnInfo
+	for _, c := range l.conns {
+		cs = append(cs, ConnInfo{ID: c.id, Remote: c.remoteAddr, Start: c.start, Messages: atomic.LoadUint64(&c.msgs)})
+	}
+	sort.Slice(cs, func(i, j int) bool { return cs[i].ID < cs[j].ID })
+	return cs
+}
+
+// Evict closes the connection with id, as given by Conns.
+func (l *Listener) Evict(id uint64) error {
+	l.mu.Lock()
+	c, ok := l.conns[id]
+	l.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("no connection %d", id)
+	}
+	if c.rwc == nil {
+		return nil
+	}
+	return c.rwc.Close()
+}
+
+// Stats returns the Listener's totals.
+func (l *Listener) Stats() ListenerStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	st := ListenerStats{Conns: len(l.conns), Accepted: l.accepted, Messages: l.msgs, TraceDropped: l.dropped}
+	for _, c := range l.conns {
+		st.Messages += atomic.LoadUint64(&c.msgs)
+	}
+	if l.traced != nil {
+		st.Traced = make(map[string]uint64)
+		for t, n := range l.traced {
+			st.Traced[RPCNames[t]] = n
+		}
+	}
+	return st
+}
+
 // Shutdown closes all active listeners. It does not close all active
 // connections but probably should.
 func (l *Listener) Shutdown() error {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
-	return l.closeListenersLocked()
+	err := l.closeListenersLocked()
+	if f := l.OnShutdown; f != nil {
+		l.OnShutdown = nil
+		if ferr := f(); err == nil {
+			err = ferr
+		}
+	}
+	return err
 }
 
 func (l *Listener) String() string {
@@ -184,7 +448,7 @@ func (l *Listener) String() string {
 }
 
 func (l *Listener) logf(format string, args ...interface{}) {
-	if l.Trace != nil {
+	if !Minimal && l.Trace != nil {
 		l.Trace(format, args...)
 	}
 }
@@ -194,53 +458,333 @@ func (c *conn) String() string {
 }
 
 func (c *conn) logf(format string, args ...interface{}) {
+	if !Minimal && c.listener.Trace != nil {
+		c.tracef(format, args...)
+	}
+}
+
+// tracef is logf, for a Listener with a Trace.
+func (c *conn) tracef(format string, args ...interface{}) {
+	f := c.traceFilter()
+	if f != nil && !f.matchConn(c.id, c.remoteAddr) {
+		return
+	}
+	if f != nil && f.BytesPerSecond > 0 {
+		s := fmt.Sprintf(format, args...)
+		if !c.listener.spend(f, len(s)+len(c.remoteAddr)+3) {
+			return
+		}
+		c.listener.logf("[%v] %s", c.remoteAddr, s)
+		return
+	}
 	// prepend some info about the conn
 	c.listener.logf("[%v] "+format, append([]interface{}{c.remoteAddr}, args...)...)
 }
 
+// spend reports whether the TraceFilter f has the budget to trace n
+// bytes more, and counts them as dropped if not.
+func (l *Listener) spend(f *TraceFilter, n int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if f.spend(n, clockOrSystem(l.Clock).Now()) {
+		return true
+	}
+	l.dropped++
+	return false
+}
+
 func (c *conn) serve() {
-	if c.rwc == nil {
+	// The last word on the connection is why it ended, once the
+	// NineServer has been told and the Listener has forgotten it.
+	var cause error
+	defer func() {
+		c.logf("connection dead: %v", cause)
+	}()
+	defer c.listener.track(c, false)
+	defer c.server.hangup()
+	defer c.dropAll()
+	defer func() {
 		c.dead = true
+	}()
+	if c.rwc == nil {
+		cause = fmt.Errorf("no connection")
 		return
 	}
 
-	c.remoteAddr = c.rwc.RemoteAddr().String()
-
 	defer c.rwc.Close()
 
 	c.logf("Starting readNetPackets")
 
+	c.r = newFrameReader(c.rwc)
+	c.w = &frameWriter{w: c.rwc, window: c.listener.ReplyWindow, clock: clockOrSystem(c.listener.Clock)}
+	defer c.w.flush()
+	if c.codec == nil {
+		err := checkFirst(c.r, c.maxMem, func(t MType) bool {
+			_, ok := RPCNames[t]
+			return ok && t%2 == 0
+		})
+		if errors.Is(err, ErrNot9P) {
+			c.logf("readNetPackets: %v", err)
+			cause = err
+			return
+		}
+	}
 	for !c.dead {
-		l := make([]byte, 7)
-		if n, err := c.rwc.Read(l); err != nil || n < 7 {
-			c.logf("readNetPackets: short read: %v", err)
-			c.dead = true
+		if !c.pending() {
+			if err := c.w.idle(); err != nil {
+				c.logf("readNetPackets: write error: %v", err)
+				cause = err
+				return
+			}
+		}
+		if streamed, err := c.streamWrite(); err != nil {
+			c.logf("readNetPackets: %v", err)
+			cause = err
 			return
+		} else if streamed {
+			if !Minimal {
+				atomic.AddUint64(&c.msgs, 1)
+			}
+			continue
 		}
-		sz := int64(l[0]) + int64(l[1])<<8 + int64(l[2])<<16 + int64(l[3])<<24
-		t := MType(l[4])
-		b := bytes.NewBuffer(l[5:])
-		r := io.LimitReader(c.rwc, sz-7)
-		if _, err := io.Copy(b, r); err != nil {
+		b, t, err := c.body()
+		if err != nil {
 			c.logf("readNetPackets: short read: %v", err)
-			c.dead = true
+			cause = err
 			return
 		}
-		c.logf("readNetPackets: got %v, len %d, sending to IO", RPCNames[MType(l[4])], b.Len())
-		//panic(fmt.Sprintf("packet is %v", b.Bytes()[:]))
-		//panic(fmt.Sprintf("s is %v", s))
-		if err := c.server.D(c.server, b, t); err != nil {
-			c.logf("%v: %v", RPCNames[MType(l[4])], err)
+		if !Minimal {
+			atomic.AddUint64(&c.msgs, 1)
+		}
+		c.limitRead(b, t)
+		if c.hold(b, t) {
+			putFrame(b)
+			continue
+		}
+		// With a TraceFilter, dispatch traces what it matches.
+		filtered := c.traceFilter() != nil
+		if !filtered {
+			c.logf("readNetPackets: got %v, len %d, sending to IO", RPCNames[t], b.Len())
+		}
+		if err := c.dispatch(b, t); err != nil && !filtered {
+			c.logf("%v: %v", RPCNames[t], err)
+		}
+		if !filtered {
+			c.logf("readNetPackets: Write %v back", b)
 		}
-		c.logf("readNetPackets: Write %v back", b)
-		amt, err := c.rwc.Write(b.Bytes())
+		err = c.write(b.Bytes())
+		putFrame(b)
 		if err != nil {
 			c.logf("readNetPackets: write error: %v", err)
-			c.dead = true
+			cause = err
 			return
 		}
-		c.logf("Returned %v amt %v", b, amt)
 	}
+	cause = fmt.Errorf("connection marked dead")
+}
+
+// dispatch dispatches the message in b, of type t, to the server. A
+// Tflush drops the read it flushes, if that is held.
+func (c *conn) dispatch(b *bytes.Buffer, t MType) error {
+	c.dmu.Lock()
+	defer c.dmu.Unlock()
+	return c.dispatchLocked(b, t)
+}
+
+// dispatchLocked is dispatch, with c.dmu held.
+func (c *conn) dispatchLocked(b *bytes.Buffer, t MType) error {
+	// tag[2] oldtag[2]
+	if d := b.Bytes(); t == Tflush && len(d) >= 4 {
+		c.drop(Tag(d[2]) | Tag(d[3])<<8)
+	}
+	m := c.before(b, t)
+	err := c.server.dispatch(b, t)
+	c.after(m, b, t)
+	return err
+}
+
+// hangup tells the NineServer, if it wants to know, that the
+// connection has ended.
+func (s *Server) hangup() {
+	if h, ok := s.NS.(HangupServer); ok {
+		h.Hangup()
+	}
+}
+
+// body reads the next message, for a Dispatcher, through the codec if
+// there is one. Messages over the memory limit are an error.
+func (c *conn) body() (*bytes.Buffer, MType, error) {
+	max := c.limit()
+	if c.codec == nil {
+		sz, _, _, err := c.r.header()
+		if err != nil {
+			return nil, 0, err
+		}
+		if sz > max {
+			return nil, 0, fmt.Errorf("message of %d bytes is over the connection's limit of %d", sz, max)
+		}
+		return c.r.body()
+	}
+	m, err := readLimit(c.codec, c.r.Reader, max)
+	if err != nil {
+		return nil, 0, err
+	}
+	return bytes.NewBuffer(m[5:]), MType(m[4]), nil
+}
+
+// limit is the size of the biggest message the connection takes: the
+// memory limit, and, once Tversion is done, the msize agreed.
+func (c *conn) limit() int64 {
+	max := c.maxMem
+	if m := int64(c.server.msize); m > 0 && m < max {
+		max = m
+	}
+	return max
+}
+
+// limitRead cuts the count of a Tread, or 9P2000.L Treaddir, in b, so
+// that the reply fits in the msize agreed, and in the memory limit along
+// with the request. A short read is always allowed.
+func (c *conn) limitRead(b *bytes.Buffer, t MType) {
+	if t != Tread && t != Treaddir {
+		return
+	}
+	// tag[2] fid[4] offset[8] count[4]
+	d := b.Bytes()
+	if len(d) < 18 {
+		return
+	}
+	n := int64(d[14]) | int64(d[15])<<8 | int64(d[16])<<16 | int64(d[17])<<24
+	max := c.maxMem - int64(len(d)) - IOHDRSZ
+	if m := int64(c.server.msize) - IOHDRSZ; m > 0 && m < max {
+		max = m
+	}
+	if n > max {
+		c.logf("%v: count %d cut to %d", RPCNames[t], n, max)
+		d[14], d[15], d[16], d[17] = byte(max), byte(max>>8), byte(max>>16), byte(max>>24)
+	}
+}
+
+// write sends the reply m. If too many replies are held back, they are
+// all sent, which waits for the client to read them.
+func (c *conn) write(m []byte) error {
+	var err error
+	if c.codec == nil {
+		_, err = c.w.Write(m)
+	} else {
+		err = c.codec.Write(c.w, m)
+	}
+	if err == nil && c.w.replied() >= c.maxInFlight && c.maxInFlight > 0 {
+		err = c.w.flushErr()
+	}
+	return err
+}
+
+// pending reports whether the next message has arrived, all of it.
+// With a codec which can't tell, it says no, so that held replies are
+// not kept waiting on the rest of a message.
+func (c *conn) pending() bool {
+	n := c.r.Buffered()
+	if c.codec != nil {
+		if w, ok := c.codec.(wholeCodec); ok {
+			b, _ := c.r.Peek(n)
+			return w.whole(b)
+		}
+		return false
+	}
+	if n < 7 {
+		return false
+	}
+	sz, _, _, err := c.r.header()
+	return err != nil || int64(n) >= sz
+}
+
+// writeChunk is the most of a Twrite's data the server holds at once.
+// Bigger Twrites are dispatched in pieces of this size as they arrive, so
+// that a connection's memory does not grow with msize.
+const writeChunk = 64 * 1024
+
+// streamWrite handles the next message if it is a Twrite of more than
+// writeChunk bytes, and reports whether it did. It passes the data to the
+// Dispatcher as a series of Twrites of at most writeChunk bytes, as it is
+// read. If one fails or is short, the rest of the data is read and
+// dropped, and the client is told how much was written, or the error if
+// nothing was. If the connection fails part way, what was already written
+// stays written, as with a short write, and the error is returned.
+// Codecs other than the 9P one have their messages read whole.
+func (c *conn) streamWrite() (bool, error) {
+	if c.codec != nil {
+		return false, nil
+	}
+	sz, t, tag, err := c.r.header()
+	if err != nil {
+		return false, err
+	}
+	if t != Twrite || sz <= writeChunk || !c.server.Versioned || tag == NOTAG {
+		return false, nil
+	}
+	if m := int64(c.server.msize); m > 0 && sz > m {
+		// body refuses it.
+		return false, nil
+	}
+	if _, err := c.r.Discard(7); err != nil {
+		return true, err
+	}
+	n := sz - 7
+	var h [4 + 8 + 4]byte
+	if _, err := io.ReadFull(c.r, h[:]); err != nil {
+		return true, err
+	}
+	n -= int64(len(h))
+	fid := h[0:4]
+	o := Offset(h[4]) | Offset(h[5])<<8 | Offset(h[6])<<16 | Offset(h[7])<<24 |
+		Offset(h[8])<<32 | Offset(h[9])<<40 | Offset(h[10])<<48 | Offset(h[11])<<56
+	if cnt := int64(h[12]) | int64(h[13])<<8 | int64(h[14])<<16 | int64(h[15])<<24; cnt != n {
+		return true, fmt.Errorf("Twrite: count %d, but %d bytes of data", cnt, n)
+	}
+
+	var b bytes.Buffer
+	var reply []byte
+	var total Count
+	for n > 0 {
+		m := n
+		if m > writeChunk {
+			m = writeChunk
+		}
+		at := o + Offset(total)
+		b.Reset()
+		b.Write([]byte{byte(tag), byte(tag >> 8), fid[0], fid[1], fid[2], fid[3],
+			byte(at), byte(at >> 8), byte(at >> 16), byte(at >> 24),
+			byte(at >> 32), byte(at >> 40), byte(at >> 48), byte(at >> 56),
+			byte(m), byte(m >> 8), byte(m >> 16), byte(m >> 24)})
+		if _, err := io.CopyN(&b, c.r, m); err != nil {
+			return true, err
+		}
+		n -= m
+		if err := c.dispatch(&b, Twrite); err != nil {
+			c.logf("%v: %v", RPCNames[Twrite], err)
+		}
+		if replyType(&b) != Rwrite {
+			reply = append([]byte{}, b.Bytes()...)
+			break
+		}
+		w, _, err := UnmarshalRwritePkt(bytes.NewBuffer(b.Bytes()[5:]))
+		if err != nil {
+			return true, err
+		}
+		total += w
+		if int64(w) < m {
+			break
+		}
+	}
+	if _, err := io.CopyN(ioutil.Discard, c.r, n); err != nil {
+		return true, err
+	}
+	if reply == nil || total > 0 {
+		MarshalRwritePkt(&b, tag, total)
+		reply = b.Bytes()
+	}
+	return true, c.write(reply)
 }
 
 // Dispatch dispatches request to different functions.
@@ -254,17 +798,17 @@ func Dispatch(s *Server, b *bytes.Buffer, t MType) error {
 		s.Versioned = true
 	default:
 		if !s.Versioned {
-			m := fmt.Sprintf("Dispatch: %v not allowed before Tversion", RPCNames[t])
+			err := Errorf(ErrInvalid, "Dispatch: %v before Tversion", RPCNames[t])
 			// Yuck. Provide helper.
 			d := b.Bytes()
-			MarshalRerrorPkt(b, Tag(d[0])|Tag(d[1])<<8, m)
-			return fmt.Errorf("Dispatch: %v not allowed before Tversion", RPCNames[t])
+			MarshalRerrorPkt(b, Tag(d[0])|Tag(d[1])<<8, err.Error())
+			return err
 		}
 	}
 
 	switch t {
 	case Tversion:
-		return s.SrvRversion(b)
+		return s.version(b)
 	case Tattach:
 		return s.SrvRattach(b)
 	case Tflush:
@@ -0,0 +1,454 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestStreamWrite(t *testing.T) {
+	c, ds := newDialectClient(t, "9P2000")
+	// The writes are bigger than the msize of 8192.
+	if _, _, err := c.CallTversion(MaxMsize, "9P2000"); err != nil {
+		t.Fatalf("CallTversion: want nil, got %v", err)
+	}
+	if _, err := c.CallTattach(0, NOFID, "glenda", ""); err != nil {
+		t.Fatalf("CallTattach: want nil, got %v", err)
+	}
+	if _, err := c.CallTwalk(0, 1, []string{"a"}); err != nil {
+		t.Fatalf("CallTwalk(a): want nil, got %v", err)
+	}
+
+	data := bytes.Repeat([]byte("0123456789abcdef"), 3*writeChunk/16+100)
+	var b bytes.Buffer
+	MarshalTwritePkt(&b, 0, 1, 1, data)
+	typ, r := rpc(c, &b)
+	if typ != Rwrite {
+		t.Fatalf("Twrite of %d bytes: want Rwrite, got %v", len(data), RPCNames[typ])
+	}
+	if n, _, _ := UnmarshalRwritePkt(r); int(n) != len(data) {
+		t.Errorf("Twrite of %d bytes: got count %d", len(data), n)
+	}
+	if !bytes.Equal(ds.data["a"][1:], data) {
+		t.Errorf("Twrite of %d bytes: data does not match", len(data))
+	}
+
+	// A short write stops at the first short piece, and the rest is
+	// skipped, leaving the connection usable.
+	ds.short = 10
+	MarshalTwritePkt(&b, 0, 1, 0, data)
+	typ, r = rpc(c, &b)
+	if n, _, _ := UnmarshalRwritePkt(r); typ != Rwrite || n != 10 {
+		t.Errorf("short Twrite: got %v count %d, want Rwrite 10", RPCNames[typ], n)
+	}
+	ds.short = 0
+
+	// An error with nothing written is the reply.
+	MarshalTwritePkt(&b, 0, 99, 0, data)
+	if typ, _ = rpc(c, &b); typ != Rerror {
+		t.Errorf("Twrite to bad fid: want Rerror, got %v", RPCNames[typ])
+	}
+	if err := c.CallTclunk(1); err != nil {
+		t.Errorf("CallTclunk after streamed writes: want nil, got %v", err)
+	}
+}
+
+func TestStreamWriteDisconnect(t *testing.T) {
+	ds := newDirServer()
+	s, err := NewListener(func() NineServer { return ds })
+	if err != nil {
+		t.Fatalf("NewListener: want nil, got %v", err)
+	}
+	p, p2 := net.Pipe()
+	if err := s.Accept(p2); err != nil {
+		t.Fatalf("Accept: want nil, got %v", err)
+	}
+	var b bytes.Buffer
+	for _, m := range []func(){
+		func() { MarshalTversionPkt(&b, NOTAG, MaxMsize, "9P2000") },
+		func() { MarshalTattachPkt(&b, 1, 0, NOFID, "glenda", "") },
+		func() { MarshalTwalkPkt(&b, 1, 0, 1, []string{"a"}) },
+	} {
+		m()
+		if _, err := p.Write(b.Bytes()); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+		if _, err := p.Read(make([]byte, 8192)); err != nil {
+			t.Fatalf("Read: %v", err)
+		}
+	}
+
+	// Send the first piece of a big Twrite, and hang up.
+	MarshalTwritePkt(&b, 1, 1, 0, make([]byte, 2*writeChunk))
+	if _, err := p.Write(b.Bytes()[:23+writeChunk+10]); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	p.Close()
+	for i := 0; ; i++ {
+		if _, err := p2.Read(make([]byte, 1)); err == io.ErrClosedPipe {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("server did not hang up")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(ds.data["a"]) != writeChunk {
+		t.Errorf("after disconnect: got %d bytes written, want %d", len(ds.data["a"]), writeChunk)
+	}
+}
+
+func TestConnMemory(t *testing.T) {
+	if _, err := NewListener(nil, func(l *Listener) error {
+		l.MaxConnMemory = 1000
+		return nil
+	}); err == nil {
+		t.Errorf("NewListener with MaxConnMemory 1000: want err, got nil")
+	}
+
+	ds := newDirServer()
+	ds.data["a"] = make([]byte, 4*minConnMemory)
+	c := newPackClient(t, "9P2000", ds, func(l *Listener) error {
+		l.MaxConnMemory = minConnMemory
+		return nil
+	})
+	if _, err := c.CallTattach(0, NOFID, "glenda", ""); err != nil {
+		t.Fatalf("CallTattach: want nil, got %v", err)
+	}
+	if _, err := c.CallTwalk(0, 1, []string{"a"}); err != nil {
+		t.Fatalf("CallTwalk(a): want nil, got %v", err)
+	}
+
+	// Big reads are cut short.
+	var b bytes.Buffer
+	MarshalTreadPkt(&b, 0, 1, 0, 3*minConnMemory)
+	typ, r := rpc(c, &b)
+	if typ != Rread {
+		t.Fatalf("Tread: want Rread, got %v", RPCNames[typ])
+	}
+	if d, _, _ := UnmarshalRreadPkt(r); len(d) == 0 || len(d) > minConnMemory-IOHDRSZ {
+		t.Errorf("Tread of %d: got %d bytes, want some, but less than %d", 3*minConnMemory, len(d), minConnMemory)
+	}
+
+	// Big writes are fine, since they are taken in pieces, so long as
+	// they fit the msize.
+	if _, _, err := c.CallTversion(MaxMsize, "9P2000"); err != nil {
+		t.Fatalf("CallTversion: want nil, got %v", err)
+	}
+	if _, err := c.CallTattach(0, NOFID, "glenda", ""); err != nil {
+		t.Fatalf("CallTattach: want nil, got %v", err)
+	}
+	if _, err := c.CallTwalk(0, 1, []string{"a"}); err != nil {
+		t.Fatalf("CallTwalk(a): want nil, got %v", err)
+	}
+	MarshalTwritePkt(&b, 0, 1, 0, make([]byte, 2*minConnMemory))
+	if typ, _ = rpc(c, &b); typ != Rwrite {
+		t.Errorf("Twrite of %d: want Rwrite, got %v", 2*minConnMemory, RPCNames[typ])
+	}
+
+	// Anything else that big ends the connection.
+	s, err := NewListener(func() NineServer { return newDirServer() }, func(l *Listener) error {
+		l.MaxConnMemory = minConnMemory
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("NewListener: want nil, got %v", err)
+	}
+	p, p2 := net.Pipe()
+	if err := s.Accept(p2); err != nil {
+		t.Fatalf("Accept: want nil, got %v", err)
+	}
+	MarshalTversionPkt(&b, NOTAG, 8192, "9P2000")
+	p.Write(b.Bytes())
+	if _, err := p.Read(make([]byte, 8192)); err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	MarshalTwalkPkt(&b, 1, 0, 2, []string{string(make([]byte, minConnMemory))})
+	p.Write(b.Bytes())
+	if n, err := p.Read(make([]byte, 8192)); err != io.EOF {
+		t.Errorf("Twalk of %d bytes: got %d bytes, %v, want EOF", b.Len(), n, err)
+	}
+}
+
+func TestMsizeLimit(t *testing.T) {
+	for _, sz := range []int{8192 - IOHDRSZ, 8192, 2 * writeChunk} {
+		c, _ := newDialectClient(t, "9P2000")
+		if _, err := c.CallTattach(0, NOFID, "glenda", ""); err != nil {
+			t.Fatalf("CallTattach: want nil, got %v", err)
+		}
+		if _, err := c.CallTwalk(0, 1, []string{"a"}); err != nil {
+			t.Fatalf("CallTwalk(a): want nil, got %v", err)
+		}
+		var b bytes.Buffer
+		MarshalTwritePkt(&b, 0, 1, 0, make([]byte, sz))
+		typ, _ := rpc(c, &b)
+		if want := b.Len() <= 8192; (typ == Rwrite) != want {
+			t.Errorf("Twrite of %d bytes with msize 8192: got %v, want Rwrite %v", b.Len(), RPCNames[typ], want)
+		}
+	}
+}
+
+func TestMaxMsize(t *testing.T) {
+	if _, err := NewListener(nil, func(l *Listener) error {
+		l.MaxMsize = IOHDRSZ
+		return nil
+	}); err == nil {
+		t.Errorf("NewListener with MaxMsize %d: want err, got nil", IOHDRSZ)
+	}
+
+	for _, tc := range []struct {
+		max, ask, want uint32
+	}{
+		{0, 4 * MaxMsize, MaxMsize},
+		{0, 4*1048576 + IOHDRSZ, 4*1048576 + IOHDRSZ},
+		{65536, MaxMsize, 65536},
+		{65536, 8192, 8192},
+	} {
+		ds := newDirServer()
+		ds.data["a"] = make([]byte, 2*MaxMsize)
+		s, err := NewListener(func() NineServer { return ds }, func(l *Listener) error {
+			l.MaxMsize = tc.max
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("NewListener: want nil, got %v", err)
+		}
+		c := pipeClient(t, s, func(c *Client) error {
+			c.Msize = tc.ask
+			return nil
+		})
+		msize, _, err := c.CallTversion(MaxSize(tc.ask), "9P2000")
+		if err != nil || uint32(msize) != tc.want {
+			t.Errorf("MaxMsize %d, Tversion %d: got %d, %v, want %d, nil", tc.max, tc.ask, msize, err, tc.want)
+			continue
+		}
+		if _, err := c.CallTattach(0, NOFID, "glenda", ""); err != nil {
+			t.Fatalf("CallTattach: want nil, got %v", err)
+		}
+		if _, err := c.CallTwalk(0, 1, []string{"a"}); err != nil {
+			t.Fatalf("CallTwalk(a): want nil, got %v", err)
+		}
+
+		// Reads are cut to fit the msize, whatever is asked for.
+		var b bytes.Buffer
+		MarshalTreadPkt(&b, 0, 1, 0, Count(2*MaxMsize))
+		typ, r := rpc(c, &b)
+		if typ != Rread {
+			t.Fatalf("Tread: want Rread, got %v", RPCNames[typ])
+		}
+		if d, _, _ := UnmarshalRreadPkt(r); len(d) != int(msize)-IOHDRSZ {
+			t.Errorf("msize %d: Tread of %d got %d bytes, want %d", msize, 2*MaxMsize, len(d), int(msize)-IOHDRSZ)
+		}
+	}
+}
+
+// countConn counts the writes to a net.Conn.
+type countConn struct {
+	net.Conn
+	writes chan int
+}
+
+func (c *countConn) Write(b []byte) (int, error) {
+	c.writes <- len(b)
+	return c.Conn.Write(b)
+}
+
+func TestCoalesce(t *testing.T) {
+	for _, window := range []time.Duration{0, 20 * time.Millisecond} {
+		s, err := NewListener(func() NineServer { return newDirServer() }, func(l *Listener) error {
+			l.ReplyWindow = window
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("NewListener: want nil, got %v", err)
+		}
+		p, p2 := net.Pipe()
+		cc := &countConn{Conn: p2, writes: make(chan int, 100)}
+		if err := s.Accept(cc); err != nil {
+			t.Fatalf("Accept: want nil, got %v", err)
+		}
+
+		// Requests sent together get their replies together.
+		var all, b bytes.Buffer
+		MarshalTversionPkt(&b, NOTAG, 8192, "9P2000")
+		all.Write(b.Bytes())
+		MarshalTattachPkt(&b, 1, 0, NOFID, "glenda", "")
+		all.Write(b.Bytes())
+		for i := 0; i < 5; i++ {
+			MarshalTstatPkt(&b, Tag(i+2), 0)
+			all.Write(b.Bytes())
+		}
+		start := time.Now()
+		if _, err := p.Write(all.Bytes()); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+		r := newFrameReader(p)
+		for i := 0; i < 7; i++ {
+			if _, err := r.frame(MaxMsize); err != nil {
+				t.Fatalf("reply %d: %v", i, err)
+			}
+		}
+		if d := time.Since(start); d < window {
+			t.Errorf("window %v: replies came after %v", window, d)
+		}
+		if n := len(cc.writes); n != 1 {
+			t.Errorf("window %v: 7 replies took %d writes, want 1", window, n)
+		}
+		p.Close()
+	}
+}
+
+// TestReplyWindowClock holds a reply for the window, which only passes
+// when the clock says so.
+func TestReplyWindowClock(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	s, err := NewListener(func() NineServer { return newDirServer() }, func(l *Listener) error {
+		l.ReplyWindow = time.Hour
+		l.Clock = clock
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("NewListener: want nil, got %v", err)
+	}
+	p, p2 := net.Pipe()
+	defer p.Close()
+	if err := s.Accept(p2); err != nil {
+		t.Fatalf("Accept: want nil, got %v", err)
+	}
+	if _, err := p.Write(marshal(func(b *bytes.Buffer) { MarshalTversionPkt(b, NOTAG, 8192, "9P2000") })); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	clock.Wait(1)
+	got := make(chan error)
+	go func() {
+		_, err := newFrameReader(p).frame(MaxMsize)
+		got <- err
+	}()
+	clock.Advance(time.Minute)
+	select {
+	case err := <-got:
+		t.Fatalf("reply a minute into an hour's window: got it, with %v", err)
+	default:
+	}
+	clock.Advance(time.Hour)
+	if err := <-got; err != nil {
+		t.Fatalf("reply after the window: want nil, got %v", err)
+	}
+}
+
+func TestMaxInFlight(t *testing.T) {
+	s, err := NewListener(func() NineServer { return newDirServer() }, func(l *Listener) error {
+		l.ReplyWindow = time.Hour
+		l.MaxInFlight = 4
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("NewListener: want nil, got %v", err)
+	}
+	p, p2 := net.Pipe()
+	defer p.Close()
+	cc := &countConn{Conn: p2, writes: make(chan int, 100)}
+	if err := s.Accept(cc); err != nil {
+		t.Fatalf("Accept: want nil, got %v", err)
+	}
+
+	// However long the window, no more than 4 replies wait in it.
+	var all, b bytes.Buffer
+	MarshalTversionPkt(&b, NOTAG, 8192, "9P2000")
+	all.Write(b.Bytes())
+	MarshalTattachPkt(&b, 1, 0, NOFID, "glenda", "")
+	all.Write(b.Bytes())
+	for i := 0; i < 6; i++ {
+		MarshalTstatPkt(&b, Tag(i+2), 0)
+		all.Write(b.Bytes())
+	}
+	if _, err := p.Write(all.Bytes()); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	p.SetReadDeadline(time.Now().Add(10 * time.Second))
+	r := newFrameReader(p)
+	for i := 0; i < 8; i++ {
+		if _, err := r.frame(MaxMsize); err != nil {
+			t.Fatalf("reply %d: %v", i, err)
+		}
+	}
+	if n := len(cc.writes); n != 2 {
+		t.Errorf("8 replies took %d writes, want 2", n)
+	}
+}
+
+func TestConns(t *testing.T) {
+	skipMinimal(t, "message counts")
+	s, err := NewListener(func() NineServer { return newDirServer() })
+	if err != nil {
+		t.Fatalf("NewListener: want nil, got %v", err)
+	}
+	var ps []net.Conn
+	for i := 0; i < 2; i++ {
+		p, p2 := net.Pipe()
+		if err := s.Accept(p2); err != nil {
+			t.Fatalf("Accept: want nil, got %v", err)
+		}
+		var b bytes.Buffer
+		MarshalTversionPkt(&b, NOTAG, 8192, "9P2000")
+		p.Write(b.Bytes())
+		if _, err := p.Read(make([]byte, 8192)); err != nil {
+			t.Fatalf("Read: %v", err)
+		}
+		ps = append(ps, p)
+	}
+
+	cs := s.Conns()
+	if len(cs) != 2 || cs[0].ID >= cs[1].ID || cs[0].Messages != 1 {
+		t.Fatalf("Conns: got %+v, want 2, oldest first, of 1 message", cs)
+	}
+	if err := s.Evict(cs[0].ID); err != nil {
+		t.Fatalf("Evict(%d): want nil, got %v", cs[0].ID, err)
+	}
+	if _, err := ps[0].Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Read from evicted connection: want EOF, got %v", err)
+	}
+	for i := 0; len(s.Conns()) != 1; i++ {
+		if i == 100 {
+			t.Fatalf("Conns after Evict: got %+v, want 1", s.Conns())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := s.Evict(cs[0].ID); err == nil {
+		t.Errorf("Evict(%d) again: want err, got nil", cs[0].ID)
+	}
+	if st := s.Stats(); st.Conns != 1 || st.Accepted != 2 || st.Messages != 2 {
+		t.Errorf("Stats: got %+v, want 1 connection of 2, 2 messages", st)
+	}
+	ps[1].Close()
+}
+
+func TestOnShutdown(t *testing.T) {
+	calls := 0
+	s, err := NewListener(func() NineServer { return newDirServer() }, func(l *Listener) error {
+		l.OnShutdown = func() error {
+			calls++
+			return errors.New("journal: bad sync")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("NewListener: want nil, got %v", err)
+	}
+	if err := s.Shutdown(); err == nil || err.Error() != "journal: bad sync" {
+		t.Errorf("Shutdown: got %v, want OnShutdown's error", err)
+	}
+	if err := s.Shutdown(); err != nil {
+		t.Errorf("Shutdown again: want nil, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("OnShutdown: got %d calls, want 1", calls)
+	}
+}
@@ -0,0 +1,617 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// A session carries a connection's bytes across the loss of the network
+// connection under it. Each side numbers the bytes it sends, and keeps
+// them until the other acknowledges them. When the connection drops, the
+// client dials again and says which session it was in and how much it
+// got, and the server says how much it got, and each sends again what
+// the other missed. Neither 9P end notices, so fids and outstanding
+// requests survive.
+//
+// The connection starts with a hello from the client,
+//
+//	magic[4] id[16] received[8]
+//
+// with an id of zeros for a new session, to which the server replies in
+// the same form, with the id of the session, or zeros if it does not
+// know it. After that, each side sends frames:
+//
+//	'd' len[4] data[len]	the next len bytes
+//	'a' received[8]	all bytes up to received arrived
+//	'c'	no more bytes will be sent
+//
+// Numbers are little endian.
+
+const (
+	sessionMagic = "9PS1"
+
+	// DefaultSessionTimeout is how long a session waits to be resumed.
+	DefaultSessionTimeout = 2 * time.Minute
+
+	// maxUnacked is how much a session sends before waiting for
+	// acknowledgement, which is also how much it may have to keep.
+	maxUnacked = 1 << 20
+
+	// maxSessionFrame is the most data a frame carries.
+	maxSessionFrame = 64 * 1024
+)
+
+type sessionID [16]byte
+
+// session is one end of a session, a net.Conn.
+type session struct {
+	id sessionID
+
+	// redial, on the client, makes a new connection to resume with.
+	redial func() (net.Conn, error)
+	// timeout is how long the session waits to be resumed.
+	timeout time.Duration
+	// done is called once the session is over.
+	done func()
+
+	// mu guards below
+	mu   sync.Mutex
+	cond *sync.Cond
+	// c is the connection under the session, nil while there is
+	// none. gen counts the connections.
+	c   net.Conn
+	gen int
+	// local and remote are the addresses of the first connection.
+	local, remote net.Addr
+
+	// Bytes sent are numbered from 0. unacked holds those from
+	// acked to sent, and wrote is how far the connection has got.
+	unacked []byte
+	acked   uint64
+	sent    uint64
+	wrote   uint64
+
+	// in holds bytes received, which number recvd so far, and
+	// ackSent is how many of them the other end has been told about.
+	in      bytes.Buffer
+	recvd   uint64
+	ackSent uint64
+
+	// closing is set when Close has been called, and closed when
+	// the session is over, with err saying why, if it wasn't Close.
+	closing bool
+	closed  bool
+	err     error
+
+	rd, wd time.Time
+	// lost is when the connection went, for the timeout.
+	lost *time.Timer
+}
+
+func newSession(id sessionID, timeout time.Duration) *session {
+	if timeout == 0 {
+		timeout = DefaultSessionTimeout
+	}
+	s := &session{id: id, timeout: timeout}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+// DialSession starts a session over a connection made by dial, which it
+// calls again to resume the session whenever the connection drops. If
+// it can't within timeout, or DefaultSessionTimeout if timeout is 0, the
+// session is over, and reads and writes fail. The server must be serving
+// a SessionListener.
+func DialSession(dial func() (net.Conn, error), timeout time.Duration) (net.Conn, error) {
+	c, err := dial()
+	if err != nil {
+		return nil, err
+	}
+	s := newSession(sessionID{}, timeout)
+	s.redial = dial
+	if err := s.resume(c); err != nil {
+		c.Close()
+		return nil, err
+	}
+	s.local, s.remote = c.LocalAddr(), c.RemoteAddr()
+	return s, nil
+}
+
+// resume has the client resume the session over c.
+func (s *session) resume(c net.Conn) error {
+	s.mu.Lock()
+	hello := sessionHello(s.id, s.recvd)
+	s.mu.Unlock()
+	c.SetDeadline(time.Now().Add(s.timeout))
+	if _, err := c.Write(hello); err != nil {
+		return err
+	}
+	br := bufio.NewReader(c)
+	id, recvd, err := readHello(br)
+	if err != nil {
+		return err
+	}
+	c.SetDeadline(time.Time{})
+	if id == (sessionID{}) {
+		return fmt.Errorf("session %x: unknown to the server", s.id)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.id = id
+	return s.attach(c, br, recvd)
+}
+
+// attach makes c, read through br, the session's connection, and
+// starts sending what the other end, which has received recvd bytes,
+// has not had. s.mu must be held.
+func (s *session) attach(c net.Conn, br *bufio.Reader, recvd uint64) error {
+	if s.closed {
+		return fmt.Errorf("session %x: over", s.id)
+	}
+	if recvd < s.acked || recvd > s.sent {
+		return fmt.Errorf("session %x: other end has %d bytes, but %d to %d were sent", s.id, recvd, s.acked, s.sent)
+	}
+	if s.c != nil {
+		s.c.Close()
+	}
+	if s.lost != nil {
+		s.lost.Stop()
+		s.lost = nil
+	}
+	s.unacked = s.unacked[recvd-s.acked:]
+	s.acked, s.wrote, s.ackSent = recvd, recvd, s.recvd
+	s.c = c
+	s.gen++
+	go s.reader(c, br, s.gen)
+	go s.sender(c, s.gen)
+	s.cond.Broadcast()
+	return nil
+}
+
+// lose drops the connection of generation gen, if it still is the
+// session's, and starts resuming, on the client, or waiting to be
+// resumed, on the server. s.mu must be held.
+func (s *session) lose(gen int, err error) {
+	if gen != s.gen || s.c == nil {
+		return
+	}
+	s.c.Close()
+	s.c = nil
+	s.cond.Broadcast()
+	if s.closed {
+		return
+	}
+	if s.closing && s.wrote == s.sent {
+		s.end(nil)
+		return
+	}
+	s.lost = time.AfterFunc(s.timeout, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.c == nil {
+			s.end(fmt.Errorf("session %x: not resumed in %v: %v", s.id, s.timeout, err))
+		}
+	})
+	if s.redial != nil {
+		go s.redialLoop(s.gen)
+	}
+}
+
+// redialLoop dials until the session is resumed or over.
+func (s *session) redialLoop(gen int) {
+	delay := 10 * time.Millisecond
+	for {
+		s.mu.Lock()
+		over := s.closed || s.gen != gen
+		s.mu.Unlock()
+		if over {
+			return
+		}
+		c, err := s.redial()
+		if err == nil {
+			if err = s.resume(c); err == nil {
+				return
+			}
+			c.Close()
+		}
+		time.Sleep(delay)
+		if delay *= 2; delay > time.Second {
+			delay = time.Second
+		}
+	}
+}
+
+// end ends the session. s.mu must be held.
+func (s *session) end(err error) {
+	if s.closed {
+		return
+	}
+	s.closed = true
+	s.err = err
+	if s.c != nil {
+		s.c.Close()
+		s.c = nil
+	}
+	if s.lost != nil {
+		s.lost.Stop()
+	}
+	s.cond.Broadcast()
+	if s.done != nil {
+		go s.done()
+	}
+}
+
+// reader takes frames from the connection of generation gen.
+func (s *session) reader(c net.Conn, br *bufio.Reader, gen int) {
+	for {
+		typ, err := br.ReadByte()
+		var n uint64
+		var data []byte
+		if err == nil {
+			switch typ {
+			case 'd':
+				var l uint32
+				if err = binary.Read(br, binary.LittleEndian, &l); err == nil && l > maxSessionFrame {
+					err = fmt.Errorf("frame of %d bytes", l)
+				}
+				if err == nil {
+					data = make([]byte, l)
+					_, err = io.ReadFull(br, data)
+				}
+			case 'a':
+				err = binary.Read(br, binary.LittleEndian, &n)
+			case 'c':
+			default:
+				err = fmt.Errorf("bad frame type %#x", typ)
+			}
+		}
+
+		s.mu.Lock()
+		if s.gen != gen || s.c != c {
+			// Anything more from a lost connection would be
+			// counted twice, once it has been resumed.
+			s.mu.Unlock()
+			return
+		}
+		if err != nil {
+			s.lose(gen, err)
+			s.mu.Unlock()
+			return
+		}
+		switch typ {
+		case 'd':
+			s.in.Write(data)
+			s.recvd += uint64(len(data))
+		case 'a':
+			if n > s.acked && n <= s.wrote {
+				s.unacked = s.unacked[n-s.acked:]
+				s.acked = n
+			}
+		case 'c':
+			s.end(nil)
+			s.mu.Unlock()
+			return
+		}
+		s.cond.Broadcast()
+		s.mu.Unlock()
+	}
+}
+
+// sender sends data and acknowledgements over the connection of
+// generation gen. It alone writes to the connection, so that no one
+// waits on a write while holding anything the reader needs.
+func (s *session) sender(c net.Conn, gen int) {
+	var b bytes.Buffer
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for {
+		for s.c == c && s.gen == gen && s.recvd == s.ackSent && s.wrote == s.sent && !s.closing {
+			s.cond.Wait()
+		}
+		if s.c != c || s.gen != gen {
+			return
+		}
+		b.Reset()
+		if s.recvd != s.ackSent {
+			b.WriteByte('a')
+			binary.Write(&b, binary.LittleEndian, s.recvd)
+			s.ackSent = s.recvd
+		}
+		if s.wrote != s.sent {
+			d := s.unacked[s.wrote-s.acked:]
+			if len(d) > maxSessionFrame {
+				d = d[:maxSessionFrame]
+			}
+			b.WriteByte('d')
+			binary.Write(&b, binary.LittleEndian, uint32(len(d)))
+			b.Write(d)
+			s.wrote += uint64(len(d))
+		}
+		last := s.closing && s.wrote == s.sent
+		if last {
+			b.WriteByte('c')
+		}
+		s.mu.Unlock()
+		_, err := c.Write(b.Bytes())
+		s.mu.Lock()
+		if err != nil {
+			s.lose(gen, err)
+			return
+		}
+		if last {
+			s.end(nil)
+			return
+		}
+	}
+}
+
+// wait waits on s.cond until the deadline t, if it is set, and reports
+// whether it has passed. s.mu must be held.
+func (s *session) wait(t time.Time) bool {
+	if t.IsZero() {
+		s.cond.Wait()
+		return false
+	}
+	d := time.Until(t)
+	if d <= 0 {
+		return true
+	}
+	tm := time.AfterFunc(d, func() {
+		s.mu.Lock()
+		s.cond.Broadcast()
+		s.mu.Unlock()
+	})
+	s.cond.Wait()
+	tm.Stop()
+	return !time.Now().Before(t)
+}
+
+func (s *session) Read(b []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for s.in.Len() == 0 {
+		switch {
+		case s.closing:
+			return 0, net.ErrClosed
+		case s.closed && s.err != nil:
+			return 0, s.err
+		case s.closed:
+			return 0, io.EOF
+		}
+		if s.wait(s.rd) {
+			return 0, errSessionTimeout
+		}
+	}
+	return s.in.Read(b)
+}
+
+func (s *session) Write(b []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var tot int
+	for tot < len(b) {
+		switch {
+		case s.closing:
+			return tot, net.ErrClosed
+		case s.closed && s.err != nil:
+			return tot, s.err
+		case s.closed:
+			return tot, io.ErrClosedPipe
+		}
+		room := maxUnacked - len(s.unacked)
+		if room <= 0 {
+			if s.wait(s.wd) {
+				return tot, errSessionTimeout
+			}
+			continue
+		}
+		if room > len(b)-tot {
+			room = len(b) - tot
+		}
+		s.unacked = append(s.unacked, b[tot:tot+room]...)
+		s.sent += uint64(room)
+		tot += room
+		s.cond.Broadcast()
+	}
+	return tot, nil
+}
+
+// Close ends the session, once what has been written has been sent.
+func (s *session) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closing {
+		return net.ErrClosed
+	}
+	s.closing = true
+	if s.c == nil && !s.closed {
+		// There is no one to tell; the other end will time out.
+		s.end(nil)
+	}
+	s.cond.Broadcast()
+	return nil
+}
+
+func (s *session) LocalAddr() net.Addr  { return s.local }
+func (s *session) RemoteAddr() net.Addr { return s.remote }
+
+func (s *session) SetDeadline(t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rd, s.wd = t, t
+	s.cond.Broadcast()
+	return nil
+}
+
+func (s *session) SetReadDeadline(t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rd = t
+	s.cond.Broadcast()
+	return nil
+}
+
+func (s *session) SetWriteDeadline(t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.wd = t
+	s.cond.Broadcast()
+	return nil
+}
+
+// sessionTimeout is the error for a passed deadline.
+type sessionTimeout struct{}
+
+func (sessionTimeout) Error() string   { return "i/o timeout" }
+func (sessionTimeout) Timeout() bool   { return true }
+func (sessionTimeout) Temporary() bool { return true }
+
+var errSessionTimeout net.Error = sessionTimeout{}
+
+func sessionHello(id sessionID, recvd uint64) []byte {
+	b := append([]byte(sessionMagic), id[:]...)
+	var n [8]byte
+	binary.LittleEndian.PutUint64(n[:], recvd)
+	return append(b, n[:]...)
+}
+
+func readHello(r io.Reader) (sessionID, uint64, error) {
+	var b [4 + 16 + 8]byte
+	var id sessionID
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return id, 0, err
+	}
+	if string(b[:4]) != sessionMagic {
+		return id, 0, fmt.Errorf("not a session: %q", b[:4])
+	}
+	copy(id[:], b[4:20])
+	return id, binary.LittleEndian.Uint64(b[20:]), nil
+}
+
+// sessionListener accepts sessions.
+type sessionListener struct {
+	net.Listener
+	timeout time.Duration
+	accept  chan net.Conn
+	// done is closed, and err set, when ln fails.
+	done chan struct{}
+	err  error
+
+	// mu guards below
+	mu       sync.Mutex
+	sessions map[sessionID]*session
+}
+
+// SessionListener accepts sessions started by DialSession on ln, and
+// resumes them on the connections which come in later. Accept returns
+// only new sessions. A session not resumed within timeout, or
+// DefaultSessionTimeout if timeout is 0, is over, and reads and writes
+// on it fail.
+func SessionListener(ln net.Listener, timeout time.Duration) net.Listener {
+	if timeout == 0 {
+		timeout = DefaultSessionTimeout
+	}
+	l := &sessionListener{
+		Listener: ln,
+		timeout:  timeout,
+		accept:   make(chan net.Conn),
+		done:     make(chan struct{}),
+		sessions: make(map[sessionID]*session),
+	}
+	go l.serve()
+	return l
+}
+
+func (l *sessionListener) serve() {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			l.err = err
+			close(l.done)
+			return
+		}
+		go l.hello(c)
+	}
+}
+
+// hello starts or resumes a session on c.
+func (l *sessionListener) hello(c net.Conn) {
+	c.SetDeadline(time.Now().Add(l.timeout))
+	br := bufio.NewReader(c)
+	id, recvd, err := readHello(br)
+	if err != nil {
+		c.Close()
+		return
+	}
+	c.SetDeadline(time.Time{})
+
+	fresh := id == sessionID{}
+	l.mu.Lock()
+	s, ok := l.sessions[id]
+	if fresh {
+		if _, err := rand.Read(id[:]); err != nil {
+			l.mu.Unlock()
+			c.Close()
+			return
+		}
+		s = newSession(id, l.timeout)
+		s.local, s.remote = c.LocalAddr(), c.RemoteAddr()
+		s.done = func() {
+			l.mu.Lock()
+			delete(l.sessions, id)
+			l.mu.Unlock()
+		}
+		l.sessions[id] = s
+	}
+	l.mu.Unlock()
+	if !fresh && !ok {
+		c.Write(sessionHello(sessionID{}, 0))
+		c.Close()
+		return
+	}
+
+	s.mu.Lock()
+	hello := sessionHello(id, s.recvd)
+	if _, err := c.Write(hello); err != nil {
+		s.mu.Unlock()
+		c.Close()
+		return
+	}
+	err = s.attach(c, br, recvd)
+	s.mu.Unlock()
+	if err != nil {
+		c.Close()
+		return
+	}
+	if fresh {
+		select {
+		case l.accept <- s:
+		case <-l.done:
+			s.Close()
+		}
+	}
+}
+
+func (l *sessionListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.accept:
+		return c, nil
+	case <-l.done:
+		return nil, l.err
+	}
+}
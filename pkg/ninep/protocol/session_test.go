@@ -0,0 +1,189 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyDialer dials addr, and can break the connection it made last.
+type flakyDialer struct {
+	addr string
+
+	// mu guards below
+	mu   sync.Mutex
+	last net.Conn
+	down bool
+}
+
+func (d *flakyDialer) dial() (net.Conn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.down {
+		return nil, fmt.Errorf("network is down")
+	}
+	c, err := net.Dial("tcp", d.addr)
+	if err != nil {
+		return nil, err
+	}
+	d.last = c
+	return c, nil
+}
+
+func (d *flakyDialer) blip() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.last != nil {
+		d.last.Close()
+	}
+}
+
+func sessionListen(t *testing.T, timeout time.Duration) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("no loopback: %v", err)
+	}
+	return SessionListener(ln, timeout)
+}
+
+func TestSessionResume(t *testing.T) {
+	ln := sessionListen(t, 0)
+	s, err := NewListener(func() NineServer { return newDirServer() })
+	if err != nil {
+		t.Fatalf("NewListener: want nil, got %v", err)
+	}
+	go s.Serve(ln)
+	defer s.Shutdown()
+
+	d := &flakyDialer{addr: ln.Addr().String()}
+	conn, err := DialSession(d.dial, 0)
+	if err != nil {
+		t.Fatalf("DialSession: want nil, got %v", err)
+	}
+	c, err := NewClient(func(c *Client) error {
+		c.FromNet, c.ToNet = conn, conn
+		c.Msize = 8192
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("NewClient: want nil, got %v", err)
+	}
+	if _, _, err := c.CallTversion(8192, "9P2000"); err != nil {
+		t.Fatalf("CallTversion: want nil, got %v", err)
+	}
+	if _, err := c.CallTattach(0, NOFID, "glenda", ""); err != nil {
+		t.Fatalf("CallTattach: want nil, got %v", err)
+	}
+	if _, err := c.CallTwalk(0, 1, []string{"a"}); err != nil {
+		t.Fatalf("CallTwalk(a): want nil, got %v", err)
+	}
+
+	// The fids outlive the connection.
+	for i := 0; i < 3; i++ {
+		d.blip()
+		if _, err := c.CallTstat(1); err != nil {
+			t.Errorf("CallTstat after blip %d: want nil, got %v", i, err)
+		}
+	}
+	conn.Close()
+}
+
+// TestSessionStream echoes random data through a session whose
+// connection keeps breaking, and checks that it all comes back, in order.
+func TestSessionStream(t *testing.T) {
+	ln := sessionListen(t, 0)
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(c, c)
+				c.Close()
+			}()
+		}
+	}()
+
+	d := &flakyDialer{addr: ln.Addr().String()}
+	conn, err := DialSession(d.dial, 0)
+	if err != nil {
+		t.Fatalf("DialSession: want nil, got %v", err)
+	}
+	defer conn.Close()
+
+	r := rand.New(rand.NewSource(4))
+	data := make([]byte, 3*maxUnacked)
+	r.Read(data)
+	go func() {
+		for b := data; len(b) > 0; {
+			n := 1 + r.Intn(100000)
+			if n > len(b) {
+				n = len(b)
+			}
+			if _, err := conn.Write(b[:n]); err != nil {
+				t.Errorf("Write: %v", err)
+				return
+			}
+			b = b[n:]
+		}
+	}()
+	stop := make(chan bool)
+	defer close(stop)
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(5 * time.Millisecond):
+				d.blip()
+			}
+		}
+	}()
+
+	got := make([]byte, len(data))
+	conn.SetReadDeadline(time.Now().Add(30 * time.Second))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatalf("ReadFull: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("echoed data does not match")
+	}
+}
+
+func TestSessionTimeout(t *testing.T) {
+	ln := sessionListen(t, 0)
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(c, c)
+		}
+	}()
+
+	d := &flakyDialer{addr: ln.Addr().String()}
+	conn, err := DialSession(d.dial, 100*time.Millisecond)
+	if err != nil {
+		t.Fatalf("DialSession: want nil, got %v", err)
+	}
+	d.mu.Lock()
+	d.down = true
+	d.mu.Unlock()
+	d.blip()
+	if _, err := conn.Read(make([]byte, 1)); err == nil || err == io.EOF {
+		t.Errorf("Read with no way to resume: want a timeout err, got %v", err)
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import "strings"
+
+// A Snapshotter is a NineServer which keeps point-in-time views of its
+// files. A client attaches to one with an aname of the form
+// "export@snap": the server splits the aname, calls RattachSnapshot,
+// and refuses anything which would change a file under the attach, so
+// the NineServer need not.
+type Snapshotter interface {
+	// RattachSnapshot is Rattach, to the tree aname as it was in the
+	// snapshot snap.
+	RattachSnapshot(fid, afid FID, uname, aname, snap string) (QID, error)
+}
+
+// errSnapshot is the error for changes to a snapshot. It reads as
+// EROFS in 9P2000.L.
+var errSnapshot = Errorf(ErrReadOnly, "snapshot")
+
+// splitSnapshot splits aname at its last @ into the export and the
+// snapshot of it. ok is false if aname names no snapshot.
+func splitSnapshot(aname string) (export, snap string, ok bool) {
+	i := strings.LastIndex(aname, "@")
+	if i < 0 || i == len(aname)-1 {
+		return aname, "", false
+	}
+	return aname[:i], aname[i+1:], true
+}
+
+// snapServer serves the NineServer of one connection, a Snapshotter,
+// routing snapshot attaches to it and keeping track of the fids in
+// snapshots, which are read-only.
+type snapServer struct {
+	NineServer
+	snap Snapshotter
+
+	// fids are the fids in a snapshot. Dispatch is serial, so no
+	// lock is needed.
+	fids map[FID]bool
+}
+
+// newSnapServer returns ns, wrapped in a snapServer if it is a
+// Snapshotter.
+func newSnapServer(ns NineServer) NineServer {
+	snap, ok := ns.(Snapshotter)
+	if !ok {
+		return ns
+	}
+	return &snapServer{NineServer: ns, snap: snap, fids: make(map[FID]bool)}
+}
+
+func (s *snapServer) Rattach(fid, afid FID, uname, aname string) (QID, error) {
+	export, snap, ok := splitSnapshot(aname)
+	if !ok {
+		delete(s.fids, fid)
+		return s.NineServer.Rattach(fid, afid, uname, aname)
+	}
+	q, err := s.snap.RattachSnapshot(fid, afid, uname, export, snap)
+	if err == nil {
+		s.fids[fid] = true
+	}
+	return q, err
+}
+
+func (s *snapServer) Rwalk(fid, newfid FID, names []string) ([]QID, error) {
+	q, err := s.NineServer.Rwalk(fid, newfid, names)
+	// newfid only exists once every name has been walked.
+	if err == nil && len(q) == len(names) && s.fids[fid] {
+		s.fids[newfid] = true
+	}
+	return q, err
+}
+
+func (s *snapServer) Ropen(fid FID, mode Mode) (QID, MaxSize, error) {
+	if s.fids[fid] && mode.Writes() {
+		return QID{}, 0, errSnapshot
+	}
+	return s.NineServer.Ropen(fid, mode)
+}
+
+func (s *snapServer) Rcreate(fid FID, name string, perm Perm, mode Mode) (QID, MaxSize, error) {
+	if s.fids[fid] {
+		return QID{}, 0, errSnapshot
+	}
+	return s.NineServer.Rcreate(fid, name, perm, mode)
+}
+
+func (s *snapServer) Rwstat(fid FID, b []byte) error {
+	if s.fids[fid] {
+		return errSnapshot
+	}
+	return s.NineServer.Rwstat(fid, b)
+}
+
+func (s *snapServer) Rwrite(fid FID, o Offset, b []byte) (Count, error) {
+	if s.fids[fid] {
+		return 0, errSnapshot
+	}
+	return s.NineServer.Rwrite(fid, o, b)
+}
+
+func (s *snapServer) Rclunk(fid FID) error {
+	delete(s.fids, fid)
+	return s.NineServer.Rclunk(fid)
+}
+
+// Rremove of a file in a snapshot fails, but, as for any failed
+// remove, the fid is clunked.
+func (s *snapServer) Rremove(fid FID) error {
+	if !s.fids[fid] {
+		return s.NineServer.Rremove(fid)
+	}
+	delete(s.fids, fid)
+	s.NineServer.Rclunk(fid)
+	return errSnapshot
+}
+
+// Hangup passes the end of the connection on to the NineServer, if it
+// wants to know.
+func (s *snapServer) Hangup() {
+	if h, ok := s.NineServer.(HangupServer); ok {
+		h.Hangup()
+	}
+}
+
+// Rcopy copies with the NineServer's Rcopy, if it has one, so that
+// wrapping it doesn't hide it.
+func (s *snapServer) Rcopy(fid FID, o Offset, dfid FID, do Offset, count uint64) (uint64, error) {
+	if s.fids[dfid] {
+		return 0, errSnapshot
+	}
+	if c, ok := s.NineServer.(Copier); ok {
+		return c.Rcopy(fid, o, dfid, do, count)
+	}
+	return CopyByReads(s.NineServer, fid, o, dfid, do, count)
+}
+
+// Rreadlink reads links with the NineServer's Rreadlink, if it has one.
+func (s *snapServer) Rreadlink(fid FID) (string, error) {
+	if r, ok := s.NineServer.(Readlinker); ok {
+		return r.Rreadlink(fid)
+	}
+	return "", Errorf(ErrNotSupported, "Treadlink")
+}
+
+// Wait holds reads as the NineServer's Wait does, if it has one.
+func (s *snapServer) Wait(fid FID, o Offset) <-chan struct{} {
+	if w, ok := s.NineServer.(Waiter); ok {
+		return w.Wait(fid, o)
+	}
+	return nil
+}
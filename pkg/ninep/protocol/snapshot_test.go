@@ -0,0 +1,29 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import "testing"
+
+func TestSplitSnapshot(t *testing.T) {
+	for _, tc := range []struct {
+		aname, export, snap string
+		ok                  bool
+	}{
+		{"", "", "", false},
+		{"usr", "usr", "", false},
+		{"usr@monday", "usr", "monday", true},
+		{"@monday", "", "monday", true},
+		{"usr@", "usr@", "", false},
+		{"me@home@monday", "me@home", "monday", true},
+	} {
+		export, snap, ok := splitSnapshot(tc.aname)
+		if export != tc.export || snap != tc.snap || ok != tc.ok {
+			t.Errorf("splitSnapshot(%q): got %q, %q, %v, want %q, %q, %v", tc.aname, export, snap, ok, tc.export, tc.snap, tc.ok)
+		}
+	}
+	if e := errno(errSnapshot.Error()); e != EROFS {
+		t.Errorf("errno(%q): got %d, want EROFS", errSnapshot, e)
+	}
+}
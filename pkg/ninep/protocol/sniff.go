@@ -0,0 +1,79 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// ErrNot9P is the error, wrapped, for a peer whose first message is not
+// a 9P one, e.g. after dialing a web server's port by mistake.
+var ErrNot9P = errors.New("peer does not speak 9P")
+
+// sniffs are the starts of other protocols' first messages, from either
+// end, which a 9P peer is most likely to be mistakenly talking to.
+var sniffs = []struct {
+	prefix string
+	name   string
+}{
+	{"HTTP/", "HTTP"},
+	{"GET ", "HTTP"},
+	{"HEAD ", "HTTP"},
+	{"POST ", "HTTP"},
+	{"PUT ", "HTTP"},
+	{"OPTIONS ", "HTTP"},
+	{"PRI * HTTP/2", "HTTP/2"},
+	{"\x16\x03", "TLS"},
+	{"SSH-", "SSH"},
+	{"220 ", "SMTP or FTP"},
+	{"220-", "SMTP or FTP"},
+	{"* OK", "IMAP"},
+	{"+OK", "POP3"},
+}
+
+// sniff names the protocol which the first bytes of a connection, b,
+// look like, or "" if none.
+func sniff(b []byte) string {
+	for _, s := range sniffs {
+		n := len(s.prefix)
+		if n > len(b) {
+			n = len(b)
+		}
+		if n > 0 && bytes.Equal(b[:n], []byte(s.prefix[:n])) {
+			return s.name
+		}
+	}
+	return ""
+}
+
+// not9P returns the error for a peer whose first message starts with h.
+func not9P(h []byte) error {
+	if n := sniff(h); n != "" {
+		return fmt.Errorf("%w: first bytes look like %v", ErrNot9P, n)
+	}
+	return fmt.Errorf("%w: first bytes are %q", ErrNot9P, h)
+}
+
+// checkFirst checks that the next message in f, the first on its
+// connection, is plausibly 9P: at most max bytes, and of a type ok
+// allows. Only the header is read.
+func checkFirst(f frameReader, max int64, ok func(MType) bool) error {
+	h, err := f.Peek(7)
+	if err != nil {
+		// Fewer than 7 bytes and then nothing is no message either,
+		// but there may be enough to say what it is.
+		if len(h) > 0 {
+			return not9P(h)
+		}
+		return err
+	}
+	sz := int64(h[0]) | int64(h[1])<<8 | int64(h[2])<<16 | int64(h[3])<<24
+	if sz < 7 || sz > max || !ok(MType(h[4])) {
+		return not9P(h)
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestSniff(t *testing.T) {
+	for _, tt := range []struct {
+		b    string
+		want string
+	}{
+		{"HTTP/1.1 400 Bad Request\r\n", "HTTP"},
+		{"GET / HTTP/1.1\r\n", "HTTP"},
+		{"\x16\x03\x01\x02\x00\x01\x00", "TLS"},
+		{"SSH-2.0-OpenSSH_8.4\r\n", "SSH"},
+		{"SS", "SSH"},
+		{"\x13\x00\x00\x00\x65\xff\xff", ""},
+		{"", ""},
+	} {
+		if got := sniff([]byte(tt.b)); got != tt.want {
+			t.Errorf("sniff(%q): got %q, want %q", tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestNot9PServer(t *testing.T) {
+	for _, tt := range []struct {
+		reply string
+		want  string
+	}{
+		{"HTTP/1.1 400 Bad Request\r\n\r\n", "look like HTTP"},
+		{"SSH-2.0-OpenSSH_8.4\r\n", "look like SSH"},
+		// A plausible size, but an Rwalk can't come first.
+		{"\x09\x00\x00\x00\x6f\xff\xff\x00\x00", "first bytes are"},
+	} {
+		p, p2 := net.Pipe()
+		go func() {
+			p2.Read(make([]byte, 512))
+			p2.Write([]byte(tt.reply))
+			io.Copy(ioutil.Discard, p2)
+		}()
+		c, err := NewClient(func(c *Client) error {
+			c.FromNet, c.ToNet = p, p
+			c.Msize = 8192
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		_, _, err = c.CallTversion(8192, "9P2000")
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("CallTversion to a server replying %q: got %v, want an error with %q", tt.reply, err, tt.want)
+		}
+		if err := c.Err(); !errors.Is(err, ErrNot9P) {
+			t.Errorf("Err: got %v, want ErrNot9P", err)
+		}
+		p2.Close()
+	}
+}
+
+func TestNot9PClient(t *testing.T) {
+	s, err := NewListener(func() NineServer { return newDirServer() })
+	if err != nil {
+		t.Fatalf("NewListener: want nil, got %v", err)
+	}
+	p, p2 := net.Pipe()
+	if err := s.Accept(p2); err != nil {
+		t.Fatalf("Accept: want nil, got %v", err)
+	}
+	go p.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	// The server hangs up, rather than waiting for 500MB of message.
+	if _, err := p.Read(make([]byte, 1)); err == nil {
+		t.Errorf("Read from the server: want error, got nil")
+	}
+}
@@ -0,0 +1,251 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+)
+
+// The sum extension has the server hash blocks of an open fid, so that a
+// client can find which parts of a file differ from a copy it has, as
+// rsync does, without reading the file:
+//
+//	size[4] Tsum tag[2] fid[4] offset[8] count[8] bsize[4]
+//	size[4] Rsum tag[2] n[2] n*(sha256[32])
+//
+// Each hash is the SHA-256 of bsize bytes, from offset on, but the last,
+// at the end of the file, may be of fewer. The server may hash fewer
+// blocks than count covers, at most maxSumBlocks; 0 is the end of the
+// file. Clients must ask for few enough that the reply fits in msize.
+const (
+	Tsum MType = 164 + iota
+	Rsum
+)
+
+// SumExtension is the name of the sum extension.
+const SumExtension = "sum"
+
+const (
+	// maxSumBlocks bounds the work, and reply, of a Tsum.
+	maxSumBlocks = 4096
+	// maxSumBlock bounds the size of a block.
+	maxSumBlock = 1 << 24
+)
+
+func init() {
+	if err := RegisterExtension(&Extension{
+		Name:     SumExtension,
+		Handlers: map[MType]Dispatcher{Tsum: dispatchSum},
+		Names:    map[MType]string{Tsum: "Tsum", Rsum: "Rsum"},
+	}); err != nil {
+		panic(err)
+	}
+}
+
+// MarshalTsumPkt puts a Tsum in b.
+func MarshalTsumPkt(b *bytes.Buffer, t Tag, fid FID, offset Offset, count uint64, bsize uint32) {
+	b.Reset()
+	b.Write([]byte{0, 0, 0, 0, uint8(Tsum), byte(t), byte(t >> 8)})
+	put32(b, uint32(fid))
+	put64(b, uint64(offset))
+	put64(b, count)
+	put32(b, bsize)
+	putSize(b)
+}
+
+// MarshalRsumPkt puts an Rsum in b.
+func MarshalRsumPkt(b *bytes.Buffer, t Tag, sums [][sha256.Size]byte) {
+	b.Reset()
+	b.Write([]byte{0, 0, 0, 0, uint8(Rsum), byte(t), byte(t >> 8), byte(len(sums)), byte(len(sums) >> 8)})
+	for _, s := range sums {
+		b.Write(s[:])
+	}
+	putSize(b)
+}
+
+func dispatchSum(s *Server, b *bytes.Buffer, t MType) error {
+	// tag[2] fid[4] offset[8] count[8] bsize[4]
+	d := b.Bytes()
+	var tag Tag
+	if len(d) >= 2 {
+		tag = Tag(d[0]) | Tag(d[1])<<8
+	}
+	if len(d) != 26 {
+		err := Errorf(ErrInvalid, "Tsum: %d bytes, want 26", len(d))
+		MarshalRerrorPkt(b, tag, err.Error())
+		return err
+	}
+	fid := FID(d[2]) | FID(d[3])<<8 | FID(d[4])<<16 | FID(d[5])<<24
+	o := Offset(get64(d[6:]))
+	count := get64(d[14:])
+	bsize := uint64(d[22]) | uint64(d[23])<<8 | uint64(d[24])<<16 | uint64(d[25])<<24
+	if bsize == 0 || bsize > maxSumBlock {
+		MarshalRerrorPkt(b, tag, Errorf(ErrInvalid, "Tsum: block size %d, want 1 to %d", bsize, maxSumBlock).Error())
+		return nil
+	}
+
+	var sums [][sha256.Size]byte
+	for n := uint64(0); n < count && len(sums) < maxSumBlocks; n += bsize {
+		l := count - n
+		if l > bsize {
+			l = bsize
+		}
+		h := sha256.New()
+		var got uint64
+		for got < l {
+			c := l - got
+			if c > writeChunk {
+				c = writeChunk
+			}
+			data, err := s.NS.Rread(fid, o+Offset(n+got), Count(c))
+			if err != nil {
+				if len(sums) == 0 && got == 0 {
+					MarshalRerrorPkt(b, tag, err.Error())
+					return nil
+				}
+				break
+			}
+			if len(data) == 0 {
+				break
+			}
+			h.Write(data)
+			got += uint64(len(data))
+		}
+		if got == 0 {
+			break
+		}
+		var sum [sha256.Size]byte
+		h.Sum(sum[:0])
+		sums = append(sums, sum)
+		if got < l {
+			break
+		}
+	}
+	MarshalRsumPkt(b, tag, sums)
+	return nil
+}
+
+// Sums returns the SHA-256 of each block of bsize bytes of the open fid
+// from offset to offset+count, or the end of the file, the last block
+// being short if the file ends in it. If the server agreed to the sum
+// extension it hashes them; otherwise they are read and hashed here.
+func (c *Client) Sums(fid FID, offset, count int64, bsize int) ([][sha256.Size]byte, error) {
+	if bsize <= 0 || bsize > maxSumBlock {
+		return nil, fmt.Errorf("block size %d, want 1 to %d", bsize, maxSumBlock)
+	}
+	if !c.HasExtension(SumExtension) {
+		return sumReader(&clientReaderAt{c: c, fid: fid}, offset, count, bsize)
+	}
+	// As many blocks as fit in the reply.
+	per := (int64(c.Msize) - 9) / sha256.Size
+	if per < 1 {
+		return nil, fmt.Errorf("msize %d is too small for Tsum", c.Msize)
+	}
+	var sums [][sha256.Size]byte
+	for n := int64(0); n < count; {
+		m := count - n
+		if max := per * int64(bsize); m > max {
+			m = max
+		}
+		var b bytes.Buffer
+		MarshalTsumPkt(&b, 0, fid, Offset(offset+n), uint64(m), uint32(bsize))
+		r := make(chan []byte)
+		c.FromClient <- &RPCCall{b: b.Bytes(), Reply: r}
+		bb := <-r
+		if err := replyErr(bb); err != nil {
+			return sums, err
+		}
+		if MType(bb[4]) != Rsum || len(bb) < 9 {
+			return sums, fmt.Errorf("Tsum: got %v, want Rsum", RPCNames[MType(bb[4])])
+		}
+		k := int(bb[7]) | int(bb[8])<<8
+		if len(bb) != 9+k*sha256.Size {
+			return sums, fmt.Errorf("Rsum: %d bytes for %d sums", len(bb), k)
+		}
+		for i := 0; i < k; i++ {
+			var s [sha256.Size]byte
+			copy(s[:], bb[9+i*sha256.Size:])
+			sums = append(sums, s)
+		}
+		if k == 0 {
+			break
+		}
+		n += int64(k) * int64(bsize)
+	}
+	return sums, nil
+}
+
+// clientReaderAt reads an open fid.
+type clientReaderAt struct {
+	c   *Client
+	fid FID
+}
+
+func (r *clientReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	var n int
+	for n < len(p) {
+		m := len(p) - n
+		if max := int(r.c.Msize) - IOHDRSZ; m > max {
+			m = max
+		}
+		d, err := r.c.CallTread(r.fid, Offset(off+int64(n)), Count(m))
+		n += copy(p[n:], d)
+		if err != nil {
+			return n, err
+		}
+		if len(d) == 0 {
+			return n, io.EOF
+		}
+	}
+	return n, nil
+}
+
+// sumReader hashes blocks of r, as Sums does.
+func sumReader(r io.ReaderAt, offset, count int64, bsize int) ([][sha256.Size]byte, error) {
+	var sums [][sha256.Size]byte
+	b := make([]byte, bsize)
+	for n := int64(0); n < count; n += int64(bsize) {
+		if int64(len(b)) > count-n {
+			b = b[:count-n]
+		}
+		m, err := r.ReadAt(b, offset+n)
+		if err != nil && err != io.EOF {
+			return sums, err
+		}
+		if m == 0 {
+			break
+		}
+		sums = append(sums, sha256.Sum256(b[:m]))
+		if m < len(b) {
+			break
+		}
+	}
+	return sums, nil
+}
+
+// ChangedBlocks compares the first size bytes of the open fid with local,
+// a copy of them, in blocks of bsize bytes, and returns the offsets of
+// the blocks which differ, including those in only one of them, so that
+// only those need be sent.
+func (c *Client) ChangedBlocks(fid FID, local io.ReaderAt, size int64, bsize int) ([]int64, error) {
+	remote, err := c.Sums(fid, 0, size, bsize)
+	if err != nil {
+		return nil, err
+	}
+	mine, err := sumReader(local, 0, size, bsize)
+	if err != nil {
+		return nil, err
+	}
+	var changed []int64
+	for i := 0; i < len(remote) || i < len(mine); i++ {
+		if i >= len(remote) || i >= len(mine) || remote[i] != mine[i] {
+			changed = append(changed, int64(i)*int64(bsize))
+		}
+	}
+	return changed, nil
+}
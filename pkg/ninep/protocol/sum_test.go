@@ -0,0 +1,67 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"testing"
+)
+
+func TestSums(t *testing.T) {
+	big := bytes.Repeat([]byte("abcdefghij"), 100)
+	for _, exts := range [][]string{{SumExtension}, nil} {
+		ds := newDirServer()
+		ds.files["b"] = &Dir{QID: QID{Path: 3}, Mode: 0644, Length: uint64(len(big)), Name: "b", User: "1000", Group: "1000", ModUser: "glenda"}
+		ds.data["b"] = big
+		c := newPackClient(t, "9P2000", ds)
+		if _, _, got, err := c.Version(8192, "9P2000", exts...); err != nil || len(got) != len(exts) {
+			t.Fatalf("Version(%v): got %v, %v, want %v", exts, got, err, exts)
+		}
+		if _, err := c.CallTattach(0, NOFID, "glenda", ""); err != nil {
+			t.Fatalf("CallTattach: want nil, got %v", err)
+		}
+		a, err := c.Open(0, []string{"a"}, OREAD)
+		if err != nil {
+			t.Fatalf("Open(a): want nil, got %v", err)
+		}
+		sums, err := c.Sums(a.FID(), 1, 100, 2)
+		if err != nil {
+			t.Fatalf("%v: Sums(a): want nil, got %v", exts, err)
+		}
+		want := [][sha256.Size]byte{sha256.Sum256([]byte("el")), sha256.Sum256([]byte("lo"))}
+		if fmt.Sprint(sums) != fmt.Sprint(want) {
+			t.Errorf("%v: Sums(a): got %x, want %x", exts, sums, want)
+		}
+
+		// More blocks than fit in one reply.
+		b, err := c.Open(0, []string{"b"}, OREAD)
+		if err != nil {
+			t.Fatalf("Open(b): want nil, got %v", err)
+		}
+		if sums, err = c.Sums(b.FID(), 0, 1<<20, 3); err != nil || len(sums) != 334 {
+			t.Fatalf("%v: Sums(b): got %d sums, %v, want 334, nil", exts, len(sums), err)
+		}
+		if sums[333] != sha256.Sum256([]byte("j")) {
+			t.Errorf("%v: last sum of b: got %x, want that of j", exts, sums[333])
+		}
+
+		local := append([]byte{}, big...)
+		local[10] = 'X'
+		local = append(local, "more"...)
+		changed, err := c.ChangedBlocks(b.FID(), bytes.NewReader(local), int64(len(local)), 100)
+		if err != nil {
+			t.Fatalf("%v: ChangedBlocks: want nil, got %v", exts, err)
+		}
+		if fmt.Sprint(changed) != "[0 1000]" {
+			t.Errorf("%v: ChangedBlocks: got %v, want [0 1000]", exts, changed)
+		}
+
+		if _, err := c.Sums(99, 0, 10, 2); err == nil {
+			t.Errorf("%v: Sums of a bad fid: want error, got nil", exts)
+		}
+	}
+}
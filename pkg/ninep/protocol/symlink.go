@@ -0,0 +1,202 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// A Readlinker is a NineServer which has symbolic links, whose Dirs
+// have DMSYMLINK set, and whose QIDs QTSYMLINK. 9P2000 has no way to
+// say where they point; 9P2000.u puts the target in the Dir's
+// extension, and 9P2000.L has Treadlink.
+type Readlinker interface {
+	Rreadlink(fid FID) (string, error)
+}
+
+// readlink handles 9P2000.L's Treadlink.
+func (s *Server) readlink(b *bytes.Buffer) error {
+	fid, t, err := UnmarshalTreadlinkPkt(b)
+	if err != nil {
+		MarshalRerrorPkt(b, t, fmt.Sprintf("%v", err))
+		return err
+	}
+	r, ok := s.NS.(Readlinker)
+	if !ok {
+		MarshalRerrorPkt(b, t, Errorf(ErrNotSupported, "Treadlink").Error())
+		return nil
+	}
+	target, err := r.Rreadlink(fid)
+	if err != nil {
+		MarshalRerrorPkt(b, t, fmt.Sprintf("%v", err))
+		return nil
+	}
+	MarshalRreadlinkPkt(b, t, target)
+	return nil
+}
+
+// Readlink returns where the symbolic link fid points. It needs a
+// server which agreed to 9P2000.u or 9P2000.L in Version.
+func (c *Client) Readlink(fid FID) (string, error) {
+	switch c.dialect {
+	case "9P2000.L":
+		var b bytes.Buffer
+		MarshalTreadlinkPkt(&b, 0, fid)
+		r := make(chan []byte)
+		c.FromClient <- &RPCCall{b: b.Bytes(), Reply: r}
+		bb := <-r
+		if err := replyErr(bb); err != nil {
+			return "", err
+		}
+		if MType(bb[4]) != Rreadlink {
+			return "", fmt.Errorf("Treadlink: got %v, want Rreadlink", RPCNames[MType(bb[4])])
+		}
+		target, _, err := UnmarshalRreadlinkPkt(bytes.NewBuffer(bb[5:]))
+		return target, err
+	case "9P2000.u":
+		st, err := c.CallTstat(fid)
+		if err != nil {
+			return "", err
+		}
+		d, target, _, _, _, err := UnmarshaldirDotu(bytes.NewBuffer(st))
+		if err != nil {
+			return "", err
+		}
+		if d.Mode&DMSYMLINK == 0 {
+			return "", fmt.Errorf("%v: not a symbolic link", d.Name)
+		}
+		return target, nil
+	}
+	return "", fmt.Errorf("Readlink: %q has no symbolic links", c.dialect)
+}
+
+// A SymlinkPolicy is how a Client resolves the symbolic links it finds
+// on the way to a file, in Open, Create, OpenFile and FS. Links are
+// resolved by the client, from the root of the walk, so a server which
+// follows them itself never gets the chance to lead it elsewhere.
+type SymlinkPolicy struct {
+	// Follow is the most links followed in resolving one name. If
+	// it is 0, links are never followed: a link on the way to a
+	// file is an error, and one at the end is the file, as with
+	// O_NOFOLLOW.
+	Follow int
+
+	// Within refuses links which point out of the tree walked
+	// from: absolute ones, and those which climb out of it with
+	// "..". Otherwise, absolute links are resolved from the root of
+	// the walk, since that is all the client can see, and ".." goes
+	// no higher than it.
+	Within bool
+}
+
+var (
+	// NoFollow never follows links.
+	NoFollow = SymlinkPolicy{}
+	// FollowWithin follows links, as many as Linux does, as long as
+	// they stay in the tree.
+	FollowWithin = SymlinkPolicy{Follow: 40, Within: true}
+)
+
+// resolve walks a new fid from fid to names, resolving the symbolic
+// links on the way as c.Symlinks says. With no policy, the walk is
+// left to the server.
+func (c *Client) resolve(fid FID, names []string) (WalkResult, error) {
+	p := c.Symlinks
+	if p == nil {
+		return c.Walk(fid, names)
+	}
+	for links := 0; ; {
+		w, err := c.Walk(fid, names)
+		i := firstLink(w.QIDs)
+		if i < 0 || (i == len(names)-1 && p.Follow == 0) {
+			return w, err
+		}
+		if err == nil {
+			c.CallTclunk(w.FID)
+		}
+		at := strings.Join(names[:i+1], "/")
+		stop := func(err error) (WalkResult, error) {
+			return WalkResult{Names: names, QIDs: w.QIDs[:i], FID: NOFID}, fmt.Errorf("%v: %v", at, err)
+		}
+		if p.Follow == 0 {
+			return stop(fmt.Errorf("symbolic link not followed"))
+		}
+		if links++; links > p.Follow {
+			return stop(fmt.Errorf("too many levels of symbolic links"))
+		}
+		l, err := c.Walk(fid, names[:i+1])
+		if err != nil {
+			return stop(err)
+		}
+		target, err := c.Readlink(l.FID)
+		c.CallTclunk(l.FID)
+		if err != nil {
+			return stop(err)
+		}
+		n, err := linkNames(names[:i], target, p.Within)
+		if err != nil {
+			return stop(err)
+		}
+		names = append(n, names[i+1:]...)
+	}
+}
+
+// firstLink returns the index of the first symbolic link in qs, or -1.
+func firstLink(qs []QID) int {
+	for i, q := range qs {
+		if q.Type&QTSYMLINK != 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+// linkNames returns the names, from the root, of where a link in dir,
+// also from the root, to target leads.
+func linkNames(dir []string, target string, within bool) ([]string, error) {
+	if target == "" {
+		return nil, fmt.Errorf("empty symbolic link")
+	}
+	if path.IsAbs(target) {
+		if within {
+			return nil, fmt.Errorf("symbolic link to %v leads out of the tree", target)
+		}
+	} else {
+		if within && escapes(path.Join(dir...), target) {
+			return nil, fmt.Errorf("symbolic link to %v leads out of the tree", target)
+		}
+		target = path.Join(append(dir, target)...)
+	}
+	// Cleaning from / stops .. at the root.
+	p := strings.TrimPrefix(path.Clean("/"+target), "/")
+	if p == "" {
+		return nil, nil
+	}
+	return strings.Split(p, "/"), nil
+}
+
+// escapes reports whether target, relative to dir, climbs above the
+// root dir is in.
+func escapes(dir, target string) bool {
+	depth := 0
+	if dir != "" {
+		depth = len(strings.Split(dir, "/"))
+	}
+	for _, n := range strings.Split(target, "/") {
+		switch n {
+		case "", ".":
+		case "..":
+			if depth--; depth < 0 {
+				return true
+			}
+		default:
+			depth++
+		}
+	}
+	return false
+}
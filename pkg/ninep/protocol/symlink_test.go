@@ -0,0 +1,52 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLinkNames(t *testing.T) {
+	var tests = []struct {
+		dir    string
+		target string
+		within bool
+		want   string
+		err    bool
+	}{
+		{dir: "a/b", target: "c", want: "a/b/c"},
+		{dir: "a/b", target: "../c", within: true, want: "a/c"},
+		{dir: "a/b", target: "../../c", within: true, want: "c"},
+		{dir: "a/b", target: "../../../c", within: true, err: true},
+		{dir: "a/b", target: "x/../../../../c", within: true, err: true},
+		{dir: "a/b", target: "../../../c", want: "c"},
+		{dir: "", target: "..", want: ""},
+		{dir: "a", target: "/etc/passwd", want: "etc/passwd"},
+		{dir: "a", target: "/etc/passwd", within: true, err: true},
+		{dir: "a", target: "", err: true},
+	}
+	for _, tt := range tests {
+		var dir []string
+		if tt.dir != "" {
+			dir = strings.Split(tt.dir, "/")
+		}
+		got, err := linkNames(dir, tt.target, tt.within)
+		if tt.err {
+			if err == nil {
+				t.Errorf("linkNames(%q, %q, %v): want an error, got %q", tt.dir, tt.target, tt.within, got)
+			}
+			continue
+		}
+		var want []string
+		if tt.want != "" {
+			want = strings.Split(tt.want, "/")
+		}
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("linkNames(%q, %q, %v): want %q, nil, got %q, %v", tt.dir, tt.target, tt.within, want, got, err)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"net"
+	"time"
+)
+
+// TCPOptions tune TCP connections, whose defaults suit neither links
+// with long round trips nor ones moving a lot of data. The zero value
+// leaves everything as the system has it.
+type TCPOptions struct {
+	// Nagle turns Nagle's algorithm back on, so small writes wait to
+	// be sent with more. Go turns it off.
+	Nagle bool
+
+	// KeepAlive is the time between keep-alive probes. If 0, the
+	// system's default is used; if negative, there are none.
+	KeepAlive time.Duration
+
+	// ReadBuffer and WriteBuffer, if not 0, set the size of the
+	// socket's receive and send buffers.
+	ReadBuffer  int
+	WriteBuffer int
+
+	// Cork holds back partial segments, for up to 200ms on Linux,
+	// which is where it is supported, so that bulk transfers go in
+	// full segments. It adds latency to everything else.
+	Cork bool
+}
+
+// Apply applies the options to c, if it is a TCP connection.
+func (o *TCPOptions) Apply(c net.Conn) error {
+	t, ok := c.(*net.TCPConn)
+	if !ok || o == nil {
+		return nil
+	}
+	if err := t.SetNoDelay(!o.Nagle); err != nil {
+		return err
+	}
+	switch {
+	case o.KeepAlive > 0:
+		if err := t.SetKeepAlive(true); err != nil {
+			return err
+		}
+		if err := t.SetKeepAlivePeriod(o.KeepAlive); err != nil {
+			return err
+		}
+	case o.KeepAlive < 0:
+		if err := t.SetKeepAlive(false); err != nil {
+			return err
+		}
+	}
+	if o.ReadBuffer != 0 {
+		if err := t.SetReadBuffer(o.ReadBuffer); err != nil {
+			return err
+		}
+	}
+	if o.WriteBuffer != 0 {
+		if err := t.SetWriteBuffer(o.WriteBuffer); err != nil {
+			return err
+		}
+	}
+	if o.Cork {
+		return setCork(t)
+	}
+	return nil
+}
+
+// Dial connects to addr on network, which should be a TCP one, and
+// applies the options to the connection.
+func (o *TCPOptions) Dial(network, addr string) (net.Conn, error) {
+	d := net.Dialer{KeepAlive: o.KeepAlive}
+	c, err := d.Dial(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	if err := o.Apply(c); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
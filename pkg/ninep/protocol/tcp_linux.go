@@ -0,0 +1,26 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build linux
+
+package protocol
+
+import (
+	"net"
+	"syscall"
+)
+
+func setCork(t *net.TCPConn) error {
+	rc, err := t.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	if err := rc.Control(func(fd uintptr) {
+		serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_CORK, 1)
+	}); err != nil {
+		return err
+	}
+	return serr
+}
@@ -0,0 +1,16 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !linux
+
+package protocol
+
+import (
+	"fmt"
+	"net"
+)
+
+func setCork(t *net.TCPConn) error {
+	return fmt.Errorf("TCP cork is not supported here")
+}
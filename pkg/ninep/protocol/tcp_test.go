@@ -0,0 +1,61 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"net"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestTCPOptions(t *testing.T) {
+	o := &TCPOptions{
+		KeepAlive:   time.Minute,
+		ReadBuffer:  1 << 20,
+		WriteBuffer: 1 << 20,
+		Cork:        runtime.GOOS == "linux",
+	}
+	s, err := NewListener(func() NineServer { return newDirServer() }, func(l *Listener) error {
+		l.TCP = o
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("NewListener: want nil, got %v", err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("no loopback: %v", err)
+	}
+	go s.Serve(ln)
+	defer s.Shutdown()
+
+	conn, err := o.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: want nil, got %v", err)
+	}
+	c, err := NewClient(func(c *Client) error {
+		c.FromNet, c.ToNet = conn, conn
+		c.Msize = 8192
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("NewClient: want nil, got %v", err)
+	}
+	if _, v, err := c.CallTversion(8192, "9P2000"); err != nil || v != "9P2000" {
+		t.Fatalf("CallTversion: got %q, %v, want 9P2000, nil", v, err)
+	}
+	if _, err := c.CallTattach(0, NOFID, "glenda", ""); err != nil {
+		t.Errorf("CallTattach: want nil, got %v", err)
+	}
+
+	// Other connections are left alone.
+	p, p2 := net.Pipe()
+	defer p.Close()
+	defer p2.Close()
+	if err := o.Apply(p); err != nil {
+		t.Errorf("Apply to a pipe: want nil, got %v", err)
+	}
+}
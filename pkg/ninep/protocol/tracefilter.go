@@ -0,0 +1,343 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"path"
+	"strings"
+	"time"
+)
+
+// A TraceFilter picks the messages a Listener traces, so that tracing
+// can be left on for a busy server, scoped to what is of interest, such
+// as the Twrites under /logs from 10.0.0.5. A message is traced if it
+// matches every field which is set, with one line for it and its reply,
+// and counted, by type, in the Listener's Stats. Connection events are
+// traced if the connection matches.
+//
+// So that tracing can stay on in production, a TraceFilter can also
+// sample what it matches, and hold the trace to a budget of bytes, so
+// that a busy server is neither slowed to the speed of its log nor
+// floods it. What is matched but not traced is counted in the
+// Listener's Stats, as TraceDropped.
+type TraceFilter struct {
+	// Types are the message types to trace, such as Twrite.
+	Types []MType
+
+	// Prefix is the path the files of the messages traced are in, or
+	// are. Paths are those the clients walked, from the root of the
+	// tree they attached to, such as /logs/a for a walk to logs and
+	// then a from an attach with an aname of "". Messages which are
+	// not about files, such as Tversion, have no path.
+	Prefix string
+
+	// Remote are the connections to trace, by the address of the
+	// other end: a host, as in 10.0.0.5, a host and port, or a
+	// network, as in 10.0.0.0/8.
+	Remote []string
+
+	// Conns are the connections to trace, by the IDs Listener.Conns
+	// gives them.
+	Conns []uint64
+
+	// Every, if more than 1, traces only one in Every of the messages
+	// matched.
+	Every uint64
+
+	// Errors traces every message matched whose reply is an error,
+	// whatever Every says.
+	Errors bool
+
+	// BytesPerSecond, if not 0, is the most the connections may trace,
+	// on average, with a second's worth to spend at once. Lines over
+	// it, errors too, are dropped, until time has made up for them.
+	BytesPerSecond int
+
+	// nothing is set for TraceNothing.
+	nothing bool
+	// types, hosts, nets and conns are Types, Remote and Conns, ready
+	// to look things up in.
+	types map[MType]bool
+	hosts map[string]bool
+	nets  []*net.IPNet
+	conns map[uint64]bool
+	// seen counts the messages sampled. budget is what is left of
+	// BytesPerSecond, as of spent. The Listener's mu guards them.
+	seen   uint64
+	budget float64
+	spent  time.Time
+}
+
+// TraceNothing is a TraceFilter which matches nothing, for a Listener
+// with a Trace which is to stay quiet until it is given another.
+var TraceNothing = &TraceFilter{nothing: true}
+
+// SetTraceFilter makes l trace only what f matches, from now on, for
+// connections old and new, and starts the counts of what it matched
+// afresh. If f is nil, everything is traced, as it is by default.
+// Tracing needs a Trace, which the filter can't set.
+func (l *Listener) SetTraceFilter(f *TraceFilter) error {
+	if f != nil && !f.nothing {
+		c, err := f.compile()
+		if err != nil {
+			return err
+		}
+		f = c
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.filter = f
+	l.traced = nil
+	l.dropped = 0
+	return nil
+}
+
+// TraceFilter returns the filter set by SetTraceFilter, if any.
+func (l *Listener) TraceFilter() *TraceFilter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.filter
+}
+
+// compile returns a copy of f, ready to match with.
+func (f *TraceFilter) compile() (*TraceFilter, error) {
+	c := &TraceFilter{Types: f.Types, Prefix: f.Prefix, Remote: f.Remote, Conns: f.Conns, Every: f.Every, Errors: f.Errors, BytesPerSecond: f.BytesPerSecond}
+	if c.BytesPerSecond < 0 {
+		return nil, fmt.Errorf("trace filter: %d bytes per second is negative", c.BytesPerSecond)
+	}
+	c.budget = float64(c.BytesPerSecond)
+	if c.Prefix != "" {
+		c.Prefix = path.Clean("/" + c.Prefix)
+	}
+	if len(f.Types) > 0 {
+		c.types = make(map[MType]bool)
+		for _, t := range f.Types {
+			if _, ok := RPCNames[t]; !ok || t%2 != 0 {
+				return nil, fmt.Errorf("trace filter: %d is not a request type", t)
+			}
+			c.types[t] = true
+		}
+	}
+	if len(f.Remote) > 0 {
+		c.hosts = make(map[string]bool)
+		for _, r := range f.Remote {
+			if strings.Contains(r, "/") {
+				_, n, err := net.ParseCIDR(r)
+				if err != nil {
+					return nil, fmt.Errorf("trace filter: %v", err)
+				}
+				c.nets = append(c.nets, n)
+				continue
+			}
+			c.hosts[r] = true
+		}
+	}
+	if len(f.Conns) > 0 {
+		c.conns = make(map[uint64]bool)
+		for _, id := range f.Conns {
+			c.conns[id] = true
+		}
+	}
+	return c, nil
+}
+
+// matchConn reports whether f matches the connection with id, to remote.
+func (f *TraceFilter) matchConn(id uint64, remote string) bool {
+	if f.nothing {
+		return false
+	}
+	if f.conns != nil && !f.conns[id] {
+		return false
+	}
+	if f.hosts == nil && f.nets == nil {
+		return true
+	}
+	if f.hosts[remote] {
+		return true
+	}
+	host, _, err := net.SplitHostPort(remote)
+	if err != nil {
+		host = remote
+	}
+	if f.hosts[host] {
+		return true
+	}
+	ip := net.ParseIP(host)
+	for _, n := range f.nets {
+		if ip != nil && n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// match reports whether f matches a message of type t about the file at
+// p, or none if p is "", on a connection it matches.
+func (f *TraceFilter) match(t MType, p string) bool {
+	if f.types != nil && !f.types[t] {
+		return false
+	}
+	if f.Prefix == "" || f.Prefix == "/" {
+		return true
+	}
+	return p == f.Prefix || strings.HasPrefix(p, f.Prefix+"/")
+}
+
+// sample reports whether a message f matched is to be traced, failed
+// if its reply is an error. The Listener's mu is held.
+func (f *TraceFilter) sample(failed bool) bool {
+	if f.Every <= 1 || failed && f.Errors {
+		return true
+	}
+	f.seen++
+	return (f.seen-1)%f.Every == 0
+}
+
+// spend reports whether f's budget has n bytes left to trace, at now,
+// and takes them if so. A line longer than a second's worth is traced
+// when the budget is full, and put into debt. The Listener's mu is
+// held.
+func (f *TraceFilter) spend(n int, now time.Time) bool {
+	if f.BytesPerSecond == 0 {
+		return true
+	}
+	max := float64(f.BytesPerSecond)
+	if !f.spent.IsZero() {
+		f.budget += now.Sub(f.spent).Seconds() * max
+		if f.budget > max {
+			f.budget = max
+		}
+	}
+	f.spent = now
+	if f.budget < float64(n) && f.budget < max {
+		return false
+	}
+	f.budget -= float64(n)
+	return true
+}
+
+// traceFilter returns the Listener's filter, or nil.
+func (c *conn) traceFilter() *TraceFilter {
+	if Minimal || c.listener.Trace == nil {
+		return nil
+	}
+	c.listener.mu.Lock()
+	defer c.listener.mu.Unlock()
+	return c.listener.filter
+}
+
+// traced is what is known, before it is dispatched, of a message the
+// connection may trace: its fid, if it has one, and the path it is about.
+type traced struct {
+	fid  FID
+	p    string
+	walk []string
+}
+
+// before notes what there is to know about the message in b, of type t,
+// before it is dispatched, which overwrites it. It returns nil if the
+// connection is not traced. c.dmu is held.
+func (c *conn) before(b *bytes.Buffer, t MType) *traced {
+	if Minimal || c.listener.Trace == nil {
+		return nil
+	}
+	// tag[2] fid[4] ...
+	d := b.Bytes()
+	switch t {
+	case Tversion, Tflush, Tauth:
+		return &traced{fid: NOFID}
+	}
+	if len(d) < 6 {
+		return &traced{fid: NOFID}
+	}
+	m := &traced{fid: FID(d[2]) | FID(d[3])<<8 | FID(d[4])<<16 | FID(d[5])<<24}
+	p := c.paths[m.fid]
+	switch t {
+	case Tattach:
+		if _, _, _, aname, _, err := UnmarshalTattachPkt(bytes.NewBuffer(d)); err == nil {
+			m.p = path.Clean("/" + aname)
+		}
+	case Twalk:
+		if _, newfid, names, _, err := UnmarshalTwalkPkt(bytes.NewBuffer(d)); err == nil && p != "" {
+			m.walk = names
+			m.p = path.Join(append([]string{p}, names...)...)
+			m.fid = newfid
+		}
+	case Tcreate:
+		if _, name, _, _, _, err := UnmarshalTcreatePkt(bytes.NewBuffer(d)); err == nil && p != "" {
+			m.p = path.Join(p, name)
+		}
+	default:
+		m.p = p
+	}
+	return m
+}
+
+// after keeps track of the paths of fids, as the reply in b to a message
+// of type t, as before saw it, says, and traces the message, if it is to
+// be. c.dmu is held.
+func (c *conn) after(m *traced, b *bytes.Buffer, t MType) {
+	if m == nil {
+		return
+	}
+	r := replyType(b)
+	switch {
+	case t == Tversion:
+		// A new session has none of the old fids.
+		c.paths = nil
+	case t == Tclunk || t == Tremove:
+		delete(c.paths, m.fid)
+	case r == Rerror || r == Rlerror || m.p == "":
+	case t == Tattach, t == Tcreate:
+		c.track(m.fid, m.p)
+	case t == Twalk:
+		// Only a walk of every name makes newfid.
+		if d := b.Bytes(); len(d) >= 9 && int(d[7])|int(d[8])<<8 == len(m.walk) {
+			c.track(m.fid, m.p)
+		}
+	}
+
+	l := c.listener
+	l.mu.Lock()
+	f := l.filter
+	if f == nil || !f.matchConn(c.id, c.remoteAddr) || !f.match(t, m.p) {
+		l.mu.Unlock()
+		return
+	}
+	if l.traced == nil {
+		l.traced = make(map[MType]uint64)
+	}
+	l.traced[t]++
+	if !f.sample(r == Rerror || r == Rlerror) {
+		l.dropped++
+		l.mu.Unlock()
+		return
+	}
+	l.mu.Unlock()
+
+	reply := RPCNames[r]
+	if err := replyErr(b.Bytes()); err != nil {
+		reply = fmt.Sprintf("%v %q", reply, err)
+	}
+	switch {
+	case m.p != "":
+		c.logf("%v fid %d %v: %v", RPCNames[t], m.fid, m.p, reply)
+	case m.fid != NOFID:
+		c.logf("%v fid %d: %v", RPCNames[t], m.fid, reply)
+	default:
+		c.logf("%v: %v", RPCNames[t], reply)
+	}
+}
+
+// track notes that fid is the file at p. c.dmu is held.
+func (c *conn) track(fid FID, p string) {
+	if c.paths == nil {
+		c.paths = make(map[FID]string)
+	}
+	c.paths[fid] = p
+}
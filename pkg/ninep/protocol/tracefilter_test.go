@@ -0,0 +1,234 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// traceLog is a Tracer which keeps what it is given.
+type traceLog struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *traceLog) trace(format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, fmt.Sprintf(format, args...))
+}
+
+func (l *traceLog) take() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	lines := l.lines
+	l.lines = nil
+	return lines
+}
+
+func TestTraceFilter(t *testing.T) {
+	skipMinimal(t, "tracing")
+	tl := &traceLog{}
+	s, err := NewListener(func() NineServer { return newDirServer() }, func(l *Listener) error {
+		l.Trace = tl.trace
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("NewListener: want nil, got %v", err)
+	}
+	if err := s.SetTraceFilter(TraceNothing); err != nil {
+		t.Fatalf("SetTraceFilter(TraceNothing): want nil, got %v", err)
+	}
+	p, p2 := net.Pipe()
+	defer p.Close()
+	if err := s.Accept(p2); err != nil {
+		t.Fatalf("Accept: want nil, got %v", err)
+	}
+	c, err := NewClient(func(c *Client) error {
+		c.FromNet, c.ToNet = p, p
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, _, err := c.CallTversion(8192, "9P2000"); err != nil {
+		t.Fatalf("CallTversion: want nil, got %v", err)
+	}
+	if _, err := c.CallTattach(0, NOFID, "glenda", ""); err != nil {
+		t.Fatalf("CallTattach: want nil, got %v", err)
+	}
+	if lines := tl.take(); len(lines) != 0 {
+		t.Errorf("TraceNothing: got %q, want nothing", lines)
+	}
+
+	if err := s.SetTraceFilter(&TraceFilter{Types: []MType{Twrite}, Prefix: "a"}); err != nil {
+		t.Fatalf("SetTraceFilter: want nil, got %v", err)
+	}
+	a, err := c.Open(0, []string{"a"}, ORDWR)
+	if err != nil {
+		t.Fatalf("Open(a): want nil, got %v", err)
+	}
+	b, err := c.Create(0, []string{"b"}, 0644, ORDWR)
+	if err != nil {
+		t.Fatalf("Create(b): want nil, got %v", err)
+	}
+	for _, f := range []*ClientFile{a, b} {
+		if _, err := f.WriteAt([]byte("x"), 0); err != nil {
+			t.Fatalf("WriteAt: want nil, got %v", err)
+		}
+		if _, err := f.ReadAt(make([]byte, 1), 0); err != nil {
+			t.Fatalf("ReadAt: want nil, got %v", err)
+		}
+	}
+	lines := tl.take()
+	if len(lines) != 1 || !strings.Contains(lines[0], fmt.Sprintf("Twrite fid %d /a: Rwrite", a.FID())) {
+		t.Errorf("Twrites of /a: got %q, want one", lines)
+	}
+	if got := s.Stats().Traced; len(got) != 1 || got["Twrite"] != 1 {
+		t.Errorf("Stats().Traced: got %v, want 1 Twrite", got)
+	}
+
+	// Errors are traced with their reply.
+	if err := s.SetTraceFilter(&TraceFilter{Types: []MType{Twalk}}); err != nil {
+		t.Fatalf("SetTraceFilter: want nil, got %v", err)
+	}
+	if _, err := c.CallTwalk(0, 9, []string{"nope"}); err == nil {
+		t.Fatalf("Walk(nope): want an error, got nil")
+	}
+	if lines := tl.take(); len(lines) != 1 || !strings.Contains(lines[0], "Twalk fid 9 /nope: Rerror") {
+		t.Errorf("Twalk to nope: got %q, want its Rerror", lines)
+	}
+
+	if err := s.SetTraceFilter(&TraceFilter{Types: []MType{Rwrite}}); err == nil {
+		t.Errorf("SetTraceFilter(Rwrite): want an error, got nil")
+	}
+}
+
+func TestTraceSampling(t *testing.T) {
+	skipMinimal(t, "tracing")
+	tl := &traceLog{}
+	clock := NewFakeClock(time.Unix(0, 0))
+	s, err := NewListener(func() NineServer { return newDirServer() }, func(l *Listener) error {
+		l.Trace = tl.trace
+		l.Clock = clock
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("NewListener: want nil, got %v", err)
+	}
+	if err := s.SetTraceFilter(&TraceFilter{Types: []MType{Twalk}, Every: 3, Errors: true}); err != nil {
+		t.Fatalf("SetTraceFilter: want nil, got %v", err)
+	}
+	p, p2 := net.Pipe()
+	defer p.Close()
+	if err := s.Accept(p2); err != nil {
+		t.Fatalf("Accept: want nil, got %v", err)
+	}
+	c, err := NewClient(func(c *Client) error {
+		c.FromNet, c.ToNet = p, p
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, _, err := c.CallTversion(8192, "9P2000"); err != nil {
+		t.Fatalf("CallTversion: want nil, got %v", err)
+	}
+	if _, err := c.CallTattach(0, NOFID, "glenda", ""); err != nil {
+		t.Fatalf("CallTattach: want nil, got %v", err)
+	}
+	// Connection events are not sampled.
+	if lines := tl.take(); len(lines) != 1 || !strings.Contains(lines[0], "Starting") {
+		t.Errorf("connection start: got %q, want it traced", lines)
+	}
+	walks := func(n int) {
+		for i := 0; i < n; i++ {
+			if _, err := c.CallTwalk(0, 1, nil); err != nil {
+				t.Fatalf("CallTwalk: want nil, got %v", err)
+			}
+			if err := c.CallTclunk(1); err != nil {
+				t.Fatalf("CallTclunk: want nil, got %v", err)
+			}
+		}
+	}
+
+	// One in three, and every error.
+	walks(6)
+	for i := 0; i < 2; i++ {
+		if _, err := c.CallTwalk(0, 1, []string{"nope"}); err == nil {
+			t.Fatalf("Walk(nope): want an error, got nil")
+		}
+	}
+	lines := tl.take()
+	if len(lines) != 4 || strings.Contains(lines[1], "Rerror") || !strings.Contains(lines[2], "Rerror") || !strings.Contains(lines[3], "Rerror") {
+		t.Errorf("Every 3 of 6 walks, and 2 errors: got %q, want 2 walks, then the errors", lines)
+	}
+	if st := s.Stats(); st.Traced["Twalk"] != 8 || st.TraceDropped != 4 {
+		t.Errorf("Stats: got %d Twalks traced, %d dropped, want 8 and 4", st.Traced["Twalk"], st.TraceDropped)
+	}
+
+	// A budget of 100 bytes a second.
+	if err := s.SetTraceFilter(&TraceFilter{Types: []MType{Twalk}, BytesPerSecond: 100}); err != nil {
+		t.Fatalf("SetTraceFilter: want nil, got %v", err)
+	}
+	walks(10)
+	lines = tl.take()
+	n := 0
+	for _, l := range lines {
+		n += len(l)
+	}
+	if len(lines) == 0 || n > 100 {
+		t.Errorf("10 walks on 100 bytes a second: got %d lines of %d bytes, want some, under 100", len(lines), n)
+	}
+	if st := s.Stats(); st.TraceDropped != uint64(10-len(lines)) {
+		t.Errorf("Stats: got %d dropped, want %d", st.TraceDropped, 10-len(lines))
+	}
+	clock.Advance(time.Second)
+	walks(1)
+	if lines := tl.take(); len(lines) != 1 {
+		t.Errorf("walk a second later: got %q, want it traced", lines)
+	}
+
+	if err := s.SetTraceFilter(&TraceFilter{BytesPerSecond: -1}); err == nil {
+		t.Errorf("SetTraceFilter(-1 bytes per second): want an error, got nil")
+	}
+}
+
+func TestTraceFilterConn(t *testing.T) {
+	f, err := (&TraceFilter{Remote: []string{"10.0.0.5", "192.168.1.7:564", "172.16.0.0/12"}}).compile()
+	if err != nil {
+		t.Fatalf("compile: want nil, got %v", err)
+	}
+	for _, tc := range []struct {
+		remote string
+		want   bool
+	}{
+		{"10.0.0.5:4000", true},
+		{"10.0.0.6:4000", false},
+		{"192.168.1.7:564", true},
+		{"192.168.1.7:565", false},
+		{"172.20.1.1:1", true},
+		{"pipe", false},
+	} {
+		if got := f.matchConn(1, tc.remote); got != tc.want {
+			t.Errorf("matchConn(%q): got %v, want %v", tc.remote, got, tc.want)
+		}
+	}
+	f, err = (&TraceFilter{Conns: []uint64{2}}).compile()
+	if err != nil {
+		t.Fatalf("compile: want nil, got %v", err)
+	}
+	if f.matchConn(1, "pipe") || !f.matchConn(2, "pipe") {
+		t.Errorf("Conns [2]: want only connection 2 to match")
+	}
+	if _, err := (&TraceFilter{Remote: []string{"10.0.0.0/33"}}).compile(); err == nil {
+		t.Errorf("compile(10.0.0.0/33): want an error, got nil")
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// probeMsgs is how many messages of each size TuneMsize times before it
+// settles, if its window has not passed first.
+const probeMsgs = 8
+
+// msizeTuner picks the message size ClientFiles move data in. While it
+// probes, transfers take turns at each size; once it settles, they all
+// use the fastest.
+type msizeTuner struct {
+	clock Clock
+	end   time.Time
+
+	// mu guards below
+	mu sync.Mutex
+	// sizes are the data per message of each size tried, ascending,
+	// and bytes, took and msgs what was timed at each.
+	sizes []Count
+	bytes []int64
+	took  []time.Duration
+	msgs  []int
+	next  int
+	// best is the data per message settled on, 0 while probing.
+	best Count
+}
+
+// TuneMsize has the ClientFiles of c find which message size, of sizes
+// and Msize, moves data fastest, and settle on it. Until then, reads and
+// writes big enough take turns at each size, and the messages are timed,
+// until each size has been tried a few times, or window has passed on
+// clock, which is SystemClock if nil. Messages smaller than the msize
+// are always allowed, so nothing is renegotiated. Sizes bigger than
+// Msize are left out. TuneMsize must be called before c is used.
+func (c *Client) TuneMsize(clock Clock, window time.Duration, sizes ...uint32) {
+	msize := c.Msize
+	if msize <= IOHDRSZ {
+		msize = MSIZE
+	}
+	clock = clockOrSystem(clock)
+	t := &msizeTuner{clock: clock, end: clock.Now().Add(window)}
+	sizes = append(append([]uint32{}, sizes...), msize)
+	sort.Slice(sizes, func(i, j int) bool { return sizes[i] < sizes[j] })
+	for i, s := range sizes {
+		if s <= IOHDRSZ || s > msize || (i > 0 && s == sizes[i-1]) {
+			continue
+		}
+		t.sizes = append(t.sizes, Count(s-IOHDRSZ))
+	}
+	t.bytes = make([]int64, len(t.sizes))
+	t.took = make([]time.Duration, len(t.sizes))
+	t.msgs = make([]int, len(t.sizes))
+	c.tune = t
+}
+
+// TunedMsize returns the msize TuneMsize settled on, or 0 if it has not,
+// or was not asked to.
+func (c *Client) TunedMsize() uint32 {
+	t := c.tune
+	if t == nil {
+		return 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.best == 0 {
+		return 0
+	}
+	return uint32(t.best) + IOHDRSZ
+}
+
+// chunk returns how much of left to move in the next message of a file
+// whose iounit is iounit, and which size it is timing, or -1 if none.
+// It can be called on a nil msizeTuner.
+func (t *msizeTuner) chunk(iounit Count, left int) (Count, int) {
+	n := iounit
+	if Count(left) < n {
+		n = Count(left)
+	}
+	if t == nil {
+		return n, -1
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.best == 0 && !t.clock.Now().Before(t.end) {
+		t.settle()
+	}
+	if t.best != 0 {
+		if t.best < n {
+			n = t.best
+		}
+		return n, -1
+	}
+	// The next size in turn which the message will be full at.
+	for k := range t.sizes {
+		i := (t.next + k) % len(t.sizes)
+		if t.sizes[i] <= n {
+			t.next = i + 1
+			return t.sizes[i], i
+		}
+	}
+	return n, -1
+}
+
+// start returns when the message for size i starts.
+func (t *msizeTuner) start(i int) time.Time {
+	if i < 0 {
+		return time.Time{}
+	}
+	return t.clock.Now()
+}
+
+// done times a full message of size i, started at start.
+func (t *msizeTuner) done(i int, start time.Time) {
+	if i < 0 {
+		return
+	}
+	d := t.clock.Now().Sub(start)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.best != 0 {
+		return
+	}
+	t.bytes[i] += int64(t.sizes[i])
+	t.took[i] += d
+	t.msgs[i]++
+	for _, m := range t.msgs {
+		if m < probeMsgs {
+			return
+		}
+	}
+	t.settle()
+}
+
+// settle picks the size which moved the most bytes for the time it
+// took, or, if none was timed, the biggest. t.mu must be held.
+func (t *msizeTuner) settle() {
+	best, rate := len(t.sizes)-1, -1.0
+	for i := range t.sizes {
+		if t.msgs[i] == 0 {
+			continue
+		}
+		// A size too quick to time at all is the fastest.
+		r := float64(t.bytes[i]) / float64(t.took[i]+1)
+		if r >= rate {
+			best, rate = i, r
+		}
+	}
+	t.best = t.sizes[best]
+}
@@ -0,0 +1,138 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// slowServer is a dirServer whose reads take time on a FakeClock, as
+// cost says, and which records how much each asked for.
+type slowServer struct {
+	*dirServer
+	clock *FakeClock
+	cost  func(Count) time.Duration
+
+	mu     sync.Mutex
+	counts []Count
+}
+
+func (s *slowServer) Rread(fid FID, o Offset, c Count) ([]byte, error) {
+	s.mu.Lock()
+	s.counts = append(s.counts, c)
+	s.mu.Unlock()
+	s.clock.Advance(s.cost(c))
+	return s.dirServer.Rread(fid, o, c)
+}
+
+// newSlowClient returns a client of s with an msize of msize, attached
+// as fid 0, with the file a open as fid 1.
+func newSlowClient(t *testing.T, s *slowServer, msize uint32) (*Client, *ClientFile) {
+	s.data["a"] = make([]byte, 1<<20)
+	s.files["a"].Length = 1 << 20
+	l, err := NewListener(func() NineServer { return s })
+	if err != nil {
+		t.Fatalf("NewListener: want nil, got %v", err)
+	}
+	c := pipeClient(t, l, func(c *Client) error {
+		c.Msize = msize
+		return nil
+	})
+	if _, _, err := c.CallTversion(MaxSize(msize), "9P2000"); err != nil {
+		t.Fatalf("CallTversion: want nil, got %v", err)
+	}
+	if _, err := c.CallTattach(0, NOFID, "glenda", ""); err != nil {
+		t.Fatalf("CallTattach: want nil, got %v", err)
+	}
+	f, err := c.Open(0, []string{"a"}, OREAD)
+	if err != nil {
+		t.Fatalf("Open(a): want nil, got %v", err)
+	}
+	return c, f
+}
+
+func TestTuneMsize(t *testing.T) {
+	const k = 1024
+	for _, tc := range []struct {
+		name string
+		cost func(Count) time.Duration
+		want uint32
+	}{
+		// Each message costs the same, so the biggest is best.
+		{"latency", func(Count) time.Duration { return time.Millisecond }, 8*k + IOHDRSZ},
+		// Big messages cost more than they carry, so the smallest is.
+		{"congestion", func(c Count) time.Duration { return time.Duration(c) * time.Duration(c) }, k + IOHDRSZ},
+	} {
+		clock := NewFakeClock(time.Unix(0, 0))
+		s := &slowServer{dirServer: newDirServer(), clock: clock, cost: tc.cost}
+		c, f := newSlowClient(t, s, 8*k+IOHDRSZ)
+		c.TuneMsize(clock, time.Hour, k+IOHDRSZ, 4*k+IOHDRSZ, 64*k+IOHDRSZ)
+
+		// Probing tries each size, in turn, until it has timed each
+		// enough.
+		b := make([]byte, 64*k)
+		for i := 0; c.TunedMsize() == 0; i++ {
+			if i == 100 {
+				t.Fatalf("%v: not settled after %d reads", tc.name, i)
+			}
+			if _, err := f.ReadAt(b, 0); err != nil {
+				t.Fatalf("%v: ReadAt: want nil, got %v", tc.name, err)
+			}
+		}
+		if got := c.TunedMsize(); got != tc.want {
+			t.Errorf("%v: TunedMsize: got %d, want %d", tc.name, got, tc.want)
+		}
+		seen := map[Count]bool{}
+		for _, n := range s.counts {
+			seen[n] = true
+		}
+		for _, n := range []Count{k, 4 * k, 8 * k} {
+			if !seen[n] {
+				t.Errorf("%v: no read of %d while probing, got %v", tc.name, n, s.counts)
+			}
+		}
+		if seen[64*k] {
+			t.Errorf("%v: read of %d, bigger than the msize", tc.name, 64*k)
+		}
+
+		// Once settled, reads are all of the size chosen.
+		s.counts = nil
+		if _, err := f.ReadAt(b, 0); err != nil {
+			t.Fatalf("%v: ReadAt: want nil, got %v", tc.name, err)
+		}
+		for _, n := range s.counts {
+			if uint32(n) != tc.want-IOHDRSZ {
+				t.Errorf("%v: settled read of %d, want %d", tc.name, n, tc.want-IOHDRSZ)
+				break
+			}
+		}
+	}
+}
+
+func TestTuneMsizeWindow(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	s := &slowServer{dirServer: newDirServer(), clock: clock, cost: func(Count) time.Duration { return 0 }}
+	c, f := newSlowClient(t, s, 8192)
+	c.TuneMsize(clock, time.Second, 1024+IOHDRSZ)
+
+	// Reads too small to time leave it probing until the window is
+	// over, and then it takes the biggest.
+	b := make([]byte, 100)
+	if _, err := f.ReadAt(b, 0); err != nil {
+		t.Fatalf("ReadAt: want nil, got %v", err)
+	}
+	if got := c.TunedMsize(); got != 0 {
+		t.Errorf("TunedMsize within the window: got %d, want 0", got)
+	}
+	clock.Advance(time.Second)
+	if _, err := f.ReadAt(b, 0); err != nil {
+		t.Fatalf("ReadAt: want nil, got %v", err)
+	}
+	if got := c.TunedMsize(); got != 8192 {
+		t.Errorf("TunedMsize after the window: got %d, want 8192", got)
+	}
+}
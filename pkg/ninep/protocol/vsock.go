@@ -0,0 +1,28 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import "fmt"
+
+// vsock, or AF_VSOCK, connects virtual machines and their host without
+// any networking set up, which suits an initramfs.
+const (
+	// VsockCIDAny is any CID, for listening.
+	VsockCIDAny = 0xffffffff
+	// VsockCIDHost is the host, as seen from a virtual machine.
+	VsockCIDHost = 2
+	// VsockPortAny is any port, for listening.
+	VsockPortAny = 0xffffffff
+)
+
+// A VsockAddr is the address of a vsock endpoint: the context ID of a
+// virtual machine, or the host, and a port.
+type VsockAddr struct {
+	CID  uint32
+	Port uint32
+}
+
+func (a *VsockAddr) Network() string { return "vsock" }
+func (a *VsockAddr) String() string  { return fmt.Sprintf("%d:%d", a.CID, a.Port) }
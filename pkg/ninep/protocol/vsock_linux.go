@@ -0,0 +1,162 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// afVsock is AF_VSOCK, which package syscall lacks.
+const afVsock = 40
+
+// rawSockaddrVM is the kernel's struct sockaddr_vm.
+type rawSockaddrVM struct {
+	Family    uint16
+	Reserved1 uint16
+	Port      uint32
+	CID       uint32
+	Zero      [4]uint8
+}
+
+// vsockSocket returns a new, nonblocking vsock stream socket.
+func vsockSocket() (int, error) {
+	return syscall.Socket(afVsock, syscall.SOCK_STREAM|syscall.SOCK_NONBLOCK|syscall.SOCK_CLOEXEC, 0)
+}
+
+// vsockCall makes the socket system call trap, of bind, connect,
+// getsockname or getpeername, on fd with the address a.
+func vsockCall(trap uintptr, fd int, a *rawSockaddrVM) error {
+	l := uint32(unsafe.Sizeof(*a))
+	arg := uintptr(l)
+	if trap == syscall.SYS_GETSOCKNAME || trap == syscall.SYS_GETPEERNAME {
+		arg = uintptr(unsafe.Pointer(&l))
+	}
+	if _, _, e := syscall.Syscall(trap, uintptr(fd), uintptr(unsafe.Pointer(a)), arg); e != 0 {
+		return e
+	}
+	return nil
+}
+
+// vsockConn is a vsock connection. The file does the work, using the
+// runtime's poller, which also gives it deadlines.
+type vsockConn struct {
+	*os.File
+	local, remote *VsockAddr
+}
+
+func (c *vsockConn) LocalAddr() net.Addr  { return c.local }
+func (c *vsockConn) RemoteAddr() net.Addr { return c.remote }
+
+// DialVsock connects to port on the virtual machine, or host, cid.
+func DialVsock(cid, port uint32) (net.Conn, error) {
+	remote := &VsockAddr{CID: cid, Port: port}
+	fd, err := vsockSocket()
+	if err != nil {
+		return nil, fmt.Errorf("dial %v: %v", remote, err)
+	}
+	f := os.NewFile(uintptr(fd), "vsock:"+remote.String())
+	rc, err := f.SyscallConn()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("dial %v: %v", remote, err)
+	}
+	err = vsockCall(syscall.SYS_CONNECT, fd, &rawSockaddrVM{Family: afVsock, CID: cid, Port: port})
+	if err == syscall.EINPROGRESS {
+		// Wait until the socket can be written, which is when
+		// connecting is done, and find out how it went.
+		werr := rc.Write(func(fd uintptr) bool {
+			var e int
+			if e, err = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_ERROR); err != nil {
+				return true
+			}
+			if e != 0 {
+				err = syscall.Errno(e)
+				return err != syscall.EINPROGRESS && err != syscall.EALREADY
+			}
+			// No error yet may be not connected yet either.
+			err = vsockCall(syscall.SYS_GETPEERNAME, int(fd), &rawSockaddrVM{})
+			return err != syscall.ENOTCONN
+		})
+		if werr != nil {
+			err = werr
+		}
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("dial %v: %v", remote, err)
+	}
+	var a rawSockaddrVM
+	vsockCall(syscall.SYS_GETSOCKNAME, fd, &a)
+	return &vsockConn{File: f, local: &VsockAddr{CID: a.CID, Port: a.Port}, remote: remote}, nil
+}
+
+// vsockListener listens for vsock connections.
+type vsockListener struct {
+	f    *os.File
+	addr *VsockAddr
+}
+
+// ListenVsock listens for vsock connections to port, from the host if
+// this is a virtual machine, or from virtual machines if it is the host.
+// A port of VsockPortAny picks one.
+func ListenVsock(port uint32) (net.Listener, error) {
+	fd, err := vsockSocket()
+	if err != nil {
+		return nil, fmt.Errorf("listen vsock port %d: %v", port, err)
+	}
+	a := rawSockaddrVM{Family: afVsock, CID: VsockCIDAny, Port: port}
+	if err := vsockCall(syscall.SYS_BIND, fd, &a); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("listen vsock port %d: %v", port, err)
+	}
+	if err := syscall.Listen(fd, syscall.SOMAXCONN); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("listen vsock port %d: %v", port, err)
+	}
+	vsockCall(syscall.SYS_GETSOCKNAME, fd, &a)
+	addr := &VsockAddr{CID: a.CID, Port: a.Port}
+	return &vsockListener{f: os.NewFile(uintptr(fd), "vsock:"+addr.String()), addr: addr}, nil
+}
+
+func (l *vsockListener) Accept() (net.Conn, error) {
+	rc, err := l.f.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var nfd int
+	var a rawSockaddrVM
+	rerr := rc.Read(func(fd uintptr) bool {
+		// Not syscall.Accept4, which gives up on addresses it does
+		// not know, closing the connection.
+		n := uint32(unsafe.Sizeof(a))
+		r, _, e := syscall.Syscall6(syscall.SYS_ACCEPT4, fd, uintptr(unsafe.Pointer(&a)), uintptr(unsafe.Pointer(&n)),
+			syscall.SOCK_NONBLOCK|syscall.SOCK_CLOEXEC, 0, 0)
+		nfd, err = int(r), nil
+		if e != 0 {
+			err = e
+		}
+		return err != syscall.EAGAIN
+	})
+	if rerr != nil {
+		return nil, rerr
+	}
+	if err != nil {
+		return nil, err
+	}
+	remote := &VsockAddr{CID: a.CID, Port: a.Port}
+	return &vsockConn{File: os.NewFile(uintptr(nfd), "vsock:"+remote.String()), local: l.addr, remote: remote}, nil
+}
+
+func (l *vsockListener) Close() error {
+	return l.f.Close()
+}
+
+func (l *vsockListener) Addr() net.Addr {
+	return l.addr
+}
@@ -0,0 +1,64 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"testing"
+)
+
+// vsockCIDLocal is this machine, if the vsock_loopback module is loaded.
+const vsockCIDLocal = 1
+
+func TestVsock(t *testing.T) {
+	ln, err := ListenVsock(VsockPortAny)
+	if err != nil {
+		t.Skipf("no vsock here: %v", err)
+	}
+	defer ln.Close()
+	s, err := NewListener(func() NineServer { return newDirServer() })
+	if err != nil {
+		t.Fatalf("NewListener: want nil, got %v", err)
+	}
+	go s.Serve(ln)
+
+	port := ln.Addr().(*VsockAddr).Port
+	conn, err := DialVsock(vsockCIDLocal, port)
+	if err != nil {
+		t.Skipf("no vsock loopback here: %v", err)
+	}
+	c, err := NewClient(func(c *Client) error {
+		c.FromNet, c.ToNet = conn, conn
+		c.Msize = 8192
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("NewClient: want nil, got %v", err)
+	}
+	if _, v, err := c.CallTversion(8192, "9P2000"); err != nil || v != "9P2000" {
+		t.Fatalf("CallTversion: got %q, %v, want 9P2000, nil", v, err)
+	}
+	if _, err := c.CallTattach(0, NOFID, "glenda", ""); err != nil {
+		t.Errorf("CallTattach: want nil, got %v", err)
+	}
+	if a := conn.RemoteAddr().String(); a != (&VsockAddr{CID: vsockCIDLocal, Port: port}).String() {
+		t.Errorf("RemoteAddr: got %v, want %d:%d", a, vsockCIDLocal, port)
+	}
+}
+
+func TestVsockClose(t *testing.T) {
+	ln, err := ListenVsock(VsockPortAny)
+	if err != nil {
+		t.Skipf("no vsock here: %v", err)
+	}
+	done := make(chan error)
+	go func() {
+		_, err := ln.Accept()
+		done <- err
+	}()
+	ln.Close()
+	if err := <-done; err == nil {
+		t.Errorf("Accept after Close: want error, got nil")
+	}
+}
@@ -0,0 +1,22 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !linux
+
+package protocol
+
+import (
+	"fmt"
+	"net"
+)
+
+// DialVsock connects to a vsock port, which only Linux has.
+func DialVsock(cid, port uint32) (net.Conn, error) {
+	return nil, fmt.Errorf("vsock %d:%d: vsock is only on Linux", cid, port)
+}
+
+// ListenVsock listens on a vsock port, which only Linux has.
+func ListenVsock(port uint32) (net.Listener, error) {
+	return nil, fmt.Errorf("vsock port %d: vsock is only on Linux", port)
+}
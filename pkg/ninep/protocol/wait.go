@@ -0,0 +1,118 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import "bytes"
+
+// A Waiter is a NineServer whose reads may wait for data which is yet to
+// come, as tail -f does at the end of a log. Before a Tread is
+// dispatched, the Server asks Wait whether a read of fid at o has to
+// wait. If it returns a channel, the read is held, while the rest of the
+// connection's messages are served, until the channel is closed, when
+// Wait is asked again. Once it returns nil, the read is dispatched. A
+// Tflush of a held read drops it, so that only the Rflush is sent.
+//
+// Wait is called as the rest of the NineServer's methods are, one at a
+// time, and must not wait itself. A channel may be closed when there
+// turns out to be nothing to read after all, since Wait is asked again.
+type Waiter interface {
+	Wait(fid FID, o Offset) <-chan struct{}
+}
+
+// hold holds the message in b, of type t, if it is a Tread which has to
+// wait, and reports whether it did. A held read gets a copy of b.
+func (c *conn) hold(b *bytes.Buffer, t MType) bool {
+	w, ok := c.server.NS.(Waiter)
+	if !ok || t != Tread {
+		return false
+	}
+	// tag[2] fid[4] offset[8] count[4]
+	d := b.Bytes()
+	if len(d) < 18 {
+		return false
+	}
+	c.dmu.Lock()
+	defer c.dmu.Unlock()
+	tag, fid, o := readArgs(d)
+	ready := w.Wait(fid, o)
+	if ready == nil {
+		return false
+	}
+	if c.held == nil {
+		c.held = make(map[Tag]chan struct{})
+	}
+	cancel := make(chan struct{})
+	c.held[tag] = cancel
+	c.logf("holding Tread tag %d, fid %d at %d", tag, fid, o)
+	go c.wait(w, append([]byte(nil), d...), ready, cancel)
+	return true
+}
+
+// wait dispatches the held Tread in d, once w says it need wait no more,
+// unless cancel is closed first.
+func (c *conn) wait(w Waiter, d []byte, ready <-chan struct{}, cancel chan struct{}) {
+	tag, fid, o := readArgs(d)
+	for {
+		select {
+		case <-ready:
+		case <-cancel:
+			return
+		}
+		c.dmu.Lock()
+		if c.held[tag] != cancel {
+			c.dmu.Unlock()
+			return
+		}
+		if ready = w.Wait(fid, o); ready != nil {
+			c.dmu.Unlock()
+			continue
+		}
+		delete(c.held, tag)
+		b := bytes.NewBuffer(d)
+		if err := c.dispatchLocked(b, Tread); err != nil {
+			c.logf("%v: %v", RPCNames[Tread], err)
+		}
+		// The reply is written before anything else is
+		// dispatched, so that it can't follow an Rflush of it.
+		err := c.write(b.Bytes())
+		if err == nil {
+			err = c.w.idle()
+		}
+		c.dmu.Unlock()
+		if err != nil {
+			c.logf("held Tread: write error: %v", err)
+		}
+		return
+	}
+}
+
+// drop drops the held read with tag, if there is one. c.dmu is held.
+func (c *conn) drop(tag Tag) {
+	if cancel, ok := c.held[tag]; ok {
+		c.logf("dropping held Tread tag %d", tag)
+		close(cancel)
+		delete(c.held, tag)
+	}
+}
+
+// dropAll drops every held read, once the connection is done.
+func (c *conn) dropAll() {
+	c.dmu.Lock()
+	defer c.dmu.Unlock()
+	for tag := range c.held {
+		c.drop(tag)
+	}
+}
+
+// readArgs returns the tag, fid and offset of the Tread in d.
+func readArgs(d []byte) (Tag, FID, Offset) {
+	tag := Tag(d[0]) | Tag(d[1])<<8
+	fid := FID(d[2]) | FID(d[3])<<8 | FID(d[4])<<16 | FID(d[5])<<24
+	var o Offset
+	for i := 13; i >= 6; i-- {
+		o = o<<8 | Offset(d[i])
+	}
+	return tag, fid, o
+}
@@ -0,0 +1,137 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+// waitServer is a dirServer whose reads at the end of a file wait, as
+// tail -f does, until something is written.
+type waitServer struct {
+	*dirServer
+	more chan struct{}
+}
+
+func (s *waitServer) Wait(fid FID, o Offset) <-chan struct{} {
+	if n := s.fids[fid]; n == "/" || int(o) < len(s.data[n]) {
+		return nil
+	}
+	if s.more == nil {
+		s.more = make(chan struct{})
+	}
+	return s.more
+}
+
+func (s *waitServer) Rwrite(fid FID, o Offset, b []byte) (Count, error) {
+	n, err := s.dirServer.Rwrite(fid, o, b)
+	if s.more != nil {
+		close(s.more)
+		s.more = nil
+	}
+	return n, err
+}
+
+func newWaitConn(t *testing.T) net.Conn {
+	l, err := NewListener(func() NineServer { return &waitServer{dirServer: newDirServer()} })
+	if err != nil {
+		t.Fatalf("NewListener: want nil, got %v", err)
+	}
+	p, p2 := net.Pipe()
+	if err := l.Accept(p2); err != nil {
+		t.Fatalf("Accept: want nil, got %v", err)
+	}
+	return p
+}
+
+func TestWait(t *testing.T) {
+	p := newWaitConn(t)
+	defer p.Close()
+	c, err := NewClient(func(c *Client) error {
+		c.FromNet, c.ToNet = p, p
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, _, err := c.CallTversion(8192, "9P2000"); err != nil {
+		t.Fatalf("CallTversion: want nil, got %v", err)
+	}
+	if _, err := c.CallTattach(0, NOFID, "glenda", ""); err != nil {
+		t.Fatalf("CallTattach: want nil, got %v", err)
+	}
+	r, err := c.Open(0, []string{"a"}, OREAD)
+	if err != nil {
+		t.Fatalf("Open(a): want nil, got %v", err)
+	}
+
+	// A read at the end waits, and the connection carries on.
+	got := make(chan string)
+	go func() {
+		b, err := c.CallTread(r.FID(), 5, 16)
+		if err != nil {
+			t.Errorf("CallTread(5): want nil, got %v", err)
+		}
+		got <- string(b)
+	}()
+	w, err := c.Open(0, []string{"a"}, OWRITE)
+	if err != nil {
+		t.Fatalf("Open(a, OWRITE): want nil, got %v", err)
+	}
+	select {
+	case s := <-got:
+		t.Fatalf("CallTread(5) at the end: got %q, want it to wait", s)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// A write wakes it.
+	if _, err := w.WriteAt([]byte(" world"), 5); err != nil {
+		t.Fatalf("WriteAt: want nil, got %v", err)
+	}
+	if s := <-got; s != " world" {
+		t.Errorf("CallTread(5) after write: got %q, want \" world\"", s)
+	}
+}
+
+func TestWaitFlush(t *testing.T) {
+	p := newWaitConn(t)
+	defer p.Close()
+	p.SetReadDeadline(time.Now().Add(10 * time.Second))
+	r := newFrameReader(p)
+	var b bytes.Buffer
+	// send sends the message m makes, and checks that the replies which
+	// come back next are those with tags.
+	send := func(m func(), tags ...Tag) {
+		t.Helper()
+		m()
+		if _, err := p.Write(b.Bytes()); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+		for _, tag := range tags {
+			f, err := r.frame(MaxMsize)
+			if err != nil {
+				t.Fatalf("reply: %v", err)
+			}
+			if got := Tag(f[5]) | Tag(f[6])<<8; got != tag || MType(f[4]) == Rerror {
+				t.Fatalf("reply: got %v tag %d, want tag %d", RPCNames[MType(f[4])], got, tag)
+			}
+		}
+	}
+	send(func() { MarshalTversionPkt(&b, NOTAG, 8192, "9P2000") }, NOTAG)
+	send(func() { MarshalTattachPkt(&b, 1, 0, NOFID, "glenda", "") }, 1)
+	send(func() { MarshalTwalkPkt(&b, 1, 0, 1, []string{"a"}) }, 1)
+	send(func() { MarshalTopenPkt(&b, 1, 1, OREAD) }, 1)
+
+	// The held read is flushed, and, when a write comes, stays so.
+	send(func() { MarshalTreadPkt(&b, 5, 1, 5, 100) })
+	send(func() { MarshalTflushPkt(&b, 6, 5) }, 6)
+	send(func() { MarshalTwalkPkt(&b, 7, 0, 2, []string{"a"}) }, 7)
+	send(func() { MarshalTopenPkt(&b, 8, 2, OWRITE) }, 8)
+	send(func() { MarshalTwritePkt(&b, 9, 2, 5, []byte("!")) }, 9)
+	send(func() { MarshalTstatPkt(&b, 10, 1) }, 10)
+}
@@ -0,0 +1,77 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import "strings"
+
+// MAXWELEM is the most names one Twalk may have.
+const MAXWELEM = 16
+
+// A WalkResult is how far a walk got: the QIDs of the files along the
+// way, one for each name walked, for as many as could be.
+type WalkResult struct {
+	// Names are the names asked for.
+	Names []string
+	// QIDs are those of the files walked to. A walk which stopped
+	// short has fewer than Names.
+	QIDs []QID
+	// FID is the new fid, at the end of the walk, or NOFID if the
+	// walk stopped short and there is none.
+	FID FID
+}
+
+// Complete reports whether every name was walked.
+func (w WalkResult) Complete() bool {
+	return len(w.QIDs) == len(w.Names)
+}
+
+// Failed returns the index in Names of the name which could not be
+// walked, or -1 if the walk was complete.
+func (w WalkResult) Failed() int {
+	if w.Complete() {
+		return -1
+	}
+	return len(w.QIDs)
+}
+
+// QID returns the QID of the file walked to. A walk of no names, which
+// only clones a fid, learns none.
+func (w WalkResult) QID() QID {
+	if len(w.QIDs) == 0 {
+		return QID{}
+	}
+	return w.QIDs[len(w.QIDs)-1]
+}
+
+// Walk walks a new fid from fid along names, any number of them, with
+// as many Twalks as it takes. It returns how far it got, and, if that
+// is not to the end, an error naming the file which could not be walked
+// to; the new fid is then clunked.
+func (c *Client) Walk(fid FID, names []string) (WalkResult, error) {
+	w := WalkResult{Names: names, FID: c.GetFID()}
+	from := fid
+	for i := 0; i == 0 || i < len(names); i += MAXWELEM {
+		n := names[i:]
+		if len(n) > MAXWELEM {
+			n = n[:MAXWELEM]
+		}
+		q, err := c.CallTwalk(from, w.FID, n)
+		w.QIDs = append(w.QIDs, q...)
+		if err == nil && len(q) < len(n) {
+			// Rather than the server's error, a short Rwalk.
+			err = Errorf(ErrNotExist, "%v", strings.Join(names[:w.Failed()+1], "/"))
+		}
+		if err != nil {
+			// After a whole Twalk, the new fid is partway.
+			if from == w.FID {
+				c.CallTclunk(w.FID)
+			}
+			w.FID = NOFID
+			return w, err
+		}
+		from = w.FID
+	}
+	return w, nil
+}
@@ -0,0 +1,108 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// deepServer is a dirServer whose directory d holds a d, and so on, and
+// a file f, as deep as you like.
+type deepServer struct {
+	*dirServer
+	depth map[FID]int
+	// most is the most names in one Twalk.
+	most int
+}
+
+func (s *deepServer) Rwalk(fid FID, newfid FID, paths []string) ([]QID, error) {
+	if _, ok := s.fids[fid]; !ok {
+		return nil, fmt.Errorf("fid unknown")
+	}
+	if len(paths) > s.most {
+		s.most = len(paths)
+	}
+	d := s.depth[fid]
+	var q []QID
+	for _, p := range paths {
+		if len(q) > 0 && q[len(q)-1].Type != QTDIR {
+			break
+		}
+		d++
+		if p == "d" {
+			q = append(q, QID{Type: QTDIR, Path: uint64(d)})
+		} else if p == "f" {
+			q = append(q, QID{Path: uint64(1000 + d)})
+		} else {
+			break
+		}
+	}
+	if len(q) == 0 && len(paths) > 0 {
+		return nil, fmt.Errorf("%v: file does not exist", paths[0])
+	}
+	if len(q) == len(paths) {
+		s.fids[newfid] = "/"
+		s.depth[newfid] = s.depth[fid] + len(q)
+	}
+	return q, nil
+}
+
+func TestWalk(t *testing.T) {
+	ds := &deepServer{dirServer: newDirServer(), depth: map[FID]int{}}
+	c := newPackClient(t, "9P2000", ds)
+	if _, err := c.CallTattach(0, NOFID, "glenda", ""); err != nil {
+		t.Fatalf("CallTattach: want nil, got %v", err)
+	}
+
+	// Deeper than one Twalk can go.
+	names := append(strings.Split(strings.Repeat("d", 2*MAXWELEM), ""), "f")
+	w, err := c.Walk(0, names)
+	if err != nil {
+		t.Fatalf("Walk(%d names): want nil, got %v", len(names), err)
+	}
+	if !w.Complete() || w.Failed() != -1 || len(w.QIDs) != len(names) || w.FID == NOFID {
+		t.Errorf("Walk(%d names): got %+v, want complete", len(names), w)
+	}
+	if w.QIDs[0].Type != QTDIR || w.QID().Type == QTDIR || w.QID().Path != uint64(1000+len(names)) {
+		t.Errorf("Walk(%d names): got QIDs %v, want directories, then f", len(names), w.QIDs)
+	}
+	if ds.most > MAXWELEM {
+		t.Errorf("Walk(%d names): a Twalk had %d names, want at most %d", len(names), ds.most, MAXWELEM)
+	}
+	if ds.depth[w.FID] != len(names) {
+		t.Errorf("Walk(%d names): new fid is %d deep", len(names), ds.depth[w.FID])
+	}
+
+	// Stopping short, in the first Twalk and in a later one.
+	for _, tc := range []struct {
+		names  []string
+		failed int
+		err    string
+	}{
+		{[]string{"x"}, 0, "x: file does not exist"},
+		{[]string{"d", "f", "d"}, 2, "d/f/d: file does not exist"},
+		{append(strings.Split(strings.Repeat("d", MAXWELEM+2), ""), "x"), MAXWELEM + 2, "x: file does not exist"},
+		{append(strings.Split(strings.Repeat("d", MAXWELEM+2), ""), "f", "d"), MAXWELEM + 3, "d/f/d: file does not exist"},
+	} {
+		fids := len(ds.fids)
+		w, err := c.Walk(0, tc.names)
+		if err == nil || !strings.HasSuffix(err.Error(), tc.err) {
+			t.Errorf("Walk(%v): got %v, want an error ending %q", tc.names, err, tc.err)
+		}
+		if w.Complete() || w.Failed() != tc.failed || w.FID != NOFID || len(w.QIDs) != tc.failed {
+			t.Errorf("Walk(%v): got %+v, failed at %d, want failed at %d, no fid", tc.names, w, w.Failed(), tc.failed)
+		}
+		if len(ds.fids) != fids {
+			t.Errorf("Walk(%v): left %d fids, want none", tc.names, len(ds.fids)-fids)
+		}
+	}
+
+	// No names clones the fid.
+	if w, err := c.Walk(0, nil); err != nil || !w.Complete() || w.FID == NOFID {
+		t.Errorf("Walk(nil): got %+v, %v, want a new fid", w, err)
+	}
+}
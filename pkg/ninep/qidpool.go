@@ -0,0 +1,253 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ninep
+
+import (
+	"bufio"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// QIDPool maps backend keys to QID paths. Backends name their files
+// in whatever way is natural to them (device and inode, a path string,
+// an object ID) and the pool hands back a 64-bit path that is stable
+// for the life of the pool and never shared by two keys.
+//
+// A key may come with a hint, e.g. an inode number. If the hint is not
+// in use by some other key, it is used as the path, so that backends
+// with good native IDs see them unchanged on the wire. If it is taken,
+// we probe forward from the hash of the key until we find a free path.
+type QIDPool struct {
+	mu    sync.Mutex
+	paths map[string]uint64
+	keys  map[uint64]string
+
+	// journal, if set, has every change to the pool appended to it,
+	// in the format read by Load.
+	journal io.Writer
+}
+
+// QIDPoolOpt is an option for NewQIDPool.
+type QIDPoolOpt func(*QIDPool) error
+
+// NewQIDPool returns an empty QIDPool.
+func NewQIDPool(opts ...QIDPoolOpt) (*QIDPool, error) {
+	p := &QIDPool{
+		paths: make(map[string]uint64),
+		keys:  make(map[uint64]string),
+	}
+	for _, o := range opts {
+		if err := o(p); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// QIDJournal makes the pool append every change to w, so that the
+// pool can be rebuilt with Load after a restart. Writes to w are not
+// buffered. Errors are logged and otherwise ignored: losing the journal
+// should not take the file server down with it.
+func QIDJournal(w io.Writer) QIDPoolOpt {
+	return func(p *QIDPool) error {
+		p.journal = w
+		return nil
+	}
+}
+
+// DevInoKey returns the key used for files named by device and inode.
+func DevInoKey(dev, ino uint64) string {
+	return fmt.Sprintf("dev:%d:%d", dev, ino)
+}
+
+// PathKey returns the key used for files named by a path string.
+func PathKey(p string) string {
+	return "path:" + p
+}
+
+// ObjectKey returns the key used for files named by an opaque object ID.
+func ObjectKey(id string) string {
+	return "obj:" + id
+}
+
+func hashKey(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return h.Sum64()
+}
+
+// Path returns the QID path for key, allocating one if needed.
+func (p *QIDPool) Path(key string) uint64 {
+	return p.PathHint(key, hashKey(key))
+}
+
+// PathHint is like Path, but prefers hint as the path if the key has
+// not been seen before and hint is free.
+func (p *QIDPool) PathHint(key string, hint uint64) uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if q, ok := p.paths[key]; ok {
+		return q
+	}
+	q := hint
+	if _, ok := p.keys[q]; ok {
+		q = hashKey(key)
+		for {
+			if _, ok := p.keys[q]; !ok {
+				break
+			}
+			q++
+		}
+	}
+	p.paths[key] = q
+	p.keys[q] = key
+	p.record(q, key)
+	return q
+}
+
+// record appends an entry to the journal, if any.
+// A key without a path is written as "- key", meaning forget it.
+func (p *QIDPool) record(q uint64, key string) {
+	if p.journal == nil {
+		return
+	}
+	var err error
+	if key == "" {
+		_, err = fmt.Fprintf(p.journal, "- %s\n", strconv.Quote(p.keys[q]))
+	} else {
+		err = writeQIDEntry(p.journal, q, key)
+	}
+	if err != nil {
+		log.Printf("QIDPool: journal: %v", err)
+	}
+}
+
+// Lookup returns the path for key, if it has one.
+func (p *QIDPool) Lookup(key string) (uint64, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	q, ok := p.paths[key]
+	return q, ok
+}
+
+// Forget drops key from the pool. Its path may be handed out again.
+// Backends should only do this when the file is truly gone, e.g.
+// after a remove, since clients may still hold the old QID.
+func (p *QIDPool) Forget(key string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if q, ok := p.paths[key]; ok {
+		p.record(q, "")
+		delete(p.keys, q)
+		delete(p.paths, key)
+	}
+}
+
+// Rename moves the path held by from to to. If to already had a path,
+// it is forgotten, as rename(2) would replace the file.
+func (p *QIDPool) Rename(from, to string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	q, ok := p.paths[from]
+	if !ok {
+		return
+	}
+	if old, ok := p.paths[to]; ok {
+		delete(p.keys, old)
+	}
+	delete(p.paths, from)
+	p.paths[to] = q
+	p.keys[q] = to
+	p.record(q, to)
+}
+
+// Len returns the number of keys in the pool.
+func (p *QIDPool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.paths)
+}
+
+// Save writes the pool to w, one "path key" pair per line.
+// The key is quoted, so any string may be used as a key.
+func (p *QIDPool) Save(w io.Writer) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	bw := bufio.NewWriter(w)
+	for k, q := range p.paths {
+		if err := writeQIDEntry(bw, q, k); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+func writeQIDEntry(w io.Writer, q uint64, key string) error {
+	_, err := fmt.Fprintf(w, "%d %s\n", q, strconv.Quote(key))
+	return err
+}
+
+// Load reads entries written by Save, or by a journal, into the pool.
+// Entries in r replace any the pool already has for the same key or
+// path. Later lines win, so a journal may be loaded as is. Load does
+// not write to the journal.
+//
+// A last line with no newline is a record torn by a crash part way
+// through writing it, and is ignored, since what it held was never
+// known to be saved. A journal which is to be appended to after such a
+// line should be compacted first, by Save, as ufs does, so that the
+// next record doesn't run on from it. Any other line which can't be
+// read is an error.
+func (p *QIDPool) Load(r io.Reader) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	br := bufio.NewReader(r)
+	for line := 1; ; line++ {
+		t, err := br.ReadString('\n')
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		t = t[:len(t)-1]
+		i := strings.IndexByte(t, ' ')
+		if i < 0 {
+			return fmt.Errorf("QIDPool: line %d: no key", line)
+		}
+		if t[:i] == "-" {
+			k, err := strconv.Unquote(t[i+1:])
+			if err != nil {
+				return fmt.Errorf("QIDPool: line %d: %v", line, err)
+			}
+			if old, ok := p.paths[k]; ok {
+				delete(p.keys, old)
+				delete(p.paths, k)
+			}
+			continue
+		}
+		q, err := strconv.ParseUint(t[:i], 10, 64)
+		if err != nil {
+			return fmt.Errorf("QIDPool: line %d: %v", line, err)
+		}
+		k, err := strconv.Unquote(t[i+1:])
+		if err != nil {
+			return fmt.Errorf("QIDPool: line %d: %v", line, err)
+		}
+		if old, ok := p.paths[k]; ok {
+			delete(p.keys, old)
+		}
+		if old, ok := p.keys[q]; ok {
+			delete(p.paths, old)
+		}
+		p.paths[k] = q
+		p.keys[q] = k
+	}
+}
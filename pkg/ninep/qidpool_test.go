@@ -0,0 +1,103 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ninep
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestQIDPool(t *testing.T) {
+	p, err := NewQIDPool()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := p.PathHint(DevInoKey(1, 42), 42)
+	if a != 42 {
+		t.Errorf("PathHint(dev 1 ino 42, 42): want 42, got %v", a)
+	}
+	// Same inode on another device: the hint is taken, so we must
+	// get something else.
+	b := p.PathHint(DevInoKey(2, 42), 42)
+	if b == a {
+		t.Errorf("PathHint(dev 2 ino 42, 42): got %v, same as dev 1", b)
+	}
+	if q := p.PathHint(DevInoKey(1, 42), 7); q != a {
+		t.Errorf("PathHint(dev 1 ino 42) again: want %v, got %v", a, q)
+	}
+	c := p.Path(PathKey("/a b\nc"))
+	if c == a || c == b {
+		t.Errorf("Path(\"/a b\\nc\"): got %v, collides with %v or %v", c, a, b)
+	}
+
+	p.Rename(PathKey("/a b\nc"), PathKey("/d"))
+	if q, ok := p.Lookup(PathKey("/d")); !ok || q != c {
+		t.Errorf("Lookup(/d) after rename: want (%v, true), got (%v, %v)", c, q, ok)
+	}
+
+	var buf bytes.Buffer
+	if err := p.Save(&buf); err != nil {
+		t.Fatalf("Save: want nil, got %v", err)
+	}
+	n, err := NewQIDPool()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Load(&buf); err != nil {
+		t.Fatalf("Load: want nil, got %v", err)
+	}
+	if n.Len() != p.Len() {
+		t.Fatalf("Load: want %d entries, got %d", p.Len(), n.Len())
+	}
+	for _, k := range []string{DevInoKey(1, 42), DevInoKey(2, 42), PathKey("/d")} {
+		w, _ := p.Lookup(k)
+		if q, ok := n.Lookup(k); !ok || q != w {
+			t.Errorf("Lookup(%q) after Load: want (%v, true), got (%v, %v)", k, w, q, ok)
+		}
+	}
+
+	p.Forget(DevInoKey(1, 42))
+	if q := p.PathHint(ObjectKey("x"), 42); q != 42 {
+		t.Errorf("PathHint(obj x, 42) after Forget: want 42, got %v", q)
+	}
+}
+
+func TestQIDPoolLoadTorn(t *testing.T) {
+	for _, tt := range []struct {
+		name, journal string
+		ok            bool
+		want          []string
+	}{
+		{"whole", "1 \"a\"\n2 \"b\"\n", true, []string{"a", "b"}},
+		{"torn entry", "1 \"a\"\n2 \"b\"\n3 \"c", true, []string{"a", "b"}},
+		{"torn path", "1 \"a\"\n2 \"b\"\n3", true, []string{"a", "b"}},
+		{"torn forget", "1 \"a\"\n2 \"b\"\n- \"a\"", true, []string{"a", "b"}},
+		{"whole but for the newline", "1 \"a\"\n2 \"b\"", true, []string{"a"}},
+		{"corrupt in the middle", "1 \"a\"\n2 \"b\n3 \"c\"\n", false, nil},
+		{"no key in the middle", "1 \"a\"\n2\n3 \"c\"\n", false, nil},
+	} {
+		p, err := NewQIDPool()
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = p.Load(strings.NewReader(tt.journal))
+		if (err == nil) != tt.ok {
+			t.Errorf("%v: Load: got %v, want ok %v", tt.name, err, tt.ok)
+			continue
+		}
+		if !tt.ok {
+			continue
+		}
+		if p.Len() != len(tt.want) {
+			t.Errorf("%v: Load: got %d entries, want %d", tt.name, p.Len(), len(tt.want))
+		}
+		for _, k := range tt.want {
+			if _, ok := p.Lookup(k); !ok {
+				t.Errorf("%v: Lookup(%q) after Load: not found", tt.name, k)
+			}
+		}
+	}
+}
@@ -0,0 +1,201 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ninep
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os/user"
+	"strconv"
+	"strings"
+
+	"harvey-os.org/pkg/ninep/protocol"
+)
+
+// A UserDB gives names to the numeric user and group ids of a backend,
+// for the User, Group and ModUser of a Dir, and knows who is in which
+// group, for checking group permissions.
+type UserDB interface {
+	// User returns the name of the user with id uid.
+	User(uid uint32) (string, error)
+
+	// Group returns the name of the group with id gid.
+	Group(gid uint32) (string, error)
+
+	// InGroup reports whether the user is a member of the group.
+	InGroup(user, group string) bool
+}
+
+// Allowed reports whether user may do what want asks of the file d:
+// want is some of 4 for read, 2 for write and 1 for execute. As in
+// Plan 9, the owner gets the owner bits, members of the group the group
+// bits, and everyone the other bits, so the owner may also use the group
+// and other bits.
+func Allowed(db UserDB, user string, d protocol.Dir, want uint32) bool {
+	m := d.Mode & 7
+	if user == d.Group || db.InGroup(user, d.Group) {
+		m |= d.Mode >> 3 & 7
+	}
+	if user == d.User {
+		m |= d.Mode >> 6 & 7
+	}
+	return m&want == want
+}
+
+// NumericUsers is a UserDB which names users and groups by their ids,
+// and has no members in any group.
+type NumericUsers struct{}
+
+func (NumericUsers) User(uid uint32) (string, error) {
+	return strconv.FormatUint(uint64(uid), 10), nil
+}
+
+func (NumericUsers) Group(gid uint32) (string, error) {
+	return strconv.FormatUint(uint64(gid), 10), nil
+}
+
+func (NumericUsers) InGroup(user, group string) bool {
+	return false
+}
+
+// OSUsers is a UserDB which asks the system's password and group files.
+type OSUsers struct{}
+
+func (OSUsers) User(uid uint32) (string, error) {
+	u, err := user.LookupId(strconv.FormatUint(uint64(uid), 10))
+	if err != nil {
+		return "", err
+	}
+	return u.Username, nil
+}
+
+func (OSUsers) Group(gid uint32) (string, error) {
+	g, err := user.LookupGroupId(strconv.FormatUint(uint64(gid), 10))
+	if err != nil {
+		return "", err
+	}
+	return g.Name, nil
+}
+
+func (OSUsers) InGroup(name, group string) bool {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return false
+	}
+	g, err := user.LookupGroup(group)
+	if err != nil {
+		return false
+	}
+	if u.Gid == g.Gid {
+		return true
+	}
+	ids, err := u.GroupIds()
+	if err != nil {
+		return false
+	}
+	for _, id := range ids {
+		if id == g.Gid {
+			return true
+		}
+	}
+	return false
+}
+
+// UserTable is a UserDB read from a file in the format of Plan 9's
+// /adm/users: lines of id:name:leader:members, where members is a comma
+// separated list of names. As in Plan 9, users and groups are the same
+// thing, so a group's id is in the same table as a user's.
+type UserTable struct {
+	names   map[uint32]string
+	members map[string]map[string]bool
+}
+
+// ReadUsers reads a UserTable. Blank lines, and lines starting with #,
+// are ignored.
+func ReadUsers(r io.Reader) (*UserTable, error) {
+	t := &UserTable{names: map[uint32]string{}, members: map[string]map[string]bool{}}
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		l := strings.TrimSpace(s.Text())
+		if l == "" || l[0] == '#' {
+			continue
+		}
+		f := strings.Split(l, ":")
+		if len(f) != 4 {
+			return nil, fmt.Errorf("users line %d: %q: want id:name:leader:members", n, l)
+		}
+		id, err := strconv.ParseUint(f[0], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("users line %d: bad id: %v", n, err)
+		}
+		if f[1] == "" {
+			return nil, fmt.Errorf("users line %d: no name", n)
+		}
+		t.names[uint32(id)] = f[1]
+		m := map[string]bool{}
+		if f[2] != "" {
+			m[f[2]] = true
+		}
+		for _, u := range strings.Split(f[3], ",") {
+			if u != "" {
+				m[u] = true
+			}
+		}
+		t.members[f[1]] = m
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// User returns the name for uid, or the number if there is none.
+func (t *UserTable) User(uid uint32) (string, error) {
+	if n, ok := t.names[uid]; ok {
+		return n, nil
+	}
+	return NumericUsers{}.User(uid)
+}
+
+// Group returns the name for gid, or the number if there is none.
+func (t *UserTable) Group(gid uint32) (string, error) {
+	return t.User(gid)
+}
+
+// InGroup reports whether user is the leader or a member of group.
+func (t *UserTable) InGroup(user, group string) bool {
+	return t.members[group][user]
+}
+
+// UserFuncs is a UserDB made of functions, for databases such as LDAP
+// which are only reachable through some other package. Functions which
+// are nil act as NumericUsers.
+type UserFuncs struct {
+	UserFunc    func(uid uint32) (string, error)
+	GroupFunc   func(gid uint32) (string, error)
+	InGroupFunc func(user, group string) bool
+}
+
+func (u *UserFuncs) User(uid uint32) (string, error) {
+	if u.UserFunc == nil {
+		return NumericUsers{}.User(uid)
+	}
+	return u.UserFunc(uid)
+}
+
+func (u *UserFuncs) Group(gid uint32) (string, error) {
+	if u.GroupFunc == nil {
+		return NumericUsers{}.Group(gid)
+	}
+	return u.GroupFunc(gid)
+}
+
+func (u *UserFuncs) InGroup(user, group string) bool {
+	if u.InGroupFunc == nil {
+		return false
+	}
+	return u.InGroupFunc(user, group)
+}
@@ -0,0 +1,76 @@
+// Copyright 2020 The Ninep Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ninep
+
+import (
+	"strings"
+	"testing"
+
+	"harvey-os.org/pkg/ninep/protocol"
+)
+
+const users = `# Plan 9 style
+1000:glenda:glenda:
+1001:bob::
+100:sys:glenda:bob,alice
+`
+
+func TestUserTable(t *testing.T) {
+	db, err := ReadUsers(strings.NewReader(users))
+	if err != nil {
+		t.Fatalf("ReadUsers: want nil, got %v", err)
+	}
+	for _, tt := range []struct {
+		id   uint32
+		want string
+	}{
+		{1000, "glenda"},
+		{100, "sys"},
+		{7, "7"},
+	} {
+		if n, err := db.User(tt.id); n != tt.want || err != nil {
+			t.Errorf("User(%d): got %q, %v, want %q, nil", tt.id, n, err, tt.want)
+		}
+	}
+	if n, _ := db.Group(100); n != "sys" {
+		t.Errorf("Group(100): got %q, want sys", n)
+	}
+
+	d := protocol.Dir{User: "glenda", Group: "sys", Mode: 0640}
+	for _, tt := range []struct {
+		user string
+		want uint32
+		ok   bool
+	}{
+		{"glenda", 6, true},
+		{"bob", 4, true},
+		{"bob", 2, false},
+		{"alice", 4, true},
+		{"eve", 4, false},
+	} {
+		if ok := Allowed(db, tt.user, d, tt.want); ok != tt.ok {
+			t.Errorf("Allowed(%q, %o): got %v, want %v", tt.user, tt.want, ok, tt.ok)
+		}
+	}
+
+	for _, bad := range []string{"1000:glenda", "x:glenda::", "1:::"} {
+		if _, err := ReadUsers(strings.NewReader(bad)); err == nil {
+			t.Errorf("ReadUsers(%q): want err, got nil", bad)
+		}
+	}
+}
+
+func TestUserFuncs(t *testing.T) {
+	db := &UserFuncs{UserFunc: func(uid uint32) (string, error) { return "ldap", nil }}
+	if n, _ := db.User(1); n != "ldap" {
+		t.Errorf("User(1): got %q, want ldap", n)
+	}
+	if n, _ := db.Group(1); n != "1" {
+		t.Errorf("Group(1): got %q, want 1", n)
+	}
+	if db.InGroup("ldap", "1") {
+		t.Errorf("InGroup with no InGroupFunc: got true, want false")
+	}
+}